@@ -19,6 +19,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 
 	"github.com/F5Networks/k8s-bigip-ctlr/pkg/crmanager"
@@ -108,9 +109,50 @@ var (
 
 	// Custom Resource
 	customResourceMode *bool
+	dryRun             *bool
+
+	namespaceMaxVirtualServers   *int
+	namespaceMaxVirtualAddresses *int
+	namespaceMaxPools            *int
+	warnCertExpiryDays           *int
+	namingScheme                 *string
+	nameEscaping                 *string
+	useResourceNames             *bool
+	allowedPartitions            *[]string
+	sharedObjectsPartition       *string
+	sharedVIPPolicy              *string
+	defaultSNAT                  *string
+	defaultICMPEcho              *string
+	disableARP                   *bool
+	debugAddress                 *string
+	debugToken                   *string
+	serveResources               *bool
+	useEndpointSlices            *bool
+	processingWorkers            *int
+	flushInterval                *time.Duration
+	shutdownGracePeriod          *time.Duration
+	processingStallTimeout       *time.Duration
+	resyncPeriod                 *time.Duration
+	pruneOnStartup               *bool
+	disableFinalizers            *bool
+	webhookAddress               *string
+	webhookCertFile              *string
+	webhookKeyFile               *string
+	manageCRDs                   *bool
+	disableInactive              *bool
+	filterUnhealthyNodes         *bool
+
+	ipam          *bool
+	ipamRanges    *[]string
+	ipamNamespace *string
+
+	leaderElect    *bool
+	leaseNamespace *string
+	leaseName      *string
 
 	pythonBaseDir    *string
 	logLevel         *string
+	logFormat        *string
 	verifyInterval   *int
 	nodePollInterval *int
 	printVersion     *bool
@@ -147,6 +189,9 @@ var (
 	ciphers                   *string
 	trustedCerts              *string
 	as3PostDelay              *int
+	defaultRouteDomain        *int
+	namespaceRouteDomains     *[]string
+	namespacePartitions       *[]string
 
 	trustedCertsCfgmap *string
 	agent              *string
@@ -201,6 +246,10 @@ func _init() {
 		"DEPRECATED: Optional, directory location of python utilities")
 	logLevel = globalFlags.String("log-level", "INFO",
 		"Optional, logging level")
+	logFormat = globalFlags.String("log-format", "text",
+		"Optional, format of the log messages, 'text' or 'json'. The JSON messages "+
+			"logged while processing a custom resource have its namespace, kind, name "+
+			"and syncID fields, the Events recorded then are annotated with the syncID.")
 	verifyInterval = globalFlags.Int("verify-interval", 30,
 		"Optional, interval (in seconds) at which to verify the BIG-IP configuration.")
 	nodePollInterval = globalFlags.Int("node-poll-interval", 30,
@@ -213,6 +262,98 @@ func _init() {
 	// Custom Resource
 	customResourceMode = globalFlags.Bool("custom-resource-mode", false,
 		"Optional, When set to true, controller processes only F5 Custom Resources.")
+	dryRun = globalFlags.Bool("dry-run", false,
+		"Optional, when set to true in custom resource mode, prints the AS3 declarations "+
+			"to stdout and serves the last one at /declaration of http-listen-address "+
+			"instead of posting them to BIG-IP.")
+	namingScheme = globalFlags.String("naming-scheme", crmanager.CRDNamingScheme,
+		"Optional, naming scheme of BIG-IP objects in custom resource mode. "+
+			"'crd' names the objects with 'f5_crd_virtualserver' prefix, "+
+			"'legacy' names the objects the same as Ingress resources.")
+	nameEscaping = globalFlags.String("name-escaping", crmanager.CompatibleNameEscaping,
+		"Optional, escaping of pool and rule names of 'crd' naming scheme. "+
+			"'compatible' replaces the characters not allowed in BIG-IP names with '_', "+
+			"'unique' escapes them so that different services never share a pool name.")
+	useResourceNames = globalFlags.Bool("use-resource-names", false,
+		"Optional, name the BIG-IP virtuals of VirtualServers after their namespace, name and port "+
+			"instead of their address and port in custom resource mode. VirtualServers sharing "+
+			"an address and port are served by the virtual named after the first of them.")
+	allowedPartitions = globalFlags.StringArray("allowed-partitions", []string{},
+		"Optional, BIG-IP partitions VirtualServers can select with partition in custom resource mode, "+
+			"can be repeated. The partitions are created on demand, the objects of VirtualServers "+
+			"without partition are in the partition of the controller.")
+	sharedObjectsPartition = globalFlags.String("shared-objects-partition", "",
+		"Optional, BIG-IP partition of the iRules and data groups of the virtuals in custom resource mode, "+
+			"instead of the partition of each virtual. The copies left in the former partition are deleted.")
+	sharedVIPPolicy = globalFlags.String("shared-vip-policy", crmanager.SharedVIPMerge,
+		"Optional, policy for VirtualServers using the same address and port "+
+			"in custom resource mode. 'merge' merges the VirtualServers into one virtual, "+
+			"'reject' rejects the newer VirtualServer.")
+	defaultSNAT = globalFlags.String("default-snat", crmanager.SNATAutomap,
+		"Optional, SNAT of VirtualServers without snat in custom resource mode. "+
+			"'automap', 'none' or the path of a SNAT pool like /Common/snatpool.")
+	defaultICMPEcho = globalFlags.String("default-icmp-echo", crmanager.ICMPEchoEnable,
+		"Optional, ICMP echo of the virtual addresses of VirtualServers without icmpEcho "+
+			"in custom resource mode. 'enable', 'disable' or 'selective'.")
+	disableARP = globalFlags.Bool("disable-arp", false,
+		"Optional, when set to true, disables ARP on the virtual addresses of VirtualServers "+
+			"without arp in custom resource mode, like addresses advertised by BGP.")
+	debugAddress = globalFlags.String("debug-address", "",
+		"Optional, address of the debug server serving pprof profiles, log level "+
+			"and resync in custom resource mode. The server is not started by default.")
+	debugToken = globalFlags.String("debug-token", "",
+		"Optional, bearer token required by the debug server, "+
+			"mandatory when debug-address is not a loopback address.")
+	serveResources = globalFlags.Bool("serve-resources", false,
+		"Optional, when set to true, serves the resource configs of custom resource mode "+
+			"as JSON at /resources of http-listen-address, filtered by the 'namespace' parameter. "+
+			"Certificates and keys are never served.")
+	useEndpointSlices = globalFlags.Bool("use-endpointslices", false,
+		"Optional, populate the pool members from EndpointSlices instead of Endpoints "+
+			"in custom resource mode. Endpoints are used when the cluster does not serve EndpointSlices.")
+	processingWorkers = globalFlags.Int("processing-workers", 2,
+		"Optional, number of workers processing the custom resources in custom resource mode. "+
+			"The workers get the secrets of VirtualServers concurrently.")
+	resyncPeriod = globalFlags.Duration("resync-period", 0,
+		"Optional, interval of the full resyncs in custom resource mode, rebuilding the configuration "+
+			"from the resources and posting it to BIG-IP even if unchanged. Default 0 disables them, "+
+			"a resync is also triggered by the SIGUSR1 signal.")
+	pruneOnStartup = globalFlags.Bool("prune-on-startup", false,
+		"Optional, remove the objects on BIG-IP named like the virtuals of CIS and missing from the "+
+			"configuration once the resources are processed on startup, in custom resource mode.")
+	disableFinalizers = globalFlags.Bool("disable-finalizers", false,
+		"Optional, do not place the cis.f5.com/cleanup finalizer on the VirtualServers and TLSProfiles in "+
+			"custom resource mode. The deleted resources are then removed from BIG-IP without delaying their "+
+			"deletion, their removal is missed while the controller is down.")
+	webhookAddress = globalFlags.String("webhook-address", "",
+		"Optional, address of the admission webhook server validating the VirtualServers and TLSProfiles "+
+			"in custom resource mode, like :8443. The server is not started by default.")
+	webhookCertFile = globalFlags.String("webhook-cert-file", "",
+		"Optional, path of the certificate of the admission webhook server, like a mounted tls.crt, "+
+			"mandatory with webhook-address.")
+	webhookKeyFile = globalFlags.String("webhook-key-file", "",
+		"Optional, path of the key of the admission webhook server, like a mounted tls.key, "+
+			"mandatory with webhook-address.")
+	manageCRDs = globalFlags.Bool("manage-crds", false,
+		"Optional, create or update the CustomResourceDefinitions of CIS on startup in custom resource mode, "+
+			"with the schemas validating and defaulting the resources. Requires the permissions to get, "+
+			"create and update the customresourcedefinitions.")
+	disableInactive = globalFlags.Bool("disable-inactive-virtuals", false,
+		"Optional, disable the virtuals without pool members in custom resource mode, "+
+			"unless they have a default or backup pool. By default they stay enabled and reset the connections.")
+	filterUnhealthyNodes = globalFlags.Bool("filter-unhealthy-nodes", false,
+		"Optional, exclude the unschedulable nodes and the nodes with the not-ready taint from the pool members "+
+			"in nodeport mode, in custom resource mode.")
+	flushInterval = globalFlags.Duration("flush-interval", time.Second,
+		"Optional, minimum interval between the declarations posted to BIG-IP in custom resource mode. "+
+			"The changes of the resources processed meanwhile are posted together.")
+	shutdownGracePeriod = globalFlags.Duration("shutdown-grace-period", 20*time.Second,
+		"Optional, on SIGTERM in custom resource mode, maximum time to process the resources queued "+
+			"and post the last declaration to BIG-IP before exiting. The health check reports "+
+			"draining meanwhile. Keep it below the terminationGracePeriodSeconds of the pod.")
+	processingStallTimeout = globalFlags.Duration("processing-stall-timeout", 5*time.Minute,
+		"Optional, in custom resource mode, the /health endpoint fails when resources are queued and none "+
+			"was processed for this duration. 0 disables the check.")
 
 	globalFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "  Global:\n%s\n", globalFlags.FlagUsagesWrapped(width))
@@ -226,7 +367,9 @@ func _init() {
 	bigIPPassword = bigIPFlags.String("bigip-password", "",
 		"Required, password for the Big-IP user account.")
 	bigIPPartitions = bigIPFlags.StringArray("bigip-partition", []string{},
-		"Required, partition(s) for the Big-IP kubernetes objects.")
+		"Required, partition(s) for the Big-IP kubernetes objects, can be repeated or comma separated. "+
+			"In custom resource mode, the first partition is the default one and the resources can "+
+			"select the others.")
 	credsDir = bigIPFlags.String("credentials-directory", "",
 		"Optional, directory that contains the BIG-IP username, password, and/or "+
 			"url files. To be used instead of username, password, and/or url arguments.")
@@ -236,6 +379,15 @@ func _init() {
 		"Optional, when set to true, enable insecure SSL communication to BIGIP.")
 	as3PostDelay = bigIPFlags.Int("as3-post-delay", 0,
 		"Optional, time (in seconds) that CIS waits to post the available AS3 declaration.")
+	defaultRouteDomain = bigIPFlags.Int("default-route-domain", 0,
+		"Optional, CIS uses this value to configure the route domain of virtual addresses and pool members in custom resource mode.")
+	namespaceRouteDomains = bigIPFlags.StringArray("namespace-route-domain", []string{},
+		"Optional, route domain of the resources of a namespace as <namespace>=<route_domain>, "+
+			"overrides default-route-domain in custom resource mode, can be repeated.")
+	namespacePartitions = bigIPFlags.StringArray("namespace-partition", []string{},
+		"Optional, partition of the resources of a namespace as <namespace>=<partition>, one of "+
+			"bigip-partition or allowed-partitions, in custom resource mode, can be repeated. "+
+			"The partition of a VirtualServer overrides it.")
 	logAS3Response = bigIPFlags.Bool("log-as3-response", false,
 		"Optional, when set to true, add the body of AS3 API response in Controller logs.")
 	enableTLS = bigIPFlags.String("tls-version", "1.2",
@@ -304,6 +456,32 @@ func _init() {
 	manageIngressClassOnly = kubeFlags.Bool("manage-ingress-class-only", false,
 		"Optional, default `false`. Process all ingress resources without `kubernetes.io/ingress.class`"+
 			"annotation and ingresses with annotation `kubernetes.io/ingress.class=f5`.")
+	namespaceMaxVirtualServers = kubeFlags.Int("namespace-max-virtual-servers", 0,
+		"Optional, maximum number of VirtualServers processed per namespace in custom resource mode. "+
+			"Default 0 is unlimited.")
+	namespaceMaxVirtualAddresses = kubeFlags.Int("namespace-max-virtual-addresses", 0,
+		"Optional, maximum number of distinct virtual addresses used per namespace in custom resource mode. "+
+			"Default 0 is unlimited.")
+	namespaceMaxPools = kubeFlags.Int("namespace-max-pools", 0,
+		"Optional, maximum number of pools created per namespace in custom resource mode. "+
+			"Default 0 is unlimited.")
+	warnCertExpiryDays = kubeFlags.Int("warn-cert-expiry-days", 0,
+		"Optional, the certificates of the secrets of the TLSProfiles expiring within these days are reported "+
+			"with a warning event and metric in custom resource mode. Default 0 disables the warnings.")
+	ipam = kubeFlags.Bool("ipam", false,
+		"Optional, when set to true, allocates the address of VirtualServers with ipamLabel "+
+			"and without virtualServerAddress in custom resource mode.")
+	ipamRanges = kubeFlags.StringArray("ipam-range", []string{},
+		"Optional, address range for an ipamLabel as <label>=<start_ip>-<end_ip>, can be repeated.")
+	ipamNamespace = kubeFlags.String("ipam-namespace", "kube-system",
+		"Optional, namespace of the ConfigMap which persists the addresses allocated by IPAM.")
+	leaderElect = kubeFlags.Bool("leader-elect", false,
+		"Optional, when set to true, only the leader among the controllers using the same Lease "+
+			"processes the resources and posts to BIG-IP in custom resource mode.")
+	leaseNamespace = kubeFlags.String("leader-elect-namespace", "",
+		"Optional, namespace of the Lease of the leader election. Default is the namespace of the controller.")
+	leaseName = kubeFlags.String("leader-elect-lease", crmanager.DefaultLeaseName,
+		"Optional, name of the Lease of the leader election.")
 	ingressClass = kubeFlags.String("ingress-class", "f5",
 		"Optional, default `f5`. A class of the Ingress controller. The Ingress controller only processes Ingress"+
 			"resources that belong to its class - i.e. have the annotation `kubernetes.io/ingress.class` equal to the class."+
@@ -371,9 +549,18 @@ func _init() {
 	}
 }
 
-func initLogger(logLevel string) error {
-	log.RegisterLogger(
-		log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, clog.NewConsoleLogger())
+func initLogger(logLevel, logFormat string) error {
+	switch logFormat {
+	case "text":
+		log.RegisterLogger(
+			log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, clog.NewConsoleLogger())
+	case "json":
+		log.RegisterLogger(
+			log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, clog.NewJSONLogger())
+	default:
+		return fmt.Errorf("Unknown log format requested: %s\n"+
+			"    Valid log formats are: text, json", logFormat)
+	}
 
 	if ll := log.NewLogLevel(logLevel); nil != ll {
 		log.SetLogLevel(*ll)
@@ -389,6 +576,26 @@ func init() {
 	_init()
 }
 
+// splitPartitions returns the partitions repeated or comma separated
+func splitPartitions(values []string) []string {
+	var partitions []string
+	for _, value := range values {
+		for _, partition := range strings.Split(value, ",") {
+			if partition = strings.TrimSpace(partition); partition != "" {
+				partitions = append(partitions, partition)
+			}
+		}
+	}
+	return partitions
+}
+
+// allowedCRPartitions returns the partitions the custom resources can
+// select besides the first partition of bigip-partition
+func allowedCRPartitions() []string {
+	partitions := append([]string{}, (*bigIPPartitions)[1:]...)
+	return append(partitions, *allowedPartitions...)
+}
+
 func hasCommonPartition(partitions []string) bool {
 	for _, x := range partitions {
 		if x == "Common" {
@@ -400,7 +607,8 @@ func hasCommonPartition(partitions []string) bool {
 
 func verifyArgs() error {
 	*logLevel = strings.ToUpper(*logLevel)
-	logErr := initLogger(*logLevel)
+	*logFormat = strings.ToLower(*logFormat)
+	logErr := initLogger(*logLevel, *logFormat)
 	if nil != logErr {
 		return logErr
 	}
@@ -409,6 +617,7 @@ func verifyArgs() error {
 		return fmt.Errorf("missing pool member type")
 	}
 
+	*bigIPPartitions = splitPartitions(*bigIPPartitions)
 	if len(*bigIPPartitions) == 0 {
 		return fmt.Errorf("missing a BIG-IP partition")
 	} else if len(*bigIPPartitions) > 0 {
@@ -418,7 +627,11 @@ func verifyArgs() error {
 		}
 	}
 
-	if (len(*bigIPURL) == 0 || len(*bigIPUsername) == 0 ||
+	if *dryRun && !*customResourceMode {
+		return fmt.Errorf("dry-run is only supported in custom resource mode")
+	}
+
+	if !*dryRun && (len(*bigIPURL) == 0 || len(*bigIPUsername) == 0 ||
 		len(*bigIPPassword) == 0) && len(*credsDir) == 0 {
 		return fmt.Errorf("Missing BIG-IP credentials info")
 	}
@@ -483,6 +696,91 @@ func verifyArgs() error {
 				"Usage: --userdefined-as3-declaration=<namespace>/<configmap-name>")
 		}
 	}
+	if _, err := crmanager.NewNamer(*namingScheme, *nameEscaping); err != nil {
+		return err
+	}
+	if _, err := crmanager.ParseNamespaceRouteDomains(
+		*namespaceRouteDomains); err != nil {
+		return fmt.Errorf("Invalid value provided for --namespace-route-domain: %v",
+			err)
+	}
+	for _, partition := range *allowedPartitions {
+		if err := crmanager.ValidatePartition(partition); err != nil {
+			return fmt.Errorf("Invalid value provided for --allowed-partitions: %v",
+				err)
+		}
+	}
+	if _, err := crmanager.ParseNamespacePartitions(*namespacePartitions,
+		append([]string{(*bigIPPartitions)[0]},
+			allowedCRPartitions()...)); err != nil {
+		return fmt.Errorf("Invalid value provided for --namespace-partition: %v",
+			err)
+	}
+	if *sharedObjectsPartition != "" && *sharedObjectsPartition != "Common" {
+		if err := crmanager.ValidatePartition(
+			*sharedObjectsPartition); err != nil {
+			return fmt.Errorf("Invalid value provided for --shared-objects-partition: %v",
+				err)
+		}
+	}
+	if *sharedVIPPolicy != crmanager.SharedVIPMerge &&
+		*sharedVIPPolicy != crmanager.SharedVIPReject {
+		return fmt.Errorf("Invalid value provided for --shared-vip-policy: %s",
+			*sharedVIPPolicy)
+	}
+	if err := crmanager.ValidateICMPEcho(*defaultICMPEcho); err != nil {
+		return fmt.Errorf("Invalid value provided for --default-icmp-echo: %v",
+			err)
+	}
+	if err := crmanager.ValidateSNAT(*defaultSNAT); err != nil {
+		return fmt.Errorf("Invalid value provided for --default-snat: %v", err)
+	}
+	if *debugAddress != "" && *debugToken == "" {
+		host, _, err := net.SplitHostPort(*debugAddress)
+		if err != nil {
+			return fmt.Errorf("Invalid value provided for --debug-address: %v", err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" &&
+			(nil == ip || !ip.IsLoopback()) {
+			return fmt.Errorf("Missing required parameter debug-token " +
+				"for non-loopback debug-address")
+		}
+	}
+	if *webhookAddress != "" && (*webhookCertFile == "" || *webhookKeyFile == "") {
+		return fmt.Errorf("Missing required parameters webhook-cert-file and " +
+			"webhook-key-file for webhook-address")
+	}
+	if *processingWorkers < 1 {
+		return fmt.Errorf("Invalid value provided for --processing-workers: %d",
+			*processingWorkers)
+	}
+	if *flushInterval < 0 {
+		return fmt.Errorf("Invalid value provided for --flush-interval: %v",
+			*flushInterval)
+	}
+	if *shutdownGracePeriod < 0 {
+		return fmt.Errorf("Invalid value provided for --shutdown-grace-period: %v",
+			*shutdownGracePeriod)
+	}
+	if *processingStallTimeout < 0 {
+		return fmt.Errorf("Invalid value provided for --processing-stall-timeout: %v",
+			*processingStallTimeout)
+	}
+	if *resyncPeriod < 0 {
+		return fmt.Errorf("Invalid value provided for --resync-period: %v",
+			*resyncPeriod)
+	}
+	if *ipam && len(*ipamRanges) == 0 {
+		return fmt.Errorf("Missing required parameter ipam-range")
+	}
+	if *namespaceMaxVirtualServers < 0 || *namespaceMaxVirtualAddresses < 0 ||
+		*namespaceMaxPools < 0 {
+		return fmt.Errorf("Namespace quota cannot be negative")
+	}
+	if *warnCertExpiryDays < 0 {
+		return fmt.Errorf("Invalid value provided for --warn-cert-expiry-days: %v",
+			*warnCertExpiryDays)
+	}
 	return nil
 }
 
@@ -664,6 +962,14 @@ func initCustomResourceManager(
 	config *rest.Config,
 ) *crmanager.CRManager {
 
+	if *leaderElect && *leaseNamespace == "" {
+		ns, err := crmanager.ControllerNamespace()
+		if err != nil {
+			log.Fatalf("[INIT] %v, provide --leader-elect-namespace", err)
+		}
+		*leaseNamespace = ns
+	}
+
 	postMgrParams := crmanager.PostParams{
 		BIGIPUsername: *bigIPUsername,
 		BIGIPPassword: *bigIPPassword,
@@ -681,21 +987,80 @@ func initCustomResourceManager(
 		VerifyInterval: *verifyInterval,
 		VXLANName:      vxlanName,
 		PythonBaseDir:  *pythonBaseDir,
+		HTTPAddress:    *httpAddress,
+	}
+	var agent *crmanager.Agent
+	tunnelName, tunnelMode := vxlanName, vxlanMode
+	if *dryRun {
+		// The declarations are written instead of posted, and the network
+		// of BIG-IP is not configured
+		tunnelName, tunnelMode = "", ""
+		dryRunWriter := crmanager.NewDryRunWriter(os.Stdout)
+		agent = crmanager.NewDryRunAgent((*bigIPPartitions)[0], dryRunWriter)
+		agent.HTTPMux.Handle("/declaration", dryRunWriter)
+		go func() {
+			log.Fatal(http.ListenAndServe(*httpAddress, agent.HTTPMux).Error())
+		}()
+	} else {
+		agent = crmanager.NewAgent(agentParams)
 	}
-	agent := crmanager.NewAgent(agentParams)
+	// Expose Prometheus metrics
+	agent.HTTPMux.Handle("/metrics", promhttp.Handler())
+	bigIPPrometheus.RegisterMetrics()
 
 	crMgr := crmanager.NewCRManager(
 		crmanager.Params{
-			Config:            config,
-			Namespaces:        *namespaces,
-			Partition:         (*bigIPPartitions)[0],
-			Agent:             agent,
-			ControllerMode:    *poolMemberType,
-			VXLANName:         vxlanName,
-			VXLANMode:         vxlanMode,
-			UseNodeInternal:   *useNodeInternal,
-			NodePollInterval:  *nodePollInterval,
-			NodeLabelSelector: *nodeLabelSelector,
+			Config:                config,
+			Namespaces:            *namespaces,
+			Partition:             (*bigIPPartitions)[0],
+			Agent:                 agent,
+			ControllerMode:        *poolMemberType,
+			VXLANName:             tunnelName,
+			VXLANMode:             tunnelMode,
+			UseNodeInternal:       *useNodeInternal,
+			NodePollInterval:      *nodePollInterval,
+			NodeLabelSelector:     *nodeLabelSelector,
+			DefaultRouteDomain:    int32(*defaultRouteDomain),
+			NamespaceRouteDomains: *namespaceRouteDomains,
+			NamespacePartitions:   *namespacePartitions,
+			NamingScheme:          *namingScheme,
+			NameEscaping:          *nameEscaping,
+			UseResourceNames:      *useResourceNames,
+			AllowedPartitions:     allowedCRPartitions(),
+			SharedPartition:       *sharedObjectsPartition,
+			SharedVIPPolicy:       *sharedVIPPolicy,
+			DefaultSNAT:           *defaultSNAT,
+			DefaultICMPEcho:       *defaultICMPEcho,
+			DisableARP:            *disableARP,
+			DebugAddress:          *debugAddress,
+			DebugToken:            *debugToken,
+			ServeResources:        *serveResources,
+			UseEndpointSlices:     *useEndpointSlices,
+			ProcessingWorkers:     *processingWorkers,
+			FlushInterval:         *flushInterval,
+			ResyncPeriod:          *resyncPeriod,
+			PruneOnStartup:        *pruneOnStartup,
+			ShutdownGracePeriod:   *shutdownGracePeriod,
+			StallTimeout:          *processingStallTimeout,
+			DisableFinalizers:     *disableFinalizers,
+			WebhookAddress:        *webhookAddress,
+			WebhookCertFile:       *webhookCertFile,
+			WebhookKeyFile:        *webhookKeyFile,
+			ManageCRDs:            *manageCRDs,
+			DisableInactive:       *disableInactive,
+			FilterUnhealthyNodes:  *filterUnhealthyNodes,
+			IPAM:                  *ipam,
+			IPAMRanges:            *ipamRanges,
+			IPAMNamespace:         *ipamNamespace,
+			LeaderElection:        *leaderElect,
+			LeaseNamespace:        *leaseNamespace,
+			LeaseName:             *leaseName,
+			CertExpiryWarnDays:    *warnCertExpiryDays,
+			NamespaceQuota: crmanager.NamespaceQuota{
+				MaxVirtualServers:   *namespaceMaxVirtualServers,
+				MaxVirtualAddresses: *namespaceMaxVirtualAddresses,
+				MaxPools:            *namespaceMaxPools,
+			},
 		},
 	)
 
@@ -719,11 +1084,13 @@ func main() {
 		flags.Usage()
 		os.Exit(1)
 	}
-	err = getCredentials()
-	if nil != err {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		flags.Usage()
-		os.Exit(1)
+	if !*dryRun {
+		err = getCredentials()
+		if nil != err {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			flags.Usage()
+			os.Exit(1)
+		}
 	}
 
 	log.Infof("[INIT] Starting: Container Ingress Services - Version: %s, BuildInfo: %s", version, buildInfo)
@@ -764,10 +1131,18 @@ func main() {
 
 	if *customResourceMode {
 		crMgr := initCustomResourceManager(config)
+		resyncSigs := make(chan os.Signal, 1)
+		signal.Notify(resyncSigs, syscall.SIGUSR1)
+		go func() {
+			for range resyncSigs {
+				log.Infof("Full resync triggered by SIGUSR1")
+				crMgr.RequestResync()
+			}
+		}()
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-sigs
-		crMgr.Stop()
+		crMgr.Shutdown()
 		log.Infof("Exiting - signal %v\n", sig)
 		return
 	}
@@ -869,15 +1244,16 @@ func main() {
 
 	setupWatchers(appMgr, 30*time.Second)
 	// Expose Prometheus metrics
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 	// Add health check e.g. is Python process still there?
 	hc := &health.HealthChecker{
 		SubPID: subPid,
 	}
-	http.Handle("/health", hc.HealthCheckHandler())
+	mux.Handle("/health", hc.HealthCheckHandler())
 	bigIPPrometheus.RegisterMetrics()
 	go func() {
-		log.Fatal(http.ListenAndServe(*httpAddress, nil).Error())
+		log.Fatal(http.ListenAndServe(*httpAddress, mux).Error())
 	}()
 
 	stopCh := make(chan struct{})
@@ -285,6 +285,33 @@ var _ = Describe("Main Tests", func() {
 			Expect(hasCommon).To(BeTrue())
 		})
 
+		It("splits the comma separated partitions", func() {
+			defer _init()
+			os.Args = []string{
+				"./bin/k8s-bigip-ctlr",
+				"--namespace=testing",
+				"--bigip-partition=velcro1, velcro2",
+				"--bigip-partition=velcro3",
+				"--bigip-password=admin",
+				"--bigip-url=bigip.example.com",
+				"--bigip-username=admin",
+				"--allowed-partitions=velcro4",
+				"--namespace-partition=testing=velcro2"}
+			flags.Parse(os.Args)
+			Expect(verifyArgs()).To(BeNil())
+			Expect(*bigIPPartitions).To(Equal(
+				[]string{"velcro1", "velcro2", "velcro3"}))
+			Expect(allowedCRPartitions()).To(Equal(
+				[]string{"velcro2", "velcro3", "velcro4"}))
+
+			*namespacePartitions = []string{"testing=velcro5"}
+			Expect(verifyArgs()).NotTo(BeNil())
+			*namespacePartitions = []string{"testing=velcro1"}
+			Expect(verifyArgs()).To(BeNil())
+			*bigIPPartitions = []string{"velcro1,Common"}
+			Expect(verifyArgs()).NotTo(BeNil())
+		})
+
 		It("verifies args labels", func() {
 			defer _init()
 			os.Args = []string{
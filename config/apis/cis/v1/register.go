@@ -40,6 +40,14 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&VirtualServerList{},
 		&TLSProfile{},
 		&TLSProfileList{},
+		&TransportServer{},
+		&TransportServerList{},
+		&IngressLink{},
+		&IngressLinkList{},
+		&ExternalDNS{},
+		&ExternalDNSList{},
+		&Policy{},
+		&PolicyList{},
 	)
 
 	scheme.AddKnownTypes(
@@ -2,6 +2,7 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
@@ -13,7 +14,21 @@ type VirtualServer struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec VirtualServerSpec `json:"spec"`
+	Spec   VirtualServerSpec   `json:"spec"`
+	Status VirtualServerStatus `json:"status,omitempty"`
+}
+
+// VirtualServerStatus is the status of the VirtualServer resource.
+type VirtualServerStatus struct {
+	// VSAddress is the address of the virtuals.
+	VSAddress string `json:"vsAddress,omitempty"`
+	// VirtualNames are the names of the virtuals on BIG-IP.
+	VirtualNames []string `json:"virtualNames,omitempty"`
+	// Status is either Ready, Degraded or Error.
+	Status string `json:"status,omitempty"`
+	// Message describes the Status.
+	Message     string      `json:"message,omitempty"`
+	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
 }
 
 // VirtualServerSpec is the spec of the VirtualServer resource.
@@ -22,15 +37,264 @@ type VirtualServerSpec struct {
 	VirtualServerAddress string `json:"virtualServerAddress"`
 	Pools                []Pool `json:"pools"`
 	TLSProfileName       string `json:"tlsProfileName"`
-	HTTPTraffic          string `json:"httpTraffic,omitempty"`
+	// TLSProfileNames are the TLSProfiles of more hosts served with other
+	// certificates selected with SNI, the certificate of TLSProfileName (or
+	// else of the first TLSProfile) is the default.
+	TLSProfileNames []string `json:"tlsProfileNames,omitempty"`
+	HTTPTraffic     string   `json:"httpTraffic,omitempty"`
+	// Hosts are the hosts served alike by the VirtualServer, instead of
+	// Host.
+	Hosts []string `json:"hosts,omitempty"`
+	// HostGroup shares the address of the VirtualServers of the group,
+	// the ones without VirtualServerAddress use the address of the group.
+	HostGroup string `json:"hostGroup,omitempty"`
+	// IPAMLabel is used to allocate the address from IPAM when
+	// VirtualServerAddress is not provided.
+	IPAMLabel string `json:"ipamLabel,omitempty"`
+	// PartialErrorPolicy is either reject or skipInvalidPools, it
+	// defines the handling of pools referring to nonexistent services.
+	PartialErrorPolicy string `json:"partialErrorPolicy,omitempty"`
+	// IRules are the BIG-IP iRules attached to the virtual, either full
+	// paths like /Common/my_irule or names in the partition of CIS.
+	IRules []string `json:"iRules,omitempty"`
+	// PersistenceProfile is either cookie, source_addr, ssl or the path
+	// of a persistence profile on BIG-IP.
+	PersistenceProfile string `json:"persistenceProfile,omitempty"`
+	// SNAT is either automap, none or the path of a SNAT pool on BIG-IP,
+	// defaults to the --default-snat of CIS.
+	SNAT string `json:"snat,omitempty"`
+	// SNATAddresses are the addresses of a SNAT pool created with the
+	// virtual, instead of snat. They are in the route domain of the virtual
+	// unless given like 10.1.1.1%2.
+	SNATAddresses []string `json:"snatAddresses,omitempty"`
+	// WAF is the path of the WAF policy on BIG-IP like /Common/WAF_Policy
+	WAF string `json:"waf,omitempty"`
+	// Profiles are the BIG-IP profiles attached to the virtual
+	Profiles ProfileSpec `json:"profiles,omitempty"`
+	// AllowVLANs restricts the virtual to the VLANs, either full paths
+	// like /Common/external or names in /Common. All VLANs when empty.
+	AllowVLANs []string `json:"allowVlans,omitempty"`
+	// ConnectionLimit is the maximum number of concurrent connections,
+	// unlimited when 0.
+	ConnectionLimit int32 `json:"connectionLimit,omitempty"`
+	// RateLimit is the maximum number of connections per second,
+	// unlimited when 0.
+	RateLimit int32 `json:"rateLimit,omitempty"`
+	// PolicyName is the name of a Policy in the namespace of the
+	// VirtualServer, the fields set on the VirtualServer take precedence.
+	PolicyName string `json:"policyName,omitempty"`
+	// RedirectCode is the status code of the redirect of HTTP to HTTPS,
+	// either 301, 302, 307 or 308, defaults to 302.
+	RedirectCode int32 `json:"redirectCode,omitempty"`
+	// RedirectHost replaces the host and port of the redirect location,
+	// like example.com:8443.
+	RedirectHost string `json:"redirectHost,omitempty"`
+	// HSTS inserts the Strict-Transport-Security header in the HTTPS
+	// responses of the host.
+	HSTS *HSTS `json:"hsts,omitempty"`
+	// DefaultPool is the default pool of the virtual, it gets the requests
+	// matching no host and path.
+	DefaultPool *DefaultPool `json:"defaultPool,omitempty"`
+	// Partition is the BIG-IP partition of the virtual and its pools,
+	// one of the --allowed-partitions of CIS. Defaults to the partition
+	// of CIS.
+	Partition string `json:"partition,omitempty"`
+	// ICMPEcho of the virtual address, either enable, disable or selective
+	ICMPEcho string `json:"icmpEcho,omitempty"`
+	// ARP enables ARP of the virtual address, set false for addresses
+	// advertised by BGP instead
+	ARP *bool `json:"arp,omitempty"`
+	// AllowSourceRange restricts the pools to the clients of the CIDRs,
+	// the connections of other clients are reset. All clients when empty.
+	AllowSourceRange []string `json:"allowSourceRange,omitempty"`
+	// Maintenance sends all the requests of the host to a redirect or a
+	// BIG-IP pool when enabled, over the rules of the pools.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+	// DisableVirtualServer disables the virtual on BIG-IP, its connections
+	// are rejected and its configuration is kept.
+	DisableVirtualServer bool `json:"disableVirtualServer,omitempty"`
+}
+
+// DefaultPool defines the default pool of the virtual.
+type DefaultPool struct {
+	Service     string `json:"service"`
+	ServicePort int32  `json:"servicePort"`
+}
+
+// Maintenance defines the redirect or the pool of the requests of a host
+// during maintenance, either redirectURL or pool.
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+	// RedirectURL is the location of the redirect, like
+	// https://status.example.com/maintenance.
+	RedirectURL string `json:"redirectURL,omitempty"`
+	// Pool is the path of a pool on BIG-IP like /Common/maintenance_pool
+	Pool string `json:"pool,omitempty"`
+}
+
+// HSTS defines the Strict-Transport-Security header of the HTTPS
+// responses.
+type HSTS struct {
+	Enabled bool `json:"enabled"`
+	// MaxAge is the max-age of the header in seconds, defaults to one
+	// year.
+	MaxAge            *int64 `json:"maxAge,omitempty"`
+	IncludeSubdomains bool   `json:"includeSubdomains,omitempty"`
+	Preload           bool   `json:"preload,omitempty"`
+}
+
+// ProfileSpec references existing HTTP and TCP profiles on BIG-IP.
+type ProfileSpec struct {
+	HTTP string     `json:"http,omitempty"`
+	TCP  ProfileTCP `json:"tcp,omitempty"`
+}
+
+// ProfileTCP references the client side and server side TCP profiles.
+type ProfileTCP struct {
+	Client string `json:"client,omitempty"`
+	Server string `json:"server,omitempty"`
 }
 
 // Pool defines a pool object in BIG-IP.
 type Pool struct {
-	Path            string `json:"path"`
-	Service         string `json:"service"`
-	ServicePort     int32  `json:"servicePort"`
-	NodeMemberLabel string `json:"nodeMemberLabel"`
+	Path    string `json:"path"`
+	Service string `json:"service"`
+	// ServicePort is either the port number or the name of the port of
+	// the service.
+	ServicePort     intstr.IntOrString `json:"servicePort"`
+	NodeMemberLabel string             `json:"nodeMemberLabel"`
+	// NodeMemberSelector selects the nodes of the nodeport members with
+	// matchLabels and matchExpressions, along with the nodeMemberLabel.
+	NodeMemberSelector *metav1.LabelSelector `json:"nodeMemberSelector,omitempty"`
+	// PathMatchType is either prefix, exact or regex, defaults to prefix.
+	PathMatchType string `json:"pathMatchType,omitempty"`
+	// Weight splits the traffic of the pools with the same path, defaults
+	// to 100. Pools with weight 0 get no traffic.
+	Weight *int32 `json:"weight,omitempty"`
+	// Rewrite rewrites the path and host of the requests of the pool.
+	Rewrite *Rewrite `json:"rewrite,omitempty"`
+	// HostRewrite replaces the Host header of the requests of the pool,
+	// like the targetHost of the rewrite.
+	HostRewrite string `json:"hostRewrite,omitempty"`
+	// ResponseHeaders sets and removes the headers of the responses of the
+	// pool.
+	ResponseHeaders *ResponseHeaders `json:"responseHeaders,omitempty"`
+	// AllowSourceRange replaces the allowSourceRange of the VirtualServer
+	// for the pool.
+	AllowSourceRange []string `json:"allowSourceRange,omitempty"`
+	// MatchMethod restricts the pool to the requests with one of the HTTP
+	// methods, like GET or POST.
+	MatchMethod []string `json:"matchMethod,omitempty"`
+	// MatchQueryParams restricts the pool to the requests with all the
+	// query parameters, each one is name=value.
+	MatchQueryParams []string `json:"matchQueryParams,omitempty"`
+	// Action resets, drops or redirects the requests of the path instead
+	// of forwarding them to a service.
+	Action *PoolAction `json:"action,omitempty"`
+	// PoolMemberType is either cluster or nodeport, defaults to the
+	// --pool-member-type of CIS.
+	PoolMemberType string `json:"poolMemberType,omitempty"`
+	// IncludeNotReady adds the endpoints which are not ready as pool
+	// members.
+	IncludeNotReady bool `json:"includeNotReady,omitempty"`
+	// BackupPool adds the backup members to the BIG-IP pool with priority
+	// group 0, serving when less than minActiveMembers of the service are
+	// available.
+	BackupPool *BackupPool `json:"backupPool,omitempty"`
+	// PriorityGroup of the members of the service, defaults to 1 with a
+	// backupPool.
+	PriorityGroup int32 `json:"priorityGroup,omitempty"`
+	// MinActiveMembers of the priority group activation, defaults to 1
+	// with a backupPool.
+	MinActiveMembers int32 `json:"minActiveMembers,omitempty"`
+	// ServiceDownAction is the action of BIG-IP on the connections to a
+	// member going down, either none, reset, drop or reselect, defaults
+	// to none.
+	ServiceDownAction string `json:"serviceDownAction,omitempty"`
+	// StaticMembers are pool members outside the cluster, in addition to
+	// or instead of the members of the service.
+	StaticMembers []StaticMember `json:"staticMembers,omitempty"`
+	// Ports expose several ports of the service under different paths,
+	// instead of path and servicePort. Each one is a pool with the other
+	// settings of this pool.
+	Ports []PoolPort `json:"ports,omitempty"`
+	// Monitor is a health monitor of the pool created on BIG-IP, instead
+	// of monitors.
+	Monitor *Monitor `json:"monitor,omitempty"`
+	// Monitors are the paths of health monitors on BIG-IP, like
+	// /Common/http. Names without partition refer to the partition of CIS.
+	Monitors []string `json:"monitors,omitempty"`
+	// MinimumMonitors is the number of monitors up for a member to be up,
+	// defaults to all the monitors.
+	MinimumMonitors int32 `json:"minimumMonitors,omitempty"`
+}
+
+// Monitor defines a health monitor of a pool.
+type Monitor struct {
+	// Type is either http, https, tcp or udp.
+	Type string `json:"type"`
+	// Send is the request of the monitor, like GET / for http.
+	Send string `json:"send,omitempty"`
+	// Recv is the pattern of the responses of the members up.
+	Recv string `json:"recv,omitempty"`
+	// Interval between the checks in seconds.
+	Interval int `json:"interval,omitempty"`
+	// Timeout in seconds after which a member not responding is down.
+	Timeout int `json:"timeout,omitempty"`
+	// TargetAddress is the address checked instead of the members, in the
+	// route domain of the virtual unless given like 10.1.1.1%2.
+	TargetAddress string `json:"targetAddress,omitempty"`
+	// TargetPort is the port checked instead of the port of the members.
+	TargetPort int32 `json:"targetPort,omitempty"`
+}
+
+// PoolPort defines the path and the port of the service of a pool.
+type PoolPort struct {
+	Path        string             `json:"path"`
+	ServicePort intstr.IntOrString `json:"servicePort"`
+}
+
+// StaticMember defines a pool member outside the cluster, the address may
+// have a route domain like 10.1.1.1%2.
+type StaticMember struct {
+	Address string `json:"address"`
+	Port    int32  `json:"port"`
+}
+
+// BackupPool defines the backup members of a pool, either the members of
+// a service or static addresses.
+type BackupPool struct {
+	Service     string   `json:"service,omitempty"`
+	ServicePort int32    `json:"servicePort,omitempty"`
+	Addresses   []string `json:"addresses,omitempty"`
+	Port        int32    `json:"port,omitempty"`
+}
+
+// PoolAction defines the action on the requests of a path without service.
+type PoolAction struct {
+	// Type is either reset, drop or redirect.
+	Type string `json:"type"`
+	// Location is the URL of the redirect.
+	Location string `json:"location,omitempty"`
+	// Code is the status code of the redirect, either 301, 302, 307 or
+	// 308, defaults to 302.
+	Code int32 `json:"code,omitempty"`
+}
+
+// Rewrite replaces the path of the pool in the request URI with
+// TargetPath and the Host header with TargetHost.
+type Rewrite struct {
+	TargetPath string `json:"targetPath,omitempty"`
+	TargetHost string `json:"targetHost,omitempty"`
+}
+
+// ResponseHeaders modifies the headers of the HTTP responses.
+type ResponseHeaders struct {
+	// Set replaces the values of the headers by name, the headers missing
+	// from the response are inserted.
+	Set map[string]string `json:"set,omitempty"`
+	// Remove removes the headers by name.
+	Remove []string `json:"remove,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -66,6 +330,23 @@ type TLS struct {
 	ClientSSL   string `json:"clientSSL"`
 	ServerSSL   string `json:"serverSSL"`
 	Reference   string `json:"reference"`
+	// ClientAuth enables the authentication of clients with certificates,
+	// only with the secret reference.
+	ClientAuth *ClientAuth `json:"clientAuth,omitempty"`
+	// SNIDefault makes the certificate the default of the virtual for the
+	// clients without a matching server name, only with the secret
+	// reference.
+	SNIDefault bool `json:"sniDefault,omitempty"`
+}
+
+// ClientAuth contains the fields of client certificate authentication
+type ClientAuth struct {
+	// CACertificate is either the name of a secret with the CA certificates
+	// in ca.crt, or the path of a CA bundle on BIG-IP like
+	// /Common/ca-bundle.crt.
+	CACertificate string `json:"caCertificate"`
+	// Mode is either require or request, defaults to require.
+	Mode string `json:"mode,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -77,3 +358,174 @@ type TLSProfileList struct {
 
 	Items []TLSProfile `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TransportServer is a Custom Resource for plain TCP or UDP virtual servers
+type TransportServer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TransportServerSpec `json:"spec"`
+}
+
+// TransportServerSpec is the spec of the TransportServer resource.
+type TransportServerSpec struct {
+	VirtualServerAddress string `json:"virtualServerAddress"`
+	VirtualServerPort    int32  `json:"virtualServerPort,omitempty"`
+	// VirtualServerPorts are the ports of the virtual instead of
+	// VirtualServerPort.
+	VirtualServerPorts []int32 `json:"virtualServerPorts,omitempty"`
+	// VirtualServerPortRange is the range of the ports of the virtual,
+	// like 30000-30100, instead of VirtualServerPort.
+	VirtualServerPortRange string `json:"virtualServerPortRange,omitempty"`
+	// PortMapping is either same or offset, defaults to same. With same the
+	// requests of all the ports go to the port of the pool members, with
+	// offset the requests of the first port plus n go to the port of the
+	// members plus n.
+	PortMapping string `json:"portMapping,omitempty"`
+	// Mode is either performance-l4 or standard, defaults to standard.
+	Mode string `json:"mode,omitempty"`
+	// Type is either tcp or udp, defaults to tcp.
+	Type string              `json:"type,omitempty"`
+	Pool TransportServerPool `json:"pool"`
+}
+
+// TransportServerPool defines the pool of the TransportServer.
+type TransportServerPool struct {
+	Service     string `json:"service"`
+	ServicePort int32  `json:"servicePort"`
+	// Monitor is the path of a health monitor on BIG-IP, defaults to the
+	// tcp or udp monitor.
+	Monitor string `json:"monitor,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// TransportServerList is a list of the TransportServer resources.
+type TransportServerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TransportServer `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IngressLink is a Custom Resource to front an ingress controller running
+// in the cluster with BIG-IP
+type IngressLink struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IngressLinkSpec `json:"spec"`
+}
+
+// IngressLinkSpec is the spec of the IngressLink resource.
+type IngressLinkSpec struct {
+	VirtualServerAddress string `json:"virtualServerAddress"`
+	// Selector selects the service of the ingress controller.
+	Selector *metav1.LabelSelector `json:"selector"`
+	IRules   []string              `json:"iRules,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IngressLinkList is a list of the IngressLink resources.
+type IngressLinkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []IngressLink `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExternalDNS is a Custom Resource for a Wide-IP on BIG-IP DNS pointing to
+// the virtuals of the VirtualServers with the same host
+type ExternalDNS struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ExternalDNSSpec `json:"spec"`
+}
+
+// ExternalDNSSpec is the spec of the ExternalDNS resource.
+type ExternalDNSSpec struct {
+	DomainName string `json:"domainName"`
+	// DNSRecordType is either A or AAAA, defaults to A.
+	DNSRecordType string `json:"dnsRecordType,omitempty"`
+	// LoadBalanceMethod of the pools, defaults to round-robin.
+	LoadBalanceMethod string    `json:"loadBalanceMethod,omitempty"`
+	Pools             []DNSPool `json:"pools"`
+}
+
+// DNSPool defines a pool of the Wide-IP.
+type DNSPool struct {
+	Name string `json:"name"`
+	// DataServerName is the BIG-IP server of the virtuals, like
+	// /Common/bigip.
+	DataServerName    string       `json:"dataServerName"`
+	DNSRecordType     string       `json:"dnsRecordType,omitempty"`
+	LoadBalanceMethod string       `json:"loadBalanceMethod,omitempty"`
+	Monitors          []DNSMonitor `json:"monitors,omitempty"`
+}
+
+// DNSMonitor defines a health monitor of a DNS pool.
+type DNSMonitor struct {
+	Type     string `json:"type"`
+	Send     string `json:"send,omitempty"`
+	Recv     string `json:"recv,omitempty"`
+	Interval int    `json:"interval,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ExternalDNSList is a list of the ExternalDNS resources.
+type ExternalDNSList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ExternalDNS `json:"items"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Policy is a Custom Resource holding the settings shared by VirtualServers
+type Policy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PolicySpec `json:"spec"`
+}
+
+// PolicySpec is the spec of the Policy resource.
+type PolicySpec struct {
+	// Profiles are the BIG-IP profiles attached to the virtual
+	Profiles ProfileSpec `json:"profiles,omitempty"`
+	// IRules are the BIG-IP iRules attached to the virtual
+	IRules []string `json:"iRules,omitempty"`
+	// SNAT is either automap, none or the path of a SNAT pool on BIG-IP
+	SNAT string `json:"snat,omitempty"`
+	// PersistenceProfile is either cookie, source_addr, ssl or the path
+	// of a persistence profile on BIG-IP.
+	PersistenceProfile string `json:"persistenceProfile,omitempty"`
+	// FirewallPolicy is the path of the AFM firewall policy on BIG-IP
+	// like /Common/fw_policy
+	FirewallPolicy string `json:"firewallPolicy,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PolicyList is a list of the Policy resources.
+type PolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Policy `json:"items"`
+}
@@ -26,7 +26,11 @@ import (
 
 type K8sV1Interface interface {
 	RESTClient() rest.Interface
+	ExternalDNSesGetter
+	IngressLinksGetter
+	PoliciesGetter
 	TLSProfilesGetter
+	TransportServersGetter
 	VirtualServersGetter
 }
 
@@ -35,10 +39,26 @@ type K8sV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *K8sV1Client) ExternalDNSes(namespace string) ExternalDNSInterface {
+	return newExternalDNSes(c, namespace)
+}
+
+func (c *K8sV1Client) IngressLinks(namespace string) IngressLinkInterface {
+	return newIngressLinks(c, namespace)
+}
+
+func (c *K8sV1Client) Policies(namespace string) PolicyInterface {
+	return newPolicies(c, namespace)
+}
+
 func (c *K8sV1Client) TLSProfiles(namespace string) TLSProfileInterface {
 	return newTLSProfiles(c, namespace)
 }
 
+func (c *K8sV1Client) TransportServers(namespace string) TransportServerInterface {
+	return newTransportServers(c, namespace)
+}
+
 func (c *K8sV1Client) VirtualServers(namespace string) VirtualServerInterface {
 	return newVirtualServers(c, namespace)
 }
@@ -100,6 +100,18 @@ func (c *FakeVirtualServers) Update(virtualServer *cisv1.VirtualServer) (result
 	return obj.(*cisv1.VirtualServer), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeVirtualServers) UpdateStatus(virtualServer *cisv1.VirtualServer) (*cisv1.VirtualServer, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(virtualserversResource, "status", c.ns, virtualServer), &cisv1.VirtualServer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*cisv1.VirtualServer), err
+}
+
 // Delete takes name of the virtualServer and deletes it. Returns an error if one occurs.
 func (c *FakeVirtualServers) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -18,6 +18,14 @@ limitations under the License.
 
 package v1
 
+type ExternalDNSExpansion interface{}
+
+type IngressLinkExpansion interface{}
+
+type PolicyExpansion interface{}
+
 type TLSProfileExpansion interface{}
 
+type TransportServerExpansion interface{}
+
 type VirtualServerExpansion interface{}
@@ -39,6 +39,7 @@ type VirtualServersGetter interface {
 type VirtualServerInterface interface {
 	Create(*v1.VirtualServer) (*v1.VirtualServer, error)
 	Update(*v1.VirtualServer) (*v1.VirtualServer, error)
+	UpdateStatus(*v1.VirtualServer) (*v1.VirtualServer, error)
 	Delete(name string, options *metav1.DeleteOptions) error
 	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
 	Get(name string, options metav1.GetOptions) (*v1.VirtualServer, error)
@@ -132,6 +133,22 @@ func (c *virtualServers) Update(virtualServer *v1.VirtualServer) (result *v1.Vir
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *virtualServers) UpdateStatus(virtualServer *v1.VirtualServer) (result *v1.VirtualServer, err error) {
+	result = &v1.VirtualServer{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("virtualservers").
+		Name(virtualServer.Name).
+		SubResource("status").
+		Body(virtualServer).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the virtualServer and deletes it. Returns an error if one occurs.
 func (c *virtualServers) Delete(name string, options *metav1.DeleteOptions) error {
 	return c.client.Delete().
@@ -24,8 +24,16 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ExternalDNSes returns a ExternalDNSInformer.
+	ExternalDNSes() ExternalDNSInformer
+	// IngressLinks returns a IngressLinkInformer.
+	IngressLinks() IngressLinkInformer
+	// Policies returns a PolicyInformer.
+	Policies() PolicyInformer
 	// TLSProfiles returns a TLSProfileInformer.
 	TLSProfiles() TLSProfileInformer
+	// TransportServers returns a TransportServerInformer.
+	TransportServers() TransportServerInformer
 	// VirtualServers returns a VirtualServerInformer.
 	VirtualServers() VirtualServerInformer
 }
@@ -41,11 +49,31 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ExternalDNSes returns a ExternalDNSInformer.
+func (v *version) ExternalDNSes() ExternalDNSInformer {
+	return &externalDNSInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// IngressLinks returns a IngressLinkInformer.
+func (v *version) IngressLinks() IngressLinkInformer {
+	return &ingressLinkInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// Policies returns a PolicyInformer.
+func (v *version) Policies() PolicyInformer {
+	return &policyInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // TLSProfiles returns a TLSProfileInformer.
 func (v *version) TLSProfiles() TLSProfileInformer {
 	return &tLSProfileInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// TransportServers returns a TransportServerInformer.
+func (v *version) TransportServers() TransportServerInformer {
+	return &transportServerInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // VirtualServers returns a VirtualServerInformer.
 func (v *version) VirtualServers() VirtualServerInformer {
 	return &virtualServerInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -17,16 +17,21 @@
 package crmanager
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/F5Networks/k8s-bigip-ctlr/pkg/writer"
 
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
 	rsc "github.com/F5Networks/k8s-bigip-ctlr/pkg/resource"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 )
@@ -59,10 +64,13 @@ func NewAgent(params AgentParams) *Agent {
 	}
 	agent := &Agent{
 		PostManager:  postMgr,
+		DeclWriter:   postMgr,
+		ObjectStore:  postMgr,
 		Partition:    params.Partition,
 		ConfigWriter: configWriter,
 		EventChan:    make(chan interface{}),
-		activeDecl:   "",
+		HTTPMux:      http.NewServeMux(),
+		httpAddress:  params.HTTPAddress,
 	}
 	// If running in VXLAN mode, extract the partition name from the tunnel
 	// to be used in configuring a net instance of CCCL for that partition
@@ -92,6 +100,8 @@ func NewAgent(params AgentParams) *Agent {
 		BigIPPartitions: []string{params.Partition},
 	}
 
+	agent.checkPortListSupport()
+
 	agent.startPythonDriver(
 		gs,
 		bs,
@@ -102,46 +112,159 @@ func NewAgent(params AgentParams) *Agent {
 }
 
 func (agent *Agent) Stop() {
-	agent.ConfigWriter.Stop()
+	if nil != agent.ConfigWriter {
+		agent.ConfigWriter.Stop()
+	}
 	agent.stopPythonDriver()
 }
 
-func (agent *Agent) PostConfig(config ResourceConfigWrapper) {
-	decl := createAS3Declaration(config)
-	if DeepEqualJSON(agent.activeDecl, decl) {
-		log.Debug("[AS3] No Change in the Configuration")
-		return
+// Drain marks the controller shutting down, the health check reports it
+// draining so that it is taken out of rotation
+func (agent *Agent) Drain() {
+	atomic.StoreInt32(&agent.draining, 1)
+}
+
+// Draining returns whether the controller is shutting down
+func (agent *Agent) Draining() bool {
+	return atomic.LoadInt32(&agent.draining) == 1
+}
+
+// AddLivenessCheck adds a check failing the health check of the controller
+func (agent *Agent) AddLivenessCheck(check func() error) {
+	agent.livenessMutex.Lock()
+	defer agent.livenessMutex.Unlock()
+	agent.livenessChecks = append(agent.livenessChecks, check)
+}
+
+// checkLiveness returns the error of the first liveness check failing
+func (agent *Agent) checkLiveness() error {
+	agent.livenessMutex.Lock()
+	checks := agent.livenessChecks
+	agent.livenessMutex.Unlock()
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return err
+		}
 	}
-	agent.Write(string(decl), nil)
-	agent.activeDecl = decl
+	return nil
+}
 
-	allPoolMembers := config.rsCfgs.GetAllPoolMembers()
+// CancelPosts stops posting the declarations written, including the one
+// being posted
+func (agent *Agent) CancelPosts() {
+	if nil != agent.DeclWriter {
+		agent.DeclWriter.Cancel()
+	}
+}
 
-	// Convert allPoolMembers to appmanger.Members so that vxlan Manger accepts
-	var allPoolMems []rsc.Member
+// PostConfig writes the declaration of the config to update the partitions
+// changed since the declaration last applied to BIG-IP, or not applied yet.
+// The other partitions are left as they are, the DeclarationWriter posts
+// each partition on its own so that one failing does not hold up the others.
+func (agent *Agent) PostConfig(config ResourceConfigWrapper) {
+	adc := createAS3ADC(config)
+	// The partitions without virtuals anymore are declared empty
+	for partition := range agent.activeTenants {
+		if _, ok := adc[partition]; !ok {
+			adc[partition] = emptyAS3Tenant()
+		}
+	}
+	tenants := make(map[string]activeTenant)
+	var partitions []string
+	for partition, tenant := range adc {
+		hash := tenantHash(tenant)
+		active, ok := agent.activeTenants[partition]
+		if ok && hash == active.hash &&
+			agent.DeclWriter.PartitionPosted(partition) >= active.seq {
+			tenants[partition] = active
+			continue
+		}
+		tenants[partition] = activeTenant{hash: hash}
+		partitions = append(partitions, partition)
+	}
+	if len(partitions) == 0 {
+		log.Debugf("[AS3] Skipped the declaration identical to the one " +
+			"applied")
+		bigIPPrometheus.SkippedDeclarations.Inc()
+		return
+	}
+	sort.Strings(partitions)
+	agent.DeclWriter.Write(string(as3DeclarationOf(adc)), partitions)
+	seq := agent.DeclWriter.Written()
+	for _, partition := range partitions {
+		tenants[partition] = activeTenant{
+			hash: tenants[partition].hash,
+			seq:  seq,
+		}
+	}
+	agent.activeTenants = tenants
 
-	for _, poolMem := range allPoolMembers {
-		allPoolMems = append(
-			allPoolMems,
-			rsc.Member(poolMem),
-		)
+	allPoolMembers := config.rsCfgs.GetAllPoolMembers(config.podNetworks)
+	var pools int
+	for _, cfg := range config.rsCfgs {
+		pools += len(cfg.Pools)
+	}
+	bigIPPrometheus.Pools.Set(float64(pools))
+	bigIPPrometheus.PoolMembers.Set(float64(len(allPoolMembers)))
+
+	// The removed members not written yet are written with the next
+	// update, unless they are members again
+	removed := make(map[string]bool)
+	for _, member := range allPoolMembers {
+		removed[member.Address] = true
 	}
+	var removedMembers []Member
+	for _, member := range append(agent.removedMembers,
+		config.removedMembers...) {
+		if !removed[member.Address] {
+			removed[member.Address] = true
+			removedMembers = append(removedMembers, member)
+		}
+	}
+	agent.removedMembers = removedMembers
+
 	if agent.EventChan != nil {
+		// Convert the members to resource Members so that VxlanMgr accepts
+		update := rsc.MemberUpdate{
+			Members: convertMembers(allPoolMembers),
+			Removed: convertMembers(removedMembers),
+			Full:    !agent.membersWritten,
+		}
 		select {
-		case agent.EventChan <- allPoolMems:
+		case agent.EventChan <- update:
 			log.Debugf("Custom Resource Manager wrote endpoints to VxlanMgr")
+			agent.membersWritten = true
+			agent.removedMembers = nil
 		case <-time.After(3 * time.Second):
 		}
 	}
 }
 
+// convertMembers returns the members as resource Members.
+func convertMembers(members []Member) []rsc.Member {
+	var rscMembers []rsc.Member
+	for _, member := range members {
+		rscMembers = append(rscMembers, rsc.Member{
+			Address: member.Address,
+			Port:    member.Port,
+			Session: member.Session,
+		})
+	}
+	return rscMembers
+}
+
 //Create AS3 declaration
 func createAS3Declaration(config ResourceConfigWrapper) as3Declaration {
+	return as3DeclarationOf(createAS3ADC(config))
+}
+
+// as3DeclarationOf returns the declaration of the tenants
+func as3DeclarationOf(tenants as3ADC) as3Declaration {
 	var as3Config map[string]interface{}
 	_ = json.Unmarshal([]byte(baseAS3Config), &as3Config)
 
 	adc := as3Config["declaration"].(map[string]interface{})
-	for k, v := range createAS3ADC(config) {
+	for k, v := range tenants {
 		adc[k] = v
 	}
 
@@ -153,11 +276,71 @@ func createAS3Declaration(config ResourceConfigWrapper) as3Declaration {
 }
 
 func createAS3ADC(config ResourceConfigWrapper) as3ADC {
+	// The allowed partitions are declared even without virtuals, so that
+	// the objects of the VirtualServers moved to another partition are
+	// removed. They are declared empty, as iRules and data groups outlive
+	// the virtuals using them.
+	partitions := map[string]bool{DEFAULT_PARTITION: true}
+	for _, partition := range config.partitions {
+		if _, ok := partitions[partition]; !ok {
+			partitions[partition] = false
+		}
+	}
+	virtualPartitions := make(map[string]string)
+	for _, cfg := range config.rsCfgs {
+		partitions[cfg.Virtual.Partition] = true
+		virtualPartitions[cfg.Virtual.Name] = cfg.Virtual.Partition
+	}
+	// The iRules and data groups may be shared in another partition
+	if config.sharedPartition != "" {
+		partitions[config.sharedPartition] = true
+	}
+
+	as3JSONDecl := as3ADC{}
+	for partition, used := range partitions {
+		if !used {
+			as3JSONDecl[partition] = emptyAS3Tenant()
+			continue
+		}
+		as3JSONDecl[partition] = createAS3Tenant(
+			config.forPartition(partition))
+	}
+	// The Wide-IPs are in /Common, the tenant is posted once an
+	// ExternalDNS is processed so the deleted Wide-IPs are removed.
+	if nil != config.dnsConfig {
+		gslbTenant := createGSLBTenant(config.dnsConfig, virtualPartitions)
+		if tenant, ok := as3JSONDecl["Common"].(as3Tenant); ok {
+			// Along with the shared iRules and data groups
+			sharedApp := tenant[as3SharedApplication].(as3Application)
+			for name, obj := range gslbTenant[as3SharedApplication].(as3Application) {
+				sharedApp[name] = obj
+			}
+		} else {
+			as3JSONDecl["Common"] = gslbTenant
+		}
+	}
+	return as3JSONDecl
+}
+
+// emptyAS3Tenant returns the tenant of a partition without objects
+func emptyAS3Tenant() as3Tenant {
+	return createAS3Tenant(ResourceConfigWrapper{
+		customProfiles: NewCustomProfiles(),
+	})
+}
 
+// createAS3Tenant returns the tenant of the objects of a partition.
+func createAS3Tenant(config ResourceConfigWrapper) as3Tenant {
 	// Create Shared as3Application object
 	sharedApp := as3Application{}
 	sharedApp["class"] = "Application"
 	sharedApp["template"] = "shared"
+	// Process IRules first, so the services refer to them by name
+	processIRulesForAS3(config.iRuleMap, sharedApp)
+
+	// Process virtual addresses first, so the services refer to them
+	processVirtualAddressesForAS3(config.rsCfgs, sharedApp)
+
 	// Process rscfg to create AS3 Resources
 	processResourcesForAS3(config.rsCfgs, sharedApp)
 
@@ -167,19 +350,111 @@ func createAS3ADC(config ResourceConfigWrapper) as3ADC {
 	// Process Profiles
 	processProfilesForAS3(config.rsCfgs, sharedApp)
 
-	processIRulesForAS3(config.iRuleMap, sharedApp)
-
 	processDataGroupForAS3(config.intDgMap, sharedApp)
 
-	// Create AS3 Tenant
-	tenant := as3Tenant{
+	return as3Tenant{
 		"class":              "Tenant",
 		as3SharedApplication: sharedApp,
 	}
-	as3JSONDecl := as3ADC{
-		DEFAULT_PARTITION: tenant,
+}
+
+// forPartition returns the virtuals, iRules, data groups and custom
+// profiles of the partition. The CA profiles of the server SSL profiles
+// belong to no virtual and are kept for all the partitions.
+func (config ResourceConfigWrapper) forPartition(
+	partition string,
+) ResourceConfigWrapper {
+	out := ResourceConfigWrapper{
+		iRuleMap:       make(IRulesMap),
+		intDgMap:       make(InternalDataGroupMap),
+		customProfiles: NewCustomProfiles(),
+	}
+	for _, cfg := range config.rsCfgs {
+		if cfg.Virtual.Partition == partition {
+			out.rsCfgs = append(out.rsCfgs, cfg)
+		}
+	}
+	for key, iRule := range config.iRuleMap {
+		if key.Partition == partition {
+			out.iRuleMap[key] = iRule
+		}
+	}
+	for key, nsDg := range config.intDgMap {
+		if key.Partition == partition {
+			out.intDgMap[key] = nsDg
+		}
+	}
+	if nil != config.customProfiles {
+		for key, prof := range config.customProfiles.Profs {
+			if key.ResourceName == "" || prof.Partition == partition {
+				out.customProfiles.Profs[key] = prof
+			}
+		}
+	}
+	return out
+}
+
+// createGSLBTenant returns the Common tenant with the Wide-IPs, pools and
+// monitors of BIG-IP DNS. The members are the virtuals in their partitions.
+func createGSLBTenant(
+	dnsConfig DNSConfig,
+	virtualPartitions map[string]string,
+) as3Tenant {
+	sharedApp := as3Application{}
+	sharedApp["class"] = "Application"
+	sharedApp["template"] = "shared"
+	for _, wideIP := range dnsConfig {
+		domain := &as3GSLBDomain{
+			Class:              "GSLB_Domain",
+			DomainName:         wideIP.DomainName,
+			ResourceRecordType: wideIP.RecordType,
+			PoolLbMode:         wideIP.LBMethod,
+		}
+		for _, pool := range wideIP.Pools {
+			gslbPool := &as3GSLBPool{
+				Class:              "GSLB_Pool",
+				ResourceRecordType: pool.RecordType,
+				LBModePreferred:    pool.LBMethod,
+			}
+			for _, member := range pool.Members {
+				partition, ok := virtualPartitions[member]
+				if !ok {
+					partition = DEFAULT_PARTITION
+				}
+				gslbPool.Members = append(gslbPool.Members,
+					as3GSLBPoolMember{
+						Server: as3ResourcePointer{BigIP: pool.DataServer},
+						VirtualServer: fmt.Sprintf("/%s/%s/%s",
+							partition,
+							as3SharedApplication,
+							member),
+					})
+			}
+			for _, mon := range pool.Monitors {
+				monitor := &as3Monitor{
+					Class:       "GSLB_Monitor",
+					MonitorType: mon.Type,
+					Interval:    mon.Interval,
+					Timeout:     mon.Timeout,
+					Send:        mon.Send,
+					Receive:     mon.Recv,
+				}
+				monitorName := AS3NameFormatter(mon.Name)
+				sharedApp[monitorName] = monitor
+				gslbPool.Monitors = append(gslbPool.Monitors,
+					as3ResourcePointer{Use: monitorName})
+			}
+			poolName := AS3NameFormatter(pool.Name)
+			sharedApp[poolName] = gslbPool
+			domain.Pools = append(domain.Pools,
+				as3ResourcePointer{Use: poolName})
+		}
+		sharedApp[AS3NameFormatter(wideIP.DomainName)] = domain
+	}
+	return as3Tenant{
+		"class":              "Tenant",
+		as3SharedApplication: sharedApp,
 	}
-	return as3JSONDecl
 }
 
 func processIRulesForAS3(iRuleMao IRulesMap, sharedApp as3Application) {
@@ -193,69 +468,75 @@ func processIRulesForAS3(iRuleMao IRulesMap, sharedApp as3Application) {
 }
 
 func processDataGroupForAS3(intDgMap InternalDataGroupMap, sharedApp as3Application) {
-	for idk, idg := range intDgMap {
-		for _, dg := range idg {
-			dataGroupRecord, found := sharedApp[dg.Name]
-			if !found {
-				dgMap := &as3DataGroup{}
-				dgMap.Class = "Data_Group"
-				dgMap.KeyDataType = "string"
-				for _, record := range dg.Records {
-					var rec as3Record
-					rec.Key = record.Name
-					// To override default Value created for CCCL for certain DG types
-					if val, ok := getDGRecordValueForAS3(idk.Name, sharedApp); ok {
-						rec.Value = val
-					} else {
-						rec.Value = record.Data
-					}
-					dgMap.Records = append(dgMap.Records, rec)
-				}
-				// sort above create dgMap records.
-				sort.Slice(dgMap.Records, func(i, j int) bool { return (dgMap.Records[i].Key < dgMap.Records[j].Key) })
-				sharedApp[dg.Name] = dgMap
-			} else {
-				for _, record := range dg.Records {
-					var rec as3Record
-					rec.Key = record.Name
-					// To override default Value created for CCCL for certain DG types
-					if val, ok := getDGRecordValueForAS3(idk.Name, sharedApp); ok {
-						rec.Value = val
-					} else {
-						rec.Value = record.Data
-					}
-					sharedApp[dg.Name].(*as3DataGroup).Records = append(dataGroupRecord.(*as3DataGroup).Records, rec)
-				}
-				// sort above created
-				sort.Slice(sharedApp[dg.Name].(*as3DataGroup).Records,
-					func(i, j int) bool {
-						return (sharedApp[dg.Name].(*as3DataGroup).Records[i].Key <
-							sharedApp[dg.Name].(*as3DataGroup).Records[j].Key)
-					})
-			}
+	for _, idg := range intDgMap {
+		// The records of the namespaces are merged by the conflict handler
+		// of the data group
+		dg := idg.FlattenNamespaces()
+		if nil == dg {
+			continue
+		}
+		dgMap := &as3DataGroup{}
+		dgMap.Class = "Data_Group"
+		dgMap.KeyDataType = "string"
+		for _, record := range dg.Records {
+			var rec as3Record
+			rec.Key = record.Name
+			rec.Value = record.Data
+			dgMap.Records = append(dgMap.Records, rec)
 		}
+		sort.Slice(dgMap.Records, func(i, j int) bool {
+			return dgMap.Records[i].Key < dgMap.Records[j].Key
+		})
+		sharedApp[dg.Name] = dgMap
 	}
 }
 
-func getDGRecordValueForAS3(dgName string, sharedApp as3Application) (string, bool) {
-	switch dgName {
-	case ReencryptServerSslDgName:
-		for _, v := range sharedApp {
-			if svc, ok := v.(*as3Service); ok && svc.Class == "Service_HTTPS" {
-				if val, ok := svc.ClientTLS.(*as3ResourcePointer); ok {
-					return val.BigIP, true
-				}
-				if val, ok := svc.ClientTLS.(string); ok {
-					return strings.Join([]string{"", DEFAULT_PARTITION, as3SharedApplication, val}, "/"), true
-				}
-				log.Errorf("Unable to find serverssl for Data Group: %v\n", dgName)
-			}
+//Process for AS3 Resource
+// formatVirtualAddressName returns the name of the Service_Address of a
+// virtual address
+func formatVirtualAddressName(bindAddr string) string {
+	return AS3NameFormatter("va_" + bindAddr)
+}
+
+// processVirtualAddressesForAS3 declares a Service_Address for the virtual
+// addresses with ICMP echo or ARP settings other than the defaults. The
+// virtuals sharing an address with different settings use the most
+// restrictive ones.
+func processVirtualAddressesForAS3(rsCfgs ResourceConfigs, sharedApp as3Application) {
+	addrs := make(map[string]virtualAddress)
+	for _, cfg := range rsCfgs {
+		va := cfg.Virtual.VirtualAddress
+		if nil == va || va.BindAddr == "" {
+			continue
+		}
+		addr, found := addrs[va.BindAddr]
+		if !found {
+			addrs[va.BindAddr] = *va
+			continue
+		}
+		if addr.ICMPEcho != va.ICMPEcho || addr.ARPDisabled != va.ARPDisabled {
+			log.Warningf("Virtuals of address %s have different icmpEcho "+
+				"or arp, using the most restrictive", va.BindAddr)
+		}
+		if icmpEchoRank[va.ICMPEcho] > icmpEchoRank[addr.ICMPEcho] {
+			addr.ICMPEcho = va.ICMPEcho
+		}
+		addr.ARPDisabled = addr.ARPDisabled || va.ARPDisabled
+		addrs[va.BindAddr] = addr
+	}
+	for bindAddr, addr := range addrs {
+		if addr.ICMPEcho == "" && !addr.ARPDisabled {
+			continue
+		}
+		sharedApp[formatVirtualAddressName(bindAddr)] = &as3ServiceAddress{
+			Class:          "Service_Address",
+			VirtualAddress: bindAddr,
+			ARPEnabled:     !addr.ARPDisabled,
+			ICMPEcho:       addr.ICMPEcho,
 		}
 	}
-	return "", false
 }
 
-//Process for AS3 Resource
 func processResourcesForAS3(rsCfgs ResourceConfigs, sharedApp as3Application) {
 	for _, cfg := range rsCfgs {
 		//Create policies
@@ -275,7 +556,11 @@ func createPoliciesDecl(cfg *ResourceConfig, sharedApp as3Application) {
 	for _, pl := range cfg.Policies {
 		//Create EndpointPolicy
 		ep := &as3EndpointPolicy{}
-		for _, rl := range pl.Rules {
+		rules := append(Rules{}, pl.Rules...)
+		sort.SliceStable(rules, func(i, j int) bool {
+			return rules[i].Ordinal < rules[j].Ordinal
+		})
+		for _, rl := range rules {
 
 			ep.Class = "Endpoint_Policy"
 			s := strings.Split(pl.Strategy, "/")
@@ -304,26 +589,50 @@ func createPoolDecl(cfg *ResourceConfig, sharedApp as3Application) {
 		// TODO
 		// pool.LoadBalancingMode = v.Balance
 		pool.Class = "Pool"
-		for _, val := range v.Members {
+		pool.MinimumMembersActive = v.MinActiveMembers
+		pool.ServiceDownAction = v.ServiceDownAction
+		members := append([]Member{}, v.Members...)
+		sortMembers(members)
+		for _, val := range members {
 			var member as3PoolMember
 			member.AddressDiscovery = "static"
 			member.ServicePort = val.Port
 			member.ServerAddresses = append(member.ServerAddresses, val.Address)
+			member.AdminState = val.AdminState
+			member.PriorityGroup = val.PriorityGroup
 			pool.Members = append(pool.Members, member)
 		}
-		// TODO
-		/**
 		for _, val := range v.MonitorNames {
-			var monitor as3ResourcePointer
-			use := strings.Split(val, "/")
-			monitor.Use = fmt.Sprintf("/%s/%s/%s",
-				DEFAULT_PARTITION,
-				as3SharedApplication,
-				use[len(use)-1],
-			)
-			pool.Monitors = append(pool.Monitors, monitor)
+			pool.Monitors = append(pool.Monitors,
+				as3ResourcePointer{BigIP: val})
+		}
+		if nil != v.Monitor {
+			monitorName := AS3NameFormatter(v.Monitor.Name)
+			sharedApp[monitorName] = &as3Monitor{
+				Class:       "Monitor",
+				MonitorType: v.Monitor.Type,
+				Interval:    v.Monitor.Interval,
+				Timeout:     v.Monitor.Timeout,
+				Send:        v.Monitor.Send,
+				Receive:     v.Monitor.Recv,
+			}
+			if v.Monitor.TargetAddress != "" {
+				sharedApp[monitorName].(*as3Monitor).TargetAddress =
+					&v.Monitor.TargetAddress
+			}
+			if v.Monitor.TargetPort != 0 {
+				port := int(v.Monitor.TargetPort)
+				sharedApp[monitorName].(*as3Monitor).TargetPort = &port
+			}
+			pool.Monitors = append(pool.Monitors,
+				as3ResourcePointer{Use: monitorName})
+		}
+		// AS3 marks the members up with one monitor up by default
+		if v.MinimumMonitors > 0 {
+			pool.MinimumMonitors = v.MinimumMonitors
+		} else if len(pool.Monitors) > 1 {
+			pool.MinimumMonitors = "all"
 		}
-		**/
 		sharedApp[v.Name] = pool
 	}
 }
@@ -342,7 +651,7 @@ func createServiceDecl(cfg *ResourceConfig, sharedApp as3Application) {
 	case numPolicies == 1:
 		policyName := cfg.Virtual.Policies[0].Name
 		svc.PolicyEndpoint = fmt.Sprintf("/%s/%s/%s",
-			DEFAULT_PARTITION,
+			cfg.Virtual.Partition,
 			as3SharedApplication,
 			policyName)
 	case numPolicies > 1:
@@ -352,7 +661,7 @@ func createServiceDecl(cfg *ResourceConfig, sharedApp as3Application) {
 				peps,
 				as3ResourcePointer{
 					BigIP: fmt.Sprintf("/%s/%s/%s",
-						DEFAULT_PARTITION,
+						cfg.Virtual.Partition,
 						as3SharedApplication,
 						pep.Name,
 					),
@@ -360,42 +669,133 @@ func createServiceDecl(cfg *ResourceConfig, sharedApp as3Application) {
 			)
 		}
 		svc.PolicyEndpoint = peps
-	case numPolicies == 0:
-		// No policies since we need to handle the pool name.
+	}
+	// The default pool gets the requests matching no policy rule.
+	if cfg.Virtual.PoolName != "" {
 		ps := strings.Split(cfg.Virtual.PoolName, "/")
-		if cfg.Virtual.PoolName != "" {
-			svc.Pool = fmt.Sprintf("/%s/%s/%s",
-				DEFAULT_PARTITION,
-				as3SharedApplication,
-				ps[len(ps)-1])
-		}
+		svc.Pool = fmt.Sprintf("/%s/%s/%s",
+			cfg.Virtual.Partition,
+			as3SharedApplication,
+			ps[len(ps)-1])
+	}
+
+	// The virtual disabled while inactive, enabled is the default
+	if !cfg.Virtual.Enabled {
+		enable := false
+		svc.Enable = &enable
 	}
 
 	svc.Layer4 = cfg.Virtual.IpProtocol
 	svc.Source = "0.0.0.0/0"
 	svc.TranslateServerAddress = true
-	svc.TranslateServerPort = true
+	svc.TranslateServerPort = !cfg.Virtual.KeepClientPort
 
-	svc.Class = "Service_HTTP"
+	svc.Class = getServiceClass(&cfg.Virtual, cfg.MetaData.ResourceType)
 
 	virtualAddress, port := extractVirtualAddressAndPort(cfg.Virtual.Destination)
 	// verify that ip address and port exists.
 	if virtualAddress != "" && port != 0 {
-		va := append(svc.VirtualAddresses, virtualAddress)
-		svc.VirtualAddresses = va
+		vaName := formatVirtualAddressName(virtualAddress)
+		if _, ok := sharedApp[vaName].(*as3ServiceAddress); ok {
+			svc.VirtualAddresses = append(svc.VirtualAddresses,
+				&as3ResourcePointer{Use: vaName})
+		} else {
+			svc.VirtualAddresses = append(svc.VirtualAddresses, virtualAddress)
+		}
 		svc.VirtualPort = port
 	}
+	// The virtual listens on the ports of its port list
+	if len(cfg.Virtual.PortList) > 0 {
+		svc.VirtualPort = createPortListDecl(cfg, sharedApp)
+	}
 
-	svc.SNAT = "auto"
+	switch cfg.Virtual.SourceAddrTranslation.Type {
+	case SNATNone:
+		svc.SNAT = "none"
+	case "snat":
+		svc.SNAT = &as3ResourcePointer{
+			BigIP: cfg.Virtual.SourceAddrTranslation.Pool,
+		}
+	case SNATPool:
+		name := cfg.Virtual.Name + "_snatpool"
+		sharedApp[name] = &as3SNATPool{
+			Class:         "SNAT_Pool",
+			SNATAddresses: cfg.Virtual.SourceAddrTranslation.Addresses,
+		}
+		svc.SNAT = &as3ResourcePointer{Use: name}
+	default:
+		svc.SNAT = "auto"
+	}
 	for _, v := range cfg.Virtual.IRules {
 		splits := strings.Split(v, "/")
 		iRuleName := splits[len(splits)-1]
-		svc.IRules = append(svc.IRules, iRuleName)
+		// IRules created by CIS are in the declaration, others are
+		// referred to by their BIG-IP path.
+		if _, ok := sharedApp[iRuleName].(*as3IRules); ok &&
+			len(splits) == 3 && splits[1] == cfg.Virtual.Partition {
+			svc.IRules = append(svc.IRules, iRuleName)
+		} else {
+			svc.IRules = append(svc.IRules, &as3ResourcePointer{BigIP: v})
+		}
+	}
+
+	svc.MaxConnections = cfg.Virtual.ConnectionLimit
+	svc.RateLimit = cfg.Virtual.RateLimit
+
+	for _, vlan := range cfg.Virtual.AllowVLANs {
+		svc.AllowVLANs = append(svc.AllowVLANs,
+			as3ResourcePointer{BigIP: vlan})
+	}
+
+	if cfg.Virtual.WAF != "" {
+		svc.PolicyWAF = &as3ResourcePointer{BigIP: cfg.Virtual.WAF}
+	}
+
+	if cfg.Virtual.Firewall != "" {
+		svc.PolicyFirewallEnforced = &as3ResourcePointer{
+			BigIP: cfg.Virtual.Firewall,
+		}
+	}
+
+	if cfg.Virtual.PersistenceProfile != "" {
+		svc.PersistenceMethods = []as3MultiTypeParam{
+			createPersistenceMethod(cfg.Virtual.PersistenceProfile),
+		}
 	}
 
 	sharedApp[cfg.Virtual.Name] = svc
 }
 
+// getServiceClass returns the AS3 class of the virtual, the virtuals of
+// TransportServers and IngressLinks are TCP, UDP or Performance (Layer 4)
+// virtuals.
+func getServiceClass(v *Virtual, resourceType string) string {
+	if resourceType != TransportServer && resourceType != IngressLink {
+		return "Service_HTTP"
+	}
+	switch {
+	case v.Mode == TransportServerPerformanceL4:
+		return "Service_L4"
+	case v.IpProtocol == TransportServerUDP:
+		return "Service_UDP"
+	}
+	return "Service_TCP"
+}
+
+// createPersistenceMethod returns the AS3 persistence method of a built-in
+// persistence type, or a pointer to the persistence profile on BIG-IP.
+func createPersistenceMethod(profile string) as3MultiTypeParam {
+	switch profile {
+	case PersistenceCookie:
+		return "cookie"
+	case PersistenceSourceAddr:
+		return "source-address"
+	case PersistenceSSL:
+		return "tls-session-id"
+	}
+	return &as3ResourcePointer{BigIP: profile}
+}
+
 // Create AS3 Rule Condition for CRD
 func createRuleCondition(rl *Rule, rulesData *as3Rule, port int) {
 	for _, c := range rl.Conditions {
@@ -424,6 +824,9 @@ func createRuleCondition(rl *Rule, rulesData *as3Rule, port int) {
 			if c.Equals {
 				condition.All.Operand = "equals"
 			}
+			if c.EndsWith {
+				condition.All.Operand = "ends-with"
+			}
 		} else if c.PathSegment {
 			condition.PathSegment = &as3PolicyCompareString{
 				Values: c.Values,
@@ -452,6 +855,28 @@ func createRuleCondition(rl *Rule, rulesData *as3Rule, port int) {
 			if c.Equals {
 				condition.Path.Operand = "equals"
 			}
+			if c.Matches {
+				condition.Path.Operand = "matches"
+			}
+		} else if c.QueryParameter {
+			condition.Type = "httpUri"
+			condition.Name = c.ParameterName
+			condition.QueryParameter = &as3PolicyCompareString{
+				Values:  c.Values,
+				Operand: "equals",
+			}
+		} else if c.HTTPMethod {
+			condition.Type = "httpMethod"
+			condition.All = &as3PolicyCompareString{
+				Values:  c.Values,
+				Operand: "equals",
+			}
+		} else if c.Tcp && c.Address {
+			condition.Type = "tcp"
+			condition.Address = &as3PolicyMatchAddress{
+				Values:  c.Values,
+				Operand: "match",
+			}
 		}
 		if c.Request {
 			condition.Event = "request"
@@ -464,17 +889,28 @@ func createRuleCondition(rl *Rule, rulesData *as3Rule, port int) {
 // Create AS3 Rule Action for CRD
 func createRuleAction(rl *Rule, rulesData *as3Rule) {
 	for _, v := range rl.Actions {
+		// Dropped and redirected by the pool action iRule of the virtual
+		if v.Drop || v.Code != 0 {
+			continue
+		}
 		action := &as3Action{}
 		if v.Forward {
 			action.Type = "forward"
 		}
+		// AS3 resets the connection with the drop action
+		if v.Reset {
+			action.Type = "drop"
+		}
 		if v.Request {
 			action.Event = "request"
 		}
+		if v.Response {
+			action.Event = "response"
+		}
 		if v.Redirect {
 			action.Type = "httpRedirect"
 		}
-		if v.HTTPHost {
+		if v.HTTPHost || v.TmName != "" {
 			action.Type = "httpHeader"
 		}
 		if v.HTTPURI {
@@ -496,8 +932,29 @@ func createRuleAction(rl *Rule, rulesData *as3Rule) {
 				Value: v.Value,
 			}
 		}
+		// Handle the response headers.
+		if v.TmName != "" {
+			if v.Replace {
+				action.Replace = &as3ActionReplaceMap{
+					Value: v.Value,
+					Name:  v.TmName,
+				}
+			}
+			if v.Remove {
+				action.Remove = &as3ActionRemoveMap{
+					Name: v.TmName,
+				}
+			}
+		}
 		p := strings.Split(v.Pool, "/")
-		if v.Pool != "" {
+		if isBigIPPath(v.Pool) {
+			// The maintenance pools are pools on BIG-IP
+			action.Select = &as3ActionForwardSelect{
+				Pool: &as3ResourcePointer{
+					BigIP: v.Pool,
+				},
+			}
+		} else if v.Pool != "" {
 			action.Select = &as3ActionForwardSelect{
 				Pool: &as3ResourcePointer{
 					Use: p[len(p)-1],
@@ -511,16 +968,32 @@ func createRuleAction(rl *Rule, rulesData *as3Rule) {
 //Extract virtual address and port from host URL
 func extractVirtualAddressAndPort(str string) (string, int) {
 	destination := strings.Split(str, "/")
-	ipPort := strings.Split(destination[len(destination)-1], ":")
+	ipPort := destination[len(destination)-1]
+	// The port of an IPv6 address is delimited by '.'
+	delimiter := ":"
+	if strings.Count(ipPort, ":") > 1 {
+		delimiter = "."
+	}
 	// verify that ip address and port exists else log error.
-	if len(ipPort) == 2 {
-		port, _ := strconv.Atoi(ipPort[1])
-		return ipPort[0], port
-	} else {
-		log.Error("Invalid Virtual Server Destination IP address/Port.")
-		return "", 0
+	if i := strings.LastIndex(ipPort, delimiter); i > 0 {
+		if port, err := strconv.Atoi(ipPort[i+1:]); err == nil {
+			return ipPort[:i], port
+		}
 	}
+	log.Error("Invalid Virtual Server Destination IP address/Port.")
+	return "", 0
+}
 
+// tenantHash returns the hash of the declaration of a tenant, stable as the
+// maps are serialized with sorted keys and the data groups with sorted
+// records
+func tenantHash(tenant interface{}) string {
+	data, err := json.Marshal(tenant)
+	if err != nil {
+		log.Debugf("[AS3] Unable to hash the tenant: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 func DeepEqualJSON(decl1, decl2 as3Declaration) bool {
@@ -546,13 +1019,50 @@ func processProfilesForAS3(rsCfgs ResourceConfigs, sharedApp as3Application) {
 	for _, cfg := range rsCfgs {
 		if svc, ok := sharedApp[cfg.Virtual.Name].(*as3Service); ok {
 			processTLSProfilesForAS3(&cfg.Virtual, svc)
+			processHTTPAndTCPProfilesForAS3(&cfg.Virtual, svc)
 		}
 	}
 }
 
+func processHTTPAndTCPProfilesForAS3(virtual *Virtual, svc *as3Service) {
+	var tcp as3ProfileTCP
+	for _, profile := range virtual.Profiles {
+		pointer := &as3ResourcePointer{
+			BigIP: fmt.Sprintf("/%v/%v", profile.Partition, profile.Name),
+		}
+		switch profile.Type {
+		case ProfileTypeHTTP:
+			svc.ProfileHTTP = pointer
+		case ProfileTypeTCP:
+			switch profile.Context {
+			case rsc.CustomProfileClient:
+				tcp.Ingress = pointer
+			case rsc.CustomProfileServer:
+				tcp.Egress = pointer
+			default:
+				svc.ProfileTCP = pointer
+			}
+		}
+	}
+	if tcp.Ingress == nil && tcp.Egress == nil {
+		return
+	}
+	// The side without profile uses the default TCP profile of BIG-IP
+	if tcp.Ingress == nil {
+		tcp.Ingress = &as3ResourcePointer{BigIP: "/Common/tcp"}
+	}
+	if tcp.Egress == nil {
+		tcp.Egress = &as3ResourcePointer{BigIP: "/Common/tcp"}
+	}
+	svc.ProfileTCP = &tcp
+}
+
 func processTLSProfilesForAS3(virtual *Virtual, svc *as3Service) {
 	// lets discard BIGIP profile creation when there exists a custom profile.
 	for _, profile := range virtual.Profiles {
+		if profile.Type != "" {
+			continue
+		}
 		switch profile.Context {
 		case rsc.CustomProfileClient:
 			// Incoming traffic (clientssl) from a web client will be handled by ServerTLS in AS3
@@ -568,13 +1078,37 @@ func processTLSProfilesForAS3(virtual *Virtual, svc *as3Service) {
 			updateVirtualToHTTPS(svc)
 		}
 	}
+	// The passthrough iRule disables the client SSL profile for the
+	// passthrough hosts, the other hosts are terminated with it.
+	if nil != svc.ServerTLS {
+		return
+	}
+	for _, irule := range virtual.IRules {
+		if strings.HasSuffix(irule, "/"+SslPassthroughIRuleName) {
+			svc.ServerTLS = &as3ResourcePointer{BigIP: "/Common/clientssl"}
+			updateVirtualToHTTPS(svc)
+			return
+		}
+	}
 }
 
 func processCustomProfilesForAS3(customProfiles *CustomProfileStore, sharedApp as3Application) {
 	caBundleName := "serverssl_ca_bundle"
-	var tlsClient *as3TLSClient
+	// The profiles are processed in order for a stable declaration
+	keys := make([]SecretKey, 0, len(customProfiles.Profs))
+	for key := range customProfiles.Profs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ResourceName != keys[j].ResourceName {
+			return keys[i].ResourceName < keys[j].ResourceName
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	tlsClients := make(map[string]*as3TLSClient)
 	// TLS Certificates are available in CustomProfiles
-	for key, prof := range customProfiles.Profs {
+	for _, key := range keys {
+		prof := customProfiles.Profs[key]
 		// Create TLSServer and Certificate for each profile
 		svcName := key.ResourceName
 		if svcName == "" {
@@ -585,13 +1119,23 @@ func processCustomProfilesForAS3(customProfiles *CustomProfileStore, sharedApp a
 			createCertificateDecl(prof, sharedApp)
 		} else {
 			createUpdateCABundle(prof, caBundleName, sharedApp)
-			if tlsClient == nil {
+			tlsClient, found := tlsClients[svcName]
+			if !found {
 				tlsClient = createTLSClient(prof, svcName, caBundleName, sharedApp)
+				if tlsClient == nil {
+					continue
+				}
+				tlsClients[svcName] = tlsClient
+			}
+			// The pool members are validated with the CA certificates of
+			// the server SSL profiles
+			if prof.Context == CustomProfileServer {
+				tlsClient.ValidateCertificate = true
 			}
 			skey := SecretKey{
 				Name: prof.Name + "-ca",
 			}
-			if _, ok := customProfiles.Profs[skey]; ok && tlsClient != nil {
+			if _, ok := customProfiles.Profs[skey]; ok {
 				// If a profile exist in customProfiles with key as created above
 				// then it indicates that secure-serverssl needs to be added
 				tlsClient.ValidateCertificate = true
@@ -619,25 +1163,65 @@ func createUpdateTLSServer(prof CustomProfile, svcName string, sharedApp as3Appl
 			svc.ServerTLS = tlsServerName
 			updateVirtualToHTTPS(svc)
 		}
+		createClientAuthDecl(prof, svcName, tlsServer, sharedApp)
 
-		tlsServer.Certificates = append(
-			tlsServer.Certificates,
-			as3TLSServerCertificates{
-				Certificate: certName,
-			},
-		)
+		cert := as3TLSServerCertificates{
+			Certificate: certName,
+		}
+		if prof.SNIDefault {
+			// The first certificate is served to the clients without a
+			// matching server name
+			tlsServer.Certificates = append(
+				[]as3TLSServerCertificates{cert},
+				tlsServer.Certificates...,
+			)
+		} else {
+			cert.MatchToSNI = prof.ServerName
+			tlsServer.Certificates = append(tlsServer.Certificates, cert)
+		}
 		return true
 	}
 	return false
 }
 
+// createClientAuthDecl enables the client certificate authentication of
+// the profile on the TLSServer, with a CA bundle of its CA certificates or
+// the CA bundle on BIG-IP.
+func createClientAuthDecl(
+	prof CustomProfile,
+	svcName string,
+	tlsServer *as3TLSServer,
+	sharedApp as3Application,
+) {
+	if prof.PeerCertMode != PeerCertRequired &&
+		prof.PeerCertMode != PeerCertRequested {
+		return
+	}
+	tlsServer.AuthenticationMode = prof.PeerCertMode
+	if "" != prof.CAFile {
+		caBundleName := fmt.Sprintf("%s_client_ca", svcName)
+		sharedApp[caBundleName] = &as3CABundle{
+			Class:  "CA_Bundle",
+			Bundle: prof.CAFile,
+		}
+		tlsServer.AuthenticationTrustCA = caBundleName
+		return
+	}
+	tlsServer.AuthenticationTrustCA = &as3ResourcePointer{
+		BigIP: prof.CABundle,
+	}
+}
+
 func createCertificateDecl(prof CustomProfile, sharedApp as3Application) {
 	if "" != prof.Cert && "" != prof.Key {
 		cert := &as3Certificate{
 			Class:       "Certificate",
 			Certificate: prof.Cert,
 			PrivateKey:  prof.Key,
-			ChainCA:     prof.CAFile,
+		}
+		// The intermediate certificates sent along with the certificate
+		if "" != prof.ChainCert {
+			cert.ChainCA = prof.ChainCert
 		}
 		sharedApp[prof.Name] = cert
 	}
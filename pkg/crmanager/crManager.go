@@ -21,11 +21,14 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"time"
 
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	"github.com/F5Networks/k8s-bigip-ctlr/config/client/clientset/versioned"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	discoveryv1alpha1 "k8s.io/api/discovery/v1alpha1"
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/util/workqueue"
@@ -36,12 +39,112 @@ const (
 	DefaultCustomResourceLabel = "f5cr in (true)"
 	// VirtualServer is a F5 Custom Resource Kind.
 	VirtualServer = "VirtualServer"
+	// TLSProfile is a F5 Custom Resource Kind
+	TLSProfile = "TLSProfile"
+	// TransportServer is a F5 Custom Resource Kind
+	TransportServer = "TransportServer"
+	// IngressLink is a F5 Custom Resource Kind
+	IngressLink = "IngressLink"
+	// ExternalDNS is a F5 Custom Resource Kind
+	ExternalDNS = "ExternalDNS"
+	// CustomPolicy is a F5 Custom Resource Kind, the Policy referred by
+	// VirtualServers.
+	CustomPolicy = "Policy"
 	// Service is a k8s native Service Resource.
 	Service = "Service"
 	// Endpoints is a k8s native Endpoint Resource.
 	Endpoints = "Endpoints"
+	// EndpointSlice is a k8s native EndpointSlice Resource, used instead of
+	// Endpoints with --use-endpointslices.
+	EndpointSlice = "EndpointSlice"
+	// TLSSecret is a k8s native Secret Resource referred by TLSProfiles.
+	TLSSecret = "Secret"
+	// HostGroup is the group of VirtualServers sharing an address, the
+	// dependency of its members.
+	HostGroup = "HostGroup"
+	// Resync processes all the VirtualServers again.
+	Resync = "Resync"
+	// Prune processes all the VirtualServers again and removes the stale
+	// objects from BIG-IP.
+	Prune = "Prune"
 
 	NodePortMode = "nodeport"
+	ClusterMode  = "cluster"
+
+	// SharedVIPMerge merges the VirtualServers using the same address and
+	// port into one virtual.
+	SharedVIPMerge = "merge"
+	// SharedVIPReject rejects the newer VirtualServer using the address and
+	// port of another VirtualServer.
+	SharedVIPReject = "reject"
+
+	// RejectInvalidPools rejects the VirtualServer with a pool referring to
+	// a nonexistent service.
+	RejectInvalidPools = "reject"
+	// SkipInvalidPools configures the VirtualServer without the pools
+	// referring to nonexistent services.
+	SkipInvalidPools = "skipInvalidPools"
+
+	// PathMatchPrefix matches the request path by path segments
+	PathMatchPrefix = "prefix"
+	// PathMatchExact matches the request path exactly
+	PathMatchExact = "exact"
+	// PathMatchRegex matches the request path with a regular expression
+	PathMatchRegex = "regex"
+
+	// ServiceDownActionNone keeps the connections to a member going down
+	ServiceDownActionNone = "none"
+	// ServiceDownActionReset resets the connections to a member going down
+	ServiceDownActionReset = "reset"
+	// ServiceDownActionDrop drops the connections to a member going down
+	ServiceDownActionDrop = "drop"
+	// ServiceDownActionReselect sends the connections to a member going
+	// down to another member
+	ServiceDownActionReselect = "reselect"
+
+	// MonitorHTTP checks the members with HTTP requests
+	MonitorHTTP = "http"
+	// MonitorHTTPS checks the members with HTTPS requests
+	MonitorHTTPS = "https"
+	// MonitorTCP checks the members with TCP connections
+	MonitorTCP = "tcp"
+	// MonitorUDP checks the members with UDP datagrams
+	MonitorUDP = "udp"
+
+	// PersistenceCookie persists the sessions with HTTP cookies
+	PersistenceCookie = "cookie"
+	// PersistenceSourceAddr persists the sessions by client address
+	PersistenceSourceAddr = "source_addr"
+	// PersistenceSSL persists the sessions by SSL session ID
+	PersistenceSSL = "ssl"
+
+	// SNATAutomap translates the source address to a self IP of BIG-IP
+	SNATAutomap = "automap"
+	// SNATNone does not translate the source address
+	SNATNone = "none"
+	// SNATPool translates the source address to the addresses of a SNAT
+	// pool declared with the virtual
+	SNATPool = "snatpool"
+
+	// ICMPEchoEnable answers ICMP echo requests to the virtual address
+	ICMPEchoEnable = "enable"
+	// ICMPEchoDisable does not answer ICMP echo requests
+	ICMPEchoDisable = "disable"
+	// ICMPEchoSelective answers ICMP echo requests when the virtuals of the
+	// address are available
+	ICMPEchoSelective = "selective"
+
+	// TransportServerTCP load balances TCP traffic
+	TransportServerTCP = "tcp"
+	// TransportServerUDP load balances UDP traffic
+	TransportServerUDP = "udp"
+	// TransportServerStandard processes the traffic with a standard virtual
+	TransportServerStandard = "standard"
+	// TransportServerPerformanceL4 processes the traffic with a
+	// Performance (Layer 4) virtual
+	TransportServerPerformanceL4 = "performance-l4"
+	// maxTransportServerPorts bounds the ports of a TransportServer
+	maxTransportServerPorts = 1024
 )
 
 // NewCRManager creates a new CRManager Instance.
@@ -51,16 +154,63 @@ func NewCRManager(params Params) *CRManager {
 		namespaces:  params.Namespaces,
 		crInformers: make(map[string]*CRInformer),
 		rscQueue: workqueue.NewNamedRateLimitingQueue(
-			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller"),
-		resources:       NewResources(),
-		Agent:           params.Agent,
-		ControllerMode:  params.ControllerMode,
-		UseNodeInternal: params.UseNodeInternal,
-		initState:       true,
-		SSLContext:      make(map[string]*v1.Secret),
-		customProfiles:  NewCustomProfiles(),
-		irulesMap:       make(IRulesMap),
-		intDgMap:        make(InternalDataGroupMap),
+			newResourceRateLimiter(), "custom-resource-controller"),
+		statusQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-status"),
+		finalizerQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-finalizer"),
+		pendingFinalizers:  make(map[finalizerKey]uint64),
+		vsStatusMap:        make(map[string]cisapiv1.VirtualServerStatus),
+		vsWarnings:         make(map[string]string),
+		persistenceWarned:  make(map[string]int64),
+		pendingServices:    make(ObjectDependencyMap),
+		resources:          NewResources(),
+		Agent:              params.Agent,
+		ControllerMode:     params.ControllerMode,
+		UseNodeInternal:    params.UseNodeInternal,
+		FilterUnhealthy:    params.FilterUnhealthyNodes,
+		DefaultRouteDomain: params.DefaultRouteDomain,
+		initState:          true,
+		SSLContext:         make(map[string]*v1.Secret),
+		TLSContext:         make(map[string]*cisapiv1.TLSProfile),
+		customProfiles:     NewCustomProfiles(),
+		irulesMap:          make(IRulesMap),
+		intDgMap:           make(InternalDataGroupMap),
+		redirectRecords:    make(map[string]map[string]bool),
+		mergedRulesMap:     make(map[NameRef]map[string]mergedRuleEntry),
+		NamespaceQuota:     params.NamespaceQuota,
+		admittedVirtuals:   make(map[string]*cisapiv1.VirtualServer),
+		rejectedVirtuals:   make(map[string]*cisapiv1.VirtualServer),
+		hostGroups:         make(map[string]string),
+		eventNotifier:      NewEventNotifier(nil),
+		SharedVIPPolicy:    params.SharedVIPPolicy,
+		UseResourceNames:   params.UseResourceNames,
+		AllowedPartitions:  params.AllowedPartitions,
+		DefaultSNAT:        params.DefaultSNAT,
+		DefaultICMPEcho:    params.DefaultICMPEcho,
+		DisableARP:         params.DisableARP,
+		ProcessingWorkers:  params.ProcessingWorkers,
+		FlushInterval:      params.FlushInterval,
+		flushCh:            make(chan struct{}, 1),
+		LeaderElection:     params.LeaderElection,
+		ResyncPeriod:       params.ResyncPeriod,
+		PruneOnStartup:     params.PruneOnStartup,
+		UseFinalizers:      !params.DisableFinalizers,
+		DisableInactive:    params.DisableInactive,
+	}
+
+	crMgr.ShutdownGracePeriod = params.ShutdownGracePeriod
+	crMgr.StallTimeout = params.StallTimeout
+	crMgr.SharedPartition = params.SharedPartition
+	crMgr.CertExpiryWarnDays = params.CertExpiryWarnDays
+	if crMgr.ProcessingWorkers < 1 {
+		crMgr.ProcessingWorkers = 1
+	}
+
+	if nm, err := NewNamer(params.NamingScheme, params.NameEscaping); err != nil {
+		log.Errorf("%v, using '%s' naming scheme", err, CRDNamingScheme)
+	} else {
+		namer = nm
 	}
 
 	log.Debug("Custom Resource Manager Created")
@@ -73,11 +223,87 @@ func NewCRManager(params Params) *CRManager {
 	if err := crMgr.setupClients(params.Config); err != nil {
 		log.Errorf("Failed to Setup Clients: %v", err)
 	}
+	crMgr.nodeInformer = crMgr.newNodeInformer()
+
+	if params.UseEndpointSlices {
+		if crMgr.isEndpointSliceServed() {
+			crMgr.UseEndpointSlices = true
+		} else {
+			log.Warningf("EndpointSlices are not served by the API server, " +
+				"using Endpoints for the pool members")
+		}
+	}
+
+	if len(params.NamespaceRouteDomains) > 0 {
+		rds, err := ParseNamespaceRouteDomains(params.NamespaceRouteDomains)
+		if err != nil {
+			log.Errorf("Failed to parse namespace route domains: %v", err)
+		} else {
+			crMgr.NamespaceRouteDomains = rds
+		}
+	}
+
+	if len(params.NamespacePartitions) > 0 {
+		partitions := append([]string{crMgr.Partition},
+			crMgr.AllowedPartitions...)
+		nsPartitions, err := ParseNamespacePartitions(
+			params.NamespacePartitions, partitions)
+		if err != nil {
+			log.Errorf("Failed to parse namespace partitions: %v", err)
+		} else {
+			crMgr.NamespacePartitions = nsPartitions
+		}
+	}
+
+	if params.IPAM {
+		ipam, err := NewRangeIPAM(params.IPAMRanges, crMgr.kubeClient,
+			params.IPAMNamespace)
+		if err != nil {
+			log.Errorf("Failed to Setup IPAM: %v", err)
+		} else {
+			crMgr.ipam = ipam
+		}
+	}
+
+	if params.LeaderElection {
+		err := crMgr.setupLeaderElection(params.LeaseNamespace, params.LeaseName)
+		if err != nil {
+			// Never leading rather than fighting over BIG-IP with the
+			// leader
+			log.Errorf("Failed to Setup Leader Election: %v", err)
+		}
+	}
+
+	if params.ManageCRDs && nil != crMgr.dynamicClient {
+		if err := crMgr.installCustomResourceDefinitions(); err != nil {
+			log.Errorf("Failed to Install CRDs: %v", err)
+		}
+	}
 
 	if err := crMgr.setupInformers(); err != nil {
 		log.Error("Failed to Setup Informers")
 	}
 
+	if params.DebugAddress != "" {
+		crMgr.startDebugServer(params.DebugAddress, params.DebugToken)
+	}
+
+	if params.WebhookAddress != "" {
+		crMgr.startWebhookServer(params.WebhookAddress,
+			params.WebhookCertFile, params.WebhookKeyFile)
+	}
+
+	if params.ServeResources && nil != crMgr.Agent && nil != crMgr.Agent.HTTPMux {
+		crMgr.Agent.HTTPMux.HandleFunc("/resources", crMgr.handleResources)
+	}
+
+	if nil != crMgr.Agent {
+		crMgr.Agent.AddLivenessCheck(crMgr.checkProgress)
+		if nil != crMgr.Agent.HTTPMux {
+			crMgr.Agent.HTTPMux.HandleFunc("/ready", crMgr.handleReady)
+		}
+	}
+
 	err := crMgr.SetupNodePolling(
 		params.NodePollInterval,
 		params.NodeLabelSelector,
@@ -120,12 +346,39 @@ func (crMgr *CRManager) setupClients(config *rest.Config) error {
 		return fmt.Errorf("Failed to create kubeClient: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("Failed to create dynamicClient: %v", err)
+	}
+
 	log.Debug("Client Created")
 	crMgr.kubeCRClient = kubeCRClient
 	crMgr.kubeClient = kubeClient
+	crMgr.dynamicClient = dynamicClient
 	return nil
 }
 
+// isEndpointSliceServed checks whether the API server serves the
+// EndpointSlices of the discovery API group.
+func (crMgr *CRManager) isEndpointSliceServed() bool {
+	if crMgr.kubeClient == nil {
+		return false
+	}
+	rscList, err := crMgr.kubeClient.Discovery().ServerResourcesForGroupVersion(
+		discoveryv1alpha1.SchemeGroupVersion.String())
+	if err != nil {
+		log.Debugf("Unable to discover %v: %v",
+			discoveryv1alpha1.SchemeGroupVersion, err)
+		return false
+	}
+	for _, rsc := range rscList.APIResources {
+		if rsc.Name == "endpointslices" {
+			return true
+		}
+	}
+	return false
+}
+
 func (crMgr *CRManager) setupInformers() error {
 	for _, n := range crMgr.namespaces {
 		if err := crMgr.addNamespacedInformer(n); err != nil {
@@ -140,15 +393,33 @@ func (crMgr *CRManager) Start() {
 	log.Infof("Starting Custom Resource Manager")
 	defer utilruntime.HandleCrash()
 	defer crMgr.rscQueue.ShutDown()
+	defer crMgr.statusQueue.ShutDown()
+	defer crMgr.finalizerQueue.ShutDown()
 
 	for _, inf := range crMgr.crInformers {
 		inf.start()
 	}
 
 	crMgr.nodePoller.Run()
+	crMgr.markProgress()
 
 	stopChan := make(chan struct{})
-	go wait.Until(crMgr.customResourceWorker, time.Second, stopChan)
+	go crMgr.nodeInformer.Run(stopChan)
+	for i := 0; i < crMgr.ProcessingWorkers; i++ {
+		go wait.Until(crMgr.customResourceWorker, time.Second, stopChan)
+	}
+	go wait.Until(crMgr.statusWorker, time.Second, stopChan)
+	go wait.Until(crMgr.finalizerWorker, time.Second, stopChan)
+	go crMgr.configFlusher(stopChan)
+	if nil != crMgr.leaderElector {
+		go crMgr.runLeaderElection(stopChan)
+	}
+	if crMgr.ResyncPeriod > 0 {
+		go crMgr.periodicResync(stopChan)
+	}
+	if crMgr.PruneOnStartup {
+		go crMgr.enqueuePruneOnceSynced(stopChan)
+	}
 
 	<-stopChan
 	crMgr.Stop()
@@ -156,9 +427,18 @@ func (crMgr *CRManager) Start() {
 
 // Stop the Custom Resource Manager.
 func (crMgr *CRManager) Stop() {
-	for _, inf := range crMgr.crInformers {
-		inf.stop()
-	}
-	crMgr.nodePoller.Stop()
+	crMgr.stopWatching()
 	crMgr.Agent.Stop()
 }
+
+// stopWatching stops the informers and the node poller, once.
+func (crMgr *CRManager) stopWatching() {
+	crMgr.stopOnce.Do(func() {
+		for _, inf := range crMgr.crInformers {
+			inf.stop()
+		}
+		if nil != crMgr.nodePoller {
+			crMgr.nodePoller.Stop()
+		}
+	})
+}
@@ -0,0 +1,187 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sync"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	crdfake "github.com/F5Networks/k8s-bigip-ctlr/config/client/clientset/versioned/fake"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1alpha1 "k8s.io/api/discovery/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type mockCRManager struct {
+	*CRManager
+}
+
+// newMockCRManager returns a CRManager which is not connected to any
+// Kubernetes cluster or BIG-IP, for unit testing.
+func newMockCRManager() *mockCRManager {
+	return &mockCRManager{
+		CRManager: &CRManager{
+			resources:        NewResources(),
+			kubeCRClient:     crdfake.NewSimpleClientset(),
+			kubeClient:       k8sfake.NewSimpleClientset(),
+			crInformers:      make(map[string]*CRInformer),
+			resourceSelector: labels.Everything(),
+			rscQueue: workqueue.NewNamedRateLimitingQueue(
+				workqueue.DefaultControllerRateLimiter(), "custom-resource-controller"),
+			statusQueue: workqueue.NewNamedRateLimitingQueue(
+				workqueue.DefaultControllerRateLimiter(), "custom-resource-status"),
+			finalizerQueue: workqueue.NewNamedRateLimitingQueue(
+				workqueue.DefaultControllerRateLimiter(), "custom-resource-finalizer"),
+			pendingFinalizers: make(map[finalizerKey]uint64),
+			vsStatusMap:       make(map[string]cisapiv1.VirtualServerStatus),
+			vsWarnings:        make(map[string]string),
+			persistenceWarned: make(map[string]int64),
+			pendingServices:   make(ObjectDependencyMap),
+			Partition:         "test",
+			SSLContext:        make(map[string]*v1.Secret),
+			TLSContext:        make(map[string]*cisapiv1.TLSProfile),
+			customProfiles:    NewCustomProfiles(),
+			irulesMap:         make(IRulesMap),
+			intDgMap:          make(InternalDataGroupMap),
+			redirectRecords:   make(map[string]map[string]bool),
+			mergedRulesMap:    make(map[NameRef]map[string]mergedRuleEntry),
+			admittedVirtuals:  make(map[string]*cisapiv1.VirtualServer),
+			rejectedVirtuals:  make(map[string]*cisapiv1.VirtualServer),
+			hostGroups:        make(map[string]string),
+			eventNotifier:     NewEventNotifier(NewFakeEventBroadcaster),
+			flushCh:           make(chan struct{}, 1),
+		},
+	}
+}
+
+// addVirtualServer adds the VirtualServer to the informer store without
+// running the informer.
+func (m *mockCRManager) addVirtualServer(vs *cisapiv1.VirtualServer) {
+	_ = m.addNamespacedInformer(vs.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	_ = crInf.vsInformer.GetIndexer().Add(vs)
+}
+
+// addTLSProfile adds the TLSProfile to the informer store without
+// running the informer.
+func (m *mockCRManager) addTLSProfile(tls *cisapiv1.TLSProfile) {
+	_ = m.addNamespacedInformer(tls.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(tls.ObjectMeta.Namespace)
+	_ = crInf.tsInformer.GetIndexer().Add(tls)
+}
+
+// addTransportServer adds the TransportServer to the informer store
+// without running the informer.
+func (m *mockCRManager) addTransportServer(ts *cisapiv1.TransportServer) {
+	_ = m.addNamespacedInformer(ts.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(ts.ObjectMeta.Namespace)
+	_ = crInf.transportInformer.GetIndexer().Add(ts)
+}
+
+// addIngressLink adds the IngressLink to the informer store without
+// running the informer.
+func (m *mockCRManager) addIngressLink(il *cisapiv1.IngressLink) {
+	_ = m.addNamespacedInformer(il.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(il.ObjectMeta.Namespace)
+	_ = crInf.ilInformer.GetIndexer().Add(il)
+}
+
+// addExternalDNS adds the ExternalDNS to the informer store without
+// running the informer.
+func (m *mockCRManager) addExternalDNS(edns *cisapiv1.ExternalDNS) {
+	_ = m.addNamespacedInformer(edns.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(edns.ObjectMeta.Namespace)
+	_ = crInf.ednsInformer.GetIndexer().Add(edns)
+}
+
+// addPolicy adds the Policy to the informer store without running the
+// informer.
+func (m *mockCRManager) addPolicy(plc *cisapiv1.Policy) {
+	_ = m.addNamespacedInformer(plc.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(plc.ObjectMeta.Namespace)
+	_ = crInf.plcInformer.GetIndexer().Add(plc)
+}
+
+// addService adds the Service to the informer store without running the
+// informer.
+func (m *mockCRManager) addService(svc *v1.Service) {
+	_ = m.addNamespacedInformer(svc.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(svc.ObjectMeta.Namespace)
+	_ = crInf.svcInformer.GetIndexer().Add(svc)
+}
+
+// addEndpoints adds the Endpoints to the informer store without running
+// the informer.
+func (m *mockCRManager) addEndpoints(eps *v1.Endpoints) {
+	_ = m.addNamespacedInformer(eps.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(eps.ObjectMeta.Namespace)
+	_ = crInf.epsInformer.GetIndexer().Add(eps)
+}
+
+// addPod adds the Pod to the informer store without running the informer.
+func (m *mockCRManager) addPod(pod *v1.Pod) {
+	_ = m.addNamespacedInformer(pod.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(pod.ObjectMeta.Namespace)
+	_ = crInf.podInformer.GetIndexer().Add(pod)
+}
+
+// addEndpointSlice adds the EndpointSlice to the informer store without
+// running the informer.
+func (m *mockCRManager) addEndpointSlice(slice *discoveryv1alpha1.EndpointSlice) {
+	_ = m.addNamespacedInformer(slice.ObjectMeta.Namespace)
+	crInf, _ := m.getNamespaceInformer(slice.ObjectMeta.Namespace)
+	_ = crInf.sliceInformer.GetIndexer().Add(slice)
+}
+
+// drainQueue returns the keys of all the resources in rscQueue.
+func (m *mockCRManager) drainQueue() []*rqKey {
+	var keys []*rqKey
+	for m.rscQueue.Len() > 0 {
+		key, _ := m.rscQueue.Get()
+		m.rscQueue.Done(key)
+		keys = append(keys, key.(*rqKey))
+	}
+	return keys
+}
+
+// processWithWorkers processes the resources in rscQueue with the workers
+// until the queue is empty, no resources are added to it afterwards.
+func (m *mockCRManager) processWithWorkers(workers int) {
+	m.rscQueue.ShutDown()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m.processResource() {
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// getFakeEvents returns the Events recorded for the namespace
+func (m *mockCRManager) getFakeEvents(namespace string) []FakeEvent {
+	nen, found := m.eventNotifier.notifierMap[namespace]
+	if !found {
+		return nil
+	}
+	fakeRecorder := nen.recorder.(*FakeEventRecorder)
+	return fakeRecorder.FEvent
+}
@@ -0,0 +1,408 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// crdResource is the resource of the CustomResourceDefinitions
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// customResource describes the CRD of a custom resource of CIS
+type customResource struct {
+	kind       string
+	plural     string
+	shortNames []string
+	// object is the Go type of the resource, its schema is generated
+	// from it
+	object interface{}
+	// status enables the status subresource
+	status bool
+	// printerColumns are the additional columns of kubectl get
+	printerColumns []interface{}
+}
+
+// customResources are the custom resources of CIS
+var customResources = []customResource{
+	{
+		kind:       VirtualServer,
+		plural:     "virtualservers",
+		shortNames: []string{"vs"},
+		object:     cisapiv1.VirtualServer{},
+		status:     true,
+		printerColumns: []interface{}{
+			printerColumn("address", ".status.vsAddress"),
+			printerColumn("status", ".status.status"),
+		},
+	},
+	{
+		kind:       TLSProfile,
+		plural:     "tlsprofiles",
+		shortNames: []string{"tls"},
+		object:     cisapiv1.TLSProfile{},
+	},
+	{
+		kind:       TransportServer,
+		plural:     "transportservers",
+		shortNames: []string{"ts"},
+		object:     cisapiv1.TransportServer{},
+	},
+	{
+		kind:       IngressLink,
+		plural:     "ingresslinks",
+		shortNames: []string{"il"},
+		object:     cisapiv1.IngressLink{},
+	},
+	{
+		kind:       ExternalDNS,
+		plural:     "externaldnses",
+		shortNames: []string{"edns"},
+		object:     cisapiv1.ExternalDNS{},
+	},
+	{
+		kind:       CustomPolicy,
+		plural:     "policies",
+		shortNames: []string{"plc"},
+		object:     cisapiv1.Policy{},
+	},
+}
+
+// schemaProps are the validations and the default of a field, which its Go
+// type does not tell. The props of an array apply to its items.
+type schemaProps struct {
+	enum     []interface{}
+	minimum  *int64
+	maximum  *int64
+	pattern  string
+	required []string
+	def      interface{}
+}
+
+func enum(values ...interface{}) schemaProps {
+	return schemaProps{enum: values}
+}
+
+func minimum(min int64) schemaProps {
+	return schemaProps{minimum: &min}
+}
+
+func pattern(p string) schemaProps {
+	return schemaProps{pattern: p}
+}
+
+func required(fields ...string) schemaProps {
+	return schemaProps{required: fields}
+}
+
+// portProps bound the port numbers
+var portProps = schemaProps{minimum: int64Ptr(1), maximum: int64Ptr(65535)}
+
+// redirectCodes are the status codes of the redirects
+var redirectCodes = enum(int64(301), int64(302), int64(307), int64(308))
+
+// bigIPPathPattern matches the full path of a BIG-IP object
+const bigIPPathPattern = `^/[^/]+/.+$`
+
+// fieldProps are the schemaProps of the fields by kind and path, like
+// VirtualServer.spec.pools.weight
+var fieldProps = map[string]schemaProps{
+	"VirtualServer.spec.httpTraffic": enum("allow", "none", "redirect"),
+	"VirtualServer.spec.partialErrorPolicy": enum(RejectInvalidPools,
+		SkipInvalidPools),
+	"VirtualServer.spec.pools.poolMemberType":         enum(ClusterMode, NodePortMode),
+	"VirtualServer.spec.pools.backupPool.servicePort": portProps,
+	"VirtualServer.spec.pools.backupPool.port":        portProps,
+	"VirtualServer.spec.pools.priorityGroup":          minimum(0),
+	"VirtualServer.spec.pools.minActiveMembers":       minimum(0),
+	"VirtualServer.spec.pools.serviceDownAction": {
+		enum: []interface{}{ServiceDownActionNone, ServiceDownActionReset,
+			ServiceDownActionDrop, ServiceDownActionReselect},
+		def: ServiceDownActionNone,
+	},
+	"VirtualServer.spec.pools.staticMembers":      required("address", "port"),
+	"VirtualServer.spec.pools.staticMembers.port": portProps,
+	"VirtualServer.spec.pools.ports":              required("path", "servicePort"),
+	"VirtualServer.spec.pools.pathMatchType": {
+		enum: []interface{}{PathMatchPrefix, PathMatchExact, PathMatchRegex},
+		def:  PathMatchPrefix,
+	},
+	"VirtualServer.spec.pools.weight": {
+		minimum: int64Ptr(0),
+		def:     int64(DefaultPoolWeight),
+	},
+	"VirtualServer.spec.pools.rewrite.targetPath": pattern(`^/`),
+	"VirtualServer.spec.pools.matchQueryParams":   pattern(`^[^=]+=.*$`),
+	"VirtualServer.spec.pools.action":             required("type"),
+	"VirtualServer.spec.pools.action.type": enum(PoolActionReset,
+		PoolActionDrop, PoolActionRedirect),
+	"VirtualServer.spec.pools.action.code": redirectCodes,
+	"VirtualServer.spec.pools.monitor":     required("type"),
+	"VirtualServer.spec.pools.monitor.type": enum(MonitorHTTP, MonitorHTTPS,
+		MonitorTCP, MonitorUDP),
+	"VirtualServer.spec.pools.monitor.interval":   minimum(0),
+	"VirtualServer.spec.pools.monitor.timeout":    minimum(0),
+	"VirtualServer.spec.pools.monitor.targetPort": portProps,
+	"VirtualServer.spec.pools.minimumMonitors":    minimum(0),
+	"VirtualServer.spec.waf":                      pattern(bigIPPathPattern),
+	"VirtualServer.spec.connectionLimit":          minimum(0),
+	"VirtualServer.spec.rateLimit":                minimum(0),
+	"VirtualServer.spec.partition":                pattern(partitionRegex.String()),
+	"VirtualServer.spec.pools.nodeMemberSelector.matchExpressions": required(
+		"key", "operator"),
+	"VirtualServer.spec.pools.nodeMemberSelector.matchExpressions.operator": enum(
+		string(metav1.LabelSelectorOpIn), string(metav1.LabelSelectorOpNotIn),
+		string(metav1.LabelSelectorOpExists),
+		string(metav1.LabelSelectorOpDoesNotExist)),
+	"VirtualServer.spec.pools.responseHeaders.remove": pattern(
+		headerNameRegex.String()),
+	"VirtualServer.spec.maintenance":      required("enabled"),
+	"VirtualServer.spec.maintenance.pool": pattern(bigIPPathPattern),
+	"VirtualServer.spec.icmpEcho": enum(ICMPEchoEnable, ICMPEchoDisable,
+		ICMPEchoSelective),
+	"VirtualServer.spec.redirectCode":            redirectCodes,
+	"VirtualServer.spec.hsts":                    required("enabled"),
+	"VirtualServer.spec.hsts.maxAge":             minimum(0),
+	"VirtualServer.spec.defaultPool":             required("service", "servicePort"),
+	"VirtualServer.spec.defaultPool.servicePort": portProps,
+
+	"TLSProfile.spec.tls.termination": enum(TLSEdge, TLSReencrypt,
+		TLSPassthrough),
+	"TLSProfile.spec.tls.reference":       enum(BIGIP, Secret),
+	"TLSProfile.spec.tls.clientAuth":      required("caCertificate"),
+	"TLSProfile.spec.tls.clientAuth.mode": enum(PeerCertRequired, PeerCertRequested),
+
+	"TransportServer.spec":                        required("virtualServerAddress", "pool"),
+	"TransportServer.spec.virtualServerPort":      portProps,
+	"TransportServer.spec.virtualServerPorts":     portProps,
+	"TransportServer.spec.virtualServerPortRange": pattern(`^[0-9]+-[0-9]+$`),
+	"TransportServer.spec.portMapping":            enum(PortMappingSame, PortMappingOffset),
+	"TransportServer.spec.mode": enum(TransportServerStandard,
+		TransportServerPerformanceL4),
+	"TransportServer.spec.type": enum(TransportServerTCP,
+		TransportServerUDP),
+	"TransportServer.spec.pool":             required("service"),
+	"TransportServer.spec.pool.servicePort": portProps,
+	"TransportServer.spec.pool.monitor":     pattern(bigIPPathPattern),
+
+	"IngressLink.spec": required("virtualServerAddress", "selector"),
+
+	"ExternalDNS.spec":                      required("domainName", "pools"),
+	"ExternalDNS.spec.dnsRecordType":        enum("A", "AAAA"),
+	"ExternalDNS.spec.pools":                required("name", "dataServerName"),
+	"ExternalDNS.spec.pools.dataServerName": pattern(bigIPPathPattern),
+	"ExternalDNS.spec.pools.dnsRecordType":  enum("A", "AAAA"),
+	"ExternalDNS.spec.pools.monitors":       required("type"),
+
+	"Policy.spec.firewallPolicy": pattern(bigIPPathPattern),
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func printerColumn(name, jsonPath string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"type":     "string",
+		"jsonPath": jsonPath,
+	}
+}
+
+// openAPISchema returns the structural OpenAPI v3 schema of the Go type of
+// the field at path of the custom resource of kind
+func openAPISchema(t reflect.Type, kind, path string) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(intstr.IntOrString{}):
+		return map[string]interface{}{"x-kubernetes-int-or-string": true}
+	case reflect.TypeOf(metav1.ObjectMeta{}):
+		return map[string]interface{}{"type": "object"}
+	case reflect.TypeOf(metav1.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	var s map[string]interface{}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem(), kind, path)
+	case reflect.Slice:
+		// The props of the field apply to the items
+		return map[string]interface{}{
+			"type":  "array",
+			"items": openAPISchema(t.Elem(), kind, path),
+		}
+	case reflect.Map:
+		s = map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": openAPISchema(t.Elem(), kind, path),
+		}
+	case reflect.Struct:
+		s = map[string]interface{}{
+			"type":       "object",
+			"properties": structProperties(t, kind, path),
+		}
+	case reflect.String:
+		s = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		s = map[string]interface{}{"type": "boolean"}
+	case reflect.Int32:
+		s = map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		s = map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int:
+		s = map[string]interface{}{"type": "integer"}
+	default:
+		panic(fmt.Sprintf("No schema of field %s.%s of type %v", kind, path, t))
+	}
+	props, ok := fieldProps[kind+"."+path]
+	if !ok {
+		return s
+	}
+	if len(props.enum) > 0 {
+		s["enum"] = props.enum
+	}
+	if nil != props.minimum {
+		s["minimum"] = *props.minimum
+	}
+	if nil != props.maximum {
+		s["maximum"] = *props.maximum
+	}
+	if props.pattern != "" {
+		s["pattern"] = props.pattern
+	}
+	if len(props.required) > 0 {
+		var fields []interface{}
+		for _, f := range props.required {
+			fields = append(fields, f)
+		}
+		s["required"] = fields
+	}
+	if nil != props.def {
+		s["default"] = props.def
+	}
+	return s
+}
+
+// structProperties returns the schemas of the fields of the struct by JSON
+// name, the fields of the inlined structs included
+func structProperties(t reflect.Type, kind, path string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" && f.Anonymous {
+			for n, p := range structProperties(f.Type, kind, path) {
+				properties[n] = p
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		properties[name] = openAPISchema(f.Type, kind, fieldPath)
+	}
+	return properties
+}
+
+// newCustomResourceDefinition returns the CRD of the custom resource with
+// the schema of its Go type
+func newCustomResourceDefinition(cr customResource) *unstructured.Unstructured {
+	version := map[string]interface{}{
+		"name":    cisapiv1.SchemeGroupVersion.Version,
+		"served":  true,
+		"storage": true,
+		"schema": map[string]interface{}{
+			"openAPIV3Schema": openAPISchema(reflect.TypeOf(cr.object),
+				cr.kind, ""),
+		},
+	}
+	if cr.status {
+		version["subresources"] = map[string]interface{}{
+			"status": map[string]interface{}{},
+		}
+	}
+	if len(cr.printerColumns) > 0 {
+		version["additionalPrinterColumns"] = cr.printerColumns
+	}
+	var shortNames []interface{}
+	for _, name := range cr.shortNames {
+		shortNames = append(shortNames, name)
+	}
+	group := cisapiv1.SchemeGroupVersion.Group
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": crdResource.GroupVersion().String(),
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": cr.plural + "." + group,
+		},
+		"spec": map[string]interface{}{
+			"group": group,
+			"names": map[string]interface{}{
+				"kind":       cr.kind,
+				"plural":     cr.plural,
+				"singular":   strings.ToLower(cr.kind),
+				"shortNames": shortNames,
+			},
+			"scope":    "Namespaced",
+			"versions": []interface{}{version},
+		},
+	}}
+}
+
+// installCustomResourceDefinitions creates the CRDs of the custom resources
+// of CIS, or replaces the spec of the existing ones, so the API server
+// validates and defaults the resources with the schemas of the Go types.
+func (crMgr *CRManager) installCustomResourceDefinitions() error {
+	client := crMgr.dynamicClient.Resource(crdResource)
+	for _, cr := range customResources {
+		crd := newCustomResourceDefinition(cr)
+		old, err := client.Get(crd.GetName(), metav1.GetOptions{})
+		switch {
+		case errors.IsNotFound(err):
+			_, err = client.Create(crd, metav1.CreateOptions{})
+		case err == nil:
+			crd.SetResourceVersion(old.GetResourceVersion())
+			_, err = client.Update(crd, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to install CRD %s: %v", crd.GetName(),
+				err)
+		}
+		log.Infof("Installed CRD %s", crd.GetName())
+	}
+	return nil
+}
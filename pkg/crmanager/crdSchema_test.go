@@ -0,0 +1,226 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/xeipuuv/gojsonschema"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// crdExamples are the sample resources of the docs
+const crdExamples = "../../docs/_static/config_examples/crd"
+
+var _ = Describe("CRD Schema Tests", func() {
+	// strict returns the schema of the custom resource rejecting
+	// the unknown fields, like the API server prunes them
+	var strict func(s map[string]interface{}) map[string]interface{}
+	strict = func(s map[string]interface{}) map[string]interface{} {
+		out := make(map[string]interface{})
+		for k, v := range s {
+			out[k] = v
+		}
+		if props, ok := s["properties"].(map[string]interface{}); ok {
+			strictProps := make(map[string]interface{})
+			for name, p := range props {
+				strictProps[name] = strict(p.(map[string]interface{}))
+			}
+			out["properties"] = strictProps
+			out["additionalProperties"] = false
+		}
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			out["items"] = strict(items)
+		}
+		return out
+	}
+
+	schemaOf := func(kind string) *gojsonschema.Schema {
+		for _, cr := range customResources {
+			if cr.kind == kind {
+				s, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(
+					strict(openAPISchema(reflect.TypeOf(cr.object), kind, ""))))
+				Expect(err).NotTo(HaveOccurred())
+				return s
+			}
+		}
+		return nil
+	}
+
+	validate := func(kind, doc string) []string {
+		js, err := yaml.YAMLToJSON([]byte(doc))
+		Expect(err).NotTo(HaveOccurred())
+		result, err := schemaOf(kind).Validate(gojsonschema.NewBytesLoader(js))
+		Expect(err).NotTo(HaveOccurred())
+		var errs []string
+		for _, e := range result.Errors() {
+			errs = append(errs, e.String())
+		}
+		return errs
+	}
+
+	It("Validates the sample resources", func() {
+		files, err := filepath.Glob(filepath.Join(crdExamples, "*", "*.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		kinds := make(map[string]bool)
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			Expect(err).NotTo(HaveOccurred())
+			for _, doc := range strings.Split(string(data), "\n---") {
+				var obj struct {
+					Kind string `json:"kind"`
+				}
+				Expect(yaml.Unmarshal([]byte(doc), &obj)).To(Succeed())
+				if nil == schemaOf(obj.Kind) {
+					continue
+				}
+				kinds[obj.Kind] = true
+				Expect(validate(obj.Kind, doc)).To(BeEmpty(), f)
+			}
+		}
+		for _, cr := range customResources {
+			Expect(kinds).To(HaveKey(cr.kind), "No sample %s", cr.kind)
+		}
+	})
+
+	It("Rejects the invalid fields and values", func() {
+		vs := `
+apiVersion: cis.f5.com/v1
+kind: VirtualServer
+metadata:
+  name: vs
+spec:
+  host: test.com
+  virtualServerAddress: 10.1.1.1
+  %s
+  pools:
+  - path: /foo
+    service: svc1
+    servicePort: 80
+    backupPool:
+      service: svc2
+      servicePort: %d
+`
+		format := func(field string, port int) string {
+			return fmt.Sprintf(vs, field, port)
+		}
+		Expect(validate(VirtualServer, format("httpTraffic: redirect",
+			8080))).To(BeEmpty())
+		Expect(validate(VirtualServer, format("httpTraffics: redirect",
+			8080))).NotTo(BeEmpty())
+		Expect(validate(VirtualServer, format("httpTraffic: deny",
+			8080))).NotTo(BeEmpty())
+		Expect(validate(VirtualServer, format("httpTraffic: allow",
+			65536))).NotTo(BeEmpty())
+
+		tls := `
+apiVersion: cis.f5.com/v1
+kind: TLSProfile
+metadata:
+  name: tls
+spec:
+  tls:
+    termination: edge
+    clientSSL: /Common/clientssl
+    reference: configmap
+`
+		Expect(validate(TLSProfile, tls)).NotTo(BeEmpty())
+		Expect(validate(TLSProfile, strings.Replace(tls, "configmap",
+			BIGIP, 1))).To(BeEmpty())
+	})
+
+	It("Generates the props of existing fields", func() {
+		for path := range fieldProps {
+			parts := strings.Split(path, ".")
+			var object interface{}
+			for _, cr := range customResources {
+				if cr.kind == parts[0] {
+					object = cr.object
+				}
+			}
+			Expect(object).NotTo(BeNil(), path)
+			s := openAPISchema(reflect.TypeOf(object), parts[0], "")
+			for _, name := range parts[1:] {
+				if items, ok := s["items"].(map[string]interface{}); ok {
+					s = items
+				}
+				props, _ := s["properties"].(map[string]interface{})
+				Expect(props).To(HaveKey(name), path)
+				s = props[name].(map[string]interface{})
+			}
+		}
+	})
+
+	It("Defaults the pools", func() {
+		s := openAPISchema(reflect.TypeOf(customResources[0].object),
+			VirtualServer, "")
+		pool, _, err := unstructured.NestedMap(s, "properties", "spec",
+			"properties", "pools", "items", "properties")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool["pathMatchType"]).To(HaveKeyWithValue("default",
+			PathMatchPrefix))
+		Expect(pool["serviceDownAction"]).To(HaveKeyWithValue("default",
+			ServiceDownActionNone))
+		Expect(pool["weight"]).To(HaveKeyWithValue("default",
+			int64(DefaultPoolWeight)))
+		Expect(pool["servicePort"]).To(HaveKeyWithValue(
+			"x-kubernetes-int-or-string", true))
+		// The pools with ports have no servicePort to default
+		Expect(pool["servicePort"]).NotTo(HaveKey("default"))
+	})
+
+	It("Installs and updates the CRDs", func() {
+		mockCRM := newMockCRManager()
+		client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+		mockCRM.dynamicClient = client
+		Expect(mockCRM.installCustomResourceDefinitions()).To(Succeed())
+
+		crds := client.Resource(crdResource)
+		crd, err := crds.Get("virtualservers.cis.f5.com", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(crd.GetKind()).To(Equal("CustomResourceDefinition"))
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec",
+			"versions")
+		Expect(versions).To(HaveLen(1))
+		Expect(versions[0]).To(HaveKey("subresources"))
+		for _, cr := range customResources {
+			_, err := crds.Get(cr.plural+".cis.f5.com", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// The CRDs changed since are replaced
+		Expect(unstructured.SetNestedField(crd.Object, "Cluster", "spec",
+			"scope")).To(Succeed())
+		_, err = crds.Update(crd, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mockCRM.installCustomResourceDefinitions()).To(Succeed())
+		crd, err = crds.Get("virtualservers.cis.f5.com", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope")
+		Expect(scope).To(Equal("Namespaced"))
+	})
+})
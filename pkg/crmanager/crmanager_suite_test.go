@@ -0,0 +1,13 @@
+package crmanager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCRManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Custom Resource Manager Suite")
+}
@@ -0,0 +1,327 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+type (
+	// resourcesSnapshot is the state of the resources served at
+	// /resources. It is copied with the locks held and encoded after
+	// releasing them.
+	resourcesSnapshot struct {
+		configs    []debugResourceConfig
+		deps       []debugObject
+		iRules     []NameRef
+		dataGroups []debugDataGroup
+		profiles   []debugProfile
+	}
+
+	// debugResourceConfig is a ResourceConfig with its metadata
+	debugResourceConfig struct {
+		ResourceType string   `json:"resourceType"`
+		Active       bool     `json:"active"`
+		Owners       []string `json:"owners,omitempty"`
+		*ResourceConfig
+	}
+
+	// debugObject is an ObjectDependency, with its use count when it is
+	// a dependency and with its dependencies otherwise
+	debugObject struct {
+		Kind         string        `json:"kind"`
+		Namespace    string        `json:"namespace,omitempty"`
+		Name         string        `json:"name"`
+		Service      string        `json:"service,omitempty"`
+		Pool         string        `json:"pool,omitempty"`
+		Count        int           `json:"count,omitempty"`
+		Dependencies []debugObject `json:"dependencies,omitempty"`
+	}
+
+	// debugDataGroup is an internal data group of a namespace, without
+	// its records
+	debugDataGroup struct {
+		Name      string `json:"name"`
+		Partition string `json:"partition"`
+		Namespace string `json:"namespace"`
+		Records   int    `json:"records"`
+	}
+
+	// debugProfile is the key of a custom profile, never its certificate
+	// and key
+	debugProfile struct {
+		Name         string `json:"name"`
+		ResourceName string `json:"resourceName"`
+		Partition    string `json:"partition,omitempty"`
+	}
+)
+
+// handleResources serves the resource configs, the object dependencies,
+// and the names of the iRules, data groups and custom profiles as JSON.
+// With the "namespace" parameter, only the resources of the namespace are
+// served.
+func (crMgr *CRManager) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snapshot := crMgr.snapshotResources(r.URL.Query().Get("namespace"))
+	w.Header().Set("Content-Type", "application/json")
+	if err := snapshot.writeJSON(w); err != nil {
+		log.Errorf("Failed to serve the resources: %v", err)
+	}
+}
+
+// snapshotResources copies the resources of the namespace, all the
+// resources when namespace is empty. Each lock is held only while its
+// resources are copied. The configs are copied holding processingMutex too,
+// as the workers update the pool members of the configs in place.
+func (crMgr *CRManager) snapshotResources(namespace string) *resourcesSnapshot {
+	var snapshot resourcesSnapshot
+	inNamespace := func(ns string) bool {
+		return namespace == "" || ns == namespace
+	}
+	// Virtuals of the namespace and their iRules
+	virtuals := make(map[NameRef]bool)
+	iRules := make(map[string]bool)
+
+	// RLock is not enough: the workers hold processingMutex for reading
+	// while they update the configs of their virtuals, under the locks of
+	// the virtuals only.
+	crMgr.processingMutex.Lock()
+	rs := crMgr.resources
+	rs.RLock()
+	for _, cfg := range rs.rsMap {
+		owned := namespace == ""
+		for _, owner := range cfg.MetaData.owners {
+			if strings.HasPrefix(owner, namespace+"/") {
+				owned = true
+			}
+		}
+		if !owned {
+			continue
+		}
+		cfg = cfg.DeepCopy()
+		snapshot.configs = append(snapshot.configs, debugResourceConfig{
+			ResourceType:   cfg.MetaData.ResourceType,
+			Active:         cfg.MetaData.Active,
+			Owners:         cfg.MetaData.owners,
+			ResourceConfig: cfg,
+		})
+		virtuals[cfg.GetRef()] = true
+		for _, irule := range cfg.Virtual.IRules {
+			iRules[irule] = true
+		}
+	}
+	for key, deps := range rs.objDeps {
+		if !inNamespace(key.Namespace) {
+			continue
+		}
+		obj := newDebugObject(key, 0)
+		for dep, count := range deps {
+			obj.Dependencies = append(obj.Dependencies, newDebugObject(dep, count))
+		}
+		sortDebugObjects(obj.Dependencies)
+		snapshot.deps = append(snapshot.deps, obj)
+	}
+	rs.RUnlock()
+	crMgr.processingMutex.Unlock()
+
+	crMgr.irulesMutex.Lock()
+	for ref := range crMgr.irulesMap {
+		if namespace == "" || iRules[JoinBigipPath(ref.Partition, ref.Name)] ||
+			iRules[fmt.Sprintf("/%s/%s/%s", ref.Partition,
+				as3SharedApplication, ref.Name)] {
+			snapshot.iRules = append(snapshot.iRules, ref)
+		}
+	}
+	crMgr.irulesMutex.Unlock()
+
+	crMgr.intDgMutex.Lock()
+	for ref, nsDgs := range crMgr.intDgMap {
+		for ns, dg := range nsDgs {
+			if !inNamespace(ns) {
+				continue
+			}
+			snapshot.dataGroups = append(snapshot.dataGroups, debugDataGroup{
+				Name:      ref.Name,
+				Partition: ref.Partition,
+				Namespace: ns,
+				Records:   len(dg.Records),
+			})
+		}
+	}
+	crMgr.intDgMutex.Unlock()
+
+	crMgr.customProfiles.Lock()
+	for key := range crMgr.customProfiles.Profs {
+		if namespace == "" || virtuals[NameRef{Name: key.ResourceName,
+			Partition: key.Partition}] {
+			snapshot.profiles = append(snapshot.profiles, debugProfile{
+				Name:         key.Name,
+				ResourceName: key.ResourceName,
+				Partition:    key.Partition,
+			})
+		}
+	}
+	crMgr.customProfiles.Unlock()
+
+	snapshot.sort()
+	return &snapshot
+}
+
+func newDebugObject(dep ObjectDependency, count int) debugObject {
+	return debugObject{
+		Kind:      dep.Kind,
+		Namespace: dep.Namespace,
+		Name:      dep.Name,
+		Service:   dep.Service,
+		Pool:      dep.Pool,
+		Count:     count,
+	}
+}
+
+// sort sorts the resources of the snapshot, so that it is served the same
+// until the resources change
+func (snapshot *resourcesSnapshot) sort() {
+	sort.Slice(snapshot.configs, func(i, j int) bool {
+		a, b := snapshot.configs[i].Virtual, snapshot.configs[j].Virtual
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Name < b.Name
+	})
+	sortDebugObjects(snapshot.deps)
+	sort.Slice(snapshot.iRules, func(i, j int) bool {
+		a, b := snapshot.iRules[i], snapshot.iRules[j]
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Name < b.Name
+	})
+	sort.Slice(snapshot.dataGroups, func(i, j int) bool {
+		a, b := snapshot.dataGroups[i], snapshot.dataGroups[j]
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Namespace < b.Namespace
+	})
+	sort.Slice(snapshot.profiles, func(i, j int) bool {
+		a, b := snapshot.profiles[i], snapshot.profiles[j]
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		if a.ResourceName != b.ResourceName {
+			return a.ResourceName < b.ResourceName
+		}
+		return a.Name < b.Name
+	})
+}
+
+func sortDebugObjects(objs []debugObject) {
+	key := func(obj debugObject) []string {
+		return []string{obj.Kind, obj.Namespace, obj.Name, obj.Service, obj.Pool}
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		a, b := key(objs[i]), key(objs[j])
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+}
+
+// writeJSON writes the snapshot as a JSON object. The resource configs are
+// keyed by their path and encoded one at a time, so that the whole object
+// is never held in memory.
+func (snapshot *resourcesSnapshot) writeJSON(w io.Writer) error {
+	jw := &jsonWriter{w: w}
+	jw.raw(`{"resourceConfigs":{`)
+	for i, cfg := range snapshot.configs {
+		if i > 0 {
+			jw.raw(",")
+		}
+		jw.value(JoinBigipPath(cfg.Virtual.Partition, cfg.Virtual.Name))
+		jw.raw(":")
+		jw.value(cfg)
+	}
+	jw.raw(`},"objectDependencies":`)
+	jw.list(len(snapshot.deps), func(i int) interface{} {
+		return snapshot.deps[i]
+	})
+	jw.raw(`,"iRules":`)
+	jw.list(len(snapshot.iRules), func(i int) interface{} {
+		return snapshot.iRules[i]
+	})
+	jw.raw(`,"dataGroups":`)
+	jw.list(len(snapshot.dataGroups), func(i int) interface{} {
+		return snapshot.dataGroups[i]
+	})
+	jw.raw(`,"customProfiles":`)
+	jw.list(len(snapshot.profiles), func(i int) interface{} {
+		return snapshot.profiles[i]
+	})
+	jw.raw("}\n")
+	return jw.err
+}
+
+// jsonWriter writes JSON to w until the first error
+type jsonWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (jw *jsonWriter) raw(s string) {
+	if nil == jw.err {
+		_, jw.err = io.WriteString(jw.w, s)
+	}
+}
+
+func (jw *jsonWriter) value(v interface{}) {
+	if nil != jw.err {
+		return
+	}
+	var data []byte
+	if data, jw.err = json.Marshal(v); nil == jw.err {
+		_, jw.err = jw.w.Write(data)
+	}
+}
+
+// list writes the n elements got from elem as a JSON array, an empty one
+// when n is 0
+func (jw *jsonWriter) list(n int, elem func(i int) interface{}) {
+	jw.raw("[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			jw.raw(",")
+		}
+		jw.value(elem(i))
+	}
+	jw.raw("]")
+}
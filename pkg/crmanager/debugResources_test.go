@@ -0,0 +1,200 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Debug Resources Tests", func() {
+	var mockCRM *mockCRManager
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mockCRM.handleResources(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	// resources returns the resources served at the url
+	resources := func(url string) map[string]interface{} {
+		rec := serve("GET", url)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		var rscs map[string]interface{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &rscs)).To(Succeed())
+		return rscs
+	}
+
+	addConfig := func(namespace, name string) {
+		cfg := &ResourceConfig{}
+		cfg.MetaData.ResourceType = VirtualServer
+		cfg.MetaData.addOwner(namespace + "/" + name)
+		cfg.Virtual.Name = "vs_" + name
+		cfg.Virtual.Partition = "test"
+		cfg.Virtual.AddIRule(JoinBigipPath("test", name+"_irule"))
+		cfg.Pools = Pools{{Name: name + "_pool", Partition: "test"}}
+		mockCRM.resources.setResourceConfig(cfg)
+
+		vsKey := ObjectDependency{Kind: VirtualServer, Namespace: namespace,
+			Name: name}
+		mockCRM.resources.objDeps[vsKey] = ObjectDependencies{
+			{Kind: Service, Namespace: namespace, Name: name}: 1,
+		}
+		mockCRM.irulesMap[NameRef{Name: name + "_irule", Partition: "test"}] =
+			&IRule{Name: name + "_irule", Partition: "test", Code: "when"}
+		dg := NewInternalDataGroup("https_redirect_dg", "test")
+		dg.AddOrUpdateRecord("host", "path")
+		dgRef := NameRef{Name: "https_redirect_dg", Partition: "test"}
+		if _, ok := mockCRM.intDgMap[dgRef]; !ok {
+			mockCRM.intDgMap[dgRef] = make(DataGroupNamespaceMap)
+		}
+		mockCRM.intDgMap[dgRef][namespace] = dg
+		mockCRM.customProfiles.Profs[SecretKey{Name: name + "_tls",
+			ResourceName: cfg.Virtual.Name, Partition: "test"}] = CustomProfile{
+			Name: name + "_tls", Cert: "CERTIFICATE", Key: "PRIVATE KEY"}
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		addConfig("default", "foo")
+		addConfig("other", "bar")
+	})
+
+	It("Serves the resources", func() {
+		body := serve("GET", "/resources").Body.String()
+		Expect(body).NotTo(ContainSubstring("CERTIFICATE"))
+		Expect(body).NotTo(ContainSubstring("PRIVATE KEY"))
+
+		rscs := resources("/resources")
+		cfgs := rscs["resourceConfigs"].(map[string]interface{})
+		Expect(cfgs).To(HaveLen(2))
+		cfg := cfgs["/test/vs_foo"].(map[string]interface{})
+		Expect(cfg["resourceType"]).To(Equal(VirtualServer))
+		Expect(cfg["owners"]).To(Equal([]interface{}{"default/foo"}))
+		Expect(cfg["virtual"]).To(HaveKeyWithValue("name", "vs_foo"))
+		Expect(cfg["pools"]).To(HaveLen(1))
+
+		Expect(rscs["objectDependencies"]).To(Equal([]interface{}{
+			map[string]interface{}{
+				"kind": VirtualServer, "namespace": "default", "name": "foo",
+				"dependencies": []interface{}{
+					map[string]interface{}{"kind": Service,
+						"namespace": "default", "name": "foo", "count": 1.0},
+				},
+			},
+			map[string]interface{}{
+				"kind": VirtualServer, "namespace": "other", "name": "bar",
+				"dependencies": []interface{}{
+					map[string]interface{}{"kind": Service,
+						"namespace": "other", "name": "bar", "count": 1.0},
+				},
+			},
+		}))
+		Expect(rscs["iRules"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "bar_irule", "partition": "test"},
+			map[string]interface{}{"name": "foo_irule", "partition": "test"},
+		}))
+		Expect(rscs["dataGroups"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "https_redirect_dg",
+				"partition": "test", "namespace": "default", "records": 1.0},
+			map[string]interface{}{"name": "https_redirect_dg",
+				"partition": "test", "namespace": "other", "records": 1.0},
+		}))
+		Expect(rscs["customProfiles"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "bar_tls", "resourceName": "vs_bar",
+				"partition": "test"},
+			map[string]interface{}{"name": "foo_tls", "resourceName": "vs_foo",
+				"partition": "test"},
+		}))
+	})
+
+	It("Serves the resources of a namespace", func() {
+		rscs := resources("/resources?namespace=other")
+		cfgs := rscs["resourceConfigs"].(map[string]interface{})
+		Expect(cfgs).To(HaveLen(1))
+		Expect(cfgs).To(HaveKey("/test/vs_bar"))
+		Expect(rscs["objectDependencies"]).To(HaveLen(1))
+		Expect(rscs["iRules"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "bar_irule", "partition": "test"},
+		}))
+		Expect(rscs["dataGroups"]).To(HaveLen(1))
+		Expect(rscs["customProfiles"]).To(Equal([]interface{}{
+			map[string]interface{}{"name": "bar_tls", "resourceName": "vs_bar",
+				"partition": "test"},
+		}))
+
+		rscs = resources("/resources?namespace=none")
+		Expect(rscs["resourceConfigs"]).To(BeEmpty())
+		Expect(rscs["objectDependencies"]).To(BeEmpty())
+		Expect(rscs["iRules"]).To(BeEmpty())
+		Expect(rscs["dataGroups"]).To(BeEmpty())
+		Expect(rscs["customProfiles"]).To(BeEmpty())
+	})
+
+	It("Serves a snapshot of the resources", func() {
+		snapshot := mockCRM.snapshotResources("")
+		mockCRM.resources.deleteVirtualServer(NameRef{Name: "vs_foo",
+			Partition: "test"})
+		cfg, _ := mockCRM.resources.GetByName("test", "vs_bar")
+		cfg.Virtual.Name = "renamed"
+		Expect(snapshot.configs).To(HaveLen(2))
+		Expect(snapshot.configs[0].Virtual.Name).To(Equal("vs_bar"))
+		Expect(snapshot.configs[1].Virtual.Name).To(Equal("vs_foo"))
+	})
+
+	It("Serves the resources while the pool members are updated", func() {
+		cfg, _ := mockCRM.resources.GetByName("test", "vs_foo")
+		cfg.Pools[0].ServiceName = "foo"
+		cfg.Pools[0].ServiceNamespace = "default"
+		mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+		mockCRM.addService(test.NewService("foo", "1", "default",
+			v1.ServiceTypeClusterIP, []v1.ServicePort{{Port: 80}}))
+		eps := test.NewEndpoints("foo", "1", "node1", "default",
+			[]string{"10.1.1.1"}, nil, []v1.EndpointPort{{Port: 8080}})
+		mockCRM.addEndpoints(eps)
+
+		// The workers update the members of the pool in place, run with
+		// -race to detect the snapshots racing with them
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				mockCRM.enqueueEndpoints(eps)
+				mockCRM.processResource()
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			rscs := resources("/resources?namespace=default")
+			Expect(rscs["resourceConfigs"]).To(HaveKey("/test/vs_foo"))
+		}
+		<-done
+		cfg, _ = mockCRM.resources.GetByName("test", "vs_foo")
+		Expect(cfg.Pools[0].Members).To(HaveLen(1))
+	})
+
+	It("Serves GET requests only", func() {
+		Expect(serve("POST", "/resources").Code).To(
+			Equal(http.StatusMethodNotAllowed))
+	})
+})
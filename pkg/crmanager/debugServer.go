@@ -0,0 +1,98 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+// newDebugHandler returns the handler of the debug server. It serves the
+// pprof profiles, the log level and the resync trigger. When token is not
+// empty, requests must have the "Authorization: Bearer <token>" header.
+func (crMgr *CRManager) newDebugHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/loglevel", handleLogLevel)
+	mux.HandleFunc("/debug/resync", crMgr.handleResync)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+				log.Warningf("Debug server: denied %s %s from %s",
+					r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		log.Infof("Debug server: %s %s from %s",
+			r.Method, r.URL.Path, r.RemoteAddr)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// startDebugServer serves the debug handler on the address
+func (crMgr *CRManager) startDebugServer(address, token string) {
+	log.Infof("Starting debug server on %s", address)
+	go func() {
+		err := http.ListenAndServe(address, crMgr.newDebugHandler(token))
+		log.Errorf("Debug server stopped: %v", err)
+	}()
+}
+
+// handleLogLevel returns the log level, or sets it with the "level"
+// parameter of a POST request.
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level := strings.ToUpper(r.FormValue("level"))
+		ll := log.NewLogLevel(level)
+		if nil == ll {
+			http.Error(w, fmt.Sprintf("Invalid log level: %s", level),
+				http.StatusBadRequest)
+			return
+		}
+		log.SetLogLevel(*ll)
+		log.Infof("Debug server: log level set to %s", level)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, log.GetLogLevel())
+}
+
+// handleResync triggers a full resync with a POST request
+func (crMgr *CRManager) handleResync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	crMgr.enqueueResync()
+	log.Infof("Debug server: full resync triggered")
+	w.WriteHeader(http.StatusAccepted)
+}
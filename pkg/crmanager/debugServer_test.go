@@ -0,0 +1,98 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Debug Server Tests", func() {
+	var mockCRM *mockCRManager
+	var handler http.Handler
+
+	serve := func(method, url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		handler = mockCRM.newDebugHandler("secret")
+	})
+
+	It("Rejects requests without valid token", func() {
+		Expect(serve("GET", "/debug/loglevel", "").Code).To(
+			Equal(http.StatusUnauthorized))
+		Expect(serve("GET", "/debug/loglevel", "wrong").Code).To(
+			Equal(http.StatusUnauthorized))
+		Expect(serve("GET", "/debug/loglevel", "secret").Code).To(
+			Equal(http.StatusOK))
+		Expect(serve("GET", "/debug/pprof/", "secret").Code).To(
+			Equal(http.StatusOK))
+	})
+
+	It("Sets log level", func() {
+		oldLevel := log.GetLogLevel()
+		defer log.SetLogLevel(oldLevel)
+
+		rec := serve("POST", "/debug/loglevel?level=debug", "secret")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(log.GetLogLevel()).To(Equal(log.LogLevel(log.LL_DEBUG)))
+		Expect(strings.TrimSpace(rec.Body.String())).To(Equal("debug"))
+
+		rec = serve("POST", "/debug/loglevel?level=verbose", "secret")
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(log.GetLogLevel()).To(Equal(log.LogLevel(log.LL_DEBUG)))
+	})
+
+	It("Triggers resync of all VirtualServers", func() {
+		mockCRM.addVirtualServer(test.NewVirtualServer("SampleVS", "default",
+			cisapiv1.VirtualServerSpec{Host: "test.com"}))
+		ref := NameRef{Name: "virtual", Partition: "test"}
+		mockCRM.resources.oldRsMap[ref] = &ResourceConfig{}
+
+		Expect(serve("GET", "/debug/resync", "secret").Code).To(
+			Equal(http.StatusMethodNotAllowed))
+		Expect(serve("POST", "/debug/resync", "secret").Code).To(
+			Equal(http.StatusAccepted))
+		keys := mockCRM.drainQueue()
+		Expect(len(keys)).To(Equal(1))
+		Expect(keys[0].kind).To(Equal(Resync))
+
+		mockCRM.resync()
+		// The configs last posted are kept to detect the drift
+		Expect(mockCRM.resources.oldRsMap).To(HaveKey(
+			NameRef{Name: "virtual", Partition: "test"}))
+		Expect(mockCRM.rebuilding).To(BeTrue())
+		keys = mockCRM.drainQueue()
+		Expect(len(keys)).To(Equal(1))
+		Expect(keys[0].rscName).To(Equal("SampleVS"))
+	})
+})
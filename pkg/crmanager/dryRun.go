@@ -0,0 +1,113 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+// DryRunWriter writes the declarations to out instead of posting them to
+// BIG-IP, and serves the last one over HTTP. The declarations are indented
+// with their keys sorted, so that the output of two runs can be diffed.
+type DryRunWriter struct {
+	sync.Mutex
+	out  io.Writer
+	decl []byte
+	// Number of declarations written
+	written uint64
+}
+
+// NewDryRunWriter returns a DryRunWriter writing the declarations to out
+func NewDryRunWriter(out io.Writer) *DryRunWriter {
+	return &DryRunWriter{out: out}
+}
+
+// NewDryRunAgent returns an Agent writing the declarations with the
+// writer, it neither starts the python driver nor contacts BIG-IP. Its
+// HTTPMux is not served.
+func NewDryRunAgent(partition string, declWriter DeclarationWriter) *Agent {
+	DEFAULT_PARTITION = partition
+	return &Agent{
+		DeclWriter: declWriter,
+		Partition:  partition,
+		HTTPMux:    http.NewServeMux(),
+	}
+}
+
+// Write writes the declaration indented to out
+func (w *DryRunWriter) Write(data string, partitions []string) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(data), "", "  "); err != nil {
+		log.Errorf("[AS3] Invalid declaration: %v", err)
+		return
+	}
+	buf.WriteByte('\n')
+
+	w.Lock()
+	defer w.Unlock()
+	w.decl = buf.Bytes()
+	w.written++
+	if _, err := w.out.Write(w.decl); err != nil {
+		log.Errorf("[AS3] Failed to write the declaration: %v", err)
+	}
+}
+
+// Cancel does nothing, the declarations are written at once
+func (w *DryRunWriter) Cancel() {
+}
+
+// Written returns the number of declarations written
+func (w *DryRunWriter) Written() uint64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.written
+}
+
+// Posted returns the number of declarations written, they are written at
+// once
+func (w *DryRunWriter) Posted() uint64 {
+	return w.Written()
+}
+
+// PartitionPosted returns the number of declarations written, the
+// partitions are all written at once
+func (w *DryRunWriter) PartitionPosted(partition string) uint64 {
+	return w.Written()
+}
+
+// ServeHTTP serves the last declaration written
+func (w *DryRunWriter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Lock()
+	decl := w.decl
+	w.Unlock()
+	if nil == decl {
+		http.Error(rw, "No declaration yet", http.StatusServiceUnavailable)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(decl)
+}
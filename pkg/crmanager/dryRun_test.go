@@ -0,0 +1,93 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dry Run Tests", func() {
+	var oldPartition string
+
+	BeforeEach(func() {
+		oldPartition = DEFAULT_PARTITION
+	})
+
+	AfterEach(func() {
+		DEFAULT_PARTITION = oldPartition
+	})
+
+	// declare processes the VirtualServers in the order of the indexes
+	// and returns the declaration written in dry-run mode
+	declare := func(indexes []int) string {
+		var out bytes.Buffer
+		mockCRM := newMockCRManager()
+		mockCRM.Agent = NewDryRunAgent("test", NewDryRunWriter(&out))
+		for _, i := range indexes {
+			// VirtualServers in pairs share the address
+			mockCRM.addSecretVirtualServer(i, fmt.Sprintf("10.1.1.%d", i/2))
+		}
+		mockCRM.processWithWorkers(1)
+		mockCRM.flushConfig()
+		return out.String()
+	}
+
+	It("Writes the declaration without BIG-IP", func() {
+		out := declare([]int{0, 1})
+		Expect(out).To(HavePrefix("{\n  \"$schema\""))
+		Expect(out).To(HaveSuffix("}\n"))
+
+		var decl map[string]interface{}
+		Expect(json.Unmarshal([]byte(out), &decl)).To(Succeed())
+		adc := decl["declaration"].(map[string]interface{})
+		Expect(adc).To(HaveKey("test"))
+		app := adc["test"].(map[string]interface{})[as3SharedApplication]
+		Expect(app).To(HaveKey(formatVirtualServerName("10.1.1.0", 443)))
+	})
+
+	It("Writes the same declaration in any processing order", func() {
+		out := declare([]int{0, 1, 2, 3, 4, 5})
+		Expect(declare([]int{5, 3, 1, 4, 2, 0})).To(Equal(out))
+	})
+
+	It("Serves the last declaration", func() {
+		writer := NewDryRunWriter(&bytes.Buffer{})
+		serve := func(method string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			writer.ServeHTTP(rec, httptest.NewRequest(method, "/declaration", nil))
+			return rec
+		}
+		Expect(serve("GET").Code).To(Equal(http.StatusServiceUnavailable))
+
+		writer.Write(`{"class":"AS3","declaration":{}}`, nil)
+		writer.Write(`{"class":"AS3","declaration":{"class":"ADC"}}`, nil)
+		rec := serve("GET")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(Equal(
+			"{\n  \"class\": \"AS3\",\n  \"declaration\": {\n" +
+				"    \"class\": \"ADC\"\n  }\n}\n"))
+		Expect(serve("POST").Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,165 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/F5Networks/k8s-bigip-ctlr/config/client/clientset/versioned/scheme"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+type (
+	NewBroadcasterFunc func() record.EventBroadcaster
+
+	EventNotifier struct {
+		mutex           sync.Mutex
+		notifierMap     map[string]*NamespaceEventNotifier
+		broadcasterFunc NewBroadcasterFunc
+	}
+
+	NamespaceEventNotifier struct {
+		mutex       sync.Mutex
+		broadcaster record.EventBroadcaster
+		recorder    record.EventRecorder
+		// Events recorded within eventDedupInterval, key is the kind,
+		// name and reason of the Event
+		recentEvents map[string]recentEvent
+	}
+
+	recentEvent struct {
+		message string
+		time    time.Time
+	}
+)
+
+// eventDedupInterval is the interval an Event with the same reason and
+// message is recorded only once for an object, so a failure repeated on
+// every sync does not flood the API server with Events.
+const eventDedupInterval = 10 * time.Minute
+
+func NewEventNotifier(bfunc NewBroadcasterFunc) *EventNotifier {
+	if nil == bfunc {
+		// No broadcaster func provided (unit testing), use real one.
+		bfunc = record.NewBroadcaster
+	}
+	return &EventNotifier{
+		notifierMap:     make(map[string]*NamespaceEventNotifier),
+		broadcasterFunc: bfunc,
+	}
+}
+
+// Create a notifier for a namespace, or return the existing one
+func (en *EventNotifier) createNotifierForNamespace(
+	namespace string,
+	coreIntf corev1.CoreV1Interface,
+) *NamespaceEventNotifier {
+
+	en.mutex.Lock()
+	defer en.mutex.Unlock()
+
+	evNotifier, found := en.notifierMap[namespace]
+	if !found {
+		source := v1.EventSource{Component: "k8s-bigip-ctlr"}
+		broadcaster := en.broadcasterFunc()
+		// Custom Resources are registered with the clientset scheme
+		recorder := broadcaster.NewRecorder(scheme.Scheme, source)
+		evNotifier = &NamespaceEventNotifier{
+			broadcaster:  broadcaster,
+			recorder:     recorder,
+			recentEvents: make(map[string]recentEvent),
+		}
+		en.notifierMap[namespace] = evNotifier
+		broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{
+			Interface: coreIntf.Events(namespace),
+		})
+	}
+	return evNotifier
+}
+
+func (nen *NamespaceEventNotifier) recordEvent(
+	obj runtime.Object,
+	annotations map[string]string,
+	eventType,
+	reason,
+	message string,
+) {
+	if nen.isRecentEvent(obj, reason, message) {
+		return
+	}
+	if len(annotations) > 0 {
+		nen.recorder.AnnotatedEventf(obj, annotations, eventType, reason,
+			"%s", message)
+		return
+	}
+	nen.recorder.Event(obj, eventType, reason, message)
+}
+
+// isRecentEvent returns true if the Event was recorded for the object
+// within eventDedupInterval, otherwise it is remembered as recorded now.
+func (nen *NamespaceEventNotifier) isRecentEvent(
+	obj runtime.Object,
+	reason,
+	message string,
+) bool {
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	key := fmt.Sprintf("%T/%s/%s", obj, objMeta.GetName(), reason)
+	now := time.Now()
+
+	nen.mutex.Lock()
+	defer nen.mutex.Unlock()
+	for k, ev := range nen.recentEvents {
+		if now.Sub(ev.time) >= eventDedupInterval {
+			delete(nen.recentEvents, k)
+		}
+	}
+	if ev, ok := nen.recentEvents[key]; ok && ev.message == message {
+		return true
+	}
+	nen.recentEvents[key] = recentEvent{message: message, time: now}
+	return false
+}
+
+// recordEvent records an Event for the Custom Resource in its namespace
+func (crMgr *CRManager) recordEvent(
+	obj runtime.Object,
+	namespace,
+	eventType,
+	reason,
+	message string,
+) {
+	evNotifier := crMgr.eventNotifier.createNotifierForNamespace(
+		namespace, crMgr.kubeClient.CoreV1())
+	// The Event is annotated with the sync ID of the messages logged while
+	// processing the resource
+	var annotations map[string]string
+	if syncID := log.ContextFields()[syncIDField]; syncID != "" {
+		annotations = map[string]string{SyncIDAnnotation: syncID}
+	}
+	evNotifier.recordEvent(obj, annotations, eventType, reason, message)
+	crMgr.recordVirtualServerWarning(obj, eventType, message)
+}
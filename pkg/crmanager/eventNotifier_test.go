@@ -0,0 +1,142 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
+)
+
+func NewFakeEventBroadcaster() record.EventBroadcaster {
+	return &FakeEventBroadcaster{}
+}
+
+func NewFakeEvent(
+	obj interface{},
+	eventType string,
+	reason string,
+	message string,
+) FakeEvent {
+
+	namespace := ""
+	name := ""
+	var annotations map[string]string
+
+	// Only the F5 custom resources are supported now, others added
+	// easily here.
+	switch obj.(type) {
+	case *cisapiv1.VirtualServer:
+		vs := obj.(*cisapiv1.VirtualServer)
+		namespace = vs.ObjectMeta.Namespace
+		name = vs.ObjectMeta.Name
+	case *cisapiv1.TLSProfile:
+		tls := obj.(*cisapiv1.TLSProfile)
+		namespace = tls.ObjectMeta.Namespace
+		name = tls.ObjectMeta.Name
+	case *cisapiv1.TransportServer:
+		ts := obj.(*cisapiv1.TransportServer)
+		namespace = ts.ObjectMeta.Namespace
+		name = ts.ObjectMeta.Name
+	case *cisapiv1.IngressLink:
+		il := obj.(*cisapiv1.IngressLink)
+		namespace = il.ObjectMeta.Namespace
+		name = il.ObjectMeta.Name
+	case *cisapiv1.ExternalDNS:
+		edns := obj.(*cisapiv1.ExternalDNS)
+		namespace = edns.ObjectMeta.Namespace
+		name = edns.ObjectMeta.Name
+	case *v1.Secret:
+		secret := obj.(*v1.Secret)
+		namespace = secret.ObjectMeta.Namespace
+		name = secret.ObjectMeta.Name
+	default:
+		// Set namespace and name to the error message
+		namespace = fmt.Sprintf("NewFakeEvent: Unhandled object type: %T\n", obj)
+		name = namespace
+	}
+
+	return FakeEvent{
+		Namespace:   namespace,
+		Name:        name,
+		EventType:   eventType,
+		Reason:      reason,
+		Message:     message,
+		Annotations: annotations,
+	}
+}
+
+type FakeEventBroadcaster struct {
+	EventRecorder FakeEventRecorder
+}
+
+type FakeEventRecorder struct {
+	FEvent []FakeEvent
+}
+
+type FakeEvent struct {
+	Namespace   string
+	Name        string
+	EventType   string
+	Reason      string
+	Message     string
+	Annotations map[string]string
+}
+
+// record.EventBroadcaster interface methods
+func (feb *FakeEventBroadcaster) StartEventWatcher(eventHandler func(*v1.Event)) watch.Interface {
+	return nil
+}
+
+func (feb *FakeEventBroadcaster) StartRecordingToSink(sink record.EventSink) watch.Interface {
+	return nil
+}
+
+func (feb *FakeEventBroadcaster) StartLogging(logf func(format string, args ...interface{})) watch.Interface {
+	return nil
+}
+
+func (feb *FakeEventBroadcaster) NewRecorder(scheme *runtime.Scheme, source v1.EventSource) record.EventRecorder {
+	return &feb.EventRecorder
+}
+
+// record.EventRecorder interface methods
+func (fer *FakeEventRecorder) Event(obj runtime.Object, eventType, reason, message string) {
+	ev := NewFakeEvent(obj, eventType, reason, message)
+	fer.FEvent = append(fer.FEvent, ev)
+}
+
+func (fer *FakeEventRecorder) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	ev := NewFakeEvent(obj, eventType, reason, fmt.Sprintf(messageFmt, args...))
+	fer.FEvent = append(fer.FEvent, ev)
+}
+
+func (fer *FakeEventRecorder) PastEventf(obj runtime.Object, timestamp metav1.Time, eventType, reason, messageFmt string, args ...interface{}) {
+	ev := NewFakeEvent(obj, eventType, reason, fmt.Sprintf(messageFmt, args...)+" @ "+timestamp.String())
+	fer.FEvent = append(fer.FEvent, ev)
+}
+
+func (fer *FakeEventRecorder) AnnotatedEventf(obj runtime.Object, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	ev := NewFakeEvent(obj, eventType, reason, fmt.Sprintf(messageFmt, args...))
+	ev.Annotations = annotations
+	fer.FEvent = append(fer.FEvent, ev)
+}
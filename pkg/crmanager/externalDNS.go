@@ -0,0 +1,214 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"sort"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultDNSRecordType is the record type of the Wide-IPs and pools
+	// without dnsRecordType
+	DefaultDNSRecordType = "A"
+	// DefaultDNSLBMethod is the load balancing method of the Wide-IPs and
+	// pools without loadBalanceMethod
+	DefaultDNSLBMethod = "round-robin"
+)
+
+// ednsKey returns the key of the ExternalDNS, namespace/name
+func ednsKey(edns *cisapiv1.ExternalDNS) string {
+	return edns.ObjectMeta.Namespace + "/" + edns.ObjectMeta.Name
+}
+
+// syncExternalDNS creates the Wide-IP of the ExternalDNS with the virtuals
+// of the VirtualServers having the domain name as host. The ExternalDNS is
+// pending until such a VirtualServer is configured.
+func (crMgr *CRManager) syncExternalDNS(edns *cisapiv1.ExternalDNS) {
+	if err := validateExternalDNS(edns); err != nil {
+		log.Errorf("ExternalDNS %s rejected: %v", ednsKey(edns), err)
+		crMgr.recordEvent(edns, edns.ObjectMeta.Namespace,
+			v1.EventTypeWarning, "InvalidData", err.Error())
+		crMgr.deleteExternalDNS(edns)
+		return
+	}
+	if nil == crMgr.resources.dnsConfig {
+		crMgr.resources.dnsConfig = make(DNSConfig)
+	}
+
+	virtuals := crMgr.getVirtualsForHost(edns.Spec.DomainName)
+	if len(virtuals) == 0 {
+		log.Infof("ExternalDNS %s is pending, no VirtualServer with host %s",
+			ednsKey(edns), edns.Spec.DomainName)
+		delete(crMgr.resources.dnsConfig, ednsKey(edns))
+		return
+	}
+
+	wideIP := WideIP{
+		DomainName: edns.Spec.DomainName,
+		RecordType: edns.Spec.DNSRecordType,
+		LBMethod:   edns.Spec.LoadBalanceMethod,
+	}
+	if wideIP.RecordType == "" {
+		wideIP.RecordType = DefaultDNSRecordType
+	}
+	if wideIP.LBMethod == "" {
+		wideIP.LBMethod = DefaultDNSLBMethod
+	}
+	for _, pl := range edns.Spec.Pools {
+		pool := GSLBPool{
+			Name:       pl.Name,
+			RecordType: pl.DNSRecordType,
+			LBMethod:   pl.LoadBalanceMethod,
+			DataServer: pl.DataServerName,
+			Members:    virtuals,
+		}
+		if pool.RecordType == "" {
+			pool.RecordType = wideIP.RecordType
+		}
+		if pool.LBMethod == "" {
+			pool.LBMethod = DefaultDNSLBMethod
+		}
+		for i, mon := range pl.Monitors {
+			pool.Monitors = append(pool.Monitors, Monitor{
+				Name:     fmt.Sprintf("%s_monitor_%d", pl.Name, i),
+				Type:     mon.Type,
+				Send:     mon.Send,
+				Recv:     mon.Recv,
+				Interval: mon.Interval,
+				Timeout:  mon.Timeout,
+			})
+		}
+		wideIP.Pools = append(wideIP.Pools, pool)
+	}
+	crMgr.resources.dnsConfig[ednsKey(edns)] = wideIP
+}
+
+// deleteExternalDNS removes the Wide-IP of the ExternalDNS, the virtuals
+// are not changed.
+func (crMgr *CRManager) deleteExternalDNS(edns *cisapiv1.ExternalDNS) {
+	delete(crMgr.resources.dnsConfig, ednsKey(edns))
+}
+
+// getVirtualsForHost returns the names of the virtuals configured for the
+// VirtualServers with the host.
+func (crMgr *CRManager) getVirtualsForHost(host string) []string {
+	var virtuals []string
+	for _, rsCfg := range crMgr.resources.GetAllResources() {
+		if rsCfg.MetaData.ResourceType != VirtualServer {
+			continue
+		}
+		for _, owner := range rsCfg.MetaData.owners {
+			vs, found := crMgr.getVirtualServer(owner)
+			if found && hasVirtualServerHost(vs, host) {
+				virtuals = append(virtuals, rsCfg.Virtual.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(virtuals)
+	return virtuals
+}
+
+// enqueueExternalDNSForVirtualServer enqueues the ExternalDNS resources
+// with a host of the VirtualServer as domain name, or with Wide-IPs
+// using the virtuals of the VirtualServer.
+func (crMgr *CRManager) enqueueExternalDNSForVirtualServer(
+	vs *cisapiv1.VirtualServer,
+) {
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.ednsInformer.GetIndexer().List() {
+			edns := obj.(*cisapiv1.ExternalDNS)
+			if hasVirtualServerHost(vs, edns.Spec.DomainName) ||
+				crMgr.wideIPUsesVirtualServer(ednsKey(edns), vs) {
+				crMgr.enqueueExternalDNS(edns)
+			}
+		}
+	}
+}
+
+// wideIPUsesVirtualServer returns true if the Wide-IP has a virtual of
+// the VirtualServer.
+func (crMgr *CRManager) wideIPUsesVirtualServer(
+	key string,
+	vs *cisapiv1.VirtualServer,
+) bool {
+	wideIP, ok := crMgr.resources.dnsConfig[key]
+	if !ok {
+		return false
+	}
+	for _, portStruct := range crMgr.virtualPorts(vs) {
+		rsName := crMgr.getVirtualServerName(vs, portStruct.port)
+		for _, pool := range wideIP.Pools {
+			if containsString(pool.Members, rsName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateExternalDNS returns an error if the spec of the ExternalDNS can
+// not be configured on BIG-IP DNS
+func validateExternalDNS(edns *cisapiv1.ExternalDNS) error {
+	if edns.Spec.DomainName == "" {
+		return fmt.Errorf("The domainName is not provided")
+	}
+	if len(edns.Spec.Pools) == 0 {
+		return fmt.Errorf("No pools are provided")
+	}
+	for _, pool := range edns.Spec.Pools {
+		if pool.Name == "" {
+			return fmt.Errorf("The name of a pool is not provided")
+		}
+		if !isBigIPPath(pool.DataServerName) {
+			return fmt.Errorf("Invalid dataServerName '%s' of pool %s, it "+
+				"must be a path like /Common/bigip", pool.DataServerName,
+				pool.Name)
+		}
+		for _, mon := range pool.Monitors {
+			if mon.Type == "" {
+				return fmt.Errorf("The type of a monitor of pool %s is "+
+					"not provided", pool.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// copyDNSConfig returns a deep copy of the DNSConfig.
+func (dc DNSConfig) copyDNSConfig() DNSConfig {
+	if nil == dc {
+		return nil
+	}
+	dnsConfig := make(DNSConfig)
+	for key, wideIP := range dc {
+		pools := make([]GSLBPool, len(wideIP.Pools))
+		for i, pool := range wideIP.Pools {
+			pools[i] = pool
+			pools[i].Members = append([]string{}, pool.Members...)
+			pools[i].Monitors = append([]Monitor{}, pool.Monitors...)
+		}
+		wideIP.Pools = pools
+		dnsConfig[key] = wideIP
+	}
+	return dnsConfig
+}
@@ -0,0 +1,154 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("ExternalDNS", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+	var edns *cisapiv1.ExternalDNS
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.addService(test.NewService("svc1", "1", "default",
+			v1.ServiceTypeClusterIP, nil))
+		vs = test.NewVirtualServer(
+			"SampleVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host:                 "test.com",
+				VirtualServerAddress: "1.2.3.4",
+				Pools: []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				},
+			},
+		)
+		edns = test.NewExternalDNS(
+			"SampleEDNS",
+			"default",
+			cisapiv1.ExternalDNSSpec{
+				DomainName: "test.com",
+				Pools: []cisapiv1.DNSPool{{
+					Name:           "test.com_pool",
+					DataServerName: "/Common/bigip",
+					Monitors: []cisapiv1.DNSMonitor{
+						{Type: "http", Send: "GET /", Interval: 10},
+					},
+				}},
+			},
+		)
+		mockCRM.addExternalDNS(edns)
+	})
+
+	It("Holds ExternalDNS pending until a VirtualServer has the host", func() {
+		mockCRM.syncExternalDNS(edns)
+		Expect(mockCRM.resources.dnsConfig).To(BeEmpty())
+
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		mockCRM.enqueueExternalDNSForVirtualServer(vs)
+		keys := mockCRM.drainQueue()
+		Expect(len(keys)).To(Equal(1))
+		Expect(keys[0].kind).To(Equal(ExternalDNS))
+
+		mockCRM.syncExternalDNS(edns)
+		wideIP, ok := mockCRM.resources.dnsConfig["default/SampleEDNS"]
+		Expect(ok).To(BeTrue())
+		Expect(wideIP.RecordType).To(Equal(DefaultDNSRecordType))
+		Expect(wideIP.LBMethod).To(Equal(DefaultDNSLBMethod))
+		Expect(len(wideIP.Pools)).To(Equal(1))
+		Expect(wideIP.Pools[0].Members).To(Equal([]string{
+			mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)}))
+		Expect(wideIP.Pools[0].Monitors[0].Type).To(Equal("http"))
+	})
+
+	It("Removes only the Wide-IP", func() {
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		mockCRM.syncExternalDNS(edns)
+		Expect(len(mockCRM.resources.dnsConfig)).To(Equal(1))
+
+		mockCRM.deleteExternalDNS(edns)
+		Expect(mockCRM.resources.dnsConfig).To(BeEmpty())
+		Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+
+		// VirtualServer deleted
+		mockCRM.syncExternalDNS(edns)
+		crInf, _ := mockCRM.getNamespaceInformer("default")
+		_ = crInf.vsInformer.GetIndexer().Delete(vs)
+		mockCRM.deleteVirtualServerConfig(vs)
+		mockCRM.enqueueExternalDNSForVirtualServer(vs)
+		Expect(len(mockCRM.drainQueue())).To(Equal(1))
+		mockCRM.syncExternalDNS(edns)
+		Expect(mockCRM.resources.dnsConfig).To(BeEmpty())
+	})
+
+	It("Rejects ExternalDNS without data server", func() {
+		edns.Spec.Pools[0].DataServerName = ""
+		mockCRM.syncExternalDNS(edns)
+		Expect(mockCRM.resources.dnsConfig).To(BeEmpty())
+		events := mockCRM.getFakeEvents("default")
+		Expect(len(events)).To(Equal(1))
+		Expect(events[0].Reason).To(Equal("InvalidData"))
+	})
+
+	It("Creates the GSLB objects in the Common tenant", func() {
+		oldPartition := DEFAULT_PARTITION
+		DEFAULT_PARTITION = "test"
+		defer func() { DEFAULT_PARTITION = oldPartition }()
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		mockCRM.syncExternalDNS(edns)
+
+		adc := createAS3ADC(ResourceConfigWrapper{
+			rsCfgs:         mockCRM.resources.GetAllResources(),
+			iRuleMap:       mockCRM.irulesMap,
+			intDgMap:       mockCRM.intDgMap,
+			customProfiles: mockCRM.customProfiles,
+			dnsConfig:      mockCRM.resources.dnsConfig,
+		})
+		tenant, ok := adc["Common"].(as3Tenant)
+		Expect(ok).To(BeTrue())
+		app := tenant[as3SharedApplication].(as3Application)
+		domain := app["test_com"].(*as3GSLBDomain)
+		Expect(domain.DomainName).To(Equal("test.com"))
+		Expect(domain.Pools).To(Equal(
+			[]as3ResourcePointer{{Use: "test_com_pool"}}))
+		pool := app["test_com_pool"].(*as3GSLBPool)
+		Expect(pool.Members).To(Equal([]as3GSLBPoolMember{{
+			Server:        as3ResourcePointer{BigIP: "/Common/bigip"},
+			VirtualServer: "/test/Shared/" + mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT),
+		}}))
+		Expect(pool.Monitors).To(Equal(
+			[]as3ResourcePointer{{Use: "test_com_pool_monitor_0"}}))
+
+		// Not posted without ExternalDNS
+		adc = createAS3ADC(ResourceConfigWrapper{
+			customProfiles: mockCRM.customProfiles,
+		})
+		_, ok = adc["Common"]
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,223 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CleanupFinalizer keeps the VirtualServers and TLSProfiles deleted until
+// their objects are removed from BIG-IP
+const CleanupFinalizer = "cis.f5.com/cleanup"
+
+// hasFinalizer tells whether the finalizers include CleanupFinalizer
+func hasFinalizer(meta metav1.ObjectMeta) bool {
+	for _, f := range meta.Finalizers {
+		if f == CleanupFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutFinalizer returns the finalizers other than CleanupFinalizer
+func withoutFinalizer(finalizers []string) []string {
+	var out []string
+	for _, f := range finalizers {
+		if f != CleanupFinalizer {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// addFinalizers places the finalizer on the VirtualServer and the
+// TLSProfiles it uses. The resources failing to update get it when the
+// VirtualServer is processed again.
+func (crMgr *CRManager) addFinalizers(vs *cisapiv1.VirtualServer) {
+	if !crMgr.UseFinalizers || nil != vs.ObjectMeta.DeletionTimestamp {
+		return
+	}
+	if !hasFinalizer(vs.ObjectMeta) {
+		vs = vs.DeepCopy()
+		vs.ObjectMeta.Finalizers = append(vs.ObjectMeta.Finalizers,
+			CleanupFinalizer)
+		_, err := crMgr.kubeCRClient.K8sV1().VirtualServers(
+			vs.ObjectMeta.Namespace).Update(vs)
+		if err != nil {
+			log.Warningf("Failed to add the finalizer to VirtualServer %s/%s: %v",
+				vs.ObjectMeta.Namespace, vs.ObjectMeta.Name, err)
+		}
+	}
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	for _, tlsName := range getTLSProfileNames(vs) {
+		obj, found, _ := crInf.tsInformer.GetIndexer().GetByKey(
+			vs.ObjectMeta.Namespace + "/" + tlsName)
+		if !found {
+			continue
+		}
+		tls := obj.(*cisapiv1.TLSProfile)
+		if hasFinalizer(tls.ObjectMeta) ||
+			nil != tls.ObjectMeta.DeletionTimestamp {
+			continue
+		}
+		tls = tls.DeepCopy()
+		tls.ObjectMeta.Finalizers = append(tls.ObjectMeta.Finalizers,
+			CleanupFinalizer)
+		_, err := crMgr.kubeCRClient.K8sV1().TLSProfiles(
+			tls.ObjectMeta.Namespace).Update(tls)
+		if err != nil {
+			log.Warningf("Failed to add the finalizer to TLSProfile %s/%s: %v",
+				tls.ObjectMeta.Namespace, tls.ObjectMeta.Name, err)
+		}
+	}
+}
+
+// resourceDeleted records the deleted resource to remove its finalizer once
+// the configuration without it is posted. The finalizers placed before are
+// removed even if the finalizers are disabled since. The caller holds
+// processingMutex exclusively.
+func (crMgr *CRManager) resourceDeleted(kind string, meta metav1.ObjectMeta) {
+	if !hasFinalizer(meta) {
+		return
+	}
+	crMgr.deletedResources = append(crMgr.deletedResources, finalizerKey{
+		kind:      kind,
+		namespace: meta.Namespace,
+		name:      meta.Name,
+	})
+}
+
+// queueFinalizers queues the finalizers of the resources deleted, to be
+// removed once the declaration last written is posted. The caller holds
+// processingMutex.
+func (crMgr *CRManager) queueFinalizers() {
+	if len(crMgr.deletedResources) == 0 {
+		return
+	}
+	seq := crMgr.Agent.DeclWriter.Written()
+	crMgr.finalizerMutex.Lock()
+	for _, key := range crMgr.deletedResources {
+		crMgr.pendingFinalizers[key] = seq
+		crMgr.finalizerQueue.Add(key)
+	}
+	crMgr.finalizerMutex.Unlock()
+	crMgr.deletedResources = nil
+}
+
+// dropFinalizers forgets the finalizers to be removed, the resources
+// deleted are processed again by the next leader.
+func (crMgr *CRManager) dropFinalizers() {
+	crMgr.finalizerMutex.Lock()
+	defer crMgr.finalizerMutex.Unlock()
+	crMgr.pendingFinalizers = make(map[finalizerKey]uint64)
+}
+
+// finalizerWorker removes the finalizers of the resources in
+// finalizerQueue.
+func (crMgr *CRManager) finalizerWorker() {
+	for crMgr.processFinalizer() {
+	}
+}
+
+// processFinalizer removes the finalizer of a deleted resource from the
+// finalizerQueue once the declaration without it is posted, checked again
+// with backoff until then. Failed updates are retried with backoff.
+func (crMgr *CRManager) processFinalizer() bool {
+	key, quit := crMgr.finalizerQueue.Get()
+	if quit {
+		return false
+	}
+	defer crMgr.finalizerQueue.Done(key)
+	fKey := key.(finalizerKey)
+
+	crMgr.finalizerMutex.Lock()
+	seq, found := crMgr.pendingFinalizers[fKey]
+	crMgr.finalizerMutex.Unlock()
+	if !found || !crMgr.isLeader() {
+		crMgr.finalizerQueue.Forget(key)
+		return true
+	}
+	if crMgr.Agent.DeclWriter.Posted() < seq {
+		log.Debugf("Waiting for the declaration to be posted to remove "+
+			"the finalizer of %s %s/%s", fKey.kind, fKey.namespace, fKey.name)
+		crMgr.finalizerQueue.AddRateLimited(key)
+		return true
+	}
+	if err := crMgr.removeFinalizer(fKey); err != nil {
+		log.Warningf("Failed to remove the finalizer of %s %s/%s: %v",
+			fKey.kind, fKey.namespace, fKey.name, err)
+		crMgr.finalizerQueue.AddRateLimited(key)
+		return true
+	}
+	log.Debugf("Removed the finalizer of %s %s/%s", fKey.kind,
+		fKey.namespace, fKey.name)
+	crMgr.finalizerMutex.Lock()
+	// The resource may be recreated and deleted again meanwhile
+	if crMgr.pendingFinalizers[fKey] == seq {
+		delete(crMgr.pendingFinalizers, fKey)
+	}
+	crMgr.finalizerMutex.Unlock()
+	crMgr.finalizerQueue.Forget(key)
+	return true
+}
+
+// removeFinalizer removes the finalizer from the resource on the API server
+func (crMgr *CRManager) removeFinalizer(key finalizerKey) error {
+	var err error
+	switch key.kind {
+	case VirtualServer:
+		client := crMgr.kubeCRClient.K8sV1().VirtualServers(key.namespace)
+		var vs *cisapiv1.VirtualServer
+		vs, err = client.Get(key.name, metav1.GetOptions{})
+		if err == nil {
+			// A VirtualServer recreated with the same name keeps it
+			if nil == vs.ObjectMeta.DeletionTimestamp ||
+				!hasFinalizer(vs.ObjectMeta) {
+				return nil
+			}
+			vs.ObjectMeta.Finalizers = withoutFinalizer(vs.ObjectMeta.Finalizers)
+			_, err = client.Update(vs)
+		}
+	case TLSProfile:
+		client := crMgr.kubeCRClient.K8sV1().TLSProfiles(key.namespace)
+		var tls *cisapiv1.TLSProfile
+		tls, err = client.Get(key.name, metav1.GetOptions{})
+		if err == nil {
+			if nil == tls.ObjectMeta.DeletionTimestamp ||
+				!hasFinalizer(tls.ObjectMeta) {
+				return nil
+			}
+			tls.ObjectMeta.Finalizers = withoutFinalizer(tls.ObjectMeta.Finalizers)
+			_, err = client.Update(tls)
+		}
+	default:
+		return fmt.Errorf("Unknown resource Kind: %v", key.kind)
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
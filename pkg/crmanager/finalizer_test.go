@@ -0,0 +1,163 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("Finalizer Tests", func() {
+	var mockCRM *mockCRManager
+	var postMgr *PostManager
+	var vs *cisapiv1.VirtualServer
+	var tls *cisapiv1.TLSProfile
+
+	// process processes the resources queued and replaces rscQueue, shut
+	// down by processWithWorkers
+	process := func() {
+		mockCRM.processWithWorkers(1)
+		mockCRM.rscQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller")
+	}
+
+	getVirtualServer := func() *cisapiv1.VirtualServer {
+		vs, err := mockCRM.kubeCRClient.K8sV1().VirtualServers("default").Get(
+			"vs0", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return vs
+	}
+
+	getTLSProfile := func() *cisapiv1.TLSProfile {
+		tls, err := mockCRM.kubeCRClient.K8sV1().TLSProfiles("default").Get(
+			"vs0", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		return tls
+	}
+
+	// deleteVirtualServer marks the VirtualServer deleted, kept by its
+	// finalizer, and queues it as its update event would
+	deleteVirtualServer := func() {
+		vs = getVirtualServer()
+		now := metav1.Now()
+		vs.ObjectMeta.DeletionTimestamp = &now
+		_, err := mockCRM.kubeCRClient.K8sV1().VirtualServers("default").Update(vs)
+		Expect(err).NotTo(HaveOccurred())
+		mockCRM.addVirtualServer(vs)
+		mockCRM.enqueueVirtualServer(vs)
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.UseFinalizers = true
+		postMgr = &PostManager{postChan: make(chan config, 1)}
+		mockCRM.Agent = &Agent{DeclWriter: postMgr}
+		vs = mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+		crInf, _ := mockCRM.getNamespaceInformer("default")
+		obj, _, _ := crInf.tsInformer.GetIndexer().GetByKey("default/vs0")
+		tls = obj.(*cisapiv1.TLSProfile)
+		_, err := mockCRM.kubeCRClient.K8sV1().VirtualServers("default").Create(vs)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = mockCRM.kubeCRClient.K8sV1().TLSProfiles("default").Create(tls)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Places the finalizer on the VirtualServer and its TLSProfile", func() {
+		process()
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(
+			ConsistOf(CleanupFinalizer))
+		Expect(getTLSProfile().ObjectMeta.Finalizers).To(
+			ConsistOf(CleanupFinalizer))
+	})
+
+	It("Does not place the finalizer when disabled", func() {
+		mockCRM.UseFinalizers = false
+		process()
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(BeEmpty())
+		Expect(getTLSProfile().ObjectMeta.Finalizers).To(BeEmpty())
+	})
+
+	It("Removes the finalizer once the declaration is posted", func() {
+		process()
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postMgr.postChan).To(Receive(&cfg))
+		postMgr.setPosted(cfg)
+
+		deleteVirtualServer()
+		process()
+		Expect(mockCRM.resources.getVirtuals(VirtualServer, "default/vs0")).To(
+			BeEmpty(), "The VirtualServer being deleted should be removed")
+		mockCRM.flushConfig()
+		Expect(postMgr.postChan).To(Receive(&cfg))
+		Expect(cfg.data).NotTo(ContainSubstring(
+			formatVirtualServerName("10.1.1.1", 443)))
+
+		// The finalizer is kept until the declaration is posted
+		Expect(mockCRM.processFinalizer()).To(BeTrue())
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(
+			ConsistOf(CleanupFinalizer))
+
+		postMgr.setPosted(cfg)
+		Expect(mockCRM.processFinalizer()).To(BeTrue())
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(BeEmpty())
+		Expect(mockCRM.pendingFinalizers).To(BeEmpty())
+	})
+
+	It("Keeps the finalizer of the VirtualServer recreated", func() {
+		process()
+		mockCRM.flushConfig()
+		deleteVirtualServer()
+		process()
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postMgr.postChan).To(Receive(&cfg))
+
+		// The VirtualServer is recreated before the declaration is posted
+		Expect(mockCRM.kubeCRClient.K8sV1().VirtualServers("default").Delete(
+			"vs0", nil)).To(Succeed())
+		vs.ObjectMeta.DeletionTimestamp = nil
+		vs.ObjectMeta.ResourceVersion = ""
+		_, err := mockCRM.kubeCRClient.K8sV1().VirtualServers("default").Create(vs)
+		Expect(err).NotTo(HaveOccurred())
+
+		postMgr.setPosted(cfg)
+		Expect(mockCRM.processFinalizer()).To(BeTrue())
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(
+			ConsistOf(CleanupFinalizer))
+	})
+
+	It("Drops the finalizers to be removed when the leadership is lost", func() {
+		mockCRM.LeaderElection = true
+		mockCRM.leading = 1
+		process()
+		mockCRM.flushConfig()
+		deleteVirtualServer()
+		process()
+		mockCRM.flushConfig()
+		Expect(mockCRM.pendingFinalizers).To(HaveLen(1))
+
+		mockCRM.stopLeading()
+		Expect(mockCRM.pendingFinalizers).To(BeEmpty())
+		Expect(mockCRM.processFinalizer()).To(BeTrue())
+		Expect(getVirtualServer().ObjectMeta.Finalizers).To(
+			ConsistOf(CleanupFinalizer))
+	})
+})
@@ -0,0 +1,87 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// handleReady serves the readiness of the controller, 200 once ready and
+// 503 with the reason otherwise
+func (crMgr *CRManager) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := crMgr.readiness(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Ok"))
+}
+
+// readiness returns why the controller is not ready: it is shutting down,
+// the informer caches are not synced, or no declaration was posted to
+// BIG-IP successfully while one is needed. The standby controllers post
+// no declarations.
+func (crMgr *CRManager) readiness() error {
+	if crMgr.Agent.Draining() {
+		return fmt.Errorf("draining")
+	}
+	if !crMgr.cachesSynced() {
+		return fmt.Errorf("informer caches not synced")
+	}
+	declWriter := crMgr.Agent.DeclWriter
+	if !crMgr.isLeader() || nil == declWriter || declWriter.Posted() > 0 {
+		return nil
+	}
+	crMgr.processingMutex.Lock()
+	pending := crMgr.configDirty || !crMgr.isDrained()
+	crMgr.processingMutex.Unlock()
+	if declWriter.Written() == 0 && !pending {
+		// Nothing to post
+		return nil
+	}
+	if nil != crMgr.Agent.PostManager {
+		if _, failure := crMgr.Agent.PostManager.LastPosts(); !failure.IsZero() {
+			return fmt.Errorf("no declaration posted to BIG-IP, last "+
+				"failure at %v", failure.Format(time.RFC3339))
+		}
+	}
+	return fmt.Errorf("no declaration posted to BIG-IP")
+}
+
+// markProgress records that a worker got or processed a resource
+func (crMgr *CRManager) markProgress() {
+	atomic.StoreInt64(&crMgr.lastProgress, time.Now().UnixNano())
+}
+
+// checkProgress fails when resources are pending and none was got or
+// processed by the workers for StallTimeout, the processing
+// being wedged.
+func (crMgr *CRManager) checkProgress() error {
+	last := atomic.LoadInt64(&crMgr.lastProgress)
+	if crMgr.StallTimeout <= 0 || last == 0 || crMgr.isDrained() {
+		return nil
+	}
+	if stalled := time.Since(time.Unix(0, last)); stalled > crMgr.StallTimeout {
+		return fmt.Errorf("no resource processed for %v with %d queued "+
+			"and %d in flight", stalled.Round(time.Second),
+			crMgr.rscQueue.Len(), atomic.LoadInt32(&crMgr.inFlight))
+	}
+	return nil
+}
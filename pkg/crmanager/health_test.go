@@ -0,0 +1,111 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health Tests", func() {
+	var mockCRM *mockCRManager
+	var postMgr *PostManager
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		postMgr = &PostManager{postChan: make(chan config, 1)}
+		mockCRM.Agent = &Agent{PostManager: postMgr, DeclWriter: postMgr}
+	})
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		mockCRM.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code
+	}
+
+	It("Reports not ready until the informer caches are synced", func() {
+		Expect(mockCRM.readiness()).To(Succeed(), "No informers to sync")
+		Expect(mockCRM.addNamespacedInformer("default")).To(Succeed())
+		Expect(mockCRM.readiness()).To(MatchError("informer caches not synced"))
+		Expect(ready()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("Reports ready once a declaration is posted", func() {
+		Expect(mockCRM.readiness()).To(Succeed(), "Nothing should be posted")
+		Expect(ready()).To(Equal(http.StatusOK))
+
+		mockCRM.configDirty = true
+		Expect(mockCRM.readiness()).To(MatchError(
+			"no declaration posted to BIG-IP"))
+		mockCRM.configDirty = false
+		postMgr.Write("{}", nil)
+		Expect(mockCRM.readiness()).To(MatchError(
+			"no declaration posted to BIG-IP"))
+		postMgr.observePost(time.Now(), false)
+		Expect(mockCRM.readiness()).To(MatchError(
+			HavePrefix("no declaration posted to BIG-IP, last failure at")))
+		Expect(ready()).To(Equal(http.StatusServiceUnavailable))
+
+		var cfg config
+		Expect(postMgr.postChan).To(Receive(&cfg))
+		postMgr.setPosted(cfg)
+		postMgr.observePost(time.Now(), true)
+		Expect(mockCRM.readiness()).To(Succeed())
+		success, failure := postMgr.LastPosts()
+		Expect(success).To(BeTemporally(">=", failure))
+
+		mockCRM.Agent.Drain()
+		Expect(mockCRM.readiness()).To(MatchError("draining"))
+	})
+
+	It("Reports the standby controllers ready", func() {
+		mockCRM.LeaderElection = true
+		mockCRM.configDirty = true
+		Expect(mockCRM.readiness()).To(Succeed())
+	})
+
+	It("Fails the liveness check when the processing is stalled", func() {
+		mockCRM.StallTimeout = time.Minute
+		mockCRM.Agent.AddLivenessCheck(mockCRM.checkProgress)
+		mockCRM.rscQueue.Add(resyncKey)
+		Expect(mockCRM.Agent.checkLiveness()).To(Succeed(),
+			"The workers should not be stalled before starting")
+
+		mockCRM.markProgress()
+		Expect(mockCRM.Agent.checkLiveness()).To(Succeed())
+		atomic.StoreInt64(&mockCRM.lastProgress,
+			time.Now().Add(-2*time.Minute).UnixNano())
+		Expect(mockCRM.Agent.checkLiveness()).To(MatchError(
+			ContainSubstring("with 1 queued and 0 in flight")))
+
+		mockCRM.StallTimeout = 0
+		Expect(mockCRM.Agent.checkLiveness()).To(Succeed(), "Disabled")
+		mockCRM.StallTimeout = time.Minute
+
+		mockCRM.processWithWorkers(1)
+		Expect(mockCRM.Agent.checkLiveness()).To(Succeed())
+		atomic.StoreInt64(&mockCRM.lastProgress,
+			time.Now().Add(-2*time.Minute).UnixNano())
+		Expect(mockCRM.Agent.checkLiveness()).To(Succeed(),
+			"Nothing should be pending")
+	})
+})
@@ -0,0 +1,267 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// hostGroupIPAMKey returns the IPAM key of the host group. The namespaces
+// have no colon, so it is not the key of a VirtualServer.
+func hostGroupIPAMKey(group string) string {
+	return "hostGroup:" + group + "/"
+}
+
+// hostGroupDependency returns the dependency of the members of the host group
+func hostGroupDependency(group string) ObjectDependency {
+	return ObjectDependency{Kind: HostGroup, Name: group}
+}
+
+// validateHostGroup returns an error if the name of the host group is not
+// a DNS label
+func validateHostGroup(group string) error {
+	if group == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(group); len(errs) > 0 {
+		return fmt.Errorf("Invalid hostGroup '%s': %s", group,
+			strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// getHostGroupAddress returns the address shared by the host group
+func (crMgr *CRManager) getHostGroupAddress(group string) string {
+	crMgr.hostGroupMutex.Lock()
+	defer crMgr.hostGroupMutex.Unlock()
+	return crMgr.hostGroups[group]
+}
+
+// getHostGroupMembers returns the VirtualServers of the host group not being
+// deleted, the oldest first.
+func (crMgr *CRManager) getHostGroupMembers(
+	group string,
+) []*cisapiv1.VirtualServer {
+	var members []*cisapiv1.VirtualServer
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.vsInformer.GetIndexer().List() {
+			vs := obj.(*cisapiv1.VirtualServer)
+			if vs.Spec.HostGroup == group &&
+				nil == vs.ObjectMeta.DeletionTimestamp {
+				members = append(members, vs)
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return isOlderVirtualServer(members[i], members[j])
+	})
+	return members
+}
+
+// updateHostGroups updates the address of the host group of the
+// VirtualServer, and of the host group it left as recorded in its
+// dependencies.
+func (crMgr *CRManager) updateHostGroups(vs *cisapiv1.VirtualServer) error {
+	groups := make(map[string]bool)
+	if vs.Spec.HostGroup != "" {
+		groups[vs.Spec.HostGroup] = true
+		// The address allocated before joining the group is not used
+		// anymore
+		if nil != crMgr.ipam {
+			if err := crMgr.ipam.Release(ipamKey(vs)); err != nil {
+				log.Errorf("Failed to release address of VirtualServer "+
+					"%s: %v", ipamKey(vs), err)
+			}
+		}
+	}
+	objKey, _ := NewObjectDependencies(vs)
+	crMgr.resources.RLock()
+	for dep := range crMgr.resources.objDeps[objKey] {
+		if dep.Kind == HostGroup {
+			groups[dep.Name] = true
+		}
+	}
+	crMgr.resources.RUnlock()
+	for group := range groups {
+		if err := crMgr.updateHostGroup(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateHostGroup sets the address of the host group to the
+// VirtualServerAddress of its oldest member with one, or else to the address
+// allocated by IPAM with the ipamLabel of its oldest member with one. The
+// group without members is removed and its address released.
+func (crMgr *CRManager) updateHostGroup(group string) error {
+	var address, label string
+	for _, member := range crMgr.getHostGroupMembers(group) {
+		if member.Spec.VirtualServerAddress != "" {
+			address = normalizeAddress(member.Spec.VirtualServerAddress)
+			break
+		}
+		if label == "" {
+			label = member.Spec.IPAMLabel
+		}
+	}
+	if nil != crMgr.ipam {
+		if address == "" && label != "" {
+			ip, err := crMgr.ipam.Allocate(hostGroupIPAMKey(group), label)
+			if err != nil {
+				return fmt.Errorf("Failed to allocate address for host "+
+					"group %s: %v", group, err)
+			}
+			address = ip
+		} else if err := crMgr.ipam.Release(
+			hostGroupIPAMKey(group)); err != nil {
+			log.Errorf("Failed to release address of host group %s: %v",
+				group, err)
+		}
+	}
+	crMgr.setHostGroupAddress(group, address)
+	return nil
+}
+
+// releaseHostGroup updates the host group of the deleted VirtualServer. The
+// next oldest member with an address owns the group, or else the group
+// keeps the address allocated by IPAM, and the group is removed with its
+// last member.
+func (crMgr *CRManager) releaseHostGroup(vs *cisapiv1.VirtualServer) {
+	if vs.Spec.HostGroup == "" {
+		return
+	}
+	if err := crMgr.updateHostGroup(vs.Spec.HostGroup); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// setHostGroupAddress records the address of the host group. When the
+// address changes, the members configured on the former address are removed
+// from it and all the members are processed again.
+func (crMgr *CRManager) setHostGroupAddress(group, address string) {
+	if crMgr.getHostGroupAddress(group) == address {
+		return
+	}
+	dep := hostGroupDependency(group)
+	var members []string
+	crMgr.resources.RLock()
+	for key, deps := range crMgr.resources.objDeps {
+		if _, ok := deps[dep]; ok && key.Kind == VirtualServer {
+			members = append(members, key.Namespace+"/"+key.Name)
+		}
+	}
+	crMgr.resources.RUnlock()
+	sort.Strings(members)
+	for _, vsKey := range members {
+		// The members with their own address stay on it
+		vs, ok := crMgr.getAdmittedVirtualServer(vsKey)
+		if ok && vs.Spec.VirtualServerAddress == "" {
+			crMgr.deleteVirtualServerConfig(vs)
+		}
+	}
+
+	crMgr.hostGroupMutex.Lock()
+	if address == "" {
+		log.Infof("Host group %s has no address", group)
+		delete(crMgr.hostGroups, group)
+	} else {
+		log.Infof("Host group %s uses address %s", group, address)
+		crMgr.hostGroups[group] = address
+	}
+	crMgr.hostGroupMutex.Unlock()
+
+	if crMgr.initState {
+		return
+	}
+	for _, vs := range crMgr.getHostGroupMembers(group) {
+		log.Debugf("Enqueueing VirtualServer %s/%s of host group %s",
+			vs.ObjectMeta.Namespace, vs.ObjectMeta.Name, group)
+		crMgr.enqueueVirtualServer(vs)
+	}
+}
+
+// checkHostGroupMember returns an error if the host group of the
+// VirtualServer has no address, or if the VirtualServer declares an address,
+// an ipamLabel or a TLS termination conflicting with an older member.
+func (crMgr *CRManager) checkHostGroupMember(vs *cisapiv1.VirtualServer) error {
+	group := vs.Spec.HostGroup
+	if group == "" {
+		return nil
+	}
+	if crMgr.getHostGroupAddress(group) == "" {
+		return fmt.Errorf("Host group %s has no address, set the "+
+			"virtualServerAddress or ipamLabel of a member", group)
+	}
+	termination := crMgr.getTLSTermination(vs)
+	for _, member := range crMgr.getHostGroupMembers(group) {
+		if !isOlderVirtualServer(member, vs) {
+			break
+		}
+		memberKey := member.ObjectMeta.Namespace + "/" + member.ObjectMeta.Name
+		if vs.Spec.VirtualServerAddress != "" &&
+			member.Spec.VirtualServerAddress != "" &&
+			normalizeAddress(vs.Spec.VirtualServerAddress) !=
+				normalizeAddress(member.Spec.VirtualServerAddress) {
+			return fmt.Errorf("virtualServerAddress %s conflicts with "+
+				"address %s of VirtualServer %s in host group %s",
+				vs.Spec.VirtualServerAddress,
+				member.Spec.VirtualServerAddress, memberKey, group)
+		}
+		if vs.Spec.VirtualServerAddress == "" &&
+			member.Spec.VirtualServerAddress == "" &&
+			vs.Spec.IPAMLabel != "" && member.Spec.IPAMLabel != "" &&
+			vs.Spec.IPAMLabel != member.Spec.IPAMLabel {
+			return fmt.Errorf("ipamLabel %s conflicts with ipamLabel %s of "+
+				"VirtualServer %s in host group %s", vs.Spec.IPAMLabel,
+				member.Spec.IPAMLabel, memberKey, group)
+		}
+		// The passthrough virtual has no client SSL profile
+		memberTermination := crMgr.getTLSTermination(member)
+		if termination != "" && memberTermination != "" &&
+			(termination == TLSPassthrough) !=
+				(memberTermination == TLSPassthrough) {
+			return fmt.Errorf("TLS termination %s conflicts with "+
+				"termination %s of VirtualServer %s in host group %s",
+				termination, memberTermination, memberKey, group)
+		}
+	}
+	return nil
+}
+
+// getTLSTermination returns the termination of the first TLSProfile of the
+// VirtualServer found, empty without TLSProfile.
+func (crMgr *CRManager) getTLSTermination(vs *cisapiv1.VirtualServer) string {
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok {
+		return ""
+	}
+	for _, name := range getTLSProfileNames(vs) {
+		tls, found := crMgr.getTLSProfile(crInf,
+			vs.ObjectMeta.Namespace+"/"+name)
+		if found {
+			return tls.Spec.TLS.Termination
+		}
+	}
+	return ""
+}
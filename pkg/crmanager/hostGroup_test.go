@@ -0,0 +1,196 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sort"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Host Group Tests", func() {
+	var mockCRM *mockCRManager
+	var newVS func(name, address string, created int) *cisapiv1.VirtualServer
+
+	// owners returns the VirtualServers configured on the HTTP virtual of
+	// the address
+	owners := func(address string) []string {
+		rsCfg, ok := mockCRM.resources.GetByName("test",
+			formatVirtualServerName(address, DEFAULT_HTTP_PORT))
+		if !ok {
+			return nil
+		}
+		names := append([]string{}, rsCfg.MetaData.owners...)
+		sort.Strings(names)
+		return names
+	}
+
+	// queued returns the names of the VirtualServers in rscQueue
+	queued := func() []string {
+		var names []string
+		for _, key := range mockCRM.drainQueue() {
+			names = append(names, key.rscName)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.SharedVIPPolicy = SharedVIPReject
+		mockCRM.addService(test.NewService("svc1", "1", "default",
+			v1.ServiceTypeClusterIP, nil))
+		newVS = func(name, address string, created int) *cisapiv1.VirtualServer {
+			vs := test.NewVirtualServer(name, "default",
+				cisapiv1.VirtualServerSpec{
+					Host:                 name + ".com",
+					HostGroup:            "shared",
+					VirtualServerAddress: address,
+					Pools: []cisapiv1.Pool{{
+						Path:        "/foo",
+						Service:     "svc1",
+						ServicePort: intstr.FromInt(80),
+					}},
+				})
+			vs.ObjectMeta.CreationTimestamp = metav1.NewTime(
+				time.Unix(int64(created), 0))
+			mockCRM.addVirtualServer(vs)
+			return vs
+		}
+	})
+
+	It("Shares the address of the oldest member with one", func() {
+		vs2 := newVS("vs2", "", 2)
+		vs1 := newVS("vs1", "10.1.1.1", 1)
+		Expect(mockCRM.syncVirtualServer(vs2)).To(BeNil())
+		Expect(mockCRM.syncVirtualServer(vs1)).To(BeNil())
+		Expect(mockCRM.getVirtualServerAddress(vs2)).To(Equal("10.1.1.1"))
+		Expect(owners("10.1.1.1")).To(Equal([]string{"default/vs1",
+			"default/vs2"}))
+		Expect(mockCRM.resources.objDeps[ObjectDependency{
+			Kind:      VirtualServer,
+			Namespace: "default",
+			Name:      "vs2",
+		}]).To(HaveKey(hostGroupDependency("shared")))
+	})
+
+	It("Rejects the members conflicting with older members", func() {
+		vs1 := newVS("vs1", "10.1.1.1", 1)
+		vs3 := newVS("vs3", "10.1.1.3", 3)
+		Expect(mockCRM.syncVirtualServer(vs1)).To(BeNil())
+		Expect(mockCRM.syncVirtualServer(vs3)).To(BeNil())
+		Expect(owners("10.1.1.3")).To(BeEmpty())
+		events := mockCRM.getFakeEvents("default")
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Name).To(Equal("vs3"))
+		Expect(events[0].Reason).To(Equal("HostGroupConflict"))
+
+		// The passthrough termination conflicts with the others
+		for _, termination := range []string{TLSPassthrough, TLSEdge,
+			TLSReencrypt} {
+			mockCRM.addTLSProfile(test.NewTLSProfile(termination, "default",
+				cisapiv1.TLSProfileSpec{
+					TLS: cisapiv1.TLS{Termination: termination},
+				}))
+		}
+		vs1.Spec.TLSProfileName = TLSEdge
+		vs2 := newVS("vs2", "", 2)
+		vs2.Spec.TLSProfileName = TLSPassthrough
+		Expect(mockCRM.checkHostGroupMember(vs2)).NotTo(BeNil())
+		vs2.Spec.TLSProfileName = TLSReencrypt
+		Expect(mockCRM.checkHostGroupMember(vs2)).To(BeNil())
+
+		Expect(ValidateVirtualServer(newVS("vs4", "", 4),
+			mockCRM.validationOptions())).To(BeNil())
+		invalid := newVS("vs4", "", 4)
+		invalid.Spec.HostGroup = "Shared/Group"
+		Expect(ValidateVirtualServer(invalid,
+			mockCRM.validationOptions())).NotTo(BeNil())
+	})
+
+	It("Promotes the next member with an address when the owner is deleted",
+		func() {
+			mockCRM.Agent = &Agent{
+				DeclWriter: &PostManager{postChan: make(chan config, 1)},
+			}
+			vs1 := newVS("vs1", "10.1.1.1", 1)
+			vs2 := newVS("vs2", "", 2)
+			vs3 := newVS("vs3", "10.1.1.3", 3)
+			for _, vs := range []*cisapiv1.VirtualServer{vs1, vs2, vs3} {
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			}
+			Expect(owners("10.1.1.1")).To(Equal([]string{"default/vs1",
+				"default/vs2"}))
+			Expect(owners("10.1.1.3")).To(BeEmpty())
+			queued()
+
+			crInf, _ := mockCRM.getNamespaceInformer("default")
+			Expect(crInf.vsInformer.GetIndexer().Delete(vs1)).To(Succeed())
+			mockCRM.enqueueDeletedVirtualServer(vs1)
+			Expect(mockCRM.processResource()).To(BeTrue())
+			Expect(mockCRM.getHostGroupAddress("shared")).To(Equal("10.1.1.3"))
+			Expect(owners("10.1.1.1")).To(BeEmpty())
+			Expect(queued()).To(Equal([]string{"vs2", "vs3"}))
+
+			for _, vs := range []*cisapiv1.VirtualServer{vs2, vs3} {
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			}
+			Expect(owners("10.1.1.3")).To(Equal([]string{"default/vs2",
+				"default/vs3"}))
+
+			// The group is removed with its last member with an address
+			Expect(crInf.vsInformer.GetIndexer().Delete(vs3)).To(Succeed())
+			mockCRM.enqueueDeletedVirtualServer(vs3)
+			Expect(mockCRM.processResource()).To(BeTrue())
+			Expect(mockCRM.hostGroups).NotTo(HaveKey("shared"))
+			Expect(owners("10.1.1.3")).To(BeEmpty())
+			Expect(queued()).To(Equal([]string{"vs2"}))
+			Expect(mockCRM.syncVirtualServer(vs2)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		})
+
+	It("Shares the address allocated by IPAM for the group", func() {
+		mockCRM.ipam, _ = NewRangeIPAM([]string{"dev=10.1.1.1-10.1.1.2"},
+			mockCRM.kubeClient, "kube-system")
+		vs1 := newVS("vs1", "", 1)
+		vs1.Spec.IPAMLabel = "dev"
+		vs2 := newVS("vs2", "", 2)
+		Expect(mockCRM.syncVirtualServer(vs2)).To(BeNil())
+		Expect(mockCRM.syncVirtualServer(vs1)).To(BeNil())
+		ip, found := mockCRM.ipam.Lookup(hostGroupIPAMKey("shared"))
+		Expect(found).To(BeTrue())
+		Expect(ip).To(Equal("10.1.1.1"))
+		Expect(owners(ip)).To(Equal([]string{"default/vs1", "default/vs2"}))
+
+		vs2.Spec.IPAMLabel = "prod"
+		Expect(mockCRM.checkHostGroupMember(vs2)).NotTo(BeNil())
+
+		// The address of an owner is used instead
+		vs0 := newVS("vs0", "10.1.1.9", 0)
+		Expect(mockCRM.syncVirtualServer(vs0)).To(BeNil())
+		_, found = mockCRM.ipam.Lookup(hostGroupIPAMKey("shared"))
+		Expect(found).To(BeFalse())
+		Expect(owners(ip)).To(BeEmpty())
+	})
+})
@@ -18,16 +18,56 @@ package crmanager
 
 import (
 	"fmt"
+	"reflect"
 	"time"
 
 	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	cisinfv1 "github.com/F5Networks/k8s-bigip-ctlr/config/client/informers/externalversions/cis/v1"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1alpha1 "k8s.io/api/discovery/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/cache"
 )
 
+// endpointSliceServiceIndex indexes the EndpointSlices by the
+// namespace/name of their service.
+const endpointSliceServiceIndex = "service"
+
+func endpointSliceServiceIndexFunc(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryv1alpha1.EndpointSlice)
+	if !ok {
+		return []string{}, nil
+	}
+	svcName, ok := slice.ObjectMeta.Labels[discoveryv1alpha1.LabelServiceName]
+	if !ok || svcName == "" {
+		return []string{}, nil
+	}
+	return []string{slice.ObjectMeta.Namespace + "/" + svcName}, nil
+}
+
+// virtualServerNodeLabelIndex indexes the VirtualServers by the node
+// selectors of their pools.
+const virtualServerNodeLabelIndex = "nodeMemberLabel"
+
+func virtualServerNodeLabelIndexFunc(obj interface{}) ([]string, error) {
+	vs, ok := obj.(*cisapiv1.VirtualServer)
+	if !ok {
+		return []string{}, nil
+	}
+	found := make(map[string]bool)
+	nodeLabels := []string{}
+	for _, pl := range getVirtualServerPools(vs) {
+		nodeLabel, err := getPoolNodeSelector(pl)
+		if err == nil && nodeLabel != "" && !found[nodeLabel] {
+			found[nodeLabel] = true
+			nodeLabels = append(nodeLabels, nodeLabel)
+		}
+	}
+	return nodeLabels, nil
+}
+
 // start the VirtualServer informer
 func (crInfr *CRInformer) start() {
 	log.Infof("Starting VirtualServer Informer")
@@ -38,12 +78,37 @@ func (crInfr *CRInformer) start() {
 	if crInfr.tsInformer != nil {
 		go crInfr.tsInformer.Run(crInfr.stopCh)
 	}
+	log.Infof("Starting TransportServer Informer")
+	if crInfr.transportInformer != nil {
+		go crInfr.transportInformer.Run(crInfr.stopCh)
+	}
+	log.Infof("Starting IngressLink Informer")
+	if crInfr.ilInformer != nil {
+		go crInfr.ilInformer.Run(crInfr.stopCh)
+	}
+	log.Infof("Starting ExternalDNS Informer")
+	if crInfr.ednsInformer != nil {
+		go crInfr.ednsInformer.Run(crInfr.stopCh)
+	}
+	log.Infof("Starting Policy Informer")
+	if crInfr.plcInformer != nil {
+		go crInfr.plcInformer.Run(crInfr.stopCh)
+	}
 	if crInfr.svcInformer != nil {
 		go crInfr.svcInformer.Run(crInfr.stopCh)
 	}
 	if crInfr.epsInformer != nil {
 		go crInfr.epsInformer.Run(crInfr.stopCh)
 	}
+	if crInfr.sliceInformer != nil {
+		go crInfr.sliceInformer.Run(crInfr.stopCh)
+	}
+	if crInfr.podInformer != nil {
+		go crInfr.podInformer.Run(crInfr.stopCh)
+	}
+	if crInfr.secretInformer != nil {
+		go crInfr.secretInformer.Run(crInfr.stopCh)
+	}
 }
 
 func (crInfr *CRInformer) stop() {
@@ -101,7 +166,10 @@ func (crMgr *CRManager) newInformer(
 			crMgr.kubeCRClient,
 			namespace,
 			resyncPeriod,
-			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			cache.Indexers{
+				cache.NamespaceIndex:        cache.MetaNamespaceIndexFunc,
+				virtualServerNodeLabelIndex: virtualServerNodeLabelIndexFunc,
+			},
 			crOptions,
 		),
 		tsInformer: cisinfv1.NewFilteredTLSProfileInformer(
@@ -111,6 +179,34 @@ func (crMgr *CRManager) newInformer(
 			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 			crOptions,
 		),
+		transportInformer: cisinfv1.NewFilteredTransportServerInformer(
+			crMgr.kubeCRClient,
+			namespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			crOptions,
+		),
+		ilInformer: cisinfv1.NewFilteredIngressLinkInformer(
+			crMgr.kubeCRClient,
+			namespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			crOptions,
+		),
+		ednsInformer: cisinfv1.NewFilteredExternalDNSInformer(
+			crMgr.kubeCRClient,
+			namespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			crOptions,
+		),
+		plcInformer: cisinfv1.NewFilteredPolicyInformer(
+			crMgr.kubeCRClient,
+			namespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			crOptions,
+		),
 		svcInformer: cache.NewSharedIndexInformer(
 			cache.NewFilteredListWatchFromClient(
 				restClientv1,
@@ -122,7 +218,47 @@ func (crMgr *CRManager) newInformer(
 			resyncPeriod,
 			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 		),
-		epsInformer: cache.NewSharedIndexInformer(
+		podInformer: cache.NewSharedIndexInformer(
+			cache.NewFilteredListWatchFromClient(
+				restClientv1,
+				"pods",
+				namespace,
+				everything,
+			),
+			&corev1.Pod{},
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		),
+		secretInformer: cache.NewSharedIndexInformer(
+			cache.NewFilteredListWatchFromClient(
+				restClientv1,
+				"secrets",
+				namespace,
+				everything,
+			),
+			&corev1.Secret{},
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		),
+	}
+
+	if crMgr.UseEndpointSlices {
+		crInf.sliceInformer = cache.NewSharedIndexInformer(
+			cache.NewFilteredListWatchFromClient(
+				crMgr.kubeClient.DiscoveryV1alpha1().RESTClient(),
+				"endpointslices",
+				namespace,
+				everything,
+			),
+			&discoveryv1alpha1.EndpointSlice{},
+			resyncPeriod,
+			cache.Indexers{
+				cache.NamespaceIndex:      cache.MetaNamespaceIndexFunc,
+				endpointSliceServiceIndex: endpointSliceServiceIndexFunc,
+			},
+		)
+	} else {
+		crInf.epsInformer = cache.NewSharedIndexInformer(
 			cache.NewFilteredListWatchFromClient(
 				restClientv1,
 				"endpoints",
@@ -132,41 +268,135 @@ func (crMgr *CRManager) newInformer(
 			&corev1.Endpoints{},
 			resyncPeriod,
 			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
-		),
+		)
 	}
 
 	return crInf
 }
 
+// newNodeInformer returns the informer of the nodes of the cluster, the
+// VirtualServers whose pools select the nodes changed by their
+// NodeMemberLabel are processed again.
+func (crMgr *CRManager) newNodeInformer() cache.SharedIndexInformer {
+	nodeInformer := cache.NewSharedIndexInformer(
+		cache.NewFilteredListWatchFromClient(
+			crMgr.kubeClient.CoreV1().RESTClient(),
+			"nodes",
+			"",
+			func(options *metav1.ListOptions) {},
+		),
+		&corev1.Node{},
+		0*time.Second,
+		cache.Indexers{},
+	)
+	nodeInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueueNewNode(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueUpdatedNode(old, cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedNode(obj) },
+		},
+	)
+	return nodeInformer
+}
+
 func (crMgr *CRManager) addEventHandlers(crInf *CRInformer) {
 
 	crInf.vsInformer.AddEventHandler(
 		&cache.ResourceEventHandlerFuncs{
 			AddFunc:    func(obj interface{}) { crMgr.enqueueVirtualServer(obj) },
-			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueVirtualServer(cur) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueUpdatedVirtualServer(old, cur) },
 			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedVirtualServer(obj) },
 		},
 	)
 
+	crInf.tsInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueueTLSProfile(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueTLSProfile(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedTLSProfile(obj) },
+		},
+	)
+
+	crInf.transportInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueueTransportServer(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueTransportServer(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedTransportServer(obj) },
+		},
+	)
+
+	crInf.ilInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueueIngressLink(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueIngressLink(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedIngressLink(obj) },
+		},
+	)
+
+	crInf.ednsInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueueExternalDNS(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueExternalDNS(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedExternalDNS(obj) },
+		},
+	)
+
+	crInf.plcInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { crMgr.enqueuePolicy(obj) },
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueuePolicy(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedPolicy(obj) },
+		},
+	)
+
 	crInf.svcInformer.AddEventHandler(
 		&cache.ResourceEventHandlerFuncs{
-			// Ignore AddFunc for service as we dont bother about services until they are
-			// mapped to VirtualServer. Any new service added and mapped to a VirtualServer
-			// will be handled in the VirtualServer Informer AddFunc.
-			// AddFunc:    func(obj interface{}) { crMgr.enqueueService(obj) },
+			// New services only matter to the VirtualServers waiting for them.
+			AddFunc:    func(obj interface{}) { crMgr.enqueueNewService(obj) },
 			UpdateFunc: func(obj, cur interface{}) { crMgr.enqueueService(cur) },
 			DeleteFunc: func(obj interface{}) { crMgr.enqueueService(obj) },
 		},
 	)
 
-	crInf.epsInformer.AddEventHandler(
+	if crInf.epsInformer != nil {
+		crInf.epsInformer.AddEventHandler(
+			&cache.ResourceEventHandlerFuncs{
+				// New endpoints only matter to the VirtualServers waiting for
+				// their service to be populated.
+				AddFunc:    func(obj interface{}) { crMgr.enqueueNewEndpoints(obj) },
+				UpdateFunc: func(obj, cur interface{}) { crMgr.enqueueEndpoints(cur) },
+				DeleteFunc: func(obj interface{}) { crMgr.enqueueEndpoints(obj) },
+			},
+		)
+	}
+
+	if crInf.sliceInformer != nil {
+		crInf.sliceInformer.AddEventHandler(
+			&cache.ResourceEventHandlerFuncs{
+				// A service may have several EndpointSlices, a new slice
+				// changes the pool members unlike new Endpoints.
+				AddFunc:    func(obj interface{}) { crMgr.enqueueEndpointSlice(obj) },
+				UpdateFunc: func(obj, cur interface{}) { crMgr.enqueueEndpointSlice(cur) },
+				DeleteFunc: func(obj interface{}) { crMgr.enqueueEndpointSlice(obj) },
+			},
+		)
+	}
+
+	crInf.podInformer.AddEventHandler(
 		&cache.ResourceEventHandlerFuncs{
-			// Ignore AddFunc for endpoint as we dont bother about endpoints until they are
-			// mapped to VirtualServer. Any new endpoint added and mapped to a Service
-			// will be handled in the Service Informer AddFunc.
-			// AddFunc:    func(obj interface{}) { crMgr.enqueueEndpoints(obj) },
-			UpdateFunc: func(obj, cur interface{}) { crMgr.enqueueEndpoints(cur) },
-			DeleteFunc: func(obj interface{}) { crMgr.enqueueEndpoints(obj) },
+			// Only terminating pods matter, their members are drained until
+			// the pods are deleted.
+			UpdateFunc: func(old, cur interface{}) { crMgr.enqueueUpdatedPod(old, cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedPod(obj) },
+		},
+	)
+
+	crInf.secretInformer.AddEventHandler(
+		&cache.ResourceEventHandlerFuncs{
+			// Secrets are read when the VirtualServers referring them are
+			// processed, only the changes of the secrets in use matter.
+			UpdateFunc: func(obj, cur interface{}) { crMgr.enqueueSecret(cur) },
+			DeleteFunc: func(obj interface{}) { crMgr.enqueueDeletedSecret(obj) },
 		},
 	)
 }
@@ -194,6 +424,22 @@ func (crMgr *CRManager) enqueueVirtualServer(obj interface{}) {
 	crMgr.rscQueue.Add(key)
 }
 
+// enqueueUpdatedVirtualServer adds the VirtualServer to rscQueue, unless
+// only its status or finalizers are updated.
+func (crMgr *CRManager) enqueueUpdatedVirtualServer(old, cur interface{}) {
+	oldVS := old.(*cisapiv1.VirtualServer)
+	curVS := cur.(*cisapiv1.VirtualServer)
+	if reflect.DeepEqual(oldVS.Spec, curVS.Spec) &&
+		reflect.DeepEqual(oldVS.ObjectMeta.Labels, curVS.ObjectMeta.Labels) &&
+		nil == curVS.ObjectMeta.DeletionTimestamp &&
+		(!reflect.DeepEqual(oldVS.Status, curVS.Status) ||
+			!reflect.DeepEqual(oldVS.ObjectMeta.Finalizers,
+				curVS.ObjectMeta.Finalizers)) {
+		return
+	}
+	crMgr.enqueueVirtualServer(cur)
+}
+
 func (crMgr *CRManager) enqueueDeletedVirtualServer(obj interface{}) {
 	vs := obj.(*cisapiv1.VirtualServer)
 	log.Infof("Enqueueing VirtualServer: %v", vs)
@@ -208,6 +454,141 @@ func (crMgr *CRManager) enqueueDeletedVirtualServer(obj interface{}) {
 	crMgr.rscQueue.Add(key)
 }
 
+func (crMgr *CRManager) enqueueTLSProfile(obj interface{}) {
+	tls := obj.(*cisapiv1.TLSProfile)
+	log.Infof("Enqueueing TLSProfile: %v", tls)
+	key := &rqKey{
+		namespace: tls.ObjectMeta.Namespace,
+		kind:      TLSProfile,
+		rscName:   tls.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedTLSProfile(obj interface{}) {
+	tls := obj.(*cisapiv1.TLSProfile)
+	log.Infof("Enqueueing TLSProfile: %v", tls)
+	key := &rqKey{
+		namespace: tls.ObjectMeta.Namespace,
+		kind:      TLSProfile,
+		rscName:   tls.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueTransportServer(obj interface{}) {
+	ts := obj.(*cisapiv1.TransportServer)
+	log.Infof("Enqueueing TransportServer: %v", ts)
+	key := &rqKey{
+		namespace: ts.ObjectMeta.Namespace,
+		kind:      TransportServer,
+		rscName:   ts.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedTransportServer(obj interface{}) {
+	ts := obj.(*cisapiv1.TransportServer)
+	log.Infof("Enqueueing TransportServer: %v", ts)
+	key := &rqKey{
+		namespace: ts.ObjectMeta.Namespace,
+		kind:      TransportServer,
+		rscName:   ts.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueIngressLink(obj interface{}) {
+	il := obj.(*cisapiv1.IngressLink)
+	log.Infof("Enqueueing IngressLink: %v", il)
+	key := &rqKey{
+		namespace: il.ObjectMeta.Namespace,
+		kind:      IngressLink,
+		rscName:   il.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedIngressLink(obj interface{}) {
+	il := obj.(*cisapiv1.IngressLink)
+	log.Infof("Enqueueing IngressLink: %v", il)
+	key := &rqKey{
+		namespace: il.ObjectMeta.Namespace,
+		kind:      IngressLink,
+		rscName:   il.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueExternalDNS(obj interface{}) {
+	edns := obj.(*cisapiv1.ExternalDNS)
+	log.Infof("Enqueueing ExternalDNS: %v", edns)
+	key := &rqKey{
+		namespace: edns.ObjectMeta.Namespace,
+		kind:      ExternalDNS,
+		rscName:   edns.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedExternalDNS(obj interface{}) {
+	edns := obj.(*cisapiv1.ExternalDNS)
+	log.Infof("Enqueueing ExternalDNS: %v", edns)
+	key := &rqKey{
+		namespace: edns.ObjectMeta.Namespace,
+		kind:      ExternalDNS,
+		rscName:   edns.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueuePolicy(obj interface{}) {
+	plc := obj.(*cisapiv1.Policy)
+	log.Infof("Enqueueing Policy: %v", plc)
+	key := &rqKey{
+		namespace: plc.ObjectMeta.Namespace,
+		kind:      CustomPolicy,
+		rscName:   plc.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedPolicy(obj interface{}) {
+	plc := obj.(*cisapiv1.Policy)
+	log.Infof("Enqueueing Policy: %v", plc)
+	key := &rqKey{
+		namespace: plc.ObjectMeta.Namespace,
+		kind:      CustomPolicy,
+		rscName:   plc.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
 func (crMgr *CRManager) enqueueService(obj interface{}) {
 	svc := obj.(*corev1.Service)
 	log.Infof("Enqueueing Service: %v", svc)
@@ -221,6 +602,43 @@ func (crMgr *CRManager) enqueueService(obj interface{}) {
 	crMgr.rscQueue.Add(key)
 }
 
+// enqueueNewService adds the VirtualServers waiting for the new service to
+// rscQueue.
+func (crMgr *CRManager) enqueueNewService(obj interface{}) {
+	svc := obj.(*corev1.Service)
+	crMgr.enqueuePendingVirtualServers(svc.ObjectMeta.Namespace,
+		svc.ObjectMeta.Name)
+}
+
+func (crMgr *CRManager) enqueueSecret(obj interface{}) {
+	secret := obj.(*corev1.Secret)
+	log.Infof("Enqueueing Secret: %s/%s", secret.ObjectMeta.Namespace,
+		secret.ObjectMeta.Name)
+	key := &rqKey{
+		namespace: secret.ObjectMeta.Namespace,
+		kind:      TLSSecret,
+		rscName:   secret.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+func (crMgr *CRManager) enqueueDeletedSecret(obj interface{}) {
+	secret := obj.(*corev1.Secret)
+	log.Infof("Enqueueing Secret: %s/%s", secret.ObjectMeta.Namespace,
+		secret.ObjectMeta.Name)
+	key := &rqKey{
+		namespace: secret.ObjectMeta.Namespace,
+		kind:      TLSSecret,
+		rscName:   secret.ObjectMeta.Name,
+		rsc:       obj,
+		rscDelete: true,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
 func (crMgr *CRManager) enqueueEndpoints(obj interface{}) {
 	eps := obj.(*corev1.Endpoints)
 	log.Infof("Enqueueing Endpoints: %v", eps)
@@ -233,3 +651,136 @@ func (crMgr *CRManager) enqueueEndpoints(obj interface{}) {
 
 	crMgr.rscQueue.Add(key)
 }
+
+// enqueueNewEndpoints adds the new Endpoints to rscQueue when
+// VirtualServers wait for their service.
+func (crMgr *CRManager) enqueueNewEndpoints(obj interface{}) {
+	eps := obj.(*corev1.Endpoints)
+	svcDep := serviceDependency(eps.ObjectMeta.Namespace, eps.ObjectMeta.Name)
+	crMgr.pendingMutex.Lock()
+	_, pending := crMgr.pendingServices[svcDep]
+	crMgr.pendingMutex.Unlock()
+	if pending {
+		crMgr.enqueueEndpoints(obj)
+	}
+}
+
+// enqueueUpdatedPod adds the services of the pod to rscQueue when the pod
+// starts terminating.
+func (crMgr *CRManager) enqueueUpdatedPod(old, cur interface{}) {
+	oldPod := old.(*corev1.Pod)
+	curPod := cur.(*corev1.Pod)
+	if oldPod.ObjectMeta.DeletionTimestamp != nil ||
+		curPod.ObjectMeta.DeletionTimestamp == nil {
+		return
+	}
+	crMgr.enqueuePodServices(curPod)
+}
+
+// enqueueDeletedPod adds the services of the terminated pod to rscQueue to
+// remove its draining members.
+func (crMgr *CRManager) enqueueDeletedPod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.ObjectMeta.DeletionTimestamp == nil {
+		return
+	}
+	crMgr.enqueuePodServices(pod)
+}
+
+func (crMgr *CRManager) enqueuePodServices(pod *corev1.Pod) {
+	for _, svc := range crMgr.getPodServices(pod) {
+		log.Infof("Enqueueing Service %v of terminating pod %v",
+			svc.ObjectMeta.Name, pod.ObjectMeta.Name)
+		key := &rqKey{
+			namespace: svc.ObjectMeta.Namespace,
+			kind:      Service,
+			rscName:   svc.ObjectMeta.Name,
+			rsc:       svc,
+		}
+		crMgr.rscQueue.Add(key)
+	}
+}
+
+func (crMgr *CRManager) enqueueEndpointSlice(obj interface{}) {
+	slice := obj.(*discoveryv1alpha1.EndpointSlice)
+	log.Infof("Enqueueing EndpointSlice: %v", slice)
+	key := &rqKey{
+		namespace: slice.ObjectMeta.Namespace,
+		kind:      EndpointSlice,
+		rscName:   slice.ObjectMeta.Name,
+		rsc:       obj,
+	}
+
+	crMgr.rscQueue.Add(key)
+}
+
+// enqueueNewNode adds the VirtualServers whose pools select the new node to
+// rscQueue.
+func (crMgr *CRManager) enqueueNewNode(obj interface{}) {
+	crMgr.enqueueNodeVirtualServers(nil, obj.(*corev1.Node))
+}
+
+// enqueueUpdatedNode adds the VirtualServers whose pools select the node
+// either before or after its update to rscQueue.
+func (crMgr *CRManager) enqueueUpdatedNode(old, cur interface{}) {
+	oldNode := old.(*corev1.Node)
+	curNode := cur.(*corev1.Node)
+	// The status of the nodes is updated periodically
+	if reflect.DeepEqual(oldNode.ObjectMeta.Labels, curNode.ObjectMeta.Labels) &&
+		isUnhealthyNode(oldNode) == isUnhealthyNode(curNode) {
+		return
+	}
+	crMgr.enqueueNodeVirtualServers(oldNode, curNode)
+}
+
+// enqueueDeletedNode adds the VirtualServers whose pools selected the
+// deleted node to rscQueue.
+func (crMgr *CRManager) enqueueDeletedNode(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		crMgr.enqueueNodeVirtualServers(node, nil)
+	}
+}
+
+// enqueueNodeVirtualServers adds the VirtualServers with a pool whose
+// NodeMemberLabel selects either the old or the current node, not both, to
+// rscQueue. The old node is nil for a new node, the current one for a
+// deleted node.
+func (crMgr *CRManager) enqueueNodeVirtualServers(old, cur *corev1.Node) {
+	for _, crInf := range crMgr.crInformers {
+		indexer := crInf.vsInformer.GetIndexer()
+		for _, nodeLabel := range indexer.ListIndexFuncValues(
+			virtualServerNodeLabelIndex) {
+			selector, err := labels.Parse(nodeLabel)
+			if err != nil {
+				continue
+			}
+			if crMgr.isMemberNode(old, selector) ==
+				crMgr.isMemberNode(cur, selector) {
+				continue
+			}
+			virtuals, _ := indexer.ByIndex(virtualServerNodeLabelIndex,
+				nodeLabel)
+			for _, obj := range virtuals {
+				crMgr.enqueueVirtualServer(obj)
+			}
+		}
+	}
+}
+
+// isMemberNode returns true if the node selected by the NodeMemberLabel is
+// a member of the pools, unless it is unhealthy with FilterUnhealthy.
+func (crMgr *CRManager) isMemberNode(
+	node *corev1.Node,
+	selector labels.Selector,
+) bool {
+	if nil == node || crMgr.FilterUnhealthy && isUnhealthyNode(node) {
+		return false
+	}
+	return selector.Matches(labels.Set(node.ObjectMeta.Labels))
+}
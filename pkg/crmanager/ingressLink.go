@@ -0,0 +1,242 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"sort"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ingressLinkPorts are the ports of the virtuals of an IngressLink, the
+// same ports of the ingress controller service are the pool members.
+var ingressLinkPorts = []int32{DEFAULT_HTTP_PORT, DEFAULT_HTTPS_PORT}
+
+// ilKey returns the key of the IngressLink, namespace/name
+func ilKey(il *cisapiv1.IngressLink) string {
+	return il.ObjectMeta.Namespace + "/" + il.ObjectMeta.Name
+}
+
+// syncIngressLink creates the resource configs of the HTTP and HTTPS
+// virtuals of the IngressLink. The traffic is forwarded to the ingress
+// controller service with the PROXY protocol header.
+func (crMgr *CRManager) syncIngressLink(il *cisapiv1.IngressLink) {
+	if !crMgr.checkValidIngressLink(il) {
+		log.Infof("IngressLink %s, invalid configuration or not valid",
+			ilKey(il))
+		crMgr.deleteResourceConfigs(IngressLink, ilKey(il))
+		return
+	}
+
+	svc := crMgr.getIngressLinkService(il)
+	if nil == svc {
+		msg := "No service matches the selector of the IngressLink"
+		log.Errorf("IngressLink %s: %s", ilKey(il), msg)
+		crMgr.recordEvent(il, il.ObjectMeta.Namespace, v1.EventTypeWarning,
+			"ServiceNotFound", msg)
+		crMgr.deleteResourceConfigs(IngressLink, ilKey(il))
+		return
+	}
+
+	var refs []NameRef
+	for _, port := range ingressLinkPorts {
+		refs = append(refs, NameRef{
+			Name:      crMgr.getIngressLinkName(il, port),
+			Partition: crMgr.getNamespacePartition(il.ObjectMeta.Namespace),
+		})
+	}
+	// Remove the virtuals of the previous address and partition.
+	crMgr.deleteResourceConfigs(IngressLink, ilKey(il), refs...)
+
+	for i, port := range ingressLinkPorts {
+		if !crMgr.claimResourceVirtual(il, IngressLink, il.ObjectMeta.Namespace,
+			ilKey(il), refs[i].Name, il.Spec.VirtualServerAddress, port) {
+			continue
+		}
+		rsCfg := crMgr.createRSConfigFromIngressLink(il, svc.ObjectMeta.Name, port)
+		crMgr.updatePoolMembers(rsCfg, il.ObjectMeta.Namespace)
+		// Pool members are in the same route domain as the virtual.
+		rsCfg.updatePoolMembersRouteDomain()
+		log.Infof("ResourceConfig looks like %v", rsCfg)
+	}
+}
+
+// getIngressLinkName returns the name of the BIG-IP virtual created for
+// an IngressLink on the given port
+func (crMgr *CRManager) getIngressLinkName(
+	il *cisapiv1.IngressLink,
+	port int32,
+) string {
+	return formatVirtualServerName(
+		formatRouteDomainAddress(
+			normalizeAddress(il.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(il.ObjectMeta.Namespace),
+		),
+		port,
+	)
+}
+
+// format the pool name for an IngressLink, the HTTP and HTTPS virtuals
+// have a pool each.
+func formatIngressLinkPoolName(namespace, svc string, port int32) string {
+	return fmt.Sprintf("%s_%d",
+		formatVirtualServerPoolName(namespace, svc, "", ""), port)
+}
+
+// Creates resource config based on IngressLink resource config, the
+// virtual has a single pool of the ingress controller service.
+func (crMgr *CRManager) createRSConfigFromIngressLink(
+	il *cisapiv1.IngressLink,
+	svcName string,
+	port int32,
+) *ResourceConfig {
+	var cfg ResourceConfig
+
+	cfg.Virtual.Partition = crMgr.getNamespacePartition(il.ObjectMeta.Namespace)
+	cfg.Virtual.Name = crMgr.getIngressLinkName(il, port)
+
+	cfg.MetaData.rscName = il.ObjectMeta.Name
+	cfg.MetaData.addOwner(ilKey(il))
+	cfg.MetaData.ResourceType = IngressLink
+
+	cfg.Virtual.Enabled = true
+	cfg.Virtual.IpProtocol = TransportServerTCP
+	cfg.Virtual.Mode = TransportServerStandard
+	cfg.Virtual.SourceAddrTranslation = crMgr.getSourceAddrTranslation("")
+	cfg.Virtual.SetVirtualAddress(
+		formatRouteDomainAddress(
+			normalizeAddress(il.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(il.ObjectMeta.Namespace),
+		),
+		port,
+	)
+
+	pool := Pool{
+		Name:             formatIngressLinkPoolName(il.ObjectMeta.Namespace, svcName, port),
+		Partition:        cfg.Virtual.Partition,
+		ServiceName:      svcName,
+		ServiceNamespace: il.ObjectMeta.Namespace,
+		ServicePort:      port,
+	}
+	cfg.Virtual.PoolName = pool.Name
+	cfg.AddOrUpdatePool(pool)
+
+	crMgr.addIRule(ProxyProtocolIRuleName, cfg.Virtual.Partition,
+		proxyProtocolIRule())
+	cfg.Virtual.AddIRule(
+		crMgr.iRulePath(cfg.Virtual.Partition, ProxyProtocolIRuleName))
+	for _, irule := range il.Spec.IRules {
+		cfg.Virtual.AddIRule(formatIRuleName(irule))
+	}
+
+	crMgr.resources.setResourceConfig(&cfg)
+	return &cfg
+}
+
+// proxyProtocolIRule sends the PROXY protocol header with the address of
+// the client to the ingress controller.
+func proxyProtocolIRule() string {
+	return `
+		when CLIENT_ACCEPTED {
+			set proxyheader "PROXY TCP[IP::version] [IP::remote_addr] [IP::local_addr] [TCP::remote_port] [TCP::local_port]\r\n"
+		}
+
+		when SERVER_CONNECTED {
+			TCP::respond $proxyheader
+		}`
+}
+
+// getIngressLinkService returns the service selected by the IngressLink,
+// the first by name if the selector matches several services.
+func (crMgr *CRManager) getIngressLinkService(
+	il *cisapiv1.IngressLink,
+) *v1.Service {
+	crInf, ok := crMgr.getNamespaceInformer(il.ObjectMeta.Namespace)
+	if !ok {
+		log.Errorf("Informer not found for namespace: %v",
+			il.ObjectMeta.Namespace)
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(il.Spec.Selector)
+	if err != nil {
+		return nil
+	}
+	var services []*v1.Service
+	for _, obj := range crInf.svcInformer.GetIndexer().List() {
+		svc := obj.(*v1.Service)
+		if svc.ObjectMeta.Namespace == il.ObjectMeta.Namespace &&
+			selector.Matches(labels.Set(svc.ObjectMeta.Labels)) {
+			services = append(services, svc)
+		}
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ObjectMeta.Name < services[j].ObjectMeta.Name
+	})
+	if len(services) > 1 {
+		log.Warningf("IngressLink %s selects %d services, using %s",
+			ilKey(il), len(services), services[0].ObjectMeta.Name)
+	}
+	return services[0]
+}
+
+// syncIngressLinksForService processes the IngressLinks selecting the
+// service, or using it, again to update their pool members.
+func (crMgr *CRManager) syncIngressLinksForService(svc *v1.Service) {
+	crInf, ok := crMgr.getNamespaceInformer(svc.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	for _, obj := range crInf.ilInformer.GetIndexer().List() {
+		il := obj.(*cisapiv1.IngressLink)
+		if il.ObjectMeta.Namespace != svc.ObjectMeta.Namespace {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(il.Spec.Selector)
+		if (err == nil && selector.Matches(labels.Set(svc.ObjectMeta.Labels))) ||
+			crMgr.ingressLinkUsesService(il, svc.ObjectMeta.Name) {
+			crMgr.syncIngressLink(il)
+		}
+	}
+}
+
+// ingressLinkUsesService returns true if the virtuals of the IngressLink
+// have the pool of the service.
+func (crMgr *CRManager) ingressLinkUsesService(
+	il *cisapiv1.IngressLink,
+	svcName string,
+) bool {
+	for _, rsCfg := range crMgr.resources.GetAllResources() {
+		if rsCfg.MetaData.ResourceType != IngressLink ||
+			!rsCfg.MetaData.hasOwner(ilKey(il)) {
+			continue
+		}
+		for _, pool := range rsCfg.Pools {
+			if pool.ServiceName == svcName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("IngressLink", func() {
+	var mockCRM *mockCRManager
+	var il *cisapiv1.IngressLink
+	var svc *v1.Service
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.ControllerMode = NodePortMode
+		mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+		il = test.NewIngressLink(
+			"SampleIL",
+			"nginx",
+			cisapiv1.IngressLinkSpec{
+				VirtualServerAddress: "1.2.3.4",
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "ingress-nginx"},
+				},
+				IRules: []string{"/Common/my_irule"},
+			},
+		)
+		svc = test.NewService("nginx-ingress", "1", "nginx",
+			v1.ServiceTypeNodePort, []v1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080},
+				{Name: "https", Port: 443, NodePort: 30443},
+			})
+		svc.ObjectMeta.Labels = map[string]string{"app": "ingress-nginx"}
+		mockCRM.addIngressLink(il)
+	})
+
+	It("Creates HTTP and HTTPS virtuals for the ingress controller", func() {
+		oldPartition := DEFAULT_PARTITION
+		DEFAULT_PARTITION = "test"
+		defer func() { DEFAULT_PARTITION = oldPartition }()
+		mockCRM.addService(svc)
+		mockCRM.syncIngressLink(il)
+
+		Expect(len(mockCRM.resources.rsMap)).To(Equal(2))
+		nodePorts := map[int32]int32{80: 30080, 443: 30443}
+		for port, nodePort := range nodePorts {
+			rsCfg, ok := mockCRM.resources.GetByName("test",
+				mockCRM.getIngressLinkName(il, port))
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.MetaData.ResourceType).To(Equal(IngressLink))
+			Expect(rsCfg.Virtual.IpProtocol).To(Equal("tcp"))
+			Expect(rsCfg.Virtual.IRules).To(Equal([]string{
+				"/test/" + ProxyProtocolIRuleName,
+				"/Common/my_irule",
+			}))
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{{
+				Address: "10.0.0.1",
+				Port:    nodePort,
+				Session: "user-enabled",
+			}}))
+		}
+		_, ok := mockCRM.irulesMap[NameRef{
+			Name:      ProxyProtocolIRuleName,
+			Partition: "test",
+		}]
+		Expect(ok).To(BeTrue())
+	})
+
+	It("Requeues the IngressLink when the service is created", func() {
+		mockCRM.syncIngressLink(il)
+		Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		events := mockCRM.getFakeEvents("nginx")
+		Expect(len(events)).To(Equal(1))
+		Expect(events[0].Reason).To(Equal("ServiceNotFound"))
+
+		mockCRM.addService(svc)
+		mockCRM.syncIngressLinksForService(svc)
+		Expect(len(mockCRM.resources.rsMap)).To(Equal(2))
+	})
+
+	It("Deletes both virtuals", func() {
+		mockCRM.addService(svc)
+		mockCRM.syncIngressLink(il)
+		Expect(len(mockCRM.resources.rsMap)).To(Equal(2))
+
+		mockCRM.deleteResourceConfigs(IngressLink, ilKey(il))
+		Expect(mockCRM.resources.rsMap).To(BeEmpty())
+	})
+
+	It("Rejects IngressLink without selector", func() {
+		il.Spec.Selector = nil
+		mockCRM.addIngressLink(il)
+		mockCRM.syncIngressLink(il)
+		Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		events := mockCRM.getFakeEvents("nginx")
+		Expect(len(events)).To(Equal(1))
+		Expect(events[0].Reason).To(Equal("InvalidData"))
+	})
+})
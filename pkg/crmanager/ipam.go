@@ -0,0 +1,333 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IPAMConfigMapName is the ConfigMap which persists the IPAM allocations
+const IPAMConfigMapName = "f5-cis-ipam"
+
+// IPAM allocates addresses to the VirtualServers without
+// VirtualServerAddress. The key identifies the VirtualServer as
+// namespace/name/host and the label selects the address range. Allocating
+// for a key releases the addresses allocated for the other hosts of the
+// same VirtualServer. The address of a host group is allocated for the key
+// hostGroup:name/.
+type IPAM interface {
+	Allocate(key, label string) (string, error)
+	Lookup(key string) (string, bool)
+	Release(key string) error
+}
+
+type (
+	ipRange struct {
+		start net.IP
+		end   net.IP
+	}
+
+	ipamAllocation struct {
+		Label string `json:"label"`
+		IP    string `json:"ip"`
+	}
+
+	// rangeIPAM allocates addresses from the ranges configured per label
+	// and persists the allocations in a ConfigMap, so that the same
+	// address is used across the restarts of the controller.
+	rangeIPAM struct {
+		sync.Mutex
+		ranges      map[string][]ipRange
+		allocations map[string]ipamAllocation
+		kubeClient  kubernetes.Interface
+		namespace   string
+		loaded      bool
+	}
+)
+
+// parseIPAMRanges parses the ranges of the form label=startIP-endIP
+func parseIPAMRanges(ranges []string) (map[string][]ipRange, error) {
+	ipRanges := make(map[string][]ipRange)
+	for _, rng := range ranges {
+		parts := strings.SplitN(rng, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid IPAM range '%s', "+
+				"expected <label>=<start_ip>-<end_ip>", rng)
+		}
+		ips := strings.SplitN(parts[1], "-", 2)
+		if len(ips) != 2 {
+			return nil, fmt.Errorf("Invalid IPAM range '%s', "+
+				"expected <label>=<start_ip>-<end_ip>", rng)
+		}
+		start := net.ParseIP(strings.TrimSpace(ips[0]))
+		end := net.ParseIP(strings.TrimSpace(ips[1]))
+		if nil == start || nil == end ||
+			(nil == start.To4()) != (nil == end.To4()) ||
+			bytes.Compare(start.To16(), end.To16()) > 0 {
+			return nil, fmt.Errorf("Invalid IPAM range '%s'", rng)
+		}
+		ipRanges[parts[0]] = append(ipRanges[parts[0]],
+			ipRange{start: start.To16(), end: end.To16()})
+	}
+	return ipRanges, nil
+}
+
+// NewRangeIPAM returns an IPAM allocating addresses from the ranges
+func NewRangeIPAM(
+	ranges []string,
+	kubeClient kubernetes.Interface,
+	namespace string,
+) (IPAM, error) {
+	ipRanges, err := parseIPAMRanges(ranges)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeIPAM{
+		ranges:      ipRanges,
+		allocations: make(map[string]ipamAllocation),
+		kubeClient:  kubeClient,
+		namespace:   namespace,
+	}, nil
+}
+
+// nextIP returns the address next to ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func (ipam *rangeIPAM) Allocate(key, label string) (string, error) {
+	ipam.Lock()
+	defer ipam.Unlock()
+
+	if err := ipam.load(); err != nil {
+		return "", err
+	}
+	if alloc, ok := ipam.allocations[key]; ok && alloc.Label == label {
+		return alloc.IP, nil
+	}
+	ranges, ok := ipam.ranges[label]
+	if !ok {
+		return "", fmt.Errorf("IPAM label '%s' is not configured", label)
+	}
+
+	// Release the addresses of the previous hosts of the VirtualServer
+	vsPrefix := key[:strings.LastIndex(key, "/")+1]
+	released := make(map[string]ipamAllocation)
+	used := make(map[string]bool)
+	for k, alloc := range ipam.allocations {
+		if k == key {
+			continue
+		}
+		if strings.HasPrefix(k, vsPrefix) &&
+			!strings.Contains(strings.TrimPrefix(k, vsPrefix), "/") {
+			released[k] = alloc
+			delete(ipam.allocations, k)
+			continue
+		}
+		used[alloc.IP] = true
+	}
+	// Restore the released addresses if the allocation fails
+	oldAlloc, hadAlloc := ipam.allocations[key]
+	restore := func() {
+		delete(ipam.allocations, key)
+		if hadAlloc {
+			ipam.allocations[key] = oldAlloc
+		}
+		for k, alloc := range released {
+			ipam.allocations[k] = alloc
+		}
+	}
+	for _, rng := range ranges {
+		for ip := rng.start; bytes.Compare(ip, rng.end) <= 0; ip = nextIP(ip) {
+			if used[ip.String()] {
+				continue
+			}
+			ipam.allocations[key] = ipamAllocation{Label: label, IP: ip.String()}
+			if err := ipam.save(); err != nil {
+				restore()
+				return "", err
+			}
+			log.Debugf("IPAM allocated %s to %s", ip.String(), key)
+			return ip.String(), nil
+		}
+	}
+	restore()
+	return "", fmt.Errorf("No address available for IPAM label '%s'", label)
+}
+
+func (ipam *rangeIPAM) Lookup(key string) (string, bool) {
+	ipam.Lock()
+	defer ipam.Unlock()
+
+	if err := ipam.load(); err != nil {
+		log.Errorf("%v", err)
+		return "", false
+	}
+	alloc, ok := ipam.allocations[key]
+	return alloc.IP, ok
+}
+
+func (ipam *rangeIPAM) Release(key string) error {
+	ipam.Lock()
+	defer ipam.Unlock()
+
+	if err := ipam.load(); err != nil {
+		return err
+	}
+	alloc, ok := ipam.allocations[key]
+	if !ok {
+		return nil
+	}
+	delete(ipam.allocations, key)
+	if err := ipam.save(); err != nil {
+		ipam.allocations[key] = alloc
+		return err
+	}
+	log.Debugf("IPAM released %s from %s", alloc.IP, key)
+	return nil
+}
+
+// load reads the allocations from the ConfigMap once
+func (ipam *rangeIPAM) load() error {
+	if ipam.loaded {
+		return nil
+	}
+	cm, err := ipam.kubeClient.CoreV1().ConfigMaps(ipam.namespace).
+		Get(IPAMConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			ipam.loaded = true
+			return nil
+		}
+		return fmt.Errorf("Failed to read IPAM allocations: %v", err)
+	}
+	if data, ok := cm.Data["allocations"]; ok {
+		if err := json.Unmarshal([]byte(data), &ipam.allocations); err != nil {
+			return fmt.Errorf("Failed to parse IPAM allocations: %v", err)
+		}
+	}
+	ipam.loaded = true
+	return nil
+}
+
+// save writes the allocations to the ConfigMap
+func (ipam *rangeIPAM) save() error {
+	data, err := json.Marshal(ipam.allocations)
+	if err != nil {
+		return err
+	}
+	cmClient := ipam.kubeClient.CoreV1().ConfigMaps(ipam.namespace)
+	cm, err := cmClient.Get(IPAMConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Failed to save IPAM allocations: %v", err)
+		}
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      IPAMConfigMapName,
+				Namespace: ipam.namespace,
+			},
+			Data: map[string]string{"allocations": string(data)},
+		}
+		_, err = cmClient.Create(cm)
+	} else {
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data["allocations"] = string(data)
+		_, err = cmClient.Update(cm)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to save IPAM allocations: %v", err)
+	}
+	return nil
+}
+
+// ipamKey returns the key of the VirtualServer for IPAM
+func ipamKey(vs *cisapiv1.VirtualServer) string {
+	return vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name + "/" + vs.Spec.Host
+}
+
+// usesIPAM returns true if the address of VirtualServer is allocated by IPAM
+// for the VirtualServer, the address of a host group is allocated for the
+// group.
+func (crMgr *CRManager) usesIPAM(vs *cisapiv1.VirtualServer) bool {
+	return nil != crMgr.ipam && vs.Spec.VirtualServerAddress == "" &&
+		vs.Spec.IPAMLabel != "" && vs.Spec.HostGroup == ""
+}
+
+// getVirtualServerAddress returns the VirtualServerAddress, or the address
+// of its host group or allocated by IPAM when not provided.
+func (crMgr *CRManager) getVirtualServerAddress(vs *cisapiv1.VirtualServer) string {
+	if vs.Spec.VirtualServerAddress == "" && vs.Spec.HostGroup != "" {
+		return crMgr.getHostGroupAddress(vs.Spec.HostGroup)
+	}
+	if !crMgr.usesIPAM(vs) {
+		return normalizeAddress(vs.Spec.VirtualServerAddress)
+	}
+	ip, _ := crMgr.ipam.Lookup(ipamKey(vs))
+	return ip
+}
+
+// allocateVirtualServerAddress allocates the address from IPAM for the
+// VirtualServer.
+func (crMgr *CRManager) allocateVirtualServerAddress(
+	vs *cisapiv1.VirtualServer,
+) error {
+	if !crMgr.usesIPAM(vs) {
+		return nil
+	}
+	ip, err := crMgr.ipam.Allocate(ipamKey(vs), vs.Spec.IPAMLabel)
+	if err != nil {
+		return err
+	}
+	log.Debugf("VirtualServer %s uses address %s allocated by IPAM",
+		ipamKey(vs), ip)
+	return nil
+}
+
+// releaseVirtualServerAddress releases the address allocated by IPAM for
+// the VirtualServer.
+func (crMgr *CRManager) releaseVirtualServerAddress(vs *cisapiv1.VirtualServer) {
+	if !crMgr.usesIPAM(vs) {
+		return
+	}
+	if err := crMgr.ipam.Release(ipamKey(vs)); err != nil {
+		log.Errorf("Failed to release address of VirtualServer %s: %v",
+			ipamKey(vs), err)
+	}
+}
@@ -0,0 +1,171 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("IPAM Tests", func() {
+	var fakeClient *k8sfake.Clientset
+
+	BeforeEach(func() {
+		fakeClient = k8sfake.NewSimpleClientset()
+	})
+
+	It("Parses IPAM ranges", func() {
+		ranges, err := parseIPAMRanges([]string{
+			"dev=10.1.1.1-10.1.1.5",
+			"dev=10.1.2.1-10.1.2.1",
+			"prod=2001:db8::1-2001:db8::10",
+		})
+		Expect(err).To(BeNil())
+		Expect(len(ranges["dev"])).To(Equal(2))
+		Expect(len(ranges["prod"])).To(Equal(1))
+
+		for _, rng := range []string{
+			"10.1.1.1-10.1.1.5",
+			"=10.1.1.1-10.1.1.5",
+			"dev=10.1.1.1",
+			"dev=10.1.1.5-10.1.1.1",
+			"dev=10.1.1.1-2001:db8::1",
+			"dev=abc-10.1.1.5",
+		} {
+			_, err := parseIPAMRanges([]string{rng})
+			Expect(err).NotTo(BeNil(), "Range: %s", rng)
+		}
+	})
+
+	It("Allocates and releases addresses", func() {
+		ipam, err := NewRangeIPAM([]string{"dev=10.1.1.254-10.1.2.0"},
+			fakeClient, "kube-system")
+		Expect(err).To(BeNil())
+
+		ip, err := ipam.Allocate("default/vs1/foo.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.1.254"))
+		ip, err = ipam.Allocate("default/vs1/foo.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.1.254"), "Allocation should be reused")
+		ip, err = ipam.Allocate("default/vs2/bar.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.1.255"))
+		ip, err = ipam.Allocate("default/vs3/baz.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.2.0"))
+
+		_, err = ipam.Allocate("default/vs4/qux.com", "dev")
+		Expect(err).NotTo(BeNil(), "Range should be exhausted")
+		_, err = ipam.Allocate("default/vs4/qux.com", "prod")
+		Expect(err).NotTo(BeNil(), "Label is not configured")
+
+		Expect(ipam.Release("default/vs2/bar.com")).To(BeNil())
+		_, found := ipam.Lookup("default/vs2/bar.com")
+		Expect(found).To(BeFalse())
+		ip, err = ipam.Allocate("default/vs4/qux.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.1.255"))
+	})
+
+	It("Releases address of previous host", func() {
+		ipam, _ := NewRangeIPAM([]string{"dev=10.1.1.1-10.1.1.1"},
+			fakeClient, "kube-system")
+		_, err := ipam.Allocate("default/vs1/foo.com", "dev")
+		Expect(err).To(BeNil())
+		ip, err := ipam.Allocate("default/vs1/bar.com", "dev")
+		Expect(err).To(BeNil())
+		Expect(ip).To(Equal("10.1.1.1"))
+		_, found := ipam.Lookup("default/vs1/foo.com")
+		Expect(found).To(BeFalse())
+	})
+
+	It("Persists allocations across restarts", func() {
+		ipam, _ := NewRangeIPAM([]string{"dev=10.1.1.1-10.1.1.10"},
+			fakeClient, "kube-system")
+		_, _ = ipam.Allocate("default/vs1/foo.com", "dev")
+		ip2, _ := ipam.Allocate("default/vs2/bar.com", "dev")
+		Expect(ipam.Release("default/vs1/foo.com")).To(BeNil())
+
+		cm, err := fakeClient.CoreV1().ConfigMaps("kube-system").
+			Get(IPAMConfigMapName, metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(cm.Data["allocations"]).To(ContainSubstring(ip2))
+
+		newIPAM, _ := NewRangeIPAM([]string{"dev=10.1.1.1-10.1.1.10"},
+			fakeClient, "kube-system")
+		ip, found := newIPAM.Lookup("default/vs2/bar.com")
+		Expect(found).To(BeTrue())
+		Expect(ip).To(Equal(ip2))
+		_, found = newIPAM.Lookup("default/vs1/foo.com")
+		Expect(found).To(BeFalse())
+	})
+
+	Context("VirtualServer", func() {
+		var mockCRM *mockCRManager
+		var vs *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			mockCRM = newMockCRManager()
+			mockCRM.ipam, _ = NewRangeIPAM([]string{"dev=10.1.1.1-10.1.1.1"},
+				mockCRM.kubeClient, "kube-system")
+			vs = test.NewVirtualServer(
+				"SampleVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host:      "foo.com",
+					IPAMLabel: "dev",
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+		})
+
+		It("Uses address allocated by IPAM", func() {
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, nil))
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(rsName).To(Equal("f5_crd_virtualserver_10_1_1_1_80"))
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.Virtual.Destination).To(Equal("/test/10.1.1.1:80"))
+
+			mockCRM.releaseVirtualServerAddress(vs)
+			_, found := mockCRM.ipam.Lookup(ipamKey(vs))
+			Expect(found).To(BeFalse())
+		})
+
+		It("Fails VirtualServer when address is not available", func() {
+			_, _ = mockCRM.ipam.Allocate("default/OtherVS/bar.com", "dev")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).NotTo(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("IPAMError"))
+		})
+	})
+})
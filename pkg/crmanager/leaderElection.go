@@ -0,0 +1,203 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// DefaultLeaseName is the name of the Lease of the leader election
+	DefaultLeaseName = "k8s-bigip-ctlr"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// ControllerNamespace returns the namespace the controller runs in, read
+// from its service account.
+func ControllerNamespace() (string, error) {
+	data, err := ioutil.ReadFile(serviceAccountNamespace)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read the namespace of the controller: %v",
+			err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// setupLeaderElection creates the elector of the leader among the
+// controllers using the Lease in the namespace.
+func (crMgr *CRManager) setupLeaderElection(namespace, leaseName string) error {
+	if nil == crMgr.kubeClient {
+		return fmt.Errorf("no kubeClient")
+	}
+	if leaseName == "" {
+		leaseName = DefaultLeaseName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	// The hostname is the name of the pod, the suffix tells apart the
+	// restarts of its container
+	identity := hostname + "_" + newSyncID()
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      leaseName,
+			},
+			Client:     crMgr.kubeClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: crMgr.startLeading,
+			OnStoppedLeading: crMgr.stopLeading,
+			OnNewLeader: func(leader string) {
+				log.Infof("Leader of %s/%s is %s", namespace, leaseName, leader)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Electing the leader with Lease %s/%s as %s",
+		namespace, leaseName, identity)
+	crMgr.leaderElector = elector
+	return nil
+}
+
+// runLeaderElection runs for the leadership until stopCh is closed, again
+// after losing it.
+func (crMgr *CRManager) runLeaderElection(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	for ctx.Err() == nil {
+		crMgr.leaderElector.Run(ctx)
+	}
+}
+
+// isLeader tells whether the resources are processed and the configuration
+// posted, always without leader election.
+func (crMgr *CRManager) isLeader() bool {
+	return !crMgr.LeaderElection || atomic.LoadInt32(&crMgr.leading) == 1
+}
+
+// startLeading processes the resources from scratch: the state of the
+// previous leadership may be stale, so the configs are rebuilt from the
+// resources in the informer caches and the configuration is posted even if
+// unchanged.
+func (crMgr *CRManager) startLeading(ctx context.Context) {
+	crMgr.processingMutex.Lock()
+	if ctx.Err() != nil {
+		// The leadership is already lost
+		crMgr.processingMutex.Unlock()
+		return
+	}
+	crMgr.resetState()
+	// The prune queued once the caches are synced is dropped by the
+	// followers
+	if crMgr.PruneOnStartup && !crMgr.pruned && crMgr.cachesSynced() {
+		crMgr.pruneStale = true
+	}
+	atomic.StoreInt32(&crMgr.leading, 1)
+	crMgr.processingMutex.Unlock()
+
+	log.Infof("Started leading, processing all the resources")
+	// The TLSProfiles are queued before the VirtualServers referring to
+	// them
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.tsInformer.GetIndexer().List() {
+			crMgr.enqueueTLSProfile(obj)
+		}
+	}
+	crMgr.enqueueAllResources()
+}
+
+// stopLeading stops processing the resources and posting the
+// configuration. The resources queued or being processed are dropped, they
+// are queued again when the leadership is acquired again.
+func (crMgr *CRManager) stopLeading() {
+	if atomic.SwapInt32(&crMgr.leading, 0) == 0 {
+		return
+	}
+	log.Infof("Stopped leading, holding the resources")
+	if nil != crMgr.Agent {
+		crMgr.Agent.CancelPosts()
+	}
+	// Waits for the resource being processed
+	crMgr.dropFinalizers()
+	crMgr.processingMutex.Lock()
+	defer crMgr.processingMutex.Unlock()
+	crMgr.configDirty = false
+	crMgr.pendingEvents = 0
+	crMgr.deletedResources = nil
+}
+
+// resetState forgets the configs, including the configs last posted, and
+// the state derived from the resources processed. The caller holds
+// processingMutex.
+func (crMgr *CRManager) resetState() {
+	crMgr.resetConfigs()
+	crMgr.resources.resetOldConfig()
+	if nil != crMgr.Agent {
+		// The members of the next post replace all the ARP entries
+		crMgr.Agent.membersWritten = false
+		crMgr.Agent.removedMembers = nil
+	}
+	crMgr.tlsMutex.Lock()
+	crMgr.TLSContext = make(map[string]*cisapiv1.TLSProfile)
+	crMgr.tlsMutex.Unlock()
+	crMgr.initState = true
+	crMgr.configDirty = false
+	crMgr.pendingEvents = 0
+	crMgr.deletedResources = nil
+
+	crMgr.sslMutex.Lock()
+	crMgr.SSLContext = make(map[string]*v1.Secret)
+	crMgr.sslMutex.Unlock()
+
+	crMgr.statusMutex.Lock()
+	crMgr.vsStatusMap = make(map[string]cisapiv1.VirtualServerStatus)
+	crMgr.vsWarnings = make(map[string]string)
+	crMgr.persistenceWarned = make(map[string]int64)
+	crMgr.statusMutex.Unlock()
+}
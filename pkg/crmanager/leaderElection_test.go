@@ -0,0 +1,160 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("Leader Election Tests", func() {
+	var mockCRM *mockCRManager
+	var postChan chan config
+
+	// newQueue replaces rscQueue, shut down by processWithWorkers
+	newQueue := func() {
+		mockCRM.rscQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller")
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.LeaderElection = true
+		postChan = make(chan config, 1)
+		mockCRM.Agent = &Agent{DeclWriter: &PostManager{postChan: postChan}}
+		mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+	})
+
+	It("Holds the resources until leading", func() {
+		Expect(mockCRM.isLeader()).To(BeFalse())
+		mockCRM.processWithWorkers(1)
+		mockCRM.flushConfig()
+		Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+		Expect(postChan).NotTo(Receive())
+	})
+
+	It("Processes all the resources from scratch when leading", func() {
+		mockCRM.drainQueue()
+		stale := &ResourceConfig{}
+		stale.Virtual.Name = "stale"
+		mockCRM.resources.setResourceConfig(stale)
+
+		mockCRM.startLeading(context.Background())
+		Expect(mockCRM.isLeader()).To(BeTrue())
+		Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		Expect(mockCRM.initState).To(BeTrue())
+		mockCRM.processWithWorkers(1)
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(ContainSubstring(
+			formatVirtualServerName("10.1.1.1", 443)))
+		Expect(cfg.data).NotTo(ContainSubstring("stale"))
+
+		// The unchanged configuration is posted again by the next leadership
+		mockCRM.stopLeading()
+		newQueue()
+		mockCRM.startLeading(context.Background())
+		mockCRM.processWithWorkers(1)
+		mockCRM.flushConfig()
+		var again config
+		Expect(postChan).To(Receive(&again))
+		Expect(again.data).To(Equal(cfg.data))
+	})
+
+	It("Ignores a leadership already lost", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		mockCRM.drainQueue()
+		mockCRM.startLeading(ctx)
+		Expect(mockCRM.isLeader()).To(BeFalse())
+		Expect(mockCRM.rscQueue.Len()).To(BeZero())
+	})
+
+	It("Stops posting when losing the leadership", func() {
+		mockCRM.drainQueue()
+		mockCRM.startLeading(context.Background())
+		mockCRM.processWithWorkers(1)
+		mockCRM.stopLeading()
+		mockCRM.flushConfig()
+		Expect(postChan).NotTo(Receive())
+	})
+
+	It("Cancels the configuration posted", func() {
+		postMgr := &PostManager{postChan: postChan}
+		postMgr.Write(`{}`, []string{"test"})
+		cancelled := <-postChan
+		postMgr.Write(`{}`, []string{"test"})
+		postMgr.Cancel()
+		Expect(postChan).NotTo(Receive())
+		// Dropped without posting, httpClient is nil
+		failed, _ := postMgr.postConfig(cancelled)
+		Expect(failed).To(BeEmpty())
+
+		// The config being posted is aborted
+		requested := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// The abort is noticed once the body is read
+				_, _ = ioutil.ReadAll(r.Body)
+				close(requested)
+				<-r.Context().Done()
+			}))
+		defer server.Close()
+		postMgr.BIGIPURL = server.URL
+		postMgr.httpClient = server.Client()
+		postMgr.Write(`{}`, []string{"test"})
+		failures := make(chan []string)
+		go func() {
+			failed, _ := postMgr.postConfig(<-postChan)
+			failures <- failed
+		}()
+		<-requested
+		postMgr.Cancel()
+		Eventually(failures).Should(Receive(BeEmpty()))
+		Expect(postMgr.Posted()).To(BeZero())
+	})
+
+	It("Elects one leader with the Lease", func() {
+		other := newMockCRManager()
+		other.LeaderElection = true
+		other.kubeClient = mockCRM.kubeClient
+		Expect(mockCRM.setupLeaderElection("default", "")).To(Succeed())
+		Expect(other.setupLeaderElection("default", "")).To(Succeed())
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go mockCRM.runLeaderElection(stopCh)
+		Eventually(mockCRM.isLeader).Should(BeTrue())
+		go other.runLeaderElection(stopCh)
+		Consistently(other.isLeader).Should(BeFalse())
+
+		lease, err := mockCRM.kubeClient.CoordinationV1().Leases("default").Get(
+			DefaultLeaseName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*lease.Spec.HolderIdentity).To(Equal(
+			mockCRM.leaderElector.GetLeader()))
+	})
+})
@@ -0,0 +1,233 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Naming schemes for the BIG-IP objects created for VirtualServers
+const (
+	// CRDNamingScheme names the objects with f5_crd_virtualserver prefix
+	CRDNamingScheme = "crd"
+	// LegacyNamingScheme names the objects the same as the Ingress
+	// processed by CIS without custom resource mode
+	LegacyNamingScheme = "legacy"
+)
+
+// Escaping of the names of the pools and rules of the crd naming scheme
+const (
+	// CompatibleNameEscaping replaces the characters AS3 does not allow
+	// with '_', different services may end up with the same pool name
+	CompatibleNameEscaping = "compatible"
+	// UniqueNameEscaping escapes the characters AS3 does not allow with
+	// their hex code, different services always have different pool names
+	UniqueNameEscaping = "unique"
+)
+
+// Namer formats the names of the BIG-IP objects created for VirtualServers
+type Namer interface {
+	VirtualServerName(ip string, port int32) string
+	PoolName(namespace, svc, port, nodeMemberLabel string) string
+	RuleName(host, path, pool string) string
+	PolicyName(virtualName string) string
+	PolicyPartition(virtualPartition, namespace string) string
+	DefaultSNIProfileName(virtualName string) string
+	// Prefix of the names of the virtuals, the objects named with it are
+	// owned by CIS
+	Prefix() string
+}
+
+// namer used by the format functions, set by NewCRManager
+var namer Namer = crdNamer{}
+
+// NewNamer returns the Namer for the naming scheme, the name escaping
+// applies to the crd naming scheme only
+func NewNamer(scheme, escaping string) (Namer, error) {
+	var unique bool
+	switch escaping {
+	case "", CompatibleNameEscaping:
+	case UniqueNameEscaping:
+		unique = true
+	default:
+		return nil, fmt.Errorf("Invalid name escaping '%s'", escaping)
+	}
+	switch scheme {
+	case "", CRDNamingScheme:
+		return crdNamer{unique: unique}, nil
+	case LegacyNamingScheme:
+		return legacyNamer{}, nil
+	default:
+		return nil, fmt.Errorf("Invalid naming scheme '%s'", scheme)
+	}
+}
+
+// crdNamer with unique set escapes the parts of the pool and rule names
+// and joins them with "__", so that no two services or rules share a name.
+type crdNamer struct {
+	unique bool
+}
+
+func (crdNamer) VirtualServerName(ip string, port int32) string {
+	// Strip any bracket characters; replace special characters ". : /"
+	// with "-" and "%" with ".", for naming purposes
+	ip = strings.Trim(ip, "[]")
+	ip = AS3NameFormatter(ip)
+	return fmt.Sprintf("f5_crd_virtualserver_%s_%d", ip, port)
+}
+
+// The port keeps the pools of the ports of a service apart
+func (nm crdNamer) PoolName(namespace, svc, port, nodeMemberLabel string) string {
+	if nm.unique {
+		parts := []string{namespace, svc}
+		// The port is kept even if empty, else a label might be read as port
+		if port != "" || nodeMemberLabel != "" {
+			parts = append(parts, port)
+		}
+		if nodeMemberLabel != "" {
+			parts = append(parts, nodeMemberLabel)
+		}
+		return joinEscapedNames(parts...)
+	}
+	poolName := fmt.Sprintf("%s_%s", namespace, svc)
+	if port != "" {
+		poolName = fmt.Sprintf("%s_%s", poolName, port)
+	}
+	if nodeMemberLabel != "" {
+		poolName = fmt.Sprintf("%s_%s", poolName, nodeMemberLabel)
+	}
+	return AS3NameFormatter(poolName)
+}
+
+func (nm crdNamer) RuleName(host, path, pool string) string {
+	if nm.unique {
+		// The pool name is escaped already, and being last it needs no more
+		return joinEscapedNames("vs", host, path) + "__" + pool
+	}
+	var rule string
+	if path == "" {
+		rule = fmt.Sprintf("vs_%s_%s", host, pool)
+	} else {
+		// Remove the first slash, then replace any subsequent slashes with '_'
+		path = strings.TrimPrefix(path, "/")
+		path = strings.Replace(path, "/", "_", -1)
+		rule = fmt.Sprintf("vs_%s_%s_%s", host, path, pool)
+	}
+
+	rule = AS3NameFormatter(rule)
+	return rule
+}
+
+func (crdNamer) PolicyName(virtualName string) string {
+	return virtualName + "_policy"
+}
+
+// The policy is placed in a partition named after the namespace
+func (crdNamer) PolicyPartition(virtualPartition, namespace string) string {
+	return namespace
+}
+
+func (crdNamer) DefaultSNIProfileName(virtualName string) string {
+	return fmt.Sprintf("default-clientssl-%s", virtualName)
+}
+
+func (crdNamer) Prefix() string {
+	return "f5_crd_"
+}
+
+// escapeAS3Name keeps letters, digits and '-', and replaces any other
+// byte with '_' followed by its hex code, so '_' never repeats.
+func escapeAS3Name(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// joinEscapedNames escapes the names and joins them with "__", which no
+// escaped name contains, so different names always join differently.
+func joinEscapedNames(names ...string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = escapeAS3Name(name)
+	}
+	return strings.Join(escaped, "__")
+}
+
+// legacyNamer reproduces the names of the Ingress resources, so that the
+// iRules and automation referring them keep working.
+type legacyNamer struct{}
+
+func (legacyNamer) VirtualServerName(ip string, port int32) string {
+	// Strip any bracket characters; replace special characters ". : /"
+	// with "-" and "%" with ".", for naming purposes
+	ip = strings.Trim(ip, "[]")
+	var replacer = strings.NewReplacer(".", "-", ":", "-", "/", "-", "%", ".")
+	ip = replacer.Replace(ip)
+	return fmt.Sprintf("ingress_%s_%d", ip, port)
+}
+
+// Ingress names the pool after the service only, the ports of a service
+// cannot have pools of their own
+func (legacyNamer) PoolName(namespace, svc, port, nodeMemberLabel string) string {
+	poolName := fmt.Sprintf("ingress_%s_%s", namespace, svc)
+	// Ingress does not support node member labels, keep them unique
+	if nodeMemberLabel != "" {
+		poolName = fmt.Sprintf("%s_%s", poolName,
+			AS3NameFormatter(nodeMemberLabel))
+	}
+	return poolName
+}
+
+func (legacyNamer) RuleName(host, path, pool string) string {
+	var rule string
+	if path == "" {
+		rule = fmt.Sprintf("ingress_%s_%s", host, pool)
+	} else {
+		// Remove the first slash, then replace any subsequent slashes with '_'
+		path = strings.TrimPrefix(path, "/")
+		path = strings.Replace(path, "/", "_", -1)
+		rule = fmt.Sprintf("ingress_%s_%s_%s", host, path, pool)
+	}
+	return rule
+}
+
+// Ingress names the policy after its virtual
+func (legacyNamer) PolicyName(virtualName string) string {
+	return virtualName
+}
+
+// Ingress places the policy in the partition of its virtual
+func (legacyNamer) PolicyPartition(virtualPartition, namespace string) string {
+	return virtualPartition
+}
+
+func (legacyNamer) DefaultSNIProfileName(virtualName string) string {
+	return fmt.Sprintf("default-clientssl-%s", virtualName)
+}
+
+func (legacyNamer) Prefix() string {
+	return "ingress_"
+}
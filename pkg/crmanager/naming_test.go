@@ -0,0 +1,210 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Golden names for the naming schemes, these must not change as BIG-IP
+// objects and the automation around them are keyed to them.
+var _ = Describe("Naming Scheme Tests", func() {
+	type virtualName struct {
+		ip   string
+		port int32
+		crd  string
+		lgcy string
+	}
+	type poolName struct {
+		namespace string
+		svc       string
+		port      string
+		label     string
+		crd       string
+		lgcy      string
+	}
+	type ruleName struct {
+		host string
+		path string
+		pool string
+		crd  string
+		lgcy string
+	}
+
+	virtualNames := []virtualName{
+		{"1.2.3.4", 80, "f5_crd_virtualserver_1_2_3_4_80", "ingress_1-2-3-4_80"},
+		{"1.2.3.4%2", 443, "f5_crd_virtualserver_1_2_3_4.2_443", "ingress_1-2-3-4.2_443"},
+		{"2001:db8::5", 80, "f5_crd_virtualserver_2001_db8__5_80", "ingress_2001-db8--5_80"},
+		{"[2001:db8::5]", 443, "f5_crd_virtualserver_2001_db8__5_443", "ingress_2001-db8--5_443"},
+	}
+	poolNames := []poolName{
+		{"default", "svc1", "", "", "default_svc1", "ingress_default_svc1"},
+		{"my-ns", "my-svc", "", "", "my_ns_my_svc", "ingress_my-ns_my-svc"},
+		{"default", "svc1", "", "app=web", "default_svc1_app_web", "ingress_default_svc1_app_web"},
+		{"default", "svc1", "80", "", "default_svc1_80", "ingress_default_svc1"},
+		{"default", "svc1", "http", "app=web", "default_svc1_http_app_web", "ingress_default_svc1_app_web"},
+	}
+	ruleNames := []ruleName{
+		{"foo.com", "", "default_svc1", "vs_foo_com_default_svc1", "ingress_foo.com_default_svc1"},
+		{"foo.com", "/bar/baz", "default_svc1", "vs_foo_com_bar_baz_default_svc1", "ingress_foo.com_bar_baz_default_svc1"},
+		{"*.foo.com", "/bar", "default_svc1", "vs_*_foo_com_bar_default_svc1", "ingress_*.foo.com_bar_default_svc1"},
+	}
+
+	It("Validates naming scheme", func() {
+		for _, scheme := range []string{"", CRDNamingScheme, LegacyNamingScheme} {
+			_, err := NewNamer(scheme, "")
+			Expect(err).To(BeNil(), "Scheme: %s", scheme)
+		}
+		_, err := NewNamer("custom", "")
+		Expect(err).NotTo(BeNil())
+		for _, escaping := range []string{"", CompatibleNameEscaping, UniqueNameEscaping} {
+			_, err := NewNamer(CRDNamingScheme, escaping)
+			Expect(err).To(BeNil(), "Escaping: %s", escaping)
+		}
+		_, err = NewNamer(CRDNamingScheme, "hex")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Formats names with crd scheme", func() {
+		nm, _ := NewNamer(CRDNamingScheme, "")
+		for _, td := range virtualNames {
+			Expect(nm.VirtualServerName(td.ip, td.port)).To(Equal(td.crd))
+		}
+		for _, td := range poolNames {
+			Expect(nm.PoolName(td.namespace, td.svc, td.port, td.label)).To(Equal(td.crd))
+		}
+		for _, td := range ruleNames {
+			Expect(nm.RuleName(td.host, td.path, td.pool)).To(Equal(td.crd))
+		}
+		Expect(nm.PolicyName("f5_crd_virtualserver_1_2_3_4_80")).To(
+			Equal("f5_crd_virtualserver_1_2_3_4_80_policy"))
+		Expect(nm.PolicyPartition("test", "default")).To(Equal("default"))
+		Expect(nm.DefaultSNIProfileName("f5_crd_virtualserver_1_2_3_4_443")).To(
+			Equal("default-clientssl-f5_crd_virtualserver_1_2_3_4_443"))
+	})
+
+	It("Formats names with legacy scheme", func() {
+		nm, _ := NewNamer(LegacyNamingScheme, "")
+		for _, td := range virtualNames {
+			Expect(nm.VirtualServerName(td.ip, td.port)).To(Equal(td.lgcy))
+		}
+		for _, td := range poolNames {
+			Expect(nm.PoolName(td.namespace, td.svc, td.port, td.label)).To(Equal(td.lgcy))
+		}
+		for _, td := range ruleNames {
+			Expect(nm.RuleName(td.host, td.path, td.pool)).To(Equal(td.lgcy))
+		}
+		Expect(nm.PolicyName("ingress_1-2-3-4_80")).To(Equal("ingress_1-2-3-4_80"))
+		Expect(nm.PolicyPartition("test", "default")).To(Equal("test"))
+		Expect(nm.DefaultSNIProfileName("ingress_1-2-3-4_443")).To(
+			Equal("default-clientssl-ingress_1-2-3-4_443"))
+	})
+
+	It("Formats unique names with crd scheme", func() {
+		nm, _ := NewNamer(CRDNamingScheme, UniqueNameEscaping)
+		// Virtual names are not affected by the escaping
+		for _, td := range virtualNames {
+			Expect(nm.VirtualServerName(td.ip, td.port)).To(Equal(td.crd))
+		}
+		Expect(nm.PoolName("default", "svc1", "", "")).To(Equal("default__svc1"))
+		Expect(nm.PoolName("my-ns", "my-svc", "80", "")).To(Equal("my-ns__my-svc__80"))
+		Expect(nm.PoolName("default", "svc1", "", "app=web")).To(
+			Equal("default__svc1____app_3dweb"))
+		Expect(nm.RuleName("foo.com", "/bar", "default__svc1__80")).To(
+			Equal("vs__foo_2ecom___2fbar__default__svc1__80"))
+		Expect(nm.RuleName("*.foo.com", "", "default__svc1__80")).To(
+			Equal("vs___2a_2efoo_2ecom____default__svc1__80"))
+	})
+
+	It("Keeps the pool names of different services apart", func() {
+		compatible, _ := NewNamer(CRDNamingScheme, CompatibleNameEscaping)
+		unique, _ := NewNamer(CRDNamingScheme, UniqueNameEscaping)
+		collisions := [][2][4]string{
+			{{"default", "my-svc", "80", ""}, {"default", "my.svc", "80", ""}},
+			{{"team-a", "web", "80", ""}, {"team", "a-web", "80", ""}},
+			{{"default", "svc", "80", ""}, {"default", "svc", "", "80"}},
+			{{"default", "svc", "", "app=web"}, {"default", "svc", "", "app_web"}},
+		}
+		for _, td := range collisions {
+			a, b := td[0], td[1]
+			Expect(compatible.PoolName(a[0], a[1], a[2], a[3])).To(
+				Equal(compatible.PoolName(b[0], b[1], b[2], b[3])))
+			Expect(unique.PoolName(a[0], a[1], a[2], a[3])).NotTo(
+				Equal(unique.PoolName(b[0], b[1], b[2], b[3])), "Pools: %v", td)
+		}
+		Expect(unique.RuleName("foo.com", "/a-b", "pool")).NotTo(
+			Equal(unique.RuleName("foo.com", "/a_b", "pool")))
+		Expect(unique.RuleName("foo.com", "", "a__pool")).NotTo(
+			Equal(unique.RuleName("foo.com__a", "", "pool")))
+	})
+
+	Context("Resource Config", func() {
+		var mockCRM *mockCRManager
+		var vs *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			mockCRM = newMockCRManager()
+			vs = test.NewVirtualServer(
+				"SampleVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host:                 "foo.com",
+					VirtualServerAddress: "1.2.3.4",
+					Pools: []cisapiv1.Pool{
+						{Path: "/bar", Service: "svc1", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+		})
+
+		AfterEach(func() {
+			namer = crdNamer{}
+		})
+
+		It("Creates resource config with legacy names", func() {
+			namer = legacyNamer{}
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs,
+				portStruct{protocol: "http", port: DEFAULT_HTTP_PORT})
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.Name).To(Equal("ingress_1-2-3-4_80"))
+			Expect(rsCfg.Pools[0].Name).To(Equal("ingress_default_svc1"))
+			Expect(rsCfg.Policies[0].Name).To(Equal("ingress_1-2-3-4_80"))
+			Expect(rsCfg.Policies[0].Partition).To(Equal("test"))
+			Expect(rsCfg.Policies[0].Rules[0].Name).To(
+				Equal("ingress_foo.com_bar_ingress_default_svc1"))
+			Expect(rsCfg.Policies[0].Rules[0].Actions[0].Pool).To(
+				Equal("ingress_default_svc1"))
+		})
+
+		It("Creates resource config with crd names", func() {
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs,
+				portStruct{protocol: "http", port: DEFAULT_HTTP_PORT})
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.Name).To(Equal("f5_crd_virtualserver_1_2_3_4_80"))
+			Expect(rsCfg.Pools[0].Name).To(Equal("default_svc1_80"))
+			Expect(rsCfg.Policies[0].Name).To(
+				Equal("f5_crd_virtualserver_1_2_3_4_80_policy"))
+			Expect(rsCfg.Policies[0].Partition).To(Equal("default"))
+			Expect(rsCfg.Policies[0].Rules[0].Name).To(
+				Equal("vs_foo_com_bar_default_svc1_80"))
+		})
+	})
+})
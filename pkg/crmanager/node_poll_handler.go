@@ -3,7 +3,9 @@ package crmanager
 import (
 	"fmt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func (crMgr *CRManager) SetupNodePolling(
@@ -65,8 +68,29 @@ func (crMgr *CRManager) SetupNodePolling(
 }
 
 type Node struct {
-	Name string
-	Addr string
+	Name    string
+	Addr    string
+	PodCIDR string
+	// Unschedulable or not ready, only with FilterUnhealthy
+	Unhealthy bool
+}
+
+// taintNodeNotReady is the taint of the nodes whose Ready condition is not
+// true
+const taintNodeNotReady = "node.kubernetes.io/not-ready"
+
+// isUnhealthyNode returns true if the node is unschedulable or not ready,
+// its node port does not get the traffic of the pools.
+func isUnhealthyNode(node *v1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == taintNodeNotReady {
+			return true
+		}
+	}
+	return false
 }
 
 // Check for a change in Node state
@@ -113,6 +137,17 @@ func (crMgr *CRManager) ProcessNodeUpdate(
 	}
 }
 
+// getPodNetworks returns the pod networks of the nodes.
+func (crMgr *CRManager) getPodNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, node := range crMgr.getNodesFromCache() {
+		if _, network, err := net.ParseCIDR(node.PodCIDR); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
 // Return a copy of the node cache
 func (crMgr *CRManager) getNodesFromCache() []Node {
 	nodes := make([]Node, len(crMgr.oldNodes))
@@ -147,8 +182,11 @@ func (crMgr *CRManager) getNodes(
 		for _, addr := range nodeAddrs {
 			if addr.Type == addrType {
 				n := Node{
-					Name: node.ObjectMeta.Name,
-					Addr: addr.Address,
+					Name:    node.ObjectMeta.Name,
+					Addr:    addr.Address,
+					PodCIDR: node.Spec.PodCIDR,
+					Unhealthy: crMgr.FilterUnhealthy &&
+						isUnhealthyNode(&node),
 				}
 				watchedNodes = append(watchedNodes, n)
 			}
@@ -158,12 +196,40 @@ func (crMgr *CRManager) getNodes(
 	return watchedNodes, nil
 }
 
+// getNodesWithLabel returns the nodes selected by the label selector, from
+// the node informer cache when running.
 func (crMgr *CRManager) getNodesWithLabel(
 	nodeMemberLabel string,
 ) []Node {
-	nodeList, _ := crMgr.kubeClient.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: nodeMemberLabel})
+	var items []v1.Node
+	if nil != crMgr.nodeInformer {
+		selector, err := labels.Parse(nodeMemberLabel)
+		if nil != err {
+			log.Warningf("Invalid node selector '%s', err=%+v",
+				nodeMemberLabel, err)
+			return nil
+		}
+		for _, obj := range crMgr.nodeInformer.GetStore().List() {
+			node := obj.(*v1.Node)
+			if selector.Matches(labels.Set(node.ObjectMeta.Labels)) {
+				items = append(items, *node)
+			}
+		}
+		// The store is not ordered, the members are kept in node order
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].ObjectMeta.Name < items[j].ObjectMeta.Name
+		})
+	} else {
+		nodeList, err := crMgr.kubeClient.CoreV1().Nodes().List(
+			metav1.ListOptions{LabelSelector: nodeMemberLabel})
+		if nil != err {
+			log.Warningf("Unable to list the nodes, err=%+v", err)
+			return nil
+		}
+		items = nodeList.Items
+	}
 
-	nodes, err := crMgr.getNodes(nodeList.Items)
+	nodes, err := crMgr.getNodes(items)
 	if nil != err {
 		log.Warningf("Unable to get list of nodes, err=%+v", err)
 		return nil
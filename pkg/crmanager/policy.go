@@ -0,0 +1,83 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+)
+
+// getPolicyForVirtualServer returns the Policy the VirtualServer refers to
+// from the informer cache of its namespace.
+func (crMgr *CRManager) getPolicyForVirtualServer(
+	vs *cisapiv1.VirtualServer,
+) (*cisapiv1.Policy, bool) {
+	if vs.Spec.PolicyName == "" {
+		return nil, false
+	}
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok || nil == crInf.plcInformer {
+		return nil, false
+	}
+	obj, found, _ := crInf.plcInformer.GetIndexer().GetByKey(
+		vs.ObjectMeta.Namespace + "/" + vs.Spec.PolicyName)
+	if !found {
+		return nil, false
+	}
+	return obj.(*cisapiv1.Policy), true
+}
+
+// applyPolicy returns a copy of the VirtualServer with the settings of the
+// Policy, the settings of the VirtualServer take precedence.
+func applyPolicy(
+	vs *cisapiv1.VirtualServer,
+	plc *cisapiv1.Policy,
+) *cisapiv1.VirtualServer {
+	if nil == plc {
+		return vs
+	}
+	vs = vs.DeepCopy()
+	if len(vs.Spec.IRules) == 0 {
+		vs.Spec.IRules = append([]string(nil), plc.Spec.IRules...)
+	}
+	if vs.Spec.SNAT == "" {
+		vs.Spec.SNAT = plc.Spec.SNAT
+	}
+	if vs.Spec.PersistenceProfile == "" {
+		vs.Spec.PersistenceProfile = plc.Spec.PersistenceProfile
+	}
+	profiles := &vs.Spec.Profiles
+	if profiles.HTTP == "" {
+		profiles.HTTP = plc.Spec.Profiles.HTTP
+	}
+	if profiles.TCP.Client == "" {
+		profiles.TCP.Client = plc.Spec.Profiles.TCP.Client
+	}
+	if profiles.TCP.Server == "" {
+		profiles.TCP.Server = plc.Spec.Profiles.TCP.Server
+	}
+	return vs
+}
+
+// enqueueVirtualServersForPolicy adds the VirtualServers referring the
+// Policy to rscQueue.
+func (crMgr *CRManager) enqueueVirtualServersForPolicy(plc *cisapiv1.Policy) {
+	crMgr.enqueueVirtualServersForDependency(ObjectDependency{
+		Kind:      CustomPolicy,
+		Namespace: plc.ObjectMeta.Namespace,
+		Name:      plc.ObjectMeta.Name,
+	})
+}
@@ -0,0 +1,156 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Policy", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+	var plc *cisapiv1.Policy
+	var oldPartition string
+
+	BeforeEach(func() {
+		oldPartition = DEFAULT_PARTITION
+		DEFAULT_PARTITION = "test"
+		mockCRM = newMockCRManager()
+		mockCRM.addService(test.NewService("svc1", "1", "default",
+			v1.ServiceTypeClusterIP, nil))
+		vs = test.NewVirtualServer(
+			"SampleVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host:                 "test.com",
+				VirtualServerAddress: "1.2.3.4",
+				PolicyName:           "SamplePolicy",
+				Pools: []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				},
+			},
+		)
+		plc = test.NewPolicy(
+			"SamplePolicy",
+			"default",
+			cisapiv1.PolicySpec{
+				Profiles: cisapiv1.ProfileSpec{
+					HTTP: "/Common/http-xff",
+				},
+				IRules:             []string{"/Common/geo_steering"},
+				SNAT:               SNATNone,
+				PersistenceProfile: "/Common/my_persist",
+				FirewallPolicy:     "/Common/fw_policy",
+			},
+		)
+	})
+
+	AfterEach(func() {
+		DEFAULT_PARTITION = oldPartition
+	})
+
+	getVirtual := func() Virtual {
+		rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+		Expect(ok).To(BeTrue())
+		return rsCfg.Virtual
+	}
+
+	It("Applies the settings of the Policy", func() {
+		mockCRM.addPolicy(plc)
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		virtual := getVirtual()
+		Expect(virtual.IRules).To(Equal([]string{"/Common/geo_steering"}))
+		Expect(virtual.SourceAddrTranslation).To(Equal(
+			SourceAddrTranslation{Type: SNATNone}))
+		Expect(virtual.PersistenceProfile).To(Equal("/Common/my_persist"))
+		Expect(virtual.Firewall).To(Equal("/Common/fw_policy"))
+		Expect(virtual.Profiles).To(Equal(ProfileRefs{
+			{Name: "http-xff", Partition: "Common", Context: CustomProfileAll,
+				Namespace: "default", Type: ProfileTypeHTTP},
+		}))
+		Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+
+		rsCfg, _ := mockCRM.resources.GetByName("test", virtual.Name)
+		sharedApp := as3Application{}
+		createServiceDecl(rsCfg, sharedApp)
+		svc := sharedApp[virtual.Name].(*as3Service)
+		Expect(svc.PolicyFirewallEnforced).To(Equal(
+			&as3ResourcePointer{BigIP: "/Common/fw_policy"}))
+	})
+
+	It("Overrides the Policy with the settings of the VirtualServer", func() {
+		mockCRM.addPolicy(plc)
+		vs.Spec.IRules = []string{"scrub_headers"}
+		vs.Spec.SNAT = SNATAutomap
+		vs.Spec.PersistenceProfile = PersistenceSourceAddr
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		virtual := getVirtual()
+		Expect(virtual.IRules).To(Equal([]string{"/test/scrub_headers"}))
+		Expect(virtual.SourceAddrTranslation).To(Equal(
+			SourceAddrTranslation{Type: SNATAutomap}))
+		Expect(virtual.PersistenceProfile).To(Equal(PersistenceSourceAddr))
+		Expect(virtual.Firewall).To(Equal("/Common/fw_policy"))
+	})
+
+	It("Processes the VirtualServer without a missing Policy", func() {
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		virtual := getVirtual()
+		Expect(virtual.IRules).To(BeEmpty())
+		Expect(virtual.Firewall).To(BeEmpty())
+		events := mockCRM.getFakeEvents("default")
+		Expect(len(events)).To(Equal(1))
+		Expect(events[0].Reason).To(Equal("PolicyNotFound"))
+	})
+
+	It("Enqueues the VirtualServers referring the Policy", func() {
+		other := test.NewVirtualServer(
+			"OtherVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host:                 "other.com",
+				VirtualServerAddress: "1.2.3.5",
+				Pools: []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				},
+			},
+		)
+		mockCRM.addVirtualServer(vs)
+		mockCRM.addVirtualServer(other)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		Expect(mockCRM.syncVirtualServer(other)).To(BeNil())
+		mockCRM.drainQueue()
+
+		mockCRM.addPolicy(plc)
+		mockCRM.enqueueVirtualServersForPolicy(plc)
+		keys := mockCRM.drainQueue()
+		Expect(len(keys)).To(Equal(1))
+		Expect(keys[0].kind).To(Equal(VirtualServer))
+		Expect(keys[0].rscName).To(Equal("SampleVS"))
+
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		Expect(getVirtual().Firewall).To(Equal("/Common/fw_policy"))
+	})
+})
@@ -0,0 +1,256 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+// Port mappings of the virtuals listening on several ports
+const (
+	// PortMappingSame sends the requests of all the ports of the virtual to
+	// the port of the pool members
+	PortMappingSame = "same"
+	// PortMappingOffset sends the requests of the first port of the virtual
+	// plus n to the port of the pool members plus n
+	PortMappingOffset = "offset"
+)
+
+// First AS3 version declaring virtuals with a port list
+const (
+	portListAS3Major = 3
+	portListAS3Minor = 36
+)
+
+// parsePortRange returns the first and last ports of the range of the form
+// start-end
+func parsePortRange(rng string) (int32, int32, error) {
+	parts := strings.SplitN(rng, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid port range '%s', expected "+
+			"<start_port>-<end_port>", rng)
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	end, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("Invalid port range '%s', expected "+
+			"<start_port>-<end_port> within 1-65535", rng)
+	}
+	return int32(start), int32(end), nil
+}
+
+// formatPortList returns the port list of the ports, the consecutive ports
+// grouped in ranges like 30000-30100
+func formatPortList(ports []int32) []string {
+	sorted := append([]int32(nil), ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var portList []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			portList = append(portList, strconv.Itoa(int(sorted[i])))
+		} else {
+			portList = append(portList,
+				fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return portList
+}
+
+// listenOnPorts returns the configs of a virtual listening on the ports,
+// first being the config of the first port with its pool members. When
+// portList is true first listens on all the ports with a port list, unless
+// the offset mapping gives the members other ports than the ports of the
+// requests. Otherwise newConfig creates the config of each other port, the
+// configs sharing the pools of first with the same port mapping. With the
+// offset mapping they have pools of their own, the ports of the members
+// shifted by the offset of the port.
+func listenOnPorts(
+	first *ResourceConfig,
+	ports []int32,
+	mapping string,
+	portList bool,
+	newConfig func(port int32) *ResourceConfig,
+) ResourceConfigs {
+	rsCfgs := ResourceConfigs{first}
+	if len(ports) < 2 {
+		return rsCfgs
+	}
+	offset := mapping == PortMappingOffset
+	if portList && (!offset || membersOnPort(first, ports[0])) {
+		first.Virtual.PortList = formatPortList(ports)
+		// The members of the offset mapping get the port of the request
+		first.Virtual.KeepClientPort = offset
+		return rsCfgs
+	}
+
+	for _, port := range ports[1:] {
+		rsCfg := newConfig(port)
+		rsCfg.MetaData.Active = first.MetaData.Active
+		rsCfg.Virtual.Enabled = first.Virtual.Enabled
+		for i := range rsCfg.Pools {
+			pool := &rsCfg.Pools[i]
+			if !offset {
+				pool.Members = append([]Member(nil), first.Pools[i].Members...)
+				continue
+			}
+			shift := port - ports[0]
+			pool.Members = nil
+			for _, member := range first.Pools[i].Members {
+				if member.Port+shift > 65535 {
+					log.Warningf("Pool member %s:%d has no port for the "+
+						"port %d of virtual %s", member.Address, member.Port,
+						port, rsCfg.Virtual.Name)
+					continue
+				}
+				member.Port += shift
+				pool.Members = append(pool.Members, member)
+			}
+			name := fmt.Sprintf("%s_%d", pool.Name, port)
+			if rsCfg.Virtual.PoolName == pool.Name {
+				rsCfg.Virtual.PoolName = name
+			}
+			pool.Name = name
+		}
+		rsCfgs = append(rsCfgs, rsCfg)
+	}
+	return rsCfgs
+}
+
+// membersOnPort returns true if all the pool members of the config are on
+// the port
+func membersOnPort(rsCfg *ResourceConfig, port int32) bool {
+	for _, pool := range rsCfg.Pools {
+		for _, member := range pool.Members {
+			if member.Port != port {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// listensOn returns true if the virtual listens on the port, the port of
+// its destination or one of its port list
+func (v *Virtual) listensOn(port int32) bool {
+	if v.VirtualAddress != nil && v.VirtualAddress.Port == port {
+		return true
+	}
+	for _, p := range v.PortList {
+		start, end, err := parsePortRange(p)
+		if err != nil {
+			n, _ := strconv.Atoi(p)
+			start, end = int32(n), int32(n)
+		}
+		if start <= port && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// createPortListDecl declares the port list of the virtual and returns the
+// pointer to use as the port of its service
+func createPortListDecl(
+	cfg *ResourceConfig,
+	sharedApp as3Application,
+) *as3ResourcePointer {
+	portList := &as3PortList{Class: "Net_Port_List"}
+	for _, p := range cfg.Virtual.PortList {
+		if port, err := strconv.Atoi(p); err == nil {
+			portList.Ports = append(portList.Ports, port)
+		} else {
+			portList.Ports = append(portList.Ports, p)
+		}
+	}
+	name := cfg.Virtual.Name + "_ports"
+	sharedApp[name] = portList
+	return &as3ResourcePointer{Use: name}
+}
+
+// SupportsPortLists returns true if the virtuals listening on several ports
+// are declared with a port list, instead of a virtual per port.
+func (agent *Agent) SupportsPortLists() bool {
+	return agent != nil && agent.portListSupported
+}
+
+// checkPortListSupport checks the AS3 version of BIG-IP for the port lists,
+// the virtuals are declared per port if it cannot be found.
+func (agent *Agent) checkPortListSupport() {
+	major, minor, err := agent.PostManager.getAS3Version()
+	if err != nil {
+		log.Warningf("[AS3] Unable to get the AS3 version, virtuals "+
+			"listening on several ports are declared per port: %v", err)
+		return
+	}
+	agent.portListSupported = major > portListAS3Major ||
+		major == portListAS3Major && minor >= portListAS3Minor
+	log.Debugf("[AS3] BIG-IP is serving AS3 version %d.%d, port lists "+
+		"supported: %v", major, minor, agent.portListSupported)
+}
+
+// getAS3Version returns the major and minor versions of AS3 on BIG-IP
+func (postMgr *PostManager) getAS3Version() (int, int, error) {
+	req, err := http.NewRequest("GET",
+		postMgr.BIGIPURL+"/mgmt/shared/appsvcs/info", nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
+	httpResp, err := postMgr.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("Error response from BIG-IP with status "+
+			"code %v", httpResp.StatusCode)
+	}
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(&info); err != nil {
+		return 0, 0, err
+	}
+	return parseAS3Version(info.Version)
+}
+
+// parseAS3Version returns the major and minor versions of the AS3 version
+// like 3.36.0
+func parseAS3Version(version string) (int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("Invalid AS3 version '%s'", version)
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("Invalid AS3 version '%s'", version)
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,172 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Port List Tests", func() {
+	newConfig := func(port int32) *ResourceConfig {
+		rsCfg := &ResourceConfig{}
+		rsCfg.Virtual.Name = fmt.Sprintf("vs_%d", port)
+		rsCfg.Virtual.Enabled = true
+		rsCfg.Virtual.PoolName = "pool1"
+		rsCfg.Virtual.SetVirtualAddress("1.2.3.4", port)
+		rsCfg.Pools = Pools{{Name: "pool1"}}
+		return rsCfg
+	}
+	firstConfig := func(memberPort int32) *ResourceConfig {
+		rsCfg := newConfig(30000)
+		rsCfg.Pools[0].Members = []Member{
+			{Address: "10.1.0.1", Port: memberPort},
+			{Address: "10.1.0.2", Port: memberPort},
+		}
+		return rsCfg
+	}
+	ports := []int32{30000, 30001, 30002}
+
+	It("Formats port lists", func() {
+		Expect(formatPortList([]int32{30002, 8080, 30000, 30001, 30005})).To(
+			Equal([]string{"8080", "30000-30002", "30005"}))
+		Expect(formatPortList([]int32{80})).To(Equal([]string{"80"}))
+
+		start, end, err := parsePortRange("30000-30100")
+		Expect(err).To(BeNil())
+		Expect([]int32{start, end}).To(Equal([]int32{30000, 30100}))
+		for _, rng := range []string{"30000", "30100-30000", "0-10",
+			"1-65536", "a-b"} {
+			_, _, err := parsePortRange(rng)
+			Expect(err).NotTo(BeNil(), rng)
+		}
+	})
+
+	It("Listens on the port list with the same port", func() {
+		first := firstConfig(8080)
+		rsCfgs := listenOnPorts(first, ports, PortMappingSame, true, newConfig)
+		Expect(rsCfgs).To(Equal(ResourceConfigs{first}))
+		Expect(first.Virtual.PortList).To(Equal([]string{"30000-30002"}))
+		Expect(first.Virtual.KeepClientPort).To(BeFalse())
+		Expect(first.Virtual.listensOn(30001)).To(BeTrue())
+		Expect(first.Virtual.listensOn(30003)).To(BeFalse())
+	})
+
+	It("Expands the ports sharing the pool with the same port", func() {
+		first := firstConfig(8080)
+		rsCfgs := listenOnPorts(first, ports, PortMappingSame, false, newConfig)
+		Expect(len(rsCfgs)).To(Equal(3))
+		for i, rsCfg := range rsCfgs {
+			Expect(rsCfg.Virtual.Name).To(Equal(
+				fmt.Sprintf("vs_%d", ports[i])))
+			Expect(rsCfg.Virtual.PortList).To(BeEmpty())
+			Expect(rsCfg.Virtual.listensOn(ports[i])).To(BeTrue())
+			Expect(rsCfg.Virtual.PoolName).To(Equal("pool1"))
+			Expect(rsCfg.Pools).To(Equal(first.Pools))
+		}
+	})
+
+	It("Expands the ports with pools of their own with the offset", func() {
+		first := firstConfig(40000)
+		first.Pools[0].Members[1].Port = 65534
+		rsCfgs := listenOnPorts(first, ports, PortMappingOffset, true,
+			newConfig)
+		Expect(len(rsCfgs)).To(Equal(3))
+		Expect(first.Virtual.PortList).To(BeEmpty())
+		Expect(first.Virtual.PoolName).To(Equal("pool1"))
+
+		second := rsCfgs[1]
+		Expect(second.Virtual.PoolName).To(Equal("pool1_30001"))
+		Expect(second.Pools[0].Name).To(Equal("pool1_30001"))
+		Expect(second.Pools[0].Members).To(Equal([]Member{
+			{Address: "10.1.0.1", Port: 40001},
+			{Address: "10.1.0.2", Port: 65535},
+		}))
+		// The member without the port of the offset is left out
+		Expect(rsCfgs[2].Pools[0].Members).To(Equal([]Member{
+			{Address: "10.1.0.1", Port: 40002},
+		}))
+		Expect(first.Pools[0].Members[0].Port).To(Equal(int32(40000)))
+	})
+
+	It("Listens on the port list with the offset to the same ports", func() {
+		first := firstConfig(30000)
+		rsCfgs := listenOnPorts(first, ports, PortMappingOffset, true,
+			newConfig)
+		Expect(rsCfgs).To(Equal(ResourceConfigs{first}))
+		Expect(first.Virtual.PortList).To(Equal([]string{"30000-30002"}))
+		Expect(first.Virtual.KeepClientPort).To(BeTrue())
+	})
+
+	It("Declares the port list of the virtual", func() {
+		rsCfg := firstConfig(8080)
+		rsCfg.Virtual.PortList = []string{"8080", "30000-30002"}
+		rsCfg.Virtual.KeepClientPort = true
+		sharedApp := as3Application{}
+		createServiceDecl(rsCfg, sharedApp)
+
+		svc := sharedApp["vs_30000"].(*as3Service)
+		Expect(svc.VirtualPort).To(Equal(
+			&as3ResourcePointer{Use: "vs_30000_ports"}))
+		Expect(svc.TranslateServerPort).To(BeFalse())
+		Expect(sharedApp["vs_30000_ports"]).To(Equal(&as3PortList{
+			Class: "Net_Port_List",
+			Ports: []as3MultiTypeParam{8080, "30000-30002"},
+		}))
+
+		rsCfg = firstConfig(8080)
+		createServiceDecl(rsCfg, sharedApp)
+		svc = sharedApp["vs_30000"].(*as3Service)
+		Expect(svc.VirtualPort).To(Equal(30000))
+		Expect(svc.TranslateServerPort).To(BeTrue())
+	})
+
+	It("Checks the AS3 version for the port lists", func() {
+		version := "3.36.0"
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).To(Equal("/mgmt/shared/appsvcs/info"))
+				w.WriteHeader(status)
+				fmt.Fprintf(w, `{"version": "%s"}`, version)
+			}))
+		defer server.Close()
+
+		var agent *Agent
+		Expect(agent.SupportsPortLists()).To(BeFalse())
+		newAgent := func() *Agent {
+			agent := &Agent{PostManager: &PostManager{
+				httpClient: http.DefaultClient,
+				PostParams: PostParams{BIGIPURL: server.URL},
+			}}
+			agent.checkPortListSupport()
+			return agent
+		}
+		Expect(newAgent().SupportsPortLists()).To(BeTrue())
+		version = "4.0.1"
+		Expect(newAgent().SupportsPortLists()).To(BeTrue())
+		version = "3.9.1"
+		Expect(newAgent().SupportsPortLists()).To(BeFalse())
+		version = "3.36.0"
+		status = http.StatusNotFound
+		Expect(newAgent().SupportsPortLists()).To(BeFalse())
+	})
+})
@@ -18,14 +18,19 @@ package crmanager
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 )
 
@@ -40,6 +45,21 @@ type PostManager struct {
 	postChan   chan config
 	httpClient *http.Client
 	PostParams
+	// Guards generation, cancelPost, written, posted, partitionsPosted,
+	// lastSuccess and lastFailure
+	cancelMutex sync.Mutex
+	// Incremented by Cancel, the configs written before are not posted
+	generation int
+	// Cancels the config being posted
+	cancelPost context.CancelFunc
+	// Sequence numbers of the last config written and posted
+	written uint64
+	posted  uint64
+	// Sequence numbers of the last config posted of each partition
+	partitionsPosted map[string]uint64
+	// Times of the last successful and failed posts
+	lastSuccess time.Time
+	lastFailure time.Time
 }
 
 type PostParams struct {
@@ -56,7 +76,31 @@ type PostParams struct {
 type config struct {
 	data      string
 	routesMap map[string][]string
-	as3APIURL string
+	// Partitions of the declaration to post, each is posted on its own
+	partitions []string
+	generation int
+	seq        uint64
+}
+
+// merge returns cfg posting the partitions of the older config as well,
+// unless the older one is cancelled. The declaration of cfg is complete,
+// it replaces the older one.
+func (cfg config) merge(older config) config {
+	if older.generation != cfg.generation {
+		return cfg
+	}
+	merged := make(map[string]bool)
+	var partitions []string
+	for _, partition := range append(append([]string{}, cfg.partitions...),
+		older.partitions...) {
+		if !merged[partition] {
+			merged[partition] = true
+			partitions = append(partitions, partition)
+		}
+	}
+	sort.Strings(partitions)
+	cfg.partitions = partitions
+	return cfg
 }
 
 func NewPostManager(params PostParams) *PostManager {
@@ -110,25 +154,113 @@ func (postMgr *PostManager) Write(
 	data string,
 	partitions []string,
 ) {
+	postMgr.cancelMutex.Lock()
+	postMgr.written++
 	activeConfig := config{
-		data:      data,
-		as3APIURL: postMgr.getAS3APIURL(partitions),
+		data:       data,
+		partitions: partitions,
+		generation: postMgr.generation,
+		seq:        postMgr.written,
 	}
+	postMgr.cancelMutex.Unlock()
 
 	// Always push latest activeConfig to channel
 	// Case1: Put latest config into the channel
 	// Case2: If channel is blocked because of earlier config, pop out earlier config and push latest config
+	// along with the partitions of the earlier config
 	// Either Case1 or Case2 executes, which ensures the above
 	select {
 	case postMgr.postChan <- activeConfig:
-	case <-postMgr.postChan:
-		postMgr.postChan <- activeConfig
+	case earlierConfig := <-postMgr.postChan:
+		postMgr.postChan <- activeConfig.merge(earlierConfig)
 	}
 	log.Debug("[AS3] PostManager Accepted the configuration")
 
 	return
 }
 
+// Cancel drops the config waiting to be posted and aborts the one being
+// posted, the configs written before are not posted nor retried.
+func (postMgr *PostManager) Cancel() {
+	postMgr.cancelMutex.Lock()
+	postMgr.generation++
+	if nil != postMgr.cancelPost {
+		postMgr.cancelPost()
+	}
+	postMgr.cancelMutex.Unlock()
+
+	select {
+	case <-postMgr.postChan:
+	default:
+	}
+	log.Debug("[AS3] PostManager Cancelled the configuration")
+}
+
+// Written returns the sequence number of the last config written
+func (postMgr *PostManager) Written() uint64 {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	return postMgr.written
+}
+
+// Posted returns the sequence number of the last config posted to BIG-IP,
+// the configs written before are replaced by it.
+func (postMgr *PostManager) Posted() uint64 {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	return postMgr.posted
+}
+
+// PartitionPosted returns the sequence number of the last config of which
+// the partition is posted to BIG-IP.
+func (postMgr *PostManager) PartitionPosted(partition string) uint64 {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	return postMgr.partitionsPosted[partition]
+}
+
+// setPosted records cfg as posted, all its partitions
+func (postMgr *PostManager) setPosted(cfg config) {
+	for _, partition := range cfg.partitions {
+		postMgr.setPartitionPosted(cfg, partition)
+	}
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	if cfg.seq > postMgr.posted {
+		postMgr.posted = cfg.seq
+	}
+}
+
+// setPartitionPosted records the partition of cfg as posted
+func (postMgr *PostManager) setPartitionPosted(cfg config, partition string) {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	if nil == postMgr.partitionsPosted {
+		postMgr.partitionsPosted = make(map[string]uint64)
+	}
+	if cfg.seq > postMgr.partitionsPosted[partition] {
+		postMgr.partitionsPosted[partition] = cfg.seq
+	}
+}
+
+// postContext returns the context of the request posting cfg and the
+// function to call once posted, a nil context if cfg is cancelled.
+func (postMgr *PostManager) postContext(cfg config) (context.Context, func()) {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	if cfg.generation != postMgr.generation {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	postMgr.cancelPost = cancel
+	return ctx, func() {
+		postMgr.cancelMutex.Lock()
+		defer postMgr.cancelMutex.Unlock()
+		cancel()
+		postMgr.cancelPost = nil
+	}
+}
+
 // configWorker blocks on postChan
 // whenever gets unblocked posts active configuration to BIG-IP
 func (postMgr *PostManager) configWorker() {
@@ -143,58 +275,149 @@ func (postMgr *PostManager) configWorker() {
 
 		// After postDelay expires pick up latest declaration, if available
 		select {
-		case cfg = <-postMgr.postChan:
+		case newCfg := <-postMgr.postChan:
+			cfg = newCfg.merge(cfg)
 		case <-time.After(1 * time.Microsecond):
 		}
 
-		posted := postMgr.postConfig(cfg)
-		// To handle general errors
-		for !posted {
-			posted = postMgr.postOnEventOrTimeout(timeoutMedium, cfg)
+		failed, timeout := postMgr.postConfig(cfg)
+		// To handle general errors, only the partitions failed are retried
+		for len(failed) != 0 {
+			cfg.partitions = failed
+			cfg = postMgr.waitOnEventOrTimeout(timeout, cfg)
+			failed, timeout = postMgr.postConfig(cfg)
 		}
 		firstPost = false
 	}
 }
 
-func (postMgr *PostManager) postOnEventOrTimeout(timeout time.Duration, cfg config) bool {
+// waitOnEventOrTimeout returns the config to retry cfg with once the
+// timeout expires, or the newer config written before along with the
+// partitions of cfg.
+func (postMgr *PostManager) waitOnEventOrTimeout(timeout time.Duration, cfg config) config {
 	select {
 	case newCfg := <-postMgr.postChan:
-		return postMgr.postConfig(newCfg)
+		return newCfg.merge(cfg)
 	case <-time.After(timeout):
-		return postMgr.postConfig(cfg)
+		return cfg
+	}
+}
+
+// postConfig posts each partition of cfg on its own, so that one failing
+// does not hold up the others. It returns the partitions failed and the
+// time to wait before retrying them.
+func (postMgr *PostManager) postConfig(cfg config) ([]string, time.Duration) {
+	ctx, posted := postMgr.postContext(cfg)
+	if nil == ctx {
+		// Cancelled, there is nothing to retry
+		log.Debugf("[AS3] Dropped the cancelled request of %v", cfg.partitions)
+		return nil, 0
+	}
+	defer posted()
+
+	var failed []string
+	timeout := timeoutMedium
+	allPosted := true
+	for _, partition := range cfg.partitions {
+		ok, retry := postMgr.postPartition(ctx, cfg, partition)
+		if nil != ctx.Err() {
+			// Cancelled, there is nothing to retry
+			return nil, 0
+		}
+		allPosted = allPosted && ok
+		if ok || retry == 0 {
+			continue
+		}
+		failed = append(failed, partition)
+		if retry < timeout {
+			timeout = retry
+		}
 	}
+	if allPosted {
+		postMgr.setPosted(cfg)
+	}
+	return failed, timeout
 }
 
-func (postMgr *PostManager) postConfig(cfg config) bool {
+// postPartition posts the declaration of cfg to update the partition. It
+// returns whether the partition is posted, otherwise the time to wait
+// before retrying it, zero if it is not retried.
+func (postMgr *PostManager) postPartition(ctx context.Context, cfg config, partition string) (bool, time.Duration) {
+	as3APIURL := postMgr.getAS3APIURL([]string{partition})
 	httpReqBody := bytes.NewBuffer([]byte(cfg.data))
 
-	req, err := http.NewRequest("POST", cfg.as3APIURL, httpReqBody)
+	req, err := http.NewRequest("POST", as3APIURL, httpReqBody)
 	if err != nil {
 		log.Errorf("[AS3] Creating new HTTP request error: %v ", err)
-		return false
+		return false, timeoutMedium
 	}
-	log.Debugf("[AS3] posting request to %v", cfg.as3APIURL)
+	req = req.WithContext(ctx)
+	log.Debugf("[AS3] posting request to %v", as3APIURL)
 	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
 
+	start := time.Now()
 	httpResp, responseMap := postMgr.httpPOST(req)
 	if httpResp == nil || responseMap == nil {
-		return false
+		postMgr.observePost(start, false)
+		return false, timeoutMedium
 	}
+	postMgr.observePost(start, httpResp.StatusCode/100 == 2)
 
 	switch httpResp.StatusCode {
 	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
-		return postMgr.handleResponseStatusOK(responseMap, cfg)
+		postMgr.setPartitionPosted(cfg, partition)
+		postMgr.handleResponseStatusOK(responseMap)
+		return true, 0
 	case http.StatusServiceUnavailable:
-		return postMgr.handleResponseStatusServiceUnavailable(responseMap, cfg)
+		return false, postMgr.handleResponseStatusServiceUnavailable(responseMap)
 	case http.StatusNotFound:
-		return postMgr.handleResponseStatusNotFound(responseMap)
+		postMgr.handleResponseStatusNotFound(responseMap)
+		return false, 0
 	default:
-		return postMgr.handleResponseOthers(responseMap, cfg)
+		return false, postMgr.handleResponseOthers(responseMap)
+	}
+}
+
+// observePost records the time of the declaration posted at start, as the
+// last successful or failed post, and updates the metrics
+func (postMgr *PostManager) observePost(start time.Time, success bool) {
+	postMgr.cancelMutex.Lock()
+	if success {
+		postMgr.lastSuccess = time.Now()
+	} else {
+		postMgr.lastFailure = time.Now()
 	}
+	postMgr.cancelMutex.Unlock()
+	observeDeclarationPost(start, success)
+}
+
+// LastPosts returns the times of the last successful and failed posts,
+// zero if none
+func (postMgr *PostManager) LastPosts() (success, failure time.Time) {
+	postMgr.cancelMutex.Lock()
+	defer postMgr.cancelMutex.Unlock()
+	return postMgr.lastSuccess, postMgr.lastFailure
+}
+
+// observeDeclarationPost updates the metrics of the declaration posted at
+// start
+func observeDeclarationPost(start time.Time, success bool) {
+	bigIPPrometheus.DeclarationPostDuration.Observe(time.Since(start).Seconds())
+	if !success {
+		bigIPPrometheus.DeclarationPosts.WithLabelValues("failure").Inc()
+		bigIPPrometheus.DeclarationLastFailure.SetToCurrentTime()
+		return
+	}
+	bigIPPrometheus.DeclarationPosts.WithLabelValues("success").Inc()
+	bigIPPrometheus.DeclarationLastSuccess.SetToCurrentTime()
 }
 
 func (postMgr *PostManager) httpPOST(request *http.Request) (*http.Response, map[string]interface{}) {
 	httpResp, err := postMgr.httpClient.Do(request)
+	if err != nil && request.Context().Err() != nil {
+		log.Debugf("[AS3] REST call cancelled")
+		return nil, nil
+	}
 	if err != nil {
 		log.Errorf("[AS3] REST call error: %v ", err)
 		return nil, nil
@@ -218,7 +441,7 @@ func (postMgr *PostManager) httpPOST(request *http.Request) (*http.Response, map
 	return httpResp, response
 }
 
-func (postMgr *PostManager) handleResponseStatusOK(responseMap map[string]interface{}, cfg config) bool {
+func (postMgr *PostManager) handleResponseStatusOK(responseMap map[string]interface{}) {
 	//traverse all response results
 	results := (responseMap["results"]).([]interface{})
 	for _, value := range results {
@@ -226,17 +449,17 @@ func (postMgr *PostManager) handleResponseStatusOK(responseMap map[string]interf
 		//log result with code, tenant and message
 		log.Debugf("[AS3] Response from BIG-IP: code: %v --- tenant:%v --- message: %v", v["code"], v["tenant"], v["message"])
 	}
-
-	return true
 }
 
-func (postMgr *PostManager) handleResponseStatusServiceUnavailable(responseMap map[string]interface{}, cfg config) bool {
+// handleResponseStatusServiceUnavailable returns the time to wait before
+// re-posting the declaration
+func (postMgr *PostManager) handleResponseStatusServiceUnavailable(responseMap map[string]interface{}) time.Duration {
 	log.Errorf("[AS3] Big-IP Responded with error code: %v", responseMap["code"])
 	log.Debugf("[AS3] Response from BIG-IP: BIG-IP is busy, waiting %v seconds and re-posting the declaration", timeoutSmall)
-	return postMgr.postOnEventOrTimeout(timeoutSmall, cfg)
+	return timeoutSmall
 }
 
-func (postMgr *PostManager) handleResponseStatusNotFound(responseMap map[string]interface{}) bool {
+func (postMgr *PostManager) handleResponseStatusNotFound(responseMap map[string]interface{}) {
 	if err, ok := (responseMap["error"]).(map[string]interface{}); ok {
 		log.Errorf("[AS3] Big-IP Responded with error code: %v", err["code"])
 	} else {
@@ -246,10 +469,11 @@ func (postMgr *PostManager) handleResponseStatusNotFound(responseMap map[string]
 	if postMgr.LogResponse {
 		log.Errorf("[AS3] Raw response from Big-IP: %v ", responseMap)
 	}
-	return true
 }
 
-func (postMgr *PostManager) handleResponseOthers(responseMap map[string]interface{}, cfg config) bool {
+// handleResponseOthers returns the time to wait before re-posting the
+// declaration
+func (postMgr *PostManager) handleResponseOthers(responseMap map[string]interface{}) time.Duration {
 	if results, ok := (responseMap["results"]).([]interface{}); ok {
 		for _, value := range results {
 			v := value.(map[string]interface{})
@@ -265,5 +489,92 @@ func (postMgr *PostManager) handleResponseOthers(responseMap map[string]interfac
 	if postMgr.LogResponse {
 		log.Errorf("[AS3] Raw response from Big-IP: %v ", responseMap)
 	}
-	return postMgr.postOnEventOrTimeout(timeoutMedium, cfg)
+	return timeoutMedium
+}
+
+// ListObjects returns the names of the objects of the Shared application
+// of the partition declared with AS3, none if the tenant is not declared.
+func (postMgr *PostManager) ListObjects(partition string) ([]string, error) {
+	req, err := http.NewRequest("GET", postMgr.getAS3APIURL([]string{partition}), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
+	body, status, err := postMgr.httpDo(req)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent || status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("Big-IP Responded with code: %v", status)
+	}
+	var decl map[string]interface{}
+	if err := json.Unmarshal(body, &decl); err != nil {
+		return nil, err
+	}
+	tenant, _ := decl[partition].(map[string]interface{})
+	sharedApp, _ := tenant[as3SharedApplication].(map[string]interface{})
+	var names []string
+	for name, obj := range sharedApp {
+		// The properties of the application are not objects
+		if _, ok := obj.(map[string]interface{}); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteObjects removes the objects of the Shared application of the
+// partition with an AS3 patch.
+func (postMgr *PostManager) DeleteObjects(partition string, names []string) error {
+	type patchOp struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}
+	ops := make([]patchOp, 0, len(names))
+	for _, name := range names {
+		ops = append(ops, patchOp{
+			Op:   "remove",
+			Path: "/" + partition + "/" + as3SharedApplication + "/" + name,
+		})
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PATCH", postMgr.getAS3APIURL(nil), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
+	req.Header.Set("Content-Type", "application/json")
+	body, status, err := postMgr.httpDo(req)
+	if err != nil {
+		return err
+	}
+	if status/100 != 2 {
+		if postMgr.LogResponse {
+			log.Errorf("[AS3] Raw response from Big-IP: %v", string(body))
+		}
+		return fmt.Errorf("Big-IP Responded with code: %v", status)
+	}
+	return nil
+}
+
+// httpDo sends the request and returns the body and the status code of
+// the response.
+func (postMgr *PostManager) httpDo(request *http.Request) ([]byte, int, error) {
+	httpResp, err := postMgr.httpClient.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, httpResp.StatusCode, nil
 }
@@ -0,0 +1,153 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PostManager Tests", func() {
+	var postMgr *PostManager
+	var server *httptest.Server
+	var mutex sync.Mutex
+	// Status codes responded by partition, OK by default
+	var statusCodes map[string]int
+	var requests []string
+
+	BeforeEach(func() {
+		statusCodes = make(map[string]int)
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				partition := path.Base(r.URL.Path)
+				mutex.Lock()
+				requests = append(requests, partition+" "+string(body))
+				statusCode, ok := statusCodes[partition]
+				mutex.Unlock()
+				if !ok {
+					statusCode = http.StatusOK
+				}
+				w.WriteHeader(statusCode)
+				_, _ = w.Write([]byte(`{"results": [{"code": 200, "tenant": "` +
+					partition + `"}]}`))
+			}))
+		postMgr = &PostManager{
+			postChan:   make(chan config, 1),
+			httpClient: server.Client(),
+			PostParams: PostParams{BIGIPURL: server.URL},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	setStatusCode := func(partition string, statusCode int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		statusCodes[partition] = statusCode
+	}
+	posted := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests
+	}
+
+	It("Posts each partition on its own", func() {
+		setStatusCode("tenant2", http.StatusUnprocessableEntity)
+		postMgr.Write(`{"a": 1}`, []string{"tenant1", "tenant2", "test"})
+		cfg := <-postMgr.postChan
+
+		failed, timeout := postMgr.postConfig(cfg)
+		Expect(failed).To(Equal([]string{"tenant2"}))
+		Expect(timeout).To(Equal(timeoutMedium))
+		Expect(posted()).To(Equal([]string{`tenant1 {"a": 1}`,
+			`tenant2 {"a": 1}`, `test {"a": 1}`}))
+		Expect(postMgr.PartitionPosted("tenant1")).To(Equal(cfg.seq))
+		Expect(postMgr.PartitionPosted("tenant2")).To(BeZero())
+		Expect(postMgr.PartitionPosted("test")).To(Equal(cfg.seq))
+		Expect(postMgr.Posted()).To(BeZero(),
+			"The config should be posted once all its partitions are")
+
+		// BIG-IP busy is retried sooner
+		setStatusCode("tenant2", http.StatusServiceUnavailable)
+		cfg.partitions = failed
+		failed, timeout = postMgr.postConfig(cfg)
+		Expect(failed).To(Equal([]string{"tenant2"}))
+		Expect(timeout).To(Equal(timeoutSmall))
+
+		setStatusCode("tenant2", http.StatusOK)
+		failed, _ = postMgr.postConfig(cfg)
+		Expect(failed).To(BeEmpty())
+		Expect(postMgr.PartitionPosted("tenant2")).To(Equal(cfg.seq))
+		Expect(postMgr.Posted()).To(Equal(cfg.seq))
+	})
+
+	It("Does not retry the partitions not found", func() {
+		setStatusCode("test", http.StatusNotFound)
+		postMgr.Write(`{}`, []string{"test"})
+		failed, _ := postMgr.postConfig(<-postMgr.postChan)
+		Expect(failed).To(BeEmpty())
+		Expect(postMgr.PartitionPosted("test")).To(BeZero())
+		Expect(postMgr.Posted()).To(BeZero())
+	})
+
+	It("Retries the partitions failed alone", func() {
+		setStatusCode("tenant2", http.StatusUnprocessableEntity)
+		postMgr.Write(`{"a": 1}`, []string{"tenant1", "tenant2"})
+		go postMgr.configWorker()
+		Eventually(posted).Should(HaveLen(2))
+
+		// The newer config is posted along with the partition failed
+		setStatusCode("tenant2", http.StatusOK)
+		postMgr.Write(`{"a": 2}`, []string{"test"})
+		Eventually(postMgr.Posted).Should(Equal(uint64(2)))
+		Expect(posted()[2:]).To(Equal([]string{`tenant2 {"a": 2}`,
+			`test {"a": 2}`}))
+		Expect(postMgr.PartitionPosted("tenant1")).To(Equal(uint64(1)))
+		Expect(postMgr.PartitionPosted("tenant2")).To(Equal(uint64(2)))
+		Consistently(posted, 100*time.Millisecond).Should(HaveLen(4),
+			"The partitions posted should not be posted again")
+	})
+
+	It("Posts the partitions of the config replaced", func() {
+		postMgr.Write(`{"a": 1}`, []string{"tenant1"})
+		postMgr.Write(`{"a": 2}`, []string{"test"})
+		cfg := <-postMgr.postChan
+		Expect(cfg.data).To(Equal(`{"a": 2}`))
+		Expect(cfg.partitions).To(Equal([]string{"tenant1", "test"}))
+
+		// Not the cancelled ones
+		postMgr.Write(`{"a": 3}`, []string{"tenant1"})
+		postMgr.Cancel()
+		postMgr.Write(`{"a": 4}`, []string{"tenant2"})
+		postMgr.Write(`{"a": 5}`, []string{"test"})
+		retried := config{partitions: []string{"tenant3"}, generation: 1}
+		cfg = postMgr.waitOnEventOrTimeout(time.Hour, retried)
+		Expect(cfg.data).To(Equal(`{"a": 5}`))
+		Expect(cfg.partitions).To(Equal([]string{"tenant2", "tenant3", "test"}))
+	})
+})
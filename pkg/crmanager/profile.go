@@ -1,15 +1,28 @@
 package crmanager
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
-	v1 "k8s.io/api/core/v1"
 	"reflect"
+	"strings"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Creates a default SNI profile (if needed) and a new profile from a Secret
 func (crMgr *CRManager) createSecretSslProfile(
 	rsCfg *ResourceConfig,
 	secret *v1.Secret,
+	auth clientAuth,
+	sniCfg sniConfig,
 ) (error, bool) {
 	if _, ok := secret.Data["tls.crt"]; !ok {
 		err := fmt.Errorf("Invalid Secret '%v': 'tls.crt' field not specified.",
@@ -21,22 +34,33 @@ func (crMgr *CRManager) createSecretSslProfile(
 			secret.ObjectMeta.Name)
 		return err, false
 	}
+	cert, chain, err := splitCertificateChain(secret)
+	if err != nil {
+		return err, false
+	}
+	if err := crMgr.validateSecretCertificate(secret, cert,
+		sniCfg.hosts); err != nil {
+		return err, false
+	}
 
 	// Create Default for SNI profile
 	skey := SecretKey{
-		Name:         fmt.Sprintf("default-clientssl-%s", rsCfg.GetName()),
+		Name:         namer.DefaultSNIProfileName(rsCfg.GetName()),
 		ResourceName: rsCfg.GetName(),
+		Partition:    rsCfg.Virtual.Partition,
 	}
 	sni := ProfileRef{
 		Name:      skey.Name,
 		Partition: rsCfg.Virtual.Partition,
 		Context:   CustomProfileClient,
 	}
+	crMgr.customProfiles.Lock()
 	if _, ok := crMgr.customProfiles.Profs[skey]; !ok {
 		// This is just a basic profile, so we don't need all the fields
-		cp := NewCustomProfile(sni, "", "", "", true, "", "")
+		cp := NewCustomProfile(sni, "", "", "", "", true, "", "")
 		crMgr.customProfiles.Profs[skey] = cp
 	}
+	crMgr.customProfiles.Unlock()
 	rsCfg.Virtual.AddOrUpdateProfile(sni)
 
 	// Now add the resource profile
@@ -48,20 +72,26 @@ func (crMgr *CRManager) createSecretSslProfile(
 	}
 	cp := NewCustomProfile(
 		profRef,
-		string(secret.Data["tls.crt"]),
+		cert,
 		string(secret.Data["tls.key"]),
-		"",    // serverName
-		false, // sni
-		"",    // peerCertMode
-		"",    // caFile
+		chain,
+		sniCfg.serverName,
+		false, // sni, selected with the other profiles of the virtual
+		auth.peerCertMode,
+		auth.caFile,
 	)
+	cp.CABundle = auth.caBundle
+	cp.sniPriority = sniCfg.priority
+	cp.hosts = sniCfg.hosts
 	skey = SecretKey{
 		Name:         cp.Name,
 		ResourceName: rsCfg.GetName(),
+		Partition:    rsCfg.Virtual.Partition,
 	}
 	crMgr.customProfiles.Lock()
 	defer crMgr.customProfiles.Unlock()
 	if prof, ok := crMgr.customProfiles.Profs[skey]; ok {
+		cp.SNIDefault = prof.SNIDefault
 		if !reflect.DeepEqual(prof, cp) {
 			crMgr.customProfiles.Profs[skey] = cp
 			return nil, true
@@ -72,3 +102,482 @@ func (crMgr *CRManager) createSecretSslProfile(
 	crMgr.customProfiles.Profs[skey] = cp
 	return nil, false
 }
+
+// splitCertificateChain returns the certificate of the secret and the
+// intermediate certificates following it in tls.crt, or else the
+// certificates of ca.crt, installed as its chain.
+func splitCertificateChain(secret *v1.Secret) (string, string, error) {
+	certs, err := decodeCertificates(secret.Data["tls.crt"])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid Secret '%v': 'tls.crt' %v",
+			secret.ObjectMeta.Name, err)
+	}
+	if len(certs) == 1 && len(secret.Data["ca.crt"]) > 0 {
+		caCerts, err := decodeCertificates(secret.Data["ca.crt"])
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid Secret '%v': 'ca.crt' %v",
+				secret.ObjectMeta.Name, err)
+		}
+		certs = append(certs, caCerts...)
+	}
+	var chain []byte
+	for _, cert := range certs[1:] {
+		chain = append(chain, pem.EncodeToMemory(cert)...)
+	}
+	return string(pem.EncodeToMemory(certs[0])), string(chain), nil
+}
+
+// validateSecretCertificate checks the certificate of the secret matches its
+// key, is not expired and covers the hosts of the profile, BIG-IP otherwise
+// fails the whole declaration. The certificates expiring within
+// CertExpiryWarnDays are reported on the secret.
+func (crMgr *CRManager) validateSecretCertificate(
+	secret *v1.Secret,
+	cert string,
+	hosts []string,
+) error {
+	name := secret.ObjectMeta.Name
+	pair, err := tls.X509KeyPair([]byte(cert), secret.Data["tls.key"])
+	if err != nil {
+		return fmt.Errorf("Invalid Secret '%v': %v", name, err)
+	}
+	x509Cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Invalid Secret '%v': 'tls.crt' %v", name, err)
+	}
+	now := time.Now()
+	if now.After(x509Cert.NotAfter) {
+		return fmt.Errorf("Invalid Secret '%v': certificate expired on %v",
+			name, x509Cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		}
+		if err := x509Cert.VerifyHostname(host); err != nil {
+			return fmt.Errorf("Invalid Secret '%v': certificate does not "+
+				"cover host %s", name, host)
+		}
+	}
+
+	days := crMgr.CertExpiryWarnDays
+	if days > 0 && now.AddDate(0, 0, days).After(x509Cert.NotAfter) {
+		msg := fmt.Sprintf("Certificate of secret %s expires on %v", name,
+			x509Cert.NotAfter.UTC().Format(time.RFC3339))
+		log.Warningf("%s", msg)
+		crMgr.recordEvent(secret, secret.ObjectMeta.Namespace,
+			v1.EventTypeWarning, "CertificateExpiring", msg)
+		bigIPPrometheus.CertificateExpiry.WithLabelValues(
+			secret.ObjectMeta.Namespace, name).Set(
+			float64(x509Cert.NotAfter.Unix()))
+	} else {
+		bigIPPrometheus.CertificateExpiry.DeleteLabelValues(
+			secret.ObjectMeta.Namespace, name)
+	}
+	return nil
+}
+
+// decodeCertificates returns the PEM certificates of the bundle
+func decodeCertificates(bundle []byte) ([]*pem.Block, error) {
+	var certs []*pem.Block
+	rest := bundle
+	for {
+		block, next := pem.Decode(rest)
+		if nil == block {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("has a PEM block of type %s instead of "+
+				"CERTIFICATE", block.Type)
+		}
+		certs = append(certs, block)
+		rest = next
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("has no PEM certificate")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("has malformed PEM data after certificate %d",
+			len(certs))
+	}
+	return certs, nil
+}
+
+// createServerSslProfile creates the server SSL profile of the virtual from
+// the CA certificate of the secret, the pool members are validated with it.
+func (crMgr *CRManager) createServerSslProfile(
+	rsCfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	secret *v1.Secret,
+) error {
+	caCert, ok := secret.Data["tls.crt"]
+	if !ok || len(caCert) == 0 {
+		return fmt.Errorf("Invalid Secret '%v': 'tls.crt' field not specified.",
+			secret.ObjectMeta.Name)
+	}
+	profRef := ProfileRef{
+		Name:      serverSslProfileName(secret.ObjectMeta.Name),
+		Partition: rsCfg.Virtual.Partition,
+		Context:   CustomProfileServer,
+		Namespace: secret.ObjectMeta.Namespace,
+	}
+	cp := NewCustomProfile(
+		profRef,
+		string(caCert),
+		"",    // key
+		"",    // chainCert
+		"",    // serverName
+		false, // sni
+		"",    // peerCertMode
+		"",    // caFile
+	)
+	skey := SecretKey{
+		Name:         cp.Name,
+		ResourceName: rsCfg.GetName(),
+		Partition:    rsCfg.Virtual.Partition,
+	}
+	crMgr.customProfiles.Lock()
+	defer crMgr.customProfiles.Unlock()
+	crMgr.customProfiles.Profs[skey] = cp
+	crMgr.customProfiles.addRef(skey,
+		vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name)
+	return nil
+}
+
+// serverSslProfileName returns the name of the server SSL profile created
+// from the secret, distinct from its client SSL profile.
+func serverSslProfileName(secretName string) string {
+	return secretName + "-serverssl"
+}
+
+// getTLSSecret returns the secret from SSLContext, or from the API server
+// storing it in SSLContext to avoid further api calls.
+func (crMgr *CRManager) getTLSSecret(namespace, name string) (*v1.Secret, error) {
+	crMgr.sslMutex.Lock()
+	secret, ok := crMgr.SSLContext[name]
+	crMgr.sslMutex.Unlock()
+	if ok && secret.ObjectMeta.Namespace == namespace {
+		bigIPPrometheus.TLSSecretCache.WithLabelValues("hit").Inc()
+		return secret, nil
+	}
+	bigIPPrometheus.TLSSecretCache.WithLabelValues("miss").Inc()
+	secret, err := crMgr.kubeClient.CoreV1().Secrets(namespace).
+		Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	crMgr.sslMutex.Lock()
+	crMgr.SSLContext[name] = secret
+	crMgr.sslMutex.Unlock()
+	return secret, nil
+}
+
+// prefetchTLSSecrets stores the secrets of the TLSProfiles of the
+// VirtualServer in SSLContext. The TLSProfiles are read from the informer as
+// TLSContext is only used while processing the resources, errors are
+// reported when the VirtualServer is processed.
+func (crMgr *CRManager) prefetchTLSSecrets(vs *cisapiv1.VirtualServer) {
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	deps := make(ObjectDependencies)
+	for _, tlsName := range getTLSProfileNames(vs) {
+		obj, found, _ := crInf.tsInformer.GetIndexer().GetByKey(
+			vs.ObjectMeta.Namespace + "/" + tlsName)
+		if !found {
+			continue
+		}
+		tls := obj.(*cisapiv1.TLSProfile)
+		if tls.Spec.TLS.Reference == Secret {
+			addTLSSecretDependency(vs, tls, deps)
+		}
+	}
+	for dep := range deps {
+		if dep.Name != "" {
+			_, _ = crMgr.getTLSSecret(dep.Namespace, dep.Name)
+		}
+	}
+}
+
+// getClientAuth returns the client certificate authentication of the
+// TLSProfile, with the CA certificates of its secret. Errors are recorded
+// as Events on the VirtualServer and the TLSProfile.
+func (crMgr *CRManager) getClientAuth(
+	vs *cisapiv1.VirtualServer,
+	tls *cisapiv1.TLSProfile,
+) (clientAuth, bool) {
+	ca := tls.Spec.TLS.ClientAuth
+	if nil == ca {
+		return clientAuth{}, true
+	}
+	// The mode and the CA certificates are validated with the TLSProfile
+	auth := clientAuth{peerCertMode: PeerCertRequired}
+	if ca.Mode == PeerCertRequested {
+		auth.peerCertMode = PeerCertRequested
+	}
+	// A CA bundle on BIG-IP
+	if strings.HasPrefix(ca.CACertificate, "/") {
+		auth.caBundle = ca.CACertificate
+		return auth, true
+	}
+	secret, err := crMgr.getTLSSecret(vs.ObjectMeta.Namespace,
+		ca.CACertificate)
+	if err != nil {
+		crMgr.recordTLSEvent(vs, tls, "SecretNotFound",
+			fmt.Sprintf("CA Secret %s not found: %v", ca.CACertificate, err))
+		crMgr.retryLater(vs, "CA Secret %s not found: %v", ca.CACertificate, err)
+		return auth, false
+	}
+	caCert, ok := secret.Data["ca.crt"]
+	if !ok || len(caCert) == 0 {
+		crMgr.recordTLSEvent(vs, tls, "InvalidSecret",
+			fmt.Sprintf("Invalid Secret '%v': 'ca.crt' field not specified.",
+				ca.CACertificate))
+		return auth, false
+	}
+	auth.caFile = string(caCert)
+	return auth, true
+}
+
+// addSecretProfileRefs records the VirtualServer as a user of the profiles
+// created from the secret on the virtual.
+func (crMgr *CRManager) addSecretProfileRefs(
+	rsCfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	secretName string,
+) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	rsName := rsCfg.GetName()
+	crMgr.customProfiles.Lock()
+	defer crMgr.customProfiles.Unlock()
+	crMgr.customProfiles.addRef(SecretKey{
+		Name:         secretName,
+		ResourceName: rsName,
+		Partition:    rsCfg.Virtual.Partition,
+	}, vsKey)
+	crMgr.customProfiles.addRef(SecretKey{
+		Name:         namer.DefaultSNIProfileName(rsName),
+		ResourceName: rsName,
+		Partition:    rsCfg.Virtual.Partition,
+	}, vsKey)
+}
+
+// updateSNIDefault selects the SNI default profile of the virtual, it
+// returns its name and the names of the profiles requesting to be the
+// default.
+func (crMgr *CRManager) updateSNIDefault(
+	partition string,
+	rsName string,
+) (string, []string) {
+	crMgr.customProfiles.Lock()
+	defer crMgr.customProfiles.Unlock()
+	return crMgr.customProfiles.selectSNIDefault(partition, rsName)
+}
+
+// releaseSecretProfiles removes the VirtualServer from the users of the
+// profiles and returns the profiles it was using.
+func (crMgr *CRManager) releaseSecretProfiles(vsKey string) []SecretKey {
+	crMgr.customProfiles.Lock()
+	defer crMgr.customProfiles.Unlock()
+	return crMgr.customProfiles.releaseRefs(vsKey)
+}
+
+// deleteUnusedSecretProfiles deletes the profiles no longer used by any
+// VirtualServer along with their references on the virtuals.
+func (crMgr *CRManager) deleteUnusedSecretProfiles(keys []SecretKey) {
+	crMgr.customProfiles.Lock()
+	deleted := crMgr.customProfiles.deleteUnreferenced(keys)
+	// Another profile of the virtual becomes the SNI default
+	for _, key := range deleted {
+		crMgr.customProfiles.selectSNIDefault(key.Partition,
+			key.ResourceName)
+	}
+	crMgr.customProfiles.Unlock()
+	for _, key := range deleted {
+		log.Debugf("Deleting unused profile %s of virtual %s", key.Name,
+			key.ResourceName)
+		rsCfg, ok := crMgr.resources.GetByName(key.Partition,
+			key.ResourceName)
+		if !ok {
+			continue
+		}
+		var profiles ProfileRefs
+		for _, prof := range rsCfg.Virtual.Profiles {
+			if prof.Name != key.Name ||
+				prof.Partition != rsCfg.Virtual.Partition ||
+				prof.Context != CustomProfileClient {
+				profiles = append(profiles, prof)
+			}
+		}
+		rsCfg.Virtual.Profiles = profiles
+	}
+}
+
+// addSecretDependency adds the secret of the TLSProfile of the VirtualServer
+// to its dependencies, so the VirtualServer is processed again when the
+// secret changes.
+func (crMgr *CRManager) addSecretDependency(
+	vs *cisapiv1.VirtualServer,
+	deps ObjectDependencies,
+) {
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	for _, tlsName := range getTLSProfileNames(vs) {
+		tls, found := crMgr.getTLSProfile(crInf,
+			vs.ObjectMeta.Namespace+"/"+tlsName)
+		if found && tls.Spec.TLS.Reference == Secret {
+			addTLSSecretDependency(vs, tls, deps)
+		}
+	}
+}
+
+// addTLSSecretDependency adds the secrets of the TLSProfile to the
+// dependencies of the VirtualServer.
+func addTLSSecretDependency(
+	vs *cisapiv1.VirtualServer,
+	tls *cisapiv1.TLSProfile,
+	deps ObjectDependencies,
+) {
+	dep := ObjectDependency{
+		Kind:      TLSSecret,
+		Namespace: vs.ObjectMeta.Namespace,
+		Name:      tls.Spec.TLS.ClientSSL,
+	}
+	deps[dep]++
+	// The secret with the CA certificate of the server SSL profile
+	if tls.Spec.TLS.Termination == TLSReencrypt &&
+		tls.Spec.TLS.ServerSSL != "" {
+		dep.Name = tls.Spec.TLS.ServerSSL
+		deps[dep]++
+	}
+	// The secret with the CA certificates of client authentication
+	if ca := tls.Spec.TLS.ClientAuth; ca != nil && ca.CACertificate != "" &&
+		!strings.HasPrefix(ca.CACertificate, "/") {
+		dep.Name = ca.CACertificate
+		deps[dep]++
+	}
+}
+
+// updateSecretSslProfiles replaces the cached secret and rebuilds the
+// profiles of the virtuals using it.
+func (crMgr *CRManager) updateSecretSslProfiles(secret *v1.Secret) {
+	name := secret.ObjectMeta.Name
+	crMgr.sslMutex.Lock()
+	if cached, ok := crMgr.SSLContext[name]; ok &&
+		cached.ObjectMeta.Namespace == secret.ObjectMeta.Namespace {
+		crMgr.SSLContext[name] = secret
+	}
+	crMgr.sslMutex.Unlock()
+	for _, rsCfg := range crMgr.getResourcesForSecret(secret) {
+		// The client authentication and SNI are kept, they change with the
+		// TLSProfile
+		crMgr.customProfiles.Lock()
+		prof := crMgr.customProfiles.Profs[SecretKey{
+			Name:         name,
+			ResourceName: rsCfg.GetName(),
+			Partition:    rsCfg.Virtual.Partition,
+		}]
+		crMgr.customProfiles.Unlock()
+		auth := clientAuth{
+			peerCertMode: prof.PeerCertMode,
+			caFile:       prof.CAFile,
+			caBundle:     prof.CABundle,
+		}
+		sni := sniConfig{
+			serverName: prof.ServerName,
+			priority:   prof.sniPriority,
+			hosts:      prof.hosts,
+		}
+		if err, _ := crMgr.createSecretSslProfile(rsCfg, secret, auth,
+			sni); err != nil {
+			log.Errorf("Failed to update profile of virtual %s with secret "+
+				"%s: %v", rsCfg.GetName(), name, err)
+			crMgr.recordEvent(secret, secret.ObjectMeta.Namespace,
+				v1.EventTypeWarning, "InvalidSecret", err.Error())
+		}
+	}
+}
+
+// deleteSecretSslProfiles removes the deleted secret from the cache and its
+// profiles from the virtuals, so the certificate is no longer served.
+func (crMgr *CRManager) deleteSecretSslProfiles(secret *v1.Secret) {
+	name := secret.ObjectMeta.Name
+	crMgr.sslMutex.Lock()
+	if cached, ok := crMgr.SSLContext[name]; ok &&
+		cached.ObjectMeta.Namespace == secret.ObjectMeta.Namespace {
+		delete(crMgr.SSLContext, name)
+	}
+	crMgr.sslMutex.Unlock()
+	bigIPPrometheus.CertificateExpiry.DeleteLabelValues(
+		secret.ObjectMeta.Namespace, name)
+	crMgr.customProfiles.Lock()
+	defer crMgr.customProfiles.Unlock()
+	for _, rsCfg := range crMgr.getResourcesForSecret(secret) {
+		rsName := rsCfg.GetName()
+		sniName := namer.DefaultSNIProfileName(rsName)
+		partition := rsCfg.Virtual.Partition
+		delete(crMgr.customProfiles.Profs, SecretKey{
+			Name:         name,
+			ResourceName: rsName,
+			Partition:    partition,
+		})
+		crMgr.customProfiles.selectSNIDefault(partition, rsName)
+		// The SNI profile is kept for the certificates of other secrets
+		keepSNI := false
+		var profiles ProfileRefs
+		for _, prof := range rsCfg.Virtual.Profiles {
+			if isSecretProfile(prof, secret) || prof.Name == sniName {
+				continue
+			}
+			if _, ok := crMgr.customProfiles.Profs[SecretKey{
+				Name:         prof.Name,
+				ResourceName: rsName,
+				Partition:    partition,
+			}]; ok && prof.Context == CustomProfileClient {
+				keepSNI = true
+			}
+			profiles = append(profiles, prof)
+		}
+		rsCfg.Virtual.Profiles = profiles
+		if keepSNI {
+			rsCfg.Virtual.AddOrUpdateProfile(ProfileRef{
+				Name:      sniName,
+				Partition: rsCfg.Virtual.Partition,
+				Context:   CustomProfileClient,
+			})
+			continue
+		}
+		delete(crMgr.customProfiles.Profs, SecretKey{
+			Name:         sniName,
+			ResourceName: rsName,
+			Partition:    partition,
+		})
+	}
+}
+
+// getResourcesForSecret returns the resource configs with the profile of
+// the secret.
+func (crMgr *CRManager) getResourcesForSecret(
+	secret *v1.Secret,
+) []*ResourceConfig {
+	var rsCfgs []*ResourceConfig
+	for _, rsCfg := range crMgr.resources.GetAllResources() {
+		for _, prof := range rsCfg.Virtual.Profiles {
+			if isSecretProfile(prof, secret) {
+				rsCfgs = append(rsCfgs, rsCfg)
+				break
+			}
+		}
+	}
+	return rsCfgs
+}
+
+// isSecretProfile returns true if the profile is created from the secret
+func isSecretProfile(prof ProfileRef, secret *v1.Secret) bool {
+	return prof.Name == secret.ObjectMeta.Name &&
+		prof.Namespace == secret.ObjectMeta.Namespace &&
+		prof.Context == CustomProfileClient
+}
@@ -0,0 +1,211 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sort"
+	"strings"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"k8s.io/client-go/tools/cache"
+)
+
+// pruneKey is the key in rscQueue of the removal of the stale objects
+var pruneKey = &rqKey{kind: Prune}
+
+// enqueuePruneOnceSynced queues the removal of the stale objects once the
+// informer caches are synced, unless stopCh is closed before.
+func (crMgr *CRManager) enqueuePruneOnceSynced(stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, crMgr.cacheSyncs()...) {
+		return
+	}
+	log.Infof("Informer caches synced, pruning the stale objects on BIG-IP")
+	crMgr.rscQueue.Add(pruneKey)
+}
+
+// cacheSyncs returns the functions telling whether the informer caches are
+// synced.
+func (crMgr *CRManager) cacheSyncs() []cache.InformerSynced {
+	var syncs []cache.InformerSynced
+	for _, crInf := range crMgr.crInformers {
+		for _, inf := range []cache.SharedIndexInformer{
+			crInf.vsInformer,
+			crInf.tsInformer,
+			crInf.transportInformer,
+			crInf.ilInformer,
+			crInf.ednsInformer,
+			crInf.plcInformer,
+			crInf.svcInformer,
+			crInf.epsInformer,
+			crInf.sliceInformer,
+			crInf.podInformer,
+			crInf.secretInformer,
+		} {
+			if nil != inf {
+				syncs = append(syncs, inf.HasSynced)
+			}
+		}
+	}
+	return syncs
+}
+
+// cachesSynced tells whether the informer caches are synced
+func (crMgr *CRManager) cachesSynced() bool {
+	for _, synced := range crMgr.cacheSyncs() {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// prune rebuilds the configs from the resources in the informer caches, the
+// resources queued by the event handlers meanwhile may be missing from the
+// configs. The stale objects are removed once the resources are processed.
+// The caller holds processingMutex.
+func (crMgr *CRManager) prune() {
+	if crMgr.pruned || crMgr.pruneStale {
+		return
+	}
+	crMgr.resetConfigs()
+	crMgr.pruneStale = true
+	crMgr.enqueueAllResources()
+}
+
+// pruneStaleObjects removes from the partitions of the configuration the
+// objects on BIG-IP named with the prefix of the virtuals and missing from
+// the configuration. The caller holds processingMutex.
+func (crMgr *CRManager) pruneStaleObjects(config ResourceConfigWrapper) {
+	crMgr.pruneStale = false
+	crMgr.pruned = true
+	store := crMgr.Agent.ObjectStore
+	if nil == store {
+		log.Debugf("No objects on BIG-IP to prune")
+		return
+	}
+	for partition, tenant := range createAS3ADC(config) {
+		if partition == "Common" {
+			// The Wide-IPs are not named after the virtuals
+			continue
+		}
+		names, err := store.ListObjects(partition)
+		if err != nil {
+			log.Errorf("Unable to list the objects of partition %s: %v",
+				partition, err)
+			continue
+		}
+		stale := staleObjects(names, tenant.(as3Tenant))
+		if len(stale) == 0 {
+			continue
+		}
+		for _, name := range stale {
+			log.Infof("Pruning stale object /%s/%s/%s", partition,
+				as3SharedApplication, name)
+		}
+		if err := store.DeleteObjects(partition, stale); err != nil {
+			log.Errorf("Unable to prune the objects of partition %s: %v",
+				partition, err)
+		}
+	}
+}
+
+// staleObjects returns the names, sorted, of the objects owned by CIS and
+// missing from the Shared application of the tenant.
+func staleObjects(names []string, tenant as3Tenant) []string {
+	sharedApp, _ := tenant[as3SharedApplication].(as3Application)
+	prefix := namer.Prefix()
+	var stale []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, ok := sharedApp[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// sharedObjectNames are the names, or the prefixes of the names, of the
+// iRules and data groups of the virtuals
+var sharedObjectNames = []string{
+	HttpRedirectIRuleName,
+	HttpsRedirectDgName,
+	AbDeploymentPathIRuleName,
+	AbDeploymentDgName,
+	ProxyProtocolIRuleName,
+	SslPassthroughIRuleName,
+	SslReencryptIRuleName,
+	PassthroughHostsDgName,
+	ReencryptHostsDgName,
+	ReencryptServerSslDgName,
+	EdgeHostsDgName,
+	EdgeServerSslDgName,
+	HstsIRuleName,
+	HstsDgName,
+	PoolActionIRuleName,
+}
+
+// isSharedObjectName returns true if the object is an iRule or a data group
+// of the virtuals
+func isSharedObjectName(name string) bool {
+	for _, prefix := range sharedObjectNames {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// declareFormerSharedPartition declares Common, empty, when it has iRules
+// or data groups of the virtuals on BIG-IP while the configuration has
+// none there, so that the copies left in Common before the iRules and data
+// groups moved to another partition are removed.
+func (crMgr *CRManager) declareFormerSharedPartition(
+	config *ResourceConfigWrapper,
+) {
+	store := crMgr.Agent.ObjectStore
+	// The Wide-IPs replace the objects of Common
+	if nil == store || nil != config.dnsConfig {
+		return
+	}
+	for key := range config.iRuleMap {
+		if key.Partition == "Common" {
+			return
+		}
+	}
+	for key := range config.intDgMap {
+		if key.Partition == "Common" {
+			return
+		}
+	}
+	names, err := store.ListObjects("Common")
+	if err != nil {
+		log.Errorf("Unable to list the objects of partition Common: %v", err)
+		return
+	}
+	for _, name := range names {
+		if isSharedObjectName(name) {
+			log.Infof("Removing the iRules and data groups left in partition " +
+				"Common")
+			partitions := []string{"Common"}
+			config.partitions = append(partitions, config.partitions...)
+			return
+		}
+	}
+}
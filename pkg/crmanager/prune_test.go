@@ -0,0 +1,189 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeObjectStore serves the objects of the partitions and records the
+// objects deleted
+type fakeObjectStore struct {
+	objects map[string][]string
+	deleted map[string][]string
+}
+
+func (store *fakeObjectStore) ListObjects(partition string) ([]string, error) {
+	return store.objects[partition], nil
+}
+
+func (store *fakeObjectStore) DeleteObjects(partition string, names []string) error {
+	store.deleted[partition] = append(store.deleted[partition], names...)
+	return nil
+}
+
+var _ = Describe("Prune Tests", func() {
+	var mockCRM *mockCRManager
+	var postChan chan config
+	var store *fakeObjectStore
+	var oldPartition string
+
+	// process processes the resources queued as in the Resync Tests
+	process := func() {
+		if mockCRM.rscQueue.Len() > 0 {
+			Expect(mockCRM.processResource()).To(BeTrue())
+		}
+		mockCRM.processWithWorkers(1)
+		mockCRM.rscQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller")
+	}
+
+	// prune processes the prune queued once the caches are synced, which
+	// queues the VirtualServers from the caches again
+	prune := func() {
+		mockCRM.drainQueue()
+		mockCRM.rscQueue.Add(pruneKey)
+		process()
+	}
+
+	BeforeEach(func() {
+		oldPartition = DEFAULT_PARTITION
+		DEFAULT_PARTITION = "test"
+		mockCRM = newMockCRManager()
+		mockCRM.PruneOnStartup = true
+		postChan = make(chan config, 1)
+		store = &fakeObjectStore{
+			objects: map[string][]string{
+				"test": {
+					formatVirtualServerName("10.1.1.1", 443),
+					formatVirtualServerName("10.1.1.1", 80),
+					formatVirtualServerName("10.1.1.9", 443),
+					"custom_pool",
+				},
+			},
+			deleted: make(map[string][]string),
+		}
+		mockCRM.Agent = &Agent{
+			DeclWriter:  &PostManager{postChan: postChan},
+			ObjectStore: store,
+		}
+		mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+	})
+
+	AfterEach(func() {
+		DEFAULT_PARTITION = oldPartition
+	})
+
+	It("Prunes the stale objects once the resources are processed", func() {
+		prune()
+		mockCRM.flushConfig()
+
+		Expect(store.deleted).To(Equal(map[string][]string{
+			"test": {formatVirtualServerName("10.1.1.9", 443)},
+		}), "Only the stale objects named like the virtuals should be pruned")
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(ContainSubstring(
+			formatVirtualServerName("10.1.1.1", 443)))
+		Expect(mockCRM.pruned).To(BeTrue())
+	})
+
+	It("Prunes once", func() {
+		prune()
+		mockCRM.flushConfig()
+		Expect(store.deleted["test"]).To(HaveLen(1))
+
+		mockCRM.rscQueue.Add(pruneKey)
+		Expect(mockCRM.processResource()).To(BeTrue())
+		Expect(mockCRM.rscQueue.Len()).To(BeZero())
+		Expect(mockCRM.rebuilding).To(BeFalse())
+	})
+
+	It("Does not prune without the prune queued", func() {
+		process()
+		mockCRM.flushConfig()
+		Expect(postChan).To(Receive())
+		Expect(store.deleted).To(BeEmpty())
+	})
+
+	It("Removes the iRules and data groups left in Common", func() {
+		process()
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).NotTo(ContainSubstring(`"Common"`))
+
+		store.objects["Common"] = []string{HttpRedirectIRuleName + "_443",
+			HttpsRedirectDgName}
+		mockCRM.rebuilding = true
+		mockCRM.configChanged(true)
+		mockCRM.flushConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(ContainSubstring(`"Common"`))
+		Expect(cfg.data).NotTo(ContainSubstring(`"/Common/Shared/`))
+		Expect(store.deleted).To(BeEmpty(),
+			"AS3 should remove the objects after the virtuals referring to them")
+
+		// Unless they are still shared in Common
+		mockCRM.SharedPartition = "Common"
+		Expect(isSharedObjectName("custom_pool")).To(BeFalse())
+		mockCRM.rebuilding = true
+		mockCRM.configChanged(true)
+		mockCRM.flushConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(ContainSubstring(`"Common"`))
+	})
+
+	It("Lists and deletes the objects with AS3", func() {
+		var method, path, body string
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				data, _ := ioutil.ReadAll(r.Body)
+				method, path, body = r.Method, r.URL.Path, string(data)
+				if r.Method == "GET" {
+					w.Write([]byte(`{"class": "ADC", "test": {"class": "Tenant",
+"Shared": {"class": "Application", "template": "shared",
+"f5_crd_virtualserver_10_1_1_9_443": {"class": "Service_HTTPS"},
+"custom_pool": {"class": "Pool"}}}}`))
+				}
+			}))
+		defer server.Close()
+		postMgr := &PostManager{
+			httpClient: server.Client(),
+			PostParams: PostParams{BIGIPURL: server.URL},
+		}
+
+		names, err := postMgr.ListObjects("test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(method).To(Equal("GET"))
+		Expect(path).To(Equal("/mgmt/shared/appsvcs/declare/test"))
+		Expect(names).To(Equal([]string{
+			"custom_pool", "f5_crd_virtualserver_10_1_1_9_443"}))
+
+		Expect(postMgr.DeleteObjects("test",
+			[]string{"f5_crd_virtualserver_10_1_1_9_443"})).To(Succeed())
+		Expect(method).To(Equal("PATCH"))
+		Expect(body).To(MatchJSON(`[{"op": "remove",
+"path": "/test/Shared/f5_crd_virtualserver_10_1_1_9_443"}]`))
+	})
+})
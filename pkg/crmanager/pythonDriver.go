@@ -187,10 +187,15 @@ func (agent *Agent) stopPythonDriver() {
 func (agent *Agent) healthCheckPythonDriver() {
 	// Add health check to track whether Python process still alive
 	hc := &health.HealthChecker{
-		SubPID: agent.PythonDriverPID,
+		SubPID:   agent.PythonDriverPID,
+		Draining: agent.Draining,
+		Liveness: agent.checkLiveness,
 	}
-	http.Handle("/health", hc.HealthCheckHandler())
+	agent.HTTPMux.Handle("/health", hc.HealthCheckHandler())
 
-	httpAddress := "0.0.0.0:8080"
-	log.Fatal(http.ListenAndServe(httpAddress, nil).Error())
+	httpAddress := agent.httpAddress
+	if httpAddress == "" {
+		httpAddress = "0.0.0.0:8080"
+	}
+	log.Fatal(http.ListenAndServe(httpAddress, agent.HTTPMux).Error())
 }
@@ -0,0 +1,160 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sort"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+// Resources counted against the namespace quota
+const (
+	quotaVirtualServers   = "virtualservers"
+	quotaVirtualAddresses = "virtualaddresses"
+	quotaPools            = "pools"
+)
+
+// namespaceUsage returns the count of objects used by the admitted
+// VirtualServers of the namespace, along with the given VirtualServer.
+// quotaMutex must be held.
+func (crMgr *CRManager) namespaceUsage(
+	namespace string,
+	vs *cisapiv1.VirtualServer,
+) map[string]int {
+	virtuals := make(map[string]*cisapiv1.VirtualServer)
+	for key, virtual := range crMgr.admittedVirtuals {
+		if virtual.ObjectMeta.Namespace == namespace {
+			virtuals[key] = virtual
+		}
+	}
+	if nil != vs {
+		virtuals[vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name] = vs
+	}
+
+	addresses := make(map[string]bool)
+	pools := make(map[string]bool)
+	for _, virtual := range virtuals {
+		addresses[crMgr.getVirtualServerAddress(virtual)] = true
+		for _, pl := range getVirtualServerPools(virtual) {
+			if nil != pl.Action {
+				continue
+			}
+			pools[getVirtualServerPoolName(namespace, pl)] = true
+		}
+		if nil != virtual.Spec.DefaultPool {
+			pools[formatDefaultPoolName(
+				namespace, virtual.Spec.DefaultPool)] = true
+		}
+	}
+	return map[string]int{
+		quotaVirtualServers:   len(virtuals),
+		quotaVirtualAddresses: len(addresses),
+		quotaPools:            len(pools),
+	}
+}
+
+// limits returns the quota of each resource
+func (quota NamespaceQuota) limits() map[string]int {
+	return map[string]int{
+		quotaVirtualServers:   quota.MaxVirtualServers,
+		quotaVirtualAddresses: quota.MaxVirtualAddresses,
+		quotaPools:            quota.MaxPools,
+	}
+}
+
+// admitVirtualServer returns true if the VirtualServer is within the quota
+// of its namespace. A VirtualServer beyond the quota is stored, so that it
+// can be admitted when the usage of the namespace comes down.
+func (crMgr *CRManager) admitVirtualServer(vs *cisapiv1.VirtualServer) bool {
+	crMgr.quotaMutex.Lock()
+	defer crMgr.quotaMutex.Unlock()
+	namespace := vs.ObjectMeta.Namespace
+	vsKey := namespace + "/" + vs.ObjectMeta.Name
+	usage := crMgr.namespaceUsage(namespace, vs)
+	for rsc, limit := range crMgr.NamespaceQuota.limits() {
+		if limit > 0 && usage[rsc] > limit {
+			log.Errorf("VirtualServer %s rejected, namespace %s exceeds "+
+				"quota of %v %s", vsKey, namespace, limit, rsc)
+			crMgr.rejectedVirtuals[vsKey] = vs
+			return false
+		}
+	}
+	delete(crMgr.rejectedVirtuals, vsKey)
+	crMgr.admittedVirtuals[vsKey] = vs
+	crMgr.updateQuotaMetrics(namespace)
+	return true
+}
+
+// releaseVirtualServer releases the quota used by a deleted VirtualServer
+// and re-queues the rejected VirtualServers of the namespace in the order
+// of their creation.
+func (crMgr *CRManager) releaseVirtualServer(vs *cisapiv1.VirtualServer) {
+	crMgr.quotaMutex.Lock()
+	defer crMgr.quotaMutex.Unlock()
+	namespace := vs.ObjectMeta.Namespace
+	vsKey := namespace + "/" + vs.ObjectMeta.Name
+	delete(crMgr.rejectedVirtuals, vsKey)
+	if _, ok := crMgr.admittedVirtuals[vsKey]; !ok {
+		return
+	}
+	delete(crMgr.admittedVirtuals, vsKey)
+	crMgr.updateQuotaMetrics(namespace)
+
+	var rejected []*cisapiv1.VirtualServer
+	for _, virtual := range crMgr.rejectedVirtuals {
+		if virtual.ObjectMeta.Namespace == namespace {
+			rejected = append(rejected, virtual)
+		}
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		ti := rejected[i].ObjectMeta.CreationTimestamp
+		tj := rejected[j].ObjectMeta.CreationTimestamp
+		if ti.Equal(&tj) {
+			return rejected[i].ObjectMeta.Name < rejected[j].ObjectMeta.Name
+		}
+		return ti.Before(&tj)
+	})
+	for _, virtual := range rejected {
+		crMgr.enqueueVirtualServer(virtual)
+	}
+}
+
+// getAdmittedVirtualServer returns the VirtualServer last admitted with the
+// key namespace/name
+func (crMgr *CRManager) getAdmittedVirtualServer(
+	vsKey string,
+) (*cisapiv1.VirtualServer, bool) {
+	crMgr.quotaMutex.Lock()
+	defer crMgr.quotaMutex.Unlock()
+	vs, ok := crMgr.admittedVirtuals[vsKey]
+	return vs, ok
+}
+
+// updateQuotaMetrics updates the usage and quota metrics of the namespace.
+// quotaMutex must be held.
+func (crMgr *CRManager) updateQuotaMetrics(namespace string) {
+	usage := crMgr.namespaceUsage(namespace, nil)
+	for rsc, limit := range crMgr.NamespaceQuota.limits() {
+		bigIPPrometheus.NamespaceQuotaUsage.WithLabelValues(
+			namespace, rsc).Set(float64(usage[rsc]))
+		bigIPPrometheus.NamespaceQuota.WithLabelValues(
+			namespace, rsc).Set(float64(limit))
+	}
+}
@@ -0,0 +1,111 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Namespace Quota Tests", func() {
+	var mockCRM *mockCRManager
+	var newVS func(name, address string, created int, svcs ...string) *cisapiv1.VirtualServer
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		newVS = func(name, address string, created int, svcs ...string) *cisapiv1.VirtualServer {
+			var pools []cisapiv1.Pool
+			for _, svc := range svcs {
+				pools = append(pools, cisapiv1.Pool{
+					Path:        "/" + svc,
+					Service:     svc,
+					ServicePort: intstr.FromInt(80),
+				})
+			}
+			vs := test.NewVirtualServer(name, "default",
+				cisapiv1.VirtualServerSpec{
+					Host:                 name + ".com",
+					VirtualServerAddress: address,
+					Pools:                pools,
+				})
+			vs.ObjectMeta.CreationTimestamp = metav1.NewTime(
+				time.Unix(int64(created), 0))
+			return vs
+		}
+	})
+
+	It("Admits all VirtualServers without quota", func() {
+		for i, name := range []string{"vs1", "vs2", "vs3"} {
+			Expect(mockCRM.admitVirtualServer(
+				newVS(name, "1.2.3.4", i, "svc1", "svc2"))).To(BeTrue())
+		}
+	})
+
+	It("Rejects VirtualServers beyond quota", func() {
+		mockCRM.NamespaceQuota = NamespaceQuota{MaxVirtualServers: 2}
+		Expect(mockCRM.admitVirtualServer(newVS("vs1", "1.2.3.4", 1))).To(BeTrue())
+		Expect(mockCRM.admitVirtualServer(newVS("vs2", "1.2.3.4", 2))).To(BeTrue())
+		Expect(mockCRM.admitVirtualServer(newVS("vs3", "1.2.3.4", 3))).To(BeFalse())
+		Expect(mockCRM.rejectedVirtuals).To(HaveKey("default/vs3"))
+		// Updating an admitted VirtualServer is within the quota
+		Expect(mockCRM.admitVirtualServer(newVS("vs1", "1.2.3.5", 1))).To(BeTrue())
+		// Other namespaces have their own quota
+		otherVS := newVS("vs3", "1.2.3.4", 3)
+		otherVS.ObjectMeta.Namespace = "other"
+		Expect(mockCRM.admitVirtualServer(otherVS)).To(BeTrue())
+	})
+
+	It("Rejects VirtualServers beyond address and pool quota", func() {
+		mockCRM.NamespaceQuota = NamespaceQuota{
+			MaxVirtualAddresses: 1,
+			MaxPools:            2,
+		}
+		Expect(mockCRM.admitVirtualServer(
+			newVS("vs1", "1.2.3.4", 1, "svc1"))).To(BeTrue())
+		Expect(mockCRM.admitVirtualServer(
+			newVS("vs2", "1.2.3.5", 2, "svc1"))).To(BeFalse(),
+			"VirtualServer on a new address should be rejected")
+		Expect(mockCRM.admitVirtualServer(
+			newVS("vs2", "1.2.3.4", 2, "svc1", "svc2"))).To(BeTrue(),
+			"Pool shared with admitted VirtualServer counts once")
+		Expect(mockCRM.admitVirtualServer(
+			newVS("vs3", "1.2.3.4", 3, "svc3"))).To(BeFalse())
+	})
+
+	It("Re-admits rejected VirtualServers in creation order", func() {
+		mockCRM.NamespaceQuota = NamespaceQuota{MaxVirtualServers: 1}
+		vs1 := newVS("vs1", "1.2.3.4", 1)
+		Expect(mockCRM.admitVirtualServer(vs1)).To(BeTrue())
+		Expect(mockCRM.admitVirtualServer(newVS("vs4", "1.2.3.4", 4))).To(BeFalse())
+		Expect(mockCRM.admitVirtualServer(newVS("vs2", "1.2.3.4", 2))).To(BeFalse())
+		Expect(mockCRM.admitVirtualServer(newVS("vs3", "1.2.3.4", 3))).To(BeFalse())
+
+		mockCRM.releaseVirtualServer(vs1)
+		Expect(mockCRM.admittedVirtuals).To(BeEmpty())
+		var names []string
+		for _, key := range mockCRM.drainQueue() {
+			names = append(names, key.rscName)
+		}
+		Expect(names).To(Equal([]string{"vs2", "vs3", "vs4"}))
+	})
+})
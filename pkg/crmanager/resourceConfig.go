@@ -18,17 +18,24 @@ package crmanager
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 )
 
@@ -41,11 +48,22 @@ func NewResources() *Resources {
 
 // Resources is Map of Resource configs
 type Resources struct {
-	sync.Mutex
+	// Guards rsMap, oldRsMap, objDeps and the owners of the configs, which
+	// are read by the status worker and the other workers. Use the methods
+	// of Resources to access them.
+	sync.RWMutex
 	rm       resourceKeyMap
 	rsMap    ResourceConfigMap
 	objDeps  ObjectDependencyMap
 	oldRsMap ResourceConfigMap
+	// Virtuals by address and port, as the virtuals named after their
+	// resources are merged by address too
+	addrMap map[string]NameRef
+	// Wide-IPs of the ExternalDNS resources, nil until an ExternalDNS
+	// is processed so the GSLB objects in /Common are only managed when
+	// ExternalDNS is used.
+	dnsConfig    DNSConfig
+	oldDNSConfig DNSConfig
 }
 
 // Init is Receiver to initialize the object.
@@ -54,6 +72,7 @@ func (rs *Resources) Init() {
 	rs.rsMap = make(ResourceConfigMap)
 	rs.objDeps = make(ObjectDependencyMap)
 	rs.oldRsMap = make(ResourceConfigMap)
+	rs.addrMap = make(map[string]NameRef)
 }
 
 type mergedRuleEntry struct {
@@ -69,8 +88,9 @@ type resourceList map[string]bool
 // Key is namespace/servicename/serviceport, value is map of resources.
 type resourceKeyMap map[serviceKey]resourceList
 
-// ResourceConfigMap key is resource name, value is pointer to config. May be shared.
-type ResourceConfigMap map[string]*ResourceConfig
+// ResourceConfigMap key is the partition and name of the virtual, value is
+// pointer to config. May be shared.
+type ResourceConfigMap map[NameRef]*ResourceConfig
 
 // ObjectDependency TODO => dep can be replaced with  internal DS rqkey
 // ObjectDependency identifies a K8s Object
@@ -79,6 +99,9 @@ type ObjectDependency struct {
 	Namespace string
 	Name      string
 	Service   string
+	// Pool the rule forwards to, identifies the owner of the rule when
+	// VirtualServers share a virtual.
+	Pool string
 }
 
 // ObjectDependencyMap key is a VirtualServer and the value is a
@@ -106,15 +129,57 @@ const (
 	CustomProfileClient string = "clientside"
 	CustomProfileServer string = "serverside"
 
+	// Constants for ProfileRef.Type of the profiles referenced by the
+	// VirtualServer, SSL profiles have no type.
+	ProfileTypeHTTP = "http"
+	ProfileTypeTCP  = "tcp"
+
 	// Constants for CustomProfile.PeerCertMode
-	PeerCertRequired = "require"
-	PeerCertIgnored  = "ignore"
-	PeerCertDefault  = PeerCertIgnored
+	PeerCertRequired  = "require"
+	PeerCertRequested = "request"
+	PeerCertIgnored   = "ignore"
+	PeerCertDefault   = PeerCertIgnored
 
 	// Constants
 	HttpRedirectIRuleName = "http_redirect_irule"
 	// Internal data group for https redirect
 	HttpsRedirectDgName = "https_redirect_dg"
+	// DefaultRedirectCode is the status code of the redirects to HTTPS
+	DefaultRedirectCode int32 = 302
+	// AbDeploymentPathIRuleName selects the pool of A/B deployments
+	AbDeploymentPathIRuleName = "ab_deployment_path_irule"
+	// ProxyProtocolIRuleName sends the PROXY protocol header to the
+	// ingress controller of an IngressLink
+	ProxyProtocolIRuleName = "proxy_protocol_irule"
+	// SslPassthroughIRuleName forwards the TLS traffic of passthrough hosts
+	SslPassthroughIRuleName = "ssl_passthrough_irule"
+	// SslReencryptIRuleName selects the server SSL profile of reencrypt
+	// hosts
+	SslReencryptIRuleName = "ssl_reencrypt_irule"
+	// HstsIRuleName inserts the Strict-Transport-Security header in the
+	// HTTPS responses of the hosts with HSTS
+	HstsIRuleName = "hsts_irule"
+	// DefaultHSTSMaxAge is the max-age of the HSTS header without maxAge
+	DefaultHSTSMaxAge int64 = 31536000
+	// DefaultPoolWeight is the weight of pools without weight
+	DefaultPoolWeight int32 = 100
+	// DefaultServicePort is the servicePort of the pools without
+	// servicePort and ports
+	DefaultServicePort = 80
+	// PoolActionIRuleName drops and redirects the requests of the paths
+	// with an action, suffixed with the name of the virtual
+	PoolActionIRuleName = "pool_action_irule"
+
+	// Constants for the action of the pools without service
+	PoolActionReset    = "reset"
+	PoolActionDrop     = "drop"
+	PoolActionRedirect = "redirect"
+	// resetRuleSuffix ends the names of the rules of the pool actions,
+	// these rules are not merged with other rules
+	resetRuleSuffix = "-reset"
+	// maintenanceRuleSuffix ends the names of the maintenance rules of the
+	// hosts, these rules are not merged with other rules either
+	maintenanceRuleSuffix = "-maintenance"
 )
 
 // constants for TLS references
@@ -125,6 +190,16 @@ const (
 	Secret = "secret"
 )
 
+// constants for TLS termination
+const (
+	// TLS is terminated on BIG-IP, plain traffic to the pool members
+	TLSEdge = "edge"
+	// TLS is terminated on BIG-IP, and encrypted again to the pool members
+	TLSReencrypt = "reencrypt"
+	// TLS is terminated on the pool members
+	TLSPassthrough = "passthrough"
+)
+
 // ObjectDependencies contains each dependency and its use count (usually 1)
 type ObjectDependencies map[ObjectDependency]int
 
@@ -132,12 +207,15 @@ type ObjectDependencies map[ObjectDependency]int
 type CustomProfileStore struct {
 	sync.Mutex
 	Profs map[SecretKey]CustomProfile
+	// refs are the VirtualServers using each profile created from a secret
+	refs map[SecretKey]map[string]bool
 }
 
 func NewCustomProfile(
 	profile ProfileRef,
 	cert,
 	key,
+	chainCert,
 	serverName string,
 	sni bool,
 	peerCertMode,
@@ -149,11 +227,12 @@ func NewCustomProfile(
 		Context:      profile.Context,
 		Cert:         cert,
 		Key:          key,
+		ChainCert:    chainCert,
 		ServerName:   serverName,
 		SNIDefault:   sni,
 		PeerCertMode: peerCertMode,
 	}
-	if peerCertMode == PeerCertRequired {
+	if peerCertMode == PeerCertRequired || peerCertMode == PeerCertRequested {
 		cp.CAFile = caFile
 	}
 	return cp
@@ -163,9 +242,98 @@ func NewCustomProfile(
 func NewCustomProfiles() *CustomProfileStore {
 	var cps CustomProfileStore
 	cps.Profs = make(map[SecretKey]CustomProfile)
+	cps.refs = make(map[SecretKey]map[string]bool)
 	return &cps
 }
 
+// reset forgets all the profiles
+func (cps *CustomProfileStore) reset() {
+	cps.Lock()
+	defer cps.Unlock()
+	cps.Profs = make(map[SecretKey]CustomProfile)
+	cps.refs = make(map[SecretKey]map[string]bool)
+}
+
+// selectSNIDefault makes the profile of the virtual with the highest SNI
+// priority the SNI default, the first by name among equals. It returns the
+// name of the default and the names of the profiles requesting to be the
+// default.
+func (cps *CustomProfileStore) selectSNIDefault(
+	partition string,
+	rsName string,
+) (string, []string) {
+	var keys []SecretKey
+	var requested []string
+	for key, prof := range cps.Profs {
+		// The profiles without certificate are not served
+		if key.ResourceName != rsName || key.Partition != partition ||
+			prof.Context != CustomProfileClient ||
+			prof.Cert == "" || prof.Key == "" {
+			continue
+		}
+		keys = append(keys, key)
+		if prof.sniPriority == sniPriorityRequested {
+			requested = append(requested, key.Name)
+		}
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi := cps.Profs[keys[i]].sniPriority
+		pj := cps.Profs[keys[j]].sniPriority
+		if pi != pj {
+			return pi > pj
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	for i, key := range keys {
+		prof := cps.Profs[key]
+		prof.SNIDefault = i == 0
+		cps.Profs[key] = prof
+	}
+	sort.Strings(requested)
+	return keys[0].Name, requested
+}
+
+// addRef records the VirtualServer as a user of the profile.
+func (cps *CustomProfileStore) addRef(key SecretKey, vsKey string) {
+	if _, ok := cps.refs[key]; !ok {
+		cps.refs[key] = make(map[string]bool)
+	}
+	cps.refs[key][vsKey] = true
+}
+
+// releaseRefs removes the VirtualServer from the users of all the profiles
+// and returns the profiles it was using.
+func (cps *CustomProfileStore) releaseRefs(vsKey string) []SecretKey {
+	var keys []SecretKey
+	for key, vsKeys := range cps.refs {
+		if !vsKeys[vsKey] {
+			continue
+		}
+		keys = append(keys, key)
+		delete(vsKeys, vsKey)
+	}
+	return keys
+}
+
+// deleteUnreferenced deletes the profiles without users and returns them.
+func (cps *CustomProfileStore) deleteUnreferenced(keys []SecretKey) []SecretKey {
+	var deleted []SecretKey
+	for _, key := range keys {
+		if len(cps.refs[key]) > 0 {
+			continue
+		}
+		delete(cps.refs, key)
+		if _, ok := cps.Profs[key]; ok {
+			delete(cps.Profs, key)
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted
+}
+
 func NewIRule(name, partition, code string) *IRule {
 	return &IRule{
 		Name:      name,
@@ -174,11 +342,13 @@ func NewIRule(name, partition, code string) *IRule {
 	}
 }
 
-// Creates an IRule if it doesn't already exist
+// Creates an IRule of the virtuals of the partition if it doesn't already
+// exist, in the partition of the shared objects
 func (crMgr *CRManager) addIRule(name, partition, rule string) {
 	crMgr.irulesMutex.Lock()
 	defer crMgr.irulesMutex.Unlock()
 
+	partition = crMgr.objectsPartition(partition)
 	key := NameRef{
 		Name:      name,
 		Partition: partition,
@@ -188,20 +358,42 @@ func (crMgr *CRManager) addIRule(name, partition, rule string) {
 	}
 }
 
-// Creates an InternalDataGroup if it doesn't already exist
+// Creates an InternalDataGroup of the virtuals of the partition if it
+// doesn't already exist, in the partition of the shared objects
 func (crMgr *CRManager) addInternalDataGroup(name, partition string) {
 	crMgr.intDgMutex.Lock()
 	defer crMgr.intDgMutex.Unlock()
 
 	key := NameRef{
 		Name:      name,
-		Partition: partition,
+		Partition: crMgr.objectsPartition(partition),
 	}
 	if _, found := crMgr.intDgMap[key]; !found {
 		crMgr.intDgMap[key] = make(DataGroupNamespaceMap)
 	}
 }
 
+// objectsPartition returns the partition of the iRules and data groups of
+// the virtuals of the partition, SharedPartition when set.
+func (crMgr *CRManager) objectsPartition(partition string) string {
+	if crMgr.SharedPartition != "" {
+		return crMgr.SharedPartition
+	}
+	return partition
+}
+
+// iRulePath returns the path the virtuals of the partition refer to the
+// iRule with. The iRules of another partition are referred to by their
+// path in its Shared application.
+func (crMgr *CRManager) iRulePath(partition, name string) string {
+	objectsPartition := crMgr.objectsPartition(partition)
+	if objectsPartition == partition {
+		return JoinBigipPath(partition, name)
+	}
+	return fmt.Sprintf("/%s/%s/%s", objectsPartition, as3SharedApplication,
+		name)
+}
+
 func JoinBigipPath(partition, objName string) string {
 	if objName == "" {
 		return ""
@@ -223,6 +415,17 @@ func (v *Virtual) AddIRule(ruleName string) bool {
 	return true
 }
 
+// Removes an IRule reference from a Virtual object
+func (v *Virtual) RemoveIRule(ruleName string) bool {
+	for i, irule := range v.IRules {
+		if irule == ruleName {
+			v.IRules = append(v.IRules[:i], v.IRules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // NewObjectDependencies parses an object and returns a map of its dependencies
 func NewObjectDependencies(
 	obj interface{},
@@ -237,15 +440,46 @@ func NewObjectDependencies(
 	}
 
 	deps[key] = 1
-	for _, pool := range virtual.Spec.Pools {
+	for _, host := range getVirtualServerHosts(virtual) {
+		for _, pool := range getVirtualServerPools(virtual) {
+			dep := ObjectDependency{
+				Kind:      RuleDep,
+				Namespace: virtual.ObjectMeta.Namespace,
+				Name:      host + pool.Path,
+				Service:   pool.Service,
+			}
+			// The rules of the paths with an action have no pool
+			if nil == pool.Action {
+				dep.Pool = getVirtualServerPoolName(
+					virtual.ObjectMeta.Namespace, pool)
+			}
+			deps[dep]++
+		}
+		// The maintenance rule of the host, forwarding to the maintenance
+		// pool
+		if mt := virtual.Spec.Maintenance; nil != mt && mt.Enabled {
+			dep := ObjectDependency{
+				Kind:      RuleDep,
+				Namespace: virtual.ObjectMeta.Namespace,
+				Name:      host,
+				Pool:      mt.Pool,
+			}
+			deps[dep]++
+		}
+	}
+	if virtual.Spec.PolicyName != "" {
 		dep := ObjectDependency{
-			Kind:      RuleDep,
+			Kind:      CustomPolicy,
 			Namespace: virtual.ObjectMeta.Namespace,
-			Name:      virtual.Spec.Host + pool.Path,
-			Service:   pool.Service,
+			Name:      virtual.Spec.PolicyName,
 		}
 		deps[dep]++
 	}
+	// The membership of the host group, the members on the address of the
+	// group are removed from it when the address changes
+	if virtual.Spec.HostGroup != "" {
+		deps[hostGroupDependency(virtual.Spec.HostGroup)]++
+	}
 	return key, deps
 }
 
@@ -290,9 +524,12 @@ func (crMgr *CRManager) virtualPorts(vs *cisapiv1.VirtualServer) []portStruct {
 	}
 	var ports []portStruct
 
-	if 0 != len(vs.Spec.TLSProfileName) {
+	if 0 != len(getTLSProfileNames(vs)) {
 		// 2 virtual servers needed, both HTTP and HTTPS
-		ports = append(ports, http)
+		// httpTraffic = none -> Only HTTPS, HTTP is not served at all
+		if vs.Spec.HTTPTraffic != "none" {
+			ports = append(ports, http)
+		}
 		ports = append(ports, https)
 	} else {
 		// HTTP only
@@ -304,254 +541,1674 @@ func (crMgr *CRManager) virtualPorts(vs *cisapiv1.VirtualServer) []portStruct {
 
 // format the virtual server name for an VirtualServer
 func formatVirtualServerName(ip string, port int32) string {
-	// Strip any bracket characters; replace special characters ". : /"
-	// with "-" and "%" with ".", for naming purposes
-	ip = strings.Trim(ip, "[]")
-	ip = AS3NameFormatter(ip)
-	return fmt.Sprintf("f5_crd_virtualserver_%s_%d", ip, port)
+	return namer.VirtualServerName(normalizeAddress(ip), port)
+}
+
+// format the virtual server name for an VirtualServer named after it
+func formatVirtualServerResourceName(namespace, name string, port int32) string {
+	return AS3NameFormatter(fmt.Sprintf("%s_%s_%d", namespace, name, port))
+}
+
+// getVirtualServerPartition returns the BIG-IP partition of the virtual
+// and pools of a VirtualServer, the partition of CIS unless set on it.
+func (crMgr *CRManager) getVirtualServerPartition(
+	vs *cisapiv1.VirtualServer,
+) string {
+	if vs.Spec.Partition != "" {
+		return vs.Spec.Partition
+	}
+	return crMgr.getNamespacePartition(vs.ObjectMeta.Namespace)
+}
+
+// getNamespacePartition returns the partition of the resources of a
+// namespace, the partition of CIS unless mapped to another partition.
+func (crMgr *CRManager) getNamespacePartition(namespace string) string {
+	if partition, ok := crMgr.NamespacePartitions[namespace]; ok {
+		return partition
+	}
+	return crMgr.Partition
+}
+
+// getVirtualServerName returns the name of the BIG-IP virtual created for
+// a VirtualServer on the given port. With UseResourceNames the virtual is
+// named after the VirtualServer, unless the address and port are served by
+// a virtual already, or the name is used by a virtual on another address.
+func (crMgr *CRManager) getVirtualServerName(
+	vs *cisapiv1.VirtualServer,
+	port int32,
+) string {
+	bindAddr := crMgr.getVirtualServerBindAddr(vs)
+	if !crMgr.UseResourceNames {
+		return formatVirtualServerName(bindAddr, port)
+	}
+	if ref, ok := crMgr.resources.getVirtualByAddress(bindAddr, port); ok {
+		return ref.Name
+	}
+	rsName := formatVirtualServerResourceName(
+		vs.ObjectMeta.Namespace, vs.ObjectMeta.Name, port)
+	if rsCfg, ok := crMgr.resources.GetByName(
+		crMgr.getVirtualServerPartition(vs), rsName); ok &&
+		virtualAddressKey(rsCfg.Virtual.VirtualAddress) !=
+			virtualAddressKey(&virtualAddress{BindAddr: bindAddr, Port: port}) {
+		return formatVirtualServerName(bindAddr, port)
+	}
+	return rsName
+}
+
+// getVirtualServerRef returns the partition and name of the BIG-IP virtual
+// created for a VirtualServer on the given port.
+func (crMgr *CRManager) getVirtualServerRef(
+	vs *cisapiv1.VirtualServer,
+	port int32,
+) NameRef {
+	return NameRef{
+		Name:      crMgr.getVirtualServerName(vs, port),
+		Partition: crMgr.getVirtualServerPartition(vs),
+	}
+}
+
+// getVirtualServerBindAddr returns the address of the virtuals of a
+// VirtualServer, in the route domain of its namespace.
+func (crMgr *CRManager) getVirtualServerBindAddr(
+	vs *cisapiv1.VirtualServer,
+) string {
+	return formatRouteDomainAddress(
+		crMgr.getVirtualServerAddress(vs),
+		crMgr.getRouteDomain(vs.ObjectMeta.Namespace),
+	)
 }
 
 // format the pool name for an VirtualServer
-func formatVirtualServerPoolName(namespace, svc string, nodeMemberLabel string) string {
-	poolName := fmt.Sprintf("%s_%s", namespace, svc)
-	if nodeMemberLabel != "" {
-		poolName = fmt.Sprintf("%s_%s", poolName, nodeMemberLabel)
+func formatVirtualServerPoolName(namespace, svc, port, nodeMemberLabel string) string {
+	return namer.PoolName(namespace, svc, port, nodeMemberLabel)
+}
+
+// formatPoolPort returns the port of the service in the pool name, empty
+// when the pool uses all the ports of the service.
+func formatPoolPort(port intstr.IntOrString) string {
+	if port.Type == intstr.Int && port.IntVal == 0 {
+		return ""
+	}
+	return port.String()
+}
+
+// getVirtualServerPoolName returns the name of the BIG-IP pool of a
+// VirtualServer pool. The pools of static members only are named after a
+// hash of the members, as they have no service.
+func getVirtualServerPoolName(namespace string, pl cisapiv1.Pool) string {
+	if pl.Service == "" && len(pl.StaticMembers) > 0 {
+		h := fnv.New32a()
+		for _, m := range pl.StaticMembers {
+			fmt.Fprintf(h, "%s:%d,", m.Address, m.Port)
+		}
+		return formatVirtualServerPoolName(namespace,
+			fmt.Sprintf("static_%08x", h.Sum32()), "", formatPoolNodeSelector(pl))
+	}
+	return formatVirtualServerPoolName(namespace, pl.Service,
+		formatPoolPort(pl.ServicePort), formatPoolNodeSelector(pl))
+}
+
+// getPoolNodeSelector returns the label selector of the nodes of the pool
+// members, the nodeMemberLabel and the requirements of the
+// nodeMemberSelector all apply. It is empty when all the nodes are members.
+func getPoolNodeSelector(pl cisapiv1.Pool) (string, error) {
+	if nil == pl.NodeMemberSelector {
+		return pl.NodeMemberLabel, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pl.NodeMemberSelector)
+	if err != nil {
+		return "", err
+	}
+	if pl.NodeMemberLabel != "" {
+		nodeLabel, err := labels.Parse(pl.NodeMemberLabel)
+		if err != nil {
+			return "", err
+		}
+		requirements, _ := nodeLabel.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	// The requirements are sorted, the same selector has the same string
+	return selector.String(), nil
+}
+
+// formatPoolNodeSelector returns the node selector in the pool name. The
+// pools with a nodeMemberLabel only keep their names, the ones with a
+// nodeMemberSelector are named after a hash of the selector.
+func formatPoolNodeSelector(pl cisapiv1.Pool) string {
+	if nil == pl.NodeMemberSelector {
+		return pl.NodeMemberLabel
+	}
+	selector, _ := getPoolNodeSelector(pl)
+	if selector == "" {
+		return ""
+	}
+	h := fnv.New32a()
+	fmt.Fprint(h, selector)
+	return fmt.Sprintf("sel_%08x", h.Sum32())
+}
+
+// formatDefaultPoolName returns the name of the BIG-IP pool of the
+// defaultPool of a VirtualServer
+func formatDefaultPoolName(namespace string, dp *cisapiv1.DefaultPool) string {
+	return formatVirtualServerPoolName(namespace, dp.Service,
+		formatPoolPort(intstr.FromInt(int(dp.ServicePort))), "")
+}
+
+// getVirtualServerPools returns the pools of the VirtualServer, with a pool
+// for each of the ports of a pool. The pools of a service without
+// servicePort and ports get DefaultServicePort, which the schema of the CRD
+// cannot default as the pools with ports have no servicePort.
+func getVirtualServerPools(vs *cisapiv1.VirtualServer) []cisapiv1.Pool {
+	var pools []cisapiv1.Pool
+	for _, pl := range vs.Spec.Pools {
+		if len(pl.Ports) == 0 {
+			if pl.Service != "" && formatPoolPort(pl.ServicePort) == "" {
+				pl.ServicePort = intstr.FromInt(DefaultServicePort)
+			}
+			pools = append(pools, pl)
+			continue
+		}
+		for _, port := range pl.Ports {
+			pool := pl
+			pool.Path = port.Path
+			pool.ServicePort = port.ServicePort
+			pool.Ports = nil
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// getVirtualServerHosts returns the hosts of the VirtualServer, its hosts
+// or else its host.
+func getVirtualServerHosts(vs *cisapiv1.VirtualServer) []string {
+	if len(vs.Spec.Hosts) > 0 {
+		return vs.Spec.Hosts
+	}
+	return []string{vs.Spec.Host}
+}
+
+// hasVirtualServerHost returns true if the VirtualServer serves the host
+func hasVirtualServerHost(vs *cisapiv1.VirtualServer, host string) bool {
+	for _, vsHost := range getVirtualServerHosts(vs) {
+		if strings.EqualFold(vsHost, host) {
+			return true
+		}
 	}
-	return AS3NameFormatter(poolName)
+	return false
+}
+
+// getStaticMembers returns the static members of the pool.
+func getStaticMembers(pl cisapiv1.Pool) []Member {
+	var members []Member
+	for _, m := range pl.StaticMembers {
+		members = append(members, Member{
+			Address: normalizeAddress(m.Address),
+			Port:    m.Port,
+			Session: "user-enabled",
+			Static:  true,
+		})
+	}
+	return members
 }
 
 // Creates resource config based on VirtualServer resource config
 func (crMgr *CRManager) createRSConfigFromVirtualServer(
 	vs *cisapiv1.VirtualServer,
 	pStruct portStruct,
-) *ResourceConfig {
+) (*ResourceConfig, error) {
 
 	var cfg ResourceConfig
-	var bindAddr string
 	var pools Pools
 	var rules *Rules
 	var plcy *Policy
 
-	cfg.Virtual.Partition = crMgr.Partition
+	bigIPPrometheus.VirtualServersProcessed.WithLabelValues(
+		vs.ObjectMeta.Namespace).Inc()
 
-	if vs.Spec.VirtualServerAddress == "" {
-		// Virtual Server IP is not given, exit with error log.
-		log.Error("VirtualServer IP Address is not provided.  " +
-			"Create VirtualServer with 'virtual.spec.VirtualServerAddress'.")
-	} else {
-		bindAddr = vs.Spec.VirtualServerAddress
+	// The settings of the Policy apply unless set on the VirtualServer.
+	customPolicy, _ := crMgr.getPolicyForVirtualServer(vs)
+	vs = applyPolicy(vs, customPolicy)
+
+	address := crMgr.getVirtualServerAddress(vs)
+	if err := validateVirtualServerAddress(address); err != nil {
+		bigIPPrometheus.VirtualServerErrors.WithLabelValues(
+			vs.ObjectMeta.Namespace).Inc()
+		return nil, err
 	}
+	bindAddr := formatRouteDomainAddress(address,
+		crMgr.getRouteDomain(vs.ObjectMeta.Namespace))
+
+	// VirtualServers sharing the same address and port are served by the
+	// same virtual on BIG-IP, keep the pools and rules of the other
+	// VirtualServers.
+	if oldCfg, ok := crMgr.resources.GetByName(
+		crMgr.getVirtualServerPartition(vs),
+		crMgr.getVirtualServerName(vs, pStruct.port)); ok {
+		oldCfg.DeepCopyInto(&cfg)
+	}
+
+	cfg.Virtual.Partition = crMgr.getVirtualServerPartition(vs)
+
 	// Create VirtualServer in resource config.
-	cfg.Virtual.Name = formatVirtualServerName(bindAddr, pStruct.port)
+	cfg.Virtual.Name = crMgr.getVirtualServerName(vs, pStruct.port)
 
-	for _, pl := range vs.Spec.Pools {
+	for _, pl := range getVirtualServerPools(vs) {
+		// Requests of the paths with an action are not forwarded
+		if nil != pl.Action {
+			continue
+		}
+		// The validation rejects the invalid selectors
+		nodeSelector, _ := getPoolNodeSelector(pl)
 		pool := Pool{
-			Name: formatVirtualServerPoolName(
-				vs.ObjectMeta.Namespace,
-				pl.Service,
-				pl.NodeMemberLabel,
-			),
-			Partition:       cfg.Virtual.Partition,
-			ServiceName:     pl.Service,
-			ServicePort:     pl.ServicePort,
-			NodeMemberLabel: pl.NodeMemberLabel,
+			Name:             getVirtualServerPoolName(vs.ObjectMeta.Namespace, pl),
+			Partition:        cfg.Virtual.Partition,
+			ServiceName:      pl.Service,
+			ServiceNamespace: vs.ObjectMeta.Namespace,
+			ServicePort:      pl.ServicePort.IntVal,
+			NodeMemberLabel:  nodeSelector,
+			MemberType:       crMgr.getPoolMemberType(pl.PoolMemberType),
+			IncludeNotReady:  pl.IncludeNotReady,
+			Backup:           pl.BackupPool,
+			PriorityGroup:    pl.PriorityGroup,
+			MinActiveMembers: pl.MinActiveMembers,
+			StaticMembers:    getStaticMembers(pl),
+			// The CRD schema defaults it to none, like BIG-IP does when
+			// the declaration omits it
+			ServiceDownAction: pl.ServiceDownAction,
+			MinimumMonitors:   pl.MinimumMonitors,
+		}
+		for _, monitor := range pl.Monitors {
+			pool.MonitorNames = append(pool.MonitorNames,
+				formatMonitorName(monitor))
+		}
+		if nil != pl.Monitor {
+			pool.Monitor = &Monitor{
+				Name:      pool.Name + "_monitor",
+				Partition: pool.Partition,
+				Type:      pl.Monitor.Type,
+				Send:      pl.Monitor.Send,
+				Recv:      pl.Monitor.Recv,
+				Interval:  pl.Monitor.Interval,
+				Timeout:   pl.Monitor.Timeout,
+				// Decorated with the route domain of the virtual by
+				// updateRouteDomainAddresses
+				TargetAddress: normalizeAddress(pl.Monitor.TargetAddress),
+				TargetPort:    pl.Monitor.TargetPort,
+			}
+		}
+		if pl.ServicePort.Type == intstr.String {
+			pool.ServicePortName = pl.ServicePort.StrVal
+		}
+		pool.Members = append([]Member{}, pool.StaticMembers...)
+		if nil != pool.Backup {
+			if pool.PriorityGroup == 0 {
+				pool.PriorityGroup = 1
+			}
+			if pool.MinActiveMembers == 0 {
+				pool.MinActiveMembers = 1
+			}
 		}
 		pools = append(pools, pool)
 	}
 
 	rules = processVirtualServerRules(vs)
+	// The HTTP requests redirected to HTTPS are not rewritten, the policy
+	// runs before the redirect iRule which redirects to the public host
+	// and path.
+	if pStruct.protocol == "http" && crMgr.redirectsHTTP(vs) {
+		rules = rules.withoutRewrites()
+	}
 
-	policyName := cfg.Virtual.Name + "_policy"
+	policyName := namer.PolicyName(cfg.Virtual.Name)
 
-	plcy = createPolicy(*rules, policyName, vs.ObjectMeta.Namespace)
+	plcy = createPolicy(*rules, policyName,
+		namer.PolicyPartition(cfg.Virtual.Partition, vs.ObjectMeta.Namespace))
 
 	cfg.MetaData.rscName = vs.ObjectMeta.Name
+	cfg.MetaData.addOwner(vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name)
 
 	cfg.MetaData.ResourceType = VirtualServer
-	cfg.Virtual.Enabled = true
+	cfg.Virtual.PersistenceProfile = vs.Spec.PersistenceProfile
+	cfg.Virtual.SourceAddrTranslation = crMgr.getSourceAddrTranslation(vs.Spec.SNAT)
+	if len(vs.Spec.SNATAddresses) > 0 {
+		cfg.Virtual.SourceAddrTranslation = SourceAddrTranslation{Type: SNATPool}
+		for _, addr := range vs.Spec.SNATAddresses {
+			cfg.Virtual.SourceAddrTranslation.Addresses = append(
+				cfg.Virtual.SourceAddrTranslation.Addresses,
+				normalizeAddress(addr))
+		}
+	}
+	cfg.Virtual.AllowVLANs = nil
+	for _, vlan := range vs.Spec.AllowVLANs {
+		cfg.Virtual.AllowVLANs = append(cfg.Virtual.AllowVLANs,
+			formatVLANName(vlan))
+	}
 	cfg.Virtual.SetVirtualAddress(bindAddr, pStruct.port)
-	cfg.Pools = append(cfg.Pools, pools...)
-	if plcy != nil {
-		cfg.SetPolicy(*plcy)
+	for _, pool := range pools {
+		cfg.AddOrUpdatePool(pool)
+	}
+	if err := cfg.validateRouteDomains(); err != nil {
+		bigIPPrometheus.VirtualServerErrors.WithLabelValues(
+			vs.ObjectMeta.Namespace).Inc()
+		return nil, err
 	}
+	cfg.updateRouteDomainAddresses()
+	if plcy != nil {
+		crMgr.rulesMutex.Lock()
+		if nil == cfg.FindPolicy("forwarding") {
+			cfg.SetPolicy(*plcy)
+		} else {
+			crMgr.unmergeRewriteRules(&cfg, vs, plcy.Rules)
+			cfg.deleteSourceRangeResetRules(plcy.Rules, crMgr.mergedRulesMap)
+			for _, rl := range plcy.Rules {
+				if !isRewriteRule(rl) &&
+					crMgr.resolveRuleConflict(&cfg, vs, rl) {
+					cfg.AddRuleToPolicy(policyName, rl)
+				}
+			}
+			// The rewrites apply to the paths forwarded for the
+			// VirtualServer only, not to the paths of older VirtualServers.
+			for _, rl := range plcy.Rules {
+				if isRewriteRule(rl) &&
+					crMgr.isForwardedPath(&cfg, vs, rl.FullURI) {
+					cfg.AddRuleToPolicy(policyName, rl)
+				}
+			}
+			mergedPlcy := cfg.FindPolicy("forwarding")
+			sortRules(mergedPlcy.Rules)
+			mergedPlcy.Requires = policyRequires(mergedPlcy.Rules)
+			cfg.SetPolicy(*mergedPlcy)
+		}
+		cfg.MergeRules(crMgr.mergedRulesMap)
+		crMgr.rulesMutex.Unlock()
+	}
+	crMgr.updateVirtualIRules(&cfg, vs)
+	crMgr.updateVirtualWAF(&cfg, vs)
+	updateVirtualProfiles(&cfg, vs)
+	cfg.Virtual.Firewall = ""
+	if nil != customPolicy {
+		cfg.Virtual.Firewall = customPolicy.Spec.FirewallPolicy
+	}
+	cfg.MetaData.setABPools(vs)
+	if len(getABDeploymentPools(vs)) > 0 {
+		crMgr.addIRule(AbDeploymentPathIRuleName, cfg.Virtual.Partition,
+			abDeploymentPathIRule())
+		crMgr.addInternalDataGroup(AbDeploymentDgName, cfg.Virtual.Partition)
+		cfg.Virtual.AddIRule(
+			crMgr.iRulePath(cfg.Virtual.Partition, AbDeploymentPathIRuleName))
+	}
+	crMgr.updateVirtualHSTS(&cfg, vs)
+	crMgr.updateVirtualLimits(&cfg, vs)
+	crMgr.updateVirtualAddressSettings(&cfg, vs)
+	crMgr.updateVirtualEnabled(&cfg, vs)
+	crMgr.updateVirtualDefaultPool(&cfg, vs)
 
 	// If virtual server already exists with same name, it gets overridden
-	crMgr.resources.rsMap[cfg.Virtual.Name] = &cfg
-	return &cfg
+	crMgr.resources.setResourceConfig(&cfg)
+	return &cfg, nil
 }
 
-// handleVirtualServerTLS handles TLS configuration for the Virtual Server resource
-// Return value is whether or not a custom profile was updated
-func (crMgr *CRManager) handleVirtualServerTLS(
-	rsCfg *ResourceConfig,
-	vs *cisapiv1.VirtualServer,
-	svcFwdRulesMap ServiceFwdRuleMap,
-) bool {
-	if 0 == len(vs.Spec.TLSProfileName) {
-		// Probably this is a non-tls Virtual Server, nothing to do w.r.t TLS
-		return false
+// getTransportServerName returns the name of the BIG-IP virtual created
+// for a TransportServer on the port
+func (crMgr *CRManager) getTransportServerName(
+	ts *cisapiv1.TransportServer,
+	port int32,
+) string {
+	return formatVirtualServerName(
+		formatRouteDomainAddress(
+			normalizeAddress(ts.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(ts.ObjectMeta.Namespace),
+		),
+		port,
+	)
+}
+
+// getTransportServerPorts returns the ports of the TransportServer in
+// order, its port range expanded or its ports, or else its port.
+func getTransportServerPorts(ts *cisapiv1.TransportServer) []int32 {
+	if ts.Spec.VirtualServerPortRange != "" {
+		start, end, err := parsePortRange(ts.Spec.VirtualServerPortRange)
+		if err != nil {
+			return nil
+		}
+		var ports []int32
+		for port := start; port <= end; port++ {
+			ports = append(ports, port)
+		}
+		return ports
+	}
+	if len(ts.Spec.VirtualServerPorts) > 0 {
+		ports := append([]int32(nil), ts.Spec.VirtualServerPorts...)
+		sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+		return ports
 	}
+	return []int32{ts.Spec.VirtualServerPort}
+}
 
-	var httpsPort int32
-	httpsPort = DEFAULT_HTTPS_PORT
+// Creates resource config based on TransportServer resource config, the
+// virtual has a single pool and no policies or HTTP profiles.
+func (crMgr *CRManager) createRSConfigFromTransportServer(
+	ts *cisapiv1.TransportServer,
+	port int32,
+) *ResourceConfig {
+	var cfg ResourceConfig
 
-	// If we are processing the HTTPS server,
-	// then we don't need a redirect policy, only profiles
-	if rsCfg.Virtual.VirtualAddress.Port == httpsPort {
-		// Virtual Server related properties
-		// Virtual Server and TLSProfile are assumed to be in same namespace
-		vsNamespace := vs.ObjectMeta.Namespace
-		vsName := vs.ObjectMeta.Name
+	cfg.Virtual.Partition = crMgr.getNamespacePartition(ts.ObjectMeta.Namespace)
+	cfg.Virtual.Name = crMgr.getTransportServerName(ts, port)
 
-		// TLSProfile name
-		tlsName := vs.Spec.TLSProfileName
-		tlsKey := fmt.Sprintf("%s/%s", vsNamespace, tlsName)
+	cfg.MetaData.rscName = ts.ObjectMeta.Name
+	cfg.MetaData.addOwner(ts.ObjectMeta.Namespace + "/" + ts.ObjectMeta.Name)
+	cfg.MetaData.ResourceType = TransportServer
 
-		// Initialize CustomResource Informer for required namespace
-		crInf, ok := crMgr.getNamespaceInformer(vsNamespace)
-		if !ok {
-			log.Errorf("Informer not found for namespace: %v", vsNamespace)
-			return false
-		}
+	cfg.Virtual.Enabled = true
+	cfg.Virtual.IpProtocol = TransportServerTCP
+	if ts.Spec.Type == TransportServerUDP {
+		cfg.Virtual.IpProtocol = TransportServerUDP
+	}
+	cfg.Virtual.Mode = TransportServerStandard
+	if ts.Spec.Mode != "" {
+		cfg.Virtual.Mode = ts.Spec.Mode
+	}
+	cfg.Virtual.SourceAddrTranslation = crMgr.getSourceAddrTranslation("")
+	cfg.Virtual.SetVirtualAddress(
+		formatRouteDomainAddress(
+			normalizeAddress(ts.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(ts.ObjectMeta.Namespace),
+		),
+		port,
+	)
+
+	// Health monitor of the protocol unless a monitor is given.
+	monitor := ts.Spec.Pool.Monitor
+	if monitor == "" {
+		monitor = JoinBigipPath("Common", cfg.Virtual.IpProtocol)
+	}
+	pool := Pool{
+		Name: formatVirtualServerPoolName(
+			ts.ObjectMeta.Namespace,
+			ts.Spec.Pool.Service,
+			formatPoolPort(intstr.FromInt(int(ts.Spec.Pool.ServicePort))),
+			"",
+		),
+		Partition:        cfg.Virtual.Partition,
+		ServiceName:      ts.Spec.Pool.Service,
+		ServiceNamespace: ts.ObjectMeta.Namespace,
+		ServicePort:      ts.Spec.Pool.ServicePort,
+		MonitorNames:     []string{monitor},
+	}
+	cfg.Virtual.PoolName = pool.Name
+	cfg.AddOrUpdatePool(pool)
+
+	crMgr.resources.setResourceConfig(&cfg)
+	return &cfg
+}
 
-		// TODO: Create Internal Structure to hold TLSProfiles. Make API call only for a new TLSProfile
-		// Check if the TLSProfile exists and valid for us.
-		tlsInterface, tlsFound, _ := crInf.tsInformer.GetIndexer().GetByKey(tlsKey)
-		if !tlsFound {
-			log.Infof("TLSProfile %s is invalid", tlsName)
-			return false
+// resolveRuleConflict returns true if the rule of the VirtualServer can be
+// added to the forwarding policy. A rule of another VirtualServer for the
+// same host and path is kept if that VirtualServer is older, otherwise it is
+// replaced by the rule.
+func (crMgr *CRManager) resolveRuleConflict(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	rule *Rule,
+) bool {
+	policy := cfg.FindPolicy("forwarding")
+	if nil == policy {
+		return true
+	}
+	for i, rl := range policy.Rules {
+		if rl.FullURI != rule.FullURI || rl.Name == rule.Name ||
+			isRewriteRule(rl) || !sameMatchConditions(rl, rule) {
+			continue
 		}
-
-		// TLSProfile Object
-		tls := tlsInterface.(*cisapiv1.TLSProfile)
-
-		// Process Profile
-		switch tls.Spec.TLS.Reference {
-		case BIGIP:
-			clientSSL := tls.Spec.TLS.ClientSSL
-			serverSSL := tls.Spec.TLS.ServerSSL
-			// Profile is a BIG-IP default
-			log.Debugf("Processing BIGIP referenced profiles for Virtual '%s' using TLSProfile '%s'",
-				vsName, tlsName)
-			// Process referenced BIG-IP clientSSL
-			if clientSSL != "" {
-				clientProfRef := ConvertStringToProfileRef(
-					clientSSL, CustomProfileClient, vsNamespace)
-				rsCfg.Virtual.AddOrUpdateProfile(clientProfRef)
-			}
-			// Process referenced BIG-IP serverSSL
-			if serverSSL != "" {
-				serverProfRef := ConvertStringToProfileRef(
-					serverSSL, CustomProfileServer, vsNamespace)
-				rsCfg.Virtual.AddOrUpdateProfile(serverProfRef)
-			}
-			log.Debugf("Updated BIGIP referenced profiles for Virtual '%s' using TLSProfile '%s'",
-				vsName, tlsName)
-			return true
-		case Secret:
-			// Prepare SSL Transient Context
-			// Check if TLS Secret already exists
-			clientSSL := tls.Spec.TLS.ClientSSL
-			if secret, ok := crMgr.SSLContext[clientSSL]; ok {
-				log.Debugf("TLSProfile '%s' is already available with CIS in SSLContext",
-					tlsName)
-				err, _ := crMgr.createSecretSslProfile(rsCfg, secret)
-				if err != nil {
-					log.Debugf("error %v encountered for '%s' using TLSProfile '%s'",
-						err, vsName, tlsName)
-					return false
-				}
-			} else {
-				// Check if profile is contained in a Secret
-				// Update the SSL Context if secret found, This is used to avoid api calls
-				log.Debugf("TLSProfile '%s' does not exist with CIS in SSLContext, Store for further use",
-					tlsName)
-				secret, err := crMgr.kubeClient.CoreV1().Secrets(vsNamespace).
-					Get(clientSSL, metav1.GetOptions{})
-				if err != nil {
-					log.Debugf("secret %s not found for Virtual '%s' using TLSProfile '%s'",
-						clientSSL, vsName, tlsName)
-					return false
-				}
-				crMgr.SSLContext[clientSSL] = secret
-				error, _ := crMgr.createSecretSslProfile(rsCfg, secret)
-				if error != nil {
-					log.Debugf("error %v encountered for '%s' using TLSProfile '%s'",
-						error, vsName, tlsName)
-					return false
-				}
-			}
-			profRef := ProfileRef{
-				Partition: rsCfg.Virtual.Partition,
-				Name:      clientSSL,
-				Context:   CustomProfileClient,
-				Namespace: vsNamespace,
-			}
-			rsCfg.Virtual.AddOrUpdateProfile(profRef)
-			return true
-		default:
-			log.Errorf("referenced profile does not exist for Virtual '%s' using TLSProfile '%s'",
-				vsName, tlsName)
+		vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+		owner, found := crMgr.getRuleOwner(rl, vsKey)
+		if found && !isOlderVirtualServer(vs, owner) {
+			log.Warningf("Path %s of VirtualServer %s conflicts with "+
+				"VirtualServer %s/%s, keeping the older rule", rule.FullURI,
+				vsKey, owner.ObjectMeta.Namespace, owner.ObjectMeta.Name)
 			return false
 		}
+		if found {
+			log.Warningf("Path %s of VirtualServer %s/%s conflicts with "+
+				"VirtualServer %s, keeping the older rule", rule.FullURI,
+				owner.ObjectMeta.Namespace, owner.ObjectMeta.Name, vsKey)
+		}
+		rule.Ordinal = rl.Ordinal
+		policy.Rules[i] = rule
+		cfg.SetPolicy(*policy)
+		return false
 	}
+	return true
+}
 
-	// httpTraffic defines the behaviour of http Virtual Server on BIG-IP
-	// Possible values are allow, none and redirect
-	httpTraffic := vs.Spec.HTTPTraffic
-	if httpTraffic != "" {
-		// -----------------------------------------------------------------
-		// httpTraffic = allow -> Allows HTTP
-		// httpTraffic = none  -> Only HTTPS
-		// httpTraffic = redirect -> redirects HTTP to HTTPS
-		// -----------------------------------------------------------------
-		if httpTraffic == "redirect" {
-			// set HTTP redirect iRule
-			log.Debugf("Applying HTTP redirect iRule.")
-			ruleName := fmt.Sprintf("%s_%d", HttpRedirectIRuleName, httpsPort)
-			crMgr.addIRule(ruleName, DEFAULT_PARTITION, httpRedirectIRule(httpsPort))
-			crMgr.addInternalDataGroup(HttpsRedirectDgName, DEFAULT_PARTITION)
-			ruleName = JoinBigipPath(DEFAULT_PARTITION, ruleName)
-			rsCfg.Virtual.AddIRule(ruleName)
-			host := vs.Spec.Host
-			for _, pool := range vs.Spec.Pools {
-				svcFwdRulesMap.AddEntry(vs.ObjectMeta.Namespace,
-					pool.Service, host, pool.Path)
+// unmergeRewriteRules unmerges the rewrite rules of the paths of the
+// VirtualServer from their forwarding rules, so the rules are merged again
+// with the current rewrites and the rewrites removed from the spec are
+// deleted.
+func (crMgr *CRManager) unmergeRewriteRules(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	rules Rules,
+) {
+	uris := make(map[string]bool)
+	for _, rl := range rules {
+		uris[rl.FullURI] = true
+	}
+	policy := cfg.FindPolicy("forwarding")
+	if nil == policy {
+		return
+	}
+	fwdRules := make(map[string]*Rule)
+	for _, rl := range policy.Rules {
+		fwdRules[rl.Name] = rl
+	}
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	var names []string
+	for name, entry := range crMgr.mergedRulesMap[cfg.GetRef()] {
+		if nil == entry.OriginalRule || !isRewriteRule(entry.OriginalRule) ||
+			!uris[entry.OriginalRule.FullURI] ||
+			len(entry.OtherRuleNames) == 0 {
+			continue
+		}
+		if fwdRule, ok := fwdRules[entry.OtherRuleNames[0]]; ok {
+			if _, found := crMgr.getRuleOwner(fwdRule, vsKey); found {
+				continue
 			}
-		} else if httpTraffic == "allow" {
-			// State 3, do not apply any policy
-			log.Debugf("[CORE] TLS: Not applying any policies.")
 		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg.UnmergeRule(name, crMgr.mergedRulesMap)
 	}
-
-	return false
 }
 
-// ConvertStringToProfileRef converts strings to profile references
-func ConvertStringToProfileRef(profileName, context, ns string) ProfileRef {
-	profName := strings.TrimSpace(strings.TrimPrefix(profileName, "/"))
-	parts := strings.Split(profName, "/")
-	profRef := ProfileRef{Context: context, Namespace: ns}
-	switch len(parts) {
-	case 2:
-		profRef.Partition = parts[0]
-		profRef.Name = parts[1]
-	case 1:
-		log.Debugf("[RESOURCE] Partition not provided in profile '%s', using default partition '%s'",
-			profileName, DEFAULT_PARTITION)
-		profRef.Partition = DEFAULT_PARTITION
-		profRef.Name = profileName
-	default:
-		// This is almost certainly an error, but again issue a warning for
-		// improved context here and pass it through to be handled elsewhere.
-		log.Warningf("[RESOURCE] Profile name '%v' is formatted incorrectly.", profileName)
+// deleteSourceRangeResetRules deletes the reset rules of the forwarding
+// rules no longer restricted to source ranges.
+func (rc *ResourceConfig) deleteSourceRangeResetRules(
+	rules Rules,
+	mergedRulesMap map[NameRef]map[string]mergedRuleEntry,
+) {
+	policy := rc.FindPolicy("forwarding")
+	if nil == policy {
+		return
+	}
+	names := make(map[string]bool)
+	for _, rl := range rules {
+		names[rl.Name] = true
+	}
+	var staleRules []*Rule
+	for _, rl := range policy.Rules {
+		fwdName := strings.TrimSuffix(rl.Name, resetRuleSuffix)
+		if fwdName != rl.Name && names[fwdName] && !names[rl.Name] {
+			staleRules = append(staleRules, rl)
+		}
+	}
+	for _, rl := range staleRules {
+		if pol := rc.FindPolicy("forwarding"); nil != pol {
+			rc.DeleteRuleFromPolicy(pol.Name, rl, mergedRulesMap)
+		}
 	}
-	return profRef
 }
 
-// AddOrUpdateProfile updates profile to rsCfg
+// isForwardedPath returns true if the forwarding rule of the path on the
+// virtual is not owned by another VirtualServer.
+func (crMgr *CRManager) isForwardedPath(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	uri string,
+) bool {
+	policy := cfg.FindPolicy("forwarding")
+	if nil == policy {
+		return false
+	}
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	for _, rl := range policy.Rules {
+		if rl.FullURI != uri || isRewriteRule(rl) {
+			continue
+		}
+		_, found := crMgr.getRuleOwner(rl, vsKey)
+		return !found
+	}
+	return false
+}
+
+// getRuleOwner returns the VirtualServer, other than the one with vsKey,
+// whose dependencies include the rule.
+func (crMgr *CRManager) getRuleOwner(
+	rule *Rule,
+	vsKey string,
+) (*cisapiv1.VirtualServer, bool) {
+	pool := getRulePool(rule)
+	crMgr.resources.RLock()
+	defer crMgr.resources.RUnlock()
+	for key, deps := range crMgr.resources.objDeps {
+		ownerKey := key.Namespace + "/" + key.Name
+		if key.Kind != VirtualServer || ownerKey == vsKey {
+			continue
+		}
+		for dep := range deps {
+			if dep.Kind == RuleDep && dep.Name == rule.FullURI &&
+				dep.Pool == pool {
+				return crMgr.getVirtualServer(ownerKey)
+			}
+		}
+	}
+	return nil, false
+}
+
+// getRulePool returns the pool the rule forwards to
+func getRulePool(rule *Rule) string {
+	for _, act := range rule.Actions {
+		if act.Pool != "" {
+			return act.Pool
+		}
+	}
+	return ""
+}
+
+// addOwner records the VirtualServer as configured on the virtual
+func (m *metaData) addOwner(vsKey string) {
+	for _, owner := range m.owners {
+		if owner == vsKey {
+			return
+		}
+	}
+	m.owners = append(m.owners, vsKey)
+	sort.Strings(m.owners)
+}
+
+// removeOwner removes the VirtualServer from the owners of the virtual,
+// it returns false if the VirtualServer is not configured on the virtual.
+func (m *metaData) removeOwner(vsKey string) bool {
+	for i, owner := range m.owners {
+		if owner == vsKey {
+			m.owners = append(m.owners[:i], m.owners[i+1:]...)
+			delete(m.abPools, vsKey)
+			return true
+		}
+	}
+	return false
+}
+
+// setABPools records the pools of the A/B deployments of the VirtualServer
+func (m *metaData) setABPools(vs *cisapiv1.VirtualServer) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	var poolNames []string
+	for _, pools := range getABDeploymentPools(vs) {
+		for _, pl := range pools {
+			poolNames = append(poolNames,
+				getVirtualServerPoolName(vs.ObjectMeta.Namespace, pl))
+		}
+	}
+	if len(poolNames) == 0 {
+		delete(m.abPools, vsKey)
+		return
+	}
+	sort.Strings(poolNames)
+	if nil == m.abPools {
+		m.abPools = make(map[string][]string)
+	}
+	m.abPools[vsKey] = poolNames
+}
+
+// hasOwner returns true if the VirtualServer is configured on the virtual
+func (m *metaData) hasOwner(vsKey string) bool {
+	for _, owner := range m.owners {
+		if owner == vsKey {
+			return true
+		}
+	}
+	return false
+}
+
+// claimVirtual returns true if the VirtualServer can be configured on the
+// virtual of its address and port. A virtual of a TransportServer or IngressLink is not shared. With SharedVIPReject policy, a virtual used by another
+// VirtualServer is only claimed by the older VirtualServer and the newer
+// one is rejected with an Event, unless both are of the same host group.
+func (crMgr *CRManager) claimVirtual(
+	vs *cisapiv1.VirtualServer,
+	rsName string,
+	port int32,
+) bool {
+	rsCfg, ok := crMgr.resources.getVirtualConfig(
+		crMgr.getVirtualServerPartition(vs), rsName,
+		crMgr.getVirtualServerBindAddr(vs), port)
+	if !ok {
+		return true
+	}
+	// The virtual may be named otherwise on the address and port, like
+	// a TransportServer listening on a port list
+	rsName = rsCfg.Virtual.Name
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	if rsCfg.MetaData.ResourceType != VirtualServer {
+		msg := fmt.Sprintf("Address of virtual %s is used by %s %s",
+			rsName, rsCfg.MetaData.ResourceType,
+			strings.Join(rsCfg.MetaData.owners, ", "))
+		log.Errorf("VirtualServer %s rejected: %s", vsKey, msg)
+		crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeWarning,
+			"AddressConflict", msg)
+		return false
+	}
+	if partition := crMgr.getVirtualServerPartition(vs); partition !=
+		rsCfg.Virtual.Partition {
+		msg := fmt.Sprintf("Address of virtual %s is used in partition %s "+
+			"by %s", rsName, rsCfg.Virtual.Partition,
+			strings.Join(rsCfg.MetaData.owners, ", "))
+		log.Errorf("VirtualServer %s rejected: %s", vsKey, msg)
+		crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeWarning,
+			"AddressConflict", msg)
+		return false
+	}
+	if crMgr.SharedVIPPolicy != SharedVIPReject {
+		return true
+	}
+	for _, owner := range rsCfg.MetaData.owners {
+		if owner == vsKey {
+			continue
+		}
+		ownerVS, found := crMgr.getVirtualServer(owner)
+		if !found {
+			// Deleted VirtualServer, the virtual is being recreated
+			continue
+		}
+		if vs.Spec.HostGroup != "" &&
+			ownerVS.Spec.HostGroup == vs.Spec.HostGroup {
+			continue
+		}
+		if isOlderVirtualServer(vs, ownerVS) {
+			msg := fmt.Sprintf("Address of virtual %s is claimed by "+
+				"older VirtualServer %s", rsName, vsKey)
+			log.Warningf("VirtualServer %s rejected: %s", owner, msg)
+			crMgr.recordEvent(ownerVS, ownerVS.ObjectMeta.Namespace,
+				v1.EventTypeWarning, "AddressConflict", msg)
+			// Recreate the virtual for the older VirtualServer
+			crMgr.deleteVirtual(rsCfg.GetRef())
+			return true
+		}
+		msg := fmt.Sprintf("Address of virtual %s is used by VirtualServer %s",
+			rsName, owner)
+		log.Errorf("VirtualServer %s rejected: %s", vsKey, msg)
+		crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeWarning,
+			"AddressConflict", msg)
+		return false
+	}
+	return true
+}
+
+// isOlderVirtualServer returns true if vs is created before otherVS
+func isOlderVirtualServer(vs, otherVS *cisapiv1.VirtualServer) bool {
+	t1 := vs.ObjectMeta.CreationTimestamp
+	t2 := otherVS.ObjectMeta.CreationTimestamp
+	if t1.Equal(&t2) {
+		return vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name <
+			otherVS.ObjectMeta.Namespace+"/"+otherVS.ObjectMeta.Name
+	}
+	return t1.Before(&t2)
+}
+
+// getVirtualServer returns the VirtualServer from the informer store
+func (crMgr *CRManager) getVirtualServer(
+	vsKey string,
+) (*cisapiv1.VirtualServer, bool) {
+	namespace := strings.Split(vsKey, "/")[0]
+	crInf, ok := crMgr.getNamespaceInformer(namespace)
+	if !ok {
+		return nil, false
+	}
+	obj, found, _ := crInf.vsInformer.GetIndexer().GetByKey(vsKey)
+	if !found {
+		return nil, false
+	}
+	return obj.(*cisapiv1.VirtualServer), true
+}
+
+// updateVirtualIRules sets the iRules of the virtual to the iRules managed
+// by the controller and the iRules in the spec of its VirtualServers, so the
+// iRules removed from a spec are removed from the virtual.
+func (crMgr *CRManager) updateVirtualIRules(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	var irules []string
+	for _, irule := range cfg.Virtual.IRules {
+		if crMgr.isManagedIRule(irule) {
+			irules = append(irules, irule)
+		}
+	}
+	cfg.Virtual.IRules = irules
+
+	for _, owner := range cfg.MetaData.owners {
+		ownerVS := vs
+		if nil == vs || owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+			var found bool
+			ownerVS, found = crMgr.getVirtualServer(owner)
+			if !found {
+				continue
+			}
+			customPolicy, _ := crMgr.getPolicyForVirtualServer(ownerVS)
+			ownerVS = applyPolicy(ownerVS, customPolicy)
+		}
+		for _, irule := range ownerVS.Spec.IRules {
+			cfg.Virtual.AddIRule(formatIRuleName(irule))
+		}
+	}
+}
+
+// updateVirtualWAF attaches the WAF policy of the VirtualServers to the
+// virtual. VirtualServers sharing the virtual cannot have different WAF
+// policies, the policy attached first is retained and the conflict is
+// reported with an Event.
+func (crMgr *CRManager) updateVirtualWAF(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	var vsKey string
+	if nil != vs {
+		vsKey = vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	}
+	getWAF := func(owner string) string {
+		if nil != vs && owner == vsKey {
+			return vs.Spec.WAF
+		}
+		if ownerVS, found := crMgr.getVirtualServer(owner); found {
+			return ownerVS.Spec.WAF
+		}
+		return ""
+	}
+
+	wafOwner := cfg.MetaData.wafOwner
+	if !cfg.MetaData.hasOwner(wafOwner) || getWAF(wafOwner) == "" {
+		wafOwner = ""
+		// Prefer the VirtualServer being processed, then the others
+		owners := append([]string{vsKey}, cfg.MetaData.owners...)
+		for _, owner := range owners {
+			if owner != "" && getWAF(owner) != "" {
+				wafOwner = owner
+				break
+			}
+		}
+	}
+	cfg.MetaData.wafOwner = wafOwner
+	cfg.Virtual.WAF = ""
+	if wafOwner != "" {
+		cfg.Virtual.WAF = getWAF(wafOwner)
+	}
+
+	if nil != vs && vs.Spec.WAF != "" && vs.Spec.WAF != cfg.Virtual.WAF {
+		msg := fmt.Sprintf("WAF policy %s not attached, the virtual uses "+
+			"WAF policy %s of VirtualServer %s", vs.Spec.WAF,
+			cfg.Virtual.WAF, wafOwner)
+		log.Warningf("VirtualServer %s: %s", vsKey, msg)
+		crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeWarning,
+			"WAFConflict", msg)
+	}
+
+	// The forwarding policy controls ASM when a WAF policy is attached
+	if plcy := cfg.FindPolicy("forwarding"); nil != plcy {
+		var controls []string
+		for _, control := range plcy.Controls {
+			if control != "asm" {
+				controls = append(controls, control)
+			}
+		}
+		if cfg.Virtual.WAF != "" {
+			controls = append(controls, "asm")
+		}
+		plcy.Controls = controls
+		cfg.SetPolicy(*plcy)
+	}
+}
+
+// updateVirtualHSTS attaches the HSTS iRule to the HTTPS virtual when one
+// of its VirtualServers enables HSTS, and detaches it otherwise. The HTTP
+// virtual never inserts the header.
+func (crMgr *CRManager) updateVirtualHSTS(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	ruleName := crMgr.iRulePath(cfg.Virtual.Partition, HstsIRuleName)
+	enabled := false
+	if cfg.Virtual.VirtualAddress.Port == DEFAULT_HTTPS_PORT {
+		for _, owner := range cfg.MetaData.owners {
+			ownerVS := vs
+			if nil == vs ||
+				owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+				var found bool
+				ownerVS, found = crMgr.getVirtualServer(owner)
+				if !found {
+					continue
+				}
+			}
+			if hstsHeader(ownerVS.Spec.HSTS) != "" {
+				enabled = true
+				break
+			}
+		}
+	}
+	if !enabled {
+		cfg.Virtual.RemoveIRule(ruleName)
+		return
+	}
+	crMgr.addIRule(HstsIRuleName, cfg.Virtual.Partition, hstsIRule())
+	crMgr.addInternalDataGroup(HstsDgName, cfg.Virtual.Partition)
+	cfg.Virtual.AddIRule(ruleName)
+}
+
+// poolActionIRuleName returns the name of the pool action iRule of the
+// virtual. The iRules shared in another partition are named after the
+// partition of the virtual too, as its virtuals may have the same name.
+func (crMgr *CRManager) poolActionIRuleName(partition, rsName string) string {
+	if crMgr.objectsPartition(partition) != partition {
+		return PoolActionIRuleName + "_" + partition + "_" + rsName
+	}
+	return PoolActionIRuleName + "_" + rsName
+}
+
+// updateVirtualPoolActions attaches the pool action iRule of the virtual
+// when rules of its policy drop or redirect the requests, and deletes the
+// iRule otherwise. The other actions are actions of the policy.
+func (crMgr *CRManager) updateVirtualPoolActions(cfg *ResourceConfig) {
+	iRuleName := crMgr.poolActionIRuleName(cfg.Virtual.Partition,
+		cfg.Virtual.Name)
+	partition := crMgr.objectsPartition(cfg.Virtual.Partition)
+	key := NameRef{Name: iRuleName, Partition: partition}
+	actions := make(map[string]*action)
+	if policy := cfg.FindPolicy("forwarding"); nil != policy {
+		for _, rl := range policy.Rules {
+			for _, act := range rl.Actions {
+				if act.Drop || act.Code != 0 {
+					actions[rl.Name] = act
+				}
+			}
+		}
+	}
+	crMgr.irulesMutex.Lock()
+	if len(actions) == 0 {
+		delete(crMgr.irulesMap, key)
+	} else {
+		crMgr.irulesMap[key] = NewIRule(iRuleName, partition,
+			poolActionIRule(actions))
+	}
+	crMgr.irulesMutex.Unlock()
+
+	if len(actions) == 0 {
+		cfg.Virtual.RemoveIRule(crMgr.iRulePath(cfg.Virtual.Partition, iRuleName))
+		return
+	}
+	cfg.Virtual.AddIRule(crMgr.iRulePath(cfg.Virtual.Partition, iRuleName))
+}
+
+// updateVirtualLimits sets the connection and rate limits of the virtual.
+// VirtualServers sharing the virtual are limited by the lowest limits.
+func (crMgr *CRManager) updateVirtualLimits(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	var connLimit, rateLimit int32
+	var connOwner, rateOwner string
+	for _, owner := range cfg.MetaData.owners {
+		ownerVS := vs
+		if nil == vs || owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+			var found bool
+			ownerVS, found = crMgr.getVirtualServer(owner)
+			if !found {
+				continue
+			}
+		}
+		limit := ownerVS.Spec.ConnectionLimit
+		if limit > 0 && (connLimit == 0 || limit < connLimit) {
+			connLimit, connOwner = limit, owner
+		}
+		limit = ownerVS.Spec.RateLimit
+		if limit > 0 && (rateLimit == 0 || limit < rateLimit) {
+			rateLimit, rateOwner = limit, owner
+		}
+	}
+	if len(cfg.MetaData.owners) > 1 {
+		if connLimit != 0 {
+			log.Infof("Virtual %s uses connection limit %d of VirtualServer %s",
+				cfg.Virtual.Name, connLimit, connOwner)
+		}
+		if rateLimit != 0 {
+			log.Infof("Virtual %s uses rate limit %d of VirtualServer %s",
+				cfg.Virtual.Name, rateLimit, rateOwner)
+		}
+	}
+	cfg.Virtual.ConnectionLimit = connLimit
+	cfg.Virtual.RateLimit = rateLimit
+}
+
+// updateVirtualEnabled disables the virtual when all the VirtualServers
+// sharing it set disableVirtualServer, the virtual stays enabled for the
+// others otherwise. updateActive keeps the disabled virtual disabled.
+func (crMgr *CRManager) updateVirtualEnabled(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	var owners, disabling []string
+	for _, owner := range cfg.MetaData.owners {
+		ownerVS := vs
+		if nil == vs || owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+			var found bool
+			ownerVS, found = crMgr.getVirtualServer(owner)
+			if !found {
+				continue
+			}
+		}
+		owners = append(owners, owner)
+		if ownerVS.Spec.DisableVirtualServer {
+			disabling = append(disabling, owner)
+		}
+	}
+	disabled := len(disabling) > 0 && len(disabling) == len(owners)
+	if len(disabling) > 0 && !disabled {
+		log.Warningf("VirtualServers %s disable virtual %s shared with "+
+			"other VirtualServers, keeping it enabled",
+			strings.Join(disabling, ", "), cfg.Virtual.Name)
+	}
+	cfg.MetaData.disabled = disabled
+	cfg.Virtual.Enabled = !disabled
+}
+
+// icmpEchoRank orders the ICMP echo settings from the least to the most
+// restrictive
+var icmpEchoRank = map[string]int{
+	"":                0,
+	ICMPEchoEnable:    0,
+	ICMPEchoSelective: 1,
+	ICMPEchoDisable:   2,
+}
+
+// getVirtualAddressSettings returns the ICMP echo and ARP settings of the
+// virtual address of a VirtualServer, or the defaults when not set. ICMP
+// echo is empty when enabled, the default of BIG-IP.
+func (crMgr *CRManager) getVirtualAddressSettings(
+	vs *cisapiv1.VirtualServer,
+) (string, bool) {
+	icmpEcho := vs.Spec.ICMPEcho
+	if icmpEcho == "" {
+		icmpEcho = crMgr.DefaultICMPEcho
+	}
+	if icmpEcho == ICMPEchoEnable {
+		icmpEcho = ""
+	}
+	arpDisabled := crMgr.DisableARP
+	if nil != vs.Spec.ARP {
+		arpDisabled = !*vs.Spec.ARP
+	}
+	return icmpEcho, arpDisabled
+}
+
+// updateVirtualAddressSettings sets the ICMP echo and ARP settings of the
+// virtual address. VirtualServers sharing the virtual with different
+// settings use the most restrictive ones.
+func (crMgr *CRManager) updateVirtualAddressSettings(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	if nil == cfg.Virtual.VirtualAddress {
+		return
+	}
+	var icmpEcho string
+	var arpDisabled, seen, conflict bool
+	for _, owner := range cfg.MetaData.owners {
+		ownerVS := vs
+		if nil == vs || owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+			var found bool
+			ownerVS, found = crMgr.getVirtualServer(owner)
+			if !found {
+				continue
+			}
+		}
+		echo, disabled := crMgr.getVirtualAddressSettings(ownerVS)
+		if seen && (echo != icmpEcho || disabled != arpDisabled) {
+			conflict = true
+		}
+		if icmpEchoRank[echo] > icmpEchoRank[icmpEcho] {
+			icmpEcho = echo
+		}
+		arpDisabled = arpDisabled || disabled
+		seen = true
+	}
+	if conflict {
+		log.Warningf("VirtualServers of virtual %s have different icmpEcho "+
+			"or arp, using icmpEcho '%s' and ARP disabled %v",
+			cfg.Virtual.Name, icmpEcho, arpDisabled)
+	}
+	cfg.Virtual.VirtualAddress.ICMPEcho = icmpEcho
+	cfg.Virtual.VirtualAddress.ARPDisabled = arpDisabled
+}
+
+// updateVirtualDefaultPool sets the default pool of the virtual to the
+// defaultPool of the oldest VirtualServer sharing the virtual, the
+// defaultPool of the others is ignored.
+func (crMgr *CRManager) updateVirtualDefaultPool(
+	cfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+) {
+	var defaultVS *cisapiv1.VirtualServer
+	var conflicts []*cisapiv1.VirtualServer
+	for _, owner := range cfg.MetaData.owners {
+		ownerVS := vs
+		if nil == vs || owner != vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name {
+			var found bool
+			ownerVS, found = crMgr.getVirtualServer(owner)
+			if !found {
+				continue
+			}
+		}
+		if nil == ownerVS.Spec.DefaultPool {
+			continue
+		}
+		if nil == defaultVS || isOlderVirtualServer(ownerVS, defaultVS) {
+			if nil != defaultVS {
+				conflicts = append(conflicts, defaultVS)
+			}
+			defaultVS = ownerVS
+		} else {
+			conflicts = append(conflicts, ownerVS)
+		}
+	}
+	cfg.Virtual.PoolName = ""
+	if nil == defaultVS {
+		return
+	}
+	for _, conflict := range conflicts {
+		log.Warningf("defaultPool of VirtualServer %s/%s conflicts with "+
+			"VirtualServer %s/%s on virtual %s, keeping the older one",
+			conflict.ObjectMeta.Namespace, conflict.ObjectMeta.Name,
+			defaultVS.ObjectMeta.Namespace, defaultVS.ObjectMeta.Name,
+			cfg.Virtual.Name)
+	}
+	dp := defaultVS.Spec.DefaultPool
+	pool := Pool{
+		Name:             formatDefaultPoolName(defaultVS.ObjectMeta.Namespace, dp),
+		Partition:        cfg.Virtual.Partition,
+		ServiceName:      dp.Service,
+		ServiceNamespace: defaultVS.ObjectMeta.Namespace,
+		ServicePort:      dp.ServicePort,
+	}
+	cfg.Virtual.PoolName = pool.Name
+	// Keep the members of the pool when the rules use it too
+	for _, pl := range cfg.Pools {
+		if pl.Name == pool.Name && pl.Partition == pool.Partition {
+			return
+		}
+	}
+	cfg.AddOrUpdatePool(pool)
+}
+
+// updateVirtualProfiles attaches the HTTP and TCP profiles of the
+// VirtualServer to the virtual, replacing the previous ones.
+func updateVirtualProfiles(cfg *ResourceConfig, vs *cisapiv1.VirtualServer) {
+	var profiles ProfileRefs
+	for _, prof := range cfg.Virtual.Profiles {
+		if prof.Type != ProfileTypeHTTP && prof.Type != ProfileTypeTCP {
+			profiles = append(profiles, prof)
+		}
+	}
+	cfg.Virtual.Profiles = profiles
+
+	addProfile := func(profile, context, profType string) {
+		profRef := ConvertStringToProfileRef(profile, context,
+			vs.ObjectMeta.Namespace)
+		profRef.Type = profType
+		cfg.Virtual.AddOrUpdateProfile(profRef)
+	}
+	spec := vs.Spec.Profiles
+	if spec.HTTP != "" {
+		addProfile(spec.HTTP, CustomProfileAll, ProfileTypeHTTP)
+	}
+	// The same TCP profile on both sides is a single profile for all
+	if spec.TCP.Client != "" && spec.TCP.Client == spec.TCP.Server {
+		addProfile(spec.TCP.Client, CustomProfileAll, ProfileTypeTCP)
+		return
+	}
+	if spec.TCP.Client != "" {
+		addProfile(spec.TCP.Client, CustomProfileClient, ProfileTypeTCP)
+	}
+	if spec.TCP.Server != "" {
+		addProfile(spec.TCP.Server, CustomProfileServer, ProfileTypeTCP)
+	}
+}
+
+// isManagedIRule returns true if the iRule is created by the controller
+func (crMgr *CRManager) isManagedIRule(irule string) bool {
+	crMgr.irulesMutex.Lock()
+	defer crMgr.irulesMutex.Unlock()
+
+	// The iRules of another partition are in its Shared application
+	splits := strings.Split(irule, "/")
+	if len(splits) == 4 && splits[2] == as3SharedApplication {
+		splits = []string{"", splits[1], splits[3]}
+	}
+	if len(splits) != 3 {
+		return false
+	}
+	_, found := crMgr.irulesMap[NameRef{Name: splits[2], Partition: splits[1]}]
+	return found
+}
+
+// formatIRuleName returns the full path of an iRule of a VirtualServer,
+// bare names are in the partition of the controller.
+func formatIRuleName(irule string) string {
+	if strings.HasPrefix(irule, "/") {
+		return irule
+	}
+	return JoinBigipPath(DEFAULT_PARTITION, irule)
+}
+
+// formatMonitorName returns the path of the monitor on BIG-IP, the names
+// without partition refer to the partition of CIS like the profiles.
+func formatMonitorName(monitor string) string {
+	ref := ConvertStringToProfileRef(strings.TrimSpace(monitor), "", "")
+	return JoinBigipPath(ref.Partition, ref.Name)
+}
+
+// getSourceAddrTranslation returns the source address translation of the
+// SNAT, or of the default SNAT when not set.
+func (crMgr *CRManager) getSourceAddrTranslation(
+	snat string,
+) SourceAddrTranslation {
+	if snat == "" {
+		snat = crMgr.DefaultSNAT
+	}
+	switch snat {
+	case "", SNATAutomap:
+		return SourceAddrTranslation{Type: SNATAutomap}
+	case SNATNone:
+		return SourceAddrTranslation{Type: SNATNone}
+	}
+	return SourceAddrTranslation{Type: "snat", Pool: snat}
+}
+
+// formatVLANName returns the full path of a VLAN, bare names are in the
+// Common partition.
+func formatVLANName(vlan string) string {
+	if strings.HasPrefix(vlan, "/") {
+		return vlan
+	}
+	return JoinBigipPath("Common", vlan)
+}
+
+// validateVirtualServerAddress returns an error if the address is not a
+// valid IP address, optionally with a route domain (1.2.3.4%2).
+func validateVirtualServerAddress(address string) error {
+	if address == "" {
+		return fmt.Errorf("VirtualServer IP Address is not provided. " +
+			"Create VirtualServer with 'virtual.spec.virtualServerAddress'.")
+	}
+	if err := validateAddress(address); err != nil {
+		return fmt.Errorf("Invalid VirtualServer IP Address: %v", err)
+	}
+	return nil
+}
+
+// handleVirtualServerTLS handles TLS configuration for the Virtual Server resource
+// Return value is whether or not a custom profile was updated
+// The data of the host records of the TLS data groups are added to
+// hostRecords by data group name.
+func (crMgr *CRManager) handleVirtualServerTLS(
+	rsCfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	svcFwdRulesMap ServiceFwdRuleMap,
+	hostRecords map[string]string,
+) bool {
+	if 0 == len(getTLSProfileNames(vs)) {
+		// Probably this is a non-tls Virtual Server, nothing to do w.r.t TLS
+		return false
+	}
+
+	var httpsPort int32
+	httpsPort = DEFAULT_HTTPS_PORT
+
+	// If we are processing the HTTPS server,
+	// then we don't need a redirect policy, only profiles
+	if rsCfg.Virtual.VirtualAddress.Port == httpsPort {
+		// Virtual Server related properties
+		// Virtual Server and TLSProfile are assumed to be in same namespace
+		vsNamespace := vs.ObjectMeta.Namespace
+		vsName := vs.ObjectMeta.Name
+
+		// Initialize CustomResource Informer for required namespace
+		crInf, ok := crMgr.getNamespaceInformer(vsNamespace)
+		if !ok {
+			log.Errorf("Informer not found for namespace: %v", vsNamespace)
+			bigIPPrometheus.VirtualServerErrors.WithLabelValues(vsNamespace).Inc()
+			crMgr.retryLater(vs, "Informer not found for namespace: %v", vsNamespace)
+			return false
+		}
+
+		// The certificates of the hosts of the TLSProfiles are selected
+		// with SNI when the virtual serves several certificates.
+		tlsNames := getTLSProfileNames(vs)
+		for i, tlsName := range tlsNames {
+			tlsKey := fmt.Sprintf("%s/%s", vsNamespace, tlsName)
+			// Check if the TLSProfile exists and valid for us.
+			tls, tlsFound := crMgr.getTLSProfile(crInf, tlsKey)
+			if !tlsFound {
+				msg := fmt.Sprintf("TLSProfile %s not found", tlsName)
+				log.Errorf("VirtualServer %s/%s: %s", vsNamespace, vsName, msg)
+				crMgr.recordEvent(vs, vsNamespace, v1.EventTypeWarning,
+					"TLSProfileNotFound", msg)
+				bigIPPrometheus.VirtualServerErrors.WithLabelValues(vsNamespace).Inc()
+				return false
+			}
+			if len(tlsNames) > 1 &&
+				tls.Spec.TLS.Termination == TLSPassthrough {
+				crMgr.recordTLSEvent(vs, tls, "InvalidTLSProfile",
+					fmt.Sprintf("%s termination requires a single "+
+						"TLSProfile", TLSPassthrough))
+				bigIPPrometheus.VirtualServerErrors.WithLabelValues(vsNamespace).Inc()
+				return false
+			}
+			sni := sniConfig{hosts: getVirtualServerHosts(vs)}
+			if len(tls.Spec.Hosts) > 0 {
+				sni.hosts = tls.Spec.Hosts
+			}
+			sni.serverName = sni.hosts[0]
+			if len(tlsNames) > 1 && i == 0 {
+				sni.priority = sniPriorityFirst
+			}
+			if tls.Spec.TLS.SNIDefault {
+				sni.priority = sniPriorityRequested
+			}
+			if !crMgr.handleTLSProfile(rsCfg, vs, tls, sni, hostRecords) {
+				bigIPPrometheus.VirtualServerErrors.WithLabelValues(vsNamespace).Inc()
+				return false
+			}
+		}
+		hostRecords[HstsDgName] = hstsHeader(vs.Spec.HSTS)
+		return true
+	}
+
+	// httpTraffic defines the behaviour of http Virtual Server on BIG-IP
+	// Possible values are allow, none and redirect
+	httpTraffic := vs.Spec.HTTPTraffic
+	// HTTP cannot be served for passthrough hosts, only redirected
+	if crMgr.isPassthroughVirtualServer(vs) {
+		httpTraffic = "redirect"
+	}
+	if httpTraffic != "" {
+		// -----------------------------------------------------------------
+		// httpTraffic = allow -> Allows HTTP
+		// httpTraffic = none  -> Only HTTPS
+		// httpTraffic = redirect -> redirects HTTP to HTTPS
+		// -----------------------------------------------------------------
+		if httpTraffic == "redirect" {
+			// set HTTP redirect iRule
+			log.Debugf("Applying HTTP redirect iRule.")
+			crMgr.addRedirectRecords(vs)
+			ruleName := fmt.Sprintf("%s_%d", HttpRedirectIRuleName, httpsPort)
+			crMgr.addIRule(ruleName, rsCfg.Virtual.Partition, httpRedirectIRule(httpsPort))
+			crMgr.addInternalDataGroup(HttpsRedirectDgName, rsCfg.Virtual.Partition)
+			ruleName = crMgr.iRulePath(rsCfg.Virtual.Partition, ruleName)
+			rsCfg.Virtual.AddIRule(ruleName)
+			for _, host := range getVirtualServerHosts(vs) {
+				for _, pool := range getVirtualServerPools(vs) {
+					svcFwdRulesMap.AddEntry(vs.ObjectMeta.Namespace,
+						pool.Service, host, pool.Path, vs.Spec.RedirectCode,
+						vs.Spec.RedirectHost)
+				}
+			}
+		} else if httpTraffic == "allow" {
+			// State 3, do not apply any policy
+			log.Debugf("[CORE] TLS: Not applying any policies.")
+		}
+	}
+
+	return false
+}
+
+// getTLSProfile returns the TLSProfile from TLSContext, the informer store
+// is looked up only for a TLSProfile which is not yet known to CIS.
+func (crMgr *CRManager) getTLSProfile(
+	crInf *CRInformer,
+	tlsKey string,
+) (*cisapiv1.TLSProfile, bool) {
+	crMgr.tlsMutex.Lock()
+	defer crMgr.tlsMutex.Unlock()
+	if tls, ok := crMgr.TLSContext[tlsKey]; ok {
+		return tls, true
+	}
+	tlsInterface, tlsFound, _ := crInf.tsInformer.GetIndexer().GetByKey(tlsKey)
+	if !tlsFound {
+		return nil, false
+	}
+	tls := tlsInterface.(*cisapiv1.TLSProfile)
+	crMgr.TLSContext[tlsKey] = tls
+	return tls, true
+}
+
+// handleTLSProfile attaches the profiles of the TLSProfile to the HTTPS
+// virtual of the VirtualServer.
+func (crMgr *CRManager) handleTLSProfile(
+	rsCfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	tls *cisapiv1.TLSProfile,
+	sni sniConfig,
+	hostRecords map[string]string,
+) bool {
+	vsNamespace := vs.ObjectMeta.Namespace
+	vsName := vs.ObjectMeta.Name
+	tlsName := tls.ObjectMeta.Name
+
+	if err := ValidateTLSProfile(tls); err != nil {
+		crMgr.recordTLSEvent(vs, tls,
+			specErrorReason(err, "InvalidTLSProfile"), err.Error())
+		return false
+	}
+
+	if tls.Spec.TLS.Termination == TLSPassthrough {
+		// No profile is created, the iRule forwards the traffic of the
+		// host to its pool without terminating TLS.
+		crMgr.addIRule(SslPassthroughIRuleName, rsCfg.Virtual.Partition,
+			sslPassthroughIRule())
+		crMgr.addInternalDataGroup(PassthroughHostsDgName,
+			rsCfg.Virtual.Partition)
+		rsCfg.Virtual.AddIRule(
+			crMgr.iRulePath(rsCfg.Virtual.Partition, SslPassthroughIRuleName))
+		hostRecords[PassthroughHostsDgName] = getHostPool(vs, rsCfg.Virtual.Partition)
+		log.Debugf("Updated Virtual '%s' to pass through TLS of hosts %v",
+			vsName, getVirtualServerHosts(vs))
+		return true
+	}
+
+	// Process Profile
+	switch tls.Spec.TLS.Reference {
+	case BIGIP:
+		clientSSL := tls.Spec.TLS.ClientSSL
+		serverSSL := tls.Spec.TLS.ServerSSL
+		// Profile is a BIG-IP default
+		log.Debugf("Processing BIGIP referenced profiles for Virtual '%s' using TLSProfile '%s'",
+			vsName, tlsName)
+		// Process referenced BIG-IP clientSSL
+		if clientSSL != "" {
+			rsCfg.Virtual.AddOrUpdateProfile(ConvertStringToProfileRef(
+				clientSSL, CustomProfileClient, vsNamespace))
+		}
+		// Process referenced BIG-IP serverSSL
+		if serverSSL != "" {
+			rsCfg.Virtual.AddOrUpdateProfile(ConvertStringToProfileRef(
+				serverSSL, CustomProfileServer, vsNamespace))
+		}
+		log.Debugf("Updated BIGIP referenced profiles for Virtual '%s' using TLSProfile '%s'",
+			vsName, tlsName)
+	case Secret:
+		// The profile is not created without the CA certificates, so
+		// it does not accept all the clients.
+		auth, ok := crMgr.getClientAuth(vs, tls)
+		if !ok {
+			return false
+		}
+		clientSSL := tls.Spec.TLS.ClientSSL
+		secret, err := crMgr.getTLSSecret(vsNamespace, clientSSL)
+		if err != nil {
+			crMgr.recordTLSEvent(vs, tls, "SecretNotFound",
+				fmt.Sprintf("Secret %s not found: %v", clientSSL, err))
+			crMgr.retryLater(vs, "Secret %s not found: %v", clientSSL, err)
+			return false
+		}
+		if err, _ := crMgr.createSecretSslProfile(rsCfg, secret, auth,
+			sni); err != nil {
+			crMgr.recordTLSEvent(vs, tls, "InvalidSecret", err.Error())
+			return false
+		}
+		profRef := ProfileRef{
+			Partition: rsCfg.Virtual.Partition,
+			Name:      clientSSL,
+			Context:   CustomProfileClient,
+			Namespace: vsNamespace,
+		}
+		rsCfg.Virtual.AddOrUpdateProfile(profRef)
+		crMgr.addSecretProfileRefs(rsCfg, vs, clientSSL)
+		sniDefault, requested := crMgr.updateSNIDefault(
+			rsCfg.Virtual.Partition, rsCfg.GetName())
+		if tls.Spec.TLS.SNIDefault && len(requested) > 1 {
+			crMgr.recordTLSEvent(vs, tls, "SNIDefaultConflict",
+				fmt.Sprintf("sniDefault of secrets %v conflicts on virtual "+
+					"%s, the certificate of %s is the default", requested,
+					rsCfg.GetName(), sniDefault))
+		}
+	}
+	return crMgr.handleVirtualServerReencrypt(rsCfg, vs, tls, hostRecords)
+}
+
+// handleVirtualServerReencrypt attaches the iRule encrypting the traffic
+// of the host of a reencrypt VirtualServer with the server SSL profile of
+// its TLSProfile. The profile is created from the CA certificate of the
+// secret with the secret reference.
+func (crMgr *CRManager) handleVirtualServerReencrypt(
+	rsCfg *ResourceConfig,
+	vs *cisapiv1.VirtualServer,
+	tls *cisapiv1.TLSProfile,
+	hostRecords map[string]string,
+) bool {
+	if tls.Spec.TLS.Termination != TLSReencrypt {
+		return true
+	}
+	serverSSL := tls.Spec.TLS.ServerSSL
+	var serverSSLPath string
+	switch tls.Spec.TLS.Reference {
+	case BIGIP:
+		profRef := ConvertStringToProfileRef(serverSSL, CustomProfileServer,
+			vs.ObjectMeta.Namespace)
+		serverSSLPath = JoinBigipPath(profRef.Partition, profRef.Name)
+	case Secret:
+		secret, err := crMgr.getTLSSecret(vs.ObjectMeta.Namespace, serverSSL)
+		if err != nil {
+			crMgr.recordTLSEvent(vs, tls, "SecretNotFound",
+				fmt.Sprintf("Secret %s not found: %v", serverSSL, err))
+			crMgr.retryLater(vs, "Secret %s not found: %v", serverSSL, err)
+			return false
+		}
+		if err := crMgr.createServerSslProfile(rsCfg, vs, secret); err != nil {
+			crMgr.recordTLSEvent(vs, tls, "InvalidSecret", err.Error())
+			return false
+		}
+		serverSSLPath = fmt.Sprintf("/%s/%s/%s_tls_client",
+			rsCfg.Virtual.Partition, as3SharedApplication, rsCfg.GetName())
+	}
+	crMgr.addIRule(SslReencryptIRuleName, rsCfg.Virtual.Partition,
+		sslReencryptIRule())
+	crMgr.addInternalDataGroup(ReencryptHostsDgName, rsCfg.Virtual.Partition)
+	crMgr.addInternalDataGroup(ReencryptServerSslDgName, rsCfg.Virtual.Partition)
+	rsCfg.Virtual.AddIRule(
+		crMgr.iRulePath(rsCfg.Virtual.Partition, SslReencryptIRuleName))
+	hostRecords[ReencryptHostsDgName] = getHostPool(vs, rsCfg.Virtual.Partition)
+	hostRecords[ReencryptServerSslDgName] = serverSSLPath
+	return true
+}
+
+// isPassthroughVirtualServer returns true if the TLSProfile of the
+// VirtualServer passes through TLS to the pool members.
+func (crMgr *CRManager) isPassthroughVirtualServer(
+	vs *cisapiv1.VirtualServer,
+) bool {
+	// Passthrough requires a single TLSProfile
+	tlsNames := getTLSProfileNames(vs)
+	if len(tlsNames) != 1 {
+		return false
+	}
+	crInf, ok := crMgr.getNamespaceInformer(vs.ObjectMeta.Namespace)
+	if !ok {
+		return false
+	}
+	tls, found := crMgr.getTLSProfile(crInf,
+		vs.ObjectMeta.Namespace+"/"+tlsNames[0])
+	return found && tls.Spec.TLS.Termination == TLSPassthrough
+}
+
+// redirectsHTTP returns true if the HTTP virtual of the VirtualServer
+// redirects the requests to HTTPS.
+func (crMgr *CRManager) redirectsHTTP(vs *cisapiv1.VirtualServer) bool {
+	if 0 == len(getTLSProfileNames(vs)) {
+		return false
+	}
+	return vs.Spec.HTTPTraffic == "redirect" ||
+		crMgr.isPassthroughVirtualServer(vs)
+}
+
+// getTLSProfileNames returns the names of the TLSProfiles of the
+// VirtualServer, the TLSProfile of the default certificate first.
+func getTLSProfileNames(vs *cisapiv1.VirtualServer) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range append([]string{vs.Spec.TLSProfileName},
+		vs.Spec.TLSProfileNames...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// recordTLSEvent reports the error in the TLSProfile of the VirtualServer
+// with a Warning Event on both.
+func (crMgr *CRManager) recordTLSEvent(
+	vs *cisapiv1.VirtualServer,
+	tls *cisapiv1.TLSProfile,
+	reason,
+	message string,
+) {
+	log.Errorf("VirtualServer %s/%s using TLSProfile %s: %s",
+		vs.ObjectMeta.Namespace, vs.ObjectMeta.Name, tls.ObjectMeta.Name,
+		message)
+	crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeWarning,
+		reason, message)
+	crMgr.recordEvent(tls, tls.ObjectMeta.Namespace, v1.EventTypeWarning,
+		reason, message)
+}
+
+// ConvertStringToProfileRef converts strings to profile references
+func ConvertStringToProfileRef(profileName, context, ns string) ProfileRef {
+	profName := strings.TrimSpace(strings.TrimPrefix(profileName, "/"))
+	parts := strings.Split(profName, "/")
+	profRef := ProfileRef{Context: context, Namespace: ns}
+	switch len(parts) {
+	case 2:
+		profRef.Partition = parts[0]
+		profRef.Name = parts[1]
+	case 1:
+		log.Debugf("[RESOURCE] Partition not provided in profile '%s', using default partition '%s'",
+			profileName, DEFAULT_PARTITION)
+		profRef.Partition = DEFAULT_PARTITION
+		profRef.Name = profileName
+	default:
+		// This is almost certainly an error, but again issue a warning for
+		// improved context here and pass it through to be handled elsewhere.
+		log.Warningf("[RESOURCE] Profile name '%v' is formatted incorrectly.", profileName)
+	}
+	return profRef
+}
+
+// AddOrUpdateProfile updates profile to rsCfg
 func (v *Virtual) AddOrUpdateProfile(prof ProfileRef) bool {
-	// The profiles are maintained as a sorted array.
 	// The profiles are maintained as a sorted array.
 	keyFunc := func(i int) bool {
 		return ((v.Profiles[i].Partition > prof.Partition) ||
@@ -658,99 +2315,655 @@ func (rc *ResourceConfig) SetPolicy(policy Policy) {
 	rc.Policies = append(rc.Policies, policy)
 }
 
-// FindPolicy gets the information of a policy
+// FindPolicy returns the policy with the control type. The policy is in
+// rc.Policies, changes through the pointer are changes of the config.
 func (rc *ResourceConfig) FindPolicy(controlType string) *Policy {
-	for _, pol := range rc.Policies {
-		for _, cType := range pol.Controls {
+	for i := range rc.Policies {
+		for _, cType := range rc.Policies[i].Controls {
 			if cType == controlType {
-				return &pol
+				return &rc.Policies[i]
+			}
+		}
+	}
+	return nil
+}
+
+// GetByName gets a specific Resource cfg, the virtuals of different
+// partitions may have the same name
+func (rs *Resources) GetByName(
+	partition string,
+	name string,
+) (*ResourceConfig, bool) {
+	rs.RLock()
+	defer rs.RUnlock()
+	resource, ok := rs.rsMap[NameRef{Name: name, Partition: partition}]
+	return resource, ok
+}
+
+// GetAllResources is list of all resource configs sorted by partition and
+// name. The list
+// is taken with the lock held, so that it can be iterated while configs are
+// added or deleted.
+func (rs *Resources) GetAllResources() ResourceConfigs {
+	rs.RLock()
+	defer rs.RUnlock()
+	var cfgs ResourceConfigs
+	for _, cfg := range rs.rsMap {
+		cfgs = append(cfgs, cfg)
+	}
+	sort.Slice(cfgs, func(i, j int) bool {
+		return lessNameRef(cfgs[i].GetRef(), cfgs[j].GetRef())
+	})
+	return cfgs
+}
+
+// lessNameRef orders the references by partition and name
+func lessNameRef(a, b NameRef) bool {
+	if a.Partition != b.Partition {
+		return a.Partition < b.Partition
+	}
+	return a.Name < b.Name
+}
+
+// setResourceConfig adds the config of the virtual, replacing the config
+// with the same partition and name.
+func (rs *Resources) setResourceConfig(cfg *ResourceConfig) {
+	rs.Lock()
+	defer rs.Unlock()
+	if oldCfg, ok := rs.rsMap[cfg.GetRef()]; ok {
+		rs.deleteAddress(oldCfg)
+	}
+	rs.rsMap[cfg.GetRef()] = cfg
+	if key := virtualAddressKey(cfg.Virtual.VirtualAddress); key != "" {
+		rs.addrMap[key] = cfg.GetRef()
+	}
+	bigIPPrometheus.ResourceConfigs.Set(float64(len(rs.rsMap)))
+}
+
+// deleteAddress removes the address of the config from addrMap, unless
+// another virtual took it already.
+func (rs *Resources) deleteAddress(cfg *ResourceConfig) {
+	key := virtualAddressKey(cfg.Virtual.VirtualAddress)
+	if key != "" && rs.addrMap[key] == cfg.GetRef() {
+		delete(rs.addrMap, key)
+	}
+}
+
+// getVirtualByAddress returns the partition and name of the virtual
+// configured on the address and port, in any partition.
+func (rs *Resources) getVirtualByAddress(
+	bindAddr string,
+	port int32,
+) (NameRef, bool) {
+	rs.RLock()
+	defer rs.RUnlock()
+	va := &virtualAddress{BindAddr: bindAddr, Port: port}
+	ref, ok := rs.addrMap[virtualAddressKey(va)]
+	if !ok {
+		return NameRef{}, false
+	}
+	// The address of the config may have been changed in place
+	cfg, ok := rs.rsMap[ref]
+	if !ok || virtualAddressKey(cfg.Virtual.VirtualAddress) !=
+		virtualAddressKey(va) {
+		return NameRef{}, false
+	}
+	return ref, true
+}
+
+// getVirtualConfig returns the config of the virtual named rsName in the
+// partition, or else of the virtual configured on the address and port
+// with another name.
+func (rs *Resources) getVirtualConfig(
+	partition string,
+	rsName string,
+	bindAddr string,
+	port int32,
+) (*ResourceConfig, bool) {
+	if cfg, ok := rs.GetByName(partition, rsName); ok {
+		return cfg, true
+	}
+	if ref, ok := rs.getVirtualByAddress(bindAddr, port); ok {
+		return rs.GetByName(ref.Partition, ref.Name)
+	}
+	// The virtuals listening on a port list are indexed by their first
+	// port only
+	rs.RLock()
+	defer rs.RUnlock()
+	for _, cfg := range rs.rsMap {
+		va := cfg.Virtual.VirtualAddress
+		if len(cfg.Virtual.PortList) > 0 && va != nil &&
+			va.BindAddr == bindAddr &&
+			cfg.Virtual.listensOn(port) {
+			return cfg, true
+		}
+	}
+	return nil, false
+}
+
+// virtualAddressKey returns the key of the address in addrMap, empty for
+// the virtuals without address.
+func virtualAddressKey(va *virtualAddress) string {
+	if nil == va {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", va.BindAddr, va.Port)
+}
+
+// removeOwner removes the resource from the owners of the config of the
+// virtual. It returns the config, and false if the config is not found
+// or the resource is not its owner.
+func (rs *Resources) removeOwner(
+	ref NameRef,
+	rscKey string,
+) (*ResourceConfig, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	cfg, ok := rs.rsMap[ref]
+	if !ok || !cfg.MetaData.removeOwner(rscKey) {
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// getVirtuals returns the partitions and names of the virtuals of the kind
+// of resource configured for the resource, sorted.
+func (rs *Resources) getVirtuals(kind string, rscKey string) []NameRef {
+	rs.RLock()
+	defer rs.RUnlock()
+	var refs []NameRef
+	for ref, cfg := range rs.rsMap {
+		if cfg.MetaData.ResourceType == kind && cfg.MetaData.hasOwner(rscKey) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return lessNameRef(refs[i], refs[j])
+	})
+	return refs
+}
+
+// getInactiveVirtualNames returns the sorted names of the virtuals of the
+// kind of resource configured for the resource which are inactive.
+func (rs *Resources) getInactiveVirtualNames(
+	kind string,
+	rscKey string,
+) []string {
+	rs.RLock()
+	defer rs.RUnlock()
+	var names []string
+	for ref, cfg := range rs.rsMap {
+		if cfg.MetaData.ResourceType == kind &&
+			cfg.MetaData.hasOwner(rscKey) && !cfg.MetaData.Active {
+			names = append(names, ref.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resetOldConfig clears the configs last posted, so that all the configs
+// are posted again.
+// resetConfigs forgets the configs, but not the configs last posted
+func (rs *Resources) resetConfigs() {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.rm = make(resourceKeyMap)
+	rs.rsMap = make(ResourceConfigMap)
+	rs.objDeps = make(ObjectDependencyMap)
+	rs.addrMap = make(map[string]NameRef)
+	if nil != rs.dnsConfig {
+		// The GSLB objects stay managed
+		rs.dnsConfig = make(DNSConfig)
+	}
+}
+
+func (rs *Resources) resetOldConfig() {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.oldRsMap = make(ResourceConfigMap)
+	rs.oldDNSConfig = nil
+}
+
+// DeepCopy returns a copy of the config sharing no slices, maps or
+// pointers with it, so that changing either one does not change the other.
+func (in *ResourceConfig) DeepCopy() *ResourceConfig {
+	if nil == in {
+		return nil
+	}
+	out := new(ResourceConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies the config into out, replacing all its sections.
+func (in *ResourceConfig) DeepCopyInto(out *ResourceConfig) {
+	*out = *in
+	in.MetaData.DeepCopyInto(&out.MetaData)
+	in.Virtual.DeepCopyInto(&out.Virtual)
+	if nil != in.Pools {
+		out.Pools = make(Pools, len(in.Pools))
+		for i := range in.Pools {
+			in.Pools[i].DeepCopyInto(&out.Pools[i])
+		}
+	}
+	if nil != in.Policies {
+		out.Policies = make(Policies, len(in.Policies))
+		for i := range in.Policies {
+			in.Policies[i].DeepCopyInto(&out.Policies[i])
+		}
+	}
+}
+
+// DeepCopyInto copies the metadata into out.
+func (in *metaData) DeepCopyInto(out *metaData) {
+	*out = *in
+	out.owners = copyStrings(in.owners)
+	if nil != in.abPools {
+		out.abPools = make(map[string][]string, len(in.abPools))
+		for vsKey, poolNames := range in.abPools {
+			out.abPools[vsKey] = copyStrings(poolNames)
+		}
+	}
+}
+
+// DeepCopyInto copies the virtual into out.
+func (in *Virtual) DeepCopyInto(out *Virtual) {
+	*out = *in
+	if nil != in.Policies {
+		out.Policies = make([]nameRef, len(in.Policies))
+		copy(out.Policies, in.Policies)
+	}
+	if nil != in.Profiles {
+		out.Profiles = make(ProfileRefs, len(in.Profiles))
+		copy(out.Profiles, in.Profiles)
+	}
+	out.IRules = copyStrings(in.IRules)
+	out.AllowVLANs = copyStrings(in.AllowVLANs)
+	out.PortList = copyStrings(in.PortList)
+	out.SourceAddrTranslation.Addresses = copyStrings(
+		in.SourceAddrTranslation.Addresses)
+	if nil != in.VirtualAddress {
+		va := *in.VirtualAddress
+		out.VirtualAddress = &va
+	}
+}
+
+// DeepCopyInto copies the pool into out. The members are never nil in the
+// copy, so that copies of pools without members are equal.
+func (in *Pool) DeepCopyInto(out *Pool) {
+	*out = *in
+	out.Members = make([]Member, len(in.Members))
+	copy(out.Members, in.Members)
+	if nil != in.StaticMembers {
+		out.StaticMembers = make([]Member, len(in.StaticMembers))
+		copy(out.StaticMembers, in.StaticMembers)
+	}
+	out.MonitorNames = copyStrings(in.MonitorNames)
+	if nil != in.Monitor {
+		monitor := *in.Monitor
+		out.Monitor = &monitor
+	}
+	if nil != in.Backup {
+		out.Backup = in.Backup.DeepCopy()
+	}
+}
+
+// DeepCopyInto copies the policy into out, with copies of its rules as the
+// rules are merged in place.
+func (in *Policy) DeepCopyInto(out *Policy) {
+	*out = *in
+	out.Controls = copyStrings(in.Controls)
+	out.Requires = copyStrings(in.Requires)
+	if nil != in.Rules {
+		out.Rules = make(Rules, len(in.Rules))
+		for i, rl := range in.Rules {
+			out.Rules[i] = rl.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy returns a copy of the rule with copies of its actions and
+// conditions.
+func (in *Rule) DeepCopy() *Rule {
+	if nil == in {
+		return nil
+	}
+	out := new(Rule)
+	*out = *in
+	if nil != in.Actions {
+		out.Actions = make([]*action, len(in.Actions))
+		for i, act := range in.Actions {
+			if nil != act {
+				a := *act
+				out.Actions[i] = &a
+			}
+		}
+	}
+	if nil != in.Conditions {
+		out.Conditions = make([]*condition, len(in.Conditions))
+		for i, cond := range in.Conditions {
+			if nil != cond {
+				c := *cond
+				c.Values = copyStrings(cond.Values)
+				out.Conditions[i] = &c
 			}
 		}
 	}
-	return nil
+	return out
+}
+
+// copyStrings returns a copy of the strings, nil if they are nil.
+func copyStrings(in []string) []string {
+	if nil == in {
+		return nil
+	}
+	out := make([]string, len(in))
+	copy(out, in)
+	return out
+}
+
+// Diff returns the sections of the config changed from the old config.
+// The virtual and all the other sections in the config are changed when
+// old is nil.
+func (rc *ResourceConfig) Diff(old *ResourceConfig) ResourceConfigDiff {
+	var diff ResourceConfigDiff
+	if nil == old {
+		diff = rc.Diff(&ResourceConfig{})
+		diff.Virtual = true
+		return diff
+	}
+	// Compared as copies, so that nil and empty members are the same
+	var cfg, oldCfg ResourceConfig
+	rc.DeepCopyInto(&cfg)
+	old.DeepCopyInto(&oldCfg)
+
+	diff.Profiles = (len(cfg.Virtual.Profiles) > 0 ||
+		len(oldCfg.Virtual.Profiles) > 0) &&
+		!reflect.DeepEqual(cfg.Virtual.Profiles, oldCfg.Virtual.Profiles)
+	diff.Policies = !reflect.DeepEqual(cfg.Virtual.Policies,
+		oldCfg.Virtual.Policies) ||
+		!reflect.DeepEqual(cfg.Policies, oldCfg.Policies)
+	cfg.Virtual.Profiles, oldCfg.Virtual.Profiles = nil, nil
+	cfg.Virtual.Policies, oldCfg.Virtual.Policies = nil, nil
+	diff.Virtual = !reflect.DeepEqual(cfg.Virtual, oldCfg.Virtual) ||
+		!reflect.DeepEqual(cfg.MetaData, oldCfg.MetaData)
+
+	oldPools := make(map[nameRef]Pool)
+	for _, pool := range oldCfg.Pools {
+		oldPools[nameRef{Name: pool.Name, Partition: pool.Partition}] = pool
+	}
+	for _, pool := range cfg.Pools {
+		key := nameRef{Name: pool.Name, Partition: pool.Partition}
+		oldPool, found := oldPools[key]
+		delete(oldPools, key)
+		if !found {
+			diff.Pools = append(diff.Pools, pool.Name)
+			continue
+		}
+		members, oldMembers := pool.Members, oldPool.Members
+		pool.Members, oldPool.Members = nil, nil
+		if !reflect.DeepEqual(pool, oldPool) {
+			diff.Pools = append(diff.Pools, pool.Name)
+		} else if !reflect.DeepEqual(members, oldMembers) {
+			diff.PoolMembers = append(diff.PoolMembers, pool.Name)
+		}
+	}
+	for _, pool := range oldPools {
+		diff.Pools = append(diff.Pools, pool.Name)
+	}
+	sort.Strings(diff.Pools)
+	sort.Strings(diff.PoolMembers)
+	return diff
+}
+
+// IsEmpty returns true if no section changed
+func (diff ResourceConfigDiff) IsEmpty() bool {
+	return !diff.Virtual && !diff.Profiles && !diff.Policies &&
+		len(diff.Pools) == 0 && len(diff.PoolMembers) == 0
+}
+
+// MembersOnly returns true if only the members of the pools changed
+func (diff ResourceConfigDiff) MembersOnly() bool {
+	return !diff.Virtual && !diff.Profiles && !diff.Policies &&
+		len(diff.Pools) == 0 && len(diff.PoolMembers) > 0
+}
+
+// idRdRegex matches an address of the form <ipv4_or_ipv6>[%<routeDomainID>]
+var idRdRegex = regexp.MustCompile(`^([^%]*)%(\d+)$`)
+
+// split_ip_with_route_domain splits ip into ip and route domain
+func split_ip_with_route_domain(address string) (ip string, rd string) {
+	// Split the address into the ip and routeDomain (optional) parts
+	//     address is of the form: <ipv4_or_ipv6>[%<routeDomainID>]
+	match := idRdRegex.FindStringSubmatch(address)
+	if match != nil {
+		ip = match[1]
+		rd = match[2]
+	} else {
+		ip = address
+		rd = ""
+	}
+	return
+}
+
+// normalizeAddress returns the canonical form of an IPv4 or IPv6 address
+// with an optional route domain, so that the different forms of an address
+// like 2001:DB8::1, 2001:db8:0::1 and [2001:db8::1] are named alike. An
+// address which is not an IP address is returned unchanged.
+func normalizeAddress(address string) string {
+	addr := strings.TrimSpace(address)
+	bracketed := false
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		addr = addr[1 : len(addr)-1]
+		bracketed = true
+	}
+	ip, rd := split_ip_with_route_domain(addr)
+	if strings.HasPrefix(ip, "[") && strings.HasSuffix(ip, "]") &&
+		!bracketed {
+		ip = ip[1 : len(ip)-1]
+		bracketed = true
+	}
+	parsed := net.ParseIP(ip)
+	// Only IPv6 addresses are enclosed in brackets
+	if nil == parsed || (bracketed && !strings.Contains(ip, ":")) {
+		return address
+	}
+	if rd == "" {
+		return parsed.String()
+	}
+	id, err := strconv.ParseInt(rd, 10, 32)
+	if err != nil {
+		return address
+	}
+	return fmt.Sprintf("%s%%%d", parsed.String(), id)
+}
+
+// validateAddress returns an error if the address is not an IPv4 or IPv6
+// address with an optional route domain.
+func validateAddress(address string) error {
+	ip, _ := split_ip_with_route_domain(normalizeAddress(address))
+	if nil != net.ParseIP(ip) {
+		return nil
+	}
+	if strings.Contains(ip, "%") {
+		return fmt.Errorf("Invalid address '%s', IPv6 zone IDs are not "+
+			"supported, the route domain is given as <address>%%<id>", address)
+	}
+	return fmt.Errorf("Invalid address '%s'", address)
+}
+
+// formatRouteDomainAddress decorates an address with the route domain
+// suffix (%<rd>). An address which already carries a route domain is
+// returned unchanged so that a route domain is never applied twice, and
+// route domain 0 is never appended as it is the default on BIG-IP.
+func formatRouteDomainAddress(address string, rd int32) string {
+	if address == "" || rd == 0 {
+		return address
+	}
+	if _, addrRD := split_ip_with_route_domain(address); addrRD != "" {
+		return address
+	}
+	return fmt.Sprintf("%s%%%d", address, rd)
+}
+
+// ParseNamespaceRouteDomains parses the route domains of the form
+// namespace=routeDomain
+func ParseNamespaceRouteDomains(mappings []string) (map[string]int32, error) {
+	rds := make(map[string]int32)
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid namespace route domain '%s', "+
+				"expected <namespace>=<route_domain>", mapping)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil || id < 0 || id > 65534 {
+			return nil, fmt.Errorf("Invalid route domain in '%s', it must "+
+				"be between 0 and 65534", mapping)
+		}
+		rds[parts[0]] = int32(id)
+	}
+	return rds, nil
+}
+
+// ParseNamespacePartitions parses the partitions of the form
+// namespace=partition, the partitions must be in the list of partitions.
+func ParseNamespacePartitions(
+	mappings []string,
+	partitions []string,
+) (map[string]string, error) {
+	nsPartitions := make(map[string]string)
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid namespace partition '%s', "+
+				"expected <namespace>=<partition>", mapping)
+		}
+		partition := strings.TrimSpace(parts[1])
+		if !containsString(partitions, partition) {
+			return nil, fmt.Errorf("Invalid partition in '%s', it must be "+
+				"one of %s", mapping, strings.Join(partitions, ", "))
+		}
+		nsPartitions[parts[0]] = partition
+	}
+	return nsPartitions, nil
+}
+
+// getRouteDomain returns the route domain of the resources of a namespace
+func (crMgr *CRManager) getRouteDomain(namespace string) int32 {
+	if rd, ok := crMgr.NamespaceRouteDomains[namespace]; ok {
+		return rd
+	}
+	return crMgr.DefaultRouteDomain
 }
 
-// GetByName gets a specific Resource cfg
-func (rs *Resources) GetByName(name string) (*ResourceConfig, bool) {
-	resource, ok := rs.rsMap[name]
-	return resource, ok
+// getRouteDomain returns the route domain of the virtual address, 0 if
+// the virtual is in the default route domain.
+func (v *Virtual) getRouteDomain() int32 {
+	if v.VirtualAddress == nil {
+		return 0
+	}
+	_, rd := split_ip_with_route_domain(v.VirtualAddress.BindAddr)
+	if rd == "" {
+		return 0
+	}
+	id, err := strconv.ParseInt(rd, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(id)
 }
 
-// GetAllResources is list of all resource configs
-func (rs *Resources) GetAllResources() ResourceConfigs {
-	var cfgs ResourceConfigs
-	for _, cfg := range rs.rsMap {
-		cfgs = append(cfgs, cfg)
+// updatePoolMembersRouteDomain decorates all the pool members of the
+// resource config with the route domain of its virtual address.
+func (rc *ResourceConfig) updatePoolMembersRouteDomain() {
+	rd := rc.Virtual.getRouteDomain()
+	if rd == 0 {
+		return
+	}
+	for i := range rc.Pools {
+		for j := range rc.Pools[i].Members {
+			rc.Pools[i].Members[j].Address = formatRouteDomainAddress(
+				rc.Pools[i].Members[j].Address, rd)
+		}
 	}
-	return cfgs
 }
 
-// Copies from an existing config into our new config
-func (rc *ResourceConfig) copyConfig(cfg *ResourceConfig) {
-	// MetaData
-	rc.MetaData = cfg.MetaData
-	// Virtual
-	rc.Virtual = cfg.Virtual
-	// Policies ref
-	rc.Virtual.Policies = make([]nameRef, len(cfg.Virtual.Policies))
-	copy(rc.Virtual.Policies, cfg.Virtual.Policies)
-	// Pools
-	rc.Pools = make(Pools, len(cfg.Pools))
-	copy(rc.Pools, cfg.Pools)
-	// Pool Members and Monitor Names
+// updateRouteDomainAddresses decorates the target addresses of the pool
+// monitors and the addresses of the SNAT pool of the resource config with
+// the route domain of its virtual address.
+func (rc *ResourceConfig) updateRouteDomainAddresses() {
+	rd := rc.Virtual.getRouteDomain()
+	if rd == 0 {
+		return
+	}
 	for i := range rc.Pools {
-		rc.Pools[i].Members = make([]Member, len(cfg.Pools[i].Members))
-		copy(rc.Pools[i].Members, cfg.Pools[i].Members)
+		if nil != rc.Pools[i].Monitor {
+			rc.Pools[i].Monitor.TargetAddress = formatRouteDomainAddress(
+				rc.Pools[i].Monitor.TargetAddress, rd)
+		}
 	}
-	// Policies
-	rc.Policies = make([]Policy, len(cfg.Policies))
-	copy(rc.Policies, cfg.Policies)
+	snat := &rc.Virtual.SourceAddrTranslation
+	for i := range snat.Addresses {
+		snat.Addresses[i] = formatRouteDomainAddress(snat.Addresses[i], rd)
+	}
+}
 
-	for i := range rc.Policies {
-		rc.Policies[i].Controls = make([]string, len(cfg.Policies[i].Controls))
-		copy(rc.Policies[i].Controls, cfg.Policies[i].Controls)
-		rc.Policies[i].Requires = make([]string, len(cfg.Policies[i].Requires))
-		copy(rc.Policies[i].Requires, cfg.Policies[i].Requires)
-
-		// Rules
-		rc.Policies[i].Rules = make([]*Rule, len(cfg.Policies[i].Rules))
-		// Actions and Conditions
-		for j := range rc.Policies[i].Rules {
-			rc.Policies[i].Rules[j] = &Rule{}
-			rc.Policies[i].Rules[j].Actions = make([]*action, len(cfg.Policies[i].Rules[j].Actions))
-			rc.Policies[i].Rules[j].Conditions = make([]*condition, len(cfg.Policies[i].Rules[j].Conditions))
-			for k := range rc.Policies[i].Rules[j].Conditions {
-				rc.Policies[i].Rules[j].Conditions[k] = &condition{}
-				rc.Policies[i].Rules[j].Conditions[k].Values =
-					make([]string, len(cfg.Policies[i].Rules[j].Conditions[k].Values))
-			}
+// isActive returns true if the virtual forwards the requests somewhere: a
+// pool has members, or a default pool or a backup pool is configured. A
+// virtual without pools, like one redirecting all its paths, is active.
+func (rc *ResourceConfig) isActive() bool {
+	if len(rc.Pools) == 0 || rc.Virtual.PoolName != "" {
+		return true
+	}
+	for _, pool := range rc.Pools {
+		if len(pool.Members) > 0 || nil != pool.Backup {
+			return true
 		}
-		copy(rc.Policies[i].Rules, cfg.Policies[i].Rules)
-		for j := range rc.Policies[i].Rules {
-			copy(rc.Policies[i].Rules[j].Actions, cfg.Policies[i].Rules[j].Actions)
-			copy(rc.Policies[i].Rules[j].Conditions, cfg.Policies[i].Rules[j].Conditions)
-			for k := range rc.Policies[i].Rules[j].Conditions {
-				copy(rc.Policies[i].Rules[j].Conditions[k].Values, cfg.Policies[i].Rules[j].Conditions[k].Values)
+	}
+	return false
+}
+
+// validateRouteDomains returns an error if a static member or the target
+// of a monitor of the pools, or a SNAT pool address is in another route
+// domain than the virtual, BIG-IP does not forward the traffic of a virtual
+// across route domains.
+func (rc *ResourceConfig) validateRouteDomains() error {
+	rd := rc.Virtual.getRouteDomain()
+	for _, pool := range rc.Pools {
+		for _, m := range pool.StaticMembers {
+			if !isInRouteDomain(m.Address, rd) {
+				return fmt.Errorf("Static member '%s' of pool %s is not in "+
+					"route domain %d of virtual %s", m.Address, pool.Name,
+					rd, rc.Virtual.Name)
 			}
 		}
+		if nil != pool.Monitor &&
+			!isInRouteDomain(pool.Monitor.TargetAddress, rd) {
+			return fmt.Errorf("Target address '%s' of the monitor of pool %s "+
+				"is not in route domain %d of virtual %s",
+				pool.Monitor.TargetAddress, pool.Name, rd, rc.Virtual.Name)
+		}
+	}
+	for _, addr := range rc.Virtual.SourceAddrTranslation.Addresses {
+		if !isInRouteDomain(addr, rd) {
+			return fmt.Errorf("SNAT address '%s' is not in route domain %d "+
+				"of virtual %s", addr, rd, rc.Virtual.Name)
+		}
 	}
+	return nil
 }
 
-// split_ip_with_route_domain splits ip into ip and route domain
-func split_ip_with_route_domain(address string) (ip string, rd string) {
-	// Split the address into the ip and routeDomain (optional) parts
-	//     address is of the form: <ipv4_or_ipv6>[%<routeDomainID>]
-	idRdRegex := regexp.MustCompile(`^([^%]*)%(\d+)$`)
-
-	match := idRdRegex.FindStringSubmatch(address)
-	if match != nil {
-		ip = match[1]
-		rd = match[2]
-	} else {
-		ip = address
-		rd = ""
+// isInRouteDomain returns true if the address is in the route domain, or
+// does not carry one
+func isInRouteDomain(address string, rd int32) bool {
+	_, addrRD := split_ip_with_route_domain(address)
+	if addrRD == "" {
+		return true
 	}
-	return
+	id, err := strconv.ParseInt(addrRD, 10, 32)
+	return err == nil && int32(id) == rd
 }
 
 // UpdateDependencies will keep the rs.objDeps map updated, and return two
@@ -762,6 +2975,9 @@ func (rs *Resources) UpdateDependencies(
 	lookupFunc func(key ObjectDependency) bool,
 ) ([]ObjectDependency, []ObjectDependency) {
 
+	rs.Lock()
+	defer rs.Unlock()
+
 	// Update dependencies for newKey
 	var added, removed []ObjectDependency
 	oldDeps, found := rs.objDeps[newKey]
@@ -798,26 +3014,27 @@ func (rs *Resources) UpdateDependencies(
 func (rc *ResourceConfig) DeleteRuleFromPolicy(
 	policyName string,
 	rule *Rule,
-	mergedRulesMap map[string]map[string]mergedRuleEntry,
+	mergedRulesMap map[NameRef]map[string]mergedRuleEntry,
 ) {
 	var policy *Policy
-	for _, pol := range rc.Policies {
-		if pol.Name == policyName {
-			policy = &pol
+	for i := range rc.Policies {
+		if rc.Policies[i].Name == policyName {
+			policy = &rc.Policies[i]
 			break
 		}
 	}
 	if nil != policy {
 		for i, r := range policy.Rules {
 			if r.Name == rule.Name && r.FullURI == rule.FullURI {
-				// Remove old rule
+				// Remove old rule, the policy may be removed or changed
+				// when the rule is unmerged.
 				unmerged := rc.UnmergeRule(rule.Name, mergedRulesMap)
 				if len(policy.Rules) == 1 && !unmerged {
 					rc.RemovePolicy(*policy)
 				} else if !unmerged {
 					ruleOffsets := []int{i}
 					policy.RemoveRules(ruleOffsets)
-					rc.SetPolicy(*policy)
+					policy.Requires = policyRequires(policy.Rules)
 				}
 				break
 			}
@@ -825,11 +3042,170 @@ func (rc *ResourceConfig) DeleteRuleFromPolicy(
 	}
 }
 
-//TODO ==> To be implemented Post Alpha.
-//Delete unused pool from resource config
-// Updating or removing the service from virtual may required delete unused pool from rscfg.
-// func (rc *ResourceConfig) DeleteUnusedPool(){
-// }
+// DeleteUnusedPool deletes the pools which are no longer referenced by the
+// virtual or by any rule of its policies. Pools used by the other
+// VirtualServers sharing the resource config are retained as their rules
+// still refer them.
+func (rc *ResourceConfig) DeleteUnusedPool() bool {
+	usedPools := make(map[string]bool)
+	if rc.Virtual.PoolName != "" {
+		usedPools[rc.Virtual.PoolName] = true
+	}
+	for _, poolNames := range rc.MetaData.abPools {
+		for _, poolName := range poolNames {
+			usedPools[poolName] = true
+		}
+	}
+	for _, pol := range rc.Policies {
+		for _, rl := range pol.Rules {
+			for _, act := range rl.Actions {
+				if act.Pool != "" {
+					usedPools[act.Pool] = true
+				}
+			}
+		}
+	}
+
+	var pools Pools
+	for _, pool := range rc.Pools {
+		if usedPools[pool.Name] {
+			pools = append(pools, pool)
+			continue
+		}
+		log.Debugf("Deleting unused pool %s from Virtual %s",
+			pool.Name, rc.Virtual.Name)
+	}
+	deleted := len(pools) != len(rc.Pools)
+	rc.Pools = pools
+	return deleted
+}
+
+// DeleteUnusedRules deletes the rules of the dependencies removed from a
+// VirtualServer, except those in ruleNames which are still configured.
+// A dependency is only removed when no other object refers it.
+func (rc *ResourceConfig) DeleteUnusedRules(
+	rs *Resources,
+	depsRemoved []ObjectDependency,
+	ruleNames map[string]bool,
+	mergedRulesMap map[NameRef]map[string]mergedRuleEntry,
+) {
+	for _, dep := range depsRemoved {
+		if dep.Kind != RuleDep || rs.isDependencyInUse(dep) {
+			continue
+		}
+		rc.deleteRulesForDependency(dep, ruleNames, mergedRulesMap)
+	}
+}
+
+// deleteRulesForDependency deletes the rules of the host and path of the
+// dependency forwarding to its pool, except the rules in ruleNames.
+func (rc *ResourceConfig) deleteRulesForDependency(
+	dep ObjectDependency,
+	ruleNames map[string]bool,
+	mergedRulesMap map[NameRef]map[string]mergedRuleEntry,
+) {
+	// Collect the rules first, as deleting modifies the policy.
+	var unusedRules []*Rule
+	for _, pol := range rc.Policies {
+		for _, rl := range pol.Rules {
+			if rl.FullURI == dep.Name && getRulePool(rl) == dep.Pool &&
+				!ruleNames[rl.Name] {
+				unusedRules = append(unusedRules, rl)
+			}
+		}
+	}
+	// Along with the reset rules of their source ranges
+	resetNames := make(map[string]bool)
+	for _, rl := range unusedRules {
+		resetNames[rl.Name+resetRuleSuffix] = true
+	}
+	for _, pol := range rc.Policies {
+		for _, rl := range pol.Rules {
+			if resetNames[rl.Name] && !ruleNames[rl.Name] {
+				unusedRules = append(unusedRules, rl)
+			}
+		}
+	}
+	for _, rl := range unusedRules {
+		if pol := rc.FindPolicy("forwarding"); nil != pol {
+			rc.DeleteRuleFromPolicy(pol.Name, rl, mergedRulesMap)
+		}
+	}
+
+	// The rewrite rules of the path are unmerged with its forwarding
+	// rules, delete them once no rule forwards the path.
+	pol := rc.FindPolicy("forwarding")
+	if nil == pol {
+		return
+	}
+	var rewriteRules []*Rule
+	for _, rl := range pol.Rules {
+		if rl.FullURI != dep.Name || ruleNames[rl.Name] {
+			continue
+		}
+		if !isRewriteRule(rl) {
+			return
+		}
+		rewriteRules = append(rewriteRules, rl)
+	}
+	for _, rl := range rewriteRules {
+		if pol := rc.FindPolicy("forwarding"); nil != pol {
+			rc.DeleteRuleFromPolicy(pol.Name, rl, mergedRulesMap)
+		}
+	}
+}
+
+// deleteDependencies forgets the dependencies of the object
+func (rs *Resources) deleteDependencies(key ObjectDependency) {
+	rs.Lock()
+	defer rs.Unlock()
+	delete(rs.objDeps, key)
+}
+
+// hasDependency returns true if the object depends on an object of the kind
+func (rs *Resources) hasDependency(key ObjectDependency, kind string) bool {
+	rs.RLock()
+	defer rs.RUnlock()
+	for dep := range rs.objDeps[key] {
+		if dep.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// isDependencyInUse returns true if any object still depends on dep
+func (rs *Resources) isDependencyInUse(dep ObjectDependency) bool {
+	rs.RLock()
+	defer rs.RUnlock()
+	for _, deps := range rs.objDeps {
+		if _, found := deps[dep]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRuleForPath returns true if deps has a rule for the host and path
+func (deps ObjectDependencies) hasRuleForPath(path string) bool {
+	for dep := range deps {
+		if dep.Kind == RuleDep && dep.Name == path {
+			return true
+		}
+	}
+	return false
+}
+
+// AddOrUpdatePool adds a new pool or replaces the pool with same name
+func (rc *ResourceConfig) AddOrUpdatePool(pool Pool) {
+	for i, pl := range rc.Pools {
+		if pl.Name == pool.Name && pl.Partition == pool.Partition {
+			rc.Pools[i] = pool
+			return
+		}
+	}
+	rc.Pools = append(rc.Pools, pool)
+}
 
 func (rc *ResourceConfig) RemovePolicy(policy Policy) {
 	toFind := nameRef{
@@ -886,113 +3262,155 @@ func (pol *Policy) RemoveRuleAt(offset int) bool {
 	return true
 }
 
-func (rc *ResourceConfig) UnmergeRule(ruleName string, mergedRulesMap map[string]map[string]mergedRuleEntry) bool {
+// UnmergeRule restores the rules merged with the rule before it is deleted.
+// It returns false when the rule is not merged, or when the rules merged with
+// it are already gone from the forwarding policy. The mergedRulesMap entries
+// of the rule are removed in any case.
+func (rc *ResourceConfig) UnmergeRule(ruleName string, mergedRulesMap map[NameRef]map[string]mergedRuleEntry) bool {
 	rsName := rc.GetName()
-	if _, ok := mergedRulesMap[rsName]; !ok {
-		return false
-	}
-	if _, ok := mergedRulesMap[rsName][ruleName]; !ok {
+	key := rc.GetRef()
+	entry, ok := mergedRulesMap[key][ruleName]
+	if !ok {
 		return false
 	}
-	entry := mergedRulesMap[rsName][ruleName]
+	delete(mergedRulesMap[key], ruleName)
+	// Delete entry from the mergedRulesMap if it is empty for this config
+	defer func() {
+		if len(mergedRulesMap[key]) == 0 {
+			delete(mergedRulesMap, key)
+		}
+	}()
+	policy := rc.FindPolicy("forwarding")
 
 	// This rule had other rules merged into it
 	if entry.MergedActions != nil {
-		// Find the policy and rule for this entry and delete it
-		policy := rc.FindPolicy("forwarding")
-		for i := range policy.Rules {
-			if policy.Rules[i].Name == entry.RuleName {
-				policy.Rules = append(policy.Rules[:i], policy.Rules[i+1:]...)
-				break
+		// Take the rules that were merged with this rule and delete their
+		// mergedRulesMap entries
+		var mergeeRules Rules
+		for _, mergeeRuleName := range entry.OtherRuleNames {
+			if mergeeRuleEntry, ok := mergedRulesMap[key][mergeeRuleName]; ok {
+				if nil != mergeeRuleEntry.OriginalRule {
+					mergeeRules = append(mergeeRules, mergeeRuleEntry.OriginalRule)
+				}
+				delete(mergedRulesMap[key], mergeeRuleName)
 			}
 		}
-
-		// Unmerge the rules that were merged with this rule
-		for _, mergeeRuleName := range entry.OtherRuleNames {
-			mergeeRuleEntry := mergedRulesMap[rsName][mergeeRuleName]
-			mergeeRule := mergeeRuleEntry.OriginalRule
-
-			// Add unmerged rule back to policy delete its mergedRulesMap entry
-			policy.Rules = append(policy.Rules, mergeeRule)
-			delete(mergedRulesMap[rsName], mergeeRuleName)
+		if nil == policy {
+			log.Warningf("Virtual %s: forwarding policy of merged rule %s "+
+				"not found", rsName, ruleName)
+			return false
+		}
+		index := policy.findRule(entry.RuleName)
+		if index < 0 {
+			log.Warningf("Virtual %s: merged rule %s not found", rsName,
+				ruleName)
+			return false
 		}
 
-		// Reset the policy and delete this mergedRulesMap entry and then merge the rules of the reset policy
+		// Replace the rule with the unmerged rules and merge the rules of
+		// the reset policy
+		policy.Rules = append(policy.Rules[:index], policy.Rules[index+1:]...)
+		policy.Rules = append(policy.Rules, mergeeRules...)
 		rc.SetPolicy(*policy)
-		delete(mergedRulesMap[rsName], ruleName)
 		rc.MergeRules(mergedRulesMap)
-		// This rule was merged into anther rule
-	} else {
-		mergerRuleName := entry.OtherRuleNames[0]
-		mergerRuleEntry := mergedRulesMap[rsName][mergerRuleName]
+		return true
+	}
 
-		// Remove ruleName from merged rule entry
-		for i := range mergerRuleEntry.OtherRuleNames {
-			if mergerRuleEntry.OtherRuleNames[i] == ruleName {
-				mergerRuleEntry.OtherRuleNames = append(mergerRuleEntry.OtherRuleNames[:i], mergerRuleEntry.OtherRuleNames[i+1:]...)
-				break
-			}
-		}
+	// This rule was merged into anther rule
+	if len(entry.OtherRuleNames) == 0 {
+		return false
+	}
+	mergerRuleName := entry.OtherRuleNames[0]
+	mergerRuleEntry, ok := mergedRulesMap[key][mergerRuleName]
+	if !ok {
+		log.Warningf("Virtual %s: rule %s merged with rule %s is already "+
+			"unmerged", rsName, ruleName, mergerRuleName)
+		return false
+	}
 
-		// Get the merged actions and delete them from the merger entry and delete mergedRulesMap entries
-		mergedActions := mergerRuleEntry.MergedActions[ruleName]
-		delete(mergerRuleEntry.MergedActions, ruleName)
-		if len(mergerRuleEntry.MergedActions) == 0 {
-			delete(mergedRulesMap[rsName], mergerRuleName)
+	// Remove ruleName from merged rule entry, and delete the entry once no
+	// actions are merged into the rule
+	var otherRuleNames []string
+	for _, name := range mergerRuleEntry.OtherRuleNames {
+		if name != ruleName {
+			otherRuleNames = append(otherRuleNames, name)
 		}
-		delete(mergedRulesMap[rsName], ruleName)
-
-		// Find the merger rule and delete the merged actions from it
-		policy := rc.FindPolicy("forwarding")
-		for i := range policy.Rules {
-			if policy.Rules[i].Name == mergerRuleName {
-				var deletedActionIndices []int
+	}
+	mergerRuleEntry.OtherRuleNames = otherRuleNames
+	mergedActions := mergerRuleEntry.MergedActions[ruleName]
+	delete(mergerRuleEntry.MergedActions, ruleName)
+	if len(mergerRuleEntry.MergedActions) == 0 {
+		delete(mergedRulesMap[key], mergerRuleName)
+	} else {
+		mergedRulesMap[key][mergerRuleName] = mergerRuleEntry
+	}
 
-				// Find and these merged actions indices
-				for j := range mergedActions {
-					for k := range policy.Rules[i].Actions {
-						if mergedActions[j] == policy.Rules[i].Actions[k] {
-							deletedActionIndices = append(deletedActionIndices, k)
-						}
-					}
-				}
+	if nil == policy {
+		log.Warningf("Virtual %s: forwarding policy of merged rule %s "+
+			"not found", rsName, ruleName)
+		return false
+	}
+	index := policy.findRule(mergerRuleName)
+	if index < 0 {
+		log.Warningf("Virtual %s: rule %s merged with rule %s not found",
+			rsName, ruleName, mergerRuleName)
+		return false
+	}
 
-				// Delete these merged actions
-				for _, index := range deletedActionIndices {
-					policy.Rules[i].Actions = append(policy.Rules[i].Actions[:index], policy.Rules[i].Actions[index+1:]...)
-					for j := range deletedActionIndices {
-						deletedActionIndices[j]--
-					}
-				}
+	// Delete the merged actions from the merger rule, and the merger rule
+	// if everything has been unmerged
+	mergerRule := policy.Rules[index]
+	mergerRule.Actions = removeActions(mergerRule.Actions, mergedActions)
+	if len(mergerRule.Actions) == 0 {
+		policy.Rules = append(policy.Rules[:index], policy.Rules[index+1:]...)
+	}
 
-				// Delete the merged rule if everything has been unmerged
-				if len(policy.Rules[i].Actions) == 0 {
-					policy.Rules = append(policy.Rules[:i], policy.Rules[i+1:]...)
-				}
-				break
-			}
-		}
+	// Delete the policy if its rules are empty
+	if len(policy.Rules) == 0 {
+		rc.RemovePolicy(*policy)
+	} else {
+		rc.SetPolicy(*policy)
+	}
+	return true
+}
 
-		// Delete the policy if its rules are empty
-		if len(policy.Rules) == 0 {
-			rc.RemovePolicy(*policy)
-		} else {
-			rc.SetPolicy(*policy)
+// findRule returns the index of the rule in the policy, -1 if not found.
+func (pol *Policy) findRule(name string) int {
+	for i := range pol.Rules {
+		if pol.Rules[i].Name == name {
+			return i
 		}
 	}
+	return -1
+}
 
-	// Delete entry from the mergedRulesMap if it is empty for this config
-	if len(mergedRulesMap[rsName]) == 0 {
-		delete(mergedRulesMap, rsName)
+// removeActions returns the actions without the removed ones. The actions
+// are compared by value as they are copied along with the resource config,
+// each removed action removes one action.
+func removeActions(actions, removed []*action) []*action {
+	kept := append([]*action{}, actions...)
+	for _, act := range removed {
+		for i := range kept {
+			if *kept[i] == *act {
+				kept = append(kept[:i], kept[i+1:]...)
+				break
+			}
+		}
 	}
-	return true
+	return kept
 }
 
 func (cfg *ResourceConfig) GetName() string {
 	return cfg.Virtual.Name
 }
 
-func (rc *ResourceConfig) MergeRules(mergedRulesMap map[string]map[string]mergedRuleEntry) {
+// GetRef returns the partition and name of the virtual, the key of the
+// config in the resources
+func (cfg *ResourceConfig) GetRef() NameRef {
+	return NameRef{Name: cfg.Virtual.Name, Partition: cfg.Virtual.Partition}
+}
+
+func (rc *ResourceConfig) MergeRules(mergedRulesMap map[NameRef]map[string]mergedRuleEntry) {
 	policy := rc.FindPolicy("forwarding")
 	if policy == nil {
 		return
@@ -1000,212 +3418,299 @@ func (rc *ResourceConfig) MergeRules(mergedRulesMap map[string]map[string]merged
 
 	rules := policy.Rules
 
-	var iDeletedRuleIndices []int
-	var jDeletedRuleIndices []int
-
-	// Iterate through the rules and compare them to each other
+	// Only the rules with the same conditions are merged, the rules are
+	// grouped by their conditions in the order of the policy.
+	groups := make(map[string][]int)
+	var keys []string
 	for i, rl := range rules {
-		if strings.HasSuffix(rl.Name, "-reset") {
+		if strings.HasSuffix(rl.Name, resetRuleSuffix) ||
+			isMaintenanceRule(rl) {
 			continue
 		}
-		// Do not merge the same rule to itself or to rules that have already been merged
-		for j := i + 1; j < len(rules); j++ {
-			if strings.HasSuffix(rules[j].Name, "-reset") {
-				continue
-			}
-			numMatches := 0
-			numIConditions := len(rules[i].Conditions)
-			numJConditions := len(rules[j].Conditions)
-			if numIConditions == numJConditions {
-				for k := range rules[i].Conditions {
-					for l := range rules[j].Conditions {
-						kConditionName := rules[i].Conditions[k].Name
-						lConditionName := rules[j].Conditions[l].Name
-						rules[i].Conditions[k].Name = ""
-						rules[j].Conditions[l].Name = ""
-						if reflect.DeepEqual(rules[i].Conditions[k], rules[j].Conditions[l]) {
-							numMatches++
-						}
-						rules[i].Conditions[k].Name = kConditionName
-						rules[j].Conditions[l].Name = lConditionName
-					}
-				}
-
-				// Only merge if both sets of conditions match
-				if numMatches == numIConditions {
-					var mergerEntry mergedRuleEntry
-					var mergeeEntry mergedRuleEntry
-
-					iName := rules[i].Name
-					jName := rules[j].Name
-					// Merge rule[i] into rule[j]
-					if ((strings.Contains(iName, "app-root") || strings.Contains(iName, "url-rewrite")) && !(strings.Contains(jName, "app-root") || strings.Contains(jName, "url-rewrite"))) ||
-						((strings.Contains(iName, "app-root") || strings.Contains(iName, "url-rewrite")) && (strings.Contains(jName, "app-root") || strings.Contains(jName, "url-rewrite"))) {
-						iDeletedRuleIndices = append(iDeletedRuleIndices, i)
-						mergerEntry.RuleName = jName
-						mergeeEntry.RuleName = iName
-						mergerEntry.OtherRuleNames = []string{iName}
-						mergeeEntry.OtherRuleNames = []string{jName}
-						mergerEntry.OriginalRule = rules[j]
-						mergeeEntry.OriginalRule = rules[i]
-
-						// Merge only unique actions
-						for k := range rules[i].Actions {
-							found := false
-							for l := range rules[j].Actions {
-								mergeeName := rules[i].Actions[k].Name
-								mergerName := rules[j].Actions[l].Name
-								rules[i].Actions[k].Name = ""
-								rules[j].Actions[l].Name = ""
-								if reflect.DeepEqual(rules[i].Actions[k], rules[j].Actions[l]) {
-									found = true
-								}
-								rules[i].Actions[k].Name = mergeeName
-								rules[j].Actions[l].Name = mergerName
-							}
-							if !found {
-								rules[j].Actions = append(rules[j].Actions, rules[i].Actions[k])
-								mergerEntry.MergedActions = make(map[string][]*action)
-								mergerEntry.MergedActions[iName] = append(mergerEntry.MergedActions[iName], rules[i].Actions[k])
-							}
-						}
-						// Merge rule[j] into rule[i]
-					} else if !(strings.Contains(iName, "app-root") || strings.Contains(iName, "url-rewrite")) && (strings.Contains(jName, "app-root") || strings.Contains(jName, "url-rewrite")) {
-						jDeletedRuleIndices = append(jDeletedRuleIndices, j)
-						mergerEntry.RuleName = iName
-						mergeeEntry.RuleName = jName
-						mergerEntry.OtherRuleNames = []string{jName}
-						mergeeEntry.OtherRuleNames = []string{iName}
-						mergerEntry.OriginalRule = rules[i]
-						mergeeEntry.OriginalRule = rules[j]
-
-						// Merge only unique actions
-						for k := range rules[j].Actions {
-							found := false
-							for l := range rules[i].Actions {
-								mergeeName := rules[j].Actions[k].Name
-								mergerName := rules[i].Actions[l].Name
-								rules[j].Actions[k].Name = ""
-								rules[i].Actions[l].Name = ""
-								if reflect.DeepEqual(rules[j].Actions[k], rules[i].Actions[l]) {
-									found = true
-								}
-								rules[j].Actions[k].Name = mergeeName
-								rules[i].Actions[l].Name = mergerName
-							}
-							if !found {
-								rules[i].Actions = append(rules[i].Actions, rules[j].Actions[k])
-								mergerEntry.MergedActions = make(map[string][]*action)
-								mergerEntry.MergedActions[jName] = append(mergerEntry.MergedActions[jName], rules[j].Actions[k])
-							}
-						}
-					}
-
-					contains := func(slice []string, s string) bool {
-						for _, v := range slice {
-							if v == s {
-								return true
-							}
-						}
-						return false
-					}
-
-					// Process entries to the mergedRulesMap
-					key := rc.GetName()
-					if len(mergerEntry.MergedActions) != 0 {
-						// Check if there is are entries for this resource config
-						if _, ok := mergedRulesMap[key]; ok {
-							// See if there is an entry for the merger
-							if entry, ok := mergedRulesMap[key][mergerEntry.RuleName]; ok {
-								if !contains(entry.OtherRuleNames, mergerEntry.OtherRuleNames[0]) {
-									mergerEntry.OtherRuleNames = append(mergerEntry.OtherRuleNames, entry.OtherRuleNames...)
-								}
-								mergerEntry.OriginalRule = entry.OriginalRule
-
-								if len(entry.MergedActions) != 0 {
-									for k, v := range entry.MergedActions {
-										mergerEntry.MergedActions[k] = v
-									}
-								}
-							}
-							// See if there is an entry for the mergee
-							if entry, ok := mergedRulesMap[key][mergeeEntry.RuleName]; ok {
-								mergeeEntry.OriginalRule = entry.OriginalRule
-							}
-						} else {
-							mergedRulesMap[key] = make(map[string]mergedRuleEntry)
-						}
-
-						mergedRulesMap[key][mergerEntry.RuleName] = mergerEntry
-						mergedRulesMap[key][mergeeEntry.RuleName] = mergeeEntry
-					}
-				}
-			}
+		key := conditionsKey(rl.Conditions)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
 		}
-	}
-
-	// Process deleted rule indices and remove duplicates
-	deletedRuleIndices := append(iDeletedRuleIndices, jDeletedRuleIndices...)
-	sort.Ints(deletedRuleIndices)
-	var uniqueDeletedRuleIndices []int
-	for i := range deletedRuleIndices {
-		if i == 0 {
-			uniqueDeletedRuleIndices = append(uniqueDeletedRuleIndices, deletedRuleIndices[i])
-		} else {
-			found := false
-			for j := range uniqueDeletedRuleIndices {
-				if uniqueDeletedRuleIndices[j] == deletedRuleIndices[i] {
-					found = true
+		groups[key] = append(groups[key], i)
+	}
+
+	// Merge the rules of each group to each other
+	deletedRuleIndices := make(map[int]bool)
+	for _, key := range keys {
+		group := groups[key]
+		for x, i := range group {
+			for _, j := range group[x+1:] {
+				switch {
+				// Merge rule[i] into rule[j]
+				case isMergeableRule(rules[i]):
+					deletedRuleIndices[i] = true
+					rc.mergeRule(rules[j], rules[i], mergedRulesMap)
+				// Merge rule[j] into rule[i]
+				case isMergeableRule(rules[j]):
+					deletedRuleIndices[j] = true
+					rc.mergeRule(rules[i], rules[j], mergedRulesMap)
 				}
 			}
-			if !found {
-				uniqueDeletedRuleIndices = append(uniqueDeletedRuleIndices, deletedRuleIndices[i])
-			}
 		}
 	}
 
 	// Remove rules that were merged with others
-	for _, index := range uniqueDeletedRuleIndices {
-		rules = append(rules[:index], rules[index+1:]...)
-		for i := range uniqueDeletedRuleIndices {
-			uniqueDeletedRuleIndices[i]--
+	if len(deletedRuleIndices) > 0 {
+		var remaining Rules
+		for i, rl := range rules {
+			if !deletedRuleIndices[i] {
+				remaining = append(remaining, rl)
+			}
 		}
+		rules = remaining
 	}
 
 	// Sort the rules
-	//sort.Sort(sort.Reverse(&rules))
+	sortRules(rules)
 
 	policy.Rules = rules
 	rc.SetPolicy(*policy)
 }
 
-func (rcs ResourceConfigs) GetAllPoolMembers() []Member {
-	// Get all pool members and write them to VxlanMgr to configure ARP entries
+// isMergeableRule returns true for the app-root and URL rewrite rules,
+// which are merged into the other rules with the same conditions.
+func isMergeableRule(rl *Rule) bool {
+	return strings.Contains(rl.Name, "app-root") ||
+		strings.Contains(rl.Name, "url-rewrite")
+}
+
+// conditionsKey returns the key of the conditions of a rule, equal for the
+// rules with the same conditions in any order, regardless of their names.
+func conditionsKey(conditions []*condition) string {
+	keys := make([]string, 0, len(conditions))
+	for _, c := range conditions {
+		cond := *c
+		cond.Name = ""
+		key, _ := json.Marshal(cond)
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	key, _ := json.Marshal(keys)
+	return string(key)
+}
+
+// actionsEqual returns true if the actions are the same regardless of their
+// names.
+func actionsEqual(a, b *action) bool {
+	ac, bc := *a, *b
+	ac.Name, bc.Name = "", ""
+	return ac == bc
+}
+
+// mergeRule merges the unique actions of the mergee rule into the merger
+// rule with the same conditions, and records them in mergedRulesMap so the
+// rules are unmerged when the mergee rule is deleted.
+func (rc *ResourceConfig) mergeRule(
+	merger, mergee *Rule,
+	mergedRulesMap map[NameRef]map[string]mergedRuleEntry,
+) {
+	mergerEntry := mergedRuleEntry{
+		RuleName:       merger.Name,
+		OtherRuleNames: []string{mergee.Name},
+		OriginalRule:   merger,
+	}
+	mergeeEntry := mergedRuleEntry{
+		RuleName:       mergee.Name,
+		OtherRuleNames: []string{merger.Name},
+		OriginalRule:   mergee,
+	}
+
+	// Merge only unique actions
+	for _, act := range mergee.Actions {
+		found := false
+		for _, mergerAct := range merger.Actions {
+			if actionsEqual(act, mergerAct) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merger.Actions = append(merger.Actions, act)
+			if nil == mergerEntry.MergedActions {
+				mergerEntry.MergedActions = make(map[string][]*action)
+			}
+			mergerEntry.MergedActions[mergee.Name] = append(
+				mergerEntry.MergedActions[mergee.Name], act)
+		}
+	}
+	if len(mergerEntry.MergedActions) == 0 {
+		return
+	}
+
+	// Process entries to the mergedRulesMap
+	key := rc.GetRef()
+	// Check if there is are entries for this resource config
+	if _, ok := mergedRulesMap[key]; ok {
+		// See if there is an entry for the merger
+		if entry, ok := mergedRulesMap[key][mergerEntry.RuleName]; ok {
+			if !containsString(entry.OtherRuleNames, mergee.Name) {
+				mergerEntry.OtherRuleNames = append(mergerEntry.OtherRuleNames, entry.OtherRuleNames...)
+			}
+			mergerEntry.OriginalRule = entry.OriginalRule
+
+			for k, v := range entry.MergedActions {
+				mergerEntry.MergedActions[k] = v
+			}
+		}
+		// See if there is an entry for the mergee
+		if entry, ok := mergedRulesMap[key][mergeeEntry.RuleName]; ok {
+			mergeeEntry.OriginalRule = entry.OriginalRule
+		}
+	} else {
+		mergedRulesMap[key] = make(map[string]mergedRuleEntry)
+	}
+
+	mergedRulesMap[key][mergerEntry.RuleName] = mergerEntry
+	mergedRulesMap[key][mergeeEntry.RuleName] = mergeeEntry
+}
+
+func (rcs ResourceConfigs) GetAllPoolMembers(podNetworks []*net.IPNet) []Member {
+	// Get all pool members and write them to VxlanMgr to configure ARP entries,
+	// the draining members of terminating pods are still reachable.
 	var allPoolMembers []Member
 
 	for _, cfg := range rcs {
 		// Filter the configs to only those that have active services
 		if cfg.MetaData.Active {
 			for _, pool := range cfg.Pools {
-				allPoolMembers = append(allPoolMembers, pool.Members...)
+				for _, member := range pool.Members {
+					// Static members outside the pod networks have no
+					// VXLAN tunnel endpoint
+					if member.Static && !inNetworks(member.Address, podNetworks) {
+						continue
+					}
+					allPoolMembers = append(allPoolMembers, member)
+				}
 			}
 		}
 	}
+	sortMembers(allPoolMembers)
 	return allPoolMembers
 }
 
+// sortMembers sorts the members by address and port.
+func sortMembers(members []Member) {
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Address != members[j].Address {
+			return members[i].Address < members[j].Address
+		}
+		return members[i].Port < members[j].Port
+	})
+}
+
+// getRemovedPoolMembers returns the members of the configs last posted at
+// addresses no member of the configs has anymore, including the members of
+// the deleted configs.
+func (rs *Resources) getRemovedPoolMembers(podNetworks []*net.IPNet) []Member {
+	rs.RLock()
+	defer rs.RUnlock()
+	var cfgs, oldCfgs ResourceConfigs
+	for _, cfg := range rs.rsMap {
+		cfgs = append(cfgs, cfg)
+	}
+	for _, cfg := range rs.oldRsMap {
+		oldCfgs = append(oldCfgs, cfg)
+	}
+	addresses := make(map[string]bool)
+	for _, member := range cfgs.GetAllPoolMembers(podNetworks) {
+		addresses[member.Address] = true
+	}
+	var removed []Member
+	for _, member := range oldCfgs.GetAllPoolMembers(podNetworks) {
+		if !addresses[member.Address] {
+			addresses[member.Address] = true
+			removed = append(removed, member)
+		}
+	}
+	return removed
+}
+
+// inNetworks returns true if the address, with or without route domain, is
+// in one of the networks.
+func inNetworks(address string, networks []*net.IPNet) bool {
+	ip, _ := split_ip_with_route_domain(address)
+	addr := net.ParseIP(ip)
+	for _, network := range networks {
+		if nil != addr && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getConfigDiffs returns the diffs of the configs changed from the old
+// configs, key is the partition and name of the virtual. A deleted config has a diff of
+// all its sections.
+func (rs *Resources) getConfigDiffs() map[NameRef]ResourceConfigDiff {
+	rs.RLock()
+	defer rs.RUnlock()
+	return rs.configDiffs()
+}
+
+// configDiffs returns the diffs of getConfigDiffs, with the lock held by
+// the caller.
+func (rs *Resources) configDiffs() map[NameRef]ResourceConfigDiff {
+	diffs := make(map[NameRef]ResourceConfigDiff)
+	for ref, cfg := range rs.rsMap {
+		if diff := cfg.Diff(rs.oldRsMap[ref]); !diff.IsEmpty() {
+			diffs[ref] = diff
+		}
+	}
+	for ref, oldCfg := range rs.oldRsMap {
+		if _, found := rs.rsMap[ref]; !found {
+			diffs[ref] = oldCfg.Diff(nil)
+		}
+	}
+	return diffs
+}
+
+// updateOldConfig updates the old configs to the configs posted. Only the
+// members are copied into the old pools of the configs with only members
+// changed, the other changed configs are copied whole.
 func (rs *Resources) updateOldConfig() {
-	rs.oldRsMap = make(ResourceConfigMap)
-	for k, v := range rs.rsMap {
-		rs.oldRsMap[k] = &ResourceConfig{}
-		rs.oldRsMap[k].copyConfig(v)
+	rs.Lock()
+	defer rs.Unlock()
+	for ref, diff := range rs.configDiffs() {
+		cfg, found := rs.rsMap[ref]
+		if !found {
+			delete(rs.oldRsMap, ref)
+			continue
+		}
+		oldCfg := rs.oldRsMap[ref]
+		if !diff.MembersOnly() {
+			rs.oldRsMap[ref] = cfg.DeepCopy()
+			continue
+		}
+		members := make(map[nameRef][]Member)
+		for _, pool := range cfg.Pools {
+			members[nameRef{Name: pool.Name, Partition: pool.Partition}] =
+				pool.Members
+		}
+		for i, oldPool := range oldCfg.Pools {
+			key := nameRef{Name: oldPool.Name, Partition: oldPool.Partition}
+			oldCfg.Pools[i].Members = make([]Member, len(members[key]))
+			copy(oldCfg.Pools[i].Members, members[key])
+		}
 	}
+	rs.oldDNSConfig = rs.dnsConfig.copyDNSConfig()
 }
 
 // Deletes respective VirtualServer resource configuration from
 // resource configs.
-func (rs *Resources) deleteVirtualServer(rsName string) {
-	delete(rs.rsMap, rsName)
+func (rs *Resources) deleteVirtualServer(ref NameRef) {
+	rs.Lock()
+	defer rs.Unlock()
+	if cfg, ok := rs.rsMap[ref]; ok {
+		rs.deleteAddress(cfg)
+		bigIPPrometheus.VirtualsDeleted.Inc()
+	}
+	delete(rs.rsMap, ref)
+	bigIPPrometheus.ResourceConfigs.Set(float64(len(rs.rsMap)))
 }
 
 func NewInternalDataGroup(name, partition string) *InternalDataGroup {
@@ -1241,6 +3746,10 @@ const EdgeServerSslDgName = "ssl_edge_serverssl_dg"
 // Internal data group for ab deployment routes.
 const AbDeploymentDgName = "ab_deployment_dg"
 
+// Internal data group that maps the host name to the value of its
+// Strict-Transport-Security header.
+const HstsDgName = "hsts_dg"
+
 var groupFlattenFuncMap = map[string]FlattenConflictFunc{
 	PassthroughHostsDgName:   flattenConflictWarn,
 	ReencryptHostsDgName:     flattenConflictWarn,
@@ -1249,6 +3758,7 @@ var groupFlattenFuncMap = map[string]FlattenConflictFunc{
 	EdgeServerSslDgName:      flattenConflictWarn,
 	HttpsRedirectDgName:      flattenConflictConcat,
 	AbDeploymentDgName:       flattenConflictConcat,
+	HstsDgName:               flattenConflictWarn,
 }
 
 func flattenConflictConcat(key, oldVal, newVal string) string {
@@ -1300,10 +3810,19 @@ func (dgnm DataGroupNamespaceMap) FlattenNamespaces() *InternalDataGroup {
 		}
 	}
 
+	// The namespaces are flattened in order, so the conflicts are resolved
+	// the same way every time.
+	namespaces := make([]string, 0, len(dgnm))
+	for namespace := range dgnm {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
 	// Use a map to identify duplicates across namespaces
 	var partition, name string
 	flatMap := make(map[string]string)
-	for _, dg := range dgnm {
+	for _, namespace := range namespaces {
+		dg := dgnm[namespace]
 		if partition == "" {
 			partition = dg.Partition
 		}
@@ -0,0 +1,1026 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Resource Config Tests", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+	var httpPort portStruct
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		httpPort = portStruct{protocol: "http", port: DEFAULT_HTTP_PORT}
+		vs = test.NewVirtualServer(
+			"SampleVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host: "test.com",
+				Pools: []cisapiv1.Pool{
+					{
+						Path:        "/foo",
+						Service:     "svc1",
+						ServicePort: intstr.FromInt(80),
+					},
+				},
+			},
+		)
+	})
+
+	Context("Route Domain", func() {
+		It("decorates addresses with route domain", func() {
+			type testDataType struct {
+				address  string
+				rd       int32
+				expected string
+			}
+			testData := []testDataType{
+				{address: "", rd: 3, expected: ""},
+				{address: "1.2.3.4", rd: 0, expected: "1.2.3.4"},
+				{address: "1.2.3.4", rd: 3, expected: "1.2.3.4%3"},
+				{address: "1.2.3.4%0", rd: 3, expected: "1.2.3.4%0"},
+				{address: "1.2.3.4%5", rd: 3, expected: "1.2.3.4%5"},
+				{address: "2001:db8::1", rd: 0, expected: "2001:db8::1"},
+				{address: "2001:db8::1", rd: 3, expected: "2001:db8::1%3"},
+				{address: "2001:db8::1%5", rd: 3, expected: "2001:db8::1%5"},
+			}
+			for _, td := range testData {
+				Expect(formatRouteDomainAddress(td.address, td.rd)).To(
+					Equal(td.expected), "Address: %s RD: %d", td.address, td.rd)
+			}
+		})
+
+		It("applies route domain to virtual and members", func() {
+			members := []Member{
+				{Address: "10.1.1.1", Port: 8080},
+				{Address: "10.1.1.2", Port: 8080},
+				{Address: "2001:db8::10", Port: 8080},
+			}
+			type testDataType struct {
+				address         string
+				defaultRD       int32
+				expectedDest    string
+				expectedMembers []string
+			}
+			testData := []testDataType{
+				{
+					address:      "1.2.3.4",
+					defaultRD:    0,
+					expectedDest: "/test/1.2.3.4:80",
+					expectedMembers: []string{"10.1.1.1", "10.1.1.2",
+						"2001:db8::10"},
+				},
+				{
+					address:      "1.2.3.4",
+					defaultRD:    3,
+					expectedDest: "/test/1.2.3.4%3:80",
+					expectedMembers: []string{"10.1.1.1%3", "10.1.1.2%3",
+						"2001:db8::10%3"},
+				},
+				{
+					// Route domain on the VirtualServer overrides the default
+					address:      "1.2.3.4%5",
+					defaultRD:    3,
+					expectedDest: "/test/1.2.3.4%5:80",
+					expectedMembers: []string{"10.1.1.1%5", "10.1.1.2%5",
+						"2001:db8::10%5"},
+				},
+				{
+					address:      "1.2.3.4%0",
+					defaultRD:    3,
+					expectedDest: "/test/1.2.3.4%0:80",
+					expectedMembers: []string{"10.1.1.1", "10.1.1.2",
+						"2001:db8::10"},
+				},
+				{
+					address:      "2001:db8::5",
+					defaultRD:    3,
+					expectedDest: "/test/2001:db8::5%3.80",
+					expectedMembers: []string{"10.1.1.1%3", "10.1.1.2%3",
+						"2001:db8::10%3"},
+				},
+				{
+					address:      "[2001:db8::5]",
+					defaultRD:    3,
+					expectedDest: "/test/2001:db8::5%3.80",
+					expectedMembers: []string{"10.1.1.1%3", "10.1.1.2%3",
+						"2001:db8::10%3"},
+				},
+				{
+					address:      "[2001:db8::5%5]",
+					defaultRD:    3,
+					expectedDest: "/test/2001:db8::5%5.80",
+					expectedMembers: []string{"10.1.1.1%5", "10.1.1.2%5",
+						"2001:db8::10%5"},
+				},
+				{
+					address:      "[2001:db8::5%0]",
+					defaultRD:    3,
+					expectedDest: "/test/2001:db8::5%0.80",
+					expectedMembers: []string{"10.1.1.1", "10.1.1.2",
+						"2001:db8::10"},
+				},
+			}
+			for _, td := range testData {
+				mockCRM.DefaultRouteDomain = td.defaultRD
+				vs.Spec.VirtualServerAddress = td.address
+				rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+				Expect(err).To(BeNil())
+				Expect(rsCfg).NotTo(BeNil())
+				Expect(rsCfg.Virtual.Destination).To(Equal(td.expectedDest))
+
+				rsCfg.Pools[0].Members = make([]Member, len(members))
+				copy(rsCfg.Pools[0].Members, members)
+				rsCfg.updatePoolMembersRouteDomain()
+				var addrs []string
+				for _, mem := range rsCfg.Pools[0].Members {
+					addrs = append(addrs, mem.Address)
+				}
+				Expect(addrs).To(Equal(td.expectedMembers),
+					"Address: %s RD: %d", td.address, td.defaultRD)
+			}
+		})
+
+		It("parses namespace route domains", func() {
+			rds, err := ParseNamespaceRouteDomains(
+				[]string{"default=3", "other= 0"})
+			Expect(err).To(BeNil())
+			Expect(rds).To(Equal(map[string]int32{"default": 3, "other": 0}))
+
+			for _, mapping := range []string{
+				"default", "=3", "default=", "default=rd", "default=-1",
+				"default=65535",
+			} {
+				_, err = ParseNamespaceRouteDomains([]string{mapping})
+				Expect(err).NotTo(BeNil(), "Mapping: %s", mapping)
+			}
+		})
+
+		It("applies route domain of the namespace", func() {
+			mockCRM.DefaultRouteDomain = 3
+			mockCRM.NamespaceRouteDomains = map[string]int32{"default": 7}
+			members := []Member{
+				{Address: "10.1.1.1", Port: 8080},
+				{Address: "2001:db8::10", Port: 8080},
+			}
+			type testDataType struct {
+				address         string
+				namespace       string
+				expectedDest    string
+				expectedMembers []string
+			}
+			testData := []testDataType{
+				{
+					address:         "1.2.3.4",
+					namespace:       "default",
+					expectedDest:    "/test/1.2.3.4%7:80",
+					expectedMembers: []string{"10.1.1.1%7", "2001:db8::10%7"},
+				},
+				{
+					address:         "2001:db8::5",
+					namespace:       "default",
+					expectedDest:    "/test/2001:db8::5%7.80",
+					expectedMembers: []string{"10.1.1.1%7", "2001:db8::10%7"},
+				},
+				{
+					address:         "2001:db8::5",
+					namespace:       "other",
+					expectedDest:    "/test/2001:db8::5%3.80",
+					expectedMembers: []string{"10.1.1.1%3", "2001:db8::10%3"},
+				},
+				{
+					// Route domain on the VirtualServer overrides the namespace
+					address:         "2001:db8::5%5",
+					namespace:       "default",
+					expectedDest:    "/test/2001:db8::5%5.80",
+					expectedMembers: []string{"10.1.1.1%5", "2001:db8::10%5"},
+				},
+			}
+			for _, td := range testData {
+				vs.ObjectMeta.Namespace = td.namespace
+				vs.Spec.VirtualServerAddress = td.address
+				rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+				Expect(err).To(BeNil())
+				Expect(rsCfg.Virtual.Destination).To(Equal(td.expectedDest))
+
+				rsCfg.Pools[0].Members = make([]Member, len(members))
+				copy(rsCfg.Pools[0].Members, members)
+				rsCfg.updatePoolMembersRouteDomain()
+				var addrs []string
+				for _, mem := range rsCfg.Pools[0].Members {
+					addrs = append(addrs, mem.Address)
+				}
+				Expect(addrs).To(Equal(td.expectedMembers),
+					"Address: %s Namespace: %s", td.address, td.namespace)
+			}
+		})
+
+		It("rejects static members in another route domain", func() {
+			mockCRM.DefaultRouteDomain = 3
+			type testDataType struct {
+				address string
+				member  string
+				valid   bool
+			}
+			testData := []testDataType{
+				{address: "1.2.3.4", member: "10.1.1.1", valid: true},
+				{address: "1.2.3.4", member: "10.1.1.1%3", valid: true},
+				{address: "1.2.3.4", member: "10.1.1.1%4", valid: false},
+				{address: "1.2.3.4%0", member: "10.1.1.1%0", valid: true},
+				{address: "1.2.3.4%0", member: "10.1.1.1%3", valid: false},
+				{address: "2001:db8::5%5", member: "2001:db8::10%5", valid: true},
+				{address: "2001:db8::5%5", member: "2001:db8::10", valid: true},
+				{address: "2001:db8::5", member: "2001:db8::10%5", valid: false},
+			}
+			for _, td := range testData {
+				vs.Spec.VirtualServerAddress = td.address
+				vs.Spec.Pools[0].StaticMembers = []cisapiv1.StaticMember{
+					{Address: td.member, Port: 8080},
+				}
+				rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+				if td.valid {
+					Expect(err).To(BeNil(),
+						"Address: %s Member: %s", td.address, td.member)
+					rsCfg.updatePoolMembersRouteDomain()
+					Expect(rsCfg.Pools[0].Members[0].Address).To(
+						HavePrefix(td.member))
+				} else {
+					Expect(err).NotTo(BeNil(),
+						"Address: %s Member: %s", td.address, td.member)
+					Expect(rsCfg).To(BeNil())
+				}
+			}
+		})
+
+		It("applies route domain to monitor targets and SNAT addresses", func() {
+			mockCRM.DefaultRouteDomain = 3
+			type testDataType struct {
+				address        string
+				target         string
+				snat           string
+				expectedTarget string
+				expectedSNAT   string
+				valid          bool
+			}
+			testData := []testDataType{
+				{
+					address:        "1.2.3.4",
+					target:         "10.1.1.10",
+					snat:           "10.1.2.1",
+					expectedTarget: "10.1.1.10%3",
+					expectedSNAT:   "10.1.2.1%3",
+					valid:          true,
+				},
+				{
+					address:        "1.2.3.4%5",
+					target:         "10.1.1.10%5",
+					snat:           "10.1.2.1",
+					expectedTarget: "10.1.1.10%5",
+					expectedSNAT:   "10.1.2.1%5",
+					valid:          true,
+				},
+				{
+					address:        "[2001:db8::5]",
+					target:         "[2001:DB8::10]",
+					snat:           "2001:db8::20",
+					expectedTarget: "2001:db8::10%3",
+					expectedSNAT:   "2001:db8::20%3",
+					valid:          true,
+				},
+				{
+					address:        "2001:db8::5%0",
+					target:         "[2001:db8::10%0]",
+					snat:           "2001:db8::20",
+					expectedTarget: "2001:db8::10%0",
+					expectedSNAT:   "2001:db8::20",
+					valid:          true,
+				},
+				{address: "1.2.3.4", target: "10.1.1.10%4", valid: false},
+				{address: "1.2.3.4", snat: "10.1.2.1%4", valid: false},
+				{address: "2001:db8::5%5", target: "2001:db8::10%3",
+					valid: false},
+				{address: "2001:db8::5%5", snat: "[2001:db8::20%3]",
+					valid: false},
+			}
+			for _, td := range testData {
+				vs.Spec.VirtualServerAddress = td.address
+				vs.Spec.Pools[0].Monitor = &cisapiv1.Monitor{Type: MonitorTCP,
+					TargetAddress: td.target, TargetPort: 8080}
+				vs.Spec.SNATAddresses = nil
+				if td.snat != "" {
+					vs.Spec.SNATAddresses = []string{td.snat}
+				}
+				rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+				if !td.valid {
+					Expect(err).NotTo(BeNil(), "Address: %s Target: %s SNAT: %s",
+						td.address, td.target, td.snat)
+					Expect(rsCfg).To(BeNil())
+					continue
+				}
+				Expect(err).To(BeNil(), "Address: %s", td.address)
+				Expect(rsCfg.Pools[0].Monitor.TargetAddress).To(
+					Equal(td.expectedTarget), "Address: %s", td.address)
+				Expect(rsCfg.Pools[0].Monitor.TargetPort).To(
+					BeEquivalentTo(8080))
+				Expect(rsCfg.Virtual.SourceAddrTranslation).To(Equal(
+					SourceAddrTranslation{Type: SNATPool,
+						Addresses: []string{td.expectedSNAT}}),
+					"Address: %s", td.address)
+			}
+		})
+	})
+
+	Context("Virtual Address", func() {
+		It("Validates VirtualServer address", func() {
+			for _, address := range []string{
+				"1.2.3.4", "1.2.3.4%2", "2001:db8::5", "2001:db8::5%3",
+			} {
+				Expect(validateVirtualServerAddress(address)).To(BeNil(),
+					"Address: %s", address)
+			}
+			for _, address := range []string{
+				"", "1.2.3", "example.com", "1.2.3.4%", "%2", "[1.2.3.4]",
+				"fe80::1%eth0", "[2001:db8::5", "2001:db8::5]",
+			} {
+				Expect(validateVirtualServerAddress(address)).NotTo(BeNil(),
+					"Address: %s", address)
+			}
+		})
+
+		It("Normalizes addresses", func() {
+			for address, expected := range map[string]string{
+				"1.2.3.4":          "1.2.3.4",
+				" 1.2.3.4%2 ":      "1.2.3.4%2",
+				"2001:DB8::5":      "2001:db8::5",
+				"2001:0db8:0:0::5": "2001:db8::5",
+				"[2001:db8::5]":    "2001:db8::5",
+				"[2001:db8::5]%3":  "2001:db8::5%3",
+				"[2001:db8::5%3]":  "2001:db8::5%3",
+				"2001:db8::5%03":   "2001:db8::5%3",
+				"::ffff:1.2.3.4":   "1.2.3.4",
+				"fe80::1%eth0":     "fe80::1%eth0",
+				"[1.2.3.4]":        "[1.2.3.4]",
+				"example.com":      "example.com",
+			} {
+				Expect(normalizeAddress(address)).To(Equal(expected),
+					"Address: %s", address)
+			}
+		})
+
+		It("Names the forms of an address alike", func() {
+			for _, forms := range [][]string{
+				{"1.2.3.4", " 1.2.3.4", "::ffff:1.2.3.4"},
+				{"2001:db8::5", "2001:DB8::5", "[2001:db8::5]",
+					"2001:0db8:0000::5"},
+				{"2001:db8::5%3", "[2001:db8::5]%3", "[2001:DB8::5%3]",
+					"2001:db8:0::5%03"},
+			} {
+				var names, dests []string
+				for _, address := range forms {
+					vs.Spec.VirtualServerAddress = address
+					Expect(validateVirtualServerAddress(address)).To(BeNil(),
+						"Address: %s", address)
+					rsCfg, err := mockCRM.createRSConfigFromVirtualServer(
+						vs, httpPort)
+					Expect(err).To(BeNil(), "Address: %s", address)
+					Expect(rsCfg.Virtual.Name).To(Equal(
+						mockCRM.getVirtualServerName(vs, httpPort.port)))
+					names = append(names, rsCfg.Virtual.Name)
+					dests = append(dests, rsCfg.Virtual.Destination)
+				}
+				for i := range forms {
+					Expect(names[i]).To(Equal(names[0]),
+						"%s and %s should be named alike", forms[i], forms[0])
+					Expect(dests[i]).To(Equal(dests[0]))
+				}
+			}
+			vs.Spec.VirtualServerAddress = "[2001:db8::5]%3"
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.Name).To(Equal(
+				formatVirtualServerName("2001:db8::5%3", 80)))
+			Expect(rsCfg.Virtual.Destination).To(Equal("/test/2001:db8::5%3.80"))
+		})
+
+		It("Declares IPv4 and IPv6 virtual addresses", func() {
+			for address, expected := range map[string]string{
+				"1.2.3.4":         "1.2.3.4",
+				"1.2.3.4%2":       "1.2.3.4%2",
+				"2001:db8::5":     "2001:db8::5",
+				"[2001:db8::5]%3": "2001:db8::5%3",
+			} {
+				vs.Spec.VirtualServerAddress = address
+				rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+				Expect(err).To(BeNil())
+				sharedApp := as3Application{}
+				createServiceDecl(rsCfg, sharedApp)
+				svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+				Expect(svc.VirtualAddresses).To(Equal(
+					[]as3MultiTypeParam{expected}),
+					"Address: %s", address)
+				Expect(svc.VirtualPort).To(Equal(80))
+			}
+		})
+
+		It("Declares the ICMP echo and ARP settings of virtual addresses", func() {
+			arp := false
+			vs.Spec.VirtualServerAddress = "1.2.3.4%2"
+			vs.Spec.ICMPEcho = ICMPEchoDisable
+			vs.Spec.ARP = &arp
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.VirtualAddress).To(Equal(&virtualAddress{
+				BindAddr: "1.2.3.4%2", Port: 80, ICMPEcho: ICMPEchoDisable,
+				ARPDisabled: true}))
+
+			sharedApp := as3Application{}
+			processVirtualAddressesForAS3(ResourceConfigs{rsCfg}, sharedApp)
+			createServiceDecl(rsCfg, sharedApp)
+			Expect(sharedApp["va_1_2_3_4.2"]).To(Equal(&as3ServiceAddress{
+				Class: "Service_Address", VirtualAddress: "1.2.3.4%2",
+				ARPEnabled: false, ICMPEcho: ICMPEchoDisable}))
+			svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.VirtualAddresses).To(Equal([]as3MultiTypeParam{
+				&as3ResourcePointer{Use: "va_1_2_3_4.2"}}))
+
+			// The defaults of BIG-IP need no Service_Address
+			vs.Spec.ICMPEcho = ICMPEchoEnable
+			vs.Spec.ARP = nil
+			rsCfg, err = mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			sharedApp = as3Application{}
+			processVirtualAddressesForAS3(ResourceConfigs{rsCfg}, sharedApp)
+			createServiceDecl(rsCfg, sharedApp)
+			Expect(sharedApp).NotTo(HaveKey("va_1_2_3_4.2"))
+			svc = sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.VirtualAddresses).To(Equal(
+				[]as3MultiTypeParam{"1.2.3.4%2"}))
+		})
+
+		It("Applies the default ICMP echo and ARP settings", func() {
+			mockCRM.DefaultICMPEcho = ICMPEchoSelective
+			mockCRM.DisableARP = true
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.VirtualAddress.ICMPEcho).To(
+				Equal(ICMPEchoSelective))
+			Expect(rsCfg.Virtual.VirtualAddress.ARPDisabled).To(BeTrue())
+
+			arp := true
+			vs.Spec.ARP = &arp
+			vs.Spec.ICMPEcho = ICMPEchoEnable
+			rsCfg, err = mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.VirtualAddress.ICMPEcho).To(BeEmpty())
+			Expect(rsCfg.Virtual.VirtualAddress.ARPDisabled).To(BeFalse())
+		})
+
+		It("Uses the most restrictive settings of a shared address", func() {
+			httpCfg := &ResourceConfig{}
+			httpCfg.Virtual.Name = "http"
+			httpCfg.Virtual.SetVirtualAddress("2001:db8::5%3", 80)
+			httpCfg.Virtual.VirtualAddress.ICMPEcho = ICMPEchoSelective
+			httpsCfg := &ResourceConfig{}
+			httpsCfg.Virtual.Name = "https"
+			httpsCfg.Virtual.SetVirtualAddress("2001:db8::5%3", 443)
+			httpsCfg.Virtual.VirtualAddress.ARPDisabled = true
+			otherCfg := &ResourceConfig{}
+			otherCfg.Virtual.Name = "other"
+			otherCfg.Virtual.SetVirtualAddress("2001:db8::6", 80)
+
+			sharedApp := as3Application{}
+			processVirtualAddressesForAS3(
+				ResourceConfigs{httpCfg, httpsCfg, otherCfg}, sharedApp)
+			Expect(sharedApp).To(Equal(as3Application{
+				"va_2001_db8__5.3": &as3ServiceAddress{
+					Class: "Service_Address", VirtualAddress: "2001:db8::5%3",
+					ARPEnabled: false, ICMPEcho: ICMPEchoSelective},
+			}))
+		})
+
+		It("Rejects invalid ICMP echo", func() {
+			Expect(ValidateICMPEcho("")).To(Succeed())
+			Expect(ValidateICMPEcho(ICMPEchoSelective)).To(Succeed())
+			Expect(ValidateICMPEcho("on")).NotTo(Succeed())
+		})
+
+		It("Rejects IPv6 zone IDs", func() {
+			err := validateVirtualServerAddress("fe80::1%eth0")
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("zone IDs are not supported"))
+		})
+
+		It("Does not create resource config without valid address", func() {
+			vs.Spec.VirtualServerAddress = ""
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).NotTo(BeNil())
+			Expect(rsCfg).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+
+			vs.Spec.VirtualServerAddress = "1.2.3.4.5"
+			rsCfg, err = mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).NotTo(BeNil())
+			Expect(rsCfg).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		})
+	})
+
+	Context("Profiles", func() {
+		It("Adds and updates profiles", func() {
+			v := &Virtual{}
+			Expect(v.AddOrUpdateProfile(ProfileRef{Name: "tcp-wan",
+				Partition: "Common", Context: CustomProfileClient})).To(BeTrue())
+			Expect(v.AddOrUpdateProfile(ProfileRef{Name: "http-xff",
+				Partition: "Common", Context: CustomProfileAll})).To(BeTrue())
+			Expect(v.AddOrUpdateProfile(ProfileRef{Name: "tcp-wan",
+				Partition: "Common", Context: CustomProfileClient})).To(BeFalse(),
+				"Unchanged profile should not be updated")
+			Expect(v.Profiles).To(Equal(ProfileRefs{
+				{Name: "http-xff", Partition: "Common", Context: CustomProfileAll},
+				{Name: "tcp-wan", Partition: "Common", Context: CustomProfileClient},
+			}))
+
+			Expect(v.AddOrUpdateProfile(ProfileRef{Name: "tcp-wan",
+				Partition: "Common", Context: CustomProfileServer})).To(BeTrue())
+			Expect(v.Profiles).To(Equal(ProfileRefs{
+				{Name: "http-xff", Partition: "Common", Context: CustomProfileAll},
+				{Name: "tcp-wan", Partition: "Common", Context: CustomProfileServer},
+			}), "Profile with updated context should be replaced")
+		})
+
+		It("Attaches HTTP and TCP profiles of VirtualServer", func() {
+			oldPartition := DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			defer func() { DEFAULT_PARTITION = oldPartition }()
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Profiles = cisapiv1.ProfileSpec{
+				HTTP: "/Common/http-xff",
+				TCP: cisapiv1.ProfileTCP{
+					Client: "/Common/tcp-wan",
+					Server: "tcp-lan",
+				},
+			}
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.Profiles).To(Equal(ProfileRefs{
+				{Name: "http-xff", Partition: "Common", Context: CustomProfileAll,
+					Namespace: "default", Type: ProfileTypeHTTP},
+				{Name: "tcp-wan", Partition: "Common", Context: CustomProfileClient,
+					Namespace: "default", Type: ProfileTypeTCP},
+				{Name: "tcp-lan", Partition: "test", Context: CustomProfileServer,
+					Namespace: "default", Type: ProfileTypeTCP},
+			}))
+
+			svc := &as3Service{}
+			processHTTPAndTCPProfilesForAS3(&rsCfg.Virtual, svc)
+			Expect(svc.ProfileHTTP).To(Equal(
+				&as3ResourcePointer{BigIP: "/Common/http-xff"}))
+			Expect(svc.ProfileTCP).To(Equal(&as3ProfileTCP{
+				Ingress: &as3ResourcePointer{BigIP: "/Common/tcp-wan"},
+				Egress:  &as3ResourcePointer{BigIP: "/test/tcp-lan"},
+			}))
+			Expect(svc.ServerTLS).To(BeNil())
+
+			vs.Spec.Profiles = cisapiv1.ProfileSpec{
+				TCP: cisapiv1.ProfileTCP{
+					Client: "/Common/tcp-wan",
+					Server: "/Common/tcp-wan",
+				},
+			}
+			rsCfg, err = mockCRM.createRSConfigFromVirtualServer(vs, httpPort)
+			Expect(err).To(BeNil())
+			Expect(rsCfg.Virtual.Profiles).To(Equal(ProfileRefs{
+				{Name: "tcp-wan", Partition: "Common", Context: CustomProfileAll,
+					Namespace: "default", Type: ProfileTypeTCP},
+			}), "Removed profiles should be detached")
+		})
+	})
+
+	Context("Config diff", func() {
+		var oldCfg, rsCfg *ResourceConfig
+
+		BeforeEach(func() {
+			oldCfg = &ResourceConfig{}
+			oldCfg.MetaData.Active = true
+			oldCfg.Virtual.Name = "crd_1_2_3_4_80"
+			oldCfg.Virtual.Enabled = true
+			oldCfg.Virtual.Policies = []nameRef{{Name: "policy", Partition: "test"}}
+			oldCfg.Pools = Pools{
+				{Name: "default_svc1_80", Partition: "test", ServiceName: "svc1",
+					Members: []Member{{Address: "10.1.0.1", Port: 8080}}},
+				{Name: "default_svc2_80", Partition: "test", ServiceName: "svc2"},
+			}
+			oldCfg.Policies = Policies{{Name: "policy", Partition: "test",
+				Rules: Rules{&Rule{Name: "rule", Actions: []*action{
+					{Forward: true, Pool: "default_svc1_80"}}}}}}
+			rsCfg = &ResourceConfig{}
+			oldCfg.DeepCopyInto(rsCfg)
+		})
+
+		It("Finds no changes in the same config", func() {
+			Expect(rsCfg.Diff(oldCfg).IsEmpty()).To(BeTrue())
+			rsCfg.Pools[1].Members = nil
+			Expect(rsCfg.Diff(oldCfg).IsEmpty()).To(BeTrue(),
+				"nil and empty members should be the same")
+		})
+
+		It("Finds the pools with only their members changed", func() {
+			rsCfg.Pools[0].Members = append(rsCfg.Pools[0].Members,
+				Member{Address: "10.1.0.2", Port: 8080})
+			diff := rsCfg.Diff(oldCfg)
+			Expect(diff).To(Equal(ResourceConfigDiff{
+				PoolMembers: []string{"default_svc1_80"}}))
+			Expect(diff.MembersOnly()).To(BeTrue())
+		})
+
+		It("Finds the pools changed, added and deleted", func() {
+			rsCfg.Pools[0].MonitorNames = []string{"/test/http"}
+			rsCfg.Pools[1].Name = "default_svc2_8080"
+			rsCfg.Pools[1].Members = []Member{{Address: "10.1.0.3", Port: 80}}
+			diff := rsCfg.Diff(oldCfg)
+			Expect(diff).To(Equal(ResourceConfigDiff{Pools: []string{
+				"default_svc1_80", "default_svc2_80", "default_svc2_8080"}}))
+			Expect(diff.MembersOnly()).To(BeFalse())
+		})
+
+		It("Finds the virtual, profiles and policies changed", func() {
+			rsCfg.Virtual.Profiles = ProfileRefs{{Name: "http-xff",
+				Partition: "Common", Context: CustomProfileAll}}
+			Expect(rsCfg.Diff(oldCfg)).To(Equal(
+				ResourceConfigDiff{Profiles: true}))
+
+			oldCfg.DeepCopyInto(rsCfg)
+			rsCfg.Policies[0].Rules[0].Actions[0].Pool = "default_svc2_80"
+			Expect(rsCfg.Diff(oldCfg)).To(Equal(
+				ResourceConfigDiff{Policies: true}))
+
+			oldCfg.DeepCopyInto(rsCfg)
+			rsCfg.Virtual.ConnectionLimit = 100
+			Expect(rsCfg.Diff(oldCfg)).To(Equal(
+				ResourceConfigDiff{Virtual: true}))
+
+			oldCfg.DeepCopyInto(rsCfg)
+			rsCfg.MetaData.Active = false
+			Expect(rsCfg.Diff(oldCfg)).To(Equal(
+				ResourceConfigDiff{Virtual: true}))
+		})
+
+		It("Finds the settings of the virtual address changed", func() {
+			oldCfg.Virtual.SetVirtualAddress("1.2.3.4", 80)
+			oldCfg.DeepCopyInto(rsCfg)
+			Expect(rsCfg.Diff(oldCfg).IsEmpty()).To(BeTrue())
+			rsCfg.Virtual.VirtualAddress.ARPDisabled = true
+			Expect(rsCfg.Diff(oldCfg)).To(Equal(
+				ResourceConfigDiff{Virtual: true}))
+			Expect(oldCfg.Virtual.VirtualAddress.ARPDisabled).To(BeFalse())
+		})
+
+		It("Finds all the sections of a new config", func() {
+			Expect(rsCfg.Diff(nil)).To(Equal(ResourceConfigDiff{
+				Virtual:  true,
+				Policies: true,
+				Pools:    []string{"default_svc1_80", "default_svc2_80"},
+			}))
+		})
+
+		It("Updates only the members of the old config", func() {
+			rs := NewResources()
+			ref := func(name string) NameRef {
+				return NameRef{Name: name, Partition: "test"}
+			}
+			rs.rsMap[ref("crd_1_2_3_4_80")] = rsCfg
+			rs.rsMap[ref("crd_1_2_3_5_80")] = &ResourceConfig{}
+			rs.oldRsMap[ref("crd_1_2_3_4_80")] = oldCfg
+			rs.oldRsMap[ref("crd_1_2_3_6_80")] = &ResourceConfig{}
+
+			rsCfg.Pools[0].Members = []Member{{Address: "10.1.0.2", Port: 8080}}
+			diffs := rs.getConfigDiffs()
+			Expect(diffs).To(HaveLen(3))
+			Expect(diffs[ref("crd_1_2_3_4_80")].MembersOnly()).To(BeTrue())
+			Expect(diffs[ref("crd_1_2_3_6_80")].Virtual).To(BeTrue(),
+				"Deleted config should be changed")
+
+			rs.updateOldConfig()
+			Expect(rs.getConfigDiffs()).To(BeEmpty())
+			Expect(rs.oldRsMap[ref("crd_1_2_3_4_80")]).To(BeIdenticalTo(oldCfg),
+				"Old config with members changed should be updated in place")
+			Expect(oldCfg.Pools[0].Members).To(Equal(rsCfg.Pools[0].Members))
+			Expect(rs.oldRsMap).NotTo(HaveKey(ref("crd_1_2_3_6_80")))
+		})
+
+		It("Keeps the configs of the same name in different partitions", func() {
+			rs := NewResources()
+			rsCfg.Virtual.Partition = "test"
+			oldCfg.Virtual.Partition = "tenant1"
+			rs.setResourceConfig(rsCfg)
+			rs.setResourceConfig(oldCfg)
+			Expect(rs.GetAllResources()).To(Equal(ResourceConfigs{oldCfg, rsCfg}))
+			cfg, ok := rs.GetByName("test", "crd_1_2_3_4_80")
+			Expect(ok).To(BeTrue())
+			Expect(cfg).To(BeIdenticalTo(rsCfg))
+
+			rs.deleteVirtualServer(oldCfg.GetRef())
+			_, ok = rs.GetByName("tenant1", "crd_1_2_3_4_80")
+			Expect(ok).To(BeFalse())
+			Expect(rs.GetAllResources()).To(Equal(ResourceConfigs{rsCfg}))
+		})
+
+		It("Copies every field of the pools", func() {
+			var pool, expected Pool
+			fillValue(reflect.ValueOf(&pool).Elem(), 1)
+			fillValue(reflect.ValueOf(&expected).Elem(), 1)
+			oldCfg.Pools = Pools{pool}
+			oldCfg.DeepCopyInto(rsCfg)
+			Expect(rsCfg.Pools[0]).To(Equal(expected))
+
+			// Changing the old pool in place does not change the copy
+			fillValue(reflect.ValueOf(&oldCfg.Pools[0]).Elem(), 2)
+			Expect(rsCfg.Pools[0]).To(Equal(expected))
+			Expect(rsCfg.Diff(oldCfg).Pools).To(Equal([]string{"v1", "v2"}))
+		})
+	})
+
+	Context("Policy rules", func() {
+		var rsCfg *ResourceConfig
+		var rules Rules
+
+		BeforeEach(func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+						{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+					}})
+			rules = *processVirtualServerRules(vs)
+			rsCfg = &ResourceConfig{}
+			rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+			// The policy rules are removed in place
+			rsCfg.SetPolicy(*createPolicy(append(Rules{}, rules...),
+				"policy", "test"))
+		})
+
+		It("Finds the policy of the config", func() {
+			policy := rsCfg.FindPolicy("forwarding")
+			Expect(policy).To(BeIdenticalTo(&rsCfg.Policies[0]))
+			Expect(rsCfg.FindPolicy("asm")).To(BeNil())
+		})
+
+		It("Deletes a rule from the policy of the config", func() {
+			rsCfg.DeleteRuleFromPolicy("policy", rules[0],
+				map[NameRef]map[string]mergedRuleEntry{})
+			Expect(rsCfg.Policies[0].Rules).To(Equal(Rules{rules[1]}))
+			Expect(rsCfg.Policies[0].Rules[0].Ordinal).To(Equal(0))
+		})
+
+		It("Deletes the policy with its last rule", func() {
+			for _, rl := range rules {
+				rsCfg.DeleteRuleFromPolicy("policy", rl,
+					map[NameRef]map[string]mergedRuleEntry{})
+			}
+			Expect(rsCfg.Policies).To(BeEmpty())
+			Expect(rsCfg.Virtual.Policies).To(BeEmpty())
+		})
+	})
+
+	Context("Stable declaration", func() {
+		// buildConfig builds the same configuration from the configs,
+		// members, rules and data group namespaces in shuffled order.
+		buildConfig := func(seed int64) ResourceConfigWrapper {
+			rnd := rand.New(rand.NewSource(seed))
+			rs := NewResources()
+			var cfgs []*ResourceConfig
+			for i := 0; i < 5; i++ {
+				vs := test.NewVirtualServer(fmt.Sprintf("vs%d", i), "default",
+					cisapiv1.VirtualServerSpec{Host: "test.com",
+						Pools: []cisapiv1.Pool{
+							{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+							{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+							{Path: "/", Service: "svc3", ServicePort: intstr.FromInt(80)},
+						}})
+				rules := *processVirtualServerRules(vs)
+				rnd.Shuffle(len(rules), func(i, j int) {
+					rules[i], rules[j] = rules[j], rules[i]
+				})
+				rsCfg := &ResourceConfig{}
+				rsCfg.Virtual.Name = fmt.Sprintf("crd_1_2_3_%d_80", i)
+				rsCfg.Virtual.Destination = fmt.Sprintf("/test/1.2.3.%d:80", i)
+				rsCfg.MetaData.Active = true
+				rsCfg.MetaData.ResourceType = VirtualServer
+				rsCfg.SetPolicy(*createPolicy(rules, "policy", "test"))
+				members := []Member{
+					{Address: "10.1.0.2", Port: 8080},
+					{Address: "10.1.0.1", Port: 8081},
+					{Address: "10.1.0.1", Port: 8080},
+				}
+				rnd.Shuffle(len(members), func(i, j int) {
+					members[i], members[j] = members[j], members[i]
+				})
+				rsCfg.Pools = Pools{{Name: fmt.Sprintf("pool%d", i),
+					Members: members}}
+				cfgs = append(cfgs, rsCfg)
+			}
+			rnd.Shuffle(len(cfgs), func(i, j int) {
+				cfgs[i], cfgs[j] = cfgs[j], cfgs[i]
+			})
+			for _, rsCfg := range cfgs {
+				rs.setResourceConfig(rsCfg)
+			}
+
+			// The namespaces have conflicting records
+			dgNamespaces := make(DataGroupNamespaceMap)
+			for _, namespace := range rnd.Perm(3) {
+				dg := &InternalDataGroup{Name: "dg", Partition: "test"}
+				dg.AddOrUpdateRecord("test.com", fmt.Sprintf("ns%d", namespace))
+				dgNamespaces[fmt.Sprintf("ns%d", namespace)] = dg
+			}
+			return ResourceConfigWrapper{
+				rsCfgs:         rs.GetAllResources(),
+				iRuleMap:       make(IRulesMap),
+				intDgMap:       InternalDataGroupMap{{Name: "dg"}: dgNamespaces},
+				customProfiles: NewCustomProfiles(),
+			}
+		}
+
+		It("Serializes the same configuration identically", func() {
+			config := buildConfig(0)
+			decl := createAS3Declaration(config)
+			members := config.rsCfgs.GetAllPoolMembers(nil)
+			for seed := int64(1); seed < 20; seed++ {
+				other := buildConfig(seed)
+				Expect(string(createAS3Declaration(other))).To(
+					Equal(string(decl)), "Seed %d", seed)
+				Expect(other.rsCfgs.GetAllPoolMembers(nil)).To(Equal(members))
+			}
+			Expect(members[0]).To(Equal(Member{Address: "10.1.0.1", Port: 8080}))
+		})
+	})
+
+	Context("Concurrent access", func() {
+		It("Adds, gets and deletes configs concurrently", func() {
+			rs := NewResources()
+			newConfig := func(i int) *ResourceConfig {
+				cfg := &ResourceConfig{}
+				cfg.Virtual.Name = fmt.Sprintf("crd_1_2_3_%d_80", i%10)
+				cfg.Virtual.Partition = "test"
+				cfg.MetaData.ResourceType = VirtualServer
+				cfg.MetaData.addOwner(fmt.Sprintf("default/vs%d", i%3))
+				cfg.Pools = Pools{{Name: "default_svc1_80",
+					Members: []Member{{Address: "10.1.0.1", Port: 8080}}}}
+				return cfg
+			}
+			var wg sync.WaitGroup
+			run := func(f func(i int)) {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < 500; i++ {
+						f(i)
+					}
+				}()
+			}
+			run(func(i int) { rs.setResourceConfig(newConfig(i)) })
+			run(func(i int) { rs.setResourceConfig(newConfig(i + 5)) })
+			run(func(i int) {
+				name := fmt.Sprintf("crd_1_2_3_%d_80", i%10)
+				if cfg, ok := rs.GetByName("test", name); ok {
+					Expect(cfg).NotTo(BeNil())
+				}
+			})
+			run(func(i int) {
+				for _, cfg := range rs.GetAllResources() {
+					Expect(cfg.Virtual.Name).To(HavePrefix("crd_"))
+				}
+			})
+			run(func(i int) { rs.getVirtuals(VirtualServer, "default/vs1") })
+			run(func(i int) {
+				rs.removeOwner(NameRef{Name: fmt.Sprintf("crd_1_2_3_%d_80", i%10),
+					Partition: "test"},
+					fmt.Sprintf("default/vs%d", i%3))
+			})
+			run(func(i int) {
+				rs.deleteVirtualServer(NameRef{
+					Name: fmt.Sprintf("crd_1_2_3_%d_80", i%10), Partition: "test"})
+			})
+			run(func(i int) { rs.getConfigDiffs() })
+			run(func(i int) { rs.updateOldConfig() })
+			run(func(i int) {
+				if i%100 == 0 {
+					rs.resetOldConfig()
+				}
+			})
+			wg.Wait()
+
+			rs.updateOldConfig()
+			Expect(rs.getConfigDiffs()).To(BeEmpty())
+		})
+	})
+
+	Context("Deep copy", func() {
+		var rsCfg, expected ResourceConfig
+
+		BeforeEach(func() {
+			fillValue(reflect.ValueOf(&rsCfg).Elem(), 1)
+			fillValue(reflect.ValueOf(&expected).Elem(), 1)
+		})
+
+		It("Copies every field of the config", func() {
+			Expect(*rsCfg.DeepCopy()).To(Equal(expected))
+			var copied ResourceConfig
+			rsCfg.DeepCopyInto(&copied)
+			Expect(copied).To(Equal(expected))
+		})
+
+		It("Keeps the config when the copy changes", func() {
+			copied := rsCfg.DeepCopy()
+			fillValue(reflect.ValueOf(copied).Elem(), 2)
+			Expect(rsCfg).To(Equal(expected))
+			Expect(*copied).NotTo(Equal(expected))
+		})
+
+		It("Keeps the copy when the config changes", func() {
+			copied := rsCfg.DeepCopy()
+			fillValue(reflect.ValueOf(&rsCfg).Elem(), 2)
+			Expect(*copied).To(Equal(expected))
+		})
+
+		It("Keeps nil sections nil", func() {
+			Expect((&ResourceConfig{}).DeepCopy()).To(Equal(&ResourceConfig{}))
+			Expect((*ResourceConfig)(nil).DeepCopy()).To(BeNil())
+		})
+	})
+})
+
+// fillValue sets every field of v to a value depending on seed, in place
+// for the slices, maps and pointers already set.
+func fillValue(v reflect.Value, seed int) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprintf("v%d", seed))
+	case reflect.Bool:
+		v.SetBool(seed%2 == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(seed))
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		fillValue(v.Elem(), seed)
+	case reflect.Slice:
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		}
+		for i := 0; i < v.Len(); i++ {
+			fillValue(v.Index(i), seed)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.New(v.Type().Key()).Elem()
+		value := reflect.New(v.Type().Elem()).Elem()
+		fillValue(key, seed)
+		fillValue(value, seed)
+		v.SetMapIndex(key, value)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				// Unexported fields are copied too
+				field = reflect.NewAt(field.Type(),
+					unsafe.Pointer(field.UnsafeAddr())).Elem()
+			}
+			fillValue(field, seed)
+		}
+	default:
+		Fail(fmt.Sprintf("Unsupported kind %v of %v", v.Kind(), v.Type()))
+	}
+}
@@ -0,0 +1,135 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+// resyncKey is the key of the resyncs in rscQueue. The same key is queued
+// once, so the resyncs requested meanwhile are processed together.
+var resyncKey = &rqKey{kind: Resync}
+
+// RequestResync requests a full resync of the resources
+func (crMgr *CRManager) RequestResync() {
+	crMgr.enqueueResync()
+}
+
+// enqueueResync enqueues a key to process all the resources again and
+// post the configuration to BIG-IP, even if unchanged.
+func (crMgr *CRManager) enqueueResync() {
+	crMgr.rscQueue.Add(resyncKey)
+}
+
+// periodicResync requests a full resync every ResyncPeriod until stopCh is
+// closed.
+func (crMgr *CRManager) periodicResync(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(crMgr.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Debugf("Periodic resync of the resources")
+			crMgr.enqueueResync()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// resync rebuilds the configs from the resources in the informer caches.
+// The configs last posted are kept to detect the drift once the resources
+// are processed again. The caller holds processingMutex.
+func (crMgr *CRManager) resync() {
+	crMgr.resetConfigs()
+	crMgr.checkDrift = true
+	crMgr.enqueueAllResources()
+}
+
+// resetConfigs forgets the configs and the state derived from the
+// resources processed. The configuration is posted once the resources
+// queued are processed again. The caller holds processingMutex
+// exclusively.
+func (crMgr *CRManager) resetConfigs() {
+	crMgr.resources.resetConfigs()
+	crMgr.rulesMutex.Lock()
+	crMgr.mergedRulesMap = make(map[NameRef]map[string]mergedRuleEntry)
+	crMgr.rulesMutex.Unlock()
+	crMgr.quotaMutex.Lock()
+	crMgr.admittedVirtuals = make(map[string]*cisapiv1.VirtualServer)
+	crMgr.rejectedVirtuals = make(map[string]*cisapiv1.VirtualServer)
+	crMgr.quotaMutex.Unlock()
+	crMgr.rebuilding = true
+
+	crMgr.customProfiles.reset()
+
+	crMgr.irulesMutex.Lock()
+	crMgr.irulesMap = make(IRulesMap)
+	crMgr.irulesMutex.Unlock()
+
+	crMgr.intDgMutex.Lock()
+	crMgr.intDgMap = make(InternalDataGroupMap)
+	crMgr.redirectRecords = make(map[string]map[string]bool)
+	crMgr.intDgMutex.Unlock()
+
+	crMgr.pendingMutex.Lock()
+	crMgr.pendingServices = make(ObjectDependencyMap)
+	crMgr.pendingMutex.Unlock()
+}
+
+// enqueueAllResources queues the custom resources configured on BIG-IP
+// from the informer caches.
+func (crMgr *CRManager) enqueueAllResources() {
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.vsInformer.GetIndexer().List() {
+			crMgr.enqueueVirtualServer(obj)
+		}
+		for _, obj := range crInf.transportInformer.GetIndexer().List() {
+			crMgr.enqueueTransportServer(obj)
+		}
+		for _, obj := range crInf.ilInformer.GetIndexer().List() {
+			crMgr.enqueueIngressLink(obj)
+		}
+		for _, obj := range crInf.ednsInformer.GetIndexer().List() {
+			crMgr.enqueueExternalDNS(obj)
+		}
+	}
+}
+
+// reportDrift logs and counts whether the configs rebuilt by the resync
+// differ from the configs last posted.
+func reportDrift(diffs map[NameRef]ResourceConfigDiff, dnsChanged bool) {
+	drift := len(diffs) > 0 || dnsChanged
+	bigIPPrometheus.Resyncs.WithLabelValues(strconv.FormatBool(drift)).Inc()
+	if !drift {
+		log.Infof("Resync: no drift detected")
+		return
+	}
+	names := make([]string, 0, len(diffs))
+	for ref := range diffs {
+		names = append(names, JoinBigipPath(ref.Partition, ref.Name))
+	}
+	sort.Strings(names)
+	log.Warningf("Resync: drift detected, virtuals %v changed, "+
+		"wide-IPs changed: %v", names, dnsChanged)
+}
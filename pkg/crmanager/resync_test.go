@@ -0,0 +1,227 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("Resync Tests", func() {
+	var mockCRM *mockCRManager
+	var postChan chan config
+	var vs *cisapiv1.VirtualServer
+	var posted config
+
+	// resyncs returns the count of the resyncs with or without drift
+	resyncs := func(drift string) float64 {
+		var d dto.Metric
+		Expect(bigIPPrometheus.Resyncs.WithLabelValues(drift).Write(&d)).To(
+			Succeed())
+		return d.GetCounter().GetValue()
+	}
+
+	// process processes the resources queued and replaces rscQueue, shut
+	// down by processWithWorkers. The resync is processed first, the
+	// resources it queues would be dropped by the queue shut down.
+	process := func() {
+		if mockCRM.rscQueue.Len() > 0 {
+			Expect(mockCRM.processResource()).To(BeTrue())
+		}
+		mockCRM.processWithWorkers(1)
+		mockCRM.rscQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller")
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		postChan = make(chan config, 1)
+		mockCRM.Agent = &Agent{DeclWriter: &PostManager{postChan: postChan}}
+		vs = mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+		mockCRM.addSecretVirtualServer(1, "10.1.1.2")
+		process()
+		mockCRM.flushConfig()
+		Expect(postChan).To(Receive(&posted))
+	})
+
+	It("Posts the unchanged configuration again", func() {
+		noDrift := resyncs("false")
+		mockCRM.RequestResync()
+		mockCRM.enqueueResync()
+		Expect(mockCRM.rscQueue.Len()).To(Equal(1),
+			"Resyncs requested meanwhile should be processed together")
+		process()
+		mockCRM.flushConfig()
+
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(Equal(posted.data))
+		Expect(resyncs("false")).To(Equal(noDrift + 1))
+		Expect(mockCRM.resources.getConfigDiffs()).To(BeEmpty())
+	})
+
+	It("Detects the drift of the configs", func() {
+		drift := resyncs("true")
+		// The deletion of the VirtualServer is missed
+		crInf, _ := mockCRM.getNamespaceInformer("default")
+		Expect(crInf.vsInformer.GetIndexer().Delete(vs)).To(Succeed())
+		mockCRM.enqueueResync()
+		process()
+		mockCRM.flushConfig()
+
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).NotTo(ContainSubstring(
+			formatVirtualServerName("10.1.1.1", 443)))
+		Expect(cfg.data).To(ContainSubstring(
+			formatVirtualServerName("10.1.1.2", 443)))
+		Expect(resyncs("true")).To(Equal(drift + 1))
+	})
+
+	It("Posts the configs once rebuilt", func() {
+		mockCRM.enqueueResync()
+		// Only the resync is processed, the resources are queued
+		Expect(mockCRM.processResource()).To(BeTrue())
+		Expect(mockCRM.rscQueue.Len()).To(Equal(2))
+		mockCRM.flushConfig()
+		Expect(postChan).NotTo(Receive(), "Partial configs should not be posted")
+
+		process()
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(Equal(posted.data))
+		Expect(mockCRM.rebuilding).To(BeFalse())
+	})
+
+	It("Skips the declaration identical to the one applied", func() {
+		postMgr := mockCRM.Agent.DeclWriter.(*PostManager)
+		skipped := func() float64 {
+			var d dto.Metric
+			Expect(bigIPPrometheus.SkippedDeclarations.Write(&d)).To(Succeed())
+			return d.GetCounter().GetValue()
+		}
+		postConfig := func() {
+			mockCRM.Agent.PostConfig(ResourceConfigWrapper{
+				rsCfgs:         mockCRM.resources.GetAllResources(),
+				iRuleMap:       mockCRM.irulesMap,
+				intDgMap:       mockCRM.intDgMap,
+				customProfiles: mockCRM.customProfiles,
+			})
+		}
+		oldSkipped := skipped()
+
+		// Written again until applied
+		postConfig()
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(Equal(posted.data))
+		postMgr.setPosted(cfg)
+		postConfig()
+		Expect(postChan).NotTo(Receive())
+		Expect(skipped() - oldSkipped).To(Equal(float64(1)))
+
+		// The iRules are part of the declaration
+		ref := NameRef{Name: "test_rule", Partition: "test"}
+		mockCRM.irulesMap[ref] = &IRule{Name: ref.Name, Partition: ref.Partition,
+			Code: "when HTTP_REQUEST {}"}
+		postConfig()
+		Expect(postChan).To(Receive(&cfg))
+		postMgr.setPosted(cfg)
+		delete(mockCRM.irulesMap, ref)
+		postConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(Equal(posted.data))
+		postMgr.setPosted(cfg)
+
+		// The resync posts it even if identical
+		mockCRM.enqueueResync()
+		process()
+		mockCRM.flushConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.data).To(Equal(posted.data))
+		Expect(skipped() - oldSkipped).To(Equal(float64(1)))
+	})
+
+	It("Posts the partitions changed or not applied", func() {
+		oldPartition := DEFAULT_PARTITION
+		DEFAULT_PARTITION = "test"
+		defer func() { DEFAULT_PARTITION = oldPartition }()
+		// Forget the partitions written before the default one is set
+		mockCRM.Agent.activeTenants = nil
+		postMgr := mockCRM.Agent.DeclWriter.(*PostManager)
+		postConfig := func() {
+			mockCRM.Agent.PostConfig(ResourceConfigWrapper{
+				rsCfgs:          mockCRM.resources.GetAllResources(),
+				iRuleMap:        mockCRM.irulesMap,
+				intDgMap:        mockCRM.intDgMap,
+				customProfiles:  mockCRM.customProfiles,
+				partitions:      []string{"tenant1"},
+				sharedPartition: "tenant2",
+			})
+		}
+		addIRule := func(partition string) {
+			ref := NameRef{Name: "test_rule", Partition: partition}
+			mockCRM.irulesMap[ref] = &IRule{Name: ref.Name,
+				Partition: ref.Partition, Code: "when HTTP_REQUEST {}"}
+		}
+		postConfig()
+		var cfg config
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.partitions).To(Equal([]string{"tenant1", "tenant2", "test"}))
+		postMgr.setPosted(cfg)
+
+		addIRule("tenant2")
+		postConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.partitions).To(Equal([]string{"tenant2"}))
+		Expect(cfg.data).To(ContainSubstring(`"tenant1"`),
+			"The declaration should be complete")
+
+		// Until applied, the partition is posted with the others changed
+		addIRule("test")
+		postConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.partitions).To(Equal([]string{"tenant2", "test"}))
+		// Once posted on its own, the partition is not posted again while
+		// the other is not applied
+		postMgr.setPartitionPosted(cfg, "test")
+		postConfig()
+		Expect(postChan).To(Receive(&cfg))
+		Expect(cfg.partitions).To(Equal([]string{"tenant2"}))
+		postMgr.setPosted(cfg)
+		postConfig()
+		Expect(postChan).NotTo(Receive())
+	})
+
+	It("Requests the resyncs periodically", func() {
+		mockCRM.ResyncPeriod = 10 * time.Millisecond
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go mockCRM.periodicResync(stopCh)
+		Eventually(mockCRM.rscQueue.Len).Should(Equal(1))
+		Consistently(mockCRM.rscQueue.Len, 50*time.Millisecond).Should(Equal(1))
+		keys := mockCRM.drainQueue()
+		Expect(keys[0].kind).To(Equal(Resync))
+	})
+})
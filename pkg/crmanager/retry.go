@@ -0,0 +1,150 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// Backoff of the resources retried, doubled from retryBaseDelay up to
+	// retryMaxDelay
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 5 * time.Minute
+
+	// maxSyncRetries is the number of times a resource failing to sync is
+	// retried before waiting for the next change of the resource
+	maxSyncRetries = 10
+)
+
+// retryableError is a failure to sync a resource which may succeed later,
+// like a secret not found yet. The other failures are not retried.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// retryable marks the error to be retried
+func retryable(err error) error {
+	return retryableError{err: err}
+}
+
+// isRetryable tells whether the sync failing with err is retried
+func isRetryable(err error) bool {
+	_, ok := err.(retryableError)
+	return ok
+}
+
+// newResourceRateLimiter returns the rate limiter of rscQueue, the resources
+// retried are delayed with exponential backoff.
+func newResourceRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay,
+			retryMaxDelay),
+		// Overall 10 qps with bursts of 100, as the default rate limiter
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// retryLater records a transient failure of the VirtualServer being synced,
+// the first one is returned by syncVirtualServer to retry the sync.
+func (crMgr *CRManager) retryLater(
+	vs *cisapiv1.VirtualServer,
+	format string,
+	args ...interface{},
+) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	crMgr.retryMutex.Lock()
+	defer crMgr.retryMutex.Unlock()
+	if nil == crMgr.retryErrs {
+		crMgr.retryErrs = make(map[string]error)
+	}
+	if _, ok := crMgr.retryErrs[vsKey]; !ok {
+		crMgr.retryErrs[vsKey] = retryable(fmt.Errorf(format, args...))
+	}
+}
+
+// takeRetryError returns the transient failure of the VirtualServer
+// recorded by retryLater and clears it.
+func (crMgr *CRManager) takeRetryError(vsKey string) error {
+	crMgr.retryMutex.Lock()
+	defer crMgr.retryMutex.Unlock()
+	err := crMgr.retryErrs[vsKey]
+	delete(crMgr.retryErrs, vsKey)
+	return err
+}
+
+// retryResource queues the resource failing to sync again with backoff if
+// the failure is retryable, up to maxSyncRetries times. A Warning Event is
+// recorded for the resource when it is given up.
+func (crMgr *CRManager) retryResource(rKey *rqKey, err error) {
+	retries := crMgr.rscQueue.NumRequeues(rKey)
+	if isRetryable(err) && retries < maxSyncRetries {
+		log.Warningf("Sync of %s %s/%s failed, retrying: %v", rKey.kind,
+			rKey.namespace, rKey.rscName, err)
+		bigIPPrometheus.ResourceSyncRetries.WithLabelValues(rKey.kind,
+			rKey.namespace, rKey.rscName).Set(float64(retries + 1))
+		crMgr.rscQueue.AddRateLimited(rKey)
+		return
+	}
+	msg := fmt.Sprintf("Sync failed: %v", err)
+	if isRetryable(err) {
+		msg = fmt.Sprintf("Sync failed after %d retries, waiting for the "+
+			"next change: %v", retries, err)
+	}
+	log.Errorf("%s %s/%s: %s", rKey.kind, rKey.namespace, rKey.rscName, msg)
+	if obj, ok := rKey.rsc.(runtime.Object); ok && isRetryable(err) {
+		crMgr.recordEvent(obj, rKey.namespace, v1.EventTypeWarning,
+			"SyncRetriesExhausted", msg)
+	}
+	crMgr.resourceSynced(rKey)
+}
+
+// resourceSynced forgets the failures of the resource synced or given up.
+func (crMgr *CRManager) resourceSynced(rKey *rqKey) {
+	retried := crMgr.rscQueue.NumRequeues(rKey) > 0
+	crMgr.rscQueue.Forget(rKey)
+	if !retried {
+		return
+	}
+	bigIPPrometheus.ResourceSyncRetries.DeleteLabelValues(rKey.kind,
+		rKey.namespace, rKey.rscName)
+}
+
+// latestVirtualServer returns the VirtualServer of the key retried from the
+// informer cache, the VirtualServer may be updated since queued. It returns
+// false when the VirtualServer is deleted since.
+func (crMgr *CRManager) latestVirtualServer(rKey *rqKey) (
+	*cisapiv1.VirtualServer, bool) {
+	vs := rKey.rsc.(*cisapiv1.VirtualServer)
+	if crMgr.rscQueue.NumRequeues(rKey) == 0 {
+		return vs, true
+	}
+	return crMgr.getVirtualServer(rKey.namespace + "/" + rKey.rscName)
+}
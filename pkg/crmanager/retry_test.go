@@ -0,0 +1,145 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("Retry Tests", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+
+	// retries returns the retries of the VirtualServers by name
+	retries := func() map[string]float64 {
+		ch := make(chan prometheus.Metric, 10)
+		bigIPPrometheus.ResourceSyncRetries.Collect(ch)
+		close(ch)
+		out := make(map[string]float64)
+		for m := range ch {
+			var d dto.Metric
+			Expect(m.Write(&d)).To(Succeed())
+			labels := make(map[string]string)
+			for _, l := range d.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["kind"] == VirtualServer {
+				out[labels["namespace"]+"/"+labels["name"]] = d.GetGauge().GetValue()
+			}
+		}
+		return out
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.Agent = &Agent{
+			DeclWriter: &PostManager{postChan: make(chan config, 1)},
+		}
+		vs = mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+		Expect(mockCRM.kubeClient.CoreV1().Secrets("default").Delete(
+			"vs0", nil)).To(Succeed())
+	})
+
+	It("Converges once the secret appears on the third attempt", func() {
+		for attempt := 1; attempt <= 3; attempt++ {
+			if attempt == 3 {
+				_, err := mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+					test.NewSecret("vs0", "default", testCert("cert"), testKey("cert")))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			// The retries are queued with backoff
+			Expect(mockCRM.processResource()).To(BeTrue())
+			if attempt < 3 {
+				Expect(retries()).To(HaveKeyWithValue("default/vs0",
+					float64(attempt)))
+			}
+		}
+		Expect(mockCRM.rscQueue.Len()).To(BeZero())
+		Expect(retries()).NotTo(HaveKey("default/vs0"),
+			"The retries of the VirtualServer synced should be removed")
+		rsCfg, ok := mockCRM.resources.GetByName("test",
+			formatVirtualServerName("10.1.1.1", 443))
+		Expect(ok).To(BeTrue())
+		Expect(rsCfg.Virtual.Profiles).To(ContainElement(ProfileRef{
+			Partition: "test",
+			Name:      "vs0",
+			Context:   CustomProfileClient,
+			Namespace: "default",
+		}))
+	})
+
+	It("Gives up with an Event after the retries", func() {
+		// The VirtualServer queued is retried without delay
+		key, _ := mockCRM.rscQueue.Get()
+		mockCRM.rscQueue.Done(key)
+		mockCRM.rscQueue = workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(0, 0))
+		mockCRM.rscQueue.Add(key)
+		key, _ = mockCRM.rscQueue.Get()
+		mockCRM.rscQueue.Done(key)
+		for i := 0; i < maxSyncRetries; i++ {
+			mockCRM.rscQueue.AddRateLimited(key)
+			k, _ := mockCRM.rscQueue.Get()
+			mockCRM.rscQueue.Done(k)
+		}
+		mockCRM.rscQueue.Add(key)
+		Expect(mockCRM.processResource()).To(BeTrue())
+
+		Expect(mockCRM.rscQueue.NumRequeues(key)).To(BeZero())
+		Expect(mockCRM.rscQueue.Len()).To(BeZero())
+		var reasons []string
+		for _, ev := range mockCRM.getFakeEvents("default") {
+			reasons = append(reasons, ev.Reason)
+		}
+		Expect(reasons).To(ContainElement("SyncRetriesExhausted"))
+	})
+
+	It("Does not retry the terminal failures", func() {
+		rKey := &rqKey{
+			namespace: "default",
+			kind:      VirtualServer,
+			rscName:   vs.ObjectMeta.Name,
+			rsc:       vs,
+		}
+		mockCRM.retryResource(rKey, fmt.Errorf("invalid"))
+		Expect(mockCRM.rscQueue.NumRequeues(rKey)).To(BeZero())
+		Expect(isRetryable(retryable(fmt.Errorf("not found")))).To(BeTrue())
+	})
+
+	It("Syncs the VirtualServer retried as updated since", func() {
+		key, _ := mockCRM.rscQueue.Get()
+		mockCRM.rscQueue.Done(key)
+		mockCRM.rscQueue.AddRateLimited(key)
+
+		updated := vs.DeepCopy()
+		updated.Spec.VirtualServerAddress = "10.1.1.2"
+		mockCRM.addVirtualServer(updated)
+		Expect(mockCRM.processResource()).To(BeTrue())
+		_, ok := mockCRM.resources.GetByName("test",
+			formatVirtualServerName("10.1.1.2", 80))
+		Expect(ok).To(BeTrue())
+	})
+})
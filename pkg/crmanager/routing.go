@@ -20,10 +20,10 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 
 	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
@@ -35,78 +35,165 @@ func processVirtualServerRules(
 ) *Rules {
 	rlMap := make(ruleMap)
 	wildcards := make(ruleMap)
+	rewrites := make(ruleMap)
+	resets := make(ruleMap)
 
-	for _, pl := range vs.Spec.Pools {
-		uri := vs.Spec.Host + pl.Path
-		// Service cannot be empty
-		if pl.Service == "" {
-			continue
-		}
-		poolName := formatVirtualServerPoolName(
-			vs.ObjectMeta.Namespace,
-			pl.Service,
-			pl.NodeMemberLabel,
-		)
-		ruleName := formatVirtualServerRuleName(vs.Spec.Host, pl.Path, poolName)
-		rl, err := createRule(uri, poolName, ruleName)
-		if nil != err {
-			log.Warningf("Error configuring rule: %v", err)
-			return nil
-		}
-		if true == strings.HasPrefix(uri, "*.") {
-			wildcards[uri] = rl
-		} else {
-			rlMap[uri] = rl
+	// The rules of each host are alike
+	for _, host := range getVirtualServerHosts(vs) {
+		for _, pl := range getVirtualServerPools(vs) {
+			uri := host + pl.Path
+			// Service cannot be empty, unless the pool has an action or
+			// static members
+			if pl.Service == "" && nil == pl.Action &&
+				len(pl.StaticMembers) == 0 {
+				continue
+			}
+			// The rule of a pool action is named after the action
+			var poolName, ruleTarget string
+			if nil == pl.Action {
+				poolName = getVirtualServerPoolName(vs.ObjectMeta.Namespace,
+					pl)
+				ruleTarget = poolName
+			} else {
+				ruleTarget = pl.Action.Type
+			}
+			pathMatchType := getPathMatchType(pl)
+			var ruleName string
+			if pathMatchType == PathMatchPrefix {
+				ruleName = formatVirtualServerRuleName(host, pl.Path,
+					ruleTarget)
+			} else {
+				ruleName = formatVirtualServerRuleName(host,
+					nonNameCharRegex.ReplaceAllString(pl.Path, "_"),
+					ruleTarget) + "_" + pathMatchType
+			}
+			match := getMatchKey(pl)
+			if match != "" {
+				ruleName += "_" +
+					nonNameCharRegex.ReplaceAllString(match, "_")
+			}
+			if nil != pl.Action {
+				ruleName += resetRuleSuffix
+			}
+			rl, err := createRule(uri, poolName, ruleName, pathMatchType)
+			if nil != err {
+				log.Warningf("Error configuring rule: %v", err)
+				return nil
+			}
+			if nil != pl.Action {
+				rl.Actions = []*action{createPoolAction(pl.Action)}
+			} else {
+				rl.Actions = append(rl.Actions,
+					createResponseHeaderActions(pl, len(rl.Actions))...)
+			}
+			rl.Conditions = append(rl.Conditions,
+				createMatchConditions(pl, len(rl.Conditions))...)
+			key := uri + pathMatchType + match
+			// The requests of the other clients fall to the reset rule
+			if ranges := getSourceRanges(vs, pl); nil == pl.Action &&
+				len(ranges) > 0 {
+				resets[key] = createSourceRangeResetRule(rl)
+				rl.Conditions = append(rl.Conditions, &condition{
+					Tcp:     true,
+					Address: true,
+					Matches: true,
+					Name:    strconv.Itoa(len(rl.Conditions)),
+					Request: true,
+					Values:  ranges,
+				})
+			}
+			if isWildcardHost(uri) {
+				wildcards[key] = rl
+			} else {
+				rlMap[key] = rl
+			}
+			if rwRule := createRewriteRule(rl, pl); nil != rwRule {
+				rewrites[key] = rwRule
+			}
 		}
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+	rls := createMaintenanceRules(vs)
+	for _, v := range rlMap {
+		rls = append(rls, v)
+	}
+	for _, v := range wildcards {
+		rls = append(rls, v)
+	}
+	for _, v := range rewrites {
+		rls = append(rls, v)
+	}
+	for _, v := range resets {
+		rls = append(rls, v)
+	}
 
-	sortrules := func(r ruleMap, rls *Rules, ordinal int) {
-		for _, v := range r {
-			*rls = append(*rls, v)
+	sortRules(rls)
+	return &rls
+}
+
+// createMaintenanceRules returns the rules of the hosts of the VirtualServer
+// in maintenance, redirecting or forwarding to the maintenance pool, or none
+// when the VirtualServer is not in maintenance.
+func createMaintenanceRules(vs *cisapiv1.VirtualServer) Rules {
+	mt := vs.Spec.Maintenance
+	if nil == mt || !mt.Enabled {
+		return Rules{}
+	}
+	rls := Rules{}
+	for _, host := range getVirtualServerHosts(vs) {
+		ruleName := formatVirtualServerRuleName(host, "", "all") +
+			maintenanceRuleSuffix
+		rl, err := createRule(host, mt.Pool, ruleName, PathMatchPrefix)
+		if nil != err {
+			log.Warningf("Error configuring maintenance rule: %v", err)
+			continue
 		}
-		//sort.Sort(sort.Reverse(*rls))
-		for _, v := range *rls {
-			v.Ordinal = ordinal
-			ordinal++
+		if mt.RedirectURL != "" {
+			rl.Actions = []*action{createPoolAction(&cisapiv1.PoolAction{
+				Type:     PoolActionRedirect,
+				Location: mt.RedirectURL,
+			})}
 		}
-		wg.Done()
+		rls = append(rls, rl)
 	}
+	return rls
+}
 
-	rls := Rules{}
-	go sortrules(rlMap, &rls, 0)
-
-	w := Rules{}
-	go sortrules(wildcards, &w, len(rlMap))
-
-	wg.Wait()
-
-	rls = append(rls, w...)
-
-	sort.Sort(rls)
-	return &rls
+// isMaintenanceRule returns true if the rule is the maintenance rule of a
+// host
+func isMaintenanceRule(rule *Rule) bool {
+	return strings.HasSuffix(rule.Name, maintenanceRuleSuffix)
 }
 
 // format the rule name for VirtualServer
 func formatVirtualServerRuleName(host, path, pool string) string {
-	var rule string
-	if path == "" {
-		rule = fmt.Sprintf("vs_%s_%s", host, pool)
-	} else {
-		// Remove the first slash, then replace any subsequent slashes with '_'
-		path = strings.TrimPrefix(path, "/")
-		path = strings.Replace(path, "/", "_", -1)
-		rule = fmt.Sprintf("vs_%s_%s_%s", host, path, pool)
-	}
+	return namer.RuleName(host, path, pool)
+}
 
-	rule = AS3NameFormatter(rule)
-	return rule
+// nonNameCharRegex matches the characters of regex paths not allowed in
+// rule names
+var nonNameCharRegex = regexp.MustCompile(`[^A-Za-z0-9_./-]`)
+
+// getPathMatchType returns the path match type of the pool
+func getPathMatchType(pl cisapiv1.Pool) string {
+	if pl.PathMatchType == "" {
+		return PathMatchPrefix
+	}
+	return pl.PathMatchType
 }
 
 // Create LTM policy rules
-func createRule(uri, poolName, ruleName string) (*Rule, error) {
+func createRule(uri, poolName, ruleName, pathMatchType string) (*Rule, error) {
+	// Exact and regex paths are matched as a whole, parse only the host
+	var path string
+	if pathMatchType == PathMatchExact || pathMatchType == PathMatchRegex {
+		if idx := strings.Index(uri, "/"); idx != -1 {
+			path = uri[idx:]
+			uri = uri[:idx]
+		}
+	}
+	fullURI := uri + path
+
 	_u := "scheme://" + uri
 	_u = strings.TrimSuffix(_u, "/")
 	u, err := url.Parse(_u)
@@ -143,13 +230,24 @@ func createRule(uri, poolName, ruleName string) (*Rule, error) {
 			Values:   []string{u.Host},
 		})
 	}
-	if 0 != len(u.EscapedPath()) {
+	if path != "" {
+		c = append(c, &condition{
+			Equals:  pathMatchType == PathMatchExact,
+			Matches: pathMatchType == PathMatchRegex,
+			HTTPURI: true,
+			Path:    true,
+			Name:    "1",
+			Index:   1,
+			Request: true,
+			Values:  []string{path},
+		})
+	} else if 0 != len(u.EscapedPath()) {
 		c = append(c, createPathSegmentConditions(u)...)
 	}
 
 	rl := Rule{
 		Name:       ruleName,
-		FullURI:    uri,
+		FullURI:    fullURI,
 		Actions:    []*action{&a},
 		Conditions: c,
 	}
@@ -158,6 +256,322 @@ func createRule(uri, poolName, ruleName string) (*Rule, error) {
 	return &rl, nil
 }
 
+// createPoolAction returns the action of the rule of a pool without
+// service. Reset is an action of the policy, the pool action iRule of the
+// virtual drops and redirects the requests.
+func createPoolAction(pa *cisapiv1.PoolAction) *action {
+	switch pa.Type {
+	case PoolActionDrop:
+		return &action{Name: "0", Drop: true, Request: true}
+	case PoolActionRedirect:
+		code := pa.Code
+		if code == 0 {
+			code = DefaultRedirectCode
+		}
+		return &action{Name: "0", Redirect: true, Request: true,
+			Location: pa.Location, Code: code}
+	}
+	return &action{Name: "0", Forward: true, Request: true, Reset: true}
+}
+
+// poolActionIRule returns the iRule of the actions by rule name, applied
+// to the requests matching the rule in the policy of the virtual.
+func poolActionIRule(actions map[string]*action) string {
+	var names []string
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var cases []string
+	for _, name := range names {
+		act := actions[name]
+		cmd := "drop"
+		if act.Redirect {
+			cmd = fmt.Sprintf("HTTP::respond %d Location {%s}", act.Code,
+				act.Location)
+		}
+		cases = append(cases, fmt.Sprintf(`
+					{%s} {
+						%s
+						return
+					}`, name, cmd))
+	}
+	return fmt.Sprintf(`
+		when HTTP_REQUEST {
+			foreach policy [POLICY::names matched] {
+				foreach rule [POLICY::rules matched $policy] {
+					switch -- $rule {%s
+					}
+				}
+			}
+		}`, strings.Join(cases, ""))
+}
+
+// getMatchMethods returns the HTTP methods of the pool in upper case,
+// sorted and without duplicates.
+func getMatchMethods(pl cisapiv1.Pool) []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, method := range pl.MatchMethod {
+		method = strings.ToUpper(method)
+		if !seen[method] {
+			seen[method] = true
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// getMatchQueryParams returns the values of the query parameters of the
+// pool by parameter name.
+func getMatchQueryParams(pl cisapiv1.Pool) map[string][]string {
+	params := make(map[string][]string)
+	for _, param := range pl.MatchQueryParams {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		if !containsString(params[kv[0]], kv[1]) {
+			params[kv[0]] = append(params[kv[0]], kv[1])
+		}
+	}
+	for name := range params {
+		sort.Strings(params[name])
+	}
+	return params
+}
+
+// getMatchKey returns the methods and query parameters of the pool, which
+// distinguish the rules of pools with the same path. Empty when the pool
+// matches all the requests of the path.
+func getMatchKey(pl cisapiv1.Pool) string {
+	var parts []string
+	if methods := getMatchMethods(pl); len(methods) > 0 {
+		parts = append(parts, strings.Join(methods, ","))
+	}
+	params := getMatchQueryParams(pl)
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+strings.Join(params[name], ","))
+	}
+	return strings.Join(parts, "&")
+}
+
+// createMatchConditions returns the conditions of the methods and query
+// parameters of the pool, named from index on.
+func createMatchConditions(pl cisapiv1.Pool, index int) []*condition {
+	var c []*condition
+	if methods := getMatchMethods(pl); len(methods) > 0 {
+		c = append(c, &condition{
+			Equals:     true,
+			HTTPMethod: true,
+			Name:       strconv.Itoa(index + len(c)),
+			Request:    true,
+			Values:     methods,
+		})
+	}
+	params := getMatchQueryParams(pl)
+	var names []string
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c = append(c, &condition{
+			Equals:         true,
+			HTTPURI:        true,
+			QueryParameter: true,
+			ParameterName:  name,
+			Name:           strconv.Itoa(index + len(c)),
+			Request:        true,
+			Values:         params[name],
+		})
+	}
+	return c
+}
+
+// matchConditionCount returns the number of method, query parameter and
+// source address conditions of the rule.
+func matchConditionCount(rule *Rule) int {
+	count := 0
+	for _, c := range rule.Conditions {
+		if c.HTTPMethod || c.QueryParameter || c.Tcp {
+			count++
+		}
+	}
+	return count
+}
+
+// sameMatchConditions returns true if the rules match the same methods and
+// query parameters, whatever the names of the conditions.
+func sameMatchConditions(rule1, rule2 *Rule) bool {
+	matchConditions := func(rule *Rule) []condition {
+		var conds []condition
+		for _, c := range rule.Conditions {
+			if c.HTTPMethod || c.QueryParameter {
+				cond := *c
+				cond.Name = ""
+				conds = append(conds, cond)
+			}
+		}
+		return conds
+	}
+	return reflect.DeepEqual(matchConditions(rule1), matchConditions(rule2))
+}
+
+// getSourceRanges returns the CIDRs of the clients allowed to the pool,
+// the allowSourceRange of the pool replaces the one of the VirtualServer.
+func getSourceRanges(vs *cisapiv1.VirtualServer, pl cisapiv1.Pool) []string {
+	if len(pl.AllowSourceRange) > 0 {
+		return pl.AllowSourceRange
+	}
+	return vs.Spec.AllowSourceRange
+}
+
+// createSourceRangeResetRule returns the rule resetting the requests
+// matching the conditions of the forwarding rule from the clients out of
+// its source ranges. It is not merged with other rules.
+func createSourceRangeResetRule(fwdRule *Rule) *Rule {
+	rl := &Rule{
+		Name:    fwdRule.Name + resetRuleSuffix,
+		FullURI: fwdRule.FullURI,
+		Actions: []*action{
+			createPoolAction(&cisapiv1.PoolAction{Type: PoolActionReset})},
+	}
+	for _, c := range fwdRule.Conditions {
+		cond := *c
+		rl.Conditions = append(rl.Conditions, &cond)
+	}
+	return rl
+}
+
+// createRewriteRule returns the rule rewriting the requests matching the
+// conditions of the forwarding rule of the pool, or nil without rewrite.
+// The rule is merged with the forwarding rule by MergeRules.
+func createRewriteRule(fwdRule *Rule, pl cisapiv1.Pool) *Rule {
+	targetHost := getPoolTargetHost(pl)
+	if targetHost == "" && (nil == pl.Rewrite || pl.Rewrite.TargetPath == "") {
+		return nil
+	}
+	var actions []*action
+	if targetHost != "" {
+		actions = append(actions, &action{
+			Name:     fmt.Sprintf("%d", len(actions)),
+			HTTPHost: true,
+			Replace:  true,
+			Request:  true,
+			Value:    targetHost,
+		})
+	}
+	if nil != pl.Rewrite && pl.Rewrite.TargetPath != "" {
+		actions = append(actions, &action{
+			Name:    fmt.Sprintf("%d", len(actions)),
+			HTTPURI: true,
+			Replace: true,
+			Request: true,
+			Value:   rewritePathValue(pl),
+		})
+	}
+	var conditions []*condition
+	for _, c := range fwdRule.Conditions {
+		cond := *c
+		cond.Values = append([]string{}, c.Values...)
+		conditions = append(conditions, &cond)
+	}
+	return &Rule{
+		Name:       urlRewriteRulePrefix + fwdRule.Name,
+		FullURI:    fwdRule.FullURI,
+		Actions:    actions,
+		Conditions: conditions,
+	}
+}
+
+// createResponseHeaderActions returns the actions setting and removing the
+// response headers of the pool, named from the index of the first action.
+// The headers are sorted by name so the same spec has the same actions.
+func createResponseHeaderActions(pl cisapiv1.Pool, index int) []*action {
+	rh := pl.ResponseHeaders
+	if nil == rh {
+		return nil
+	}
+	var names []string
+	for name := range rh.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var actions []*action
+	for _, name := range names {
+		actions = append(actions, &action{
+			Name:     strconv.Itoa(index + len(actions)),
+			Replace:  true,
+			Response: true,
+			TmName:   name,
+			Value:    rh.Set[name],
+		})
+	}
+	for _, name := range rh.Remove {
+		actions = append(actions, &action{
+			Name:     strconv.Itoa(index + len(actions)),
+			Remove:   true,
+			Response: true,
+			TmName:   name,
+		})
+	}
+	return actions
+}
+
+// getPoolTargetHost returns the host replacing the Host header of the
+// requests of the pool, either the hostRewrite or the rewrite targetHost.
+func getPoolTargetHost(pl cisapiv1.Pool) string {
+	if pl.HostRewrite != "" {
+		return pl.HostRewrite
+	}
+	if nil != pl.Rewrite {
+		return pl.Rewrite.TargetHost
+	}
+	return ""
+}
+
+// rewritePathValue returns the Tcl expression replacing the path of the
+// pool at the start of the request URI with the target path.
+func rewritePathValue(pl cisapiv1.Pool) string {
+	target := pl.Rewrite.TargetPath
+	var pattern string
+	switch {
+	case getPathMatchType(pl) == PathMatchRegex:
+		pattern = "^" + pl.Path
+	case pl.Path == "" || pl.Path == "/":
+		// The paths under the root are moved under the target path
+		pattern = "^/"
+		target = strings.TrimSuffix(target, "/") + "/"
+	default:
+		pattern = "^" + regexp.QuoteMeta(pl.Path)
+	}
+	return fmt.Sprintf("tcl:[regsub {%s} [HTTP::uri] {%s}]", pattern, target)
+}
+
+// withoutRewrites returns the rules without the rewrite rules.
+func (rules *Rules) withoutRewrites() *Rules {
+	rls := Rules{}
+	for _, rl := range *rules {
+		if !isRewriteRule(rl) {
+			rls = append(rls, rl)
+		}
+	}
+	return &rls
+}
+
+// isRewriteRule returns true if the rule rewrites the requests of a path
+// forwarded by another rule.
+func isRewriteRule(rule *Rule) bool {
+	return strings.HasPrefix(rule.Name, urlRewriteRulePrefix)
+}
+
 func createPathSegmentConditions(u *url.URL) []*condition {
 	var c []*condition
 	path := strings.TrimPrefix(u.EscapedPath(), "/")
@@ -177,38 +591,38 @@ func createPathSegmentConditions(u *url.URL) []*condition {
 }
 
 func createPolicy(rls Rules, policyName, partition string) *Policy {
+	// The http requirement applies to the request and response actions of
+	// the rules, like the response header actions.
 	plcy := Policy{
 		Controls:  []string{"forwarding"},
 		Legacy:    true,
 		Name:      policyName,
 		Partition: partition,
-		Requires:  []string{"http"},
+		Requires:  policyRequires(rls),
 		Rules:     Rules{},
 		Strategy:  "/Common/first-match",
 	}
 
 	plcy.Rules = rls
 
+	log.Debugf("Configured policy: %v", plcy)
+	return &plcy
+}
+
+// policyRequires returns the requirements of the policy of the rules
+func policyRequires(rls Rules) []string {
+	requires := []string{"http"}
 	// Check for the existence of the TCP field in the conditions.
 	// This would indicate that a whitelist rule is in the policy
 	// and that we need to add the "tcp" requirement to the policy.
-	requiresTcp := false
 	for _, x := range rls {
 		for _, c := range x.Conditions {
 			if c.Tcp == true {
-				requiresTcp = true
+				return append(requires, "tcp")
 			}
 		}
 	}
-
-	// Add the tcp requirement if needed; indicated by the presence
-	// of the TCP field.
-	if requiresTcp {
-		plcy.Requires = append(plcy.Requires, "tcp")
-	}
-
-	log.Debugf("Configured policy: %v", plcy)
-	return &plcy
+	return requires
 }
 
 func (rules Rules) Len() int {
@@ -218,64 +632,832 @@ func (rules Rules) Len() int {
 func (rules Rules) Less(i, j int) bool {
 	ruleI := rules[i]
 	ruleJ := rules[j]
-	// Strategy 1: Rule with Highest number of conditions
-	l1 := len(ruleI.Conditions)
-	l2 := len(ruleJ.Conditions)
-	if l1 != l2 {
-		return l1 > l2
-	}
-
-	// Strategy 2: Rule with highest priority sequence of condition types
-	// TODO
-
-	// Strategy 3: "equal" match type takes more priority than others
-	// such as "starts-with", "ends-with", "contains"
-	// TODO: And "start-with" and "ends-with" takes same priority and take
-	// more priority than "contains"
-	getConditionCounters := func(rule *Rule) (int, int) {
-		var (
-			eqCount  int
-			endCount int
-		)
-		for _, cnd := range ruleI.Conditions {
-			if cnd.Equals {
-				eqCount++
-			}
-			if cnd.EndsWith {
-				endCount++
-			}
+	// Strategy 1: Rule with more specific host, exact host takes more
+	// priority than wildcard host, and wildcard host than no host
+	hostRankI := hostMatchRank(ruleI)
+	hostRankJ := hostMatchRank(ruleJ)
+	if hostRankI != hostRankJ {
+		return hostRankI < hostRankJ
+	}
+	// Rule with longer wildcard host is more specific
+	if isWildcardHostRule(ruleI) {
+		hostI := ruleHost(ruleI)
+		hostJ := ruleHost(ruleJ)
+		if len(hostI) != len(hostJ) {
+			return len(hostI) > len(hostJ)
 		}
-		return eqCount, endCount
 	}
-	eqCountI, endCountI := getConditionCounters(ruleI)
-	eqCountJ, endCountJ := getConditionCounters(ruleJ)
-	if eqCountI != eqCountJ {
-		return eqCountI > eqCountJ
+
+	// The maintenance rule of a host overrides the rules of its paths
+	if isMaintenanceRule(ruleI) != isMaintenanceRule(ruleJ) {
+		return isMaintenanceRule(ruleI)
 	}
-	if endCountI != endCountJ {
-		return endCountI > endCountJ
+
+	// Strategy 2: Exact path takes more priority than path prefix, and
+	// path prefix takes more priority than regex path
+	rankI := pathMatchRank(ruleI)
+	rankJ := pathMatchRank(ruleJ)
+	if rankI != rankJ {
+		return rankI < rankJ
 	}
 
-	// Strategy 4: Lowest Ordinal
-	return ruleI.Ordinal < ruleJ.Ordinal
+	// Strategy 3: Rule with highest number of path segments
+	pathI := rulePath(ruleI)
+	pathJ := rulePath(ruleJ)
+	segmentsI := pathSegmentCount(pathI)
+	segmentsJ := pathSegmentCount(pathJ)
+	if segmentsI != segmentsJ {
+		return segmentsI > segmentsJ
+	}
 
+	// Strategy 4: Rule with longest path
+	if len(pathI) != len(pathJ) {
+		return len(pathI) > len(pathJ)
+	}
+
+	// Strategy 5: Rule with more method, query parameter and source
+	// address conditions, the requests of the path matching none fall to
+	// the others
+	matchesI := matchConditionCount(ruleI)
+	matchesJ := matchConditionCount(ruleJ)
+	if matchesI != matchesJ {
+		return matchesI > matchesJ
+	}
+
+	// Strategy 6: Order by URI and name, so the order does not depend on
+	// the order of the pools
+	if ruleI.FullURI != ruleJ.FullURI {
+		return ruleI.FullURI < ruleJ.FullURI
+	}
+	return ruleI.Name < ruleJ.Name
+}
+
+// sortRules sorts the rules by specificity and sets their ordinals
+func sortRules(rules Rules) {
+	sort.Sort(rules)
+	for i, rl := range rules {
+		rl.Ordinal = i
+	}
 }
 
 func (rules Rules) Swap(i, j int) {
 	rules[i], rules[j] = rules[j], rules[i]
 }
 
+// hostMatchRank returns 0 for rules with exact host, 1 for rules with
+// wildcard host and 2 for rules without host
+func hostMatchRank(rule *Rule) int {
+	for _, cnd := range rule.Conditions {
+		if cnd.Host {
+			if cnd.EndsWith {
+				return 1
+			}
+			return 0
+		}
+	}
+	return 2
+}
+
+// rulePath returns the path of the rule URI
+func rulePath(rule *Rule) string {
+	if idx := strings.Index(rule.FullURI, "/"); idx != -1 {
+		return rule.FullURI[idx:]
+	}
+	return ""
+}
+
+// pathSegmentCount returns the number of non empty segments of the path
+func pathSegmentCount(path string) int {
+	count := 0
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// isWildcardHostRule returns true if the rule matches the host by suffix
+func isWildcardHostRule(rule *Rule) bool {
+	for _, cnd := range rule.Conditions {
+		if cnd.Host && cnd.EndsWith {
+			return true
+		}
+	}
+	return false
+}
+
+// pathMatchRank returns 0 for rules with exact path, 1 for rules with
+// path prefix or without path and 2 for rules with regex path
+func pathMatchRank(rule *Rule) int {
+	for _, cnd := range rule.Conditions {
+		if cnd.Path && cnd.Equals {
+			return 0
+		}
+		if cnd.Path && cnd.Matches {
+			return 2
+		}
+	}
+	return 1
+}
+
+// ruleHost returns the host matched by the rule
+func ruleHost(rule *Rule) string {
+	for _, cnd := range rule.Conditions {
+		if cnd.Host && len(cnd.Values) > 0 {
+			return cnd.Values[0]
+		}
+	}
+	return ""
+}
+
+// isWildcardHost returns true if the host is like *.example.com
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
+
+// wildcardHostsOverlap returns true if the hosts are different wildcard
+// hosts and a host matched by one wildcard can be matched by the other,
+// like *.example.com and *.apps.example.com
+func wildcardHostsOverlap(host1, host2 string) bool {
+	if host1 == host2 || !isWildcardHost(host1) || !isWildcardHost(host2) {
+		return false
+	}
+	suffix1 := strings.TrimPrefix(host1, "*")
+	suffix2 := strings.TrimPrefix(host2, "*")
+	return strings.HasSuffix(suffix1, suffix2) ||
+		strings.HasSuffix(suffix2, suffix1)
+}
+
+// abDeploymentPathIRule selects the pool of the A/B deployments.
+// The key in the data group is the specific route (host/path) to examine.
+// The data is a list of pool/weight pairs delimited by ';'. The pair values
+// are delineated by ','. The weight value is normalized between 0.0 and 1.0
+// and the pairs are listed in ascending order of weight values.
+func abDeploymentPathIRule() string {
+	return fmt.Sprintf(`
+		proc select_ab_pool {path default_pool } {
+			set last_slash [string length $path]
+			set ab_class "%s"
+			while {$last_slash >= 0} {
+				if {[class match $path equals $ab_class]} then {
+					break
+				}
+				set last_slash [string last "/" $path $last_slash]
+				incr last_slash -1
+				set path [string range $path 0 $last_slash]
+			}
+
+			if {$last_slash >= 0} {
+				set ab_rule [class match -value $path equals $ab_class]
+				if {$ab_rule != ""} then {
+					set weight_selection [expr {rand()}]
+					set service_rules [split $ab_rule ";"]
+					foreach service_rule $service_rules {
+						set fields [split $service_rule ","]
+						set pool_name [lindex $fields 0]
+						set weight [expr {double([lindex $fields 1])}]
+						if {$weight_selection <= $weight} then {
+							return $pool_name
+						}
+					}
+				}
+				# If we had a match, but all weights were 0 then
+				# return a 503 (Service Unavailable)
+				HTTP::respond 503
+			}
+			return $default_pool
+		}
+
+		when HTTP_REQUEST priority 200 {
+			set path [string tolower [HTTP::host]][HTTP::path]
+			set selected_pool [call select_ab_pool $path ""]
+			if {$selected_pool != ""} then {
+				pool $selected_pool
+			}
+		}`, AbDeploymentDgName)
+}
+
+// getPoolWeight returns the weight of the pool for A/B deployments
+func getPoolWeight(pl cisapiv1.Pool) int32 {
+	if nil == pl.Weight {
+		return DefaultPoolWeight
+	}
+	return *pl.Weight
+}
+
+// abDeploymentKey returns the key of the route (host/path) in the A/B
+// deployment data group, as matched by abDeploymentPathIRule.
+func abDeploymentKey(uri string) string {
+	return strings.ToLower(strings.TrimSuffix(uri, "/"))
+}
+
+// getABDeploymentPools returns the pools of the VirtualServer by A/B
+// deployment key, for the paths served by two or more pools.
+func getABDeploymentPools(vs *cisapiv1.VirtualServer) map[string][]cisapiv1.Pool {
+	poolsByKey := make(map[string][]cisapiv1.Pool)
+	for _, pl := range getVirtualServerPools(vs) {
+		// The pools matching methods or query parameters and the pools
+		// with an action have rules of their own
+		if getMatchKey(pl) != "" || nil != pl.Action {
+			continue
+		}
+		for _, host := range getVirtualServerHosts(vs) {
+			key := abDeploymentKey(host + pl.Path)
+			poolsByKey[key] = append(poolsByKey[key], pl)
+		}
+	}
+	for key, pools := range poolsByKey {
+		if len(pools) < 2 {
+			delete(poolsByKey, key)
+		}
+	}
+	return poolsByKey
+}
+
+// abDeploymentRecord returns the data of the A/B deployment record of the
+// pools. Each pool gets a slice between 0.0 and 1.0 in proportion to its
+// weight, pools with weight 0 are not listed.
+func abDeploymentRecord(
+	partition string,
+	namespace string,
+	pools []cisapiv1.Pool,
+) string {
+	var weightTotal int32
+	for _, pl := range pools {
+		weightTotal += getPoolWeight(pl)
+	}
+	if weightTotal == 0 {
+		// All the pools are out of rotation, the iRule responds with 503
+		return ""
+	}
+	var entries []string
+	var runningWeightTotal int32
+	for _, pl := range pools {
+		weight := getPoolWeight(pl)
+		if weight == 0 {
+			continue
+		}
+		runningWeightTotal += weight
+		poolName := getVirtualServerPoolName(namespace, pl)
+		entries = append(entries, fmt.Sprintf("/%s/%s/%s,%4.3f",
+			partition, as3SharedApplication, poolName,
+			float64(runningWeightTotal)/float64(weightTotal)))
+	}
+	return strings.Join(entries, ";")
+}
+
+// updateABDeploymentDataGroup adds the A/B deployment records of the
+// VirtualServer to dgMap, along with the records of the other
+// VirtualServers in the namespace. The records of the paths no longer
+// served by two or more pools are removed. intDgMutex must be held.
+func (crMgr *CRManager) updateABDeploymentDataGroup(
+	dgMap InternalDataGroupMap,
+	vs *cisapiv1.VirtualServer,
+	depsRemoved []ObjectDependency,
+) {
+	namespace := vs.ObjectMeta.Namespace
+	partition := crMgr.getVirtualServerPartition(vs)
+	mapKey := NameRef{
+		Name:      AbDeploymentDgName,
+		Partition: crMgr.objectsPartition(partition),
+	}
+	dg := &InternalDataGroup{
+		Name:      AbDeploymentDgName,
+		Partition: mapKey.Partition,
+	}
+	if oldDg, found := crMgr.intDgMap[mapKey][namespace]; found {
+		dg.Records = make(InternalDataGroupRecords, len(oldDg.Records))
+		copy(dg.Records, oldDg.Records)
+	}
+
+	for _, dep := range depsRemoved {
+		if dep.Kind == RuleDep {
+			dg.RemoveRecord(abDeploymentKey(dep.Name))
+		}
+	}
+	abPools := getABDeploymentPools(vs)
+	for _, pl := range getVirtualServerPools(vs) {
+		for _, host := range getVirtualServerHosts(vs) {
+			key := abDeploymentKey(host + pl.Path)
+			if _, ok := abPools[key]; !ok {
+				dg.RemoveRecord(key)
+			}
+		}
+	}
+	for key, pools := range abPools {
+		dg.AddOrUpdateRecord(key, abDeploymentRecord(partition, namespace, pools))
+	}
+
+	if len(dg.Records) > 0 {
+		dgMap[mapKey] = DataGroupNamespaceMap{namespace: dg}
+	}
+}
+
+// deleteABDeploymentRecords removes the A/B deployment records of the
+// VirtualServer.
+func (crMgr *CRManager) deleteABDeploymentRecords(vs *cisapiv1.VirtualServer) {
+	crMgr.intDgMutex.Lock()
+	defer crMgr.intDgMutex.Unlock()
+
+	partition := crMgr.objectsPartition(crMgr.getVirtualServerPartition(vs))
+
+	mapKey := NameRef{
+		Name:      AbDeploymentDgName,
+		Partition: partition,
+	}
+	nsDg, found := crMgr.intDgMap[mapKey]
+	if !found {
+		return
+	}
+	dg, found := nsDg[vs.ObjectMeta.Namespace]
+	if !found {
+		return
+	}
+	for key := range getABDeploymentPools(vs) {
+		dg.RemoveRecord(key)
+	}
+	if len(dg.Records) == 0 {
+		delete(nsDg, vs.ObjectMeta.Namespace)
+	}
+}
+
+// updateRedirectDataGroup updates the https redirect records of the
+// VirtualServer in the data group of its namespace, the records it no longer
+// redirects are removed. intDgMutex must be held.
+func (crMgr *CRManager) updateRedirectDataGroup(
+	dgMap InternalDataGroupMap,
+	vs *cisapiv1.VirtualServer,
+	fwdRules ServiceFwdRuleMap,
+) {
+	namespace := vs.ObjectMeta.Namespace
+	partition := crMgr.objectsPartition(crMgr.getVirtualServerPartition(vs))
+	vsKey := namespace + "/" + vs.ObjectMeta.Name
+	mapKey := NameRef{
+		Name:      HttpsRedirectDgName,
+		Partition: partition,
+	}
+	dg := &InternalDataGroup{
+		Name:      HttpsRedirectDgName,
+		Partition: partition,
+	}
+	vsDgs := make(DataGroupNamespaceMap)
+	fwdRules.AddToDataGroup(vsDgs)
+
+	if oldDg, found := crMgr.intDgMap[mapKey][namespace]; found {
+		dg.Records = make(InternalDataGroupRecords, len(oldDg.Records))
+		copy(dg.Records, oldDg.Records)
+	}
+	crMgr.removeRedirectRecords(dg, vsKey)
+	if vsDg, found := vsDgs[namespace]; found && len(vsDg.Records) > 0 {
+		records := make(map[string]bool)
+		for _, record := range vsDg.Records {
+			dg.AddOrUpdateRecord(record.Name, record.Data)
+			records[record.Name] = true
+		}
+		crMgr.redirectRecords[vsKey] = records
+	}
+	crMgr.deleteUnusedRedirect()
+
+	if len(dg.Records) > 0 {
+		dgMap[mapKey] = DataGroupNamespaceMap{namespace: dg}
+	}
+}
+
+// deleteRedirectRecords removes the https redirect records of the deleted
+// VirtualServer.
+func (crMgr *CRManager) deleteRedirectRecords(vs *cisapiv1.VirtualServer) {
+	crMgr.intDgMutex.Lock()
+	defer crMgr.intDgMutex.Unlock()
+
+	partition := crMgr.objectsPartition(crMgr.getVirtualServerPartition(vs))
+
+	mapKey := NameRef{
+		Name:      HttpsRedirectDgName,
+		Partition: partition,
+	}
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	if dg, found := crMgr.intDgMap[mapKey][vs.ObjectMeta.Namespace]; found {
+		crMgr.removeRedirectRecords(dg, vsKey)
+		if len(dg.Records) == 0 {
+			delete(crMgr.intDgMap[mapKey], vs.ObjectMeta.Namespace)
+		}
+	}
+	delete(crMgr.redirectRecords, vsKey)
+	crMgr.deleteUnusedRedirect()
+}
+
+// removeRedirectRecords removes the records of the VirtualServer from the
+// data group, except the records of other VirtualServers of the namespace.
+// intDgMutex must be held.
+func (crMgr *CRManager) removeRedirectRecords(
+	dg *InternalDataGroup,
+	vsKey string,
+) {
+	prefix := strings.SplitN(vsKey, "/", 2)[0] + "/"
+	for name := range crMgr.redirectRecords[vsKey] {
+		shared := false
+		for otherKey, records := range crMgr.redirectRecords {
+			if otherKey != vsKey && strings.HasPrefix(otherKey, prefix) &&
+				records[name] {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			dg.RemoveRecord(name)
+		}
+	}
+	delete(crMgr.redirectRecords, vsKey)
+}
+
+// addRedirectRecords records the VirtualServer as redirecting HTTP until
+// its records are updated, so that the https redirect iRule and data group
+// it uses are not deleted for a VirtualServer processed concurrently.
+func (crMgr *CRManager) addRedirectRecords(vs *cisapiv1.VirtualServer) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	crMgr.intDgMutex.Lock()
+	defer crMgr.intDgMutex.Unlock()
+	if _, found := crMgr.redirectRecords[vsKey]; !found {
+		crMgr.redirectRecords[vsKey] = make(map[string]bool)
+	}
+}
+
+// deleteUnusedRedirect deletes the https redirect data group and iRule of
+// the partitions where no VirtualServer redirects HTTP, the iRule is
+// detached from their virtuals by detachUnusedRedirect. The partitions are
+// the ones of the shared objects. intDgMutex must be held.
+func (crMgr *CRManager) deleteUnusedRedirect() {
+	used := make(map[string]bool)
+	for vsKey := range crMgr.redirectRecords {
+		if vs, found := crMgr.getVirtualServer(vsKey); found {
+			used[crMgr.objectsPartition(
+				crMgr.getVirtualServerPartition(vs))] = true
+		}
+	}
+	for key := range crMgr.intDgMap {
+		if key.Name == HttpsRedirectDgName && !used[key.Partition] {
+			delete(crMgr.intDgMap, key)
+		}
+	}
+	ruleName := fmt.Sprintf("%s_%d", HttpRedirectIRuleName, DEFAULT_HTTPS_PORT)
+	crMgr.irulesMutex.Lock()
+	for key := range crMgr.irulesMap {
+		if key.Name == ruleName && !used[key.Partition] {
+			delete(crMgr.irulesMap, key)
+		}
+	}
+	crMgr.irulesMutex.Unlock()
+}
+
+// detachUnusedRedirect detaches the https redirect iRule deleted by
+// deleteUnusedRedirect from the virtuals. The workers detach it from the
+// virtuals of the VirtualServer synced, flushConfig from all the virtuals
+// as the virtuals of other resources are changed.
+func (crMgr *CRManager) detachUnusedRedirect(cfgs ResourceConfigs) {
+	ruleName := fmt.Sprintf("%s_%d", HttpRedirectIRuleName, DEFAULT_HTTPS_PORT)
+	crMgr.irulesMutex.Lock()
+	defer crMgr.irulesMutex.Unlock()
+	for _, rsCfg := range cfgs {
+		key := NameRef{
+			Name:      ruleName,
+			Partition: crMgr.objectsPartition(rsCfg.Virtual.Partition),
+		}
+		if _, found := crMgr.irulesMap[key]; !found {
+			rsCfg.Virtual.RemoveIRule(
+				crMgr.iRulePath(rsCfg.Virtual.Partition, ruleName))
+		}
+	}
+}
+
+// tlsHostDataGroups are the data groups with the records of the hosts of
+// passthrough, reencrypt and HSTS VirtualServers.
+var tlsHostDataGroups = []string{
+	PassthroughHostsDgName,
+	ReencryptHostsDgName,
+	ReencryptServerSslDgName,
+	HstsDgName,
+}
+
+// getHostPool returns the full path of the pool of the host in the TLS
+// data groups, the pool of the root path or else the first pool of the
+// VirtualServer.
+func getHostPool(vs *cisapiv1.VirtualServer, partition string) string {
+	var pools []cisapiv1.Pool
+	for _, pool := range getVirtualServerPools(vs) {
+		if nil == pool.Action {
+			pools = append(pools, pool)
+		}
+	}
+	if len(pools) == 0 {
+		return ""
+	}
+	pl := pools[0]
+	for _, pool := range pools {
+		if pool.Path == "" || pool.Path == "/" {
+			pl = pool
+			break
+		}
+	}
+	return fmt.Sprintf("/%s/%s/%s", partition, as3SharedApplication,
+		getVirtualServerPoolName(vs.ObjectMeta.Namespace, pl))
+}
+
+// updateHostDataGroup adds the records of the hosts of the VirtualServer to
+// dgMap, along with the records of the other VirtualServers in the
+// namespace. The records of the hosts no longer served by the VirtualServer
+// are removed, and the records of its hosts if data is empty. intDgMutex
+// must be held.
+func (crMgr *CRManager) updateHostDataGroup(
+	dgMap InternalDataGroupMap,
+	dgName string,
+	vs *cisapiv1.VirtualServer,
+	data string,
+	depsRemoved []ObjectDependency,
+) {
+	namespace := vs.ObjectMeta.Namespace
+	partition := crMgr.objectsPartition(crMgr.getVirtualServerPartition(vs))
+	mapKey := NameRef{
+		Name:      dgName,
+		Partition: partition,
+	}
+	dg := &InternalDataGroup{
+		Name:      dgName,
+		Partition: partition,
+	}
+	if oldDg, found := crMgr.intDgMap[mapKey][namespace]; found {
+		dg.Records = make(InternalDataGroupRecords, len(oldDg.Records))
+		copy(dg.Records, oldDg.Records)
+	}
+
+	for _, dep := range depsRemoved {
+		if dep.Kind == RuleDep {
+			host := strings.SplitN(dep.Name, "/", 2)[0]
+			dg.RemoveRecord(strings.ToLower(host))
+		}
+	}
+	for _, host := range getVirtualServerHosts(vs) {
+		if data == "" {
+			dg.RemoveRecord(strings.ToLower(host))
+		} else {
+			dg.AddOrUpdateRecord(strings.ToLower(host), data)
+		}
+	}
+
+	if len(dg.Records) > 0 {
+		dgMap[mapKey] = DataGroupNamespaceMap{namespace: dg}
+	}
+}
+
+// deleteHostDataGroupRecord removes the records of the hosts of the deleted
+// VirtualServer, so the records are not flattened from its namespace.
+func (crMgr *CRManager) deleteHostDataGroupRecord(
+	dgName string,
+	vs *cisapiv1.VirtualServer,
+) {
+	crMgr.intDgMutex.Lock()
+	defer crMgr.intDgMutex.Unlock()
+
+	partition := crMgr.objectsPartition(crMgr.getVirtualServerPartition(vs))
+
+	mapKey := NameRef{
+		Name:      dgName,
+		Partition: partition,
+	}
+	dg, found := crMgr.intDgMap[mapKey][vs.ObjectMeta.Namespace]
+	if !found {
+		return
+	}
+	for _, host := range getVirtualServerHosts(vs) {
+		dg.RemoveRecord(strings.ToLower(host))
+	}
+	if len(dg.Records) == 0 {
+		delete(crMgr.intDgMap[mapKey], vs.ObjectMeta.Namespace)
+	}
+}
+
+// sslPassthroughIRule forwards the TLS traffic of the passthrough hosts to
+// their pools without terminating TLS. The host is the server name of the
+// TLS ClientHello, the key in the data group is the host and the data is
+// the pool. The traffic of other hosts is terminated on BIG-IP.
+func sslPassthroughIRule() string {
+	return fmt.Sprintf(`
+		when CLIENT_ACCEPTED {
+			TCP::collect
+		}
+
+		when CLIENT_DATA {
+			# Byte 0 is the content type.
+			# Bytes 1-2 are the TLS version.
+			# Bytes 3-4 are the TLS payload length.
+			# Bytes 5-$tls_payload_len are the TLS payload.
+			binary scan [TCP::payload] cSS tls_content_type tls_version tls_payload_len
+
+			switch $tls_version {
+				"769" -
+				"770" -
+				"771" {
+					# Content type of 22 indicates the TLS payload contains a handshake.
+					if { $tls_content_type == 22 } {
+						# Byte 5 (the first byte of the handshake) indicates the handshake
+						# record type, and a value of 1 signifies that the handshake record is
+						# a ClientHello.
+						binary scan [TCP::payload] @5c tls_handshake_record_type
+						if { $tls_handshake_record_type == 1 } {
+							# Byte 43 is the session ID length.  Following this are three
+							# variable-length fields which we shall skip over.
+							set record_offset 43
+
+							# Skip the session ID.
+							binary scan [TCP::payload] @${record_offset}c tls_session_id_len
+							incr record_offset [expr {1 + $tls_session_id_len}]
+
+							# Skip the cipher_suites field.
+							binary scan [TCP::payload] @${record_offset}S tls_cipher_suites_len
+							incr record_offset [expr {2 + $tls_cipher_suites_len}]
+
+							# Skip the compression_methods field.
+							binary scan [TCP::payload] @${record_offset}c tls_compression_methods_len
+							incr record_offset [expr {1 + $tls_compression_methods_len}]
+
+							# Get the number of extensions, and store the extensions.
+							binary scan [TCP::payload] @${record_offset}S tls_extensions_len
+							incr record_offset 2
+							binary scan [TCP::payload] @${record_offset}a* tls_extensions
+
+							for { set extension_start 0 }
+									{ $tls_extensions_len - $extension_start == abs($tls_extensions_len - $extension_start) }
+									{ incr extension_start 4 } {
+								# Bytes 0-1 of the extension are the extension type.
+								# Bytes 2-3 of the extension are the extension length.
+								binary scan $tls_extensions @${extension_start}SS extension_type extension_len
+
+								# Extension type 00 is the ServerName extension.
+								if { $extension_type == "00" } {
+									# Byte 6 of the extension is the SNI type.
+									set sni_type_offset [expr {$extension_start + 6}]
+									binary scan $tls_extensions @${sni_type_offset}S sni_type
+
+									# Type 0 is host_name.
+									if { $sni_type == "0" } {
+										# Bytes 7-8 of the extension are the SNI data (host_name)
+										# length.
+										set sni_len_offset [expr {$extension_start + 7}]
+										binary scan $tls_extensions @${sni_len_offset}S sni_len
+
+										# Bytes 9-$sni_len are the SNI data (host_name).
+										set sni_start [expr {$extension_start + 9}]
+										binary scan $tls_extensions @${sni_start}A${sni_len} tls_servername
+									}
+								}
+
+								incr extension_start $extension_len
+							}
+						}
+					}
+				}
+			}
+
+			if { [info exists tls_servername] } {
+				set servername [string tolower $tls_servername]
+				set passthrough_pool [class match -value $servername equals %[1]s]
+				# Check if a wildcard host like *.example.com matches the
+				# suffix of the host
+				set suffix $servername
+				while {$passthrough_pool == ""} {
+					set dot [string first "." $suffix]
+					if {$dot == -1} {
+						break
+					}
+					set suffix [string range $suffix [expr {$dot+1}] end]
+					set passthrough_pool [class match -value "*.$suffix" equals %[1]s]
+				}
+				if {$passthrough_pool != ""} {
+					SSL::disable
+					HTTP::disable
+					pool $passthrough_pool
+				}
+			}
+
+			TCP::release
+		}`, PassthroughHostsDgName)
+}
+
+// matchHostProc returns the data of the host in a data group keyed by host,
+// or else of a wildcard host like *.example.com matching the host.
+const matchHostProc = `
+		proc match_host {host dg} {
+			set data [class match -value $host equals $dg]
+			# Check if a wildcard host like *.example.com matches the
+			# suffix of the host
+			set suffix $host
+			while {$data == ""} {
+				set dot [string first "." $suffix]
+				if {$dot == -1} {
+					break
+				}
+				set suffix [string range $suffix [expr {$dot+1}] end]
+				set data [class match -value "*.$suffix" equals $dg]
+			}
+			return $data
+		}`
+
+// sslReencryptIRule encrypts the traffic of the reencrypt hosts to the pool
+// members with the server SSL profile of the host. The server side of other
+// hosts is not encrypted. The key in the data groups is the host, the data
+// is the pool or the server SSL profile.
+func sslReencryptIRule() string {
+	return fmt.Sprintf(`%[3]s
+
+		when HTTP_REQUEST {
+			set reencrypt_host [string tolower [getfield [HTTP::host] ":" 1]]
+			if {[call match_host $reencrypt_host %[1]s] == ""} {
+				SSL::disable serverside
+			} else {
+				SSL::enable serverside
+			}
+		}
+
+		when SERVER_CONNECTED {
+			if {[info exists reencrypt_host]} {
+				set serverssl [call match_host $reencrypt_host %[2]s]
+				if {$serverssl != ""} {
+					SSL::profile $serverssl
+				}
+			}
+		}`, ReencryptHostsDgName, ReencryptServerSslDgName, matchHostProc)
+}
+
+// hstsIRule inserts the Strict-Transport-Security header in the responses
+// of the hosts with HSTS. The key in the data group is the host and the
+// data is the value of the header.
+func hstsIRule() string {
+	return fmt.Sprintf(`%[2]s
+
+		when HTTP_REQUEST {
+			set hsts_header [call match_host [string tolower [getfield [HTTP::host] ":" 1]] %[1]s]
+		}
+
+		when HTTP_RESPONSE {
+			if {[info exists hsts_header] && $hsts_header != ""} {
+				HTTP::header replace Strict-Transport-Security $hsts_header
+			}
+		}`, HstsDgName, matchHostProc)
+}
+
+// hstsHeader returns the value of the Strict-Transport-Security header, or
+// an empty string when HSTS is not enabled.
+func hstsHeader(hsts *cisapiv1.HSTS) string {
+	if nil == hsts || !hsts.Enabled {
+		return ""
+	}
+	maxAge := DefaultHSTSMaxAge
+	if nil != hsts.MaxAge {
+		maxAge = *hsts.MaxAge
+	}
+	header := fmt.Sprintf("max-age=%d", maxAge)
+	if hsts.IncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		header += "; preload"
+	}
+	return header
+}
+
 func httpRedirectIRule(port int32) string {
 	// The key in the data group is the host name or * to match all.
 	// The data is a list of paths for the host delimited by '|' or '/' for all.
+	// A path is followed by the redirect code and host delimited by ' '
+	// unless they are the defaults.
 	iRuleCode := fmt.Sprintf(`
+		proc hostname {host} {
+			# An IPv6 address is enclosed in brackets followed by the port
+			if {[string index $host 0] eq "\["} {
+				return [string range $host 0 [string first "\]" $host]]
+			}
+			return [getfield $host ":" 1]
+		}
+
+		proc redirect {fields port} {
+			set code [lindex $fields 1]
+			if {$code == ""} {
+				set code %[2]d
+			}
+			set host [lindex $fields 2]
+			if {$host == ""} {
+				set host [call hostname [HTTP::host]]:$port
+			}
+			HTTP::respond $code Location "https://$host[HTTP::uri]"
+		}
+
 		when HTTP_REQUEST {
 			
 			# check if there is an entry in data-groups to accept requests from all domains.
 			# */ represents [* -> Any host / -> default path]
 			set allHosts [class match -value "*/" equals https_redirect_dg]
 			if {$allHosts != ""} {
-				HTTP::redirect https://[getfield [HTTP::host] ":" 1]:443[HTTP::uri]
+				call redirect [split [lindex [split $allHosts "|"] 0] " "] %[1]d
 				return
 			}
 			set host [HTTP::host]
@@ -298,6 +1480,20 @@ func httpRedirectIRule(port int32) string {
 					append hosts $host "/"
 					set paths [class match -value $hosts equals https_redirect_dg] 
 				}
+				# Check if a wildcard host like *.example.com matches the
+				# suffix of the host
+				set suffix $host
+				while {$paths == ""} {
+					set dot [string first "." $suffix]
+					if {$dot == -1} {
+						break
+					}
+					set suffix [string range $suffix [expr {$dot+1}] end]
+					set paths [class match -value "*.$suffix" equals https_redirect_dg]
+					if {$paths == ""} {
+						set paths [class match -value "*.$suffix/" equals https_redirect_dg]
+					}
+				}
 				# Trim the uri to last slash
 				if {$paths == ""} {
 					set host [
@@ -311,21 +1507,18 @@ func httpRedirectIRule(port int32) string {
 				}
 			}
 			if {$paths != ""} {
-				set redir 0
-				set prefix ""
 				foreach s [split $paths "|"] {
+					set fields [split $s " "]
 					# See if the request path starts with the prefix
-					append prefix "^" $s "($|/*)"
+					set prefix ""
+					append prefix "^" [lindex $fields 0] "($|/*)"
 					if {[HTTP::path] matches_regex $prefix} {
-						set redir 1
+						call redirect $fields %[1]d
 						break
 					}
 				}
-				if {$redir == 1} {
-					HTTP::redirect https://[getfield [HTTP::host] ":" 1]:%d[HTTP::uri]
-				}
 			}
-		}`, port)
+		}`, port, DefaultRedirectCode)
 
 	return iRuleCode
 }
@@ -340,10 +1533,16 @@ type ServiceFwdRuleMap map[serviceQueueKey]HostFwdRuleMap
 // key is fqdn host name, data is map of paths.
 type HostFwdRuleMap map[string]FwdRuleMap
 
-// key is path regex, data unused. Using a map as go doesn't have a set type.
-type FwdRuleMap map[string]bool
+// key is path regex, data is the data of the record of the path.
+type FwdRuleMap map[string]string
 
-func (sfrm ServiceFwdRuleMap) AddEntry(ns, svc, host, path string) {
+// AddEntry adds the redirect of the path of the host, code and redirectHost
+// are the defaults when empty.
+func (sfrm ServiceFwdRuleMap) AddEntry(
+	ns, svc, host, path string,
+	code int32,
+	redirectHost string,
+) {
 	if path == "" {
 		path = "/"
 	}
@@ -358,9 +1557,19 @@ func (sfrm ServiceFwdRuleMap) AddEntry(ns, svc, host, path string) {
 		frm = make(FwdRuleMap)
 		hfrm[host] = frm
 	}
-	if _, found = frm[path]; !found {
-		frm[path] = true
+	frm[path] = redirectRecordData(path, code, redirectHost)
+}
+
+// redirectRecordData returns the data of the redirect record of the path,
+// the path is followed by the code and host unless they are the defaults.
+func redirectRecordData(path string, code int32, host string) string {
+	if code == 0 {
+		code = DefaultRedirectCode
+	}
+	if code == DefaultRedirectCode && host == "" {
+		return path
 	}
+	return strings.TrimSpace(fmt.Sprintf("%s %d %s", path, code, host))
 }
 
 func (sfrm ServiceFwdRuleMap) AddToDataGroup(dgMap DataGroupNamespaceMap) {
@@ -375,8 +1584,8 @@ func (sfrm ServiceFwdRuleMap) AddToDataGroup(dgMap DataGroupNamespaceMap) {
 			dgMap[skey.Namespace] = nsGrp
 		}
 		for host, pathMap := range hostMap {
-			for path, _ := range pathMap {
-				nsGrp.AddOrUpdateRecord(host+path, path)
+			for path, data := range pathMap {
+				nsGrp.AddOrUpdateRecord(host+path, data)
 			}
 
 		}
@@ -385,13 +1594,11 @@ func (sfrm ServiceFwdRuleMap) AddToDataGroup(dgMap DataGroupNamespaceMap) {
 
 // Update the datagroups cache, indicating if something
 // had changed by updating 'stats', which should rewrite the config.
+// intDgMutex must be held.
 func (crMgr *CRManager) syncDataGroups(
 	dgMap InternalDataGroupMap,
 	namespace string,
 ) {
-	crMgr.intDgMutex.Lock()
-	defer crMgr.intDgMutex.Unlock()
-
 	// Add new or modified data group records
 	for mapKey, grp := range dgMap {
 		nsDg, found := crMgr.intDgMap[mapKey]
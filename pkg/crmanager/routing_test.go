@@ -0,0 +1,991 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Routing Tests", func() {
+	Context("Wildcard Host", func() {
+		It("Matches host by suffix", func() {
+			vs := test.NewVirtualServer(
+				"SampleVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host: "*.apps.example.com",
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+			rules := processVirtualServerRules(vs)
+			Expect(len(*rules)).To(Equal(1))
+			hostCondition := (*rules)[0].Conditions[0]
+			Expect(hostCondition.Host).To(BeTrue())
+			Expect(hostCondition.EndsWith).To(BeTrue())
+			Expect(hostCondition.Equals).To(BeFalse())
+			Expect(hostCondition.Values).To(Equal([]string{".apps.example.com"}))
+
+			as3Rl := &as3Rule{}
+			createRuleCondition((*rules)[0], as3Rl, 80)
+			Expect(as3Rl.Conditions[0].All.Operand).To(Equal("ends-with"))
+		})
+
+		It("Orders exact host rules before wildcard host rules", func() {
+			exact, _ := createRule("test.example.com/foo", "pool1", "exact",
+				PathMatchPrefix)
+			wildcard, _ := createRule("*.example.com/foo/bar", "pool2",
+				"wildcard", PathMatchPrefix)
+			longWildcard, _ := createRule("*.apps.example.com/foo", "pool3",
+				"longWildcard", PathMatchPrefix)
+			rules := Rules{wildcard, longWildcard, exact}
+			sort.Sort(rules)
+			var names []string
+			for _, rl := range rules {
+				names = append(names, rl.Name)
+			}
+			Expect(names).To(Equal(
+				[]string{"exact", "longWildcard", "wildcard"}))
+		})
+
+		It("Detects overlapping wildcard hosts", func() {
+			Expect(wildcardHostsOverlap("*.example.com",
+				"*.apps.example.com")).To(BeTrue())
+			Expect(wildcardHostsOverlap("*.apps.example.com",
+				"*.example.com")).To(BeTrue())
+			Expect(wildcardHostsOverlap("*.example.com",
+				"*.example.com")).To(BeFalse())
+			Expect(wildcardHostsOverlap("*.example.com",
+				"*.example.org")).To(BeFalse())
+			Expect(wildcardHostsOverlap("*.example.com",
+				"apps.example.com")).To(BeFalse())
+		})
+	})
+
+	Context("Path Match Type", func() {
+		var vs *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			vs = test.NewVirtualServer(
+				"SampleVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host: "test.com",
+					Pools: []cisapiv1.Pool{
+						{Path: "/ap.*", Service: "svc3", ServicePort: intstr.FromInt(80),
+							PathMatchType: PathMatchRegex},
+						{Path: "/api/", Service: "svc2", ServicePort: intstr.FromInt(80)},
+						{Path: "/api", Service: "svc1", ServicePort: intstr.FromInt(80),
+							PathMatchType: PathMatchExact},
+					},
+				},
+			)
+		})
+
+		It("Creates conditions for exact, prefix and regex paths", func() {
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(3))
+
+			exact := rules[0]
+			Expect(getRulePool(exact)).To(Equal("default_svc1_80"))
+			Expect(exact.FullURI).To(Equal("test.com/api"))
+			Expect(exact.Conditions[1].Path).To(BeTrue())
+			Expect(exact.Conditions[1].Equals).To(BeTrue())
+			Expect(exact.Conditions[1].Values).To(Equal([]string{"/api"}))
+
+			prefix := rules[1]
+			Expect(getRulePool(prefix)).To(Equal("default_svc2_80"))
+			Expect(prefix.Conditions[1].PathSegment).To(BeTrue())
+			Expect(prefix.Conditions[1].Values).To(Equal([]string{"api"}))
+
+			regex := rules[2]
+			Expect(getRulePool(regex)).To(Equal("default_svc3_80"))
+			Expect(regex.Conditions[1].Path).To(BeTrue())
+			Expect(regex.Conditions[1].Matches).To(BeTrue())
+			Expect(regex.Conditions[1].Values).To(Equal([]string{"/ap.*"}))
+
+			names := map[string]bool{}
+			for _, rl := range rules {
+				names[rl.Name] = true
+			}
+			Expect(len(names)).To(Equal(3), "Rule names should be unique")
+
+			as3Rl := &as3Rule{}
+			createRuleCondition(exact, as3Rl, 80)
+			Expect(as3Rl.Conditions[1].Path.Operand).To(Equal("equals"))
+			as3Rl = &as3Rule{}
+			createRuleCondition(regex, as3Rl, 80)
+			Expect(as3Rl.Conditions[1].Path.Operand).To(Equal("matches"))
+		})
+
+		It("Rejects invalid paths", func() {
+			Expect(validatePoolPath(cisapiv1.Pool{Path: "/api",
+				PathMatchType: PathMatchExact})).To(BeNil())
+			Expect(validatePoolPath(cisapiv1.Pool{Path: "/ap[",
+				PathMatchType: PathMatchRegex})).NotTo(BeNil())
+			Expect(validatePoolPath(cisapiv1.Pool{Path: "/api",
+				PathMatchType: "suffix"})).NotTo(BeNil())
+		})
+	})
+
+	Context("Rule Ordering", func() {
+		var pools []cisapiv1.Pool
+
+		BeforeEach(func() {
+			pools = []cisapiv1.Pool{
+				{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/api", Service: "svc2", ServicePort: intstr.FromInt(80)},
+				{Path: "/api/v2", Service: "svc3", ServicePort: intstr.FromInt(80)},
+				{Path: "/apis", Service: "svc4", ServicePort: intstr.FromInt(80)},
+				{Path: "/foo", Service: "svc5", ServicePort: intstr.FromInt(80)},
+			}
+		})
+
+		policyFor := func(pools []cisapiv1.Pool) []byte {
+			mockCRM := newMockCRManager()
+			vs := test.NewVirtualServer(
+				"SampleVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host:                 "test.com",
+					VirtualServerAddress: "1.2.3.4",
+					Pools:                pools,
+				},
+			)
+			rsCfg, err := mockCRM.createRSConfigFromVirtualServer(vs,
+				portStruct{protocol: "http", port: DEFAULT_HTTP_PORT})
+			Expect(err).To(BeNil())
+			policy, err := json.Marshal(rsCfg.Policies)
+			Expect(err).To(BeNil())
+			return policy
+		}
+
+		It("Orders rules by path specificity", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com", Pools: pools})
+			rules := *processVirtualServerRules(vs)
+			var uris []string
+			for i, rl := range rules {
+				Expect(rl.Ordinal).To(Equal(i))
+				uris = append(uris, rl.FullURI)
+			}
+			Expect(uris).To(Equal([]string{"test.com/api/v2", "test.com/apis",
+				"test.com/api", "test.com/foo", "test.com/"}))
+		})
+
+		It("Creates identical policies for any order of pools", func() {
+			policy := policyFor(pools)
+			reversed := make([]cisapiv1.Pool, len(pools))
+			for i, pl := range pools {
+				reversed[len(pools)-1-i] = pl
+			}
+			Expect(policyFor(reversed)).To(Equal(policy))
+			shuffled := []cisapiv1.Pool{pools[3], pools[0], pools[4],
+				pools[2], pools[1]}
+			Expect(policyFor(shuffled)).To(Equal(policy))
+		})
+
+		It("Keeps ordinals after merging rules", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com", Pools: pools})
+			rules := *processVirtualServerRules(vs)
+			shuffled := Rules{rules[4], rules[1], rules[3], rules[0], rules[2]}
+			rsCfg := &ResourceConfig{}
+			rsCfg.SetPolicy(*createPolicy(shuffled, "policy", "test"))
+			rsCfg.MergeRules(map[NameRef]map[string]mergedRuleEntry{})
+
+			policy := rsCfg.FindPolicy("forwarding")
+			for i, rl := range policy.Rules {
+				Expect(rl.Ordinal).To(Equal(i))
+				Expect(rl.Name).To(Equal(rules[i].Name))
+			}
+		})
+	})
+
+	Context("HTTP Redirect", func() {
+		It("Encodes the redirect code and host in the records", func() {
+			fwdRules := NewServiceFwdRuleMap()
+			fwdRules.AddEntry("default", "svc1", "foo.com", "/", 0, "")
+			fwdRules.AddEntry("default", "svc2", "bar.com", "/app", 301, "")
+			fwdRules.AddEntry("default", "svc3", "baz.com", "", 308,
+				"baz.com:8443")
+			dgMap := make(DataGroupNamespaceMap)
+			fwdRules.AddToDataGroup(dgMap)
+			records := dgMap["default"].Records
+			sort.Sort(records)
+			Expect(records).To(Equal(InternalDataGroupRecords{
+				{Name: "bar.com/app", Data: "/app 301"},
+				{Name: "baz.com/", Data: "/ 308 baz.com:8443"},
+				{Name: "foo.com/", Data: "/"},
+			}))
+		})
+
+		It("Keeps the code of each namespace in merged records", func() {
+			dgMap := make(DataGroupNamespaceMap)
+			for ns, code := range map[string]int32{"ns1": 0, "ns2": 307} {
+				fwdRules := NewServiceFwdRuleMap()
+				fwdRules.AddEntry(ns, "svc1", "foo.com", "/", code, "")
+				fwdRules.AddToDataGroup(dgMap)
+			}
+			Expect(dgMap.FlattenNamespaces().Records).To(Equal(
+				InternalDataGroupRecords{{Name: "foo.com/", Data: "/|/ 307"}}))
+		})
+
+		It("Redirects with the code of the record", func() {
+			iRule := httpRedirectIRule(8443)
+			Expect(iRule).To(ContainSubstring("set code 302"))
+			Expect(iRule).To(ContainSubstring("call redirect $fields 8443"))
+			Expect(iRule).To(ContainSubstring(
+				"set host [call hostname [HTTP::host]]:$port"),
+				"The port should be stripped from IPv6 hosts in brackets")
+		})
+
+		It("Rejects invalid redirects", func() {
+			Expect(validateRedirect(0, "")).To(Succeed())
+			Expect(validateRedirect(301, "example.com:8443")).To(Succeed())
+			Expect(validateRedirect(200, "")).NotTo(Succeed())
+			Expect(validateRedirect(308, "example.com/app")).NotTo(Succeed())
+		})
+	})
+
+	Context("Rewrite", func() {
+		rewritePools := func() []cisapiv1.Pool {
+			return []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Rewrite: &cisapiv1.Rewrite{TargetPath: "/bar",
+						TargetHost: "internal.com"}},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+		}
+
+		It("Rewrites the path at the start of the URI", func() {
+			pl := cisapiv1.Pool{Path: "/foo.v1",
+				Rewrite: &cisapiv1.Rewrite{TargetPath: "/bar"}}
+			Expect(rewritePathValue(pl)).To(Equal(
+				`tcl:[regsub {^/foo\.v1} [HTTP::uri] {/bar}]`))
+			pl.Path = "/"
+			Expect(rewritePathValue(pl)).To(Equal(
+				"tcl:[regsub {^/} [HTTP::uri] {/bar/}]"))
+			pl.Path = "/api/v[0-9]+"
+			pl.PathMatchType = PathMatchRegex
+			Expect(rewritePathValue(pl)).To(Equal(
+				"tcl:[regsub {^/api/v[0-9]+} [HTTP::uri] {/bar}]"))
+		})
+
+		It("Creates the rewrite rule with the conditions of the path", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Pools: rewritePools()[:1]})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(2))
+			var fwdRule, rwRule *Rule
+			for _, rl := range rules {
+				if isRewriteRule(rl) {
+					rwRule = rl
+				} else {
+					fwdRule = rl
+				}
+			}
+			Expect(rwRule.Name).To(Equal(urlRewriteRulePrefix + fwdRule.Name))
+			Expect(rwRule.FullURI).To(Equal(fwdRule.FullURI))
+			Expect(rwRule.Conditions).To(Equal(fwdRule.Conditions))
+			Expect(rwRule.Actions).To(Equal([]*action{
+				{Name: "0", HTTPHost: true, Replace: true, Request: true,
+					Value: "internal.com"},
+				{Name: "1", HTTPURI: true, Replace: true, Request: true,
+					Value: "tcl:[regsub {^/foo} [HTTP::uri] {/bar}]"},
+			}))
+		})
+
+		It("Merges and unmerges the rewrite of pools with the same path",
+			func() {
+				vs := test.NewVirtualServer("SampleVS", "default",
+					cisapiv1.VirtualServerSpec{Host: "test.com",
+						Pools: rewritePools()})
+				rules := *processVirtualServerRules(vs)
+				Expect(len(rules)).To(Equal(2))
+				rsCfg := &ResourceConfig{}
+				rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+				rsCfg.SetPolicy(*createPolicy(rules, "policy", "test"))
+				mergedRulesMap := make(map[NameRef]map[string]mergedRuleEntry)
+				rsCfg.MergeRules(mergedRulesMap)
+
+				policy := rsCfg.FindPolicy("forwarding")
+				Expect(len(policy.Rules)).To(Equal(1))
+				fwdRule := policy.Rules[0]
+				Expect(isRewriteRule(fwdRule)).To(BeFalse())
+				Expect(getRulePool(fwdRule)).To(Equal("default_svc2_80"))
+				Expect(len(fwdRule.Actions)).To(Equal(3))
+				rwName := urlRewriteRulePrefix + formatVirtualServerRuleName(
+					"test.com", "/foo", "default_svc1_80")
+				Expect(mergedRulesMap[rsCfg.GetRef()]).To(HaveKey(rwName))
+
+				// The config is copied on every sync
+				newCfg := &ResourceConfig{}
+				rsCfg.DeepCopyInto(newCfg)
+				Expect(newCfg.UnmergeRule(rwName, mergedRulesMap)).To(BeTrue())
+				policy = newCfg.FindPolicy("forwarding")
+				Expect(len(policy.Rules)).To(Equal(1))
+				Expect(policy.Rules[0].Actions).To(Equal([]*action{{
+					Name: "0", Forward: true, Request: true,
+					Pool: "default_svc2_80"}}))
+				Expect(mergedRulesMap).To(BeEmpty())
+			})
+
+		Context("Unmerging", func() {
+			var rsCfg *ResourceConfig
+			var mergedRulesMap map[NameRef]map[string]mergedRuleEntry
+			var rwName, fwdName string
+
+			BeforeEach(func() {
+				vs := test.NewVirtualServer("SampleVS", "default",
+					cisapiv1.VirtualServerSpec{Host: "test.com",
+						Pools: rewritePools()})
+				rsCfg = &ResourceConfig{}
+				rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+				rsCfg.SetPolicy(*createPolicy(*processVirtualServerRules(vs),
+					"policy", "test"))
+				mergedRulesMap = make(map[NameRef]map[string]mergedRuleEntry)
+				rsCfg.MergeRules(mergedRulesMap)
+				fwdName = formatVirtualServerRuleName("test.com", "/foo",
+					"default_svc2_80")
+				rwName = urlRewriteRulePrefix + formatVirtualServerRuleName(
+					"test.com", "/foo", "default_svc1_80")
+				Expect(mergedRulesMap[rsCfg.GetRef()]).To(HaveKey(rwName))
+				Expect(mergedRulesMap[rsCfg.GetRef()]).To(HaveKey(fwdName))
+			})
+
+			It("Cleans the entries after the policy is removed", func() {
+				rsCfg.RemovePolicy(*rsCfg.FindPolicy("forwarding"))
+				Expect(rsCfg.UnmergeRule(rwName, mergedRulesMap)).To(BeFalse())
+				Expect(mergedRulesMap).To(BeEmpty())
+			})
+
+			It("Cleans the entries of the merger after the policy is removed",
+				func() {
+					rsCfg.RemovePolicy(*rsCfg.FindPolicy("forwarding"))
+					Expect(rsCfg.UnmergeRule(fwdName, mergedRulesMap)).To(
+						BeFalse())
+					Expect(mergedRulesMap).To(BeEmpty())
+				})
+
+			It("Cleans the entries after the merger rule is deleted", func() {
+				policy := rsCfg.FindPolicy("forwarding")
+				Expect(policy.RemoveRuleAt(0)).To(BeTrue())
+				rsCfg.SetPolicy(*policy)
+				Expect(rsCfg.UnmergeRule(rwName, mergedRulesMap)).To(BeFalse())
+				Expect(mergedRulesMap).To(BeEmpty())
+			})
+
+			It("Cleans the entry of a rule whose merger is unmerged", func() {
+				delete(mergedRulesMap[rsCfg.GetRef()], fwdName)
+				Expect(rsCfg.UnmergeRule(rwName, mergedRulesMap)).To(BeFalse())
+				Expect(mergedRulesMap).To(BeEmpty())
+				Expect(rsCfg.FindPolicy("forwarding").Rules).To(HaveLen(1))
+			})
+
+			It("Removes each merged action once", func() {
+				policy := rsCfg.FindPolicy("forwarding")
+				fwdRule := policy.Rules[0]
+				// The forwarding rule has an action equal to a merged one
+				fwdRule.Actions = append([]*action{
+					fwdRule.Actions[len(fwdRule.Actions)-1]},
+					fwdRule.Actions...)
+				Expect(rsCfg.UnmergeRule(rwName, mergedRulesMap)).To(BeTrue())
+				Expect(len(rsCfg.FindPolicy("forwarding").Rules[0].Actions)).To(
+					Equal(2))
+			})
+		})
+
+		It("Merges the rewrite with the conditions in another order", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Pools: rewritePools()})
+			rules := *processVirtualServerRules(vs)
+			for _, rl := range rules {
+				if isRewriteRule(rl) {
+					conds := rl.Conditions
+					for i, j := 0, len(conds)-1; i < j; i, j = i+1, j-1 {
+						conds[i], conds[j] = conds[j], conds[i]
+					}
+				}
+			}
+			var names []string
+			for _, rl := range rules {
+				for _, c := range rl.Conditions {
+					names = append(names, c.Name)
+				}
+			}
+			rsCfg := &ResourceConfig{}
+			rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+			rsCfg.SetPolicy(*createPolicy(append(Rules{}, rules...),
+				"policy", "test"))
+			rsCfg.MergeRules(make(map[NameRef]map[string]mergedRuleEntry))
+
+			policy := rsCfg.FindPolicy("forwarding")
+			Expect(len(policy.Rules)).To(Equal(1))
+			Expect(len(policy.Rules[0].Actions)).To(Equal(3))
+			var mergedNames []string
+			for _, rl := range rules {
+				for _, c := range rl.Conditions {
+					mergedNames = append(mergedNames, c.Name)
+				}
+			}
+			Expect(mergedNames).To(Equal(names),
+				"Condition names should not change")
+		})
+
+		It("Rejects invalid rewrites", func() {
+			pl := cisapiv1.Pool{Path: "/foo"}
+			Expect(validatePoolRewrite(pl)).To(Succeed())
+			pl.Rewrite = &cisapiv1.Rewrite{TargetPath: "/bar",
+				TargetHost: "example.com:8080"}
+			Expect(validatePoolRewrite(pl)).To(Succeed())
+			pl.Rewrite.TargetPath = "bar"
+			Expect(validatePoolRewrite(pl)).NotTo(Succeed())
+			pl.Rewrite.TargetPath = "/bar}"
+			Expect(validatePoolRewrite(pl)).NotTo(Succeed())
+			pl.Rewrite = &cisapiv1.Rewrite{TargetHost: "example.com/app"}
+			Expect(validatePoolRewrite(pl)).NotTo(Succeed())
+			pl.Rewrite = &cisapiv1.Rewrite{TargetPath: "/bar"}
+			pl.HostRewrite = "example.com:8080"
+			Expect(validatePoolRewrite(pl)).To(Succeed())
+			pl.Rewrite.TargetHost = "example.com"
+			Expect(validatePoolRewrite(pl)).NotTo(Succeed())
+			pl.Rewrite = nil
+			pl.HostRewrite = "example.com/app"
+			Expect(validatePoolRewrite(pl)).NotTo(Succeed())
+		})
+	})
+
+	Context("Merging rules", func() {
+		conditions := func(path string) []*condition {
+			return []*condition{
+				{Name: "0", Equals: true, Host: true, HTTPHost: true,
+					Request: true, Values: []string{"test.com"}},
+				{Name: "1", Equals: true, HTTPURI: true, PathSegment: true,
+					Index: 1, Request: true, Values: []string{path}},
+			}
+		}
+		forwardRule := func(path string) *Rule {
+			return &Rule{Name: "forward-" + path, Conditions: conditions(path),
+				Actions: []*action{{Name: "0", Forward: true, Request: true,
+					Pool: "default_" + path + "_80"}}}
+		}
+		rewriteRule := func(i int) *Rule {
+			return &Rule{Name: fmt.Sprintf("%sfoo-%d", urlRewriteRulePrefix, i),
+				Conditions: conditions("foo"),
+				Actions: []*action{{Name: "0", HTTPURI: true, Replace: true,
+					Request: true, Value: fmt.Sprintf("/bar%d", i)}}}
+		}
+
+		for _, mergeable := range []int{3, 4, 5} {
+			mergeable := mergeable
+			It(fmt.Sprintf("Merges %d rewrite rules into the forwarding rule",
+				mergeable), func() {
+				// The rewrite rules of /foo are interleaved with the
+				// forwarding rules of other paths
+				rules := Rules{forwardRule("foo")}
+				var others []string
+				for i := 0; i < mergeable; i++ {
+					other := fmt.Sprintf("other%d", i)
+					others = append(others, "forward-"+other)
+					rules = append(rules, rewriteRule(i), forwardRule(other))
+				}
+				rsCfg := &ResourceConfig{}
+				rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+				rsCfg.SetPolicy(*createPolicy(rules, "policy", "test"))
+				mergedRulesMap := make(map[NameRef]map[string]mergedRuleEntry)
+				rsCfg.MergeRules(mergedRulesMap)
+
+				policy := rsCfg.FindPolicy("forwarding")
+				var names []string
+				for i, rl := range policy.Rules {
+					Expect(rl.Ordinal).To(Equal(i))
+					names = append(names, rl.Name)
+				}
+				Expect(names).To(ConsistOf(append(others, "forward-foo")))
+
+				fwdRule := policy.Rules[0]
+				for _, rl := range policy.Rules {
+					if rl.Name == "forward-foo" {
+						fwdRule = rl
+					}
+				}
+				Expect(getRulePool(fwdRule)).To(Equal("default_foo_80"))
+				Expect(len(fwdRule.Actions)).To(Equal(1 + mergeable))
+
+				entries := mergedRulesMap[rsCfg.GetRef()]
+				Expect(entries["forward-foo"].MergedActions).To(
+					HaveLen(mergeable))
+				for i := 0; i < mergeable; i++ {
+					rwName := rewriteRule(i).Name
+					Expect(entries).To(HaveKey(rwName))
+					Expect(entries["forward-foo"].MergedActions[rwName]).To(
+						Equal([]*action{rewriteRule(i).Actions[0]}))
+				}
+				for _, other := range others {
+					Expect(entries).NotTo(HaveKey(other))
+				}
+			})
+		}
+	})
+
+	Context("Method and query parameter match", func() {
+		matchPools := func() []cisapiv1.Pool {
+			return []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					MatchMethod:      []string{"post", "GET"},
+					MatchQueryParams: []string{"v=2", "env=test", "v=3"}},
+			}
+		}
+
+		It("Creates the method and query parameter conditions", func() {
+			conds := createMatchConditions(matchPools()[1], 2)
+			Expect(conds).To(Equal([]*condition{
+				{Name: "2", Equals: true, HTTPMethod: true, Request: true,
+					Values: []string{"GET", "POST"}},
+				{Name: "3", Equals: true, HTTPURI: true, QueryParameter: true,
+					ParameterName: "env", Request: true,
+					Values: []string{"test"}},
+				{Name: "4", Equals: true, HTTPURI: true, QueryParameter: true,
+					ParameterName: "v", Request: true,
+					Values: []string{"2", "3"}},
+			}))
+			Expect(createMatchConditions(matchPools()[0], 2)).To(BeEmpty())
+		})
+
+		It("Orders the rules matching methods and query parameters first",
+			func() {
+				vs := test.NewVirtualServer("SampleVS", "default",
+					cisapiv1.VirtualServerSpec{Host: "test.com",
+						Pools: matchPools()})
+				rules := *processVirtualServerRules(vs)
+				Expect(len(rules)).To(Equal(2))
+				Expect(getRulePool(rules[0])).To(Equal("default_svc2_80"))
+				Expect(matchConditionCount(rules[0])).To(Equal(3))
+				Expect(getRulePool(rules[1])).To(Equal("default_svc1_80"))
+				Expect(rules[0].Name).NotTo(Equal(rules[1].Name))
+			})
+
+		It("Merges only the rules matching the same requests", func() {
+			pools := matchPools()
+			pools[1].Rewrite = &cisapiv1.Rewrite{TargetPath: "/bar"}
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com", Pools: pools})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(3))
+			rsCfg := &ResourceConfig{}
+			rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+			rsCfg.SetPolicy(*createPolicy(rules, "policy", "test"))
+			rsCfg.MergeRules(map[NameRef]map[string]mergedRuleEntry{})
+
+			policy := rsCfg.FindPolicy("forwarding")
+			Expect(len(policy.Rules)).To(Equal(2))
+			for _, rl := range policy.Rules {
+				Expect(isRewriteRule(rl)).To(BeFalse())
+				if getRulePool(rl) == "default_svc2_80" {
+					Expect(len(rl.Actions)).To(Equal(2))
+				} else {
+					Expect(len(rl.Actions)).To(Equal(1))
+				}
+			}
+		})
+
+		It("Creates the AS3 conditions", func() {
+			rl := &Rule{Conditions: createMatchConditions(matchPools()[1], 0)}
+			rulesData := &as3Rule{}
+			createRuleCondition(rl, rulesData, 80)
+			Expect(rulesData.Conditions).To(Equal([]*as3Condition{
+				{Type: "httpMethod", Event: "request",
+					All: &as3PolicyCompareString{Operand: "equals",
+						Values: []string{"GET", "POST"}}},
+				{Type: "httpUri", Name: "env", Event: "request",
+					QueryParameter: &as3PolicyCompareString{
+						Operand: "equals", Values: []string{"test"}}},
+				{Type: "httpUri", Name: "v", Event: "request",
+					QueryParameter: &as3PolicyCompareString{
+						Operand: "equals", Values: []string{"2", "3"}}},
+			}))
+		})
+
+		It("Rejects invalid methods and query parameters", func() {
+			pl := matchPools()[1]
+			Expect(validatePoolMatch(pl)).To(Succeed())
+			pl.MatchMethod = []string{"GET /"}
+			Expect(validatePoolMatch(pl)).NotTo(Succeed())
+			pl = matchPools()[1]
+			pl.MatchQueryParams = []string{"=2"}
+			Expect(validatePoolMatch(pl)).NotTo(Succeed())
+			pl.MatchQueryParams = []string{"v"}
+			Expect(validatePoolMatch(pl)).NotTo(Succeed())
+		})
+	})
+
+	Context("Response headers", func() {
+		It("Sets and removes the response headers of the pool", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1",
+							ServicePort: intstr.FromInt(80),
+							ResponseHeaders: &cisapiv1.ResponseHeaders{
+								Set: map[string]string{
+									"X-Frame-Options":        "DENY",
+									"X-Content-Type-Options": "nosniff",
+								},
+								Remove: []string{"Server"},
+							}},
+						{Path: "/bar", Service: "svc2",
+							ServicePort: intstr.FromInt(80)},
+					}})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(2))
+			Expect(rules[1].FullURI).To(Equal("test.com/foo"))
+			Expect(rules[1].Actions).To(Equal([]*action{
+				{Name: "0", Forward: true, Request: true,
+					Pool: "default_svc1_80"},
+				{Name: "1", Replace: true, Response: true,
+					TmName: "X-Content-Type-Options", Value: "nosniff"},
+				{Name: "2", Replace: true, Response: true,
+					TmName: "X-Frame-Options", Value: "DENY"},
+				{Name: "3", Remove: true, Response: true, TmName: "Server"},
+			}))
+			Expect(rules[0].Actions).To(HaveLen(1))
+
+			rulesData := &as3Rule{}
+			createRuleAction(rules[1], rulesData)
+			Expect(rulesData.Actions[1:]).To(Equal([]*as3Action{
+				{Type: "httpHeader", Event: "response",
+					Replace: &as3ActionReplaceMap{
+						Name: "X-Content-Type-Options", Value: "nosniff"}},
+				{Type: "httpHeader", Event: "response",
+					Replace: &as3ActionReplaceMap{
+						Name: "X-Frame-Options", Value: "DENY"}},
+				{Type: "httpHeader", Event: "response",
+					Remove: &as3ActionRemoveMap{Name: "Server"}},
+			}))
+		})
+
+		It("Rejects invalid response headers", func() {
+			pl := cisapiv1.Pool{Path: "/foo", Service: "svc1",
+				ResponseHeaders: &cisapiv1.ResponseHeaders{
+					Set:    map[string]string{"X-Frame-Options": "DENY"},
+					Remove: []string{"Server", "X-Powered-By"},
+				}}
+			Expect(validatePoolResponseHeaders(pl)).To(Succeed())
+			for _, update := range []func(rh *cisapiv1.ResponseHeaders){
+				func(rh *cisapiv1.ResponseHeaders) {
+					rh.Set["X Frame"] = "DENY"
+				},
+				func(rh *cisapiv1.ResponseHeaders) {
+					rh.Set["X-Frame-Options"] = "tcl:[HTTP::host]"
+				},
+				func(rh *cisapiv1.ResponseHeaders) {
+					rh.Set["X-Frame-Options"] = "DENY\r\nX-Other: 1"
+				},
+				func(rh *cisapiv1.ResponseHeaders) {
+					rh.Remove = []string{"x-frame-options"}
+				},
+				func(rh *cisapiv1.ResponseHeaders) {
+					rh.Remove = []string{"Server:"}
+				},
+			} {
+				invalid := pl
+				invalid.ResponseHeaders = pl.ResponseHeaders.DeepCopy()
+				update(invalid.ResponseHeaders)
+				Expect(validatePoolResponseHeaders(invalid)).NotTo(Succeed(),
+					"%+v", invalid.ResponseHeaders)
+			}
+			pl.Service = ""
+			pl.Action = &cisapiv1.PoolAction{Type: PoolActionDrop}
+			Expect(validatePoolResponseHeaders(pl)).NotTo(Succeed())
+		})
+	})
+
+	Context("Source ranges", func() {
+		It("Resets the requests of the clients out of the source ranges", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					AllowSourceRange: []string{"10.0.0.0/8"},
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1",
+							ServicePort: intstr.FromInt(80)},
+						{Path: "/bar", Service: "svc2",
+							ServicePort:      intstr.FromInt(80),
+							AllowSourceRange: []string{"192.168.0.0/16"}},
+					}})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(4))
+			var names []string
+			for _, rl := range rules {
+				names = append(names, rl.Name)
+			}
+			Expect(names).To(Equal([]string{
+				"vs_test_com_bar_default_svc2_80",
+				"vs_test_com_foo_default_svc1_80",
+				"vs_test_com_bar_default_svc2_80-reset",
+				"vs_test_com_foo_default_svc1_80-reset",
+			}))
+			Expect(rules[0].Conditions[2]).To(Equal(&condition{Name: "2",
+				Tcp: true, Address: true, Matches: true, Request: true,
+				Values: []string{"192.168.0.0/16"}}))
+			Expect(rules[1].Conditions[2].Values).To(Equal(
+				[]string{"10.0.0.0/8"}))
+			Expect(rules[3].Conditions).To(Equal(rules[1].Conditions[:2]))
+			Expect(rules[3].Actions).To(Equal([]*action{{Name: "0",
+				Forward: true, Request: true, Reset: true}}))
+			Expect(createPolicy(rules, "policy", "test").Requires).To(Equal(
+				[]string{"http", "tcp"}))
+
+			rulesData := &as3Rule{}
+			createRuleCondition(rules[0], rulesData, 80)
+			Expect(rulesData.Conditions[2]).To(Equal(&as3Condition{
+				Type: "tcp", Event: "request",
+				Address: &as3PolicyMatchAddress{
+					Values: []string{"192.168.0.0/16"}, Operand: "match"}}))
+		})
+
+	})
+
+	Context("Maintenance", func() {
+		It("Creates the maintenance rule of the host first", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Maintenance: &cisapiv1.Maintenance{Enabled: true,
+						RedirectURL: "https://status.test.com"},
+					Pools: []cisapiv1.Pool{
+						{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+						{Path: "/foo", Service: "svc2",
+							ServicePort:   intstr.FromInt(80),
+							PathMatchType: PathMatchExact},
+					}})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(3))
+			Expect(rules[0].Name).To(Equal("vs_test_com_all-maintenance"))
+			Expect(rules[0].Ordinal).To(Equal(0))
+			Expect(rules[0].FullURI).To(Equal("test.com"))
+			Expect(rules[0].Conditions).To(HaveLen(1))
+			Expect(rules[0].Actions).To(Equal([]*action{{Name: "0",
+				Redirect: true, Request: true,
+				Location: "https://status.test.com", Code: 302}}))
+			Expect(rules[1].FullURI).To(Equal("test.com/foo"))
+
+			// Wildcard hosts are overridden by the rules of exact hosts
+			other := vs.DeepCopy()
+			other.Spec.Host = "*.test.com"
+			other.Spec.Maintenance.RedirectURL = ""
+			other.Spec.Maintenance.Pool = "/Common/maintenance_pool"
+			rules = append(rules, *processVirtualServerRules(other)...)
+			sortRules(rules)
+			Expect(rules[0].FullURI).To(Equal("test.com"))
+			Expect(rules[3].FullURI).To(Equal("*.test.com"))
+			Expect(isMaintenanceRule(rules[3])).To(BeTrue())
+
+			rulesData := &as3Rule{}
+			createRuleAction(rules[3], rulesData)
+			Expect(rulesData.Actions[0].Select.Pool).To(Equal(
+				&as3ResourcePointer{BigIP: "/Common/maintenance_pool"}))
+		})
+	})
+
+	Context("Hosts", func() {
+		It("Creates the rules and dependencies of each host", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{
+					Hosts: []string{"www.example.com", "example.com"},
+					Maintenance: &cisapiv1.Maintenance{Enabled: true,
+						RedirectURL: "https://status.example.com"},
+					Pools: []cisapiv1.Pool{
+						{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+						{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80)},
+					}})
+			var uris []string
+			for _, rl := range *processVirtualServerRules(vs) {
+				uris = append(uris, rl.FullURI)
+			}
+			Expect(uris).To(ConsistOf("www.example.com", "example.com",
+				"www.example.com/", "example.com/", "www.example.com/foo",
+				"example.com/foo"))
+
+			_, deps := NewObjectDependencies(vs)
+			var names []string
+			for dep := range deps {
+				if dep.Kind == RuleDep {
+					names = append(names, dep.Name)
+				}
+			}
+			Expect(names).To(ConsistOf(uris))
+		})
+	})
+
+	Context("Pool action", func() {
+		It("Creates the rules of the pool actions", func() {
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{Host: "test.com",
+					Pools: []cisapiv1.Pool{
+						{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+						{Path: "/internal", Action: &cisapiv1.PoolAction{
+							Type: PoolActionReset}},
+					}})
+			rules := *processVirtualServerRules(vs)
+			Expect(len(rules)).To(Equal(2))
+			rl := rules[0]
+			Expect(rl.Name).To(Equal(formatVirtualServerRuleName("test.com",
+				"/internal", PoolActionReset) + resetRuleSuffix))
+			Expect(rl.Actions).To(Equal([]*action{{Name: "0", Forward: true,
+				Request: true, Reset: true}}))
+			Expect(getRulePool(rules[1])).To(Equal("default_svc1_80"))
+		})
+
+		It("Creates the actions", func() {
+			Expect(createPoolAction(&cisapiv1.PoolAction{
+				Type: PoolActionDrop})).To(Equal(&action{Name: "0",
+				Drop: true, Request: true}))
+			Expect(createPoolAction(&cisapiv1.PoolAction{
+				Type: PoolActionRedirect, Location: "https://a.com/"})).To(
+				Equal(&action{Name: "0", Redirect: true, Request: true,
+					Location: "https://a.com/", Code: DefaultRedirectCode}))
+		})
+
+		It("Creates the AS3 actions of the policy", func() {
+			rl := &Rule{Actions: []*action{{Name: "0", Forward: true,
+				Request: true, Reset: true}}}
+			rulesData := &as3Rule{}
+			createRuleAction(rl, rulesData)
+			Expect(rulesData.Actions).To(Equal([]*as3Action{
+				{Type: "drop", Event: "request"}}))
+
+			rl.Actions = []*action{createPoolAction(&cisapiv1.PoolAction{
+				Type: PoolActionRedirect, Location: "https://a.com/",
+				Code: 301})}
+			rulesData = &as3Rule{}
+			createRuleAction(rl, rulesData)
+			Expect(rulesData.Actions).To(BeEmpty())
+		})
+
+		It("Drops and redirects the requests in the iRule", func() {
+			iRule := poolActionIRule(map[string]*action{
+				"rule_b-reset": {Drop: true},
+				"rule_a-reset": {Redirect: true, Code: 301,
+					Location: "https://a.com/"},
+			})
+			Expect(iRule).To(ContainSubstring("[POLICY::rules matched $policy]"))
+			Expect(iRule).To(ContainSubstring(
+				"HTTP::respond 301 Location {https://a.com/}"))
+			Expect(strings.Index(iRule, "{rule_a-reset}")).To(
+				BeNumerically("<", strings.Index(iRule, "{rule_b-reset}")))
+		})
+
+		It("Rejects invalid actions", func() {
+			pl := cisapiv1.Pool{Path: "/internal",
+				Action: &cisapiv1.PoolAction{Type: PoolActionReset}}
+			Expect(validatePoolAction(pl)).To(Succeed())
+			pl.Service = "svc1"
+			Expect(validatePoolAction(pl)).NotTo(Succeed())
+			pl.Service = ""
+			pl.Action.Code = 301
+			Expect(validatePoolAction(pl)).NotTo(Succeed())
+			pl.Action = &cisapiv1.PoolAction{Type: "forward"}
+			Expect(validatePoolAction(pl)).NotTo(Succeed())
+			pl.Action = &cisapiv1.PoolAction{Type: PoolActionRedirect,
+				Location: "https://a.com/", Code: 308}
+			Expect(validatePoolAction(pl)).To(Succeed())
+			pl.Action.Location = "https://a.com/{x}"
+			Expect(validatePoolAction(pl)).NotTo(Succeed())
+			pl.Action = &cisapiv1.PoolAction{Type: PoolActionRedirect,
+				Location: "https://a.com/", Code: 303}
+			Expect(validatePoolAction(pl)).NotTo(Succeed())
+		})
+	})
+
+	Context("HSTS", func() {
+		It("Formats the header", func() {
+			Expect(hstsHeader(nil)).To(BeEmpty())
+			Expect(hstsHeader(&cisapiv1.HSTS{})).To(BeEmpty())
+			Expect(hstsHeader(&cisapiv1.HSTS{Enabled: true})).To(
+				Equal("max-age=31536000"))
+			maxAge := int64(600)
+			Expect(hstsHeader(&cisapiv1.HSTS{
+				Enabled:           true,
+				MaxAge:            &maxAge,
+				IncludeSubdomains: true,
+				Preload:           true,
+			})).To(Equal("max-age=600; includeSubDomains; preload"))
+		})
+
+		It("Inserts the header of the host", func() {
+			iRule := hstsIRule()
+			Expect(iRule).To(ContainSubstring("proc match_host"))
+			Expect(iRule).To(ContainSubstring("] hsts_dg]"))
+			Expect(iRule).To(ContainSubstring(
+				"HTTP::header replace Strict-Transport-Security"))
+		})
+
+		It("Rejects invalid HSTS", func() {
+			maxAge := int64(-1)
+			Expect(validateHSTS(nil)).To(Succeed())
+			Expect(validateHSTS(&cisapiv1.HSTS{MaxAge: &maxAge})).To(Succeed())
+			Expect(validateHSTS(&cisapiv1.HSTS{Enabled: true,
+				MaxAge: &maxAge})).NotTo(Succeed())
+			Expect(validateHSTS(&cisapiv1.HSTS{Enabled: true,
+				IncludeSubdomains: true, Preload: true})).To(Succeed())
+			Expect(validateHSTS(&cisapiv1.HSTS{Enabled: true,
+				Preload: true})).NotTo(Succeed())
+			maxAge = 600
+			Expect(validateHSTS(&cisapiv1.HSTS{Enabled: true, MaxAge: &maxAge,
+				IncludeSubdomains: true, Preload: true})).NotTo(Succeed())
+		})
+	})
+})
+
+// BenchmarkMergeRules merges the 500 rules of 250 paths rewritten to
+// another path.
+func BenchmarkMergeRules(b *testing.B) {
+	var pools []cisapiv1.Pool
+	for i := 0; i < 250; i++ {
+		pools = append(pools, cisapiv1.Pool{
+			Path:        fmt.Sprintf("/foo%d/bar", i),
+			Service:     fmt.Sprintf("svc%d", i),
+			ServicePort: intstr.FromInt(80),
+			Rewrite:     &cisapiv1.Rewrite{TargetPath: "/bar"},
+		})
+	}
+	vs := test.NewVirtualServer("SampleVS", "default",
+		cisapiv1.VirtualServerSpec{Host: "test.com", Pools: pools})
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		rsCfg := &ResourceConfig{}
+		rsCfg.Virtual.Name = "crd_1_2_3_4_80"
+		rsCfg.SetPolicy(*createPolicy(*processVirtualServerRules(vs),
+			"policy", "test"))
+		b.StartTimer()
+		rsCfg.MergeRules(make(map[NameRef]map[string]mergedRuleEntry))
+	}
+}
@@ -0,0 +1,78 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// shutdownPollInterval is the interval of the checks whether the queue is
+// drained and the declaration posted while shutting down
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Shutdown stops watching the resources, processes the resources queued
+// and posts the configuration, deletions included, before stopping the
+// Custom Resource Manager. Draining and posting are bounded by
+// ShutdownGracePeriod, the health check reports the controller draining
+// meanwhile.
+func (crMgr *CRManager) Shutdown() {
+	log.Infof("Draining Custom Resource Manager for up to %v",
+		crMgr.ShutdownGracePeriod)
+	if nil != crMgr.Agent {
+		crMgr.Agent.Drain()
+	}
+	crMgr.stopWatching()
+
+	deadline := time.Now().Add(crMgr.ShutdownGracePeriod)
+	if !crMgr.waitUntil(deadline, crMgr.isDrained) {
+		log.Warningf("Shutdown grace period expired with %d resources "+
+			"queued, posting the configuration processed",
+			crMgr.rscQueue.Len())
+	}
+	// The flush requested by the last worker may be waiting for
+	// FlushInterval
+	crMgr.flushConfig()
+	if nil != crMgr.Agent && nil != crMgr.Agent.DeclWriter {
+		written := crMgr.Agent.DeclWriter.Written()
+		posted := func() bool {
+			return crMgr.Agent.DeclWriter.Posted() >= written
+		}
+		if !crMgr.waitUntil(deadline, posted) {
+			log.Warningf("Shutdown grace period expired before the " +
+				"declaration was posted to BIG-IP")
+		}
+	}
+	crMgr.Stop()
+}
+
+// isDrained returns whether the resources queued are all processed
+func (crMgr *CRManager) isDrained() bool {
+	return atomic.LoadInt32(&crMgr.inFlight) == 0 && crMgr.rscQueue.Len() == 0
+}
+
+// waitUntil waits for done until the deadline and returns whether done
+func (crMgr *CRManager) waitUntil(deadline time.Time, done func() bool) bool {
+	err := wait.PollImmediate(shutdownPollInterval, time.Until(deadline),
+		func() (bool, error) {
+			return done(), nil
+		})
+	return err == nil
+}
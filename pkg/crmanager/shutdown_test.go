@@ -0,0 +1,97 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("Shutdown Tests", func() {
+	var mockCRM *mockCRManager
+	var postMgr *PostManager
+	var vs *cisapiv1.VirtualServer
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.FlushInterval = time.Hour
+		mockCRM.ShutdownGracePeriod = 5 * time.Second
+		postMgr = &PostManager{postChan: make(chan config, 1)}
+		mockCRM.Agent = &Agent{DeclWriter: postMgr}
+		vs = mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+		mockCRM.processWithWorkers(1)
+		mockCRM.rscQueue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "custom-resource-controller")
+		mockCRM.flushConfig()
+		var cfg config
+		Expect(postMgr.postChan).To(Receive(&cfg))
+		postMgr.setPosted(cfg)
+	})
+
+	// deleteVirtualServer removes the VirtualServer from the informer
+	// store and queues its deletion
+	deleteVirtualServer := func() {
+		crInf, _ := mockCRM.getNamespaceInformer("default")
+		Expect(crInf.vsInformer.GetIndexer().Delete(vs)).To(Succeed())
+		mockCRM.enqueueDeletedVirtualServer(vs)
+	}
+
+	It("Posts the deletions queued before stopping", func() {
+		deleteVirtualServer()
+		go func() {
+			for mockCRM.processResource() {
+			}
+		}()
+		defer mockCRM.rscQueue.ShutDown()
+		posted := make(chan config, 1)
+		go func() {
+			cfg := <-postMgr.postChan
+			postMgr.setPosted(cfg)
+			posted <- cfg
+		}()
+
+		mockCRM.Shutdown()
+		Expect(mockCRM.Agent.Draining()).To(BeTrue())
+		Expect(mockCRM.isDrained()).To(BeTrue())
+		var cfg config
+		Expect(posted).To(Receive(&cfg))
+		Expect(cfg.data).NotTo(ContainSubstring(
+			formatVirtualServerName("10.1.1.1", 443)))
+		Expect(postMgr.Posted()).To(Equal(postMgr.Written()))
+	})
+
+	It("Stops once the grace period expires", func() {
+		mockCRM.ShutdownGracePeriod = 200 * time.Millisecond
+		deleteVirtualServer()
+		go func() {
+			for mockCRM.processResource() {
+			}
+		}()
+		defer mockCRM.rscQueue.ShutDown()
+
+		// The declaration is never posted
+		start := time.Now()
+		mockCRM.Shutdown()
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		Expect(postMgr.postChan).To(Receive())
+		Expect(postMgr.Posted()).To(BeNumerically("<", postMgr.Written()))
+	})
+})
@@ -0,0 +1,218 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// StatusReady is the status of a VirtualServer configured on BIG-IP
+	StatusReady = "Ready"
+	// StatusDegraded is the status of a VirtualServer configured on BIG-IP
+	// with errors, like a missing TLS secret, or without pool members
+	StatusDegraded = "Degraded"
+	// StatusError is the status of a VirtualServer not configured
+	StatusError = "Error"
+
+	// maxStatusRetries is the number of times a failed status update is
+	// retried before waiting for the next change of the status
+	maxStatusRetries = 5
+)
+
+// statusWorker writes the status of the VirtualServers in statusQueue.
+func (crMgr *CRManager) statusWorker() {
+	for crMgr.processStatus() {
+	}
+}
+
+// processStatus writes the pending status of a VirtualServer from the
+// statusQueue. Failed updates are retried with backoff.
+func (crMgr *CRManager) processStatus() bool {
+	key, quit := crMgr.statusQueue.Get()
+	if quit {
+		return false
+	}
+	defer crMgr.statusQueue.Done(key)
+	vsKey := key.(string)
+
+	crMgr.statusMutex.Lock()
+	status, found := crMgr.vsStatusMap[vsKey]
+	crMgr.statusMutex.Unlock()
+	if !found {
+		crMgr.statusQueue.Forget(key)
+		return true
+	}
+
+	if err := crMgr.writeVirtualServerStatus(vsKey, status); err != nil {
+		log.Warningf("Failed to update status of VirtualServer %s: %v",
+			vsKey, err)
+		// The first attempt is counted as a requeue too
+		if crMgr.statusQueue.NumRequeues(key) <= maxStatusRetries {
+			crMgr.statusQueue.AddRateLimited(key)
+			return true
+		}
+	}
+	crMgr.statusQueue.Forget(key)
+	return true
+}
+
+// writeVirtualServerStatus updates the status subresource of the
+// VirtualServer.
+func (crMgr *CRManager) writeVirtualServerStatus(
+	vsKey string,
+	status cisapiv1.VirtualServerStatus,
+) error {
+	vs, found := crMgr.getVirtualServer(vsKey)
+	if !found {
+		return nil
+	}
+	vs = vs.DeepCopy()
+	vs.Status = status
+	_, err := crMgr.kubeCRClient.K8sV1().VirtualServers(
+		vs.ObjectMeta.Namespace).UpdateStatus(vs)
+	return err
+}
+
+// updateVirtualServerStatus queues the status of the VirtualServer for an
+// update, unless it is unchanged.
+func (crMgr *CRManager) updateVirtualServerStatus(vs *cisapiv1.VirtualServer) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	status := crMgr.getVirtualServerStatus(vs)
+
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	oldStatus, found := crMgr.vsStatusMap[vsKey]
+	if !found {
+		oldStatus = vs.Status
+	}
+	// lastUpdated changes only with the status
+	status.LastUpdated = oldStatus.LastUpdated
+	if reflect.DeepEqual(oldStatus, status) {
+		return
+	}
+	status.LastUpdated = metav1.Now()
+	crMgr.vsStatusMap[vsKey] = status
+	// A new status is written without the backoff of failed updates
+	crMgr.statusQueue.Forget(vsKey)
+	crMgr.statusQueue.AddRateLimited(vsKey)
+}
+
+// getVirtualServerStatus returns the status of the VirtualServer from the
+// virtuals it is configured on, and the last warning recorded for it.
+func (crMgr *CRManager) getVirtualServerStatus(
+	vs *cisapiv1.VirtualServer,
+) cisapiv1.VirtualServerStatus {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	var status cisapiv1.VirtualServerStatus
+	// Called by the status worker, concurrently with the worker
+	for _, ref := range crMgr.resources.getVirtuals(VirtualServer, vsKey) {
+		status.VirtualNames = append(status.VirtualNames, ref.Name)
+	}
+
+	crMgr.statusMutex.Lock()
+	warning := crMgr.vsWarnings[vsKey]
+	crMgr.statusMutex.Unlock()
+	if len(status.VirtualNames) == 0 {
+		status.Status = StatusError
+		status.Message = "VirtualServer is not configured"
+		if warning != "" {
+			status.Message = warning
+		}
+		return status
+	}
+	status.Status = StatusReady
+	status.VSAddress = crMgr.getVirtualServerAddress(vs)
+	status.Message = fmt.Sprintf("VirtualServer is configured on %s",
+		strings.Join(status.VirtualNames, ", "))
+	if inactive := crMgr.resources.getInactiveVirtualNames(VirtualServer,
+		vsKey); len(inactive) > 0 {
+		status.Status = StatusDegraded
+		status.Message += ", without pool members on " +
+			strings.Join(inactive, ", ")
+	}
+	if warning != "" {
+		status.Status = StatusDegraded
+		status.Message += ": " + warning
+	}
+	return status
+}
+
+// recordVirtualServerWarning keeps the message of the Warning Event of a
+// VirtualServer for its status.
+func (crMgr *CRManager) recordVirtualServerWarning(
+	obj runtime.Object,
+	eventType,
+	message string,
+) {
+	vs, ok := obj.(*cisapiv1.VirtualServer)
+	if !ok || eventType != v1.EventTypeWarning {
+		return
+	}
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	crMgr.vsWarnings[vs.ObjectMeta.Namespace+"/"+vs.ObjectMeta.Name] = message
+}
+
+// clearVirtualServerWarning removes the warning of the VirtualServer kept
+// from its previous sync.
+func (crMgr *CRManager) clearVirtualServerWarning(vsKey string) {
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	delete(crMgr.vsWarnings, vsKey)
+}
+
+// deleteVirtualServerStatus drops the status of the deleted VirtualServer.
+func (crMgr *CRManager) deleteVirtualServerStatus(vs *cisapiv1.VirtualServer) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	delete(crMgr.vsStatusMap, vsKey)
+	delete(crMgr.vsWarnings, vsKey)
+	delete(crMgr.persistenceWarned, vsKey)
+}
+
+// setPersistenceWarned records the persistence warning of the generation of
+// the VirtualServer, it returns false if the generation was already warned
+// about.
+func (crMgr *CRManager) setPersistenceWarned(vs *cisapiv1.VirtualServer) bool {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	generation, ok := crMgr.persistenceWarned[vsKey]
+	if ok && generation == vs.ObjectMeta.Generation {
+		return false
+	}
+	crMgr.persistenceWarned[vsKey] = vs.ObjectMeta.Generation
+	return true
+}
+
+// clearPersistenceWarned forgets the persistence warning of the
+// VirtualServer, it is warned about again when the condition reappears.
+func (crMgr *CRManager) clearPersistenceWarned(vsKey string) {
+	crMgr.statusMutex.Lock()
+	defer crMgr.statusMutex.Unlock()
+	delete(crMgr.persistenceWarned, vsKey)
+}
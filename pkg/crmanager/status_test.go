@@ -0,0 +1,125 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("VirtualServer Status", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+		mockCRM.addService(test.NewService("svc1", "1", "default",
+			v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}}))
+		mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+			"default", []string{"10.1.0.1"}, nil,
+			[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+		vs = test.NewVirtualServer(
+			"SampleVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host:                 "test.com",
+				VirtualServerAddress: "1.2.3.4",
+				Pools: []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				},
+			},
+		)
+	})
+
+	// writeStatus creates the VirtualServer in the API server and writes
+	// its status.
+	writeStatus := func() cisapiv1.VirtualServerStatus {
+		vsClient := mockCRM.kubeCRClient.K8sV1().VirtualServers("default")
+		_, _ = vsClient.Create(vs)
+		Expect(mockCRM.processStatus()).To(BeTrue())
+		written, err := vsClient.Get(vs.ObjectMeta.Name, metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		return written.Status
+	}
+
+	It("Writes Ready status of a configured VirtualServer", func() {
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		status := writeStatus()
+		rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		Expect(status.Status).To(Equal(StatusReady))
+		Expect(status.VSAddress).To(Equal("1.2.3.4"))
+		Expect(status.VirtualNames).To(Equal([]string{rsName}))
+		Expect(status.Message).To(ContainSubstring(rsName))
+		Expect(status.LastUpdated.IsZero()).To(BeFalse())
+	})
+
+	It("Writes Degraded status of a VirtualServer without pool members", func() {
+		mockCRM.addEndpoints(test.NewEndpoints("svc1", "2", "node1",
+			"default", nil, nil, nil))
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		status := writeStatus()
+		rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		Expect(status.Status).To(Equal(StatusDegraded))
+		Expect(status.VirtualNames).To(Equal([]string{rsName}))
+		Expect(status.Message).To(HaveSuffix("without pool members on " +
+			rsName))
+	})
+
+	It("Writes Error status with the reason", func() {
+		vs.Spec.PersistenceProfile = "universal"
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		events := mockCRM.getFakeEvents("default")
+		Expect(len(events)).To(Equal(1))
+		status := writeStatus()
+		Expect(status.Status).To(Equal(StatusError))
+		Expect(status.VSAddress).To(BeEmpty())
+		Expect(status.VirtualNames).To(BeEmpty())
+		Expect(status.Message).To(Equal(events[0].Message))
+	})
+
+	It("Does not queue unchanged status", func() {
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		status := writeStatus()
+
+		vs.Status = status
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		Expect(mockCRM.vsStatusMap["default/SampleVS"]).To(Equal(status))
+		Expect(mockCRM.statusQueue.Len()).To(BeZero())
+	})
+
+	It("Retries failed status updates", func() {
+		mockCRM.addVirtualServer(vs)
+		Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		// The VirtualServer is not in the API server.
+		Expect(mockCRM.processStatus()).To(BeTrue())
+		// Queued once and retried once
+		Expect(mockCRM.statusQueue.NumRequeues("default/SampleVS")).To(Equal(2))
+		Expect(mockCRM.processStatus()).To(BeTrue())
+		Expect(mockCRM.statusQueue.NumRequeues("default/SampleVS")).To(Equal(3))
+	})
+})
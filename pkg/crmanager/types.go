@@ -17,16 +17,22 @@
 package crmanager
 
 import (
+	"net"
+	"net/http"
 	"sync"
+	"time"
 
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	"github.com/F5Networks/k8s-bigip-ctlr/config/client/clientset/versioned"
 	"github.com/F5Networks/k8s-bigip-ctlr/pkg/pollers"
 	"github.com/F5Networks/k8s-bigip-ctlr/pkg/writer"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -36,6 +42,7 @@ type (
 		resources        *Resources
 		kubeCRClient     versioned.Interface
 		kubeClient       kubernetes.Interface
+		dynamicClient    dynamic.Interface
 		crInformers      map[string]*CRInformer
 		resourceSelector labels.Selector
 		namespaces       []string
@@ -43,14 +50,34 @@ type (
 		Partition        string
 		Agent            *Agent
 		ControllerMode   string
-		// map of rules that have been merged
-		mergedRulesMap  map[string]map[string]mergedRuleEntry
+		// map of rules that have been merged, guarded by rulesMutex
+		mergedRulesMap  map[NameRef]map[string]mergedRuleEntry
 		nodePoller      pollers.Poller
 		oldNodes        []Node
 		UseNodeInternal bool
-		initState       bool
-		SSLContext      map[string]*v1.Secret
-		customProfiles  *CustomProfileStore
+		// Nodes of the cluster, the VirtualServers whose NodeMemberLabel
+		// selects a node before or after its change are processed again
+		nodeInformer cache.SharedIndexInformer
+		// Exclude the unschedulable and not ready nodes from the members
+		// in nodeport mode
+		FilterUnhealthy bool
+		// Route domain applied to virtual addresses and pool members
+		// when the VirtualServerAddress does not carry one.
+		DefaultRouteDomain int32
+		// Partitions overriding Partition per namespace
+		NamespacePartitions map[string]string
+		// Route domains overriding DefaultRouteDomain per namespace
+		NamespaceRouteDomains map[string]int32
+		initState             bool
+		SSLContext            map[string]*v1.Secret
+		// TLSProfiles referenced by VirtualServers, key is namespace/name.
+		// Guarded by tlsMutex.
+		TLSContext     map[string]*cisapiv1.TLSProfile
+		customProfiles *CustomProfileStore
+		// Mutex for TLSContext
+		tlsMutex sync.Mutex
+		// Mutex for mergedRulesMap
+		rulesMutex sync.Mutex
 		// Mutex for irulesMap
 		irulesMutex sync.Mutex
 		// Mutex for intDgMap
@@ -58,28 +85,216 @@ type (
 		// App informer support
 		irulesMap IRulesMap
 		intDgMap  InternalDataGroupMap
+		// Records of the https redirect data group of each VirtualServer,
+		// key is namespace/name. Guarded by intDgMutex.
+		redirectRecords map[string]map[string]bool
+		// Maximum objects allowed per namespace
+		NamespaceQuota NamespaceQuota
+		// VirtualServers within and beyond the namespace quota, key is
+		// namespace/name. Guarded by quotaMutex.
+		admittedVirtuals map[string]*cisapiv1.VirtualServer
+		rejectedVirtuals map[string]*cisapiv1.VirtualServer
+		quotaMutex       sync.Mutex
+		eventNotifier    *EventNotifier
+		// Allocates the addresses of VirtualServers using ipamLabel
+		ipam IPAM
+		// Address of the host groups by name. Guarded by hostGroupMutex.
+		hostGroups     map[string]string
+		hostGroupMutex sync.Mutex
+		// Whether VirtualServers can share the same address and port
+		SharedVIPPolicy string
+		// Name the virtuals of VirtualServers after the VirtualServer
+		// instead of its address
+		UseResourceNames bool
+		// Partitions the VirtualServers can select besides Partition
+		AllowedPartitions []string
+		// Partition of the iRules and data groups of all the virtuals,
+		// instead of the partition of each virtual
+		SharedPartition string
+		// SNAT of the VirtualServers without snat
+		DefaultSNAT string
+		// ICMP echo of the virtual addresses of VirtualServers without
+		// icmpEcho
+		DefaultICMPEcho string
+		// Disables ARP of the virtual addresses of VirtualServers without arp
+		DisableARP bool
+		// Queue of the VirtualServers with status to be written, key is
+		// namespace/name
+		statusQueue workqueue.RateLimitingInterface
+		// Mutex for vsStatusMap, vsWarnings and persistenceWarned
+		statusMutex sync.Mutex
+		// Last status queued for the VirtualServers and the message of
+		// their last Warning Event, key is namespace/name
+		vsStatusMap map[string]cisapiv1.VirtualServerStatus
+		vsWarnings  map[string]string
+		// Generation of the VirtualServers warned about their persistence,
+		// key is namespace/name
+		persistenceWarned map[string]int64
+		// Disable the virtuals which are not active, instead of leaving
+		// them enabled without pool members
+		DisableInactive bool
+		// Populate pool members from EndpointSlices instead of Endpoints
+		UseEndpointSlices bool
+		// Mutex for pendingServices
+		pendingMutex sync.Mutex
+		// VirtualServers waiting for the services of their pools to be
+		// created and populated, key is the Service dependency
+		pendingServices ObjectDependencyMap
+		// Number of workers processing the resources of rscQueue
+		ProcessingWorkers int
+		// Mutex serializing the posting of the configuration and the
+		// processing of the resources, the workers get the secrets of the
+		// VirtualServers concurrently before locking it. The VirtualServers,
+		// TransportServers and IngressLinks hold it for reading and lock
+		// their virtuals in virtualLocks, so that the resources on other
+		// virtuals are processed concurrently. The other resources hold it
+		// exclusively.
+		processingMutex sync.RWMutex
+		virtualLocks    virtualLocks
+		// Resources got from rscQueue and not processed yet
+		inFlight int32
+		// Mutex for SSLContext
+		sslMutex sync.Mutex
+		// Minimum interval between the configurations posted to BIG-IP
+		FlushInterval time.Duration
+		// The configuration is changed since the last post, at dirtySince
+		// by pendingEvents resources. Guarded by processingMutex.
+		configDirty   bool
+		dirtySince    time.Time
+		pendingEvents int
+		// Requests configFlusher to post the configuration
+		flushCh chan struct{}
+		// Only the leader among the controllers using the same Lease
+		// processes the resources and posts the configuration
+		LeaderElection bool
+		leaderElector  *leaderelection.LeaderElector
+		// 1 while leading, accessed atomically
+		leading int32
+		// Interval of the periodic full resyncs, 0 disables them
+		ResyncPeriod time.Duration
+		// The configs are rebuilt from scratch, they are posted once all
+		// the resources queued are processed, and compared with the
+		// configs last posted when checkDrift. Guarded by processingMutex.
+		rebuilding bool
+		checkDrift bool
+		// The objects on BIG-IP named like the objects of the virtuals and
+		// not in the configuration are removed once the resources are
+		// processed after the informer caches are synced. pruneStale is
+		// guarded by processingMutex.
+		PruneOnStartup bool
+		pruneStale     bool
+		pruned         bool
+		// Transient failures of the VirtualServers being synced, retried
+		// with backoff, key is namespace/name
+		retryErrs  map[string]error
+		retryMutex sync.Mutex
+		// The finalizer is placed on the VirtualServers and the TLSProfiles
+		// they use, and removed from the deleted ones once the configuration
+		// without them is posted
+		UseFinalizers bool
+		// Resources deleted since the last flush. Guarded by
+		// processingMutex.
+		deletedResources []finalizerKey
+		// Queue of the deleted resources with the finalizer to be removed
+		finalizerQueue workqueue.RateLimitingInterface
+		// Mutex for pendingFinalizers
+		finalizerMutex sync.Mutex
+		// Sequence number of the declaration to be posted before removing
+		// the finalizer of the deleted resources
+		pendingFinalizers map[finalizerKey]uint64
+		// Bound of the draining of the queue and of the last post on
+		// Shutdown
+		ShutdownGracePeriod time.Duration
+		// Stops the informers and the node poller once
+		stopOnce sync.Once
+		// The liveness check fails when resources are pending and none was
+		// got or processed by the workers for StallTimeout since
+		// lastProgress, in Unix nanoseconds accessed atomically
+		StallTimeout time.Duration
+		lastProgress int64
+		// The certificates of the secrets expiring within
+		// CertExpiryWarnDays are reported, 0 disables the warnings
+		CertExpiryWarnDays int
 	}
 	// Params defines parameters
 	Params struct {
-		Config            *rest.Config
-		Namespaces        []string
-		Partition         string
-		Agent             *Agent
-		ControllerMode    string
-		VXLANName         string
-		VXLANMode         string
-		UseNodeInternal   bool
-		NodePollInterval  int
-		NodeLabelSelector string
+		Config                *rest.Config
+		Namespaces            []string
+		Partition             string
+		Agent                 *Agent
+		ControllerMode        string
+		VXLANName             string
+		VXLANMode             string
+		UseNodeInternal       bool
+		NodePollInterval      int
+		NodeLabelSelector     string
+		DefaultRouteDomain    int32
+		NamespaceRouteDomains []string
+		NamespacePartitions   []string
+		NamespaceQuota        NamespaceQuota
+		NamingScheme          string
+		NameEscaping          string
+		UseResourceNames      bool
+		AllowedPartitions     []string
+		SharedPartition       string
+		IPAM                  bool
+		IPAMRanges            []string
+		IPAMNamespace         string
+		SharedVIPPolicy       string
+		DefaultSNAT           string
+		DefaultICMPEcho       string
+		DisableARP            bool
+		DebugAddress          string
+		DebugToken            string
+		ServeResources        bool
+		UseEndpointSlices     bool
+		ProcessingWorkers     int
+		FlushInterval         time.Duration
+		LeaderElection        bool
+		LeaseNamespace        string
+		LeaseName             string
+		ResyncPeriod          time.Duration
+		PruneOnStartup        bool
+		ShutdownGracePeriod   time.Duration
+		StallTimeout          time.Duration
+		DisableFinalizers     bool
+		WebhookAddress        string
+		WebhookCertFile       string
+		WebhookKeyFile        string
+		ManageCRDs            bool
+		DisableInactive       bool
+		FilterUnhealthyNodes  bool
+		CertExpiryWarnDays    int
+	}
+	// NamespaceQuota defines the maximum objects CIS configures on BIG-IP
+	// for a namespace, 0 is unlimited.
+	NamespaceQuota struct {
+		MaxVirtualServers   int
+		MaxVirtualAddresses int
+		MaxPools            int
 	}
 	// CRInformer defines the structure of Custom Resource Informer
 	CRInformer struct {
-		namespace   string
-		stopCh      chan struct{}
-		vsInformer  cache.SharedIndexInformer
-		tsInformer  cache.SharedIndexInformer
-		svcInformer cache.SharedIndexInformer
-		epsInformer cache.SharedIndexInformer
+		namespace         string
+		stopCh            chan struct{}
+		vsInformer        cache.SharedIndexInformer
+		tsInformer        cache.SharedIndexInformer
+		transportInformer cache.SharedIndexInformer
+		ilInformer        cache.SharedIndexInformer
+		ednsInformer      cache.SharedIndexInformer
+		plcInformer       cache.SharedIndexInformer
+		svcInformer       cache.SharedIndexInformer
+		epsInformer       cache.SharedIndexInformer
+		sliceInformer     cache.SharedIndexInformer
+		podInformer       cache.SharedIndexInformer
+		secretInformer    cache.SharedIndexInformer
+	}
+
+	// finalizerKey is the key of a deleted resource in finalizerQueue
+	finalizerKey struct {
+		kind      string
+		namespace string
+		name      string
 	}
 
 	rqKey struct {
@@ -91,9 +306,21 @@ type (
 	}
 
 	metaData struct {
-		Active       bool
+		// A pool has members, or a default or backup pool is configured
+		Active bool
+		// The virtual was reported inactive with an Event
+		inactive     bool
 		ResourceType string
 		rscName      string
+		// VirtualServers configured on the virtual, key is namespace/name
+		owners []string
+		// VirtualServer whose WAF policy is attached to the virtual
+		wafOwner string
+		// Pools of A/B deployments by VirtualServer, they may not be
+		// referred by the rules.
+		abPools map[string][]string
+		// All the VirtualServers configured on the virtual disable it
+		disabled bool
 	}
 
 	// Virtual Server Key - unique server is Name + Port
@@ -115,8 +342,17 @@ type (
 		Policies              []nameRef             `json:"policies,omitempty"`
 		Profiles              ProfileRefs           `json:"profiles,omitempty"`
 		IRules                []string              `json:"rules,omitempty"`
+		PersistenceProfile    string                `json:"persist,omitempty"`
+		WAF                   string                `json:"waf,omitempty"`
+		Firewall              string                `json:"firewall,omitempty"`
+		AllowVLANs            []string              `json:"allowVlans,omitempty"`
+		ConnectionLimit       int32                 `json:"connectionLimit,omitempty"`
+		RateLimit             int32                 `json:"rateLimit,omitempty"`
+		Mode                  string                `json:"mode,omitempty"`
 		Description           string                `json:"description,omitempty"`
 		VirtualAddress        *virtualAddress       `json:"-"`
+		PortList              []string              `json:"portList,omitempty"`
+		KeepClientPort        bool                  `json:"keepClientPort,omitempty"`
 	}
 	// Virtuals is slice of virtuals
 	Virtuals []Virtual
@@ -125,12 +361,17 @@ type (
 	SourceAddrTranslation struct {
 		Type string `json:"type"`
 		Pool string `json:"pool,omitempty"`
+		// Addresses of the SNAT pool declared with the virtual
+		Addresses []string `json:"addresses,omitempty"`
 	}
 
 	// frontend bindaddr and port
 	virtualAddress struct {
 		BindAddr string `json:"bindAddr,omitempty"`
 		Port     int32  `json:"port,omitempty"`
+		// ICMP echo of the address, empty for the default of BIG-IP
+		ICMPEcho    string `json:"icmpEcho,omitempty"`
+		ARPDisabled bool   `json:"arpDisabled,omitempty"`
 	}
 
 	// nameRef is virtual server policy/profile reference
@@ -149,21 +390,88 @@ type (
 	// ResourceConfigs is group of ResourceConfig
 	ResourceConfigs []*ResourceConfig
 
+	// ResourceConfigDiff is the sections of a ResourceConfig changed from
+	// its old config
+	ResourceConfigDiff struct {
+		// Virtual or its metadata changed, or the config is added or
+		// deleted
+		Virtual  bool
+		Profiles bool
+		Policies bool
+		// Pools added, deleted or changed other than their members
+		Pools []string
+		// Pools with only their members changed
+		PoolMembers []string
+	}
+
 	ResourceConfigWrapper struct {
 		rsCfgs         ResourceConfigs
 		iRuleMap       IRulesMap
 		intDgMap       InternalDataGroupMap
 		customProfiles *CustomProfileStore
+		dnsConfig      DNSConfig
+		// Partitions declared even when they have no virtuals
+		partitions []string
+		// Partition of the iRules and data groups of all the virtuals
+		sharedPartition string
+		// Pod networks of the nodes, the static members in them need ARP
+		// entries
+		podNetworks []*net.IPNet
+		// Members of the configs last posted removed from the configs
+		removedMembers []Member
+	}
+
+	// DNSConfig is the Wide-IPs of the ExternalDNS resources, key is
+	// namespace/name of the ExternalDNS
+	DNSConfig map[string]WideIP
+
+	// WideIP is a Wide-IP on BIG-IP DNS
+	WideIP struct {
+		DomainName string     `json:"domainName"`
+		RecordType string     `json:"recordType"`
+		LBMethod   string     `json:"loadBalancingMode"`
+		Pools      []GSLBPool `json:"pools"`
+	}
+
+	// GSLBPool is a pool of a Wide-IP, the members are the virtuals of
+	// the VirtualServers with the domain name as host.
+	GSLBPool struct {
+		Name       string    `json:"name"`
+		RecordType string    `json:"recordType"`
+		LBMethod   string    `json:"loadBalancingMode"`
+		DataServer string    `json:"dataServer"`
+		Members    []string  `json:"members"`
+		Monitors   []Monitor `json:"monitors,omitempty"`
 	}
 
 	// Pool config
 	Pool struct {
-		Name            string   `json:"name"`
-		Partition       string   `json:"-"`
-		ServiceName     string   `json:"-"`
-		ServicePort     int32    `json:"-"`
+		Name             string `json:"name"`
+		Partition        string `json:"-"`
+		ServiceName      string `json:"-"`
+		ServiceNamespace string `json:"-"`
+		ServicePort      int32  `json:"-"`
+		// Name of the port of the service, instead of ServicePort
+		ServicePortName string   `json:"-"`
 		Members         []Member `json:"members"`
 		NodeMemberLabel string   `json:"-"`
+		// MemberType is either cluster or nodeport
+		MemberType string `json:"-"`
+		// Whether the endpoints which are not ready are members
+		IncludeNotReady bool `json:"-"`
+		// Backup members and priority group activation of the pool
+		Backup           *cisapiv1.BackupPool `json:"-"`
+		PriorityGroup    int32                `json:"-"`
+		MinActiveMembers int32                `json:"-"`
+		// Action on the connections to a member going down
+		ServiceDownAction string `json:"-"`
+		// Members outside the cluster, kept with the members of the service
+		StaticMembers []Member `json:"-"`
+		MonitorNames  []string `json:"monitors,omitempty"`
+		// Monitor created with the pool, instead of MonitorNames
+		Monitor *Monitor `json:"-"`
+		// Number of monitors up for a member to be up, 0 is all of them
+		MinimumMonitors int32 `json:"-"`
 	}
 	// Pools is slice of pool
 	Pools []Pool
@@ -177,6 +485,9 @@ type (
 		Send      string `json:"send,omitempty"`
 		Recv      string `json:"recv,omitempty"`
 		Timeout   int    `json:"timeout,omitempty"`
+		// Destination checked instead of the members
+		TargetAddress string `json:"targetAddress,omitempty"`
+		TargetPort    int32  `json:"targetPort,omitempty"`
 	}
 	// Monitors  is slice of monitor
 	Monitors []Monitor
@@ -194,6 +505,8 @@ type (
 	action struct {
 		Name      string `json:"name"`
 		Pool      string `json:"pool,omitempty"`
+		Code      int32  `json:"code,omitempty"`
+		Drop      bool   `json:"drop,omitempty"`
 		HTTPHost  bool   `json:"httpHost,omitempty"`
 		HttpReply bool   `json:"httpReply,omitempty"`
 		HTTPURI   bool   `json:"httpUri,omitempty"`
@@ -201,10 +514,13 @@ type (
 		Location  string `json:"location,omitempty"`
 		Path      string `json:"path,omitempty"`
 		Redirect  bool   `json:"redirect,omitempty"`
+		Remove    bool   `json:"remove,omitempty"`
 		Replace   bool   `json:"replace,omitempty"`
 		Request   bool   `json:"request,omitempty"`
+		Response  bool   `json:"response,omitempty"`
 		Reset     bool   `json:"reset,omitempty"`
 		Select    bool   `json:"select,omitempty"`
+		TmName    string `json:"tmName,omitempty"`
 		Value     string `json:"value,omitempty"`
 	}
 
@@ -219,11 +535,14 @@ type (
 		HTTPHost        bool     `json:"httpHost,omitempty"`
 		Host            bool     `json:"host,omitempty"`
 		HTTPURI         bool     `json:"httpUri,omitempty"`
+		HTTPMethod      bool     `json:"httpMethod,omitempty"`
 		Index           int      `json:"index,omitempty"`
 		Matches         bool     `json:"matches,omitempty"`
 		Path            bool     `json:"path,omitempty"`
 		PathSegment     bool     `json:"pathSegment,omitempty"`
 		Present         bool     `json:"present,omitempty"`
+		QueryParameter  bool     `json:"queryParameter,omitempty"`
+		ParameterName   string   `json:"parameterName,omitempty"`
 		Remote          bool     `json:"remote,omitempty"`
 		Request         bool     `json:"request,omitempty"`
 		Scheme          bool     `json:"scheme,omitempty"`
@@ -285,6 +604,8 @@ type (
 		Name      string `json:"name"`
 		Partition string `json:"partition"`
 		Context   string `json:"context"` // 'clientside', 'serverside', or 'all'
+		// Type of the profile, http or tcp, empty for SSL profiles
+		Type string `json:"-"`
 		// Used as reference to which Namespace/Ingress this profile came from
 		// (for deletion purposes)
 		Namespace string `json:"-"`
@@ -295,6 +616,9 @@ type (
 	SecretKey struct {
 		Name         string
 		ResourceName string
+		// Partition of the virtual, the virtuals of different partitions
+		// may have the same name
+		Partition string
 	}
 
 	// SSL Profile loaded from Secret or Route object
@@ -304,13 +628,49 @@ type (
 		Context      string `json:"context"` // 'clientside', 'serverside', or 'all'
 		Cert         string `json:"cert"`
 		Key          string `json:"key"`
+		ChainCert    string `json:"chainCert,omitempty"`
 		ServerName   string `json:"serverName,omitempty"`
 		SNIDefault   bool   `json:"sniDefault,omitempty"`
 		PeerCertMode string `json:"peerCertMode,omitempty"`
 		CAFile       string `json:"caFile,omitempty"`
+		// CABundle is the path of the CA bundle on BIG-IP used instead
+		// of CAFile
+		CABundle string `json:"caBundle,omitempty"`
+		// sniPriority ranks the profile to be the SNI default of the
+		// virtual
+		sniPriority int
+		// hosts are the hosts the certificate must cover
+		hosts []string
+	}
+
+	// clientAuth contains the client certificate authentication of a
+	// profile created from a Secret
+	clientAuth struct {
+		peerCertMode string
+		caFile       string
+		caBundle     string
+	}
+
+	// sniConfig contains the SNI selection of a profile created from a
+	// Secret, the profile is selected for the server name unless it is
+	// the default. The certificate must cover the hosts.
+	sniConfig struct {
+		serverName string
+		priority   int
+		hosts      []string
 	}
 )
 
+// Priorities of the profiles created from Secrets to be the SNI default of
+// the virtual
+const (
+	sniPriorityNone = iota
+	// The first TLSProfile of a VirtualServer with several TLSProfiles
+	sniPriorityFirst
+	// The TLSProfile with sniDefault
+	sniPriorityRequested
+)
+
 type serviceQueueKey struct {
 	Namespace   string
 	ServiceName string
@@ -322,11 +682,64 @@ type serviceQueueKey struct {
 type (
 	Agent struct {
 		*PostManager
+		// DeclWriter writes the declarations, the PostManager posting them
+		// to BIG-IP unless in dry-run mode
+		DeclWriter DeclarationWriter
+		// ObjectStore lists and removes the objects on BIG-IP, nil in
+		// dry-run mode
+		ObjectStore     BigIPObjectStore
 		Partition       string
 		ConfigWriter    writer.Writer
 		EventChan       chan interface{}
 		PythonDriverPID int
-		activeDecl      as3Declaration
+		// Tenants of the partitions last written, applied once posted
+		activeTenants map[string]activeTenant
+		// AS3 on BIG-IP declares virtuals with port lists
+		portListSupported bool
+		// The members were written to VxlanMgr, the first update after a
+		// restart replaces all the ARP entries
+		membersWritten bool
+		// Removed members not written to VxlanMgr yet
+		removedMembers []Member
+		// HTTPMux serves /health and the handlers of the controller on
+		// httpAddress
+		HTTPMux     *http.ServeMux
+		httpAddress string
+		// 1 once the controller is shutting down, accessed atomically
+		draining int32
+		// Checks failing the health check when the controller is not live
+		livenessMutex  sync.Mutex
+		livenessChecks []func() error
+	}
+
+	// activeTenant is the hash of the tenant of a partition written with the
+	// declaration of sequence number seq
+	activeTenant struct {
+		hash string
+		seq  uint64
+	}
+
+	// DeclarationWriter writes the AS3 declarations of the Agent
+	DeclarationWriter interface {
+		Write(data string, partitions []string)
+		// Cancel drops the declarations written and not posted yet
+		Cancel()
+		// Written returns the sequence number of the last declaration
+		// written, Posted of the last one posted
+		Written() uint64
+		Posted() uint64
+		// PartitionPosted returns the sequence number of the last
+		// declaration of which the partition is posted
+		PartitionPosted(partition string) uint64
+	}
+
+	// BigIPObjectStore queries and removes the objects of the Shared
+	// application of the partitions on BIG-IP
+	BigIPObjectStore interface {
+		// ListObjects returns the names of the objects of the partition
+		ListObjects(partition string) ([]string, error)
+		// DeleteObjects removes the objects of the partition
+		DeleteObjects(partition string, names []string) error
 	}
 
 	AgentParams struct {
@@ -337,6 +750,7 @@ type (
 		VerifyInterval int
 		VXLANName      string
 		PythonBaseDir  string
+		HTTPAddress    string
 	}
 
 	globalSection struct {
@@ -398,6 +812,7 @@ type (
 		Enabled  *bool                   `json:"enabled,omitempty"`
 		Location string                  `json:"location,omitempty"`
 		Replace  *as3ActionReplaceMap    `json:"replace,omitempty"`
+		Remove   *as3ActionRemoveMap     `json:"remove,omitempty"`
 	}
 
 	as3ActionReplaceMap struct {
@@ -406,6 +821,10 @@ type (
 		Path  string `json:"path,omitempty"`
 	}
 
+	as3ActionRemoveMap struct {
+		Name string `json:"name"`
+	}
+
 	// as3Condition maps to Policy_Condition in AS3 Resources
 	as3Condition struct {
 		Type        string                  `json:"type,omitempty"`
@@ -416,6 +835,10 @@ type (
 		Host        *as3PolicyCompareString `json:"host,omitempty"`
 		PathSegment *as3PolicyCompareString `json:"pathSegment,omitempty"`
 		Path        *as3PolicyCompareString `json:"path,omitempty"`
+		// QueryParameter matches the value of the query parameter Name
+		QueryParameter *as3PolicyCompareString `json:"queryParameter,omitempty"`
+		// Address matches the source address of the tcp condition
+		Address *as3PolicyMatchAddress `json:"address,omitempty"`
 	}
 
 	// as3ActionForwardSelect maps to Policy_Action_Forward_Select in AS3 Resources
@@ -435,12 +858,21 @@ type (
 		Operand       string   `json:"operand"`
 	}
 
+	// as3PolicyMatchAddress maps to Policy_Match_IP_Address in AS3 Resources
+	as3PolicyMatchAddress struct {
+		Values  []string `json:"values"`
+		Operand string   `json:"operand"`
+	}
+
 	// as3Pool maps to Pool in AS3 Resources
 	as3Pool struct {
-		Class             string               `json:"class,omitempty"`
-		LoadBalancingMode string               `json:"loadBalancingMode,omitempty"`
-		Members           []as3PoolMember      `json:"members,omitempty"`
-		Monitors          []as3ResourcePointer `json:"monitors,omitempty"`
+		Class                string               `json:"class,omitempty"`
+		LoadBalancingMode    string               `json:"loadBalancingMode,omitempty"`
+		Members              []as3PoolMember      `json:"members,omitempty"`
+		Monitors             []as3ResourcePointer `json:"monitors,omitempty"`
+		MinimumMonitors      as3MultiTypeParam    `json:"minimumMonitors,omitempty"`
+		MinimumMembersActive int32                `json:"minimumMembersActive,omitempty"`
+		ServiceDownAction    string               `json:"serviceDownAction,omitempty"`
 	}
 
 	// as3PoolMember maps to Pool_Member in AS3 Resources
@@ -448,6 +880,8 @@ type (
 		AddressDiscovery string   `json:"addressDiscovery,omitempty"`
 		ServerAddresses  []string `json:"serverAddresses,omitempty"`
 		ServicePort      int32    `json:"servicePort,omitempty"`
+		AdminState       string   `json:"adminState,omitempty"`
+		PriorityGroup    int32    `json:"priorityGroup,omitempty"`
 	}
 
 	// as3ResourcePointer maps to following in AS3 Resources
@@ -461,32 +895,93 @@ type (
 		Use   string `json:"use,omitempty"`
 	}
 
+	// as3ProfileTCP maps to the client side (ingress) and server side
+	// (egress) TCP profiles of a Service in AS3 Resources
+	as3ProfileTCP struct {
+		Ingress *as3ResourcePointer `json:"ingress,omitempty"`
+		Egress  *as3ResourcePointer `json:"egress,omitempty"`
+	}
+
 	// as3Service maps to the following in AS3 Resources
 	// - Service_HTTP
 	// - Service_HTTPS
 	// - Service_TCP
 	// - Service_UDP
 	as3Service struct {
-		Layer4                 string            `json:"layer4,omitempty"`
-		Source                 string            `json:"source,omitempty"`
-		TranslateServerAddress bool              `json:"translateServerAddress,omitempty"`
-		TranslateServerPort    bool              `json:"translateServerPort,omitempty"`
-		Class                  string            `json:"class,omitempty"`
-		VirtualAddresses       []string          `json:"virtualAddresses,omitempty"`
-		VirtualPort            int               `json:"virtualPort,omitempty"`
-		SNAT                   string            `json:"snat,omitempty"`
-		PolicyEndpoint         as3MultiTypeParam `json:"policyEndpoint,omitempty"`
-		ClientTLS              as3MultiTypeParam `json:"clientTLS,omitempty"`
-		ServerTLS              as3MultiTypeParam `json:"serverTLS,omitempty"`
-		IRules                 []string          `json:"iRules,omitempty"`
-		Redirect80             *bool             `json:"redirect80,omitempty"`
-		Pool                   string            `json:"pool,omitempty"`
+		Layer4                 string               `json:"layer4,omitempty"`
+		Source                 string               `json:"source,omitempty"`
+		TranslateServerAddress bool                 `json:"translateServerAddress,omitempty"`
+		TranslateServerPort    bool                 `json:"translateServerPort"`
+		Class                  string               `json:"class,omitempty"`
+		VirtualAddresses       []as3MultiTypeParam  `json:"virtualAddresses,omitempty"`
+		VirtualPort            as3MultiTypeParam    `json:"virtualPort,omitempty"`
+		SNAT                   as3MultiTypeParam    `json:"snat,omitempty"`
+		PolicyEndpoint         as3MultiTypeParam    `json:"policyEndpoint,omitempty"`
+		ClientTLS              as3MultiTypeParam    `json:"clientTLS,omitempty"`
+		ServerTLS              as3MultiTypeParam    `json:"serverTLS,omitempty"`
+		IRules                 []as3MultiTypeParam  `json:"iRules,omitempty"`
+		PersistenceMethods     []as3MultiTypeParam  `json:"persistenceMethods,omitempty"`
+		PolicyWAF              as3MultiTypeParam    `json:"policyWAF,omitempty"`
+		PolicyFirewallEnforced as3MultiTypeParam    `json:"policyFirewallEnforced,omitempty"`
+		ProfileHTTP            as3MultiTypeParam    `json:"profileHTTP,omitempty"`
+		ProfileTCP             as3MultiTypeParam    `json:"profileTCP,omitempty"`
+		AllowVLANs             []as3ResourcePointer `json:"allowVlans,omitempty"`
+		MaxConnections         int32                `json:"maxConnections,omitempty"`
+		RateLimit              int32                `json:"rateLimit,omitempty"`
+		Redirect80             *bool                `json:"redirect80,omitempty"`
+		Enable                 *bool                `json:"enable,omitempty"`
+		Pool                   string               `json:"pool,omitempty"`
+	}
+
+	// as3SNATPool maps to SNAT_Pool in AS3 Resources
+	as3SNATPool struct {
+		Class         string   `json:"class"`
+		SNATAddresses []string `json:"snatAddresses"`
+	}
+
+	// as3PortList maps to Net_Port_List in AS3 Resources
+	as3PortList struct {
+		Class string              `json:"class"`
+		Ports []as3MultiTypeParam `json:"ports"`
+	}
+
+	// as3ServiceAddress maps to Service_Address in AS3 Resources
+	as3ServiceAddress struct {
+		Class          string `json:"class"`
+		VirtualAddress string `json:"virtualAddress"`
+		ARPEnabled     bool   `json:"arpEnabled"`
+		ICMPEcho       string `json:"icmpEcho,omitempty"`
+	}
+
+	// as3GSLBDomain maps to GSLB_Domain in AS3 Resources
+	as3GSLBDomain struct {
+		Class              string               `json:"class"`
+		DomainName         string               `json:"domainName"`
+		ResourceRecordType string               `json:"resourceRecordType"`
+		PoolLbMode         string               `json:"poolLbMode,omitempty"`
+		Pools              []as3ResourcePointer `json:"pools,omitempty"`
+	}
+
+	// as3GSLBPool maps to GSLB_Pool in AS3 Resources
+	as3GSLBPool struct {
+		Class              string               `json:"class"`
+		ResourceRecordType string               `json:"resourceRecordType"`
+		LBModePreferred    string               `json:"lbModePreferred,omitempty"`
+		Members            []as3GSLBPoolMember  `json:"members,omitempty"`
+		Monitors           []as3ResourcePointer `json:"monitors,omitempty"`
+	}
+
+	// as3GSLBPoolMember maps to GSLB_Pool_Member in AS3 Resources
+	as3GSLBPoolMember struct {
+		Server        as3ResourcePointer `json:"server"`
+		VirtualServer string             `json:"virtualServer"`
 	}
 
 	// as3Monitor maps to the following in AS3 Resources
 	// - Monitor
 	// - Monitor_HTTP
 	// - Monitor_HTTPS
+	// - GSLB_Monitor
 	as3Monitor struct {
 		Class             string  `json:"class,omitempty"`
 		Interval          int     `json:"interval,omitempty"`
@@ -524,11 +1019,15 @@ type (
 		Ciphers       string                     `json:"ciphers,omitempty"`
 		CipherGroup   *as3ResourcePointer        `json:"cipherGroup,omitempty"`
 		Tls1_3Enabled bool                       `json:"tls1_3Enabled,omitempty"`
+
+		AuthenticationMode    string            `json:"authenticationMode,omitempty"`
+		AuthenticationTrustCA as3MultiTypeParam `json:"authenticationTrustCA,omitempty"`
 	}
 
 	// as3TLSServerCertificates maps to TLS_Server_certificates in AS3 Resources
 	as3TLSServerCertificates struct {
 		Certificate string `json:"certificate,omitempty"`
+		MatchToSNI  string `json:"matchToSNI,omitempty"`
 	}
 
 	// as3TLSClient maps to TLS_Client in AS3 Resources
@@ -561,5 +1060,12 @@ type (
 		Address string `json:"address"`
 		Port    int32  `json:"port"`
 		Session string `json:"session,omitempty"`
+		// AdminState is disable for the members of terminating pods,
+		// draining their connections.
+		AdminState string `json:"adminState,omitempty"`
+		// PriorityGroup is 0 for the backup members of the pool.
+		PriorityGroup int32 `json:"priorityGroup,omitempty"`
+		// Static members are outside the cluster
+		Static bool `json:"-"`
 	}
 )
@@ -18,9 +18,17 @@ package crmanager
 
 import (
 	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
 
 	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func (crMgr *CRManager) checkValidVirtualServer(
@@ -43,14 +51,1238 @@ func (crMgr *CRManager) checkValidVirtualServer(
 		return false
 	}
 
-	bindAddr := vsResource.Spec.VirtualServerAddress
+	if err := ValidateVirtualServer(vsResource,
+		crMgr.validationOptions()); err != nil {
+		log.Errorf("VirtualServer %s rejected: %v", vkey, err)
+		crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+			specErrorReason(err, "InvalidData"), err.Error())
+		return false
+	}
+
+	// The members of a host group agree on the address and TLS
+	if err := crMgr.checkHostGroupMember(vsResource); err != nil {
+		log.Errorf("VirtualServer %s rejected: %v", vkey, err)
+		crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+			"HostGroupConflict", err.Error())
+		return false
+	}
 
-	// This ensures that pool-only mode only logs the message below the first
-	// time we see a config.
-	if bindAddr == "" {
-		log.Infof("No IP was specified for the virtual server %s", vsName)
+	// Reject the VirtualServer without a valid IP, instead of creating
+	// a virtual without destination on BIG-IP. The address allocated by
+	// IPAM is known to the controller only.
+	if err := validateVirtualServerAddress(
+		crMgr.getVirtualServerAddress(vsResource)); err != nil {
+		log.Errorf("VirtualServer %s rejected: %v", vkey, err)
+		crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+			"InvalidData", err.Error())
 		return false
 	}
 
+	// Persistence records of the client address are shared by all the
+	// paths, the requests of a client go to the same virtual whatever
+	// the path. The Event is recorded once per generation.
+	if vsResource.Spec.PersistenceProfile == PersistenceSourceAddr &&
+		hasMultiplePaths(vsResource) {
+		msg := "source_addr persistence applies to all the paths of the " +
+			"VirtualServer"
+		if crMgr.setPersistenceWarned(vsResource) {
+			log.Warningf("VirtualServer %s: %s", vkey, msg)
+			crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+				"PersistenceWarning", msg)
+		} else {
+			crMgr.recordVirtualServerWarning(vsResource,
+				v1.EventTypeWarning, msg)
+		}
+	} else {
+		crMgr.clearPersistenceWarned(vkey)
+	}
+
+	// The header is inserted in the HTTPS responses only
+	if hstsHeader(vsResource.Spec.HSTS) != "" &&
+		0 == len(getTLSProfileNames(vsResource)) {
+		msg := "hsts requires a TLSProfile, HTTP responses do not get " +
+			"the Strict-Transport-Security header"
+		log.Warningf("VirtualServer %s: %s", vkey, msg)
+		crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+			"HSTSWarning", msg)
+	}
+
 	return true
 }
+
+// ValidationOptions are the settings of CIS the specs of the resources are
+// validated with
+type ValidationOptions struct {
+	// Partition of CIS and AllowedPartitions of the VirtualServers
+	Partition         string
+	AllowedPartitions []string
+	// PoolMemberType of the pools without poolMemberType
+	PoolMemberType string
+}
+
+// validationOptions returns the settings of CIS the specs are validated with
+func (crMgr *CRManager) validationOptions() ValidationOptions {
+	return ValidationOptions{
+		Partition:         crMgr.Partition,
+		AllowedPartitions: crMgr.AllowedPartitions,
+		PoolMemberType:    crMgr.getPoolMemberType(""),
+	}
+}
+
+// specError is an invalid spec reported with its own Event reason
+type specError struct {
+	reason string
+	err    error
+}
+
+func (e specError) Error() string {
+	return e.err.Error()
+}
+
+// invalidSpec returns the error of an invalid spec reported with reason
+func invalidSpec(reason, format string, args ...interface{}) error {
+	return specError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+// specErrorReason returns the Event reason of the validation error, reason
+// when the error has none
+func specErrorReason(err error, reason string) string {
+	if se, ok := err.(specError); ok {
+		return se.reason
+	}
+	return reason
+}
+
+// ValidateVirtualServer returns an error if the spec of the VirtualServer
+// can not be configured on BIG-IP. The VirtualServers are validated with it
+// when synced and by the admission webhook, the resources it refers to are
+// not looked up.
+func ValidateVirtualServer(
+	vsResource *cisapiv1.VirtualServer,
+	opts ValidationOptions,
+) error {
+	// The address is allocated by IPAM or shared by the host group when not
+	// provided
+	if vsResource.Spec.VirtualServerAddress != "" ||
+		(vsResource.Spec.IPAMLabel == "" && vsResource.Spec.HostGroup == "") {
+		if err := validateVirtualServerAddress(
+			vsResource.Spec.VirtualServerAddress); err != nil {
+			return err
+		}
+	}
+
+	if err := validateHostGroup(vsResource.Spec.HostGroup); err != nil {
+		return err
+	}
+
+	if err := validateVirtualServerHosts(vsResource); err != nil {
+		return err
+	}
+
+	for _, pool := range vsResource.Spec.Pools {
+		if err := validatePoolPorts(pool); err != nil {
+			return err
+		}
+	}
+
+	pools := getVirtualServerPools(vsResource)
+	for _, pool := range pools {
+		if getPoolWeight(pool) < 0 {
+			return fmt.Errorf("Invalid weight %d of path '%s', it must "+
+				"not be negative", getPoolWeight(pool), pool.Path)
+		}
+		for _, validate := range []func(cisapiv1.Pool) error{
+			validatePoolPath,
+			validatePoolRewrite,
+			validatePoolAction,
+			validatePoolMatch,
+			validatePoolBackup,
+			validatePoolServiceDownAction,
+			validatePoolStaticMembers,
+			validatePoolServicePort,
+			validatePoolMonitors,
+			validatePoolNodeSelector,
+			validatePoolResponseHeaders,
+			validatePoolSourceRange,
+		} {
+			if err := validate(pool); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := validatePoolServicePorts(pools); err != nil {
+		return err
+	}
+
+	if err := validateDuplicatePaths(pools); err != nil {
+		return err
+	}
+
+	if err := validatePoolMemberTypes(pools, opts.PoolMemberType); err != nil {
+		return err
+	}
+
+	if err := validateVirtualServerPartition(vsResource, opts); err != nil {
+		return err
+	}
+
+	if err := ValidateSNAT(vsResource.Spec.SNAT); err != nil {
+		return err
+	}
+
+	if err := validateSNATAddresses(vsResource); err != nil {
+		return err
+	}
+
+	if err := ValidateICMPEcho(vsResource.Spec.ICMPEcho); err != nil {
+		return err
+	}
+
+	if err := validateHTTPTraffic(vsResource.Spec.HTTPTraffic); err != nil {
+		return err
+	}
+
+	if waf := vsResource.Spec.WAF; waf != "" && !isBigIPPath(waf) {
+		return fmt.Errorf("Invalid waf '%s', it must be a path like "+
+			"/Common/WAF_Policy", waf)
+	}
+
+	for _, vlan := range vsResource.Spec.AllowVLANs {
+		if vlan == "" || (strings.Contains(vlan, "/") && !isBigIPPath(vlan)) {
+			return fmt.Errorf("Invalid VLAN '%s' in allowVlans, it must "+
+				"be a name or a path like /Common/external", vlan)
+		}
+	}
+
+	if err := validateSourceRanges(vsResource.Spec.AllowSourceRange,
+		"allowSourceRange"); err != nil {
+		return err
+	}
+	// The requests of the default pool match no rule of the policy
+	if len(vsResource.Spec.AllowSourceRange) > 0 &&
+		nil != vsResource.Spec.DefaultPool {
+		return fmt.Errorf("allowSourceRange cannot apply to the " +
+			"defaultPool, set it on the pools instead")
+	}
+
+	if vsResource.Spec.ConnectionLimit < 0 || vsResource.Spec.RateLimit < 0 {
+		return fmt.Errorf("connectionLimit and rateLimit must not be negative")
+	}
+
+	if err := validateRedirect(vsResource.Spec.RedirectCode,
+		vsResource.Spec.RedirectHost); err != nil {
+		return err
+	}
+
+	if err := validateHSTS(vsResource.Spec.HSTS); err != nil {
+		return err
+	}
+
+	if err := validateMaintenance(vsResource.Spec.Maintenance); err != nil {
+		return err
+	}
+
+	if err := validatePersistenceProfile(
+		vsResource.Spec.PersistenceProfile); err != nil {
+		return err
+	}
+
+	return validateProfileNames(vsResource.Spec.Profiles)
+}
+
+// ValidateTLSProfile returns an error if the spec of the TLSProfile can not
+// be configured on BIG-IP. The TLSProfiles are validated with it when the
+// VirtualServers using them are synced and by the admission webhook, the
+// secrets it refers to are not looked up.
+func ValidateTLSProfile(tls *cisapiv1.TLSProfile) error {
+	switch tls.Spec.TLS.Termination {
+	case "", TLSEdge, TLSReencrypt:
+	case TLSPassthrough:
+		// TLS is not terminated, the profiles are not used
+		return nil
+	default:
+		return fmt.Errorf("Termination '%s' is neither %s, %s nor %s",
+			tls.Spec.TLS.Termination, TLSEdge, TLSReencrypt, TLSPassthrough)
+	}
+
+	switch tls.Spec.TLS.Reference {
+	case BIGIP:
+		// Client authentication of the BIG-IP profiles is configured
+		// on BIG-IP, the profiles are not attached so they do not
+		// accept all the clients.
+		if tls.Spec.TLS.ClientAuth != nil {
+			return invalidSpec("InvalidClientAuth",
+				"clientAuth requires the %s reference", Secret)
+		}
+		for _, profile := range []string{
+			tls.Spec.TLS.ClientSSL,
+			tls.Spec.TLS.ServerSSL,
+		} {
+			if profile == "" {
+				continue
+			}
+			ref := ConvertStringToProfileRef(profile, CustomProfileAll, "")
+			if ref.Name == "" {
+				return invalidSpec("InvalidProfile", "Profile name '%s' is "+
+					"formatted incorrectly", profile)
+			}
+		}
+	case Secret:
+		if err := validateClientAuth(tls.Spec.TLS.ClientAuth); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Reference '%s' is neither %s nor %s",
+			tls.Spec.TLS.Reference, BIGIP, Secret)
+	}
+
+	if tls.Spec.TLS.Termination == TLSReencrypt &&
+		tls.Spec.TLS.ServerSSL == "" {
+		return fmt.Errorf("serverSSL is required for %s termination",
+			TLSReencrypt)
+	}
+	return nil
+}
+
+// validateClientAuth returns an error if the mode of the client
+// authentication is neither require nor request, or the CA certificates
+// are not provided
+func validateClientAuth(ca *cisapiv1.ClientAuth) error {
+	if nil == ca {
+		return nil
+	}
+	switch ca.Mode {
+	case "", PeerCertRequired, PeerCertRequested:
+	default:
+		return invalidSpec("InvalidClientAuth", "Mode '%s' is neither %s "+
+			"nor %s", ca.Mode, PeerCertRequired, PeerCertRequested)
+	}
+	if ca.CACertificate == "" {
+		return invalidSpec("InvalidClientAuth", "caCertificate not specified")
+	}
+	return nil
+}
+
+// validateHTTPTraffic returns an error if the httpTraffic is neither allow,
+// none nor redirect
+func validateHTTPTraffic(httpTraffic string) error {
+	switch httpTraffic {
+	case "", "allow", "none", "redirect":
+		return nil
+	}
+	return fmt.Errorf("Invalid httpTraffic '%s', it must be allow, none or "+
+		"redirect", httpTraffic)
+}
+
+// validateDuplicatePaths returns an error if pools route the same requests
+// to the same port of a service, or two actions handle the same requests
+func validateDuplicatePaths(pools []cisapiv1.Pool) error {
+	routes := make(map[string]bool)
+	for _, pool := range pools {
+		key := strings.Join([]string{getPathMatchType(pool), pool.Path,
+			getMatchKey(pool), pool.Service, formatPoolPort(pool.ServicePort)},
+			"|")
+		if !routes[key] {
+			routes[key] = true
+			continue
+		}
+		if pool.Service == "" {
+			return fmt.Errorf("Duplicate path '%s'", pool.Path)
+		}
+		return fmt.Errorf("Duplicate path '%s' of service '%s'", pool.Path,
+			pool.Service)
+	}
+	return nil
+}
+
+func (crMgr *CRManager) checkValidTransportServer(
+	ts *cisapiv1.TransportServer,
+) bool {
+	tsNamespace := ts.ObjectMeta.Namespace
+	tsKey := fmt.Sprintf("%s/%s", tsNamespace, ts.ObjectMeta.Name)
+
+	crInf, ok := crMgr.getNamespaceInformer(tsNamespace)
+	if !ok {
+		log.Errorf("Informer not found for namespace: %v", tsNamespace)
+		return false
+	}
+	_, found, _ := crInf.transportInformer.GetIndexer().GetByKey(tsKey)
+	if !found {
+		log.Infof("TransportServer %s is invalid", tsKey)
+		return false
+	}
+
+	if err := validateTransportServer(ts); err != nil {
+		log.Errorf("TransportServer %s rejected: %v", tsKey, err)
+		crMgr.recordEvent(ts, tsNamespace, v1.EventTypeWarning,
+			"InvalidData", err.Error())
+		return false
+	}
+	return true
+}
+
+func (crMgr *CRManager) checkValidIngressLink(
+	il *cisapiv1.IngressLink,
+) bool {
+	ilNamespace := il.ObjectMeta.Namespace
+	crInf, ok := crMgr.getNamespaceInformer(ilNamespace)
+	if !ok {
+		log.Errorf("Informer not found for namespace: %v", ilNamespace)
+		return false
+	}
+	_, found, _ := crInf.ilInformer.GetIndexer().GetByKey(ilKey(il))
+	if !found {
+		log.Infof("IngressLink %s is invalid", ilKey(il))
+		return false
+	}
+
+	if err := validateIngressLink(il); err != nil {
+		log.Errorf("IngressLink %s rejected: %v", ilKey(il), err)
+		crMgr.recordEvent(il, ilNamespace, v1.EventTypeWarning,
+			"InvalidData", err.Error())
+		return false
+	}
+	return true
+}
+
+// validateIngressLink returns an error if the spec of the IngressLink can
+// not be configured on BIG-IP
+func validateIngressLink(il *cisapiv1.IngressLink) error {
+	if err := validateAddress(il.Spec.VirtualServerAddress); err != nil {
+		return fmt.Errorf("Invalid virtualServerAddress: %v", err)
+	}
+	if nil == il.Spec.Selector {
+		return fmt.Errorf("The selector of the ingress controller service " +
+			"is not provided")
+	}
+	if _, err := metav1.LabelSelectorAsSelector(il.Spec.Selector); err != nil {
+		return fmt.Errorf("Invalid selector: %v", err)
+	}
+	for _, irule := range il.Spec.IRules {
+		if irule == "" {
+			return fmt.Errorf("Empty iRule name in iRules")
+		}
+	}
+	return nil
+}
+
+// validateTransportServer returns an error if the spec of the
+// TransportServer can not be configured on BIG-IP
+func validateTransportServer(ts *cisapiv1.TransportServer) error {
+	if err := validateAddress(ts.Spec.VirtualServerAddress); err != nil {
+		return fmt.Errorf("Invalid virtualServerAddress: %v", err)
+	}
+	if err := validateTransportServerPorts(ts); err != nil {
+		return err
+	}
+	switch ts.Spec.PortMapping {
+	case "", PortMappingSame, PortMappingOffset:
+	default:
+		return fmt.Errorf("Invalid portMapping '%s', it must be %s or %s",
+			ts.Spec.PortMapping, PortMappingSame, PortMappingOffset)
+	}
+	switch ts.Spec.Type {
+	case "", TransportServerTCP, TransportServerUDP:
+	default:
+		return fmt.Errorf("Invalid type '%s', it must be %s or %s",
+			ts.Spec.Type, TransportServerTCP, TransportServerUDP)
+	}
+	switch ts.Spec.Mode {
+	case "", TransportServerStandard, TransportServerPerformanceL4:
+	default:
+		return fmt.Errorf("Invalid mode '%s', it must be %s or %s",
+			ts.Spec.Mode, TransportServerStandard,
+			TransportServerPerformanceL4)
+	}
+	if ts.Spec.Pool.Service == "" {
+		return fmt.Errorf("The service of the pool is not provided")
+	}
+	if monitor := ts.Spec.Pool.Monitor; monitor != "" && !isBigIPPath(monitor) {
+		return fmt.Errorf("Invalid monitor '%s', it must be a path like "+
+			"/Common/tcp", monitor)
+	}
+	return nil
+}
+
+// validateTransportServerPorts returns an error unless the TransportServer
+// has exactly one of a port, ports or a port range, of valid ports
+func validateTransportServerPorts(ts *cisapiv1.TransportServer) error {
+	set := 0
+	for _, ok := range []bool{ts.Spec.VirtualServerPort != 0,
+		len(ts.Spec.VirtualServerPorts) > 0,
+		ts.Spec.VirtualServerPortRange != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("Exactly one of virtualServerPort, " +
+			"virtualServerPorts and virtualServerPortRange must be provided")
+	}
+	if rng := ts.Spec.VirtualServerPortRange; rng != "" {
+		start, end, err := parsePortRange(rng)
+		if err != nil {
+			return fmt.Errorf("Invalid virtualServerPortRange: %v", err)
+		}
+		if end-start+1 > maxTransportServerPorts {
+			return fmt.Errorf("virtualServerPortRange '%s' exceeds %d ports",
+				rng, maxTransportServerPorts)
+		}
+		return nil
+	}
+	if len(ts.Spec.VirtualServerPorts) > maxTransportServerPorts {
+		return fmt.Errorf("virtualServerPorts exceed %d ports",
+			maxTransportServerPorts)
+	}
+	seen := make(map[int32]bool)
+	for _, port := range getTransportServerPorts(ts) {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("Invalid virtualServerPort %d", port)
+		}
+		if seen[port] {
+			return fmt.Errorf("Port %d is listed twice in "+
+				"virtualServerPorts", port)
+		}
+		seen[port] = true
+	}
+	return nil
+}
+
+// validateVirtualServerHosts returns an error if the VirtualServer has both
+// a host and hosts, or if its hosts are empty or listed twice
+func validateVirtualServerHosts(vsResource *cisapiv1.VirtualServer) error {
+	if len(vsResource.Spec.Hosts) == 0 {
+		return nil
+	}
+	if vsResource.Spec.Host != "" {
+		return fmt.Errorf("host and hosts are mutually exclusive")
+	}
+	seen := make(map[string]bool)
+	for _, host := range vsResource.Spec.Hosts {
+		if host == "" || strings.ContainsAny(host, " /") {
+			return fmt.Errorf("Invalid host '%s' in hosts, it must be a "+
+				"host like example.com", host)
+		}
+		if seen[strings.ToLower(host)] {
+			return fmt.Errorf("Host '%s' is listed twice in hosts", host)
+		}
+		seen[strings.ToLower(host)] = true
+	}
+	return nil
+}
+
+// validateRedirect returns an error if the redirect code is not a redirect
+// status or the host cannot be a record of the redirect data group
+func validateRedirect(code int32, host string) error {
+	switch code {
+	case 0, 301, 302, 307, 308:
+	default:
+		return fmt.Errorf("Invalid redirectCode %d, it must be 301, 302, "+
+			"307 or 308", code)
+	}
+	if strings.ContainsAny(host, " |/") {
+		return fmt.Errorf("Invalid redirectHost '%s', it must be a host "+
+			"and optional port like example.com:8443", host)
+	}
+	return nil
+}
+
+// validateHSTS returns an error if the max-age is negative or the host
+// cannot be preloaded by browsers with the header
+func validateHSTS(hsts *cisapiv1.HSTS) error {
+	if nil == hsts || !hsts.Enabled {
+		return nil
+	}
+	maxAge := DefaultHSTSMaxAge
+	if nil != hsts.MaxAge {
+		maxAge = *hsts.MaxAge
+	}
+	if maxAge < 0 {
+		return fmt.Errorf("Invalid hsts maxAge %d, it must not be "+
+			"negative", maxAge)
+	}
+	if hsts.Preload && (!hsts.IncludeSubdomains || maxAge < DefaultHSTSMaxAge) {
+		return fmt.Errorf("hsts preload requires includeSubdomains and a "+
+			"maxAge of at least %d", DefaultHSTSMaxAge)
+	}
+	return nil
+}
+
+// validateMaintenance returns an error if the maintenance has neither or
+// both a redirectURL and a pool, or the redirect cannot be sent by the pool
+// action iRule
+func validateMaintenance(mt *cisapiv1.Maintenance) error {
+	if nil == mt {
+		return nil
+	}
+	if (mt.RedirectURL == "") == (mt.Pool == "") {
+		return fmt.Errorf("maintenance must have either a redirectURL or " +
+			"a pool")
+	}
+	if mt.Pool != "" && !isBigIPPath(mt.Pool) {
+		return fmt.Errorf("Invalid maintenance pool '%s', it must be a "+
+			"path like /Common/maintenance_pool", mt.Pool)
+	}
+	if strings.ContainsAny(mt.RedirectURL, " \t{}\\\"") {
+		return fmt.Errorf("Invalid maintenance redirectURL '%s', it must "+
+			"be a URL like https://example.com/maintenance", mt.RedirectURL)
+	}
+	return nil
+}
+
+// validatePersistenceProfile returns an error if the profile is neither a
+// built-in persistence type nor the path of a BIG-IP profile
+func validatePersistenceProfile(profile string) error {
+	switch profile {
+	case "", PersistenceCookie, PersistenceSourceAddr, PersistenceSSL:
+		return nil
+	}
+	if !isBigIPPath(profile) {
+		return fmt.Errorf("Invalid persistenceProfile '%s', it must be "+
+			"%s, %s, %s or a path like /Common/profile", profile,
+			PersistenceCookie, PersistenceSourceAddr, PersistenceSSL)
+	}
+	return nil
+}
+
+// ValidateSNAT returns an error if the SNAT is neither automap, none nor the
+// path of a SNAT pool
+func ValidateSNAT(snat string) error {
+	switch snat {
+	case "", SNATAutomap, SNATNone:
+		return nil
+	}
+	if !isBigIPPath(snat) {
+		return fmt.Errorf("Invalid snat '%s', it must be %s, %s or a path "+
+			"like /Common/snatpool", snat, SNATAutomap, SNATNone)
+	}
+	return nil
+}
+
+// validateSNATAddresses returns an error if the VirtualServer has both snat
+// and snatAddresses, or an invalid SNAT address
+func validateSNATAddresses(vsResource *cisapiv1.VirtualServer) error {
+	if len(vsResource.Spec.SNATAddresses) == 0 {
+		return nil
+	}
+	if vsResource.Spec.SNAT != "" {
+		return fmt.Errorf("VirtualServer cannot have both snat '%s' and "+
+			"snatAddresses", vsResource.Spec.SNAT)
+	}
+	for _, addr := range vsResource.Spec.SNATAddresses {
+		if err := validateAddress(addr); err != nil {
+			return fmt.Errorf("Invalid SNAT address: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateICMPEcho returns an error if the ICMP echo of a virtual address
+// is neither enable, disable nor selective
+func ValidateICMPEcho(icmpEcho string) error {
+	switch icmpEcho {
+	case "", ICMPEchoEnable, ICMPEchoDisable, ICMPEchoSelective:
+		return nil
+	}
+	return fmt.Errorf("Invalid icmpEcho '%s', it must be %s, %s or %s",
+		icmpEcho, ICMPEchoEnable, ICMPEchoDisable, ICMPEchoSelective)
+}
+
+// partitionRegex matches the names AS3 accepts for a tenant
+var partitionRegex = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z_.-]*$`)
+
+// ValidatePartition returns an error if the partition is not a valid AS3
+// tenant. Common is declared for the Wide-IPs and cannot be selected.
+func ValidatePartition(partition string) error {
+	if !partitionRegex.MatchString(partition) {
+		return fmt.Errorf("Invalid partition '%s', it must start with a "+
+			"letter followed by letters, digits, '_', '.' or '-'", partition)
+	}
+	if partition == "Common" {
+		return fmt.Errorf("Invalid partition '%s', it is reserved", partition)
+	}
+	return nil
+}
+
+// validateVirtualServerPartition returns an error if the partition of the
+// VirtualServer is neither the partition of CIS nor an allowed partition.
+func validateVirtualServerPartition(
+	vs *cisapiv1.VirtualServer,
+	opts ValidationOptions,
+) error {
+	partition := vs.Spec.Partition
+	if partition == "" || partition == opts.Partition ||
+		containsString(opts.AllowedPartitions, partition) {
+		return nil
+	}
+	return fmt.Errorf("Partition '%s' is not allowed, it must be one of "+
+		"--allowed-partitions", partition)
+}
+
+// bigIPPathRegex matches the full path of a BIG-IP object like
+// /Common/name or /Partition/folder/name
+var bigIPPathRegex = regexp.MustCompile(`^(/[^/\s]+){2,}$`)
+
+// isBigIPPath returns true if the name is the full path of a BIG-IP object
+func isBigIPPath(name string) bool {
+	return bigIPPathRegex.MatchString(name)
+}
+
+// validateProfileNames returns an error if a profile is neither a name nor
+// a path like /Common/profile
+func validateProfileNames(profiles cisapiv1.ProfileSpec) error {
+	for _, profile := range []string{
+		profiles.HTTP,
+		profiles.TCP.Client,
+		profiles.TCP.Server,
+	} {
+		if profile == "" {
+			continue
+		}
+		ref := ConvertStringToProfileRef(profile, CustomProfileAll, "")
+		if ref.Name == "" {
+			return invalidSpec("InvalidProfile", "Profile name '%s' is "+
+				"formatted incorrectly, it must be a name or a path like "+
+				"/Common/profile", profile)
+		}
+	}
+	return nil
+}
+
+// hasMultiplePaths returns true if the pools of the VirtualServer route
+// different paths
+func hasMultiplePaths(vsResource *cisapiv1.VirtualServer) bool {
+	pools := getVirtualServerPools(vsResource)
+	for _, pool := range pools {
+		if pool.Path != pools[0].Path {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePoolPath returns an error if the path of the pool does not
+// match its pathMatchType
+func validatePoolPath(pool cisapiv1.Pool) error {
+	switch getPathMatchType(pool) {
+	case PathMatchPrefix:
+	case PathMatchExact:
+		if !strings.HasPrefix(pool.Path, "/") {
+			return fmt.Errorf("Invalid exact path '%s', it must start with '/'",
+				pool.Path)
+		}
+	case PathMatchRegex:
+		if _, err := regexp.Compile(pool.Path); err != nil {
+			return fmt.Errorf("Invalid regex path '%s': %v", pool.Path, err)
+		}
+		if !strings.HasPrefix(pool.Path, "/") {
+			return fmt.Errorf("Invalid regex path '%s', it must start with '/'",
+				pool.Path)
+		}
+	default:
+		return fmt.Errorf("Invalid pathMatchType '%s' of path '%s'",
+			pool.PathMatchType, pool.Path)
+	}
+	return nil
+}
+
+// validatePoolRewrite returns an error if the target path is not a path,
+// the targets cannot be a value of the rewrite actions, or the pool has both
+// a hostRewrite and a rewrite targetHost
+func validatePoolRewrite(pool cisapiv1.Pool) error {
+	if strings.ContainsAny(pool.HostRewrite, " /{}\\") {
+		return fmt.Errorf("Invalid hostRewrite '%s' of path '%s', it must "+
+			"be a host and optional port like example.com:8080",
+			pool.HostRewrite, pool.Path)
+	}
+	rw := pool.Rewrite
+	if nil == rw {
+		return nil
+	}
+	if pool.HostRewrite != "" && rw.TargetHost != "" {
+		return fmt.Errorf("Pool of path '%s' cannot have both a hostRewrite "+
+			"and a rewrite targetHost", pool.Path)
+	}
+	if rw.TargetPath != "" && (!strings.HasPrefix(rw.TargetPath, "/") ||
+		strings.ContainsAny(rw.TargetPath, " {}\\&")) {
+		return fmt.Errorf("Invalid rewrite targetPath '%s' of path '%s', it "+
+			"must be a path like /app", rw.TargetPath, pool.Path)
+	}
+	if strings.ContainsAny(rw.TargetHost, " /{}\\") {
+		return fmt.Errorf("Invalid rewrite targetHost '%s' of path '%s', it "+
+			"must be a host and optional port like example.com:8080",
+			rw.TargetHost, pool.Path)
+	}
+	return nil
+}
+
+// validatePoolAction returns an error if the pool with an action has a
+// service or a rewrite, or the redirect cannot be sent by the pool action
+// iRule
+func validatePoolAction(pool cisapiv1.Pool) error {
+	pa := pool.Action
+	if nil == pa {
+		return nil
+	}
+	switch pa.Type {
+	case PoolActionReset, PoolActionDrop, PoolActionRedirect:
+	default:
+		return fmt.Errorf("Invalid action type '%s' of path '%s', it must "+
+			"be reset, drop or redirect", pa.Type, pool.Path)
+	}
+	if pool.Service != "" || nil != pool.Rewrite || pool.HostRewrite != "" {
+		return fmt.Errorf("Path '%s' with action %s cannot have a service "+
+			"or a rewrite", pool.Path, pa.Type)
+	}
+	if pa.Type != PoolActionRedirect {
+		if pa.Location != "" || pa.Code != 0 {
+			return fmt.Errorf("Path '%s' with action %s cannot have a "+
+				"location or a code", pool.Path, pa.Type)
+		}
+		return nil
+	}
+	if pa.Location == "" || strings.ContainsAny(pa.Location, " \t{}\\\"") {
+		return fmt.Errorf("Invalid redirect location '%s' of path '%s', "+
+			"it must be a URL like https://example.com/app", pa.Location,
+			pool.Path)
+	}
+	switch pa.Code {
+	case 0, 301, 302, 307, 308:
+	default:
+		return fmt.Errorf("Invalid redirect code %d of path '%s', it must "+
+			"be 301, 302, 307 or 308", pa.Code, pool.Path)
+	}
+	return nil
+}
+
+// validatePoolServiceDownAction returns an error if the serviceDownAction
+// is neither none, reset, drop nor reselect
+func validatePoolServiceDownAction(pool cisapiv1.Pool) error {
+	switch pool.ServiceDownAction {
+	case "", ServiceDownActionNone, ServiceDownActionReset,
+		ServiceDownActionDrop, ServiceDownActionReselect:
+		return nil
+	}
+	return fmt.Errorf("Invalid serviceDownAction '%s' of path '%s', it must "+
+		"be none, reset, drop or reselect", pool.ServiceDownAction, pool.Path)
+}
+
+// httpMethodRegex matches the HTTP method tokens
+var httpMethodRegex = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// validatePoolMatch returns an error if a method is not an HTTP method token
+// or a query parameter is not name=value
+func validatePoolMatch(pool cisapiv1.Pool) error {
+	for _, method := range pool.MatchMethod {
+		if !httpMethodRegex.MatchString(method) {
+			return fmt.Errorf("Invalid matchMethod '%s' of path '%s', it "+
+				"must be an HTTP method like GET", method, pool.Path)
+		}
+	}
+	for _, param := range pool.MatchQueryParams {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("Invalid matchQueryParams '%s' of path '%s', "+
+				"it must be name=value", param, pool.Path)
+		}
+	}
+	return nil
+}
+
+// headerNameRegex matches the HTTP header name tokens
+var headerNameRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// validatePoolResponseHeaders returns an error if a response header is not
+// an HTTP header name, is both set and removed, or its value would be read
+// as Tcl or break the header
+func validatePoolResponseHeaders(pool cisapiv1.Pool) error {
+	rh := pool.ResponseHeaders
+	if nil == rh {
+		return nil
+	}
+	if nil != pool.Action {
+		return fmt.Errorf("Path '%s' with action %s cannot have "+
+			"responseHeaders", pool.Path, pool.Action.Type)
+	}
+	names := make(map[string]bool)
+	for name, value := range rh.Set {
+		if !headerNameRegex.MatchString(name) {
+			return fmt.Errorf("Invalid response header '%s' of path '%s', "+
+				"it must be a header name like X-Frame-Options", name,
+				pool.Path)
+		}
+		if strings.HasPrefix(value, "tcl:") ||
+			strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("Invalid value '%s' of response header '%s' "+
+				"of path '%s'", value, name, pool.Path)
+		}
+		names[strings.ToLower(name)] = true
+	}
+	for _, name := range rh.Remove {
+		if !headerNameRegex.MatchString(name) {
+			return fmt.Errorf("Invalid response header '%s' of path '%s', "+
+				"it must be a header name like Server", name, pool.Path)
+		}
+		if names[strings.ToLower(name)] {
+			return fmt.Errorf("Response header '%s' of path '%s' cannot be "+
+				"both set and removed", name, pool.Path)
+		}
+	}
+	return nil
+}
+
+// validatePoolBackup returns an error if the backupPool is neither a
+// service nor static addresses, or the priority group settings are negative
+func validatePoolBackup(pool cisapiv1.Pool) error {
+	if pool.PriorityGroup < 0 || pool.MinActiveMembers < 0 {
+		return fmt.Errorf("Invalid priorityGroup or minActiveMembers of "+
+			"path '%s', they must not be negative", pool.Path)
+	}
+	bp := pool.BackupPool
+	if nil == bp {
+		return nil
+	}
+	if nil != pool.Action {
+		return fmt.Errorf("Path '%s' with action %s cannot have a "+
+			"backupPool", pool.Path, pool.Action.Type)
+	}
+	if (bp.Service == "") == (len(bp.Addresses) == 0) {
+		return fmt.Errorf("Invalid backupPool of path '%s', it must have "+
+			"either a service or addresses", pool.Path)
+	}
+	if bp.Service != "" {
+		if bp.ServicePort <= 0 || bp.Port != 0 {
+			return fmt.Errorf("Invalid backupPool of path '%s', a service "+
+				"needs a servicePort and no port", pool.Path)
+		}
+		return nil
+	}
+	if bp.Port <= 0 || bp.Port > 65535 || bp.ServicePort != 0 {
+		return fmt.Errorf("Invalid backupPool of path '%s', addresses "+
+			"need a port between 1 and 65535", pool.Path)
+	}
+	for _, addr := range bp.Addresses {
+		if nil == net.ParseIP(addr) {
+			return fmt.Errorf("Invalid backupPool address '%s' of path "+
+				"'%s', it must be an IP address", addr, pool.Path)
+		}
+	}
+	return nil
+}
+
+// validatePoolStaticMembers returns an error if a static member address is
+// not an IP address with an optional route domain, or its port is invalid
+func validatePoolStaticMembers(pool cisapiv1.Pool) error {
+	if len(pool.StaticMembers) > 0 && nil != pool.Action {
+		return fmt.Errorf("Path '%s' with action %s cannot have "+
+			"staticMembers", pool.Path, pool.Action.Type)
+	}
+	for _, m := range pool.StaticMembers {
+		if nil != validateAddress(m.Address) {
+			return fmt.Errorf("Invalid static member address '%s' of path "+
+				"'%s', it must be an IP address like 10.1.1.1 or 10.1.1.1%%2",
+				m.Address, pool.Path)
+		}
+		if m.Port <= 0 || m.Port > 65535 {
+			return fmt.Errorf("Invalid static member port %d of path '%s', "+
+				"it must be between 1 and 65535", m.Port, pool.Path)
+		}
+	}
+	return nil
+}
+
+// validatePoolMonitors returns an error if the pool has both a monitor and
+// monitors, a monitor of unknown type or target, an invalid monitor name or
+// more minimumMonitors than monitors
+func validatePoolMonitors(pool cisapiv1.Pool) error {
+	count := len(pool.Monitors)
+	if nil != pool.Monitor {
+		if count > 0 {
+			return fmt.Errorf("Pool of path '%s' cannot have both a monitor "+
+				"and monitors", pool.Path)
+		}
+		count = 1
+		switch pool.Monitor.Type {
+		case MonitorHTTP, MonitorHTTPS, MonitorTCP, MonitorUDP:
+		default:
+			return fmt.Errorf("Invalid monitor type '%s' of path '%s', it "+
+				"must be http, https, tcp or udp", pool.Monitor.Type, pool.Path)
+		}
+		if pool.Monitor.Interval < 0 || pool.Monitor.Timeout < 0 {
+			return fmt.Errorf("Invalid monitor of path '%s', interval and "+
+				"timeout must not be negative", pool.Path)
+		}
+		if pool.Monitor.TargetAddress != "" {
+			if err := validateAddress(pool.Monitor.TargetAddress); err != nil {
+				return fmt.Errorf("Invalid target address of the monitor of "+
+					"path '%s': %v", pool.Path, err)
+			}
+		}
+		if pool.Monitor.TargetPort < 0 || pool.Monitor.TargetPort > 65535 {
+			return fmt.Errorf("Invalid target port %d of the monitor of "+
+				"path '%s'", pool.Monitor.TargetPort, pool.Path)
+		}
+	}
+	if count > 0 && nil != pool.Action {
+		return fmt.Errorf("Path '%s' with action %s cannot have monitors",
+			pool.Path, pool.Action.Type)
+	}
+	names := make(map[string]bool)
+	for _, monitor := range pool.Monitors {
+		ref := ConvertStringToProfileRef(strings.TrimSpace(monitor), "", "")
+		if ref.Name == "" || strings.ContainsAny(ref.Name, " \t") {
+			return fmt.Errorf("Monitor name '%s' of path '%s' is formatted "+
+				"incorrectly, it must be a name or a path like /Common/http",
+				monitor, pool.Path)
+		}
+		name := formatMonitorName(monitor)
+		if names[name] {
+			return fmt.Errorf("Duplicate monitor '%s' of path '%s'", name,
+				pool.Path)
+		}
+		names[name] = true
+	}
+	if pool.MinimumMonitors < 0 || int(pool.MinimumMonitors) > count {
+		return fmt.Errorf("Invalid minimumMonitors %d of path '%s', it must "+
+			"be between 0 and the %d monitors", pool.MinimumMonitors,
+			pool.Path, count)
+	}
+	return nil
+}
+
+// validatePoolNodeSelector returns an error if the nodeMemberSelector, or
+// the nodeMemberLabel along with it, is not a valid label selector
+func validatePoolNodeSelector(pool cisapiv1.Pool) error {
+	if nil == pool.NodeMemberSelector {
+		return nil
+	}
+	if _, err := getPoolNodeSelector(pool); err != nil {
+		return fmt.Errorf("Invalid nodeMemberSelector of path '%s': %v",
+			pool.Path, err)
+	}
+	return nil
+}
+
+// validatePoolSourceRange returns an error if the allowSourceRange of the
+// pool is not a list of CIDRs or the pool has an action
+func validatePoolSourceRange(pool cisapiv1.Pool) error {
+	if len(pool.AllowSourceRange) == 0 {
+		return nil
+	}
+	if nil != pool.Action {
+		return fmt.Errorf("Path '%s' with action %s cannot have "+
+			"allowSourceRange", pool.Path, pool.Action.Type)
+	}
+	return validateSourceRanges(pool.AllowSourceRange,
+		fmt.Sprintf("allowSourceRange of path '%s'", pool.Path))
+}
+
+// validateSourceRanges returns an error if a source range of the field is
+// not a CIDR
+func validateSourceRanges(ranges []string, field string) error {
+	for _, cidr := range ranges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid source range '%s' in %s, it must be "+
+				"a CIDR like 10.0.0.0/8", cidr, field)
+		}
+	}
+	return nil
+}
+
+// validatePoolPorts returns an error if the pool has both ports and a path
+// or servicePort, or an action along with ports
+func validatePoolPorts(pool cisapiv1.Pool) error {
+	if len(pool.Ports) == 0 {
+		return nil
+	}
+	if nil != pool.Action {
+		return fmt.Errorf("Pool with action %s cannot have ports",
+			pool.Action.Type)
+	}
+	if pool.Path != "" || formatPoolPort(pool.ServicePort) != "" {
+		return fmt.Errorf("Pool of service '%s' has ports, it cannot have "+
+			"path '%s' and servicePort '%s' too", pool.Service, pool.Path,
+			pool.ServicePort.String())
+	}
+	return nil
+}
+
+// validatePoolServicePort returns an error if the servicePort of the pool is
+// a string which is not a valid name of a service port
+func validatePoolServicePort(pool cisapiv1.Pool) error {
+	if pool.ServicePort.Type != intstr.String {
+		return nil
+	}
+	if errs := validation.IsValidPortName(pool.ServicePort.StrVal); len(errs) > 0 {
+		return fmt.Errorf("Invalid servicePort '%s' of path '%s': %s",
+			pool.ServicePort.StrVal, pool.Path, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validatePoolMemberTypes returns an error if a poolMemberType is neither
+// cluster nor nodeport, or the pools of the same service and port use
+// different member types, as they are the same BIG-IP pool. The pools
+// without poolMemberType use defaultType.
+func validatePoolMemberTypes(pools []cisapiv1.Pool, defaultType string) error {
+	memberTypes := make(map[string]string)
+	for _, pool := range pools {
+		switch pool.PoolMemberType {
+		case "", ClusterMode, NodePortMode:
+		default:
+			return fmt.Errorf("Invalid poolMemberType '%s' of path '%s', it "+
+				"must be cluster or nodeport", pool.PoolMemberType, pool.Path)
+		}
+		if nil != pool.Action || pool.Service == "" {
+			continue
+		}
+		key := getVirtualServerPoolName("", pool)
+		memberType := pool.PoolMemberType
+		if memberType == "" {
+			memberType = defaultType
+		}
+		if mt, ok := memberTypes[key]; ok && mt != memberType {
+			return fmt.Errorf("Pools of service '%s' use both %s and %s "+
+				"poolMemberType", pool.Service, mt, memberType)
+		}
+		memberTypes[key] = memberType
+	}
+	return nil
+}
+
+// validatePoolServicePorts returns an error if the pools of the same
+// BIG-IP pool use different ports of the service, as with the legacy
+// naming scheme which names the pools after the service only
+func validatePoolServicePorts(pools []cisapiv1.Pool) error {
+	ports := make(map[string]intstr.IntOrString)
+	for _, pool := range pools {
+		if nil != pool.Action || pool.Service == "" {
+			continue
+		}
+		key := getVirtualServerPoolName("", pool)
+		if port, ok := ports[key]; ok && port != pool.ServicePort {
+			return fmt.Errorf("Pools of service '%s' use both servicePort "+
+				"'%s' and '%s', which the %s naming scheme does not support",
+				pool.Service, port.String(), pool.ServicePort.String(),
+				LegacyNamingScheme)
+		}
+		ports[key] = pool.ServicePort
+	}
+	return nil
+}
+
+// getInvalidPools returns the pools of the VirtualServer referring to
+// services which do not exist.
+func (crMgr *CRManager) getInvalidPools(
+	vsResource *cisapiv1.VirtualServer,
+) []cisapiv1.Pool {
+	crInf, ok := crMgr.getNamespaceInformer(vsResource.ObjectMeta.Namespace)
+	if !ok {
+		return nil
+	}
+	var invalidPools []cisapiv1.Pool
+	for _, pool := range vsResource.Spec.Pools {
+		if nil != pool.Action ||
+			(pool.Service == "" && len(pool.StaticMembers) > 0) {
+			continue
+		}
+		svcKey := vsResource.ObjectMeta.Namespace + "/" + pool.Service
+		_, found, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
+		if !found {
+			invalidPools = append(invalidPools, pool)
+		}
+	}
+	return invalidPools
+}
+
+// filterInvalidPools applies the PartialErrorPolicy of the VirtualServer.
+// It returns the VirtualServer to be configured, without the invalid pools
+// when the policy is SkipInvalidPools, or nil when the VirtualServer is
+// rejected.
+func (crMgr *CRManager) filterInvalidPools(
+	vsResource *cisapiv1.VirtualServer,
+) *cisapiv1.VirtualServer {
+	invalidPools := crMgr.getInvalidPools(vsResource)
+	if len(invalidPools) == 0 {
+		return vsResource
+	}
+	crMgr.addPendingServices(vsResource, invalidPools)
+	vsNamespace := vsResource.ObjectMeta.Namespace
+	vkey := vsNamespace + "/" + vsResource.ObjectMeta.Name
+	var paths, svcs []string
+	for _, pool := range invalidPools {
+		paths = append(paths, pool.Path)
+		svcs = append(svcs, pool.Service)
+	}
+
+	if vsResource.Spec.PartialErrorPolicy != SkipInvalidPools {
+		msg := fmt.Sprintf("Services %v not found", svcs)
+		log.Errorf("VirtualServer %s rejected: %s", vkey, msg)
+		crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+			"InvalidData", msg)
+		return nil
+	}
+
+	// The VirtualServer is processed again when the missing services are
+	// created, see enqueuePendingVirtualServers.
+	msg := fmt.Sprintf("Paths %v skipped, services %v not found", paths, svcs)
+	log.Warningf("VirtualServer %s degraded: %s", vkey, msg)
+	crMgr.recordEvent(vsResource, vsNamespace, v1.EventTypeWarning,
+		"DegradedPaths", msg)
+
+	virtual := vsResource.DeepCopy()
+	virtual.Spec.Pools = nil
+	for _, pool := range vsResource.Spec.Pools {
+		valid := true
+		for _, invalidPool := range invalidPools {
+			if reflect.DeepEqual(pool, invalidPool) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			virtual.Spec.Pools = append(virtual.Spec.Pools, pool)
+		}
+	}
+	return virtual
+}
+
+// checkWildcardHostOverlap logs a warning for each VirtualServer sharing the
+// address whose wildcard hosts overlap the wildcard hosts of vsResource. The
+// rules of the longer wildcard host take precedence for the hosts matched
+// by both.
+func (crMgr *CRManager) checkWildcardHostOverlap(
+	vsResource *cisapiv1.VirtualServer,
+) {
+	var wildcards []string
+	for _, host := range getVirtualServerHosts(vsResource) {
+		if isWildcardHost(host) {
+			wildcards = append(wildcards, host)
+		}
+	}
+	if len(wildcards) == 0 {
+		return
+	}
+	address := crMgr.getVirtualServerAddress(vsResource)
+	vkey := vsResource.ObjectMeta.Namespace + "/" + vsResource.ObjectMeta.Name
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.vsInformer.GetIndexer().List() {
+			vs := obj.(*cisapiv1.VirtualServer)
+			if crMgr.getVirtualServerAddress(vs) != address {
+				continue
+			}
+			for _, wildcard := range wildcards {
+				for _, host := range getVirtualServerHosts(vs) {
+					if !wildcardHostsOverlap(wildcard, host) {
+						continue
+					}
+					log.Warningf("Host %s of VirtualServer %s overlaps "+
+						"with host %s of VirtualServer %s/%s", wildcard,
+						vkey, host, vs.ObjectMeta.Namespace,
+						vs.ObjectMeta.Name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"sort"
+	"sync"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+)
+
+// virtualLocks locks the virtuals by name, so that the resources configured
+// on the same virtuals are processed one at a time while the resources on
+// other virtuals are processed concurrently.
+type virtualLocks struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// Names of the virtuals locked
+	locked map[string]bool
+}
+
+// lock waits until none of the names is locked and locks them all at once,
+// so that the workers locking overlapping names do not deadlock. It returns
+// the function unlocking them.
+func (vl *virtualLocks) lock(names []string) func() {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+	if nil == vl.cond {
+		vl.cond = sync.NewCond(&vl.mutex)
+		vl.locked = make(map[string]bool)
+	}
+	for vl.isLocked(names) {
+		vl.cond.Wait()
+	}
+	for _, name := range names {
+		vl.locked[name] = true
+	}
+	return func() {
+		vl.mutex.Lock()
+		defer vl.mutex.Unlock()
+		for _, name := range names {
+			delete(vl.locked, name)
+		}
+		vl.cond.Broadcast()
+	}
+}
+
+// isLocked returns true if one of the names is locked. mutex must be held.
+func (vl *virtualLocks) isLocked(names []string) bool {
+	for _, name := range names {
+		if vl.locked[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// getLockedVirtuals returns the sorted names locked to process the
+// resource, and false if the resource is processed holding processingMutex
+// exclusively. Besides the resource itself, they are the paths of the
+// virtuals the resource is configured on, and of the virtuals of its
+// addresses and ports, under any name they may have. The VirtualServers of
+// host groups and using IPAM are processed exclusively, as their addresses
+// change while they are processed and a host group changes the virtuals of
+// its other members.
+func (crMgr *CRManager) getLockedVirtuals(rKey *rqKey) ([]string, bool) {
+	if rKey.rscDelete {
+		return nil, false
+	}
+	names := map[string]bool{rKey.kind + ":" + rKey.namespace + "/" +
+		rKey.rscName: true}
+	addVirtual := func(
+		partition string,
+		bindAddr string,
+		port int32,
+		rsName string,
+	) {
+		names[JoinBigipPath(partition, rsName)] = true
+		names[JoinBigipPath(partition,
+			formatVirtualServerName(bindAddr, port))] = true
+		if ref, ok := crMgr.resources.getVirtualByAddress(
+			bindAddr, port); ok {
+			names[JoinBigipPath(ref.Partition, ref.Name)] = true
+		}
+	}
+
+	var rscKey string
+	switch rKey.kind {
+	case VirtualServer:
+		vs := rKey.rsc.(*cisapiv1.VirtualServer)
+		rscKey = vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+		objKey, _ := NewObjectDependencies(vs)
+		if vs.Spec.HostGroup != "" || crMgr.usesIPAM(vs) ||
+			nil != vs.ObjectMeta.DeletionTimestamp ||
+			crMgr.resources.hasDependency(objKey, HostGroup) {
+			return nil, false
+		}
+		partition := crMgr.getVirtualServerPartition(vs)
+		bindAddr := crMgr.getVirtualServerBindAddr(vs)
+		ports := []int32{DEFAULT_HTTP_PORT, DEFAULT_HTTPS_PORT}
+		for _, portStruct := range crMgr.virtualPorts(vs) {
+			ports = append(ports, portStruct.port)
+		}
+		for _, port := range ports {
+			addVirtual(partition, bindAddr, port,
+				crMgr.getVirtualServerName(vs, port))
+			names[JoinBigipPath(partition, formatVirtualServerResourceName(
+				vs.ObjectMeta.Namespace, vs.ObjectMeta.Name, port))] = true
+		}
+	case TransportServer:
+		ts := rKey.rsc.(*cisapiv1.TransportServer)
+		rscKey = tsKey(ts)
+		bindAddr := formatRouteDomainAddress(
+			normalizeAddress(ts.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(ts.ObjectMeta.Namespace))
+		for _, port := range getTransportServerPorts(ts) {
+			addVirtual(crMgr.getNamespacePartition(ts.ObjectMeta.Namespace),
+				bindAddr, port, crMgr.getTransportServerName(ts, port))
+		}
+	case IngressLink:
+		il := rKey.rsc.(*cisapiv1.IngressLink)
+		rscKey = ilKey(il)
+		bindAddr := formatRouteDomainAddress(
+			normalizeAddress(il.Spec.VirtualServerAddress),
+			crMgr.getRouteDomain(il.ObjectMeta.Namespace))
+		for _, port := range ingressLinkPorts {
+			addVirtual(crMgr.getNamespacePartition(il.ObjectMeta.Namespace),
+				bindAddr, port, crMgr.getIngressLinkName(il, port))
+		}
+	default:
+		return nil, false
+	}
+	for _, ref := range crMgr.resources.getVirtuals(rKey.kind, rscKey) {
+		names[JoinBigipPath(ref.Partition, ref.Name)] = true
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, true
+}
+
+// lockVirtuals locks the virtuals of the resource holding processingMutex
+// for reading, or else processingMutex exclusively. The virtuals are looked
+// up again once locked, as they may be changed by another worker meanwhile,
+// and the resource is processed exclusively if they changed. It returns the
+// function unlocking them.
+func (crMgr *CRManager) lockVirtuals(rKey *rqKey) func() {
+	names, ok := crMgr.getLockedVirtuals(rKey)
+	if ok {
+		crMgr.processingMutex.RLock()
+		unlock := crMgr.virtualLocks.lock(names)
+		if newNames, ok := crMgr.getLockedVirtuals(
+			rKey); ok && isSubset(newNames, names) {
+			return func() {
+				unlock()
+				crMgr.processingMutex.RUnlock()
+			}
+		}
+		unlock()
+		crMgr.processingMutex.RUnlock()
+	}
+	crMgr.processingMutex.Lock()
+	return crMgr.processingMutex.Unlock
+}
+
+// isSubset returns true if all the names of the sorted list are in the
+// sorted list of names.
+func isSubset(list []string, names []string) bool {
+	i := 0
+	for _, name := range list {
+		for i < len(names) && names[i] < name {
+			i++
+		}
+		if i == len(names) || names[i] != name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newWebhookHandler returns the handler of the admission webhook server,
+// it validates the VirtualServers and TLSProfiles at /validate.
+func (crMgr *CRManager) newWebhookHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", crMgr.handleValidate)
+	return mux
+}
+
+// startWebhookServer serves the webhook handler on the address with the
+// certificate and key files, mounted from a secret.
+func (crMgr *CRManager) startWebhookServer(address, certFile, keyFile string) {
+	log.Infof("Starting admission webhook server on %s", address)
+	go func() {
+		err := http.ListenAndServeTLS(address, certFile, keyFile,
+			crMgr.newWebhookHandler())
+		log.Errorf("Admission webhook server stopped: %v", err)
+	}()
+}
+
+// handleValidate answers the AdmissionReview of a request, denying the
+// VirtualServers and TLSProfiles the controller would reject.
+func (crMgr *CRManager) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var review admissionv1beta1.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil ||
+		nil == review.Request {
+		http.Error(w, "Invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	req := review.Request
+	resp := &admissionv1beta1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if err := crMgr.validateAdmission(req); err != nil {
+		log.Infof("Admission webhook: denied %s %s/%s: %v", req.Kind.Kind,
+			req.Namespace, req.Name, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+	review.Request = nil
+	review.Response = resp
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Errorf("Admission webhook: failed to write the response: %v", err)
+	}
+}
+
+// validateAdmission returns an error if the VirtualServer or TLSProfile
+// created or updated is invalid. The updates leaving the spec unchanged,
+// like the status and finalizers, are allowed so the resources created
+// before the webhook can still be deleted.
+func (crMgr *CRManager) validateAdmission(
+	req *admissionv1beta1.AdmissionRequest,
+) error {
+	switch req.Operation {
+	case admissionv1beta1.Create, admissionv1beta1.Update:
+	default:
+		return nil
+	}
+	switch req.Kind.Kind {
+	case VirtualServer:
+		var vs, old cisapiv1.VirtualServer
+		if err := json.Unmarshal(req.Object.Raw, &vs); err != nil {
+			return fmt.Errorf("Invalid VirtualServer: %v", err)
+		}
+		if req.Operation == admissionv1beta1.Update &&
+			nil == json.Unmarshal(req.OldObject.Raw, &old) &&
+			reflect.DeepEqual(old.Spec, vs.Spec) {
+			return nil
+		}
+		return ValidateVirtualServer(&vs, crMgr.validationOptions())
+	case TLSProfile:
+		var tls, old cisapiv1.TLSProfile
+		if err := json.Unmarshal(req.Object.Raw, &tls); err != nil {
+			return fmt.Errorf("Invalid TLSProfile: %v", err)
+		}
+		if req.Operation == admissionv1beta1.Update &&
+			nil == json.Unmarshal(req.OldObject.Raw, &old) &&
+			reflect.DeepEqual(old.Spec, tls.Spec) {
+			return nil
+		}
+		return ValidateTLSProfile(&tls)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Admission Webhook Tests", func() {
+	var mockCRM *mockCRManager
+	var handler http.Handler
+	var vs *cisapiv1.VirtualServer
+	var tls *cisapiv1.TLSProfile
+
+	// review posts the AdmissionReview of the object and returns the
+	// response
+	review := func(
+		kind string,
+		op admissionv1beta1.Operation,
+		obj, old interface{},
+	) *admissionv1beta1.AdmissionResponse {
+		req := &admissionv1beta1.AdmissionRequest{
+			UID:       "uid",
+			Kind:      metav1.GroupVersionKind{Kind: kind},
+			Operation: op,
+			Namespace: "default",
+		}
+		req.Object.Raw, _ = json.Marshal(obj)
+		if nil != old {
+			req.OldObject.Raw, _ = json.Marshal(old)
+		}
+		body, _ := json.Marshal(admissionv1beta1.AdmissionReview{
+			Request: req,
+		})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/validate",
+			bytes.NewReader(body)))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var resp admissionv1beta1.AdmissionReview
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp.Response.UID).To(BeEquivalentTo("uid"))
+		return resp.Response
+	}
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		handler = mockCRM.newWebhookHandler()
+		vs = test.NewVirtualServer("SampleVS", "default",
+			cisapiv1.VirtualServerSpec{
+				Host:                 "test.com",
+				VirtualServerAddress: "10.1.1.1",
+				Pools: []cisapiv1.Pool{{
+					Path:        "/foo",
+					Service:     "svc1",
+					ServicePort: intstr.FromInt(80),
+				}},
+			})
+		tls = test.NewTLSProfile("SampleTLS", "default",
+			cisapiv1.TLSProfileSpec{
+				TLS: cisapiv1.TLS{
+					Termination: TLSEdge,
+					ClientSSL:   "/Common/clientssl",
+					Reference:   BIGIP,
+				},
+			})
+	})
+
+	It("Allows the valid resources", func() {
+		Expect(review(VirtualServer, admissionv1beta1.Create, vs,
+			nil).Allowed).To(BeTrue())
+		Expect(review(TLSProfile, admissionv1beta1.Create, tls,
+			nil).Allowed).To(BeTrue())
+	})
+
+	It("Denies the VirtualServers the controller rejects", func() {
+		for _, update := range []func(*cisapiv1.VirtualServer){
+			func(vs *cisapiv1.VirtualServer) {
+				vs.Spec.VirtualServerAddress = "10.1.1"
+			},
+			func(vs *cisapiv1.VirtualServer) {
+				vs.Spec.Profiles.HTTP = "/Common/http/profile"
+			},
+			func(vs *cisapiv1.VirtualServer) {
+				vs.Spec.HTTPTraffic = "deny"
+			},
+			func(vs *cisapiv1.VirtualServer) {
+				vs.Spec.Pools = append(vs.Spec.Pools, vs.Spec.Pools[0])
+			},
+		} {
+			invalid := vs.DeepCopy()
+			update(invalid)
+			resp := review(VirtualServer, admissionv1beta1.Create, invalid, nil)
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(Equal(ValidateVirtualServer(
+				invalid, mockCRM.validationOptions()).Error()))
+		}
+	})
+
+	It("Denies the invalid TLSProfiles", func() {
+		tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+			CACertificate: "/Common/ca-bundle.crt",
+		}
+		resp := review(TLSProfile, admissionv1beta1.Create, tls, nil)
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Message).To(Equal(
+			"clientAuth requires the secret reference"))
+	})
+
+	It("Allows the updates leaving the invalid spec unchanged", func() {
+		vs.Spec.HTTPTraffic = "deny"
+		vs.ObjectMeta.Finalizers = []string{CleanupFinalizer}
+		updated := vs.DeepCopy()
+		updated.ObjectMeta.Finalizers = nil
+		Expect(review(VirtualServer, admissionv1beta1.Update, updated,
+			vs).Allowed).To(BeTrue())
+		Expect(review(VirtualServer, admissionv1beta1.Delete, vs,
+			nil).Allowed).To(BeTrue())
+
+		updated.Spec.Host = "example.com"
+		Expect(review(VirtualServer, admissionv1beta1.Update, updated,
+			vs).Allowed).To(BeFalse())
+	})
+
+	It("Rejects the invalid requests", func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/validate",
+			bytes.NewReader([]byte("{"))))
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -17,16 +17,41 @@
 package crmanager
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
 	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
 	v1 "k8s.io/api/core/v1"
-	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	discoveryv1alpha1 "k8s.io/api/discovery/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
 )
 
+const (
+	// SyncIDAnnotation annotates the Events recorded while processing a
+	// resource with the sync ID logged with the messages of the processing
+	SyncIDAnnotation = "cis.f5.com/sync-id"
+	// syncIDField is the log field of the sync ID
+	syncIDField = "syncID"
+)
+
+// newSyncID returns a random ID of the processing of a resource
+func newSyncID() string {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
 // customResourceWorker starts the Custom Resource Worker.
 func (crMgr *CRManager) customResourceWorker() {
 	log.Debugf("Starting Custom Resource Worker")
@@ -35,7 +60,9 @@ func (crMgr *CRManager) customResourceWorker() {
 }
 
 // processResource gets resources from the rscQueue and processes the resource
-// depending  on its kind.
+// depending  on its kind. The workers process the resources on different
+// virtuals concurrently, and mark the configuration to be posted by
+// configFlusher.
 func (crMgr *CRManager) processResource() bool {
 
 	key, quit := crMgr.rscQueue.Get()
@@ -44,109 +71,340 @@ func (crMgr *CRManager) processResource() bool {
 		log.Debugf("Resource Queue is empty, Going to StandBy Mode")
 		return false
 	}
-	var isLastInQueue, isError bool
+	var syncErr error
 
-	if crMgr.rscQueue.Len() == 0 {
-		isLastInQueue = true
-	}
+	crMgr.markProgress()
+	defer crMgr.markProgress()
 	defer crMgr.rscQueue.Done(key)
+	if !crMgr.isLeader() {
+		// The resources are queued again from the informer caches when
+		// the leadership is acquired
+		crMgr.rscQueue.Forget(key)
+		return true
+	}
 	rKey := key.(*rqKey)
+	if rKey.kind == VirtualServer && !rKey.rscDelete {
+		// The VirtualServer retried is synced as updated since it was
+		// queued, its latest version is fetched from the informer
+		vs, found := crMgr.latestVirtualServer(rKey)
+		if !found {
+			crMgr.resourceSynced(rKey)
+			return true
+		}
+		rKey.rsc = vs
+	}
+	atomic.AddInt32(&crMgr.inFlight, 1)
+	// The messages logged while processing the resource carry its fields
+	defer log.WithContext(log.Fields{
+		"namespace": rKey.namespace,
+		"kind":      rKey.kind,
+		"name":      rKey.rscName,
+		syncIDField: newSyncID(),
+	})()
 	log.Debugf("Processing Key: %v", rKey)
+	bigIPPrometheus.QueueDepth.Set(float64(crMgr.rscQueue.Len()))
+	defer func(start time.Time) {
+		bigIPPrometheus.ProcessingDuration.WithLabelValues(rKey.kind).Observe(
+			time.Since(start).Seconds())
+	}(time.Now())
+
+	// The secrets are got from the API server before waiting for the other
+	// workers.
+	if rKey.kind == VirtualServer && !rKey.rscDelete {
+		crMgr.prefetchTLSSecrets(rKey.rsc.(*cisapiv1.VirtualServer))
+		crMgr.addFinalizers(rKey.rsc.(*cisapiv1.VirtualServer))
+	}
+	unlock := crMgr.lockVirtuals(rKey)
 
 	// Check the type of resource and process accordingly.
 	switch rKey.kind {
 	case VirtualServer:
 		vs := rKey.rsc.(*cisapiv1.VirtualServer)
-		// Handle Deletion of VirtualServer
+		// Handle Deletion of VirtualServer, kept by its finalizer until
+		// removed from BIG-IP
+		if rKey.rscDelete || nil != vs.ObjectMeta.DeletionTimestamp {
+			crMgr.deleteVirtualServerConfig(vs)
+			crMgr.releaseVirtualServer(vs)
+			crMgr.releaseVirtualServerAddress(vs)
+			crMgr.releaseHostGroup(vs)
+			crMgr.enqueueConflictingVirtualServers(vs)
+			crMgr.enqueueExternalDNSForVirtualServer(vs)
+			crMgr.deleteVirtualServerStatus(vs)
+			crMgr.deletePendingServices(vs)
+			crMgr.resourceDeleted(VirtualServer, vs.ObjectMeta)
+			break
+		}
+		syncErr = crMgr.syncVirtualServer(vs)
+		crMgr.enqueueExternalDNSForVirtualServer(vs)
+	case TLSProfile:
+		tls := rKey.rsc.(*cisapiv1.TLSProfile)
+		if rKey.rscDelete || nil != tls.ObjectMeta.DeletionTimestamp {
+			crMgr.deleteTLSProfile(tls)
+			crMgr.resourceDeleted(TLSProfile, tls.ObjectMeta)
+			break
+		}
+		updated := crMgr.updateTLSContext(tls)
+		if !updated || crMgr.initState {
+			break
+		}
+		// VirtualServers are processed again to get the updated profiles.
+		crMgr.enqueueVirtualServersForTLSProfile(tls)
+	case TransportServer:
+		ts := rKey.rsc.(*cisapiv1.TransportServer)
 		if rKey.rscDelete {
-			// TODO: Handle for TLS
-			// Use portSpec
-			vsName := formatVirtualServerName(vs.Spec.VirtualServerAddress, 80)
-			crMgr.resources.deleteVirtualServer(vsName)
+			crMgr.deleteResourceConfigs(TransportServer, tsKey(ts))
 			break
 		}
-		err := crMgr.syncVirtualServer(vs)
-		if err != nil {
-			// TODO
-			utilruntime.HandleError(fmt.Errorf("Sync %v failed with %v", key, err))
-			isError = true
+		crMgr.syncTransportServer(ts)
+	case IngressLink:
+		il := rKey.rsc.(*cisapiv1.IngressLink)
+		if rKey.rscDelete {
+			crMgr.deleteResourceConfigs(IngressLink, ilKey(il))
+			break
 		}
+		crMgr.syncIngressLink(il)
+	case ExternalDNS:
+		edns := rKey.rsc.(*cisapiv1.ExternalDNS)
+		if rKey.rscDelete {
+			crMgr.deleteExternalDNS(edns)
+			break
+		}
+		crMgr.syncExternalDNS(edns)
+	case CustomPolicy:
+		if crMgr.initState {
+			break
+		}
+		// VirtualServers are processed again to apply the updated Policy,
+		// or to be processed without it when deleted.
+		plc := rKey.rsc.(*cisapiv1.Policy)
+		crMgr.enqueueVirtualServersForPolicy(plc)
 	case Service:
 		if crMgr.initState {
 			break
 		}
 		svc := rKey.rsc.(*v1.Service)
+		crMgr.syncTransportServersForService(svc)
+		crMgr.syncIngressLinksForService(svc)
 		virtuals := crMgr.syncService(svc)
 		// No Virtuals are effected with the change in service.
 		if nil == virtuals {
 			break
 		}
 		for _, virtual := range virtuals {
-			err := crMgr.syncVirtualServer(virtual)
-			if err != nil {
-				// TODO
-				utilruntime.HandleError(fmt.Errorf("Sync %v failed with %v", key, err))
-				isError = true
+			if err := crMgr.syncVirtualServer(virtual); err != nil {
+				syncErr = err
 			}
 		}
-	case Endpoints:
+	case Endpoints, EndpointSlice:
 		if crMgr.initState {
 			break
 		}
-		ep := rKey.rsc.(*v1.Endpoints)
-		svc := crMgr.syncEndpoints(ep)
+		var svc *v1.Service
+		if rKey.kind == EndpointSlice {
+			svc = crMgr.syncEndpointSlice(rKey.rsc.(*discoveryv1alpha1.EndpointSlice))
+		} else {
+			svc = crMgr.syncEndpoints(rKey.rsc.(*v1.Endpoints))
+		}
 		// No Services are effected with the change in service.
 		if nil == svc {
 			break
 		}
-		virtuals := crMgr.syncService(svc)
-		for _, virtual := range virtuals {
-			err := crMgr.syncVirtualServer(virtual)
-			if err != nil {
-				// TODO
-				utilruntime.HandleError(fmt.Errorf("Sync %v failed with %v", key, err))
-				isError = true
-			}
+		// Only the members of the pools of the service change.
+		crMgr.updatePoolMembersForService(svc)
+		// VirtualServers waiting for the service are processed again to
+		// stop waiting once their pools have members.
+		crMgr.enqueuePendingVirtualServers(svc.ObjectMeta.Namespace,
+			svc.ObjectMeta.Name)
+	case TLSSecret:
+		if crMgr.initState {
+			break
 		}
+		secret := rKey.rsc.(*v1.Secret)
+		secretDep := ObjectDependency{
+			Kind:      TLSSecret,
+			Namespace: secret.ObjectMeta.Namespace,
+			Name:      secret.ObjectMeta.Name,
+		}
+		// Skip the secrets not referred by VirtualServers.
+		if !crMgr.resources.isDependencyInUse(secretDep) {
+			break
+		}
+		if rKey.rscDelete {
+			crMgr.deleteSecretSslProfiles(secret)
+		} else {
+			crMgr.updateSecretSslProfiles(secret)
+		}
+		// VirtualServers are processed again to get the updated profiles.
+		crMgr.enqueueVirtualServersForDependency(secretDep)
+	case Resync:
+		crMgr.resync()
+	case Prune:
+		crMgr.prune()
 	default:
 		log.Errorf("Unknown resource Kind: %v", rKey.kind)
 	}
 
-	if isError {
-		crMgr.rscQueue.AddRateLimited(key)
+	if syncErr != nil {
+		crMgr.retryResource(rKey, syncErr)
 	} else {
-		crMgr.rscQueue.Forget(key)
+		crMgr.resourceSynced(rKey)
+	}
+	unlock()
+
+	// The resources got by the other workers are flushed with this one
+	// when the last one is processed.
+	crMgr.processingMutex.Lock()
+	defer crMgr.processingMutex.Unlock()
+	drained := atomic.AddInt32(&crMgr.inFlight, -1) == 0 &&
+		crMgr.rscQueue.Len() == 0
+	if drained {
+		crMgr.initState = false
+	}
+	crMgr.configChanged(drained)
+	return true
+}
+
+// configChanged marks the configuration to be posted by configFlusher,
+// which is requested to post it when the queue is drained or the
+// configuration is not posted for FlushInterval. The caller holds
+// processingMutex.
+func (crMgr *CRManager) configChanged(drained bool) {
+	if !crMgr.configDirty {
+		crMgr.configDirty = true
+		crMgr.dirtySince = time.Now()
+	}
+	crMgr.pendingEvents++
+	// The configs being rebuilt are posted once complete
+	if !drained && (crMgr.rebuilding ||
+		time.Since(crMgr.dirtySince) < crMgr.FlushInterval) {
+		return
+	}
+	select {
+	case crMgr.flushCh <- struct{}{}:
+	default:
+		// A flush is already requested.
+	}
+}
+
+// configFlusher posts the configuration when requested by the workers, at
+// most once per FlushInterval.
+func (crMgr *CRManager) configFlusher(stopCh <-chan struct{}) {
+	var lastFlush time.Time
+	for {
+		select {
+		case <-crMgr.flushCh:
+		case <-stopCh:
+			return
+		}
+		if wait := crMgr.FlushInterval - time.Since(lastFlush); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-stopCh:
+				return
+			}
+		}
+		crMgr.flushConfig()
+		lastFlush = time.Now()
 	}
+}
 
-	if isLastInQueue && !reflect.DeepEqual(
-		crMgr.resources.rsMap,
-		crMgr.resources.oldRsMap,
-	) {
+// flushConfig posts the configuration changed by the resources processed
+// since the last post. The old configs are updated only when posted, so the
+// diffs are against the configuration last posted to BIG-IP.
+func (crMgr *CRManager) flushConfig() {
+	crMgr.processingMutex.Lock()
+	defer crMgr.processingMutex.Unlock()
+	if !crMgr.configDirty || !crMgr.isLeader() {
+		return
+	}
+	if crMgr.rebuilding && (atomic.LoadInt32(&crMgr.inFlight) > 0 ||
+		crMgr.rscQueue.Len() > 0) {
+		// Posted when the queue is drained
+		return
+	}
+	events := crMgr.pendingEvents
+	crMgr.configDirty = false
+	crMgr.pendingEvents = 0
+
+	crMgr.detachUnusedRedirect(crMgr.resources.GetAllResources())
+	diffs := crMgr.resources.getConfigDiffs()
+	dnsChanged := !reflect.DeepEqual(
+		crMgr.resources.dnsConfig,
+		crMgr.resources.oldDNSConfig,
+	)
+	if crMgr.checkDrift {
+		reportDrift(diffs, dnsChanged)
+		crMgr.checkDrift = false
+	}
+	// The rebuilt configuration is posted even if unchanged, to restore
+	// the objects changed on BIG-IP
+	rebuilt := crMgr.rebuilding
+	crMgr.rebuilding = false
+	if len(diffs) > 0 || dnsChanged || rebuilt {
+		for ref, diff := range diffs {
+			rsName := JoinBigipPath(ref.Partition, ref.Name)
+			if diff.MembersOnly() {
+				log.Debugf("Virtual %s: members of pools %v changed",
+					rsName, diff.PoolMembers)
+			} else {
+				log.Debugf("Virtual %s changed: %+v", rsName, diff)
+			}
+		}
 
 		config := ResourceConfigWrapper{
 			rsCfgs:         crMgr.resources.GetAllResources(),
 			iRuleMap:       crMgr.irulesMap,
 			intDgMap:       crMgr.intDgMap,
 			customProfiles: crMgr.customProfiles,
+			dnsConfig:      crMgr.resources.dnsConfig,
+			podNetworks:    crMgr.getPodNetworks(),
+			partitions:     crMgr.AllowedPartitions,
+		}
+		config.sharedPartition = crMgr.SharedPartition
+		config.removedMembers = crMgr.resources.getRemovedPoolMembers(
+			config.podNetworks)
+
+		if rebuilt {
+			crMgr.Agent.activeTenants = nil
+			crMgr.declareFormerSharedPartition(&config)
+			if crMgr.pruneStale {
+				crMgr.pruneStaleObjects(config)
+			}
 		}
-
 		crMgr.Agent.PostConfig(config)
-		crMgr.initState = false
 		crMgr.resources.updateOldConfig()
+		bigIPPrometheus.CoalescedEvents.Add(float64(events - 1))
 	}
-	return true
+	crMgr.queueFinalizers()
 }
 
 // syncEndpoints returns the service associated with endpoints.
 func (crMgr *CRManager) syncEndpoints(ep *v1.Endpoints) *v1.Service {
+	return crMgr.getEndpointsService(ep.ObjectMeta.Namespace, ep.ObjectMeta.Name)
+}
 
-	epName := ep.ObjectMeta.Name
-	epNamespace := ep.ObjectMeta.Namespace
-	svcKey := fmt.Sprintf("%s/%s", epNamespace, epName)
+// syncEndpointSlice gets the service of the EndpointSlice, nil if the
+// slice does not belong to a service.
+func (crMgr *CRManager) syncEndpointSlice(
+	slice *discoveryv1alpha1.EndpointSlice,
+) *v1.Service {
+	svcName, ok := slice.ObjectMeta.Labels[discoveryv1alpha1.LabelServiceName]
+	if !ok || svcName == "" {
+		return nil
+	}
+	return crMgr.getEndpointsService(slice.ObjectMeta.Namespace, svcName)
+}
+
+// getEndpointsService gets the service with the given name from the store.
+func (crMgr *CRManager) getEndpointsService(
+	namespace string,
+	name string,
+) *v1.Service {
+	svcKey := fmt.Sprintf("%s/%s", namespace, name)
 
-	crInf, ok := crMgr.getNamespaceInformer(epNamespace)
+	crInf, ok := crMgr.getNamespaceInformer(namespace)
 	if !ok {
-		log.Errorf("Informer not found for namespace: %v", epNamespace)
+		log.Errorf("Informer not found for namespace: %v", namespace)
 		return nil
 	}
 	svc, exists, err := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
@@ -194,6 +452,416 @@ func (crMgr *CRManager) syncService(svc *v1.Service) []*cisapiv1.VirtualServer {
 	return virtualsForService
 }
 
+// updateTLSContext stores the TLSProfile in TLSContext and returns true
+// if the TLSProfile is new or changed.
+func (crMgr *CRManager) updateTLSContext(tls *cisapiv1.TLSProfile) bool {
+	tlsKey := tls.ObjectMeta.Namespace + "/" + tls.ObjectMeta.Name
+	crMgr.tlsMutex.Lock()
+	defer crMgr.tlsMutex.Unlock()
+	if oldTLS, ok := crMgr.TLSContext[tlsKey]; ok &&
+		reflect.DeepEqual(oldTLS.Spec, tls.Spec) {
+		return false
+	}
+	crMgr.TLSContext[tlsKey] = tls
+	return true
+}
+
+// deleteTLSProfile removes the TLSProfile from TLSContext along with the
+// custom profiles created from it and re-queues the VirtualServers using it.
+func (crMgr *CRManager) deleteTLSProfile(tls *cisapiv1.TLSProfile) {
+	tlsKey := tls.ObjectMeta.Namespace + "/" + tls.ObjectMeta.Name
+	crMgr.tlsMutex.Lock()
+	delete(crMgr.TLSContext, tlsKey)
+	crMgr.tlsMutex.Unlock()
+
+	if tls.Spec.TLS.Reference == Secret {
+		crMgr.customProfiles.Lock()
+		for _, vs := range crMgr.getVirtualServersForTLSProfile(tls) {
+			ref := crMgr.getVirtualServerRef(vs, DEFAULT_HTTPS_PORT)
+			delete(crMgr.customProfiles.Profs, SecretKey{
+				Name:         tls.Spec.TLS.ClientSSL,
+				ResourceName: ref.Name,
+				Partition:    ref.Partition,
+			})
+			delete(crMgr.customProfiles.Profs, SecretKey{
+				Name:         namer.DefaultSNIProfileName(ref.Name),
+				ResourceName: ref.Name,
+				Partition:    ref.Partition,
+			})
+		}
+		crMgr.customProfiles.Unlock()
+	}
+	crMgr.enqueueVirtualServersForTLSProfile(tls)
+}
+
+// enqueueVirtualServersForTLSProfile adds all the VirtualServers referring
+// the TLSProfile to rscQueue.
+func (crMgr *CRManager) enqueueVirtualServersForTLSProfile(
+	tls *cisapiv1.TLSProfile,
+) {
+	for _, vs := range crMgr.getVirtualServersForTLSProfile(tls) {
+		log.Debugf("Enqueueing VirtualServer %s affected by TLSProfile %s",
+			vs.ObjectMeta.Name, tls.ObjectMeta.Name)
+		crMgr.enqueueVirtualServer(vs)
+	}
+}
+
+// deleteVirtualServerConfig removes the rules and pools of the VirtualServer
+// from the virtuals it is configured on. The virtual is deleted when no
+// other VirtualServer shares it.
+func (crMgr *CRManager) deleteVirtualServerConfig(vs *cisapiv1.VirtualServer) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	objKey, deps := NewObjectDependencies(vs)
+	crMgr.resources.deleteDependencies(objKey)
+	var depsRemoved []ObjectDependency
+	for dep := range deps {
+		if dep.Kind == RuleDep {
+			depsRemoved = append(depsRemoved, dep)
+		}
+	}
+
+	for _, portStruct := range crMgr.virtualPorts(vs) {
+		ref := crMgr.getVirtualServerRef(vs, portStruct.port)
+		rsCfg, ok := crMgr.resources.removeOwner(ref, vsKey)
+		if !ok {
+			continue
+		}
+		if len(rsCfg.MetaData.owners) == 0 {
+			crMgr.deleteVirtual(ref)
+			continue
+		}
+		crMgr.rulesMutex.Lock()
+		rsCfg.DeleteUnusedRules(crMgr.resources, depsRemoved, nil,
+			crMgr.mergedRulesMap)
+		crMgr.rulesMutex.Unlock()
+		crMgr.updateVirtualDefaultPool(rsCfg, nil)
+		rsCfg.DeleteUnusedPool()
+		crMgr.updateVirtualIRules(rsCfg, nil)
+		crMgr.updateVirtualWAF(rsCfg, nil)
+		crMgr.updateVirtualHSTS(rsCfg, nil)
+		crMgr.updateVirtualLimits(rsCfg, nil)
+		crMgr.updateVirtualAddressSettings(rsCfg, nil)
+		crMgr.updateVirtualEnabled(rsCfg, nil)
+		crMgr.updateVirtualPoolActions(rsCfg)
+	}
+	crMgr.deleteUnusedSecretProfiles(crMgr.releaseSecretProfiles(vsKey))
+	crMgr.deleteABDeploymentRecords(vs)
+	crMgr.deleteRedirectRecords(vs)
+	for _, dgName := range tlsHostDataGroups {
+		crMgr.deleteHostDataGroupRecord(dgName, vs)
+	}
+	crMgr.enqueueVirtualServersForRules(objKey, depsRemoved)
+}
+
+// releaseVirtualPorts removes the VirtualServer from its virtuals on the
+// ports it no longer uses, like the HTTP virtual with httpTraffic none. The
+// rules and pools of the VirtualServer are deleted from a virtual shared
+// with other VirtualServers, unless they use them too.
+func (crMgr *CRManager) releaseVirtualPorts(
+	vs *cisapiv1.VirtualServer,
+	ports []portStruct,
+) {
+	vsKey := vs.ObjectMeta.Namespace + "/" + vs.ObjectMeta.Name
+	objKey, deps := NewObjectDependencies(vs)
+	used := make(map[int32]bool)
+	for _, portStruct := range ports {
+		used[portStruct.port] = true
+	}
+	for _, port := range []int32{DEFAULT_HTTP_PORT, DEFAULT_HTTPS_PORT} {
+		if used[port] {
+			continue
+		}
+		ref := crMgr.getVirtualServerRef(vs, port)
+		rsCfg, ok := crMgr.resources.removeOwner(ref, vsKey)
+		if !ok {
+			continue
+		}
+		log.Debugf("Removing VirtualServer %s from virtual %s", vsKey,
+			ref.Name)
+		if len(rsCfg.MetaData.owners) == 0 {
+			crMgr.deleteVirtual(ref)
+			continue
+		}
+		var depsRemoved []ObjectDependency
+		for dep := range deps {
+			if dep.Kind == RuleDep && !crMgr.isOwnerDependency(rsCfg, dep) {
+				depsRemoved = append(depsRemoved, dep)
+				crMgr.rulesMutex.Lock()
+				rsCfg.deleteRulesForDependency(dep, nil, crMgr.mergedRulesMap)
+				crMgr.rulesMutex.Unlock()
+			}
+		}
+		crMgr.updateVirtualDefaultPool(rsCfg, nil)
+		rsCfg.DeleteUnusedPool()
+		crMgr.updateVirtualIRules(rsCfg, nil)
+		crMgr.updateVirtualWAF(rsCfg, nil)
+		crMgr.updateVirtualHSTS(rsCfg, nil)
+		crMgr.updateVirtualLimits(rsCfg, nil)
+		crMgr.updateVirtualAddressSettings(rsCfg, nil)
+		crMgr.updateVirtualEnabled(rsCfg, nil)
+		crMgr.updateVirtualPoolActions(rsCfg)
+		crMgr.enqueueVirtualServersForRules(objKey, depsRemoved)
+	}
+}
+
+// isOwnerDependency returns true if a VirtualServer configured on the
+// virtual depends on dep.
+func (crMgr *CRManager) isOwnerDependency(
+	rsCfg *ResourceConfig,
+	dep ObjectDependency,
+) bool {
+	crMgr.resources.RLock()
+	defer crMgr.resources.RUnlock()
+	for _, owner := range rsCfg.MetaData.owners {
+		splits := strings.SplitN(owner, "/", 2)
+		key := ObjectDependency{
+			Kind:      VirtualServer,
+			Namespace: splits[0],
+			Name:      splits[len(splits)-1],
+		}
+		if _, found := crMgr.resources.objDeps[key][dep]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueVirtualServersForRules enqueues the VirtualServers with rules for
+// the same host and path as the removed dependencies, so the rules dropped
+// on conflict are configured again.
+func (crMgr *CRManager) enqueueVirtualServersForRules(
+	objKey ObjectDependency,
+	depsRemoved []ObjectDependency,
+) {
+	crMgr.resources.RLock()
+	defer crMgr.resources.RUnlock()
+	for key, deps := range crMgr.resources.objDeps {
+		if key.Kind != VirtualServer || key == objKey {
+			continue
+		}
+		for _, depRemoved := range depsRemoved {
+			if depRemoved.Kind != RuleDep ||
+				!deps.hasRuleForPath(depRemoved.Name) {
+				continue
+			}
+			vs, found := crMgr.getVirtualServer(key.Namespace + "/" + key.Name)
+			if found {
+				crMgr.enqueueVirtualServer(vs)
+			}
+			break
+		}
+	}
+}
+
+// enqueueVirtualServersForDependency adds the VirtualServers depending on
+// the object to rscQueue.
+func (crMgr *CRManager) enqueueVirtualServersForDependency(dep ObjectDependency) {
+	crMgr.resources.RLock()
+	defer crMgr.resources.RUnlock()
+	for key, deps := range crMgr.resources.objDeps {
+		if key.Kind != VirtualServer {
+			continue
+		}
+		if _, ok := deps[dep]; !ok {
+			continue
+		}
+		vs, found := crMgr.getVirtualServer(key.Namespace + "/" + key.Name)
+		if !found {
+			continue
+		}
+		log.Debugf("Enqueueing VirtualServer %s affected by %s %s",
+			vs.ObjectMeta.Name, dep.Kind, dep.Name)
+		crMgr.enqueueVirtualServer(vs)
+	}
+}
+
+// serviceDependency returns the dependency on the service, the key of
+// pendingServices.
+func serviceDependency(namespace, svcName string) ObjectDependency {
+	return ObjectDependency{
+		Kind:      Service,
+		Namespace: namespace,
+		Service:   svcName,
+	}
+}
+
+// addPendingServices records the VirtualServer as waiting for the services
+// of its invalid pools, with an Event for each service it starts waiting for.
+func (crMgr *CRManager) addPendingServices(
+	vs *cisapiv1.VirtualServer,
+	invalidPools []cisapiv1.Pool,
+) {
+	namespace := vs.ObjectMeta.Namespace
+	vsDep := ObjectDependency{
+		Kind:      VirtualServer,
+		Namespace: namespace,
+		Name:      vs.ObjectMeta.Name,
+	}
+	for _, pool := range invalidPools {
+		svcDep := serviceDependency(namespace, pool.Service)
+		crMgr.pendingMutex.Lock()
+		vsDeps, found := crMgr.pendingServices[svcDep]
+		if !found {
+			vsDeps = make(ObjectDependencies)
+			crMgr.pendingServices[svcDep] = vsDeps
+		}
+		_, waiting := vsDeps[vsDep]
+		vsDeps[vsDep] = 1
+		crMgr.pendingMutex.Unlock()
+		if waiting {
+			continue
+		}
+		msg := fmt.Sprintf("Waiting for service %s", pool.Service)
+		log.Infof("VirtualServer %s/%s: %s", namespace, vs.ObjectMeta.Name, msg)
+		crMgr.recordEvent(vs, namespace, v1.EventTypeNormal,
+			"WaitingForService", msg)
+	}
+}
+
+// clearPendingServices stops the VirtualServer waiting for the services
+// whose pools have members, with an Event for each of them, and for the
+// services it no longer refers to.
+func (crMgr *CRManager) clearPendingServices(vs *cisapiv1.VirtualServer) {
+	namespace := vs.ObjectMeta.Namespace
+	vkey := namespace + "/" + vs.ObjectMeta.Name
+	vsDep := ObjectDependency{
+		Kind:      VirtualServer,
+		Namespace: namespace,
+		Name:      vs.ObjectMeta.Name,
+	}
+	referred := make(map[string]bool)
+	for _, pool := range vs.Spec.Pools {
+		referred[pool.Service] = true
+	}
+	populated := make(map[string]bool)
+	for _, rsCfg := range crMgr.resources.GetAllResources() {
+		if rsCfg.MetaData.ResourceType != VirtualServer ||
+			!rsCfg.MetaData.hasOwner(vkey) {
+			continue
+		}
+		for _, pool := range rsCfg.Pools {
+			if pool.ServiceNamespace == namespace && len(pool.Members) > 0 {
+				populated[pool.ServiceName] = true
+			}
+		}
+	}
+
+	var ready []string
+	crMgr.pendingMutex.Lock()
+	for svcDep, vsDeps := range crMgr.pendingServices {
+		if _, ok := vsDeps[vsDep]; !ok || svcDep.Namespace != namespace {
+			continue
+		}
+		if referred[svcDep.Service] && !populated[svcDep.Service] {
+			continue
+		}
+		delete(vsDeps, vsDep)
+		if len(vsDeps) == 0 {
+			delete(crMgr.pendingServices, svcDep)
+		}
+		if referred[svcDep.Service] {
+			ready = append(ready, svcDep.Service)
+		}
+	}
+	crMgr.pendingMutex.Unlock()
+
+	sort.Strings(ready)
+	for _, svcName := range ready {
+		msg := fmt.Sprintf("Service %s is ready", svcName)
+		log.Infof("VirtualServer %s: %s", vkey, msg)
+		crMgr.recordEvent(vs, namespace, v1.EventTypeNormal,
+			"ServiceReady", msg)
+	}
+}
+
+// deletePendingServices stops the deleted VirtualServer waiting for any
+// service.
+func (crMgr *CRManager) deletePendingServices(vs *cisapiv1.VirtualServer) {
+	vsDep := ObjectDependency{
+		Kind:      VirtualServer,
+		Namespace: vs.ObjectMeta.Namespace,
+		Name:      vs.ObjectMeta.Name,
+	}
+	crMgr.pendingMutex.Lock()
+	defer crMgr.pendingMutex.Unlock()
+	for svcDep, vsDeps := range crMgr.pendingServices {
+		delete(vsDeps, vsDep)
+		if len(vsDeps) == 0 {
+			delete(crMgr.pendingServices, svcDep)
+		}
+	}
+}
+
+// enqueuePendingVirtualServers adds the VirtualServers waiting for the
+// service to rscQueue.
+func (crMgr *CRManager) enqueuePendingVirtualServers(namespace, svcName string) {
+	var keys []string
+	crMgr.pendingMutex.Lock()
+	for vsDep := range crMgr.pendingServices[serviceDependency(namespace, svcName)] {
+		keys = append(keys, vsDep.Namespace+"/"+vsDep.Name)
+	}
+	crMgr.pendingMutex.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		vs, found := crMgr.getVirtualServer(key)
+		if !found {
+			continue
+		}
+		log.Debugf("Enqueueing VirtualServer %s waiting for service %s",
+			key, svcName)
+		crMgr.enqueueVirtualServer(vs)
+	}
+}
+
+// enqueueConflictingVirtualServers enqueues the VirtualServers rejected
+// for using the address of a deleted VirtualServer.
+func (crMgr *CRManager) enqueueConflictingVirtualServers(
+	vs *cisapiv1.VirtualServer,
+) {
+	if crMgr.SharedVIPPolicy != SharedVIPReject {
+		return
+	}
+	address := crMgr.getVirtualServerAddress(vs)
+	for _, crInf := range crMgr.crInformers {
+		for _, obj := range crInf.vsInformer.GetIndexer().List() {
+			virtual := obj.(*cisapiv1.VirtualServer)
+			if virtual.ObjectMeta.Namespace == vs.ObjectMeta.Namespace &&
+				virtual.ObjectMeta.Name == vs.ObjectMeta.Name {
+				continue
+			}
+			if crMgr.getVirtualServerAddress(virtual) == address {
+				crMgr.enqueueVirtualServer(virtual)
+			}
+		}
+	}
+}
+
+// getVirtualServersForTLSProfile returns list of VirtualServers that are
+// referring the TLSProfile.
+func (crMgr *CRManager) getVirtualServersForTLSProfile(
+	tls *cisapiv1.TLSProfile,
+) []*cisapiv1.VirtualServer {
+	var result []*cisapiv1.VirtualServer
+	for _, vs := range crMgr.getAllVirtualServers(tls.ObjectMeta.Namespace) {
+		if vs.ObjectMeta.Namespace == tls.ObjectMeta.Namespace &&
+			isTLSProfileOf(vs, tls.ObjectMeta.Name) {
+			result = append(result, vs)
+		}
+	}
+	return result
+}
+
+// isTLSProfileOf returns true if the VirtualServer refers the TLSProfile.
+func isTLSProfileOf(vs *cisapiv1.VirtualServer, tlsName string) bool {
+	for _, name := range getTLSProfileNames(vs) {
+		if name == tlsName {
+			return true
+		}
+	}
+	return false
+}
+
 // getAllVirtualServers returns list of all valid VirtualServers in rkey namespace.
 func (crMgr *CRManager) getAllVirtualServers(namespace string) []*cisapiv1.VirtualServer {
 	var allVirtuals []*cisapiv1.VirtualServer
@@ -236,6 +904,14 @@ func getVirtualServersForService(allVirtuals []*cisapiv1.VirtualServer,
 				break
 			}
 		}
+		if nil != vs.Spec.DefaultPool && vs.Spec.DefaultPool.Service == svcName {
+			isValidVirtual = true
+		}
+		for _, pool := range vs.Spec.Pools {
+			if nil != pool.BackupPool && pool.BackupPool.Service == svcName {
+				isValidVirtual = true
+			}
+		}
 		if !isValidVirtual {
 			continue
 		}
@@ -250,6 +926,12 @@ func getVirtualServersForService(allVirtuals []*cisapiv1.VirtualServer,
 // create a resource config(Internal DataStructure) for a new Virtual Server and update the
 // resource config for existing Virtual Server.
 func (crMgr *CRManager) syncVirtualServer(virtual *cisapiv1.VirtualServer) error {
+	// VirtualServers synced for a Service are logged with their fields
+	defer log.WithContext(log.Fields{
+		"namespace": virtual.ObjectMeta.Namespace,
+		"kind":      VirtualServer,
+		"name":      virtual.ObjectMeta.Name,
+	})()
 
 	startTime := time.Now()
 	defer func() {
@@ -260,8 +942,29 @@ func (crMgr *CRManager) syncVirtualServer(virtual *cisapiv1.VirtualServer) error
 
 	svcFwdRulesMap := NewServiceFwdRuleMap()
 
-	// check if the virutal server matches all the requirements.
 	vkey := virtual.ObjectMeta.Namespace + "/" + virtual.ObjectMeta.Name
+	// The status is written from the virtuals configured and the warnings
+	// recorded in this sync.
+	crMgr.clearVirtualServerWarning(vkey)
+	crMgr.takeRetryError(vkey)
+	defer crMgr.updateVirtualServerStatus(virtual)
+
+	// Allocate the address from IPAM, the VirtualServer is processed again
+	// with backoff if the allocation fails. The address of the host group
+	// is updated first, its members without address use it.
+	if err := crMgr.updateHostGroups(virtual); err != nil {
+		crMgr.recordEvent(virtual, virtual.ObjectMeta.Namespace,
+			v1.EventTypeWarning, "IPAMError", err.Error())
+		return retryable(fmt.Errorf("VirtualServer %s: %v", vkey, err))
+	}
+	if err := crMgr.allocateVirtualServerAddress(virtual); err != nil {
+		crMgr.recordEvent(virtual, virtual.ObjectMeta.Namespace,
+			v1.EventTypeWarning, "IPAMError", err.Error())
+		return retryable(fmt.Errorf(
+			"Failed to allocate address for VirtualServer %s: %v", vkey, err))
+	}
+
+	// check if the virutal server matches all the requirements.
 	valid := crMgr.checkValidVirtualServer(virtual)
 	if false == valid {
 		log.Infof("VirtualServer %s, invalid configuration or not valid",
@@ -269,36 +972,90 @@ func (crMgr *CRManager) syncVirtualServer(virtual *cisapiv1.VirtualServer) error
 		return nil
 	}
 
-	// TODO: Needed to handle multiple VS resources into one VS on BIG-IP
+	crMgr.checkWildcardHostOverlap(virtual)
+
+	// The VirtualServer is processed without the Policy it refers to until
+	// the Policy is created.
+	if virtual.Spec.PolicyName != "" {
+		if _, found := crMgr.getPolicyForVirtualServer(virtual); !found {
+			msg := fmt.Sprintf("Policy %s not found, processing the "+
+				"VirtualServer without it", virtual.Spec.PolicyName)
+			log.Warningf("VirtualServer %s: %s", vkey, msg)
+			crMgr.recordEvent(virtual, virtual.ObjectMeta.Namespace,
+				v1.EventTypeWarning, "PolicyNotFound", msg)
+		}
+	}
+
+	// Reject the VirtualServer or skip its pools referring to
+	// nonexistent services, as per its partialErrorPolicy.
+	validVirtual := crMgr.filterInvalidPools(virtual)
+	if nil == validVirtual {
+		return nil
+	}
+	// The skipped pools still wait for their services.
+	defer crMgr.clearPendingServices(virtual)
+	virtual = validVirtual
+
+	// Skip the VirtualServer beyond the quota of its namespace.
+	oldVirtual, wasAdmitted := crMgr.getAdmittedVirtualServer(vkey)
+	if !crMgr.admitVirtualServer(virtual) {
+		return nil
+	}
+
+	// The VirtualServer moved to another partition is deleted from the
+	// former partition first, its virtuals are created again below.
+	if wasAdmitted && crMgr.getVirtualServerPartition(oldVirtual) !=
+		crMgr.getVirtualServerPartition(virtual) {
+		log.Infof("VirtualServer %s moved from partition %s to %s", vkey,
+			crMgr.getVirtualServerPartition(oldVirtual),
+			crMgr.getVirtualServerPartition(virtual))
+		crMgr.deleteVirtualServerConfig(oldVirtual)
+	}
+
+	crMgr.checkServicePortNames(virtual)
+
 	// Get a list of dependencies removed so their pools can be removed.
-	//objKey, objDeps := NewObjectDependencies(virtual)
-	//
-	//virtualLookupFunc := func(key ObjectDependency) bool {
-	//	return false
-	//}
+	objKey, objDeps := NewObjectDependencies(virtual)
+	crMgr.addSecretDependency(virtual, objDeps)
+
+	virtualLookupFunc := func(key ObjectDependency) bool {
+		return false
+	}
 
-	// TODO ==> UpdateDependencies to get the added and removed deps.
-	//_, depsRemoved := crMgr.resources.UpdateDependencies(
-	//	objKey, objDeps, virtualLookupFunc)
+	_, depsRemoved := crMgr.resources.UpdateDependencies(
+		objKey, objDeps, virtualLookupFunc)
+	crMgr.enqueueVirtualServersForRules(objKey, depsRemoved)
+
+	// The profiles still used by the VirtualServer are referred again
+	// below, the others are deleted when no other VirtualServer uses them.
+	heldProfiles := crMgr.releaseSecretProfiles(vkey)
+	hostRecords := make(map[string]string)
 
 	// Depending on the ports defined, TLS type or Unsecured we will populate the resource config.
 	portStructs := crMgr.virtualPorts(virtual)
+	crMgr.releaseVirtualPorts(virtual, portStructs)
 	for _, portStruct := range portStructs {
-		rsCfg := crMgr.createRSConfigFromVirtualServer(
+		rsName := crMgr.getVirtualServerName(virtual, portStruct.port)
+		if !crMgr.claimVirtual(virtual, rsName, portStruct.port) {
+			continue
+		}
+		rsCfg, err := crMgr.createRSConfigFromVirtualServer(
 			virtual,
 			portStruct,
 		)
-		if rsCfg == nil {
-			// Currently, an error is returned only if the VirtualServer is one we
-			// do not care about
+		if err != nil {
+			log.Errorf("VirtualServer %s rejected: %v", vkey, err)
+			crMgr.recordEvent(virtual, virtual.ObjectMeta.Namespace,
+				v1.EventTypeWarning, "InvalidData", err.Error())
 			continue
 		}
 
 		// Handle TLS configuration for VirtualServer Custom Resource
-		updated := crMgr.handleVirtualServerTLS(rsCfg, virtual, svcFwdRulesMap)
+		updated := crMgr.handleVirtualServerTLS(rsCfg, virtual,
+			svcFwdRulesMap, hostRecords)
 		if updated {
-			log.Infof("Updated Virtual %s with TLSProfile %s",
-				virtual.ObjectMeta.Name, virtual.Spec.TLSProfileName)
+			log.Infof("Updated Virtual %s with TLSProfiles %v",
+				virtual.ObjectMeta.Name, getTLSProfileNames(virtual))
 		}
 
 		log.Infof("ResourceConfig looks like %v", rsCfg)
@@ -307,30 +1064,32 @@ func (crMgr *CRManager) syncVirtualServer(virtual *cisapiv1.VirtualServer) error
 		// Used in handleConfigForType.
 		var svcs []string
 		for _, pl := range virtual.Spec.Pools {
-			svcs = append(svcs, pl.Service)
+			if pl.Service != "" {
+				svcs = append(svcs, pl.Service)
+			}
+			if nil != pl.BackupPool && pl.BackupPool.Service != "" {
+				svcs = append(svcs, pl.BackupPool.Service)
+			}
+		}
+		if nil != virtual.Spec.DefaultPool {
+			svcs = append(svcs, virtual.Spec.DefaultPool.Service)
 		}
 
 		// Remove any dependencies no longer used by this VirtualServer
-		//for _, dep := range depsRemoved {
-		//	if dep.Kind == RuleDep {
-		//		//TODO ==> To be implemented Post Alpha.
-		//		//Delete unused pool from resource config
-		//		// rsCfg.DeleteUnusedPool()
-		//		for _, pol := range rsCfg.Policies {
-		//			for _, rl := range pol.Rules {
-		//				if rl.FullURI == dep.Name {
-		//					rsCfg.DeleteRuleFromPolicy(pol.Name, rl, crMgr.mergedRulesMap)
-		//				}
-		//			}
-		//		}
-		//	}
-		//}
-
-		if crMgr.ControllerMode == NodePortMode {
-			crMgr.updatePoolMembersForNodePort(rsCfg, virtual.ObjectMeta.Namespace)
-		} else {
-			crMgr.updatePoolMembersForCluster(rsCfg, virtual.ObjectMeta.Namespace)
+		ruleNames := make(map[string]bool)
+		for _, rl := range *processVirtualServerRules(virtual) {
+			ruleNames[rl.Name] = true
 		}
+		crMgr.rulesMutex.Lock()
+		rsCfg.DeleteUnusedRules(crMgr.resources, depsRemoved,
+			ruleNames, crMgr.mergedRulesMap)
+		crMgr.rulesMutex.Unlock()
+		rsCfg.DeleteUnusedPool()
+		crMgr.updateVirtualPoolActions(rsCfg)
+
+		crMgr.updatePoolMembers(rsCfg, virtual.ObjectMeta.Namespace)
+		// Pool members are in the same route domain as the virtual.
+		rsCfg.updatePoolMembersRouteDomain()
 
 		/** TODO ==> To be implemented Post Alpha.
 		if ok, found, updated := crMgr.handleConfigForType(
@@ -401,114 +1160,515 @@ func (crMgr *CRManager) syncVirtualServer(virtual *cisapiv1.VirtualServer) error
 		}
 	}
 	**/
+	crMgr.deleteUnusedSecretProfiles(heldProfiles)
+
+	// The data groups of the namespace are updated at once, as the
+	// VirtualServers of the namespace are processed concurrently
 	dgMap := make(InternalDataGroupMap)
 	log.Debugf("Length of svcFwdRulesMap is %v", len(svcFwdRulesMap))
-	if len(svcFwdRulesMap) > 0 {
-		httpsRedirectDg := NameRef{
-			Name:      HttpsRedirectDgName,
-			Partition: DEFAULT_PARTITION,
+	crMgr.intDgMutex.Lock()
+	crMgr.updateRedirectDataGroup(dgMap, virtual, svcFwdRulesMap)
+	crMgr.updateABDeploymentDataGroup(dgMap, virtual, depsRemoved)
+	for _, dgName := range tlsHostDataGroups {
+		crMgr.updateHostDataGroup(dgMap, dgName, virtual,
+			hostRecords[dgName], depsRemoved)
+	}
+	crMgr.syncDataGroups(dgMap, virtual.ObjectMeta.Namespace)
+	crMgr.intDgMutex.Unlock()
+	var rsCfgs ResourceConfigs
+	for _, ref := range crMgr.resources.getVirtuals(VirtualServer, vkey) {
+		if rsCfg, ok := crMgr.resources.GetByName(ref.Partition, ref.Name); ok {
+			rsCfgs = append(rsCfgs, rsCfg)
 		}
-		if _, found := dgMap[httpsRedirectDg]; !found {
-			dgMap[httpsRedirectDg] = make(DataGroupNamespaceMap)
+	}
+	crMgr.detachUnusedRedirect(rsCfgs)
+
+	// The VirtualServer is synced again for the transient failures, like
+	// a secret not found yet
+	return crMgr.takeRetryError(vkey)
+}
+
+// syncTransportServer creates the resource configs of the virtual for the
+// TransportServer, listening on its ports with a port list or a virtual per
+// port. The TransportServer is rejected with an Event if the address and
+// one of its ports are used by another VirtualServer or TransportServer.
+func (crMgr *CRManager) syncTransportServer(ts *cisapiv1.TransportServer) {
+	if !crMgr.checkValidTransportServer(ts) {
+		log.Infof("TransportServer %s, invalid configuration or not valid",
+			tsKey(ts))
+		crMgr.deleteResourceConfigs(TransportServer, tsKey(ts))
+		return
+	}
+
+	ports := getTransportServerPorts(ts)
+	for _, port := range ports {
+		if !crMgr.claimResourceVirtual(ts, TransportServer,
+			ts.ObjectMeta.Namespace, tsKey(ts),
+			crMgr.getTransportServerName(ts, port),
+			ts.Spec.VirtualServerAddress, port) {
+			crMgr.deleteResourceConfigs(TransportServer, tsKey(ts))
+			return
 		}
-		svcFwdRulesMap.AddToDataGroup(dgMap[httpsRedirectDg])
 	}
 
-	crMgr.syncDataGroups(dgMap, virtual.ObjectMeta.Namespace)
+	// The config of the first port gets the pool members, the configs of
+	// the other ports get them from it with the port mapping.
+	rsCfg := crMgr.createRSConfigFromTransportServer(ts, ports[0])
+	crMgr.updatePoolMembers(rsCfg, ts.ObjectMeta.Namespace)
+	// Pool members are in the same route domain as the virtual.
+	rsCfg.updatePoolMembersRouteDomain()
+	rsCfgs := listenOnPorts(rsCfg, ports, ts.Spec.PortMapping,
+		crMgr.Agent.SupportsPortLists(),
+		func(port int32) *ResourceConfig {
+			return crMgr.createRSConfigFromTransportServer(ts, port)
+		})
+
+	// Remove the virtuals of the previous address, ports and partition.
+	var refs []NameRef
+	for _, cfg := range rsCfgs {
+		refs = append(refs, NameRef{
+			Name:      cfg.Virtual.Name,
+			Partition: cfg.Virtual.Partition,
+		})
+	}
+	crMgr.deleteResourceConfigs(TransportServer, tsKey(ts), refs...)
+	log.Debugf("ResourceConfigs of TransportServer %s look like %v",
+		tsKey(ts), rsCfgs)
+}
 
-	return nil
+// tsKey returns the key of the TransportServer, namespace/name
+func tsKey(ts *cisapiv1.TransportServer) string {
+	return ts.ObjectMeta.Namespace + "/" + ts.ObjectMeta.Name
 }
 
-// updatePoolMembersForNodePort updates the pool with pool members for a
-// service created in nodeport mode.
-func (crMgr *CRManager) updatePoolMembersForNodePort(
+// claimResourceVirtual returns true if the TransportServer or IngressLink
+// can be configured on the virtual of the address and port. Their virtuals
+// are not shared, the resource is rejected with an Event if another
+// resource uses the virtual.
+func (crMgr *CRManager) claimResourceVirtual(
+	obj runtime.Object,
+	kind string,
+	namespace string,
+	rscKey string,
+	rsName string,
+	address string,
+	port int32,
+) bool {
+	rsCfg, ok := crMgr.resources.getVirtualConfig(
+		crMgr.getNamespacePartition(namespace), rsName,
+		formatRouteDomainAddress(normalizeAddress(address),
+			crMgr.getRouteDomain(namespace)), port)
+	if !ok {
+		return true
+	}
+	rsName = rsCfg.Virtual.Name
+	if rsCfg.MetaData.ResourceType == kind &&
+		rsCfg.MetaData.hasOwner(rscKey) {
+		return true
+	}
+	msg := fmt.Sprintf("Address of virtual %s is used by %s %s",
+		rsName, rsCfg.MetaData.ResourceType,
+		strings.Join(rsCfg.MetaData.owners, ", "))
+	log.Errorf("%s %s rejected: %s", kind, rscKey, msg)
+	crMgr.recordEvent(obj, namespace, v1.EventTypeWarning,
+		"AddressConflict", msg)
+	return false
+}
+
+// deleteVirtual deletes the resource config of the virtual along with the
+// records of its merged rules.
+func (crMgr *CRManager) deleteVirtual(ref NameRef) {
+	crMgr.resources.deleteVirtualServer(ref)
+	crMgr.rulesMutex.Lock()
+	delete(crMgr.mergedRulesMap, ref)
+	crMgr.rulesMutex.Unlock()
+	crMgr.irulesMutex.Lock()
+	delete(crMgr.irulesMap, NameRef{
+		Name:      crMgr.poolActionIRuleName(ref.Partition, ref.Name),
+		Partition: crMgr.objectsPartition(ref.Partition),
+	})
+	crMgr.irulesMutex.Unlock()
+}
+
+// deleteResourceConfigs removes the virtuals of the TransportServer or
+// IngressLink except the virtuals in keep.
+func (crMgr *CRManager) deleteResourceConfigs(
+	kind string,
+	rscKey string,
+	keep ...NameRef,
+) {
+	for _, ref := range crMgr.resources.getVirtuals(kind, rscKey) {
+		if !containsNameRef(keep, ref) {
+			crMgr.deleteVirtual(ref)
+		}
+	}
+}
+
+// syncTransportServersForService processes the TransportServers using the
+// service again to update their pool members.
+func (crMgr *CRManager) syncTransportServersForService(svc *v1.Service) {
+	crInf, ok := crMgr.getNamespaceInformer(svc.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	for _, obj := range crInf.transportInformer.GetIndexer().List() {
+		ts := obj.(*cisapiv1.TransportServer)
+		if ts.ObjectMeta.Namespace == svc.ObjectMeta.Namespace &&
+			ts.Spec.Pool.Service == svc.ObjectMeta.Name {
+			crMgr.syncTransportServer(ts)
+		}
+	}
+}
+
+// getPoolMemberType returns the member type of the pool, the ControllerMode
+// unless the pool has one.
+func (crMgr *CRManager) getPoolMemberType(memberType string) string {
+	if memberType != "" {
+		return memberType
+	}
+	if crMgr.ControllerMode == NodePortMode {
+		return NodePortMode
+	}
+	return ClusterMode
+}
+
+// updatePoolMembers updates the members of each pool for its member type,
+// pod addresses in cluster mode or node addresses in nodeport mode.
+func (crMgr *CRManager) updatePoolMembers(
 	rsCfg *ResourceConfig,
 	namespace string,
 ) {
-	// TODO: Can we get rid of counter? and use something better.
 	crInf, ok := crMgr.getNamespaceInformer(namespace)
 	if !ok {
 		log.Errorf("Informer not found for namespace: %v", namespace)
 		return
 	}
 
-	for index, pool := range rsCfg.Pools {
-		svcName := pool.ServiceName
-		svcKey := namespace + "/" + svcName
+	for index := range rsCfg.Pools {
+		crMgr.updatePoolMembersOfPool(rsCfg, index, namespace, crInf)
+	}
+	crMgr.updateActive(rsCfg)
+}
 
-		// TODO: Too Many API calls?
-		service, exist, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
-		if !exist {
-			log.Debugf("Service not found %s", svcKey)
-			// Update the pool with empty members
-			var member []Member
-			rsCfg.Pools[index].Members = member
-			continue
+// updateActive marks the config active or inactive from its pool members,
+// and disables the inactive virtual with DisableInactive. The VirtualServers
+// configured on the virtual get an Event when it becomes inactive and when
+// it is active again, and their status is updated. A new virtual without
+// members is only reported by the status.
+func (crMgr *CRManager) updateActive(rsCfg *ResourceConfig) {
+	wasActive := rsCfg.MetaData.Active
+	active := rsCfg.isActive()
+	rsCfg.MetaData.Active = active
+	rsCfg.Virtual.Enabled = !rsCfg.MetaData.disabled &&
+		(active || !crMgr.DisableInactive)
+	if active && !rsCfg.MetaData.inactive || !active && !wasActive {
+		return
+	}
+	rsCfg.MetaData.inactive = !active
+
+	reason := "PoolMembersAvailable"
+	msg := fmt.Sprintf("Virtual %s has pool members", rsCfg.Virtual.Name)
+	if !active {
+		reason = "NoPoolMembers"
+		msg = fmt.Sprintf("Virtual %s has no pool members", rsCfg.Virtual.Name)
+		if crMgr.DisableInactive {
+			msg += ", it is disabled"
 		}
-		svc := service.(*v1.Service)
-		// Traverse for all the pools in the Resource Config
-		if svc.Spec.Type == v1.ServiceTypeNodePort ||
-			svc.Spec.Type == v1.ServiceTypeLoadBalancer {
-			// TODO: Instead of looping over Spec Ports, get the port from the pool itself
-			for _, portSpec := range svc.Spec.Ports {
-				rsCfg.MetaData.Active = true
-				rsCfg.Pools[index].Members =
-					crMgr.getEndpointsForNodePort(portSpec.NodePort, pool.NodeMemberLabel)
-			}
-		} else {
-			log.Debugf("Requested service backend %s not of NodePort or LoadBalancer type",
-				svcName)
+	}
+	log.Infof("%s %s: %s", rsCfg.MetaData.ResourceType,
+		strings.Join(rsCfg.MetaData.owners, ", "), msg)
+	if rsCfg.MetaData.ResourceType != VirtualServer {
+		return
+	}
+	for _, vsKey := range rsCfg.MetaData.owners {
+		vs, found := crMgr.getVirtualServer(vsKey)
+		if !found {
+			continue
 		}
+		crMgr.recordEvent(vs, vs.ObjectMeta.Namespace, v1.EventTypeNormal,
+			reason, msg)
+		crMgr.updateVirtualServerStatus(vs)
 	}
 }
 
-// updatePoolMembersForCluster updates the pool with pool members for a
-// service created in cluster mode.
-func (crMgr *CRManager) updatePoolMembersForCluster(
+// updatePoolMembersOfPool updates the members of the pool at index, along
+// with its static and backup members.
+func (crMgr *CRManager) updatePoolMembersOfPool(
 	rsCfg *ResourceConfig,
+	index int,
 	namespace string,
+	crInf *CRInformer,
 ) {
+	pool := &rsCfg.Pools[index]
+	if nil != pool.Backup || len(pool.StaticMembers) > 0 {
+		// The members are added again with the static and backup
+		// members
+		pool.Members = nil
+	}
+	switch {
+	case pool.ServiceName == "":
+		// Pool of static members only
+	case crMgr.getPoolMemberType(pool.MemberType) == NodePortMode:
+		crMgr.updatePoolMembersForNodePort(rsCfg, index, namespace, crInf)
+	default:
+		crMgr.updatePoolMembersForCluster(rsCfg, index, namespace, crInf)
+	}
+	pool.Members = mergeMembers(pool.Members, pool.StaticMembers)
+	crMgr.updatePoolBackupMembers(rsCfg, index, namespace, crInf)
+}
 
+// updatePoolMembersForService updates the members of the pools of the
+// service, and of the pools with the service as backup, in place. The
+// rest of the resource configs is unchanged, so that the pools are not
+// recreated on BIG-IP when only their members change.
+func (crMgr *CRManager) updatePoolMembersForService(svc *v1.Service) {
+	namespace := svc.ObjectMeta.Namespace
 	crInf, ok := crMgr.getNamespaceInformer(namespace)
 	if !ok {
 		log.Errorf("Informer not found for namespace: %v", namespace)
 		return
 	}
+	for _, rsCfg := range crMgr.resources.GetAllResources() {
+		updated := false
+		for index, pool := range rsCfg.Pools {
+			if pool.ServiceNamespace != namespace {
+				continue
+			}
+			if pool.ServiceName != svc.ObjectMeta.Name && (nil == pool.Backup ||
+				pool.Backup.Service != svc.ObjectMeta.Name) {
+				continue
+			}
+			crMgr.updatePoolMembersOfPool(rsCfg, index, namespace, crInf)
+			updated = true
+		}
+		if updated {
+			// Pool members are in the same route domain as the virtual.
+			rsCfg.updatePoolMembersRouteDomain()
+			crMgr.updateActive(rsCfg)
+		}
+	}
+}
+
+// mergeMembers appends the members which are not in the members yet.
+func mergeMembers(members []Member, newMembers []Member) []Member {
+	found := make(map[string]bool)
+	for _, member := range members {
+		found[fmt.Sprintf("%s:%d", member.Address, member.Port)] = true
+	}
+	for _, member := range newMembers {
+		key := fmt.Sprintf("%s:%d", member.Address, member.Port)
+		if !found[key] {
+			found[key] = true
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// updatePoolBackupMembers sets the priority group of the members of the
+// pool and adds its backup members with priority group 0.
+func (crMgr *CRManager) updatePoolBackupMembers(
+	rsCfg *ResourceConfig,
+	index int,
+	namespace string,
+	crInf *CRInformer,
+) {
+	pool := &rsCfg.Pools[index]
+	for i := range pool.Members {
+		pool.Members[i].PriorityGroup = pool.PriorityGroup
+	}
+	if nil == pool.Backup {
+		return
+	}
+
+	var backup []Member
+	if pool.Backup.Service != "" {
+		backupCfg := &ResourceConfig{}
+		backupCfg.Pools = Pools{{
+			ServiceName:     pool.Backup.Service,
+			ServicePort:     pool.Backup.ServicePort,
+			NodeMemberLabel: pool.NodeMemberLabel,
+			MemberType:      pool.MemberType,
+		}}
+		if crMgr.getPoolMemberType(pool.MemberType) == NodePortMode {
+			crMgr.updatePoolMembersForNodePort(backupCfg, 0, namespace, crInf)
+		} else {
+			crMgr.updatePoolMembersForCluster(backupCfg, 0, namespace, crInf)
+		}
+		backup = backupCfg.Pools[0].Members
+	} else {
+		for _, addr := range pool.Backup.Addresses {
+			backup = append(backup, Member{
+				Address: addr,
+				Port:    pool.Backup.Port,
+				Session: "user-enabled",
+				Static:  true,
+			})
+		}
+	}
+
+	for i := range backup {
+		backup[i].PriorityGroup = 0
+	}
+	pool.Members = mergeMembers(pool.Members, backup)
+}
 
-	for index, pool := range rsCfg.Pools {
-		svcName := pool.ServiceName
-		svcKey := namespace + "/" + svcName
+// updatePoolMembersForNodePort updates the pool with pool members for a
+// service created in nodeport mode.
+func (crMgr *CRManager) updatePoolMembersForNodePort(
+	rsCfg *ResourceConfig,
+	index int,
+	namespace string,
+	crInf *CRInformer,
+) {
+	pool := rsCfg.Pools[index]
+	svcName := pool.ServiceName
+	svcKey := namespace + "/" + svcName
+
+	// TODO: Too Many API calls?
+	service, exist, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
+	if !exist {
+		log.Debugf("Service not found %s", svcKey)
+		// Update the pool with empty members
+		var member []Member
+		rsCfg.Pools[index].Members = member
+		return
+	}
+	svc := service.(*v1.Service)
+	// Traverse for all the pools in the Resource Config
+	if svc.Spec.Type == v1.ServiceTypeNodePort ||
+		svc.Spec.Type == v1.ServiceTypeLoadBalancer {
+		// The members of all the ports of the service are merged, the
+		// named port not found leaves the pool without members
+		var members []Member
+		for _, portSpec := range getServicePorts(svc, pool) {
+			members = mergeMembers(members, crMgr.getEndpointsForNodePort(
+				portSpec.NodePort, pool.NodeMemberLabel))
+		}
+		rsCfg.Pools[index].Members = members
+	} else {
+		log.Debugf("Requested service backend %s not of NodePort or LoadBalancer type",
+			svcName)
+		// The members of another member type are not kept
+		rsCfg.Pools[index].Members = nil
+	}
+}
 
+// updatePoolMembersForCluster updates the pool with pool members for a
+// service created in cluster mode.
+func (crMgr *CRManager) updatePoolMembersForCluster(
+	rsCfg *ResourceConfig,
+	index int,
+	namespace string,
+	crInf *CRInformer,
+) {
+	pool := rsCfg.Pools[index]
+	svcName := pool.ServiceName
+	svcKey := namespace + "/" + svcName
+
+	var eps *v1.Endpoints
+	var slices []interface{}
+	if crInf.sliceInformer != nil {
+		slices, _ = crInf.sliceInformer.GetIndexer().ByIndex(
+			endpointSliceServiceIndex, svcKey)
+		if len(slices) == 0 {
+			log.Debugf("EndpointSlices for service '%v' not found!", svcKey)
+			return
+		}
+	} else {
 		// TODO: Too Many API calls?
 		item, found, _ := crInf.epsInformer.GetIndexer().GetByKey(svcKey)
 		if !found {
 			log.Debugf("Endpoints for service '%v' not found!", svcKey)
+			return
+		}
+		eps, _ = item.(*v1.Endpoints)
+	}
+	// TODO: Too Many API calls?
+	// Get Service
+	service, exist, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
+	if !exist {
+		log.Debugf("Service not found %s", svcKey)
+		// Update the pool with empty members
+		var member []Member
+		rsCfg.Pools[index].Members = member
+		return
+	}
+	svc := service.(*v1.Service)
+
+	// The endpoints carry the target port of each pod, the named target
+	// ports may resolve to different container ports. The members of all
+	// the ports of the service are merged, the named port not found leaves
+	// the pool without members.
+	var members []Member
+	for _, portSpec := range getServicePorts(svc, pool) {
+		var ipPorts []Member
+		if crInf.sliceInformer != nil {
+			ipPorts = crMgr.getEndpointSliceMembers(portSpec.Name, slices,
+				pool.IncludeNotReady)
+		} else {
+			ipPorts = crMgr.getEndpointsForCluster(portSpec.Name, eps,
+				pool.IncludeNotReady)
+		}
+		ipPorts = crMgr.addDrainingMembers(ipPorts, svc, portSpec)
+		log.Debugf("Found endpoints for backend %+v: %v", svcKey, ipPorts)
+		members = mergeMembers(members, ipPorts)
+	}
+	rsCfg.Pools[index].Members = members
+}
+
+// getServicePorts returns the port of the service used by the pool, or all
+// the ports of the service if none is the port of the pool. A named port
+// of the pool is either found or none is returned.
+func getServicePorts(svc *v1.Service, pool Pool) []v1.ServicePort {
+	for _, portSpec := range svc.Spec.Ports {
+		if pool.ServicePortName != "" {
+			if portSpec.Name == pool.ServicePortName {
+				return []v1.ServicePort{portSpec}
+			}
+		} else if portSpec.Port == pool.ServicePort {
+			return []v1.ServicePort{portSpec}
+		}
+	}
+	if pool.ServicePortName != "" {
+		return nil
+	}
+	return svc.Spec.Ports
+}
+
+// checkServicePortNames records a Warning Event for the named service
+// ports of the pools which the services do not have. The VirtualServer is
+// processed again when the services are updated.
+func (crMgr *CRManager) checkServicePortNames(virtual *cisapiv1.VirtualServer) {
+	crInf, ok := crMgr.getNamespaceInformer(virtual.ObjectMeta.Namespace)
+	if !ok {
+		return
+	}
+	for _, pl := range getVirtualServerPools(virtual) {
+		if pl.ServicePort.Type != intstr.String || pl.Service == "" {
 			continue
 		}
-		eps, _ := item.(*v1.Endpoints)
-		// TODO: Too Many API calls?
-		// Get Service
-		service, exist, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
-		if !exist {
-			log.Debugf("Service not found %s", svcKey)
-			// Update the pool with empty members
-			var member []Member
-			rsCfg.Pools[index].Members = member
+		svcKey := virtual.ObjectMeta.Namespace + "/" + pl.Service
+		obj, found, _ := crInf.svcInformer.GetIndexer().GetByKey(svcKey)
+		if !found {
 			continue
 		}
-		svc := service.(*v1.Service)
-
-		// TODO: Instead of looping over Spec Ports, get the port from the pool itself
-		for _, portSpec := range svc.Spec.Ports {
-			ipPorts := crMgr.getEndpointsForCluster(portSpec.Name, eps)
-			log.Debugf("Found endpoints for backend %+v: %v", svcKey, ipPorts)
-			rsCfg.MetaData.Active = true
-			rsCfg.Pools[index].Members = ipPorts
+		svc := obj.(*v1.Service)
+		if len(getServicePorts(svc, Pool{ServicePortName: pl.ServicePort.StrVal})) > 0 {
+			continue
 		}
+		msg := fmt.Sprintf("Port '%s' of service %s not found, the pool of "+
+			"path '%s' has no members", pl.ServicePort.StrVal, pl.Service,
+			pl.Path)
+		log.Warningf("VirtualServer %s/%s: %s", virtual.ObjectMeta.Namespace,
+			virtual.ObjectMeta.Name, msg)
+		crMgr.recordEvent(virtual, virtual.ObjectMeta.Namespace,
+			v1.EventTypeWarning, "ServicePortNotFound", msg)
 	}
 }
 
-// getEndpointsForNodePort returns members.
+// getEndpointsForNodePort returns members, the unhealthy nodes are not
+// members with FilterUnhealthy.
 func (crMgr *CRManager) getEndpointsForNodePort(
 	nodePort int32,
 	nodeMemberLabel string,
@@ -521,6 +1681,9 @@ func (crMgr *CRManager) getEndpointsForNodePort(
 	}
 	var members []Member
 	for _, v := range nodes {
+		if v.Unhealthy {
+			continue
+		}
 		member := Member{
 			Address: v.Addr,
 			Port:    nodePort,
@@ -532,10 +1695,12 @@ func (crMgr *CRManager) getEndpointsForNodePort(
 	return members
 }
 
-// getEndpointsForCluster returns members.
+// getEndpointsForCluster returns members, the addresses which are not ready
+// only with includeNotReady.
 func (crMgr *CRManager) getEndpointsForCluster(
 	portName string,
 	eps *v1.Endpoints,
+	includeNotReady bool,
 ) []Member {
 	nodes := crMgr.getNodesFromCache()
 	var members []Member
@@ -545,12 +1710,16 @@ func (crMgr *CRManager) getEndpointsForCluster(
 	}
 
 	for _, subset := range eps.Subsets {
+		addrs := subset.Addresses
+		if includeNotReady {
+			addrs = append(addrs, subset.NotReadyAddresses...)
+		}
 		for _, p := range subset.Ports {
 			if portName == p.Name {
-				for _, addr := range subset.Addresses {
-					if containsNode(nodes, *addr.NodeName) {
+				for _, addr := range addrs {
+					if addr.NodeName != nil && containsNode(nodes, *addr.NodeName) {
 						member := Member{
-							Address: addr.IP,
+							Address: normalizeAddress(addr.IP),
 							Port:    p.Port,
 							Session: "user-enabled",
 						}
@@ -563,6 +1732,167 @@ func (crMgr *CRManager) getEndpointsForCluster(
 	return members
 }
 
+// getEndpointSliceMembers returns the members of the EndpointSlices of a
+// service, sorted by address and port. The endpoints which are not ready
+// are left out unless includeNotReady.
+func (crMgr *CRManager) getEndpointSliceMembers(
+	portName string,
+	slices []interface{},
+	includeNotReady bool,
+) []Member {
+	nodes := crMgr.getNodesFromCache()
+	var members []Member
+	found := make(map[string]bool)
+
+	for _, obj := range slices {
+		slice, ok := obj.(*discoveryv1alpha1.EndpointSlice)
+		if !ok {
+			continue
+		}
+		for _, p := range slice.Ports {
+			name := ""
+			if p.Name != nil {
+				name = *p.Name
+			}
+			if portName != name || p.Port == nil {
+				continue
+			}
+			for _, ep := range slice.Endpoints {
+				if !includeNotReady && ep.Conditions.Ready != nil &&
+					!*ep.Conditions.Ready {
+					continue
+				}
+				if nodeName, ok := ep.Topology[v1.LabelHostname]; ok &&
+					!containsNode(nodes, nodeName) {
+					continue
+				}
+				for _, addr := range ep.Addresses {
+					addr = normalizeAddress(addr)
+					key := fmt.Sprintf("%s:%d", addr, *p.Port)
+					if found[key] {
+						continue
+					}
+					found[key] = true
+					members = append(members, Member{
+						Address: addr,
+						Port:    *p.Port,
+						Session: "user-enabled",
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Address != members[j].Address {
+			return members[i].Address < members[j].Address
+		}
+		return members[i].Port < members[j].Port
+	})
+	return members
+}
+
+// addDrainingMembers adds the terminating pods of the service to the
+// members with disable adminState, or disables their members, so that
+// BIG-IP drains their connections until the pods are deleted.
+func (crMgr *CRManager) addDrainingMembers(
+	members []Member,
+	svc *v1.Service,
+	portSpec v1.ServicePort,
+) []Member {
+	nodes := crMgr.getNodesFromCache()
+	var draining []Member
+	for _, pod := range crMgr.getTerminatingPods(svc) {
+		if !containsNode(nodes, pod.Spec.NodeName) {
+			continue
+		}
+		port := getPodTargetPort(pod, portSpec)
+		if port == 0 {
+			continue
+		}
+		found := false
+		for i := range members {
+			if members[i].Address == pod.Status.PodIP && members[i].Port == port {
+				members[i].Session = "user-disabled"
+				members[i].AdminState = "disable"
+				found = true
+			}
+		}
+		if !found {
+			draining = append(draining, Member{
+				Address:    pod.Status.PodIP,
+				Port:       port,
+				Session:    "user-disabled",
+				AdminState: "disable",
+			})
+		}
+	}
+	sort.Slice(draining, func(i, j int) bool {
+		return draining[i].Address < draining[j].Address
+	})
+	return append(members, draining...)
+}
+
+// getTerminatingPods returns the pods of the service which are terminating.
+func (crMgr *CRManager) getTerminatingPods(svc *v1.Service) []*v1.Pod {
+	if len(svc.Spec.Selector) == 0 {
+		return nil
+	}
+	crInf, ok := crMgr.getNamespaceInformer(svc.ObjectMeta.Namespace)
+	if !ok || crInf.podInformer == nil {
+		return nil
+	}
+	objs, _ := crInf.podInformer.GetIndexer().ByIndex(cache.NamespaceIndex,
+		svc.ObjectMeta.Namespace)
+	selector := labels.SelectorFromSet(svc.Spec.Selector)
+	var pods []*v1.Pod
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if pod.ObjectMeta.DeletionTimestamp != nil && pod.Status.PodIP != "" &&
+			selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// getPodServices returns the services selecting the pod.
+func (crMgr *CRManager) getPodServices(pod *v1.Pod) []*v1.Service {
+	crInf, ok := crMgr.getNamespaceInformer(pod.ObjectMeta.Namespace)
+	if !ok {
+		return nil
+	}
+	objs, _ := crInf.svcInformer.GetIndexer().ByIndex(cache.NamespaceIndex,
+		pod.ObjectMeta.Namespace)
+	var svcs []*v1.Service
+	for _, obj := range objs {
+		svc := obj.(*v1.Service)
+		if len(svc.Spec.Selector) > 0 && labels.SelectorFromSet(
+			svc.Spec.Selector).Matches(labels.Set(pod.ObjectMeta.Labels)) {
+			svcs = append(svcs, svc)
+		}
+	}
+	return svcs
+}
+
+// getPodTargetPort returns the port of the pod the service port forwards
+// to, 0 if the pod has no such named port.
+func getPodTargetPort(pod *v1.Pod, portSpec v1.ServicePort) int32 {
+	if portSpec.TargetPort.Type == intstr.Int {
+		if portSpec.TargetPort.IntVal != 0 {
+			return portSpec.TargetPort.IntVal
+		}
+		return portSpec.Port
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			if p.Name == portSpec.TargetPort.StrVal {
+				return p.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
 // containsNode returns true for a valid node.
 func containsNode(nodes []Node, name string) bool {
 	for _, node := range nodes {
@@ -572,3 +1902,23 @@ func containsNode(nodes []Node, name string) bool {
 	}
 	return false
 }
+
+// containsString returns true if the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// containsNameRef returns true if the reference is in the list
+func containsNameRef(list []NameRef, ref NameRef) bool {
+	for _, item := range list {
+		if item == ref {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,5152 @@
+/*-
+ * Copyright (c) 2016-2019, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package crmanager
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
+	bigIPPrometheus "github.com/F5Networks/k8s-bigip-ctlr/pkg/prometheus"
+	rsc "github.com/F5Networks/k8s-bigip-ctlr/pkg/resource"
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/test"
+	log "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+	clog "github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger/console"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1alpha1 "k8s.io/api/discovery/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+var _ = Describe("Worker Tests", func() {
+	var mockCRM *mockCRManager
+	var vs *cisapiv1.VirtualServer
+	var tls *cisapiv1.TLSProfile
+	var addServices func(namespace string, names ...string)
+
+	BeforeEach(func() {
+		mockCRM = newMockCRManager()
+		addServices = func(namespace string, names ...string) {
+			for _, name := range names {
+				mockCRM.addService(test.NewService(name, "1", namespace,
+					v1.ServiceTypeClusterIP, nil))
+			}
+		}
+		vs = test.NewVirtualServer(
+			"SampleVS",
+			"default",
+			cisapiv1.VirtualServerSpec{
+				Host:           "test.com",
+				TLSProfileName: "SampleTLS",
+			},
+		)
+		tls = test.NewTLSProfile(
+			"SampleTLS",
+			"default",
+			cisapiv1.TLSProfileSpec{
+				Hosts: []string{"test.com"},
+				TLS: cisapiv1.TLS{
+					Termination: "edge",
+					ClientSSL:   "clientssl",
+					Reference:   BIGIP,
+				},
+			},
+		)
+	})
+
+	Context("TLSProfile", func() {
+		It("Caches TLSProfile", func() {
+			Expect(mockCRM.updateTLSContext(tls)).To(BeTrue())
+			Expect(mockCRM.updateTLSContext(tls)).To(BeFalse(),
+				"Unchanged TLSProfile should not be updated")
+
+			newTLS := tls.DeepCopy()
+			newTLS.Spec.TLS.ClientSSL = "newclientssl"
+			Expect(mockCRM.updateTLSContext(newTLS)).To(BeTrue())
+			Expect(mockCRM.TLSContext["default/SampleTLS"]).To(Equal(newTLS))
+		})
+
+		It("Gets TLSProfile from informer store only when not cached", func() {
+			mockCRM.addTLSProfile(tls)
+			crInf, _ := mockCRM.getNamespaceInformer("default")
+
+			cachedTLS, found := mockCRM.getTLSProfile(crInf, "default/SampleTLS")
+			Expect(found).To(BeTrue())
+			Expect(cachedTLS).To(Equal(tls))
+			Expect(mockCRM.TLSContext).To(HaveKey("default/SampleTLS"))
+
+			_ = crInf.tsInformer.GetIndexer().Delete(tls)
+			cachedTLS, found = mockCRM.getTLSProfile(crInf, "default/SampleTLS")
+			Expect(found).To(BeTrue(), "TLSProfile should be served from cache")
+			Expect(cachedTLS).To(Equal(tls))
+
+			_, found = mockCRM.getTLSProfile(crInf, "default/UnknownTLS")
+			Expect(found).To(BeFalse())
+		})
+
+		It("Enqueues VirtualServers referring TLSProfile", func() {
+			otherVS := test.NewVirtualServer(
+				"OtherVS",
+				"default",
+				cisapiv1.VirtualServerSpec{Host: "other.com"},
+			)
+			mockCRM.addVirtualServer(vs)
+			mockCRM.addVirtualServer(otherVS)
+
+			mockCRM.enqueueVirtualServersForTLSProfile(tls)
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].kind).To(Equal(VirtualServer))
+			Expect(keys[0].rscName).To(Equal("SampleVS"))
+		})
+
+		It("Deletes TLSProfile and its custom profiles", func() {
+			tls.Spec.TLS.Reference = Secret
+			mockCRM.addVirtualServer(vs)
+			mockCRM.updateTLSContext(tls)
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT)
+			secretKey := SecretKey{
+				Name:         "clientssl",
+				ResourceName: rsName,
+				Partition:    "test",
+			}
+			mockCRM.customProfiles.Profs[secretKey] = CustomProfile{}
+
+			mockCRM.deleteTLSProfile(tls)
+			Expect(mockCRM.TLSContext).NotTo(HaveKey("default/SampleTLS"))
+			Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].rscName).To(Equal("SampleVS"))
+		})
+
+		Context("Errors", func() {
+			BeforeEach(func() {
+				vs.Spec.VirtualServerAddress = "1.2.3.4"
+				vs.Spec.Pools = []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				}
+				addServices("default", "svc1")
+				mockCRM.addVirtualServer(vs)
+			})
+
+			getReasons := func() []string {
+				var reasons []string
+				for _, ev := range mockCRM.getFakeEvents("default") {
+					reasons = append(reasons, ev.Name+"/"+ev.Reason)
+				}
+				return reasons
+			}
+
+			It("Reports missing TLSProfile", func() {
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(getReasons()).To(Equal(
+					[]string{"SampleVS/TLSProfileNotFound"}))
+			})
+
+			It("Reports missing and invalid secrets", func() {
+				tls.Spec.TLS.Reference = Secret
+				mockCRM.addTLSProfile(tls)
+				Expect(isRetryable(mockCRM.syncVirtualServer(vs))).To(BeTrue(),
+					"The sync should be retried until the secret is created")
+				Expect(getReasons()).To(Equal([]string{
+					"SampleVS/SecretNotFound", "SampleTLS/SecretNotFound"}))
+
+				secret := test.NewSecret("clientssl", "default",
+					testCert("cert"), "")
+				delete(secret.Data, "tls.key")
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").
+					Create(secret)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(getReasons()[2:]).To(Equal([]string{
+					"SampleVS/InvalidSecret", "SampleTLS/InvalidSecret"}))
+			})
+
+			It("Reports malformed profile names", func() {
+				tls.Spec.TLS.ClientSSL = "/Common/ssl/clientssl"
+				mockCRM.addTLSProfile(tls)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(getReasons()).To(Equal([]string{
+					"SampleVS/InvalidProfile", "SampleTLS/InvalidProfile"}))
+			})
+
+			It("Reports the same error once", func() {
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(len(mockCRM.getFakeEvents("default"))).To(Equal(1))
+			})
+		})
+
+		Context("Secret", func() {
+			var secret *v1.Secret
+			var rsName string
+			var secretKey SecretKey
+
+			BeforeEach(func() {
+				vs.Spec.VirtualServerAddress = "1.2.3.4"
+				vs.Spec.Pools = []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				}
+				addServices("default", "svc1")
+				mockCRM.addVirtualServer(vs)
+				tls.Spec.TLS.Reference = Secret
+				mockCRM.addTLSProfile(tls)
+				secret = test.NewSecret("clientssl", "default", testCert("cert"),
+					testKey("cert"))
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").
+					Create(secret)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT)
+				secretKey = SecretKey{Name: "clientssl", ResourceName: rsName,
+					Partition: "test"}
+				Expect(mockCRM.customProfiles.Profs[secretKey].Cert).To(
+					Equal(testCert("cert")))
+			})
+
+			secretDep := func(name string) ObjectDependency {
+				return ObjectDependency{Kind: TLSSecret,
+					Namespace: "default", Name: name}
+			}
+
+			It("Updates profiles and VirtualServers with the secret", func() {
+				newSecret := test.NewSecret("clientssl", "default",
+					testCert("newcert"), testKey("newcert"))
+				Expect(mockCRM.resources.isDependencyInUse(
+					secretDep("clientssl"))).To(BeTrue())
+				mockCRM.updateSecretSslProfiles(newSecret)
+				mockCRM.enqueueVirtualServersForDependency(
+					secretDep("clientssl"))
+				Expect(mockCRM.SSLContext["clientssl"]).To(Equal(newSecret))
+				Expect(mockCRM.customProfiles.Profs[secretKey].Cert).To(
+					Equal(testCert("newcert")))
+				keys := mockCRM.drainQueue()
+				Expect(len(keys)).To(Equal(1))
+				Expect(keys[0].kind).To(Equal(VirtualServer))
+				Expect(keys[0].rscName).To(Equal("SampleVS"))
+			})
+
+			It("Installs the intermediate certificates as the chain", func() {
+				chain := testCert("intermediate1") + testCert("intermediate2")
+				newSecret := test.NewSecret("clientssl", "default",
+					testCert("newcert")+"\n"+chain, testKey("newcert"))
+				mockCRM.updateSecretSslProfiles(newSecret)
+				prof := mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.Cert).To(Equal(testCert("newcert")))
+				Expect(prof.ChainCert).To(Equal(chain))
+				sharedApp := as3Application{}
+				createCertificateDecl(prof, sharedApp)
+				Expect(sharedApp[prof.Name].(*as3Certificate).ChainCA).To(
+					Equal(chain))
+
+				// Or the certificates of ca.crt
+				newSecret.Data["tls.crt"] = []byte(testCert("newcert"))
+				newSecret.Data["ca.crt"] = []byte(testCert("ca"))
+				mockCRM.updateSecretSslProfiles(newSecret)
+				prof = mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.ChainCert).To(Equal(testCert("ca")))
+
+				// The malformed certificates are reported on the secret
+				newSecret.Data["ca.crt"] = []byte("ca")
+				mockCRM.updateSecretSslProfiles(newSecret)
+				Expect(mockCRM.customProfiles.Profs[secretKey]).To(Equal(prof))
+				newSecret.Data["tls.crt"] = []byte(testCert("newcert") +
+					"-----BEGIN CERTIFICATE-----\nbad")
+				mockCRM.updateSecretSslProfiles(newSecret)
+				events := mockCRM.getFakeEvents("default")
+				Expect(events).To(HaveLen(2))
+				Expect(events[0].Reason).To(Equal("InvalidSecret"))
+				Expect(events[0].Message).To(ContainSubstring(
+					"Invalid Secret 'clientssl': 'ca.crt' has no PEM certificate"))
+				Expect(events[1].Message).To(ContainSubstring(
+					"Invalid Secret 'clientssl': 'tls.crt' has malformed PEM " +
+						"data after certificate 1"))
+			})
+
+			It("Rejects the certificates not matching the key, expired or "+
+				"of other hosts", func() {
+				prof := mockCRM.customProfiles.Profs[secretKey]
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", testCert("newcert"), testKey("cert")))
+				cert, key := newTestKeyPair(time.Now().AddDate(0, 0, -1),
+					"test.com")
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", cert, key))
+				cert, key = newTestKeyPair(time.Now().AddDate(1, 0, 0),
+					"other.com")
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", cert, key))
+				Expect(mockCRM.customProfiles.Profs[secretKey]).To(Equal(prof))
+
+				events := mockCRM.getFakeEvents("default")
+				Expect(events).To(HaveLen(3))
+				Expect(events[0].Message).To(Equal("Invalid Secret " +
+					"'clientssl': tls: private key does not match public key"))
+				Expect(events[1].Message).To(ContainSubstring("Invalid Secret " +
+					"'clientssl': certificate expired on"))
+				Expect(events[2].Message).To(Equal("Invalid Secret " +
+					"'clientssl': certificate does not cover host test.com"))
+			})
+
+			It("Checks the certificate covers all the hosts", func() {
+				tls.Spec.Hosts = nil
+				mockCRM.addTLSProfile(tls)
+				newVS := vs.DeepCopy()
+				newVS.Spec.Host = ""
+				newVS.Spec.Hosts = []string{"test.com", "www.test.com"}
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+				Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+
+				cert, key := newTestKeyPair(time.Now().AddDate(1, 0, 0),
+					"test.com")
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", cert, key))
+				events := mockCRM.getFakeEvents("default")
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Message).To(Equal("Invalid Secret " +
+					"'clientssl': certificate does not cover host www.test.com"))
+			})
+
+			It("Warns of the certificates expiring soon", func() {
+				mockCRM.CertExpiryWarnDays = 30
+				notAfter := time.Now().AddDate(0, 0, 10)
+				cert, key := newTestKeyPair(notAfter, "test.com")
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", cert, key))
+				Expect(mockCRM.customProfiles.Profs[secretKey].Cert).To(
+					Equal(cert))
+				events := mockCRM.getFakeEvents("default")
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Name).To(Equal("clientssl"))
+				Expect(events[0].Reason).To(Equal("CertificateExpiring"))
+				var d dto.Metric
+				Expect(bigIPPrometheus.CertificateExpiry.WithLabelValues(
+					"default", "clientssl").Write(&d)).To(Succeed())
+				Expect(d.GetGauge().GetValue()).To(Equal(
+					float64(notAfter.Unix())))
+
+				// The renewed certificate is no longer reported
+				mockCRM.updateSecretSslProfiles(test.NewSecret("clientssl",
+					"default", testCert("cert"), testKey("cert")))
+				Expect(mockCRM.getFakeEvents("default")).To(HaveLen(1))
+				Expect(bigIPPrometheus.CertificateExpiry.DeleteLabelValues(
+					"default", "clientssl")).To(BeFalse())
+			})
+
+			It("Tracks the secret of the TLSProfile", func() {
+				Expect(mockCRM.resources.isDependencyInUse(
+					secretDep("other"))).To(BeFalse())
+
+				newVS := vs.DeepCopy()
+				newVS.Spec.TLSProfileName = ""
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+				Expect(mockCRM.resources.isDependencyInUse(
+					secretDep("clientssl"))).To(BeFalse())
+			})
+
+			It("Removes profiles of deleted secret", func() {
+				_ = mockCRM.kubeClient.CoreV1().Secrets("default").
+					Delete("clientssl", nil)
+				mockCRM.deleteSecretSslProfiles(secret)
+				mockCRM.enqueueVirtualServersForDependency(
+					secretDep("clientssl"))
+				Expect(mockCRM.SSLContext).NotTo(HaveKey("clientssl"))
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				for _, prof := range rsCfg.Virtual.Profiles {
+					Expect(prof.Name).NotTo(Equal("clientssl"))
+				}
+
+				keys := mockCRM.drainQueue()
+				Expect(len(keys)).To(Equal(1))
+				Expect(isRetryable(mockCRM.syncVirtualServer(vs))).To(BeTrue())
+				rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+				for _, prof := range rsCfg.Virtual.Profiles {
+					Expect(prof.Name).NotTo(Equal("clientssl"))
+				}
+				status := mockCRM.vsStatusMap["default/SampleVS"]
+				Expect(status.Status).To(Equal(StatusDegraded))
+			})
+
+			It("Deletes profiles no longer used by the VirtualServer", func() {
+				sniKey := SecretKey{
+					Name:         namer.DefaultSNIProfileName(rsName),
+					ResourceName: rsName,
+					Partition:    "test",
+				}
+				Expect(mockCRM.customProfiles.Profs).To(HaveKey(sniKey))
+
+				newVS := vs.DeepCopy()
+				newVS.Spec.TLSProfileName = ""
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(sniKey))
+				_, found := mockCRM.resources.GetByName("test", rsName)
+				Expect(found).To(BeFalse(),
+					"The HTTPS virtual should be deleted with its profiles")
+			})
+
+			It("Keeps profiles shared with another VirtualServer", func() {
+				otherVS := vs.DeepCopy()
+				otherVS.ObjectMeta.Name = "OtherVS"
+				otherVS.Spec.Pools = []cisapiv1.Pool{
+					{Path: "/bar", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				}
+				mockCRM.addVirtualServer(otherVS)
+				Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+				Expect(mockCRM.getVirtualServerName(otherVS,
+					DEFAULT_HTTPS_PORT)).To(Equal(rsName))
+
+				mockCRM.deleteVirtualServerConfig(vs)
+				Expect(mockCRM.customProfiles.Profs).To(HaveKey(secretKey))
+
+				mockCRM.deleteVirtualServerConfig(otherVS)
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+			})
+
+			It("Selects the certificates of the hosts with SNI", func() {
+				otherTLS := test.NewTLSProfile(
+					"OtherTLS",
+					"default",
+					cisapiv1.TLSProfileSpec{
+						Hosts: []string{"other.test.com"},
+						TLS: cisapiv1.TLS{
+							Termination: TLSEdge,
+							ClientSSL:   "otherssl",
+							Reference:   Secret,
+						},
+					},
+				)
+				mockCRM.addTLSProfile(otherTLS)
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+					test.NewSecret("otherssl", "default",
+						testCert("othercert"), testKey("othercert")))
+				newVS := vs.DeepCopy()
+				newVS.Spec.Host = "*.test.com"
+				newVS.Spec.TLSProfileNames = []string{"OtherTLS"}
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+				Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+				otherKey := SecretKey{Name: "otherssl", ResourceName: rsName,
+					Partition: "test"}
+				prof := mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.ServerName).To(Equal("test.com"))
+				Expect(prof.SNIDefault).To(BeTrue())
+				prof = mockCRM.customProfiles.Profs[otherKey]
+				Expect(prof.ServerName).To(Equal("other.test.com"))
+				Expect(prof.SNIDefault).To(BeFalse())
+				Expect(mockCRM.resources.isDependencyInUse(
+					secretDep("otherssl"))).To(BeTrue())
+				Expect(mockCRM.getVirtualServersForTLSProfile(otherTLS)).To(
+					Equal([]*cisapiv1.VirtualServer{newVS}))
+
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				var names []string
+				for _, prof := range rsCfg.Virtual.Profiles {
+					names = append(names, prof.Name)
+				}
+				Expect(names).To(ContainElement("clientssl"))
+				Expect(names).To(ContainElement("otherssl"))
+				sharedApp := as3Application{}
+				createServiceDecl(rsCfg, sharedApp)
+				processCustomProfilesForAS3(mockCRM.customProfiles, sharedApp)
+				tlsServer := sharedApp[rsName+"_tls_server"].(*as3TLSServer)
+				Expect(tlsServer.Certificates).To(Equal(
+					[]as3TLSServerCertificates{
+						{Certificate: "clientssl"},
+						{Certificate: "otherssl", MatchToSNI: "other.test.com"},
+					}))
+
+				// The certificate of the removed TLSProfile is deleted
+				newVS = newVS.DeepCopy()
+				newVS.Spec.TLSProfileNames = nil
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(otherKey))
+				prof = mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.SNIDefault).To(BeTrue(),
+					"The only certificate should be the default")
+				rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+				for _, prof := range rsCfg.Virtual.Profiles {
+					Expect(prof.Name).NotTo(Equal("otherssl"))
+				}
+			})
+
+			Context("SNI default", func() {
+				var otherVS *cisapiv1.VirtualServer
+				var otherTLS *cisapiv1.TLSProfile
+				var otherKey SecretKey
+
+				BeforeEach(func() {
+					otherTLS = tls.DeepCopy()
+					otherTLS.ObjectMeta.Name = "OtherTLS"
+					otherTLS.Spec.Hosts = []string{"other.com"}
+					otherTLS.Spec.TLS.ClientSSL = "otherssl"
+					mockCRM.addTLSProfile(otherTLS)
+					_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").
+						Create(test.NewSecret("otherssl", "default",
+							testCert("othercert"), testKey("othercert")))
+					otherVS = vs.DeepCopy()
+					otherVS.ObjectMeta.Name = "OtherVS"
+					otherVS.Spec.Host = "other.com"
+					otherVS.Spec.TLSProfileName = "OtherTLS"
+					otherKey = SecretKey{Name: "otherssl", ResourceName: rsName,
+						Partition: "test"}
+				})
+
+				getSNIDefaults := func() []string {
+					var names []string
+					for key, prof := range mockCRM.customProfiles.Profs {
+						if key.ResourceName == rsName && prof.SNIDefault &&
+							prof.Cert != "" {
+							names = append(names, key.Name)
+						}
+					}
+					return names
+				}
+
+				It("Makes the first certificate by name the default", func() {
+					mockCRM.addVirtualServer(otherVS)
+					Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+					Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+					Expect(getSNIDefaults()).To(Equal([]string{"clientssl"}))
+				})
+
+				It("Promotes another certificate when the default is deleted", func() {
+					otherTLS.Spec.TLS.SNIDefault = true
+					mockCRM.updateTLSContext(otherTLS)
+					mockCRM.addVirtualServer(otherVS)
+					Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+					Expect(getSNIDefaults()).To(Equal([]string{"otherssl"}))
+
+					mockCRM.deleteVirtualServerConfig(otherVS)
+					Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(otherKey))
+					Expect(getSNIDefaults()).To(Equal([]string{"clientssl"}))
+				})
+
+				It("Reports conflicting defaults", func() {
+					tls.Spec.TLS.SNIDefault = true
+					mockCRM.updateTLSContext(tls)
+					otherTLS.Spec.TLS.SNIDefault = true
+					mockCRM.updateTLSContext(otherTLS)
+					mockCRM.addVirtualServer(otherVS)
+					Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+					Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+					Expect(getSNIDefaults()).To(Equal([]string{"clientssl"}))
+					events := mockCRM.getFakeEvents("default")
+					Expect(len(events)).To(Equal(2))
+					Expect(events[0].Reason).To(Equal("SNIDefaultConflict"))
+
+					sharedApp := as3Application{}
+					rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+					createServiceDecl(rsCfg, sharedApp)
+					processCustomProfilesForAS3(mockCRM.customProfiles,
+						sharedApp)
+					tlsServer := sharedApp[rsName+"_tls_server"].(*as3TLSServer)
+					Expect(tlsServer.Certificates).To(Equal(
+						[]as3TLSServerCertificates{
+							{Certificate: "clientssl"},
+							{Certificate: "otherssl", MatchToSNI: "other.com"},
+						}))
+				})
+			})
+
+			It("Keeps the SNI profile for the certificates of other secrets", func() {
+				otherTLS := tls.DeepCopy()
+				otherTLS.ObjectMeta.Name = "OtherTLS"
+				otherTLS.Spec.TLS.ClientSSL = "otherssl"
+				mockCRM.addTLSProfile(otherTLS)
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+					test.NewSecret("otherssl", "default",
+						testCert("othercert"), testKey("othercert")))
+				vs.Spec.TLSProfileNames = []string{"OtherTLS"}
+				mockCRM.addVirtualServer(vs)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+
+				mockCRM.deleteSecretSslProfiles(secret)
+				sniKey := SecretKey{
+					Name:         namer.DefaultSNIProfileName(rsName),
+					ResourceName: rsName,
+					Partition:    "test",
+				}
+				Expect(mockCRM.customProfiles.Profs).To(HaveKey(sniKey))
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				var names []string
+				for _, prof := range rsCfg.Virtual.Profiles {
+					names = append(names, prof.Name)
+				}
+				Expect(names).To(ContainElement(sniKey.Name))
+				Expect(names).To(ContainElement("otherssl"))
+				Expect(names).NotTo(ContainElement("clientssl"))
+			})
+		})
+
+		Context("Client Authentication", func() {
+			var rsName string
+			var secretKey SecretKey
+
+			BeforeEach(func() {
+				vs.Spec.VirtualServerAddress = "1.2.3.4"
+				vs.Spec.Pools = []cisapiv1.Pool{
+					{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				}
+				addServices("default", "svc1")
+				mockCRM.addVirtualServer(vs)
+				tls.Spec.TLS.Reference = Secret
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+					test.NewSecret("clientssl", "default",
+						testCert("cert"), testKey("cert")))
+				rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT)
+				secretKey = SecretKey{Name: "clientssl", ResourceName: rsName,
+					Partition: "test"}
+			})
+
+			// getTLSServer returns the TLS_Server of the virtual in the
+			// AS3 declaration.
+			getTLSServer := func(sharedApp as3Application) *as3TLSServer {
+				rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+				Expect(ok).To(BeTrue())
+				createServiceDecl(rsCfg, sharedApp)
+				processCustomProfilesForAS3(mockCRM.customProfiles, sharedApp)
+				return sharedApp[rsName+"_tls_server"].(*as3TLSServer)
+			}
+
+			It("Requires client certificates of the CA secret", func() {
+				caSecret := test.NewSecret("client-ca", "default", "", "")
+				caSecret.Data = map[string][]byte{"ca.crt": []byte("ca")}
+				_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").
+					Create(caSecret)
+				tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+					CACertificate: "client-ca",
+				}
+				mockCRM.addTLSProfile(tls)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+				prof := mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.PeerCertMode).To(Equal(PeerCertRequired))
+				Expect(prof.CAFile).To(Equal("ca"))
+				Expect(mockCRM.resources.isDependencyInUse(ObjectDependency{
+					Kind: TLSSecret, Namespace: "default",
+					Name: "client-ca"})).To(BeTrue())
+
+				sharedApp := as3Application{}
+				tlsServer := getTLSServer(sharedApp)
+				Expect(tlsServer.AuthenticationMode).To(Equal(PeerCertRequired))
+				Expect(tlsServer.AuthenticationTrustCA).To(
+					Equal(rsName + "_client_ca"))
+				Expect(sharedApp[rsName+"_client_ca"]).To(Equal(&as3CABundle{
+					Class: "CA_Bundle", Bundle: "ca"}))
+			})
+
+			It("Requests client certificates of the BIG-IP CA bundle", func() {
+				tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+					CACertificate: "/Common/ca-bundle.crt",
+					Mode:          PeerCertRequested,
+				}
+				mockCRM.addTLSProfile(tls)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+				prof := mockCRM.customProfiles.Profs[secretKey]
+				Expect(prof.PeerCertMode).To(Equal(PeerCertRequested))
+				Expect(prof.CABundle).To(Equal("/Common/ca-bundle.crt"))
+
+				tlsServer := getTLSServer(as3Application{})
+				Expect(tlsServer.AuthenticationMode).To(Equal(PeerCertRequested))
+				Expect(tlsServer.AuthenticationTrustCA).To(Equal(
+					&as3ResourcePointer{BigIP: "/Common/ca-bundle.crt"}))
+			})
+
+			It("Does not attach the profile without the CA secret", func() {
+				tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+					CACertificate: "client-ca",
+				}
+				mockCRM.addTLSProfile(tls)
+				Expect(isRetryable(mockCRM.syncVirtualServer(vs))).To(BeTrue())
+				events := mockCRM.getFakeEvents("default")
+				Expect(len(events)).To(Equal(2))
+				Expect(events[0].Reason).To(Equal("SecretNotFound"))
+				Expect(events[0].Message).To(ContainSubstring("client-ca"))
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				for _, prof := range rsCfg.Virtual.Profiles {
+					Expect(prof.Context).NotTo(Equal(CustomProfileClient))
+				}
+			})
+
+			It("Rejects client authentication of BIG-IP profiles", func() {
+				tls.Spec.TLS.Reference = BIGIP
+				tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+					CACertificate: "/Common/ca-bundle.crt",
+				}
+				mockCRM.addTLSProfile(tls)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				events := mockCRM.getFakeEvents("default")
+				Expect(len(events)).To(Equal(2))
+				Expect(events[0].Reason).To(Equal("InvalidClientAuth"))
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				Expect(rsCfg.Virtual.Profiles).To(BeEmpty())
+			})
+
+			It("Rejects invalid mode", func() {
+				tls.Spec.TLS.ClientAuth = &cisapiv1.ClientAuth{
+					CACertificate: "/Common/ca-bundle.crt",
+					Mode:          "always",
+				}
+				mockCRM.addTLSProfile(tls)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				events := mockCRM.getFakeEvents("default")
+				Expect(len(events)).To(Equal(2))
+				Expect(events[0].Reason).To(Equal("InvalidClientAuth"))
+				Expect(mockCRM.customProfiles.Profs).NotTo(HaveKey(secretKey))
+			})
+		})
+	})
+
+	Context("VirtualServer Pools", func() {
+		var poolNames func(rsName string) []string
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+				{Path: "/baz", Service: "svc3", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2", "svc3", "svc4", "svc5")
+			poolNames = func(rsName string) []string {
+				rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+				Expect(ok).To(BeTrue())
+				var names []string
+				for _, pl := range rsCfg.Pools {
+					names = append(names, pl.Name)
+				}
+				return names
+			}
+		})
+
+		It("Deletes pools removed from VirtualServer", func() {
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(poolNames(rsName)).To(ConsistOf(
+				"default_svc1_80", "default_svc2_80", "default_svc3_80"))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools = newVS.Spec.Pools[:1]
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(poolNames(rsName)).To(ConsistOf("default_svc1_80"))
+
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(len(rsCfg.Policies)).To(Equal(1))
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(1))
+			Expect(rsCfg.Policies[0].Rules[0].Actions[0].Pool).To(
+				Equal("default_svc1_80"))
+		})
+
+		It("Deletes pool of a renamed service", func() {
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Service = "svc4"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(poolNames(rsName)).To(ConsistOf(
+				"default_svc4_80", "default_svc2_80", "default_svc3_80"))
+		})
+
+		It("Retains pools of VirtualServers sharing the virtual", func() {
+			otherVS := test.NewVirtualServer(
+				"OtherVS",
+				"default",
+				cisapiv1.VirtualServerSpec{
+					Host:                 "other.com",
+					VirtualServerAddress: "1.2.3.4",
+					Pools: []cisapiv1.Pool{
+						{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+						{Path: "/qux", Service: "svc5", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+			mockCRM.addVirtualServer(vs)
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(poolNames(rsName)).To(ConsistOf("default_svc1_80",
+				"default_svc2_80", "default_svc3_80", "default_svc5_80"))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools = newVS.Spec.Pools[1:2]
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(poolNames(rsName)).To(ConsistOf(
+				"default_svc1_80", "default_svc2_80", "default_svc5_80"),
+				"Pool used by the other VirtualServer should not be deleted")
+
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(3))
+		})
+	})
+
+	Context("VirtualServer Address", func() {
+		It("Rejects VirtualServer without valid address", func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "invalid"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Name).To(Equal("SampleVS"))
+			Expect(events[0].EventType).To(Equal("Warning"))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+		})
+	})
+
+	Context("VirtualServer iRules", func() {
+		var oldPartition string
+		var managedIRule string
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addIRule(AbDeploymentPathIRuleName, DEFAULT_PARTITION, "")
+			managedIRule = "/test/" + AbDeploymentPathIRuleName
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getIRules := func() []string {
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Virtual.IRules
+		}
+
+		It("Attaches iRules of the spec", func() {
+			vs.Spec.IRules = []string{"/Common/geo_steering", "scrub_headers",
+				"/Common/geo_steering"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getIRules()).To(Equal([]string{"/Common/geo_steering",
+				"/test/scrub_headers"}))
+		})
+
+		It("Removes iRules deleted from the spec", func() {
+			vs.Spec.IRules = []string{"/Common/geo_steering", "scrub_headers"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			rsCfg.Virtual.AddIRule(managedIRule)
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.IRules = []string{"scrub_headers"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getIRules()).To(Equal([]string{managedIRule,
+				"/test/scrub_headers"}),
+				"IRules created by CIS should be preserved")
+		})
+
+		It("Refers to iRules not created by CIS by their path", func() {
+			vs.Spec.IRules = []string{"/Common/geo_steering"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			rsCfg.Virtual.AddIRule(managedIRule)
+
+			sharedApp := as3Application{}
+			processIRulesForAS3(mockCRM.irulesMap, sharedApp)
+			createServiceDecl(rsCfg, sharedApp)
+			svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.IRules).To(Equal([]as3MultiTypeParam{
+				&as3ResourcePointer{BigIP: "/Common/geo_steering"},
+				AbDeploymentPathIRuleName,
+			}))
+		})
+	})
+
+	Context("HTTPS redirect", func() {
+		var oldPartition string
+		var otherVS *cisapiv1.VirtualServer
+		redirectDgKey := NameRef{Name: HttpsRedirectDgName, Partition: "test"}
+		redirectIRuleKey := NameRef{Name: "http_redirect_irule_443",
+			Partition: "test"}
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.HTTPTraffic = "redirect"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addTLSProfile(tls)
+			mockCRM.addVirtualServer(vs)
+			otherVS = vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "OtherVS"
+			otherVS.Spec.Host = "other.com"
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getRecords := func() InternalDataGroupRecords {
+			dg, found := mockCRM.intDgMap[redirectDgKey]["default"]
+			if !found {
+				return nil
+			}
+			return dg.Records
+		}
+
+		It("Keeps the records of the VirtualServers of the namespace", func() {
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{
+				{Name: "other.com/foo", Data: "/foo"},
+				{Name: "test.com/foo", Data: "/foo"},
+			}))
+			Expect(mockCRM.irulesMap).To(HaveKey(redirectIRuleKey))
+		})
+
+		It("Disables the shared virtuals when all VirtualServers disable them", func() {
+			enabled := func() []bool {
+				var states []bool
+				for _, port := range []int32{DEFAULT_HTTP_PORT,
+					DEFAULT_HTTPS_PORT} {
+					rsCfg, ok := mockCRM.resources.GetByName("test",
+						mockCRM.getVirtualServerName(vs, port))
+					Expect(ok).To(BeTrue())
+					states = append(states, rsCfg.Virtual.Enabled)
+				}
+				return states
+			}
+			newVS := vs.DeepCopy()
+			newVS.Spec.DisableVirtualServer = true
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			// Kept enabled for OtherVS
+			Expect(enabled()).To(Equal([]bool{true, true}))
+
+			newOtherVS := otherVS.DeepCopy()
+			newOtherVS.Spec.DisableVirtualServer = true
+			mockCRM.addVirtualServer(newOtherVS)
+			Expect(mockCRM.syncVirtualServer(newOtherVS)).To(BeNil())
+			Expect(enabled()).To(Equal([]bool{false, false}))
+			rsCfg, _ := mockCRM.resources.GetByName("test",
+				mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT))
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			svcDecl := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svcDecl.Enable).NotTo(BeNil())
+			Expect(*svcDecl.Enable).To(BeFalse())
+
+			newVS = newVS.DeepCopy()
+			newVS.Spec.DisableVirtualServer = false
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(enabled()).To(Equal([]bool{true, true}))
+
+			mockCRM.deleteVirtualServerConfig(newVS)
+			Expect(enabled()).To(Equal([]bool{false, false}))
+		})
+
+		It("Removes the records when HTTP is no longer redirected", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.HTTPTraffic = "allow"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{
+				{Name: "other.com/foo", Data: "/foo"},
+			}))
+			Expect(mockCRM.irulesMap).To(HaveKey(redirectIRuleKey))
+		})
+
+		It("Removes the iRule and data group when no host is redirected", func() {
+			mockCRM.deleteVirtualServerConfig(otherVS)
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{
+				{Name: "test.com/foo", Data: "/foo"},
+			}))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.HTTPTraffic = "allow"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.intDgMap).NotTo(HaveKey(redirectDgKey))
+			Expect(mockCRM.irulesMap).NotTo(HaveKey(redirectIRuleKey))
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.IRules).NotTo(ContainElement(
+				"/test/http_redirect_irule_443"))
+		})
+
+		It("Redirects to the public host of the pools with a hostRewrite", func() {
+			hostActions := func(port int32) []*action {
+				rsCfg, ok := mockCRM.resources.GetByName("test",
+					mockCRM.getVirtualServerName(vs, port))
+				Expect(ok).To(BeTrue())
+				var actions []*action
+				for _, rl := range rsCfg.Policies[0].Rules {
+					for _, act := range rl.Actions {
+						if act.HTTPHost && rl.FullURI == "test.com/foo" {
+							actions = append(actions, act)
+						}
+					}
+				}
+				return actions
+			}
+			vs = vs.DeepCopy()
+			vs.Spec.Pools[0].HostRewrite = "internal.svc"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(hostActions(DEFAULT_HTTP_PORT)).To(BeEmpty())
+			Expect(hostActions(DEFAULT_HTTPS_PORT)).To(Equal([]*action{{
+				Name: "0", HTTPHost: true, Replace: true, Request: true,
+				Value: "internal.svc"}}))
+			Expect(getRecords()).To(ContainElement(InternalDataGroupRecord{
+				Name: "test.com/foo", Data: "/foo"}))
+
+			// The HTTP requests are rewritten once they are not redirected
+			vs = vs.DeepCopy()
+			vs.Spec.HTTPTraffic = "allow"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(hostActions(DEFAULT_HTTP_PORT)).To(HaveLen(1))
+
+			vs = vs.DeepCopy()
+			vs.Spec.HTTPTraffic = "redirect"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(hostActions(DEFAULT_HTTP_PORT)).To(BeEmpty())
+			Expect(mockCRM.mergedRulesMap).NotTo(HaveKey(
+				mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)))
+			Expect(hostActions(DEFAULT_HTTPS_PORT)).To(HaveLen(1))
+		})
+	})
+
+	Context("Pool rewrite", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Rewrite: &cisapiv1.Rewrite{TargetPath: "/bar"}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getRules := func() Rules {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Policies[0].Rules
+		}
+		fwdAction := &action{Name: "0", Forward: true, Request: true,
+			Pool: "default_svc1_80"}
+		rewriteAction := &action{Name: "0", HTTPURI: true, Replace: true,
+			Request: true, Value: "tcl:[regsub {^/foo} [HTTP::uri] {/bar}]"}
+
+		It("Merges the rewrite with the forwarding rule", func() {
+			rules := getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction,
+				rewriteAction}))
+
+			// Syncing again keeps a single merged rule
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rules = getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction,
+				rewriteAction}))
+		})
+
+		It("Removes the rewrite removed from the spec", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Rewrite = nil
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rules := getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction}))
+			Expect(mockCRM.mergedRulesMap).NotTo(HaveKey(
+				NameRef{Name: rsName, Partition: "test"}))
+		})
+
+		It("Deletes the rewrite of the path removed from the spec", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/baz", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rules := getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].FullURI).To(Equal("test.com/baz"))
+			Expect(len(rules[0].Actions)).To(Equal(1))
+			Expect(mockCRM.mergedRulesMap).NotTo(HaveKey(
+				NameRef{Name: rsName, Partition: "test"}))
+		})
+
+		It("Replaces the host of the pools with a hostRewrite", func() {
+			hostAction := func(host string) *action {
+				return &action{Name: "0", HTTPHost: true, Replace: true,
+					Request: true, Value: host}
+			}
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Rewrite = nil
+			newVS.Spec.Pools[0].HostRewrite = "internal.svc"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rules := getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction,
+				hostAction("internal.svc")}))
+
+			// The host replaced before is not kept
+			newVS = newVS.DeepCopy()
+			newVS.Spec.Pools[0].HostRewrite = "internal.svc:8080"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rules = getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction,
+				hostAction("internal.svc:8080")}))
+
+			newVS = newVS.DeepCopy()
+			newVS.Spec.Pools[0].HostRewrite = ""
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rules = getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction}))
+			Expect(mockCRM.mergedRulesMap).NotTo(HaveKey(
+				NameRef{Name: rsName, Partition: "test"}))
+		})
+
+		It("Does not rewrite the path of an older VirtualServer", func() {
+			otherVS := vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "ZVS"
+			otherVS.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					Rewrite: &cisapiv1.Rewrite{TargetHost: "other.com"}},
+			}
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Rewrite = nil
+			mockCRM.addVirtualServer(newVS)
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rules := getRules()
+			Expect(len(rules)).To(Equal(1))
+			Expect(rules[0].Actions).To(Equal([]*action{fwdAction}))
+		})
+	})
+
+	Context("Response headers", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Rewrite: &cisapiv1.Rewrite{TargetPath: "/bar"},
+					ResponseHeaders: &cisapiv1.ResponseHeaders{
+						Set:    map[string]string{"X-Frame-Options": "DENY"},
+						Remove: []string{"Server"},
+					}},
+			}
+			addServices("default", "svc1")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		// policyDecl returns the AS3 declaration of the policy of the virtual
+		policyDecl := func() string {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			sharedApp := as3Application{}
+			createPoliciesDecl(rsCfg, sharedApp)
+			decl, err := json.Marshal(sharedApp)
+			Expect(err).NotTo(HaveOccurred())
+			return string(decl)
+		}
+		headerActions := func() []*action {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.Policies[0].Rules).To(HaveLen(1))
+			var actions []*action
+			for _, act := range rsCfg.Policies[0].Rules[0].Actions {
+				if act.TmName != "" {
+					actions = append(actions, act)
+				}
+			}
+			return actions
+		}
+
+		It("Updates the response headers changed in the spec", func() {
+			Expect(headerActions()).To(Equal([]*action{
+				{Name: "1", Replace: true, Response: true,
+					TmName: "X-Frame-Options", Value: "DENY"},
+				{Name: "2", Remove: true, Response: true, TmName: "Server"},
+			}))
+			decl := policyDecl()
+			Expect(decl).To(ContainSubstring(`"event":"response"`))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].ResponseHeaders.Set["X-Frame-Options"] =
+				"SAMEORIGIN"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(headerActions()).To(Equal([]*action{
+				{Name: "1", Replace: true, Response: true,
+					TmName: "X-Frame-Options", Value: "SAMEORIGIN"},
+				{Name: "2", Remove: true, Response: true, TmName: "Server"},
+			}))
+			Expect(policyDecl()).NotTo(Equal(decl))
+			Expect(policyDecl()).To(ContainSubstring("SAMEORIGIN"))
+		})
+
+		It("Removes the response headers removed from the spec", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].ResponseHeaders = nil
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(headerActions()).To(BeEmpty())
+			// The rewrite is still merged with the forwarding rule
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Policies[0].Rules[0].Actions).To(HaveLen(2))
+			Expect(policyDecl()).NotTo(ContainSubstring(`"event":"response"`))
+		})
+	})
+
+	Context("Source ranges", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.AllowSourceRange = []string{"10.0.0.0/8"}
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80),
+					AllowSourceRange: []string{"192.168.0.0/16"}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		ruleNames := func() []string {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			var names []string
+			for _, rl := range rsCfg.Policies[0].Rules {
+				names = append(names, rl.Name)
+			}
+			return names
+		}
+
+		It("Deletes the reset rules of the source ranges removed", func() {
+			Expect(ruleNames()).To(Equal([]string{
+				"vs_test_com_bar_default_svc2_80",
+				"vs_test_com_foo_default_svc1_80",
+				"vs_test_com_bar_default_svc2_80-reset",
+				"vs_test_com_foo_default_svc1_80-reset",
+			}))
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Policies[0].Requires).To(Equal(
+				[]string{"http", "tcp"}))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.AllowSourceRange = nil
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(ruleNames()).To(Equal([]string{
+				"vs_test_com_bar_default_svc2_80",
+				"vs_test_com_bar_default_svc2_80-reset",
+				"vs_test_com_foo_default_svc1_80",
+			}))
+
+			newVS = newVS.DeepCopy()
+			newVS.Spec.Pools = newVS.Spec.Pools[:1]
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(ruleNames()).To(Equal([]string{
+				"vs_test_com_foo_default_svc1_80",
+			}))
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Policies[0].Requires).To(Equal([]string{"http"}))
+		})
+
+		It("Rejects the VirtualServer with invalid source ranges", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[1].AllowSourceRange = []string{"192.168.0.0"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+			Expect(events[0].Message).To(ContainSubstring("192.168.0.0"))
+
+			for _, update := range []func(vs *cisapiv1.VirtualServer){
+				func(vs *cisapiv1.VirtualServer) {
+					vs.Spec.AllowSourceRange = []string{"10.0.0.0/33"}
+				},
+				func(vs *cisapiv1.VirtualServer) {
+					vs.Spec.DefaultPool = &cisapiv1.DefaultPool{
+						Service: "svc2", ServicePort: 80}
+				},
+				func(vs *cisapiv1.VirtualServer) {
+					vs.Spec.Pools[1].Service = ""
+					vs.Spec.Pools[1].Action = &cisapiv1.PoolAction{
+						Type: PoolActionDrop}
+				},
+			} {
+				invalid := vs.DeepCopy()
+				update(invalid)
+				Expect(ValidateVirtualServer(invalid,
+					mockCRM.validationOptions())).To(HaveOccurred(), "%+v",
+					invalid.Spec)
+			}
+			vs.Spec.AllowSourceRange = append(vs.Spec.AllowSourceRange,
+				"fd00::/8")
+			Expect(ValidateVirtualServer(vs,
+				mockCRM.validationOptions())).To(Succeed())
+		})
+	})
+
+	Context("Maintenance", func() {
+		var rsName string
+		iRuleKey := NameRef{Partition: "test"}
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					Rewrite: &cisapiv1.Rewrite{TargetPath: "/bar"}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			iRuleKey.Name = PoolActionIRuleName + "_" + rsName
+		})
+
+		getRules := func() Rules {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Policies[0].Rules
+		}
+
+		It("Overrides the rules of the host until disabled", func() {
+			rules := getRules()
+			Expect(rules).To(HaveLen(2))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Maintenance = &cisapiv1.Maintenance{Enabled: true,
+				RedirectURL: "https://status.test.com"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRules()).To(HaveLen(3))
+			Expect(getRules()[0].Name).To(Equal("vs_test_com_all-maintenance"))
+			Expect(getRules()[0].Ordinal).To(Equal(0))
+			Expect(getRules()[0].Actions).To(HaveLen(1))
+			// The rules of the pools are kept as they were
+			for i, rl := range getRules()[1:] {
+				Expect(rl.Ordinal).To(Equal(rules[i].Ordinal + 1))
+				Expect(rl.Actions).To(Equal(rules[i].Actions))
+				Expect(rl.Conditions).To(Equal(rules[i].Conditions))
+			}
+			Expect(mockCRM.irulesMap).To(HaveKey(iRuleKey))
+			Expect(mockCRM.irulesMap[iRuleKey].Code).To(ContainSubstring(
+				"https://status.test.com"))
+
+			newVS = newVS.DeepCopy()
+			newVS.Spec.Maintenance.Enabled = false
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRules()).To(Equal(rules))
+			Expect(mockCRM.irulesMap).NotTo(HaveKey(iRuleKey))
+		})
+
+		It("Forwards the requests of the host to the maintenance pool", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Maintenance = &cisapiv1.Maintenance{Enabled: true,
+				Pool: "/Common/maintenance_pool"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRules()).To(HaveLen(3))
+			Expect(getRules()[0].Actions).To(Equal([]*action{{Name: "0",
+				Forward: true, Request: true,
+				Pool: "/Common/maintenance_pool"}}))
+			Expect(mockCRM.irulesMap).NotTo(HaveKey(iRuleKey))
+
+			mockCRM.deleteVirtualServerConfig(newVS)
+			_, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Rejects the invalid maintenance", func() {
+			for _, mt := range []*cisapiv1.Maintenance{
+				{Enabled: true},
+				{Enabled: true, RedirectURL: "https://status.test.com",
+					Pool: "/Common/maintenance_pool"},
+				{Pool: "maintenance_pool"},
+				{RedirectURL: "https://status.test.com/{x}"},
+			} {
+				invalid := vs.DeepCopy()
+				invalid.Spec.Maintenance = mt
+				Expect(ValidateVirtualServer(invalid,
+					mockCRM.validationOptions())).To(HaveOccurred(), "%+v", mt)
+			}
+		})
+	})
+
+	Context("Pool action", func() {
+		var rsName string
+		iRuleKey := NameRef{Partition: "test"}
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/internal", Action: &cisapiv1.PoolAction{
+					Type: PoolActionDrop}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			iRuleKey.Name = PoolActionIRuleName + "_" + rsName
+		})
+
+		getConfig := func() *ResourceConfig {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg
+		}
+
+		It("Drops the requests of the path in the iRule", func() {
+			rsCfg := getConfig()
+			rules := rsCfg.Policies[0].Rules
+			Expect(len(rules)).To(Equal(2))
+			Expect(rules[0].Name).To(HaveSuffix(resetRuleSuffix))
+			Expect(rsCfg.Pools).To(HaveLen(1))
+			Expect(mockCRM.irulesMap).To(HaveKey(iRuleKey))
+			Expect(rsCfg.Virtual.IRules).To(ContainElement(
+				JoinBigipPath(iRuleKey.Partition, iRuleKey.Name)))
+		})
+
+		It("Deletes the rule of the action removed from the spec", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[1] = cisapiv1.Pool{Path: "/internal",
+				Service: "svc2", ServicePort: intstr.FromInt(80)}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg := getConfig()
+			rules := rsCfg.Policies[0].Rules
+			Expect(len(rules)).To(Equal(2))
+			for _, rl := range rules {
+				Expect(rl.Name).NotTo(HaveSuffix(resetRuleSuffix))
+			}
+			Expect(mockCRM.irulesMap).NotTo(HaveKey(iRuleKey))
+			Expect(rsCfg.Virtual.IRules).NotTo(ContainElement(
+				JoinBigipPath(iRuleKey.Partition, iRuleKey.Name)))
+		})
+
+		It("Deletes the iRule with the virtual", func() {
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(mockCRM.irulesMap).NotTo(HaveKey(iRuleKey))
+		})
+	})
+
+	Context("Default pool", func() {
+		var rsName string
+		var otherVS *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			vs.Spec.DefaultPool = &cisapiv1.DefaultPool{Service: "svc2",
+				ServicePort: 80}
+			otherVS = vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "ZVS"
+			otherVS.Spec.Host = "other.com"
+			otherVS.Spec.DefaultPool = &cisapiv1.DefaultPool{Service: "svc3",
+				ServicePort: 80}
+			addServices("default", "svc1", "svc2", "svc3")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getConfig := func() *ResourceConfig {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg
+		}
+		poolNames := func(rsCfg *ResourceConfig) []string {
+			var names []string
+			for _, pool := range rsCfg.Pools {
+				names = append(names, pool.Name)
+			}
+			return names
+		}
+
+		It("Sets the default pool of the virtual", func() {
+			rsCfg := getConfig()
+			Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc2_80"))
+			Expect(poolNames(rsCfg)).To(ConsistOf("default_svc1_80",
+				"default_svc2_80"))
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(1))
+
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.Pool).To(Equal("/" + rsCfg.Virtual.Partition + "/" +
+				as3SharedApplication + "/default_svc2_80"))
+		})
+
+		It("Keeps the default pool of the older VirtualServer", func() {
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc2_80"))
+			Expect(poolNames(rsCfg)).NotTo(ContainElement("default_svc3_80"))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			rsCfg = getConfig()
+			Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc3_80"))
+			Expect(poolNames(rsCfg)).To(ConsistOf("default_svc1_80",
+				"default_svc3_80"))
+		})
+
+		It("Removes the default pool removed from the spec", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.DefaultPool = nil
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(rsCfg.Virtual.PoolName).To(BeEmpty())
+			Expect(poolNames(rsCfg)).To(Equal([]string{"default_svc1_80"}))
+		})
+
+		It("Processes the VirtualServer on changes of the service", func() {
+			svc := test.NewService("svc2", "1", "default", v1.ServiceTypeClusterIP,
+				nil)
+			Expect(getVirtualServersForService(
+				[]*cisapiv1.VirtualServer{vs}, svc)).To(Equal(
+				[]*cisapiv1.VirtualServer{vs}))
+		})
+	})
+
+	Context("Method and query parameter match", func() {
+		It("Forwards the requests of a path by method", func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					MatchMethod: []string{"GET"}},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					MatchMethod: []string{"POST"}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			rules := rsCfg.Policies[0].Rules
+			Expect(len(rules)).To(Equal(2))
+			pools := map[string]bool{}
+			for _, rl := range rules {
+				Expect(matchConditionCount(rl)).To(Equal(1))
+				pools[getRulePool(rl)] = true
+			}
+			Expect(pools).To(Equal(map[string]bool{"default_svc1_80": true,
+				"default_svc2_80": true}))
+		})
+	})
+
+	Context("HSTS", func() {
+		var oldPartition string
+		hstsDgKey := NameRef{Name: HstsDgName, Partition: "test"}
+		hstsIRule := "/test/" + HstsIRuleName
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.HTTPTraffic = "allow"
+			vs.Spec.HSTS = &cisapiv1.HSTS{Enabled: true,
+				IncludeSubdomains: true}
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addTLSProfile(tls)
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getIRules := func(port int32) []string {
+			rsCfg, ok := mockCRM.resources.GetByName("test",
+				mockCRM.getVirtualServerName(vs, port))
+			Expect(ok).To(BeTrue())
+			return rsCfg.Virtual.IRules
+		}
+
+		It("Inserts the header in the HTTPS responses only", func() {
+			Expect(getIRules(DEFAULT_HTTPS_PORT)).To(ContainElement(hstsIRule))
+			Expect(getIRules(DEFAULT_HTTP_PORT)).NotTo(ContainElement(hstsIRule))
+			Expect(mockCRM.irulesMap).To(HaveKey(
+				NameRef{Name: HstsIRuleName, Partition: "test"}))
+			Expect(mockCRM.intDgMap[hstsDgKey]["default"].Records).To(Equal(
+				InternalDataGroupRecords{{Name: "test.com",
+					Data: "max-age=31536000; includeSubDomains"}}))
+		})
+
+		It("Detaches the iRule when HSTS is disabled", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.HSTS.Enabled = false
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getIRules(DEFAULT_HTTPS_PORT)).NotTo(ContainElement(hstsIRule))
+			Expect(mockCRM.intDgMap).NotTo(HaveKey(hstsDgKey))
+		})
+
+		It("Keeps the iRule of other VirtualServers with HSTS", func() {
+			otherVS := vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "OtherVS"
+			otherVS.Spec.Host = "other.com"
+			otherVS.Spec.HSTS = nil
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(getIRules(DEFAULT_HTTPS_PORT)).To(ContainElement(hstsIRule))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			rsCfg, _ := mockCRM.resources.GetByName("test",
+				mockCRM.getVirtualServerName(otherVS, DEFAULT_HTTPS_PORT))
+			Expect(rsCfg.Virtual.IRules).NotTo(ContainElement(hstsIRule))
+		})
+	})
+
+	Context("HTTP traffic none", func() {
+		var oldPartition string
+		var otherVS *cisapiv1.VirtualServer
+		var httpName string
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.HTTPTraffic = "redirect"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addTLSProfile(tls)
+			mockCRM.addVirtualServer(vs)
+			otherVS = vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "OtherVS"
+			otherVS.Spec.Host = "other.com"
+			otherVS.Spec.HTTPTraffic = "allow"
+			otherVS.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			httpName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		// getHTTPVirtual returns the hosts of the rules and the pools of
+		// the HTTP virtual.
+		getHTTPVirtual := func() ([]string, []string) {
+			rsCfg, ok := mockCRM.resources.GetByName("test", httpName)
+			Expect(ok).To(BeTrue())
+			var uris, pools []string
+			for _, pol := range rsCfg.Policies {
+				for _, rl := range pol.Rules {
+					uris = append(uris, rl.FullURI)
+				}
+			}
+			for _, pl := range rsCfg.Pools {
+				pools = append(pools, pl.Name)
+			}
+			return uris, pools
+		}
+
+		It("Removes the VirtualServer from the HTTP virtual", func() {
+			uris, pools := getHTTPVirtual()
+			Expect(uris).To(ConsistOf("test.com/foo", "other.com/bar"))
+			Expect(len(pools)).To(Equal(2))
+
+			newVS := otherVS.DeepCopy()
+			newVS.Spec.HTTPTraffic = "none"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			uris, pools = getHTTPVirtual()
+			Expect(uris).To(Equal([]string{"test.com/foo"}))
+			Expect(pools).To(Equal([]string{"default_svc1_80"}))
+			rsCfg, _ := mockCRM.resources.GetByName("test", httpName)
+			Expect(rsCfg.MetaData.owners).To(Equal([]string{"default/SampleVS"}))
+			Expect(rsCfg.Virtual.IRules).To(Equal(
+				[]string{"/test/http_redirect_irule_443"}),
+				"HTTP of the other host should still be redirected")
+
+			// The HTTPS virtual still serves both hosts
+			rsCfg, _ = mockCRM.resources.GetByName("test",
+				mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT))
+			Expect(rsCfg.MetaData.owners).To(Equal(
+				[]string{"default/OtherVS", "default/SampleVS"}))
+		})
+
+		It("Deletes the HTTP virtual without VirtualServers", func() {
+			for _, virtual := range []*cisapiv1.VirtualServer{vs, otherVS} {
+				newVS := virtual.DeepCopy()
+				newVS.Spec.HTTPTraffic = "none"
+				mockCRM.addVirtualServer(newVS)
+				Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			}
+			_, found := mockCRM.resources.GetByName("test", httpName)
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Context("VirtualServer persistence", func() {
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2")
+		})
+
+		getVirtual := func() Virtual {
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Virtual
+		}
+
+		It("Sets and clears the persistence profile", func() {
+			vs.Spec.PersistenceProfile = "/Common/my_persist"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getVirtual().PersistenceProfile).To(Equal("/Common/my_persist"))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.PersistenceProfile = ""
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getVirtual().PersistenceProfile).To(BeEmpty())
+		})
+
+		It("Rejects invalid persistence profile", func() {
+			vs.Spec.PersistenceProfile = "universal"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+		})
+
+		It("Warns about source_addr persistence with multiple paths", func() {
+			vs.Spec.PersistenceProfile = PersistenceSourceAddr
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getVirtual().PersistenceProfile).To(Equal(PersistenceSourceAddr))
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("PersistenceWarning"))
+
+			// The unchanged VirtualServer is not warned about again, even
+			// after the Event is no longer deduplicated
+			expireEvents := func() {
+				nen := mockCRM.eventNotifier.notifierMap["default"]
+				nen.recentEvents = make(map[string]recentEvent)
+			}
+			expireEvents()
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(HaveLen(1))
+			Expect(mockCRM.getVirtualServerStatus(vs).Message).To(
+				ContainSubstring("source_addr persistence"))
+
+			newVS := vs.DeepCopy()
+			newVS.ObjectMeta.Generation++
+			mockCRM.addVirtualServer(newVS)
+			expireEvents()
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(HaveLen(2))
+
+			// The warning reappearing is recorded again
+			noPersistVS := newVS.DeepCopy()
+			noPersistVS.Spec.PersistenceProfile = ""
+			mockCRM.addVirtualServer(noPersistVS)
+			Expect(mockCRM.syncVirtualServer(noPersistVS)).To(BeNil())
+			mockCRM.addVirtualServer(newVS)
+			expireEvents()
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(HaveLen(3))
+		})
+
+		It("Creates AS3 persistence methods", func() {
+			Expect(createPersistenceMethod(PersistenceCookie)).To(Equal("cookie"))
+			Expect(createPersistenceMethod(PersistenceSourceAddr)).To(
+				Equal("source-address"))
+			Expect(createPersistenceMethod(PersistenceSSL)).To(
+				Equal("tls-session-id"))
+			Expect(createPersistenceMethod("/Common/my_persist")).To(Equal(
+				&as3ResourcePointer{BigIP: "/Common/my_persist"}))
+		})
+	})
+
+	Context("VirtualServer SNAT", func() {
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+		})
+
+		getSNAT := func() SourceAddrTranslation {
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Virtual.SourceAddrTranslation
+		}
+
+		It("Uses default SNAT", func() {
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getSNAT()).To(Equal(SourceAddrTranslation{Type: SNATAutomap}))
+
+			mockCRM.DefaultSNAT = "/Common/snatpool"
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getSNAT()).To(Equal(SourceAddrTranslation{
+				Type: "snat", Pool: "/Common/snatpool"}))
+		})
+
+		It("Updates SNAT of the VirtualServer", func() {
+			mockCRM.DefaultSNAT = "/Common/snatpool"
+			vs.Spec.SNAT = SNATNone
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getSNAT()).To(Equal(SourceAddrTranslation{Type: SNATNone}))
+			mockCRM.resources.updateOldConfig()
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.SNAT = SNATAutomap
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getSNAT()).To(Equal(SourceAddrTranslation{Type: SNATAutomap}))
+			Expect(mockCRM.resources.rsMap).NotTo(
+				Equal(mockCRM.resources.oldRsMap),
+				"Updated SNAT should be posted to BIG-IP")
+		})
+
+		It("Rejects invalid SNAT", func() {
+			Expect(ValidateSNAT("/Common/snatpool")).To(BeNil())
+			Expect(ValidateSNAT("snatpool")).NotTo(BeNil())
+			Expect(ValidateSNAT("/Common")).NotTo(BeNil())
+
+			vs.Spec.SNAT = "auto"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+		})
+
+		It("Creates AS3 SNAT", func() {
+			vs.Spec.SNAT = "/Common/snatpool"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.SNAT).To(Equal(
+				&as3ResourcePointer{BigIP: "/Common/snatpool"}))
+		})
+
+		It("Creates AS3 SNAT pool with the virtual", func() {
+			vs.Spec.SNATAddresses = []string{"10.1.1.1", "[2001:DB8::1]"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			snatPool := rsCfg.Virtual.Name + "_snatpool"
+			svc := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svc.SNAT).To(Equal(&as3ResourcePointer{Use: snatPool}))
+			Expect(sharedApp[snatPool]).To(Equal(&as3SNATPool{
+				Class:         "SNAT_Pool",
+				SNATAddresses: []string{"10.1.1.1", "2001:db8::1"},
+			}))
+		})
+
+		It("Rejects invalid SNAT addresses", func() {
+			for _, update := range []func(vs *cisapiv1.VirtualServer){
+				func(vs *cisapiv1.VirtualServer) {
+					vs.Spec.SNAT = SNATAutomap
+					vs.Spec.SNATAddresses = []string{"10.1.1.1"}
+				},
+				func(vs *cisapiv1.VirtualServer) {
+					vs.Spec.SNATAddresses = []string{"10.1.1.1", "10.1.1"}
+				},
+			} {
+				invalid := vs.DeepCopy()
+				update(invalid)
+				Expect(ValidateVirtualServer(invalid,
+					mockCRM.validationOptions())).To(HaveOccurred(), "%+v",
+					invalid.Spec)
+			}
+		})
+	})
+
+	Context("VirtualServer allowed VLANs", func() {
+		BeforeEach(func() {
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addTLSProfile(tls)
+		})
+
+		It("Restricts HTTP and HTTPS virtuals to VLANs", func() {
+			vs.Spec.AllowVLANs = []string{"external", "/Common/vlan2"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(2))
+			for _, rsCfg := range mockCRM.resources.rsMap {
+				Expect(rsCfg.Virtual.AllowVLANs).To(Equal(
+					[]string{"/Common/external", "/Common/vlan2"}))
+			}
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.AllowVLANs = nil
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			for _, rsCfg := range mockCRM.resources.rsMap {
+				Expect(rsCfg.Virtual.AllowVLANs).To(BeEmpty())
+			}
+		})
+
+		It("Rejects invalid VLANs", func() {
+			vs.Spec.AllowVLANs = []string{"/Common"}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+		})
+	})
+
+	Context("A/B deployment", func() {
+		var oldPartition string
+		abDgKey := NameRef{Name: AbDeploymentDgName, Partition: "test"}
+		weight := func(w int32) *int32 { return &w }
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Weight: weight(80)},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					Weight: weight(20)},
+				{Path: "/bar", Service: "svc3", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2", "svc3")
+			mockCRM.addVirtualServer(vs)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getRecords := func() InternalDataGroupRecords {
+			dg, found := mockCRM.intDgMap[abDgKey]["default"]
+			if !found {
+				return nil
+			}
+			return dg.Records
+		}
+
+		It("Splits traffic of pools with the same path", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{{
+				Name: "test.com/foo",
+				Data: "/test/Shared/default_svc1_80,0.800;" +
+					"/test/Shared/default_svc2_80,1.000",
+			}}))
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.IRules).To(ContainElement(
+				"/test/ab_deployment_path_irule"))
+			Expect(len(rsCfg.Pools)).To(Equal(3))
+			pools := make(Pools, len(rsCfg.Pools))
+			copy(pools, rsCfg.Pools)
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Weight = weight(0)
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{{
+				Name: "test.com/foo",
+				Data: "/test/Shared/default_svc2_80,1.000",
+			}}), "Pool with weight 0 should be out of rotation")
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Pools).To(Equal(pools),
+				"Pools should not be updated with weights")
+		})
+
+		It("Removes records of paths with a single pool", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getRecords())).To(Equal(1))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools = newVS.Spec.Pools[1:]
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRecords()).To(BeEmpty())
+
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getRecords())).To(Equal(1))
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(getRecords()).To(BeEmpty())
+		})
+
+		It("Creates A/B deployment records", func() {
+			Expect(abDeploymentKey("Test.com/foo/")).To(Equal("test.com/foo"))
+			Expect(abDeploymentKey("test.com/")).To(Equal("test.com"))
+			Expect(abDeploymentRecord("test", "default", []cisapiv1.Pool{
+				{Service: "svc1", Weight: weight(0)},
+				{Service: "svc2", Weight: weight(0)},
+			})).To(BeEmpty(), "Pools with weight 0 should get no traffic")
+			Expect(abDeploymentRecord("test", "default", []cisapiv1.Pool{
+				{Service: "svc1"},
+				{Service: "svc2", Weight: weight(100)},
+			})).To(Equal("/test/Shared/default_svc1,0.500;" +
+				"/test/Shared/default_svc2,1.000"))
+		})
+	})
+
+	Context("TLS passthrough", func() {
+		var oldPartition string
+		passthroughDgKey := NameRef{Name: PassthroughHostsDgName,
+			Partition: "test"}
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			tls.Spec.TLS.Termination = TLSPassthrough
+			tls.Spec.TLS.Reference = Secret
+			mockCRM.addTLSProfile(tls)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getRecords := func() InternalDataGroupRecords {
+			dg, found := mockCRM.intDgMap[passthroughDgKey]["default"]
+			if !found {
+				return nil
+			}
+			return dg.Records
+		}
+
+		It("Forwards TLS traffic of the host to its pool", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{{
+				Name: "test.com",
+				Data: "/test/Shared/default_svc2_80",
+			}}))
+			Expect(mockCRM.customProfiles.Profs).To(BeEmpty())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.IRules).To(Equal(
+				[]string{"/test/ssl_passthrough_irule"}))
+			Expect(rsCfg.Virtual.Profiles).To(BeEmpty())
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			processProfilesForAS3(ResourceConfigs{rsCfg}, sharedApp)
+			svc := sharedApp[rsName].(*as3Service)
+			Expect(svc.Class).To(Equal("Service_HTTPS"))
+			Expect(svc.ServerTLS).To(Equal(
+				&as3ResourcePointer{BigIP: "/Common/clientssl"}))
+
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.IRules).To(Equal(
+				[]string{"/test/http_redirect_irule_443"}),
+				"HTTP should be redirected to HTTPS")
+		})
+
+		It("Removes the record of the host", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getRecords())).To(Equal(1))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Host = "new.com"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{{
+				Name: "new.com",
+				Data: "/test/Shared/default_svc2_80",
+			}}))
+
+			mockCRM.deleteVirtualServerConfig(newVS)
+			Expect(mockCRM.intDgMap[passthroughDgKey]).NotTo(
+				HaveKey("default"))
+		})
+
+		It("Serves the records of each host", func() {
+			tls.Spec.Hosts = nil
+			mockCRM.addTLSProfile(tls)
+			newVS := vs.DeepCopy()
+			newVS.Spec.Host = ""
+			newVS.Spec.Hosts = []string{"test.com", "www.test.com"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{
+				{Name: "test.com", Data: "/test/Shared/default_svc2_80"},
+				{Name: "www.test.com", Data: "/test/Shared/default_svc2_80"},
+			}))
+			redirects := func() []string {
+				var names []string
+				for _, record := range mockCRM.intDgMap[NameRef{
+					Name:      HttpsRedirectDgName,
+					Partition: "test",
+				}]["default"].Records {
+					names = append(names, record.Name)
+				}
+				return names
+			}
+			Expect(redirects()).To(ConsistOf("test.com/", "test.com/foo",
+				"www.test.com/", "www.test.com/foo"))
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rules := func() []string {
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				var uris []string
+				for _, rl := range rsCfg.FindPolicy("forwarding").Rules {
+					uris = append(uris, rl.FullURI)
+				}
+				return uris
+			}
+			Expect(rules()).To(ConsistOf("test.com/", "test.com/foo",
+				"www.test.com/", "www.test.com/foo"))
+
+			// Only the rules and records of the host removed are deleted
+			newVS = newVS.DeepCopy()
+			newVS.Spec.Hosts = []string{"test.com"}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getRecords()).To(Equal(InternalDataGroupRecords{
+				{Name: "test.com", Data: "/test/Shared/default_svc2_80"},
+			}))
+			Expect(redirects()).To(ConsistOf("test.com/", "test.com/foo"))
+			Expect(rules()).To(ConsistOf("test.com/", "test.com/foo"))
+
+			// The hosts are exclusive with the host
+			newVS.Spec.Host = "test.com"
+			Expect(ValidateVirtualServer(newVS, mockCRM.validationOptions())).
+				To(MatchError("host and hosts are mutually exclusive"))
+			newVS.Spec.Host = ""
+			newVS.Spec.Hosts = []string{"test.com", "TEST.com"}
+			Expect(ValidateVirtualServer(newVS, mockCRM.validationOptions())).
+				To(MatchError("Host 'TEST.com' is listed twice in hosts"))
+		})
+
+		It("Removes the record when TLS is terminated", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getRecords())).To(Equal(1))
+
+			newTLS := tls.DeepCopy()
+			newTLS.Spec.TLS.Termination = TLSEdge
+			newTLS.Spec.TLS.Reference = BIGIP
+			mockCRM.updateTLSContext(newTLS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getRecords()).To(BeEmpty())
+		})
+
+		It("Rejects invalid termination", func() {
+			tls.Spec.TLS.Termination = "terminate"
+			mockCRM.updateTLSContext(tls)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(2))
+			Expect(events[0].Reason).To(Equal("InvalidTLSProfile"))
+			Expect(getRecords()).To(BeEmpty())
+		})
+
+		It("Flattens the records of the namespaces", func() {
+			otherVS := test.NewVirtualServer(
+				"OtherVS",
+				"other",
+				cisapiv1.VirtualServerSpec{
+					Host:                 "other.com",
+					VirtualServerAddress: "1.2.3.4",
+					TLSProfileName:       "SampleTLS",
+					Pools: []cisapiv1.Pool{
+						{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+			addServices("other", "svc1")
+			mockCRM.addVirtualServer(otherVS)
+			otherTLS := tls.DeepCopy()
+			otherTLS.ObjectMeta.Namespace = "other"
+			mockCRM.addTLSProfile(otherTLS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+
+			sharedApp := as3Application{}
+			processDataGroupForAS3(mockCRM.intDgMap, sharedApp)
+			Expect(sharedApp[PassthroughHostsDgName]).To(Equal(&as3DataGroup{
+				Class:       "Data_Group",
+				KeyDataType: "string",
+				Records: []as3Record{
+					{Key: "other.com", Value: "/test/Shared/other_svc1_80"},
+					{Key: "test.com", Value: "/test/Shared/default_svc2_80"},
+				},
+			}))
+		})
+	})
+
+	Context("TLS reencrypt", func() {
+		var oldPartition string
+		var rsName string
+		hostsDgKey := NameRef{Name: ReencryptHostsDgName, Partition: "test"}
+		serverSslDgKey := NameRef{Name: ReencryptServerSslDgName,
+			Partition: "test"}
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addVirtualServer(vs)
+			tls.Spec.TLS.Termination = TLSReencrypt
+			tls.Spec.TLS.ServerSSL = "serverssl"
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		getRecords := func(dgKey NameRef) InternalDataGroupRecords {
+			dg, found := mockCRM.intDgMap[dgKey]["default"]
+			if !found {
+				return nil
+			}
+			return dg.Records
+		}
+
+		It("Encrypts traffic with the BIG-IP server SSL profile", func() {
+			tls.Spec.TLS.Reference = BIGIP
+			tls.Spec.TLS.ClientSSL = "/Common/clientssl"
+			tls.Spec.TLS.ServerSSL = "/Common/serverssl"
+			mockCRM.addTLSProfile(tls)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+			Expect(getRecords(hostsDgKey)).To(Equal(InternalDataGroupRecords{{
+				Name: "test.com",
+				Data: "/test/Shared/default_svc1_80",
+			}}))
+			Expect(getRecords(serverSslDgKey)).To(Equal(
+				InternalDataGroupRecords{{
+					Name: "test.com",
+					Data: "/Common/serverssl",
+				}}))
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.IRules).To(ContainElement(
+				"/test/ssl_reencrypt_irule"))
+		})
+
+		It("Encrypts traffic with the server SSL profile of the secret", func() {
+			tls.Spec.TLS.Reference = Secret
+			_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+				test.NewSecret("clientssl", "default",
+					testCert("cert"), testKey("cert")))
+			_, _ = mockCRM.kubeClient.CoreV1().Secrets("default").Create(
+				test.NewSecret("serverssl", "default", "ca", ""))
+			mockCRM.addTLSProfile(tls)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+			Expect(getRecords(serverSslDgKey)).To(Equal(
+				InternalDataGroupRecords{{
+					Name: "test.com",
+					Data: "/test/Shared/" + rsName + "_tls_client",
+				}}))
+			Expect(mockCRM.resources.isDependencyInUse(ObjectDependency{
+				Kind: TLSSecret, Namespace: "default",
+				Name: "serverssl"})).To(BeTrue())
+
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			processCustomProfilesForAS3(mockCRM.customProfiles, sharedApp)
+			Expect(sharedApp[rsName+"_tls_client"]).To(Equal(&as3TLSClient{
+				Class:               "TLS_Client",
+				TrustCA:             &as3ResourcePointer{Use: "serverssl_ca_bundle"},
+				ValidateCertificate: true,
+			}))
+		})
+
+		It("Removes the records of the host", func() {
+			tls.Spec.TLS.Reference = BIGIP
+			tls.Spec.TLS.ClientSSL = "/Common/clientssl"
+			tls.Spec.TLS.ServerSSL = "/Common/serverssl"
+			mockCRM.addTLSProfile(tls)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getRecords(serverSslDgKey))).To(Equal(1))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(mockCRM.intDgMap[hostsDgKey]).NotTo(HaveKey("default"))
+			Expect(mockCRM.intDgMap[serverSslDgKey]).NotTo(HaveKey("default"))
+		})
+
+		It("Requires the server SSL profile", func() {
+			tls.Spec.TLS.Reference = BIGIP
+			tls.Spec.TLS.ClientSSL = "/Common/clientssl"
+			tls.Spec.TLS.ServerSSL = ""
+			mockCRM.addTLSProfile(tls)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(2))
+			Expect(events[0].Reason).To(Equal("InvalidTLSProfile"))
+			Expect(getRecords(hostsDgKey)).To(BeEmpty())
+		})
+	})
+
+	Context("Shared VirtualServer Address", func() {
+		var otherVS *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			vs.ObjectMeta.CreationTimestamp = metav1.NewTime(time.Unix(100, 0))
+			otherVS = test.NewVirtualServer(
+				"OtherVS",
+				"other",
+				cisapiv1.VirtualServerSpec{
+					Host:                 "other.com",
+					VirtualServerAddress: "1.2.3.4",
+					Pools: []cisapiv1.Pool{
+						{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+					},
+				},
+			)
+			otherVS.ObjectMeta.CreationTimestamp =
+				metav1.NewTime(time.Unix(200, 0))
+			addServices("default", "svc1")
+			addServices("other", "svc2")
+			mockCRM.addVirtualServer(vs)
+			mockCRM.addVirtualServer(otherVS)
+		})
+
+		It("Merges VirtualServers with different hosts", func() {
+			mockCRM.SharedVIPPolicy = SharedVIPMerge
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+			Expect(len(rsCfg.Pools)).To(Equal(2))
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(2))
+			Expect(rsCfg.MetaData.owners).To(Equal(
+				[]string{"default/SampleVS", "other/OtherVS"}))
+			Expect(mockCRM.getFakeEvents("other")).To(BeEmpty())
+		})
+
+		It("Rejects newer VirtualServer using the address", func() {
+			mockCRM.SharedVIPPolicy = SharedVIPReject
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.owners).To(Equal([]string{"default/SampleVS"}),
+				"Virtual of older VirtualServer should not be overwritten")
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(1))
+
+			events := mockCRM.getFakeEvents("other")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Name).To(Equal("OtherVS"))
+			Expect(events[0].Reason).To(Equal("AddressConflict"))
+		})
+
+		It("Evicts newer VirtualServer when older one claims the address", func() {
+			mockCRM.SharedVIPPolicy = SharedVIPReject
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.owners).To(Equal([]string{"default/SampleVS"}))
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].Name).To(Equal("default_svc1_80"))
+
+			events := mockCRM.getFakeEvents("other")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("AddressConflict"))
+		})
+
+		It("Deletes only the rules and pools of deleted VirtualServer", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			mockCRM.deleteVirtualServerConfig(otherVS)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.MetaData.owners).To(Equal([]string{"default/SampleVS"}))
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].Name).To(Equal("default_svc1_80"))
+			Expect(len(rsCfg.Policies[0].Rules)).To(Equal(1))
+			Expect(rsCfg.Policies[0].Rules[0].FullURI).To(Equal("test.com/foo"))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		})
+
+		It("Attaches WAF policy of VirtualServer", func() {
+			vs.Spec.WAF = "/Common/WAF_Policy"
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.WAF).To(Equal("/Common/WAF_Policy"))
+			Expect(rsCfg.Policies[0].Controls).To(Equal(
+				[]string{"forwarding", "asm"}))
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.WAF = ""
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.WAF).To(BeEmpty())
+			Expect(rsCfg.Policies[0].Controls).To(Equal([]string{"forwarding"}))
+		})
+
+		It("Retains first WAF policy of shared virtual", func() {
+			vs.Spec.WAF = "/Common/WAF_Policy"
+			otherVS.Spec.WAF = "/Common/Other_WAF_Policy"
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.WAF).To(Equal("/Common/WAF_Policy"))
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+			events := mockCRM.getFakeEvents("other")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Name).To(Equal("OtherVS"))
+			Expect(events[0].Reason).To(Equal("WAFConflict"))
+
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.WAF).To(Equal("/Common/WAF_Policy"))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.WAF).To(Equal("/Common/Other_WAF_Policy"))
+		})
+
+		It("Limits shared virtual with lowest limits", func() {
+			vs.Spec.ConnectionLimit = 100
+			otherVS.Spec.ConnectionLimit = 50
+			otherVS.Spec.RateLimit = 10
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.ConnectionLimit).To(Equal(int32(100)))
+			Expect(rsCfg.Virtual.RateLimit).To(BeZero())
+
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.ConnectionLimit).To(Equal(int32(50)))
+			Expect(rsCfg.Virtual.RateLimit).To(Equal(int32(10)))
+			mockCRM.resources.updateOldConfig()
+
+			newVS := otherVS.DeepCopy()
+			newVS.Spec.ConnectionLimit = 200
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.ConnectionLimit).To(Equal(int32(100)))
+			Expect(mockCRM.resources.rsMap).NotTo(
+				Equal(mockCRM.resources.oldRsMap),
+				"Updated limit should be posted to BIG-IP")
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.ConnectionLimit).To(Equal(int32(200)))
+		})
+
+		It("Uses most restrictive address settings of shared virtual", func() {
+			arp := false
+			vs.Spec.ICMPEcho = ICMPEchoSelective
+			otherVS.Spec.ICMPEcho = ICMPEchoDisable
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.VirtualAddress.ICMPEcho).To(
+				Equal(ICMPEchoDisable))
+			Expect(rsCfg.Virtual.VirtualAddress.ARPDisabled).To(BeFalse())
+			mockCRM.resources.updateOldConfig()
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.ARP = &arp
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.VirtualAddress.ARPDisabled).To(BeTrue())
+			Expect(mockCRM.resources.getConfigDiffs()).To(Equal(
+				map[NameRef]ResourceConfigDiff{
+					{Name: rsName, Partition: "test"}: {Virtual: true}}),
+				"Disabled ARP alone should be posted to BIG-IP")
+
+			mockCRM.deleteVirtualServerConfig(otherVS)
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.VirtualAddress.ICMPEcho).To(
+				Equal(ICMPEchoSelective))
+			Expect(rsCfg.Virtual.VirtualAddress.ARPDisabled).To(BeTrue())
+		})
+
+		It("Keeps the rule of older VirtualServer for conflicting paths", func() {
+			otherVS.Spec.Host = "test.com"
+			otherVS.Spec.Pools[0].Path = "/foo"
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rulePools := func() []string {
+				rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+				var pools []string
+				for _, rl := range rsCfg.Policies[0].Rules {
+					pools = append(pools, getRulePool(rl))
+				}
+				return pools
+			}
+
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(rulePools()).To(Equal([]string{"other_svc2_80"}))
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(rulePools()).To(Equal([]string{"default_svc1_80"}),
+				"Rule of newer VirtualServer should be replaced")
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(rulePools()).To(Equal([]string{"default_svc1_80"}),
+				"Rule of older VirtualServer should be kept")
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].rscName).To(Equal("OtherVS"))
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(rulePools()).To(Equal([]string{"other_svc2_80"}))
+		})
+
+		It("Enqueues rejected VirtualServer on delete", func() {
+			mockCRM.SharedVIPPolicy = SharedVIPReject
+			mockCRM.enqueueConflictingVirtualServers(vs)
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].rscName).To(Equal("OtherVS"))
+		})
+	})
+
+	Context("VirtualServer with invalid pools", func() {
+		var rsName string
+		var rules func() []string
+
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1")
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rules = func() []string {
+				rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+				Expect(ok).To(BeTrue())
+				var names []string
+				for _, rl := range rsCfg.Policies[0].Rules {
+					names = append(names, rl.FullURI)
+				}
+				return names
+			}
+		})
+
+		It("Rejects VirtualServer by default", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(2))
+			Expect(events[0].Reason).To(Equal("WaitingForService"))
+			Expect(events[1].Reason).To(Equal("InvalidData"))
+		})
+
+		It("Skips invalid pools with skipInvalidPools policy", func() {
+			vs.Spec.PartialErrorPolicy = SkipInvalidPools
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].Name).To(Equal("default_svc1_80"))
+			Expect(rules()).To(ConsistOf("test.com/foo"))
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(2))
+			Expect(events[0].Reason).To(Equal("WaitingForService"))
+			Expect(events[1].Reason).To(Equal("DegradedPaths"))
+			Expect(events[1].Message).To(ContainSubstring("/bar"))
+
+			// Missing service is created
+			addServices("default", "svc2")
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(len(rsCfg.Pools)).To(Equal(2))
+			Expect(rules()).To(ConsistOf("test.com/foo", "test.com/bar"))
+		})
+
+		It("Removes pools skipped after switching policy", func() {
+			addServices("default", "svc2")
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(rules()).To(ConsistOf("test.com/foo", "test.com/bar"))
+
+			crInf, _ := mockCRM.getNamespaceInformer("default")
+			svc, _, _ := crInf.svcInformer.GetIndexer().GetByKey("default/svc2")
+			_ = crInf.svcInformer.GetIndexer().Delete(svc)
+			newVS := vs.DeepCopy()
+			newVS.Spec.PartialErrorPolicy = SkipInvalidPools
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].Name).To(Equal("default_svc1_80"))
+			Expect(rules()).To(ConsistOf("test.com/foo"))
+		})
+	})
+
+	Context("TransportServer", func() {
+		var ts *cisapiv1.TransportServer
+
+		BeforeEach(func() {
+			ts = test.NewTransportServer(
+				"SampleTS",
+				"default",
+				cisapiv1.TransportServerSpec{
+					VirtualServerAddress: "1.2.3.4",
+					VirtualServerPort:    8080,
+					Pool: cisapiv1.TransportServerPool{
+						Service:     "svc1",
+						ServicePort: 8080,
+					},
+				},
+			)
+			addServices("default", "svc1")
+			mockCRM.addTransportServer(ts)
+		})
+
+		It("Creates a TCP virtual without policies or profiles", func() {
+			mockCRM.syncTransportServer(ts)
+
+			rsCfg, ok := mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, 8080))
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.MetaData.ResourceType).To(Equal(TransportServer))
+			Expect(rsCfg.Virtual.IpProtocol).To(Equal("tcp"))
+			Expect(rsCfg.Virtual.Mode).To(Equal(TransportServerStandard))
+			Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc1_8080"))
+			Expect(rsCfg.Virtual.Profiles).To(BeEmpty())
+			Expect(rsCfg.Policies).To(BeEmpty())
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			Expect(rsCfg.Pools[0].MonitorNames).To(Equal([]string{"/Common/tcp"}))
+			Expect(getServiceClass(&rsCfg.Virtual, TransportServer)).To(
+				Equal("Service_TCP"))
+		})
+
+		It("Creates a UDP virtual with a UDP monitor", func() {
+			ts.Spec.Type = "udp"
+			mockCRM.syncTransportServer(ts)
+
+			rsCfg, _ := mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, 8080))
+			Expect(rsCfg.Virtual.IpProtocol).To(Equal("udp"))
+			Expect(rsCfg.Pools[0].MonitorNames).To(Equal([]string{"/Common/udp"}))
+			Expect(getServiceClass(&rsCfg.Virtual, TransportServer)).To(
+				Equal("Service_UDP"))
+
+			ts.Spec.Mode = TransportServerPerformanceL4
+			ts.Spec.Pool.Monitor = "/Common/custom_udp"
+			mockCRM.syncTransportServer(ts)
+			rsCfg, _ = mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, 8080))
+			Expect(rsCfg.Pools[0].MonitorNames).To(Equal(
+				[]string{"/Common/custom_udp"}))
+			Expect(getServiceClass(&rsCfg.Virtual, TransportServer)).To(
+				Equal("Service_L4"))
+		})
+
+		It("Rejects invalid TransportServer", func() {
+			ts.Spec.Type = "sctp"
+			mockCRM.syncTransportServer(ts)
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Reason).To(Equal("InvalidData"))
+		})
+
+		It("Rejects TransportServer on the port of a VirtualServer", func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+
+			ts.Spec.VirtualServerPort = DEFAULT_HTTP_PORT
+			mockCRM.syncTransportServer(ts)
+			rsCfg, _ := mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, DEFAULT_HTTP_PORT))
+			Expect(rsCfg.MetaData.ResourceType).To(Equal(VirtualServer))
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Name).To(Equal("SampleTS"))
+			Expect(events[0].Reason).To(Equal("AddressConflict"))
+		})
+
+		It("Rejects TransportServer on the address of a virtual named otherwise",
+			func() {
+				mockCRM.UseResourceNames = true
+				vs.Spec.TLSProfileName = ""
+				vs.Spec.VirtualServerAddress = "1.2.3.4"
+				mockCRM.addVirtualServer(vs)
+				Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+				vsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+				Expect(vsName).NotTo(Equal(
+					mockCRM.getTransportServerName(ts, DEFAULT_HTTP_PORT)))
+
+				ts.Spec.VirtualServerPort = DEFAULT_HTTP_PORT
+				mockCRM.syncTransportServer(ts)
+				Expect(mockCRM.resources.rsMap).To(HaveLen(1))
+				Expect(mockCRM.resources.rsMap).To(HaveKey(
+					NameRef{Name: vsName, Partition: "test"}))
+				events := mockCRM.getFakeEvents("default")
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Name).To(Equal("SampleTS"))
+				Expect(events[0].Reason).To(Equal("AddressConflict"))
+
+				// The VirtualServer is rejected on the virtual of the
+				// TransportServer the same
+				Expect(mockCRM.claimVirtual(vs, vsName,
+					DEFAULT_HTTP_PORT)).To(BeTrue())
+				mockCRM.deleteVirtual(NameRef{Name: vsName, Partition: "test"})
+				mockCRM.syncTransportServer(ts)
+				Expect(mockCRM.claimVirtual(vs,
+					formatVirtualServerResourceName("default", "SampleVS",
+						DEFAULT_HTTP_PORT), DEFAULT_HTTP_PORT)).To(BeFalse())
+			})
+
+		It("Listens on the range of ports with a virtual per port", func() {
+			ts.Spec.VirtualServerPort = 0
+			ts.Spec.VirtualServerPortRange = "30000-30002"
+			mockCRM.syncTransportServer(ts)
+
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(3))
+			for _, port := range []int32{30000, 30001, 30002} {
+				rsCfg, ok := mockCRM.resources.GetByName("test",
+					mockCRM.getTransportServerName(ts, port))
+				Expect(ok).To(BeTrue())
+				Expect(rsCfg.Virtual.VirtualAddress.Port).To(Equal(port))
+				Expect(rsCfg.Virtual.PortList).To(BeEmpty())
+				Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc1_8080"))
+			}
+
+			// The pools of their own with the offset mapping
+			ts.Spec.PortMapping = PortMappingOffset
+			mockCRM.syncTransportServer(ts)
+			rsCfg, _ := mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, 30002))
+			Expect(rsCfg.Virtual.PoolName).To(Equal("default_svc1_8080_30002"))
+		})
+
+		It("Listens on the ports with a port list", func() {
+			mockCRM.Agent = &Agent{portListSupported: true}
+			ts.Spec.VirtualServerPort = 0
+			ts.Spec.VirtualServerPorts = []int32{30002, 30000, 30001, 30005}
+			mockCRM.syncTransportServer(ts)
+
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+			rsName := mockCRM.getTransportServerName(ts, 30000)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.Virtual.PortList).To(Equal(
+				[]string{"30000-30002", "30005"}))
+
+			// Another TransportServer on one of the ports is rejected
+			otherTS := ts.DeepCopy()
+			otherTS.ObjectMeta.Name = "OtherTS"
+			otherTS.Spec.VirtualServerPorts = nil
+			otherTS.Spec.VirtualServerPort = 30005
+			mockCRM.addTransportServer(otherTS)
+			mockCRM.syncTransportServer(otherTS)
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+			Expect(mockCRM.resources.rsMap).To(HaveKey(
+				NameRef{Name: rsName, Partition: "test"}))
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].Name).To(Equal("OtherTS"))
+			Expect(events[0].Reason).To(Equal("AddressConflict"))
+		})
+
+		It("Rejects all the ports when one is used", func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+
+			ts.Spec.VirtualServerPort = 0
+			ts.Spec.VirtualServerPortRange = "79-81"
+			mockCRM.syncTransportServer(ts)
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+			_, ok := mockCRM.resources.GetByName("test",
+				mockCRM.getTransportServerName(ts, 79))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Validates the ports of the TransportServer", func() {
+			ts.Spec.VirtualServerPorts = []int32{30000}
+			Expect(validateTransportServerPorts(ts)).NotTo(BeNil())
+			ts.Spec.VirtualServerPort = 0
+			Expect(validateTransportServerPorts(ts)).To(BeNil())
+			ts.Spec.VirtualServerPorts = []int32{30000, 30000}
+			Expect(validateTransportServerPorts(ts)).NotTo(BeNil())
+			ts.Spec.VirtualServerPorts = nil
+			Expect(validateTransportServerPorts(ts)).NotTo(BeNil())
+			ts.Spec.VirtualServerPortRange = "30000-31023"
+			Expect(validateTransportServerPorts(ts)).To(BeNil())
+			ts.Spec.VirtualServerPortRange = "30000-31024"
+			Expect(validateTransportServerPorts(ts)).NotTo(BeNil())
+
+			ts.Spec.VirtualServerPortRange = "30000-30001"
+			ts.Spec.PortMapping = "random"
+			mockCRM.syncTransportServer(ts)
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		})
+
+		It("Removes the virtual on update and deletion", func() {
+			mockCRM.syncTransportServer(ts)
+			oldName := mockCRM.getTransportServerName(ts, 8080)
+
+			newTS := ts.DeepCopy()
+			newTS.Spec.VirtualServerPort = 9090
+			mockCRM.addTransportServer(newTS)
+			mockCRM.syncTransportServer(newTS)
+			_, ok := mockCRM.resources.GetByName("test", oldName)
+			Expect(ok).To(BeFalse())
+			Expect(len(mockCRM.resources.rsMap)).To(Equal(1))
+
+			mockCRM.deleteResourceConfigs(TransportServer, tsKey(newTS))
+			Expect(mockCRM.resources.rsMap).To(BeEmpty())
+		})
+	})
+
+	Context("EndpointSlices", func() {
+		var rsCfg *ResourceConfig
+
+		newSlice := func(name, portName string, port int32,
+			endpoints ...discoveryv1alpha1.Endpoint) *discoveryv1alpha1.EndpointSlice {
+			return &discoveryv1alpha1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+					Labels:    map[string]string{discoveryv1alpha1.LabelServiceName: "svc1"},
+				},
+				Ports: []discoveryv1alpha1.EndpointPort{
+					{Name: &portName, Port: &port},
+				},
+				Endpoints: endpoints,
+			}
+		}
+		newEndpoint := func(addr, node string, ready bool) discoveryv1alpha1.Endpoint {
+			return discoveryv1alpha1.Endpoint{
+				Addresses:  []string{addr},
+				Conditions: discoveryv1alpha1.EndpointConditions{Ready: &ready},
+				Topology:   map[string]string{v1.LabelHostname: node},
+			}
+		}
+
+		BeforeEach(func() {
+			mockCRM.UseEndpointSlices = true
+			mockCRM.oldNodes = []Node{
+				{Name: "node1", Addr: "10.0.0.1"},
+				{Name: "node2", Addr: "10.0.0.2"},
+			}
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}}))
+			rsCfg = &ResourceConfig{}
+			rsCfg.Pools = Pools{{Name: "default_svc1_80", ServiceName: "svc1",
+				ServicePort: 80}}
+		})
+
+		It("Detects whether EndpointSlices are served", func() {
+			Expect(mockCRM.isEndpointSliceServed()).To(BeFalse())
+
+			fakeClient := mockCRM.kubeClient.(*k8sfake.Clientset)
+			fakeClient.Resources = []*metav1.APIResourceList{{
+				GroupVersion: discoveryv1alpha1.SchemeGroupVersion.String(),
+				APIResources: []metav1.APIResource{{Name: "endpointslices"}},
+			}}
+			Expect(mockCRM.isEndpointSliceServed()).To(BeTrue())
+		})
+
+		It("Uses the informer of EndpointSlices instead of Endpoints", func() {
+			Expect(mockCRM.addNamespacedInformer("default")).To(BeNil())
+			crInf, _ := mockCRM.getNamespaceInformer("default")
+			Expect(crInf.sliceInformer).NotTo(BeNil())
+			Expect(crInf.epsInformer).To(BeNil())
+		})
+
+		It("Populates the pool members from all the EndpointSlices", func() {
+			mockCRM.addEndpointSlice(newSlice("svc1-a", "http", 8080,
+				newEndpoint("10.1.0.3", "node1", true),
+				newEndpoint("10.1.0.1", "node2", true),
+				newEndpoint("10.1.0.4", "node1", false)))
+			mockCRM.addEndpointSlice(newSlice("svc1-b", "http", 8080,
+				newEndpoint("10.1.0.2", "node2", true),
+				newEndpoint("10.1.0.1", "node2", true),
+				newEndpoint("10.1.0.5", "node3", true)))
+			mockCRM.addEndpointSlice(newSlice("svc1-c", "https", 8443,
+				newEndpoint("10.1.0.6", "node1", true)))
+
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.3", Port: 8080, Session: "user-enabled"},
+			}), "Members should be sorted without duplicates, "+
+				"not ready endpoints and endpoints of unknown nodes")
+		})
+
+		It("Populates the pool members of dual-stack services", func() {
+			mockCRM.addEndpointSlice(newSlice("svc1-v4", "http", 8080,
+				newEndpoint("10.1.0.1", "node1", true)))
+			mockCRM.addEndpointSlice(newSlice("svc1-v6", "http", 8080,
+				newEndpoint("FD00:10:1::1", "node1", true),
+				newEndpoint("fd00:10:1:0::2", "node2", true)))
+
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "fd00:10:1::1", Port: 8080, Session: "user-enabled"},
+				{Address: "fd00:10:1::2", Port: 8080, Session: "user-enabled"},
+			}))
+
+			rsCfg.Virtual.SetVirtualAddress("2001:db8::5%3", 80)
+			rsCfg.updatePoolMembersRouteDomain()
+			Expect(rsCfg.Pools[0].Members[1].Address).To(Equal("fd00:10:1::1%3"))
+		})
+
+		It("Gets the service of an EndpointSlice", func() {
+			slice := newSlice("svc1-a", "http", 8080)
+			svc := mockCRM.syncEndpointSlice(slice)
+			Expect(svc).NotTo(BeNil())
+			Expect(svc.ObjectMeta.Name).To(Equal("svc1"))
+
+			delete(slice.ObjectMeta.Labels, discoveryv1alpha1.LabelServiceName)
+			Expect(mockCRM.syncEndpointSlice(slice)).To(BeNil())
+		})
+	})
+
+	Context("Pool member type", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			mockCRM.ControllerMode = NodePortMode
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80),
+					PoolMemberType: ClusterMode},
+			}
+			for _, name := range []string{"svc1", "svc2"} {
+				mockCRM.addService(test.NewService(name, "1", "default",
+					v1.ServiceTypeNodePort, []v1.ServicePort{
+						{Name: "http", Port: 80, NodePort: 30080}}))
+				mockCRM.addEndpoints(test.NewEndpoints(name, "1", "node1",
+					"default", []string{"10.1.0.1"}, nil,
+					[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+			}
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getMembers := func(poolName string) []Member {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			for _, pool := range rsCfg.Pools {
+				if pool.Name == poolName {
+					return pool.Members
+				}
+			}
+			Fail("Pool " + poolName + " not found")
+			return nil
+		}
+		nodeMember := Member{Address: "10.0.0.1", Port: 30080,
+			Session: "user-enabled"}
+		podMember := Member{Address: "10.1.0.1", Port: 8080,
+			Session: "user-enabled"}
+
+		It("Populates the members of each pool for its member type", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getMembers("default_svc1_80")).To(Equal([]Member{nodeMember}))
+			Expect(getMembers("default_svc2_80")).To(Equal([]Member{podMember}))
+		})
+
+		It("Replaces the members when the member type changes", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].PoolMemberType = ClusterMode
+			newVS.Spec.Pools[1].PoolMemberType = NodePortMode
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getMembers("default_svc1_80")).To(Equal([]Member{podMember}))
+			Expect(getMembers("default_svc2_80")).To(Equal([]Member{nodeMember}))
+		})
+
+		It("Rejects pools of a service with different member types", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Pools[1].Service = "svc1"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+
+			vs.Spec.Pools[1].Service = "svc2"
+			vs.Spec.Pools[1].PoolMemberType = "pod"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+		})
+	})
+
+	Context("Pod readiness", func() {
+		var rsCfg *ResourceConfig
+		var pod *v1.Pod
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			svc := test.NewService("svc1", "1", "default", v1.ServiceTypeClusterIP,
+				[]v1.ServicePort{{Name: "http", Port: 80,
+					TargetPort: intstr.FromString("web")}})
+			svc.Spec.Selector = map[string]string{"app": "svc1"}
+			mockCRM.addService(svc)
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", []string{"10.1.0.1"}, []string{"10.1.0.2"},
+				[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+			now := metav1.Now()
+			pod = &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "svc1-pod",
+					Namespace:         "default",
+					Labels:            map[string]string{"app": "svc1"},
+					DeletionTimestamp: &now,
+				},
+				Spec: v1.PodSpec{
+					NodeName: "node1",
+					Containers: []v1.Container{{Ports: []v1.ContainerPort{
+						{Name: "web", ContainerPort: 8080}}}},
+				},
+				Status: v1.PodStatus{PodIP: "10.1.0.3"},
+			}
+			rsCfg = &ResourceConfig{}
+			rsCfg.Pools = Pools{{Name: "default_svc1_80", ServiceName: "svc1",
+				ServicePort: 80}}
+		})
+
+		readyMember := Member{Address: "10.1.0.1", Port: 8080,
+			Session: "user-enabled"}
+
+		It("Adds only the ready endpoints by default", func() {
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{readyMember}))
+		})
+
+		It("Adds the endpoints which are not ready with includeNotReady", func() {
+			rsCfg.Pools[0].IncludeNotReady = true
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{readyMember,
+				{Address: "10.1.0.2", Port: 8080, Session: "user-enabled"}}))
+		})
+
+		It("Drains the members of terminating pods", func() {
+			mockCRM.addPod(pod)
+			rsCfg.MetaData.Active = true
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			drainingMember := Member{Address: "10.1.0.3", Port: 8080,
+				Session: "user-disabled", AdminState: "disable"}
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{readyMember,
+				drainingMember}))
+
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			as3Pool := sharedApp["default_svc1_80"].(*as3Pool)
+			Expect(as3Pool.Members[1].AdminState).To(Equal("disable"))
+
+			rsCfgs := ResourceConfigs{rsCfg}
+			Expect(rsCfgs.GetAllPoolMembers(nil)).To(ContainElement(drainingMember),
+				"Draining members should have ARP entries")
+		})
+
+		It("Enqueues the services of terminating and deleted pods", func() {
+			oldPod := pod.DeepCopy()
+			oldPod.ObjectMeta.DeletionTimestamp = nil
+			mockCRM.enqueueUpdatedPod(oldPod, pod)
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].kind).To(Equal(Service))
+			Expect(keys[0].rscName).To(Equal("svc1"))
+
+			mockCRM.enqueueUpdatedPod(pod, pod)
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+
+			mockCRM.enqueueDeletedPod(pod)
+			Expect(len(mockCRM.drainQueue())).To(Equal(1))
+		})
+	})
+
+	Context("Backup pool", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{{
+				Path:        "/foo",
+				Service:     "svc1",
+				ServicePort: intstr.FromInt(80),
+				BackupPool: &cisapiv1.BackupPool{
+					Addresses: []string{"192.168.1.1", "192.168.1.2"},
+					Port:      8080,
+				},
+			}}
+			for _, name := range []string{"svc1", "svc2"} {
+				mockCRM.addService(test.NewService(name, "1", "default",
+					v1.ServiceTypeClusterIP, []v1.ServicePort{
+						{Name: "http", Port: 80}}))
+			}
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getPool := func() Pool {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			return rsCfg.Pools[0]
+		}
+		addEndpoints := func(name, ip string) {
+			mockCRM.addEndpoints(test.NewEndpoints(name, "1", "node1",
+				"default", []string{ip}, nil,
+				[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+		}
+		backupMembers := []Member{
+			{Address: "192.168.1.1", Port: 8080, Session: "user-enabled",
+				Static: true},
+			{Address: "192.168.1.2", Port: 8080, Session: "user-enabled",
+				Static: true},
+		}
+
+		It("Keeps the virtual active with backup members only", func() {
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", nil, nil, nil))
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			pool := getPool()
+			Expect(pool.Members).To(Equal(backupMembers))
+			Expect(pool.MinActiveMembers).To(Equal(int32(1)))
+		})
+
+		It("Adds the members of the service with a higher priority group", func() {
+			addEndpoints("svc1", "10.1.0.1")
+			vs.Spec.Pools[0].PriorityGroup = 10
+			vs.Spec.Pools[0].MinActiveMembers = 2
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			pool := getPool()
+			Expect(pool.Members).To(Equal(append([]Member{{Address: "10.1.0.1",
+				Port: 8080, Session: "user-enabled", PriorityGroup: 10}},
+				backupMembers...)))
+
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			as3Pool := sharedApp[pool.Name].(*as3Pool)
+			Expect(as3Pool.MinimumMembersActive).To(Equal(int32(2)))
+			Expect(as3Pool.Members[0].PriorityGroup).To(Equal(int32(10)))
+			Expect(as3Pool.Members[1].PriorityGroup).To(Equal(int32(0)))
+
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(len(getPool().Members)).To(Equal(3),
+				"Members should not be added twice")
+		})
+
+		It("Adds the members of a backup service", func() {
+			addEndpoints("svc1", "10.1.0.1")
+			addEndpoints("svc2", "10.1.0.2")
+			vs.Spec.Pools[0].BackupPool = &cisapiv1.BackupPool{
+				Service:     "svc2",
+				ServicePort: 80,
+			}
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getPool().Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled",
+					PriorityGroup: 1},
+				{Address: "10.1.0.2", Port: 8080, Session: "user-enabled"},
+			}))
+			svc2 := test.NewService("svc2", "1", "default",
+				v1.ServiceTypeClusterIP, nil)
+			Expect(getVirtualServersForService([]*cisapiv1.VirtualServer{vs},
+				svc2)).To(Equal([]*cisapiv1.VirtualServer{vs}))
+		})
+
+		It("Rejects invalid backup pools", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			for _, bp := range []cisapiv1.BackupPool{
+				{},
+				{Service: "svc2", ServicePort: 80, Addresses: []string{"1.1.1.1"}},
+				{Service: "svc2"},
+				{Addresses: []string{"1.1.1.1"}},
+				{Addresses: []string{"host"}, Port: 80},
+			} {
+				backupPool := bp
+				vs.Spec.Pools[0].BackupPool = &backupPool
+				Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse(),
+					"%+v should be rejected", bp)
+			}
+		})
+	})
+
+	Context("Service down action", func() {
+		BeforeEach(func() {
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80),
+					ServiceDownAction: ServiceDownActionReselect},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+		})
+
+		It("Sets the service down action of the pools", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			// Defaulted by the CRD schema rather than by the controller
+			Expect(rsCfg.Pools[0].ServiceDownAction).To(BeEmpty())
+			Expect(rsCfg.Pools[1].ServiceDownAction).To(Equal(ServiceDownActionReselect))
+
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			as3Pool := sharedApp[rsCfg.Pools[1].Name].(*as3Pool)
+			Expect(as3Pool.ServiceDownAction).To(Equal("reselect"))
+		})
+
+		It("Rejects invalid service down actions", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Pools[1].ServiceDownAction = "restart"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+			events := mockCRM.getFakeEvents("default")
+			Expect(events[len(events)-1].Message).To(ContainSubstring(
+				"Invalid serviceDownAction 'restart'"))
+		})
+	})
+
+	Context("Pool monitors", func() {
+		var oldPartition string
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Monitors: []string{"/Common/custom_http_200",
+						" tcp_half_open"},
+					MinimumMonitors: 1},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80),
+					Monitor: &cisapiv1.Monitor{Type: MonitorHTTP,
+						Send: "GET /healthz HTTP/1.0\\r\\n\\r\\n", Recv: "200",
+						Interval: 5, Timeout: 16}},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		It("Sets the monitors of the pools", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(rsCfg.Pools[0].MonitorNames).To(Equal([]string{
+				"/Common/custom_http_200", "/test/tcp_half_open"}))
+			Expect(rsCfg.Pools[0].Monitor).To(BeNil())
+			Expect(rsCfg.Pools[1].MonitorNames).To(BeEmpty())
+			Expect(rsCfg.Pools[1].Monitor).NotTo(BeNil())
+
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			pool := sharedApp[rsCfg.Pools[0].Name].(*as3Pool)
+			Expect(pool.Monitors).To(Equal([]as3ResourcePointer{
+				{BigIP: "/Common/custom_http_200"},
+				{BigIP: "/test/tcp_half_open"},
+			}))
+			Expect(pool.MinimumMonitors).To(BeEquivalentTo(1))
+
+			monitorName := AS3NameFormatter(rsCfg.Pools[1].Name + "_monitor")
+			pool = sharedApp[rsCfg.Pools[1].Name].(*as3Pool)
+			Expect(pool.Monitors).To(Equal([]as3ResourcePointer{
+				{Use: monitorName}}))
+			Expect(pool.MinimumMonitors).To(BeNil())
+			Expect(sharedApp[monitorName]).To(Equal(&as3Monitor{
+				Class:       "Monitor",
+				MonitorType: MonitorHTTP,
+				Interval:    5,
+				Timeout:     16,
+				Send:        "GET /healthz HTTP/1.0\\r\\n\\r\\n",
+				Receive:     "200",
+			}))
+		})
+
+		It("Checks the target of the monitor", func() {
+			vs.Spec.Pools[1].Monitor.TargetAddress = "[2001:DB8::10]"
+			vs.Spec.Pools[1].Monitor.TargetPort = 8080
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			monitorName := AS3NameFormatter(rsCfg.Pools[1].Name + "_monitor")
+			monitor := sharedApp[monitorName].(*as3Monitor)
+			Expect(*monitor.TargetAddress).To(Equal("2001:db8::10"))
+			Expect(*monitor.TargetPort).To(Equal(8080))
+		})
+
+		It("Requires all the monitors up by default", func() {
+			vs.Spec.Pools[0].MinimumMonitors = 0
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			sharedApp := as3Application{}
+			createPoolDecl(rsCfg, sharedApp)
+			Expect(sharedApp[rsCfg.Pools[0].Name].(*as3Pool).MinimumMonitors).To(
+				Equal("all"))
+		})
+
+		It("Rejects the invalid monitors", func() {
+			for _, update := range []func(pl *cisapiv1.Pool){
+				func(pl *cisapiv1.Pool) {
+					pl.Monitor = &cisapiv1.Monitor{Type: MonitorTCP}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Monitors = []string{"/Common/folder/http/x"}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Monitors = []string{"/Common/http", "Common/http"}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.MinimumMonitors = 3
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Monitors = nil
+					pl.Monitor = &cisapiv1.Monitor{Type: "icmp"}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Monitors = nil
+					pl.Monitor = &cisapiv1.Monitor{Type: MonitorTCP,
+						TargetAddress: "10.1.1"}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Monitors = nil
+					pl.Monitor = &cisapiv1.Monitor{Type: MonitorTCP,
+						TargetPort: 65536}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.Service = ""
+					pl.Action = &cisapiv1.PoolAction{Type: PoolActionReset}
+				},
+			} {
+				invalid := vs.DeepCopy()
+				update(&invalid.Spec.Pools[0])
+				Expect(ValidateVirtualServer(invalid,
+					mockCRM.validationOptions())).To(HaveOccurred(), "%+v",
+					invalid.Spec.Pools[0])
+			}
+			vs.Spec.Pools[0].Monitor = &cisapiv1.Monitor{Type: MonitorTCP}
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+			events := mockCRM.getFakeEvents("default")
+			Expect(events[len(events)-1].Message).To(ContainSubstring(
+				"cannot have both a monitor and monitors"))
+		})
+	})
+
+	Context("Static members", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					StaticMembers: []cisapiv1.StaticMember{
+						{Address: "192.168.1.1", Port: 80}}},
+				{Path: "/legacy", StaticMembers: []cisapiv1.StaticMember{
+					{Address: "192.168.2.1%0", Port: 8080}}},
+			}
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}}))
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", []string{"10.1.0.1"}, nil,
+				[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getConfig := func() *ResourceConfig {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(len(rsCfg.Pools)).To(Equal(2))
+			return rsCfg
+		}
+		staticMember := Member{Address: "192.168.1.1", Port: 80,
+			Session: "user-enabled", Static: true}
+
+		It("Keeps the static members with the members of the service", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				staticMember,
+			}))
+
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "2", "node1",
+				"default", []string{"10.1.0.2"}, nil,
+				[]v1.EndpointPort{{Name: "http", Port: 8080}}))
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.2", Port: 8080, Session: "user-enabled"},
+				staticMember,
+			}))
+		})
+
+		It("Creates a pool of static members without service", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg := getConfig()
+			pool := rsCfg.Pools[1]
+			Expect(pool.Name).To(HavePrefix("default_static_"))
+			Expect(pool.Members).To(Equal([]Member{{Address: "192.168.2.1%0",
+				Port: 8080, Session: "user-enabled", Static: true}}))
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			var rulePools []string
+			for _, rl := range rsCfg.Policies[0].Rules {
+				rulePools = append(rulePools, rl.Actions[0].Pool)
+			}
+			Expect(rulePools).To(ContainElement(HaveSuffix(pool.Name)))
+		})
+
+		It("Adds static members in the pod networks only to ARP entries", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfgs := ResourceConfigs{getConfig()}
+			_, podNetwork, _ := net.ParseCIDR("10.1.0.0/16")
+			Expect(rsCfgs.GetAllPoolMembers([]*net.IPNet{podNetwork})).To(
+				Equal([]Member{{Address: "10.1.0.1", Port: 8080,
+					Session: "user-enabled"}}))
+			_, staticNetwork, _ := net.ParseCIDR("192.168.0.0/16")
+			Expect(len(rsCfgs.GetAllPoolMembers([]*net.IPNet{podNetwork,
+				staticNetwork}))).To(Equal(3))
+		})
+
+		It("Rejects invalid static members", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			for _, m := range []cisapiv1.StaticMember{
+				{Address: "legacy-vm", Port: 80},
+				{Address: "192.168.1.1%x", Port: 80},
+				{Address: "192.168.1.1", Port: 0},
+			} {
+				vs.Spec.Pools[1].StaticMembers = []cisapiv1.StaticMember{m}
+				Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse(),
+					"%+v should be rejected", m)
+			}
+		})
+
+		It("Rejects static members in another route domain", func() {
+			vs.Spec.Pools[1].StaticMembers = []cisapiv1.StaticMember{
+				{Address: "192.168.2.1%2", Port: 8080}}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			_, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeFalse())
+			events := mockCRM.getFakeEvents("default")
+			Expect(events[len(events)-1].Reason).To(Equal("InvalidData"))
+			Expect(events[len(events)-1].Message).To(ContainSubstring(
+				"not in route domain 0"))
+
+			vs.Spec.VirtualServerAddress = "1.2.3.4%2"
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1%2", Port: 8080, Session: "user-enabled"},
+				{Address: "192.168.1.1%2", Port: 80, Session: "user-enabled",
+					Static: true},
+			}))
+			Expect(rsCfg.Pools[1].Members[0].Address).To(Equal("192.168.2.1%2"))
+		})
+	})
+
+	Context("Named service ports", func() {
+		var rsName string
+		svcPorts := []v1.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+			{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090)},
+		}
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1",
+					ServicePort: intstr.FromString("http")},
+			}
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, svcPorts))
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", []string{"10.1.0.1"}, nil,
+				[]v1.EndpointPort{{Name: "http", Port: 8080},
+					{Name: "metrics", Port: 9090}}))
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		getPool := func() Pool {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			Expect(len(rsCfg.Pools)).To(Equal(1))
+			return rsCfg.Pools[0]
+		}
+
+		It("Resolves the named port of the service", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			pool := getPool()
+			Expect(pool.ServicePortName).To(Equal("http"))
+			Expect(pool.Members).To(Equal([]Member{{Address: "10.1.0.1",
+				Port: 8080, Session: "user-enabled"}}))
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+		})
+
+		It("Keeps resolving the port number", func() {
+			vs.Spec.Pools[0].ServicePort = intstr.FromInt(9090)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getPool().Members).To(Equal([]Member{{Address: "10.1.0.1",
+				Port: 9090, Session: "user-enabled"}}))
+		})
+
+		It("Leaves the pool without members for an unknown port", func() {
+			mockCRM.addService(test.NewService("svc1", "2", "default",
+				v1.ServiceTypeClusterIP, svcPorts[1:]))
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getPool().Members).To(BeNil())
+			events := mockCRM.getFakeEvents("default")
+			Expect(len(events)).To(Equal(1))
+			Expect(events[0].EventType).To(Equal(v1.EventTypeWarning))
+			Expect(events[0].Reason).To(Equal("ServicePortNotFound"))
+		})
+
+		It("Resolves the named port of a NodePort service", func() {
+			mockCRM.ControllerMode = NodePortMode
+			nodePorts := []v1.ServicePort{
+				{Name: "metrics", Port: 9090, NodePort: 30090},
+				{Name: "http", Port: 80, NodePort: 30080},
+			}
+			mockCRM.addService(test.NewService("svc1", "2", "default",
+				v1.ServiceTypeNodePort, nodePorts))
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getPool().Members).To(Equal([]Member{{Address: "10.0.0.1",
+				Port: 30080, Session: "user-enabled"}}))
+		})
+
+		It("Rejects an invalid port name", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Pools[0].ServicePort = intstr.FromString("http_port")
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+		})
+	})
+
+	Context("Target port resolution", func() {
+		var rsCfg *ResourceConfig
+		var svc *v1.Service
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			svc = test.NewService("svc1", "1", "default", v1.ServiceTypeClusterIP,
+				[]v1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromString("web")},
+					{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9091)},
+				})
+			svc.Spec.Selector = map[string]string{"app": "svc1"}
+			mockCRM.addService(svc)
+			rsCfg = &ResourceConfig{}
+			rsCfg.Pools = Pools{{Name: "default_svc1_80", ServiceName: "svc1",
+				ServicePort: 80}}
+		})
+
+		// addEndpoints adds the endpoints of the pods resolving the named
+		// target port to different container ports
+		addEndpoints := func() {
+			eps := test.NewEndpoints("svc1", "1", "node1", "default",
+				[]string{"10.1.0.1"}, nil, []v1.EndpointPort{
+					{Name: "http", Port: 8080}, {Name: "metrics", Port: 9091}})
+			eps.Subsets = append(eps.Subsets, test.NewEndpoints("svc1", "1",
+				"node1", "default", []string{"10.1.0.2"}, nil,
+				[]v1.EndpointPort{{Name: "http", Port: 8081},
+					{Name: "metrics", Port: 9091}}).Subsets...)
+			mockCRM.addEndpoints(eps)
+		}
+
+		It("Resolves the numeric target port", func() {
+			addEndpoints()
+			rsCfg.Pools[0].ServicePort = 9090
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 9091, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 9091, Session: "user-enabled"},
+			}))
+		})
+
+		It("Resolves the named target port of each pod", func() {
+			addEndpoints()
+			now := metav1.Now()
+			mockCRM.addPod(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "svc1-pod",
+					Namespace:         "default",
+					Labels:            map[string]string{"app": "svc1"},
+					DeletionTimestamp: &now,
+				},
+				Spec: v1.PodSpec{
+					NodeName: "node1",
+					Containers: []v1.Container{{Ports: []v1.ContainerPort{
+						{Name: "web", ContainerPort: 8082}}}},
+				},
+				Status: v1.PodStatus{PodIP: "10.1.0.3"},
+			})
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 8081, Session: "user-enabled"},
+				{Address: "10.1.0.3", Port: 8082, Session: "user-disabled",
+					AdminState: "disable"},
+			}), "Each member should carry the port of its pod")
+		})
+
+		It("Resolves the named target port of each pod from EndpointSlices", func() {
+			// The informer of the namespace is created again with
+			// EndpointSlices
+			delete(mockCRM.crInformers, "default")
+			mockCRM.UseEndpointSlices = true
+			mockCRM.addService(svc)
+			ready := true
+			for i, port := range []int32{8080, 8081} {
+				name, port := "http", port
+				mockCRM.addEndpointSlice(&discoveryv1alpha1.EndpointSlice{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      fmt.Sprintf("svc1-%d", i),
+						Namespace: "default",
+						Labels: map[string]string{
+							discoveryv1alpha1.LabelServiceName: "svc1"},
+					},
+					Ports: []discoveryv1alpha1.EndpointPort{
+						{Name: &name, Port: &port},
+					},
+					Endpoints: []discoveryv1alpha1.Endpoint{{
+						Addresses:  []string{fmt.Sprintf("10.1.0.%d", i+1)},
+						Conditions: discoveryv1alpha1.EndpointConditions{Ready: &ready},
+					}},
+				})
+			}
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 8081, Session: "user-enabled"},
+			}))
+		})
+
+		It("Merges the members of all the ports of the service", func() {
+			addEndpoints()
+			rsCfg.Pools[0].ServicePort = 0
+			mockCRM.updatePoolMembers(rsCfg, "default")
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 8081, Session: "user-enabled"},
+				{Address: "10.1.0.1", Port: 9091, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 9091, Session: "user-enabled"},
+			}))
+		})
+	})
+
+	Context("Active configs", func() {
+		var rsName string
+		var svc *v1.Service
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			svc = test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}})
+			mockCRM.addService(svc)
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		setEndpoints := func(ips ...string) {
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", ips, nil, []v1.EndpointPort{{Name: "http", Port: 8080}}))
+		}
+
+		It("Reports the virtual losing and getting its pool members", func() {
+			setEndpoints("10.1.0.1")
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			Expect(rsCfg.Virtual.Enabled).To(BeTrue())
+			Expect(mockCRM.getFakeEvents("default")).To(BeEmpty())
+
+			setEndpoints()
+			mockCRM.updatePoolMembersForService(svc)
+			Expect(rsCfg.MetaData.Active).To(BeFalse())
+			// Left enabled unless DisableInactive
+			Expect(rsCfg.Virtual.Enabled).To(BeTrue())
+			events := mockCRM.getFakeEvents("default")
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Reason).To(Equal("NoPoolMembers"))
+			Expect(events[0].EventType).To(Equal(v1.EventTypeNormal))
+			Expect(mockCRM.getVirtualServerStatus(vs).Status).To(
+				Equal(StatusDegraded))
+
+			// Reported once
+			mockCRM.updatePoolMembersForService(svc)
+			Expect(mockCRM.getFakeEvents("default")).To(HaveLen(1))
+
+			setEndpoints("10.1.0.2")
+			mockCRM.updatePoolMembersForService(svc)
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			events = mockCRM.getFakeEvents("default")
+			Expect(events).To(HaveLen(2))
+			Expect(events[1].Reason).To(Equal("PoolMembersAvailable"))
+			Expect(mockCRM.getVirtualServerStatus(vs).Status).To(
+				Equal(StatusReady))
+		})
+
+		It("Keeps the virtual with a default pool or no pools active", func() {
+			setEndpoints()
+			vs.Spec.DefaultPool = &cisapiv1.DefaultPool{Service: "svc1",
+				ServicePort: 80}
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+
+			Expect((&ResourceConfig{}).isActive()).To(BeTrue())
+		})
+
+		It("Disables the inactive virtuals", func() {
+			mockCRM.DisableInactive = true
+			setEndpoints()
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.Active).To(BeFalse())
+			Expect(rsCfg.Virtual.Enabled).To(BeFalse())
+			sharedApp := as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			svcDecl := sharedApp[rsCfg.Virtual.Name].(*as3Service)
+			Expect(svcDecl.Enable).NotTo(BeNil())
+			Expect(*svcDecl.Enable).To(BeFalse())
+
+			setEndpoints("10.1.0.1")
+			mockCRM.updatePoolMembersForService(svc)
+			Expect(rsCfg.Virtual.Enabled).To(BeTrue())
+			sharedApp = as3Application{}
+			createServiceDecl(rsCfg, sharedApp)
+			Expect(sharedApp[rsCfg.Virtual.Name].(*as3Service).Enable).To(BeNil())
+		})
+
+		It("Keeps the disabled virtual disabled with pool members", func() {
+			setEndpoints("10.1.0.1")
+			vs.Spec.DisableVirtualServer = true
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.MetaData.Active).To(BeTrue())
+			Expect(rsCfg.Virtual.Enabled).To(BeFalse())
+			mockCRM.updatePoolMembersForService(svc)
+			Expect(rsCfg.Virtual.Enabled).To(BeFalse())
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.DisableVirtualServer = false
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg, _ = mockCRM.resources.GetByName("test", rsName)
+			Expect(rsCfg.Virtual.Enabled).To(BeTrue())
+		})
+	})
+
+	Context("Node member labels", func() {
+		var rsName string
+		var nodes map[string]*v1.Node
+
+		// setNode creates or updates the node in the API server, the
+		// informer handler gets the old and the new node
+		setNode := func(node *v1.Node) (*v1.Node, *v1.Node) {
+			old := nodes[node.ObjectMeta.Name]
+			nodes[node.ObjectMeta.Name] = node
+			nodeClient := mockCRM.kubeClient.CoreV1().Nodes()
+			if nil == old {
+				_, _ = nodeClient.Create(node)
+			} else {
+				_, _ = nodeClient.Update(node)
+			}
+			return old, node
+		}
+		newNode := func(name, addr string, nodeLabels map[string]string) *v1.Node {
+			return &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+				Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+					{Type: v1.NodeExternalIP, Address: addr}}},
+			}
+		}
+		members := func() []string {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			var addrs []string
+			for _, member := range rsCfg.Pools[0].Members {
+				addrs = append(addrs, member.Address)
+			}
+			return addrs
+		}
+		poolName := func() string {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg.Pools[0].Name
+		}
+		web := map[string]string{"pool": "web"}
+
+		BeforeEach(func() {
+			nodes = make(map[string]*v1.Node)
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					PoolMemberType: NodePortMode, NodeMemberLabel: "pool=web"},
+			}
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeNodePort, []v1.ServicePort{
+					{Name: "http", Port: 80, NodePort: 30080}}))
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			setNode(newNode("node1", "10.0.0.1", web))
+			setNode(newNode("node2", "10.0.0.2", nil))
+		})
+
+		It("Processes the VirtualServers when a node gets or loses the label", func() {
+			Expect(members()).To(Equal([]string{"10.0.0.1"}))
+
+			mockCRM.enqueueUpdatedNode(setNode(newNode("node2", "10.0.0.2", web)))
+			keys := mockCRM.drainQueue()
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0].rscName).To(Equal(vs.ObjectMeta.Name))
+			Expect(members()).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+
+			// Other labels do not matter
+			mockCRM.enqueueUpdatedNode(setNode(newNode("node1", "10.0.0.1",
+				map[string]string{"pool": "web", "zone": "a"})))
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+
+			mockCRM.enqueueUpdatedNode(setNode(newNode("node1", "10.0.0.1", nil)))
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			Expect(members()).To(Equal([]string{"10.0.0.2"}))
+
+			mockCRM.enqueueNewNode(newNode("node3", "10.0.0.3", nil))
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+			mockCRM.enqueueNewNode(newNode("node4", "10.0.0.4", web))
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+		})
+
+		It("Processes the VirtualServers when a selected node is deleted", func() {
+			Expect(mockCRM.kubeClient.CoreV1().Nodes().Delete("node1",
+				nil)).To(Succeed())
+			mockCRM.enqueueDeletedNode(nodes["node1"])
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			Expect(members()).To(BeEmpty())
+
+			mockCRM.enqueueDeletedNode(cache.DeletedFinalStateUnknown{
+				Key: "node1", Obj: nodes["node1"]})
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			mockCRM.enqueueDeletedNode(nodes["node2"])
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+		})
+
+		It("Excludes the unhealthy nodes with FilterUnhealthy", func() {
+			notReady := newNode("node1", "10.0.0.1", web)
+			notReady.Spec.Taints = []v1.Taint{{Key: taintNodeNotReady,
+				Effect: v1.TaintEffectNoSchedule}}
+			unschedulable := newNode("node2", "10.0.0.2", web)
+			unschedulable.Spec.Unschedulable = true
+			setNode(unschedulable)
+
+			// Unhealthy nodes are members by default
+			mockCRM.enqueueUpdatedNode(setNode(notReady))
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+			Expect(members()).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+
+			mockCRM.FilterUnhealthy = true
+			Expect(members()).To(BeEmpty())
+			mockCRM.enqueueUpdatedNode(setNode(newNode("node1", "10.0.0.1", web)))
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			Expect(members()).To(Equal([]string{"10.0.0.1"}))
+		})
+
+		It("Selects the nodes with a nodeMemberSelector", func() {
+			// The nodes are read from the informer cache
+			mockCRM.nodeInformer = mockCRM.newNodeInformer()
+			for _, node := range nodes {
+				Expect(mockCRM.nodeInformer.GetStore().Add(node)).To(Succeed())
+			}
+			cacheNode := func(node *v1.Node) (*v1.Node, *v1.Node) {
+				old := nodes[node.ObjectMeta.Name]
+				nodes[node.ObjectMeta.Name] = node
+				Expect(mockCRM.nodeInformer.GetStore().Update(node)).To(Succeed())
+				return old, node
+			}
+			Expect(members()).To(Equal([]string{"10.0.0.1"}))
+			legacyName := poolName()
+			Expect(legacyName).To(Equal("default_svc1_80_pool_web"))
+			// The VirtualServers are copied as updated, to keep the index
+			// of the informer store consistent
+			vs = vs.DeepCopy()
+			vs.Spec.Pools[0].NodeMemberSelector = &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "zone", Operator: metav1.LabelSelectorOpIn,
+						Values: []string{"b", "a"}},
+					{Key: "draining", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			}
+			mockCRM.addVirtualServer(vs)
+			// The nodeMemberLabel applies along with the selector
+			Expect(members()).To(BeEmpty())
+			name := poolName()
+			Expect(name).NotTo(Equal(legacyName))
+			Expect(name).To(MatchRegexp("^default_svc1_80_sel_[0-9a-f]{8}$"))
+
+			mockCRM.enqueueUpdatedNode(cacheNode(newNode("node1", "10.0.0.1",
+				map[string]string{"pool": "web", "zone": "a"})))
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			Expect(members()).To(Equal([]string{"10.0.0.1"}))
+			mockCRM.enqueueUpdatedNode(cacheNode(newNode("node2", "10.0.0.2",
+				map[string]string{"zone": "b"})))
+			Expect(mockCRM.drainQueue()).To(BeEmpty())
+
+			vs = vs.DeepCopy()
+			vs.Spec.Pools[0].NodeMemberLabel = ""
+			mockCRM.addVirtualServer(vs)
+			Expect(members()).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+			mockCRM.enqueueUpdatedNode(cacheNode(newNode("node1", "10.0.0.1",
+				map[string]string{"zone": "a", "draining": "true"})))
+			Expect(mockCRM.drainQueue()).To(HaveLen(1))
+			Expect(members()).To(Equal([]string{"10.0.0.2"}))
+
+			// The same requirements in another order keep the pool name
+			name = poolName()
+			vs = vs.DeepCopy()
+			selector := vs.Spec.Pools[0].NodeMemberSelector
+			selector.MatchExpressions[0], selector.MatchExpressions[1] =
+				selector.MatchExpressions[1], selector.MatchExpressions[0]
+			selector.MatchExpressions[1].Values = []string{"a", "b"}
+			mockCRM.addVirtualServer(vs)
+			Expect(members()).To(Equal([]string{"10.0.0.2"}))
+			Expect(poolName()).To(Equal(name))
+		})
+
+		It("Rejects the invalid nodeMemberSelectors", func() {
+			vs.Spec.Pools[0].NodeMemberSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"zone": "a"}}
+			Expect(ValidateVirtualServer(vs,
+				mockCRM.validationOptions())).To(Succeed())
+			for _, update := range []func(pl *cisapiv1.Pool){
+				func(pl *cisapiv1.Pool) {
+					pl.NodeMemberSelector.MatchLabels["zone"] = "a b"
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.NodeMemberSelector.MatchExpressions = []metav1.LabelSelectorRequirement{
+						{Key: "zone", Operator: metav1.LabelSelectorOpIn}}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.NodeMemberSelector.MatchExpressions = []metav1.LabelSelectorRequirement{
+						{Key: "zone", Operator: "Equals", Values: []string{"a"}}}
+				},
+				func(pl *cisapiv1.Pool) {
+					pl.NodeMemberLabel = "pool in"
+				},
+			} {
+				invalid := vs.DeepCopy()
+				update(&invalid.Spec.Pools[0])
+				Expect(ValidateVirtualServer(invalid,
+					mockCRM.validationOptions())).To(MatchError(ContainSubstring(
+					"Invalid nodeMemberSelector of path '/foo'")), "%+v",
+					invalid.Spec.Pools[0])
+			}
+		})
+	})
+
+	Context("Multi-port pools", func() {
+		var rsName string
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Service: "svc1", Ports: []cisapiv1.PoolPort{
+					{Path: "/api", ServicePort: intstr.FromInt(8080)},
+					{Path: "/metrics", ServicePort: intstr.FromString("metrics")},
+				}},
+			}
+			mockCRM.addService(test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{
+					{Name: "api", Port: 8080, TargetPort: intstr.FromInt(8080)},
+					{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090)},
+				}))
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", []string{"10.1.0.1"}, nil,
+				[]v1.EndpointPort{{Name: "api", Port: 8080},
+					{Name: "metrics", Port: 9090}}))
+			mockCRM.addVirtualServer(vs)
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		AfterEach(func() {
+			namer = crdNamer{}
+		})
+
+		getConfig := func() *ResourceConfig {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue())
+			return rsCfg
+		}
+		rulePools := func(rsCfg *ResourceConfig) []string {
+			var pools []string
+			for _, rl := range rsCfg.Policies[0].Rules {
+				pools = append(pools, rl.Actions[0].Pool)
+			}
+			return pools
+		}
+
+		It("Creates a pool for each port of the service", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(len(rsCfg.Pools)).To(Equal(2))
+			members := make(map[string][]Member)
+			for _, pl := range rsCfg.Pools {
+				members[pl.Name] = pl.Members
+			}
+			Expect(members).To(Equal(map[string][]Member{
+				"default_svc1_8080": {{Address: "10.1.0.1", Port: 8080,
+					Session: "user-enabled"}},
+				"default_svc1_metrics": {{Address: "10.1.0.1", Port: 9090,
+					Session: "user-enabled"}},
+			}))
+			Expect(rulePools(rsCfg)).To(ConsistOf("default_svc1_8080",
+				"default_svc1_metrics"))
+		})
+
+		It("Deletes the pools of the former names", func() {
+			// Pools named after the service only, as before the port was
+			// in the pool name
+			namer = servicePoolNamer{}
+			vs.Spec.Pools = []cisapiv1.Pool{{Path: "/api", Service: "svc1",
+				ServicePort: intstr.FromInt(8080)}}
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(getConfig().Pools[0].Name).To(Equal("default_svc1"))
+
+			namer = crdNamer{}
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools = []cisapiv1.Pool{{Service: "svc1",
+				Ports: []cisapiv1.PoolPort{
+					{Path: "/api", ServicePort: intstr.FromInt(8080)},
+					{Path: "/metrics", ServicePort: intstr.FromInt(9090)},
+				}}}
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			rsCfg := getConfig()
+			var names []string
+			for _, pl := range rsCfg.Pools {
+				names = append(names, pl.Name)
+			}
+			Expect(names).To(ConsistOf("default_svc1_8080",
+				"default_svc1_9090"))
+			Expect(rulePools(rsCfg)).To(ConsistOf("default_svc1_8080",
+				"default_svc1_9090"))
+		})
+
+		It("Rejects ports along with a path or servicePort", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Pools[0].Path = "/api"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+			vs.Spec.Pools[0].Path = ""
+			vs.Spec.Pools[0].ServicePort = intstr.FromInt(8080)
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+			vs.Spec.Pools[0].ServicePort = intstr.FromInt(DefaultServicePort)
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+		})
+
+		It("Defaults the servicePort of the pools without ports", func() {
+			vs.Spec.Pools = []cisapiv1.Pool{{Path: "/api", Service: "svc1"}}
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			pool := getConfig().Pools[0]
+			Expect(pool.Name).To(Equal("default_svc1_80"))
+			Expect(pool.ServicePort).To(Equal(int32(DefaultServicePort)))
+		})
+
+		It("Rejects several ports of a service with legacy names", func() {
+			namer = legacyNamer{}
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+		})
+	})
+
+	Context("Pool member updates", func() {
+		It("Updates the members of the pools of the service in place", func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			svc := test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}})
+			mockCRM.addService(svc)
+			mockCRM.addService(test.NewService("svc2", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}}))
+			ports := []v1.EndpointPort{{Name: "http", Port: 8080}}
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "1", "node1",
+				"default", []string{"10.1.0.1"}, nil, ports))
+			mockCRM.addEndpoints(test.NewEndpoints("svc2", "1", "node1",
+				"default", []string{"10.2.0.1"}, nil, ports))
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			mockCRM.resources.updateOldConfig()
+			rsName := mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+			rsCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			otherPool := rsCfg.Pools[1]
+
+			mockCRM.addEndpoints(test.NewEndpoints("svc1", "2", "node1",
+				"default", []string{"10.1.0.1", "10.1.0.2"}, nil, ports))
+			mockCRM.updatePoolMembersForService(svc)
+			updatedCfg, _ := mockCRM.resources.GetByName("test", rsName)
+			Expect(updatedCfg).To(BeIdenticalTo(rsCfg))
+			Expect(rsCfg.Pools[0].Members).To(Equal([]Member{
+				{Address: "10.1.0.1", Port: 8080, Session: "user-enabled"},
+				{Address: "10.1.0.2", Port: 8080, Session: "user-enabled"},
+			}))
+			Expect(rsCfg.Pools[1]).To(Equal(otherPool))
+
+			diffs := mockCRM.resources.getConfigDiffs()
+			Expect(diffs).To(Equal(map[NameRef]ResourceConfigDiff{
+				{Name: rsName, Partition: "test"}: {
+					PoolMembers: []string{"default_svc1_80"}},
+			}))
+		})
+	})
+
+	Context("Pending services", func() {
+		var svcDep ObjectDependency
+		var ports []v1.EndpointPort
+
+		BeforeEach(func() {
+			mockCRM.oldNodes = []Node{{Name: "node1", Addr: "10.0.0.1"}}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			mockCRM.addVirtualServer(vs)
+			svcDep = serviceDependency("default", "svc1")
+			ports = []v1.EndpointPort{{Name: "http", Port: 8080}}
+		})
+
+		It("Waits for the service until its pool has members", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(HaveKey(svcDep))
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			events := mockCRM.getFakeEvents("default")
+			Expect(events[0].Reason).To(Equal("WaitingForService"))
+			Expect(events[0].Message).To(Equal("Waiting for service svc1"))
+			waiting := 0
+			for _, event := range events {
+				if event.Reason == "WaitingForService" {
+					waiting++
+				}
+			}
+			Expect(waiting).To(Equal(1))
+
+			// The new service enqueues the VirtualServer
+			svc := test.NewService("svc1", "1", "default",
+				v1.ServiceTypeClusterIP, []v1.ServicePort{{Name: "http", Port: 80}})
+			mockCRM.addService(svc)
+			mockCRM.enqueueNewService(svc)
+			keys := mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].kind).To(Equal(VirtualServer))
+			Expect(keys[0].rscName).To(Equal("SampleVS"))
+
+			// Still waiting while the pool has no members
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(HaveKey(svcDep))
+
+			eps := test.NewEndpoints("svc1", "1", "node1", "default",
+				[]string{"10.1.0.1"}, nil, ports)
+			mockCRM.addEndpoints(eps)
+			mockCRM.enqueueNewEndpoints(eps)
+			keys = mockCRM.drainQueue()
+			Expect(len(keys)).To(Equal(1))
+			Expect(keys[0].kind).To(Equal(Endpoints))
+
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(BeEmpty())
+			events = mockCRM.getFakeEvents("default")
+			last := events[len(events)-1]
+			Expect(last.Reason).To(Equal("ServiceReady"))
+			Expect(last.Message).To(Equal("Service svc1 is ready"))
+
+			// Endpoints of services no VirtualServer waits for are skipped
+			mockCRM.enqueueNewEndpoints(eps)
+			Expect(mockCRM.rscQueue.Len()).To(BeZero())
+		})
+
+		It("Stops waiting for the services no longer referred", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(HaveKey(svcDep))
+
+			addServices("default", "svc2")
+			newVS := vs.DeepCopy()
+			newVS.Spec.Pools[0].Service = "svc2"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(BeEmpty())
+		})
+
+		It("Stops waiting when the VirtualServer is deleted", func() {
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(mockCRM.pendingServices).To(HaveKey(svcDep))
+			mockCRM.deletePendingServices(vs)
+			Expect(mockCRM.pendingServices).To(BeEmpty())
+		})
+	})
+
+	Context("Processing workers", func() {
+		var postChan chan config
+
+		BeforeEach(func() {
+			postChan = make(chan config, 1)
+			mockCRM.Agent = &Agent{DeclWriter: &PostManager{postChan: postChan}}
+		})
+
+		It("Flushes the VirtualServers processed by the workers once", func() {
+			var virtuals []*cisapiv1.VirtualServer
+			for i := 0; i < 20; i++ {
+				// VirtualServers in pairs share the address
+				virtuals = append(virtuals, mockCRM.addSecretVirtualServer(
+					i, fmt.Sprintf("10.1.1.%d", i/2)))
+			}
+			mockCRM.processWithWorkers(4)
+			Expect(mockCRM.inFlight).To(BeZero())
+			Expect(mockCRM.flushCh).To(HaveLen(1))
+			Expect(mockCRM.pendingEvents).To(Equal(20))
+			Expect(postChan).To(BeEmpty())
+
+			mockCRM.flushConfig()
+			Expect(postChan).To(HaveLen(1))
+			Expect(mockCRM.resources.getConfigDiffs()).To(BeEmpty(),
+				"Posted configuration should be the last one")
+			for _, virtual := range virtuals {
+				rsCfg, ok := mockCRM.resources.GetByName("test",
+					mockCRM.getVirtualServerName(virtual, DEFAULT_HTTPS_PORT))
+				Expect(ok).To(BeTrue())
+				Expect(rsCfg.MetaData.owners).To(ContainElement(
+					"default/" + virtual.ObjectMeta.Name))
+				Expect(mockCRM.SSLContext).To(HaveKey(virtual.ObjectMeta.Name))
+			}
+		})
+
+		It("Processes the VirtualServers on different virtuals concurrently", func() {
+			vs0 := mockCRM.addSecretVirtualServer(0, "10.1.1.0")
+			vs1 := mockCRM.addSecretVirtualServer(1, "10.1.1.1")
+			names, ok := mockCRM.getLockedVirtuals(&rqKey{
+				namespace: "default",
+				kind:      VirtualServer,
+				rscName:   vs0.ObjectMeta.Name,
+				rsc:       vs0,
+			})
+			Expect(ok).To(BeTrue())
+			// vs0 waits for its virtuals while vs1 is processed
+			unlock := mockCRM.virtualLocks.lock(names)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				mockCRM.processWithWorkers(2)
+			}()
+
+			isProcessed := func(vs *cisapiv1.VirtualServer) func() bool {
+				return func() bool {
+					_, ok := mockCRM.resources.GetByName("test",
+						mockCRM.getVirtualServerName(vs, DEFAULT_HTTPS_PORT))
+					return ok
+				}
+			}
+			Eventually(isProcessed(vs1)).Should(BeTrue())
+			Consistently(isProcessed(vs0), "50ms").Should(BeFalse())
+			Expect(done).NotTo(BeClosed())
+
+			unlock()
+			Eventually(done).Should(BeClosed())
+			Expect(isProcessed(vs0)()).To(BeTrue())
+			Expect(mockCRM.inFlight).To(BeZero())
+		})
+	})
+
+	Context("Flushing the configuration", func() {
+		var postChan chan config
+		var setConfig func(name string)
+
+		BeforeEach(func() {
+			postChan = make(chan config, 1)
+			mockCRM.Agent = &Agent{DeclWriter: &PostManager{postChan: postChan}}
+			setConfig = func(name string) {
+				rsCfg := &ResourceConfig{}
+				rsCfg.Virtual.Name = name
+				rsCfg.Virtual.Partition = "test"
+				rsCfg.MetaData.ResourceType = VirtualServer
+				mockCRM.resources.setResourceConfig(rsCfg)
+			}
+		})
+
+		coalescedEvents := func() float64 {
+			var m dto.Metric
+			_ = bigIPPrometheus.CoalescedEvents.Write(&m)
+			return m.GetCounter().GetValue()
+		}
+
+		It("Requests a flush when the queue is drained", func() {
+			mockCRM.FlushInterval = time.Hour
+			setConfig("crd_1_2_3_4_80")
+			mockCRM.configChanged(false)
+			mockCRM.configChanged(false)
+			Expect(mockCRM.flushCh).To(BeEmpty())
+			mockCRM.configChanged(true)
+			Expect(mockCRM.flushCh).To(HaveLen(1))
+
+			coalesced := coalescedEvents()
+			mockCRM.flushConfig()
+			Expect(postChan).To(HaveLen(1))
+			Expect(mockCRM.resources.getConfigDiffs()).To(BeEmpty())
+			Expect(coalescedEvents() - coalesced).To(Equal(float64(2)))
+
+			<-postChan
+			mockCRM.flushConfig()
+			Expect(postChan).To(BeEmpty(), "Unchanged config should not be posted")
+		})
+
+		It("Requests a flush when not posted for the flush interval", func() {
+			mockCRM.FlushInterval = 10 * time.Millisecond
+			setConfig("crd_1_2_3_4_80")
+			mockCRM.configChanged(false)
+			Expect(mockCRM.flushCh).To(BeEmpty())
+			time.Sleep(20 * time.Millisecond)
+			mockCRM.configChanged(false)
+			Expect(mockCRM.flushCh).To(HaveLen(1))
+		})
+
+		It("Writes the pool members removed to VxlanMgr", func() {
+			eventChan := make(chan interface{}, 1)
+			mockCRM.Agent.EventChan = eventChan
+			setMembers := func(name string, addrs ...string) {
+				rsCfg := &ResourceConfig{}
+				rsCfg.Virtual.Name = name
+				rsCfg.Virtual.Partition = "test"
+				rsCfg.MetaData.ResourceType = VirtualServer
+				rsCfg.MetaData.Active = true
+				pool := Pool{Name: name + "_pool", Partition: "test"}
+				for _, addr := range addrs {
+					pool.Members = append(pool.Members,
+						Member{Address: addr, Port: 8080})
+				}
+				rsCfg.Pools = Pools{pool}
+				mockCRM.resources.setResourceConfig(rsCfg)
+			}
+			flush := func() rsc.MemberUpdate {
+				mockCRM.configChanged(true)
+				mockCRM.flushConfig()
+				<-postChan
+				Expect(eventChan).To(HaveLen(1))
+				return (<-eventChan).(rsc.MemberUpdate)
+			}
+
+			// The first update replaces all the ARP entries
+			setMembers("crd_1_2_3_4_80", "10.1.0.1", "10.1.0.2")
+			setMembers("crd_1_2_3_5_80", "10.1.0.3")
+			update := flush()
+			Expect(update.Full).To(BeTrue())
+			Expect(update.Members).To(HaveLen(3))
+			Expect(update.Removed).To(BeEmpty())
+
+			// The members of the deleted config are removed, the address
+			// still used on another port is not
+			setMembers("crd_1_2_3_4_80", "10.1.0.1")
+			mockCRM.resources.deleteVirtualServer(NameRef{
+				Name: "crd_1_2_3_5_80", Partition: "test"})
+			setMembers("crd_1_2_3_6_80", "10.1.0.1", "10.1.0.4")
+			update = flush()
+			Expect(update.Full).To(BeFalse())
+			Expect(update.Members).To(HaveLen(3))
+			Expect(update.Removed).To(Equal([]rsc.Member{
+				{Address: "10.1.0.2", Port: 8080},
+				{Address: "10.1.0.3", Port: 8080},
+			}))
+
+			// The controller leading again replaces all the entries
+			mockCRM.resetState()
+			setMembers("crd_1_2_3_4_80", "10.1.0.1")
+			update = flush()
+			Expect(update.Full).To(BeTrue())
+			Expect(update.Removed).To(BeEmpty())
+		})
+
+		It("Posts at most once per flush interval", func() {
+			mockCRM.FlushInterval = 500 * time.Millisecond
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			go mockCRM.configFlusher(stopCh)
+			changed := func(name string) {
+				setConfig(name)
+				mockCRM.processingMutex.Lock()
+				mockCRM.configChanged(true)
+				mockCRM.processingMutex.Unlock()
+			}
+
+			changed("crd_1_2_3_4_80")
+			Eventually(mockCRM.resources.getConfigDiffs,
+				200*time.Millisecond).Should(BeEmpty())
+			changed("crd_1_2_3_5_80")
+			Consistently(mockCRM.resources.getConfigDiffs,
+				200*time.Millisecond).ShouldNot(BeEmpty())
+			Eventually(mockCRM.resources.getConfigDiffs,
+				time.Second).Should(BeEmpty())
+		})
+	})
+
+	Context("Metrics", func() {
+		// value returns the value of the counter or gauge, the sample
+		// count of the histogram
+		value := func(m prometheus.Metric) float64 {
+			var d dto.Metric
+			Expect(m.Write(&d)).To(Succeed())
+			switch {
+			case nil != d.Counter:
+				return d.GetCounter().GetValue()
+			case nil != d.Gauge:
+				return d.GetGauge().GetValue()
+			}
+			return float64(d.GetHistogram().GetSampleCount())
+		}
+		processed := bigIPPrometheus.VirtualServersProcessed.WithLabelValues("default")
+		errors := bigIPPrometheus.VirtualServerErrors.WithLabelValues("default")
+		hits := bigIPPrometheus.TLSSecretCache.WithLabelValues("hit")
+		misses := bigIPPrometheus.TLSSecretCache.WithLabelValues("miss")
+		durations := bigIPPrometheus.ProcessingDuration.WithLabelValues(
+			VirtualServer).(prometheus.Metric)
+
+		BeforeEach(func() {
+			mockCRM.Agent = &Agent{DeclWriter: &PostManager{
+				postChan: make(chan config, 1)}}
+		})
+
+		It("Counts the VirtualServers processed and deleted", func() {
+			oldProcessed, oldErrors := value(processed), value(errors)
+			oldHits, oldMisses := value(hits), value(misses)
+			oldDurations, oldDeleted := value(durations),
+				value(bigIPPrometheus.VirtualsDeleted)
+
+			vs := mockCRM.addSecretVirtualServer(0, "10.1.1.1")
+			mockCRM.processWithWorkers(1)
+			// The https virtual and the http one
+			Expect(value(processed) - oldProcessed).To(Equal(float64(2)))
+			Expect(value(errors)).To(Equal(oldErrors))
+			Expect(value(misses)-oldMisses).To(Equal(float64(1)),
+				"The secret should be got from the API server once")
+			Expect(value(hits)-oldHits).To(Equal(float64(1)),
+				"The secret should be got from the cache when processed")
+			Expect(value(durations) - oldDurations).To(Equal(float64(1)))
+			Expect(value(bigIPPrometheus.ResourceConfigs)).To(Equal(float64(2)))
+
+			mockCRM.flushConfig()
+			Expect(value(bigIPPrometheus.Pools)).To(Equal(float64(2)))
+			Expect(value(bigIPPrometheus.PoolMembers)).To(Equal(float64(0)))
+
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(value(bigIPPrometheus.VirtualsDeleted) - oldDeleted).To(
+				Equal(float64(2)))
+			Expect(value(bigIPPrometheus.ResourceConfigs)).To(Equal(float64(0)))
+		})
+
+		It("Counts the VirtualServers rejected", func() {
+			oldErrors := value(errors)
+			vs := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{VirtualServerAddress: "1.2.3.4%x"})
+			_, err := mockCRM.createRSConfigFromVirtualServer(vs,
+				portStruct{protocol: "http", port: DEFAULT_HTTP_PORT})
+			Expect(err).To(HaveOccurred())
+			Expect(value(errors) - oldErrors).To(Equal(float64(1)))
+
+			vs = test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{
+					VirtualServerAddress: "1.2.3.4",
+					TLSProfileName:       "missing",
+				})
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			Expect(value(errors) - oldErrors).To(Equal(float64(2)))
+		})
+
+		It("Observes the declarations posted", func() {
+			oldSuccesses := value(bigIPPrometheus.DeclarationPosts.
+				WithLabelValues("success"))
+			oldFailures := value(bigIPPrometheus.DeclarationPosts.
+				WithLabelValues("failure"))
+			oldPosts := value(bigIPPrometheus.DeclarationPostDuration)
+			oldSuccess := value(bigIPPrometheus.DeclarationLastSuccess)
+
+			observeDeclarationPost(time.Now(), false)
+			Expect(value(bigIPPrometheus.DeclarationLastSuccess)).To(
+				Equal(oldSuccess),
+				"A failed post should not be the last success")
+			observeDeclarationPost(time.Now(), true)
+			Expect(value(bigIPPrometheus.DeclarationPosts.
+				WithLabelValues("success")) - oldSuccesses).To(Equal(float64(1)))
+			Expect(value(bigIPPrometheus.DeclarationPosts.
+				WithLabelValues("failure")) - oldFailures).To(Equal(float64(1)))
+			Expect(value(bigIPPrometheus.DeclarationPostDuration) - oldPosts).To(
+				Equal(float64(2)))
+			Expect(value(bigIPPrometheus.DeclarationLastSuccess)).To(
+				BeNumerically("~", float64(time.Now().Unix()), 1))
+		})
+	})
+
+	Context("Log context", func() {
+		var out bytes.Buffer
+
+		BeforeEach(func() {
+			out.Reset()
+			log.RegisterLogger(log.LL_MIN_LEVEL, log.LL_MAX_LEVEL,
+				clog.NewJSONLoggerExt(&out, &out))
+		})
+
+		AfterEach(func() {
+			log.RegisterLogger(log.LL_MIN_LEVEL, log.LL_MAX_LEVEL,
+				clog.NewJSONLoggerExt(ioutil.Discard, ioutil.Discard))
+		})
+
+		It("Logs the fields of the VirtualServer processed", func() {
+			virtual := test.NewVirtualServer("SampleVS", "default",
+				cisapiv1.VirtualServerSpec{
+					VirtualServerAddress: "1.2.3.4",
+					TLSProfileName:       "missing",
+				})
+			mockCRM.addVirtualServer(virtual)
+			mockCRM.rscQueue.Add(&rqKey{
+				namespace: "default",
+				kind:      VirtualServer,
+				rscName:   "SampleVS",
+				rsc:       virtual,
+			})
+			mockCRM.processWithWorkers(1)
+			log.Info("Processed")
+
+			var lines []map[string]string
+			var syncID string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var fields map[string]string
+				Expect(json.Unmarshal([]byte(line), &fields)).To(Succeed(), line)
+				lines = append(lines, fields)
+				if strings.Contains(fields["msg"], "TLSProfile missing not found") {
+					syncID = fields[syncIDField]
+				}
+			}
+			Expect(syncID).NotTo(BeEmpty())
+			processing := 0
+			for _, fields := range lines {
+				if _, ok := fields[syncIDField]; !ok {
+					continue
+				}
+				processing++
+				Expect(fields).To(HaveKeyWithValue("namespace", "default"))
+				Expect(fields).To(HaveKeyWithValue("kind", VirtualServer))
+				Expect(fields).To(HaveKeyWithValue("name", "SampleVS"))
+				Expect(fields).To(HaveKeyWithValue(syncIDField, syncID))
+			}
+			Expect(processing).To(BeNumerically(">", 1))
+			Expect(lines[len(lines)-1]).To(Equal(map[string]string{
+				"time":  lines[len(lines)-1]["time"],
+				"level": "info",
+				"msg":   "Processed",
+			}), "Messages logged after processing should not have the fields")
+
+			events := mockCRM.getFakeEvents("default")
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Reason).To(Equal("TLSProfileNotFound"))
+			Expect(events[0].Annotations).To(Equal(map[string]string{
+				SyncIDAnnotation: syncID,
+			}))
+		})
+
+		It("Logs the fields of the VirtualServers synced for a Service", func() {
+			end := log.WithContext(log.Fields{"kind": Service,
+				"namespace": "default", "name": "svc", syncIDField: "1"})
+			log.Info("Service")
+			end2 := log.WithContext(log.Fields{"kind": VirtualServer,
+				"name": "SampleVS"})
+			log.Info("VirtualServer")
+			end2()
+			log.Info("Service again")
+			end()
+
+			var lines []map[string]string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var fields map[string]string
+				Expect(json.Unmarshal([]byte(line), &fields)).To(Succeed(), line)
+				delete(fields, "time")
+				lines = append(lines, fields)
+			}
+			Expect(lines).To(Equal([]map[string]string{
+				{"level": "info", "msg": "Service", "kind": Service,
+					"namespace": "default", "name": "svc", syncIDField: "1"},
+				{"level": "info", "msg": "VirtualServer", "kind": VirtualServer,
+					"namespace": "default", "name": "SampleVS", syncIDField: "1"},
+				{"level": "info", "msg": "Service again", "kind": Service,
+					"namespace": "default", "name": "svc", syncIDField: "1"},
+			}))
+			Expect(log.ContextFields()).To(BeNil())
+		})
+	})
+
+	Context("Resource names", func() {
+		var otherVS *cisapiv1.VirtualServer
+
+		BeforeEach(func() {
+			mockCRM.UseResourceNames = true
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80)},
+			}
+			otherVS = vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "other-vs"
+			otherVS.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/bar", Service: "svc2", ServicePort: intstr.FromInt(80)},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+		})
+
+		owners := func(rsName string) []string {
+			rsCfg, ok := mockCRM.resources.GetByName("test", rsName)
+			Expect(ok).To(BeTrue(), "Virtual %s not found", rsName)
+			return rsCfg.MetaData.owners
+		}
+
+		It("Names the virtual after the VirtualServer", func() {
+			Expect(mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)).To(
+				Equal("default_SampleVS_80"))
+			Expect(owners("default_SampleVS_80")).To(
+				ConsistOf("default/SampleVS"))
+			_, found := mockCRM.resources.GetByName("test",
+				"f5_crd_virtualserver_1_2_3_4_80")
+			Expect(found).To(BeFalse())
+		})
+
+		It("Merges the VirtualServers of the same address", func() {
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(mockCRM.getVirtualServerName(otherVS, DEFAULT_HTTP_PORT)).To(
+				Equal("default_SampleVS_80"))
+			Expect(owners("default_SampleVS_80")).To(
+				ConsistOf("default/SampleVS", "default/other-vs"))
+			Expect(mockCRM.resources.GetAllResources()).To(HaveLen(1))
+
+			// The virtual stays named after the first VirtualServer
+			mockCRM.deleteVirtualServerConfig(vs)
+			Expect(owners("default_SampleVS_80")).To(
+				ConsistOf("default/other-vs"))
+
+			mockCRM.deleteVirtualServerConfig(otherVS)
+			Expect(mockCRM.resources.GetAllResources()).To(BeEmpty())
+			Expect(mockCRM.getVirtualServerName(otherVS, DEFAULT_HTTP_PORT)).To(
+				Equal("default_other_vs_80"))
+		})
+
+		It("Keeps the virtual of the name shared on another address", func() {
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			newVS := vs.DeepCopy()
+			newVS.Spec.VirtualServerAddress = "1.2.3.5"
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.getVirtualServerName(newVS, DEFAULT_HTTP_PORT)).To(
+				Equal("f5_crd_virtualserver_1_2_3_5_80"))
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(owners("default_SampleVS_80")).To(
+				ContainElement("default/other-vs"))
+			Expect(owners("f5_crd_virtualserver_1_2_3_5_80")).To(
+				ConsistOf("default/SampleVS"))
+		})
+
+		It("Names the virtuals after their addresses by default", func() {
+			mockCRM.UseResourceNames = false
+			Expect(mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)).To(
+				Equal("f5_crd_virtualserver_1_2_3_4_80"))
+		})
+	})
+
+	Context("Partitions", func() {
+		var oldPartition string
+		var rsName string
+		weight := func(w int32) *int32 { return &w }
+
+		BeforeEach(func() {
+			oldPartition = DEFAULT_PARTITION
+			DEFAULT_PARTITION = "test"
+			mockCRM.AllowedPartitions = []string{"tenant1"}
+			vs.Spec.TLSProfileName = ""
+			vs.Spec.VirtualServerAddress = "1.2.3.4"
+			vs.Spec.Partition = "tenant1"
+			vs.Spec.Pools = []cisapiv1.Pool{
+				{Path: "/foo", Service: "svc1", ServicePort: intstr.FromInt(80),
+					Weight: weight(80)},
+				{Path: "/foo", Service: "svc2", ServicePort: intstr.FromInt(80),
+					Weight: weight(20)},
+			}
+			addServices("default", "svc1", "svc2")
+			mockCRM.addVirtualServer(vs)
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsName = mockCRM.getVirtualServerName(vs, DEFAULT_HTTP_PORT)
+		})
+
+		AfterEach(func() {
+			DEFAULT_PARTITION = oldPartition
+		})
+
+		// getConfig returns the config of the virtual in whichever partition
+		getConfig := func() *ResourceConfig {
+			var rsCfgs []*ResourceConfig
+			for _, rsCfg := range mockCRM.resources.GetAllResources() {
+				if rsCfg.GetName() == rsName {
+					rsCfgs = append(rsCfgs, rsCfg)
+				}
+			}
+			Expect(rsCfgs).To(HaveLen(1))
+			return rsCfgs[0]
+		}
+		declaration := func() as3ADC {
+			return createAS3ADC(ResourceConfigWrapper{
+				rsCfgs:         mockCRM.resources.GetAllResources(),
+				iRuleMap:       mockCRM.irulesMap,
+				intDgMap:       mockCRM.intDgMap,
+				customProfiles: mockCRM.customProfiles,
+				partitions:     mockCRM.AllowedPartitions,
+			})
+		}
+		sharedApp := func(adc as3ADC, partition string) as3Application {
+			Expect(adc).To(HaveKey(partition))
+			tenant := adc[partition].(as3Tenant)
+			return tenant[as3SharedApplication].(as3Application)
+		}
+
+		It("Rejects the partitions not allowed", func() {
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Partition = "test"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeTrue())
+			vs.Spec.Partition = "tenant2"
+			Expect(mockCRM.checkValidVirtualServer(vs)).To(BeFalse())
+			Expect(ValidatePartition("Common")).NotTo(BeNil())
+			Expect(ValidatePartition("tenant/1")).NotTo(BeNil())
+		})
+
+		It("Configures the VirtualServer in its partition", func() {
+			rsCfg := getConfig()
+			Expect(rsCfg.Virtual.Partition).To(Equal("tenant1"))
+			for _, pool := range rsCfg.Pools {
+				Expect(pool.Partition).To(Equal("tenant1"))
+			}
+			Expect(rsCfg.Virtual.IRules).To(ContainElement(
+				JoinBigipPath("tenant1", AbDeploymentPathIRuleName)))
+			dg := mockCRM.intDgMap[NameRef{Name: AbDeploymentDgName,
+				Partition: "tenant1"}]["default"]
+			Expect(dg).NotTo(BeNil())
+			Expect(dg.Records[0].Data).To(HavePrefix("/tenant1/Shared/"))
+
+			adc := declaration()
+			app := sharedApp(adc, "tenant1")
+			Expect(app).To(HaveKey(rsName))
+			Expect(app).To(HaveKey(AbDeploymentPathIRuleName))
+			Expect(app).To(HaveKey(AbDeploymentDgName))
+			Expect(sharedApp(adc, "test")).NotTo(HaveKey(rsName))
+		})
+
+		It("Configures the VirtualServer in the partition of its namespace", func() {
+			_, err := ParseNamespacePartitions([]string{"default=tenant2"},
+				[]string{"test", "tenant1"})
+			Expect(err).To(HaveOccurred())
+			_, err = ParseNamespacePartitions([]string{"tenant1"},
+				[]string{"test", "tenant1"})
+			Expect(err).To(HaveOccurred())
+			partitions, err := ParseNamespacePartitions(
+				[]string{"default=tenant1"}, []string{"test", "tenant1"})
+			Expect(err).NotTo(HaveOccurred())
+			mockCRM.NamespacePartitions = partitions
+
+			newVS := vs.DeepCopy()
+			newVS.Spec.Partition = ""
+			Expect(mockCRM.getVirtualServerPartition(newVS)).To(Equal("tenant1"))
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getConfig().Virtual.Partition).To(Equal("tenant1"))
+
+			// The partition of the VirtualServer overrides it
+			newVS.Spec.Partition = "test"
+			Expect(mockCRM.getVirtualServerPartition(newVS)).To(Equal("test"))
+		})
+
+		It("Shares the iRules and data groups in the shared partition", func() {
+			mockCRM.SharedPartition = "Common"
+			Expect(mockCRM.syncVirtualServer(vs)).To(BeNil())
+			rsCfg := getConfig()
+			Expect(rsCfg.Virtual.IRules).To(ContainElement(
+				"/Common/Shared/" + AbDeploymentPathIRuleName))
+			dg := mockCRM.intDgMap[NameRef{Name: AbDeploymentDgName,
+				Partition: "Common"}]["default"]
+			Expect(dg).NotTo(BeNil())
+			Expect(dg.Records[0].Data).To(HavePrefix("/tenant1/Shared/"))
+
+			config := ResourceConfigWrapper{
+				rsCfgs:         mockCRM.resources.GetAllResources(),
+				iRuleMap:       mockCRM.irulesMap,
+				intDgMap:       mockCRM.intDgMap,
+				customProfiles: mockCRM.customProfiles,
+			}
+			config.sharedPartition = mockCRM.SharedPartition
+			app := sharedApp(createAS3ADC(config), "Common")
+			Expect(app).To(HaveKey(AbDeploymentPathIRuleName))
+			Expect(app).To(HaveKey(AbDeploymentDgName))
+			Expect(app).NotTo(HaveKey(rsName))
+		})
+
+		It("Deletes the objects from the former partition", func() {
+			newVS := vs.DeepCopy()
+			newVS.Spec.Partition = ""
+			mockCRM.addVirtualServer(newVS)
+			Expect(mockCRM.syncVirtualServer(newVS)).To(BeNil())
+			Expect(getConfig().Virtual.Partition).To(Equal("test"))
+			Expect(mockCRM.intDgMap[NameRef{Name: AbDeploymentDgName,
+				Partition: "tenant1"}]).NotTo(HaveKey("default"))
+
+			adc := declaration()
+			Expect(sharedApp(adc, "test")).To(HaveKey(rsName))
+			Expect(sharedApp(adc, "tenant1")).To(Equal(as3Application{
+				"class": "Application", "template": "shared"}))
+		})
+
+		It("Rejects the address used in another partition", func() {
+			otherVS := vs.DeepCopy()
+			otherVS.ObjectMeta.Name = "other-vs"
+			otherVS.Spec.Partition = ""
+			mockCRM.addVirtualServer(otherVS)
+			Expect(mockCRM.syncVirtualServer(otherVS)).To(BeNil())
+			Expect(getConfig().MetaData.owners).To(
+				ConsistOf("default/SampleVS"))
+			events := mockCRM.getFakeEvents("default")
+			Expect(events).NotTo(BeEmpty())
+			Expect(events[len(events)-1].Reason).To(Equal("AddressConflict"))
+		})
+	})
+})
+
+// servicePoolNamer names the pools after the service only
+type servicePoolNamer struct {
+	crdNamer
+}
+
+func (servicePoolNamer) PoolName(namespace, svc, port, nodeMemberLabel string) string {
+	return crdNamer{}.PoolName(namespace, svc, "", nodeMemberLabel)
+}
+
+// testKeyPairs are the certificates and keys generated by name
+var testKeyPairs = make(map[string][2]string)
+
+// newTestKeyPair returns a self-signed PEM certificate of the hosts expiring
+// at notAfter and its PEM key
+func newTestKeyPair(notAfter time.Time, hosts ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{
+			Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{
+			Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+// testCert returns the certificate named, valid for a year for the hosts of
+// the tests
+func testCert(name string) string {
+	if _, ok := testKeyPairs[name]; !ok {
+		cert, key := newTestKeyPair(time.Now().AddDate(1, 0, 0), "*.com",
+			"*.test.com")
+		testKeyPairs[name] = [2]string{cert, key}
+	}
+	return testKeyPairs[name][0]
+}
+
+// testKey returns the key of the certificate named
+func testKey(name string) string {
+	testCert(name)
+	return testKeyPairs[name][1]
+}
+
+// addSecretVirtualServer adds the VirtualServer named after the index with
+// the address, its pool service and the TLSProfile using its secret.
+func (m *mockCRManager) addSecretVirtualServer(
+	i int,
+	address string,
+) *cisapiv1.VirtualServer {
+	name := fmt.Sprintf("vs%d", i)
+	m.addService(test.NewService(name, "1", "default",
+		v1.ServiceTypeClusterIP, nil))
+	_, _ = m.kubeClient.CoreV1().Secrets("default").Create(
+		test.NewSecret(name, "default", testCert("cert"), testKey("cert")))
+	m.addTLSProfile(test.NewTLSProfile(name, "default",
+		cisapiv1.TLSProfileSpec{
+			Hosts: []string{name + ".com"},
+			TLS: cisapiv1.TLS{
+				Termination: "edge",
+				ClientSSL:   name,
+				Reference:   Secret,
+			},
+		},
+	))
+	vs := test.NewVirtualServer(name, "default",
+		cisapiv1.VirtualServerSpec{
+			Host:                 name + ".com",
+			VirtualServerAddress: address,
+			TLSProfileName:       name,
+			Pools: []cisapiv1.Pool{
+				{Path: "/", Service: name, ServicePort: intstr.FromInt(80)},
+			},
+		},
+	)
+	m.addVirtualServer(vs)
+	m.rscQueue.Add(&rqKey{
+		namespace: "default",
+		kind:      VirtualServer,
+		rscName:   name,
+		rsc:       vs,
+	})
+	return vs
+}
+
+// benchmarkProcessing processes 1000 VirtualServers with the workers, their
+// secrets take a millisecond to get from the API server.
+func benchmarkProcessing(b *testing.B, workers int) {
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		mockCRM := newMockCRManager()
+		mockCRM.Agent = &Agent{
+			DeclWriter: &PostManager{postChan: make(chan config, 1)},
+		}
+		mockCRM.kubeClient.(*k8sfake.Clientset).PrependReactor("get",
+			"secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+				time.Sleep(time.Millisecond)
+				return false, nil, nil
+			})
+		for i := 0; i < 1000; i++ {
+			mockCRM.addSecretVirtualServer(i,
+				fmt.Sprintf("10.1.%d.%d", i/250, i%250))
+		}
+		b.StartTimer()
+		mockCRM.processWithWorkers(workers)
+		mockCRM.flushConfig()
+	}
+}
+
+func BenchmarkProcessingSerial(b *testing.B) {
+	benchmarkProcessing(b, 1)
+}
+
+func BenchmarkProcessingWorkers(b *testing.B) {
+	benchmarkProcessing(b, 8)
+}
@@ -9,12 +9,30 @@ import (
 
 type HealthChecker struct {
 	SubPID int
+	// Draining reports whether the controller is shutting down, if set
+	Draining func() bool
+	// Liveness returns why the controller is not live, if set
+	Liveness func() error
 }
 
 //TODO: Add additional health checks
 //TODO: add health check if Kubernetes API is still reachable
 func (hc HealthChecker) HealthCheckHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hc.Draining != nil && hc.Draining() {
+			// Taken out of rotation while shutting down
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("draining"))
+			return
+		}
+		if hc.Liveness != nil {
+			if err := hc.Liveness(); err != nil {
+				log.Errorf("Liveness check failed: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
 		if hc.SubPID != 0 {
 			_, err := os.FindProcess(hc.SubPID)
 			if err == nil {
@@ -31,11 +31,196 @@ var CurrentErrors = prometheus.NewGaugeVec(
 	[]string{},
 )
 
+var NamespaceQuotaUsage = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bigip_namespace_quota_usage",
+		Help: "Count of objects configured on BigIP for a namespace by the BigIP k8s CTLR",
+	},
+	[]string{"namespace", "resource"},
+)
+
+var NamespaceQuota = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bigip_namespace_quota",
+		Help: "Maximum count of objects allowed on BigIP for a namespace, 0 is unlimited",
+	},
+	[]string{"namespace", "resource"},
+)
+
 // further metrics? todo think about
 // RegisterMetrics registers all Prometheus metrics defined above
+var CoalescedEvents = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "bigip_coalesced_events_total",
+		Help: "Total count of resource events posted to BigIP with the declaration of another event",
+	},
+)
+
+// VirtualServersProcessed counts the VirtualServers processed in custom
+// resource mode, once for each of their virtuals
+var VirtualServersProcessed = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bigip_virtualservers_processed_total",
+		Help: "Total count of VirtualServers processed by the BigIP k8s CTLR, once per virtual",
+	},
+	[]string{"namespace"},
+)
+
+var VirtualServerErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bigip_virtualserver_errors_total",
+		Help: "Total count of VirtualServers rejected or with an invalid TLS configuration, once per virtual",
+	},
+	[]string{"namespace"},
+)
+
+var VirtualsDeleted = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "bigip_virtuals_deleted_total",
+		Help: "Total count of virtuals of custom resources deleted by the BigIP k8s CTLR",
+	},
+)
+
+var ResourceConfigs = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_resource_configs",
+		Help: "Count of virtuals of custom resources configured by the BigIP k8s CTLR",
+	},
+)
+
+var Pools = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_pools",
+		Help: "Count of pools of custom resources last posted to BigIP",
+	},
+)
+
+var PoolMembers = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_pool_members",
+		Help: "Count of pool members of custom resources last posted to BigIP",
+	},
+)
+
+var QueueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_resource_queue_depth",
+		Help: "Count of resources waiting to be processed by the BigIP k8s CTLR",
+	},
+)
+
+var ProcessingDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "bigip_resource_processing_duration_seconds",
+		Help: "Duration of the processing of a resource by the BigIP k8s CTLR",
+	},
+	[]string{"kind"},
+)
+
+// TLSSecretCache counts the TLS secrets got from the cache of the secrets
+// of the VirtualServers, result is hit or miss
+var TLSSecretCache = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bigip_tls_secret_cache_total",
+		Help: "Total count of TLS secrets looked up in the cache of the BigIP k8s CTLR",
+	},
+	[]string{"result"},
+)
+
+// DeclarationPosts counts the AS3 declarations posted to BigIP, result is
+// success or failure
+var DeclarationPosts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bigip_declaration_posts_total",
+		Help: "Total count of AS3 declarations posted to BigIP",
+	},
+	[]string{"result"},
+)
+
+var DeclarationPostDuration = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "bigip_declaration_post_duration_seconds",
+		Help:    "Duration of the posts of AS3 declarations to BigIP",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	},
+)
+
+var DeclarationLastSuccess = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_declaration_last_success_timestamp_seconds",
+		Help: "Time of the last AS3 declaration successfully posted to BigIP",
+	},
+)
+
+var DeclarationLastFailure = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bigip_declaration_last_failure_timestamp_seconds",
+		Help: "Time of the last AS3 declaration failing to be posted to BigIP",
+	},
+)
+
+// SkippedDeclarations counts the declarations not posted to BigIP as
+// identical to the one applied
+var SkippedDeclarations = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "bigip_declaration_skipped_total",
+		Help: "Total count of AS3 declarations skipped as identical to the one applied to BigIP",
+	},
+)
+
+// Resyncs counts the full resyncs of custom resource mode, drift is true
+// when the configs rebuilt differ from the configs last posted
+var Resyncs = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "bigip_resyncs_total",
+		Help: "Total count of full resyncs of the resources posted to BigIP",
+	},
+	[]string{"drift"},
+)
+
+// ResourceSyncRetries is the count of the retries of the resources failing
+// to sync in custom resource mode, removed once synced or given up
+var ResourceSyncRetries = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bigip_resource_sync_retries",
+		Help: "Count of the retries of the resources failing to sync in the BigIP k8s CTLR",
+	},
+	[]string{"kind", "namespace", "name"},
+)
+
+// CertificateExpiry is the expiry time of the certificates of the secrets
+// expiring within the warning days, removed once renewed
+var CertificateExpiry = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "bigip_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the certificates of the secrets of the BigIP k8s CTLR expiring soon",
+	},
+	[]string{"namespace", "name"},
+)
+
 func RegisterMetrics() {
 	log.Info("[CORE] Registered BigIP Metrics")
 	prometheus.MustRegister(MonitoredNodes)
 	prometheus.MustRegister(MonitoredServices)
 	prometheus.MustRegister(CurrentErrors)
+	prometheus.MustRegister(NamespaceQuotaUsage)
+	prometheus.MustRegister(NamespaceQuota)
+	prometheus.MustRegister(CoalescedEvents)
+	prometheus.MustRegister(VirtualServersProcessed)
+	prometheus.MustRegister(VirtualServerErrors)
+	prometheus.MustRegister(VirtualsDeleted)
+	prometheus.MustRegister(ResourceConfigs)
+	prometheus.MustRegister(Pools)
+	prometheus.MustRegister(PoolMembers)
+	prometheus.MustRegister(QueueDepth)
+	prometheus.MustRegister(ProcessingDuration)
+	prometheus.MustRegister(TLSSecretCache)
+	prometheus.MustRegister(DeclarationPosts)
+	prometheus.MustRegister(DeclarationPostDuration)
+	prometheus.MustRegister(DeclarationLastSuccess)
+	prometheus.MustRegister(DeclarationLastFailure)
+	prometheus.MustRegister(SkippedDeclarations)
+	prometheus.MustRegister(Resyncs)
+	prometheus.MustRegister(ResourceSyncRetries)
+	prometheus.MustRegister(CertificateExpiry)
 }
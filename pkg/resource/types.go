@@ -106,6 +106,16 @@ type (
 		Session string `json:"session,omitempty"`
 	}
 
+	// MemberUpdate is written to VxlanMgr with the pool members and the
+	// members removed since the previous update, whose ARP entries are
+	// deleted. A full update replaces all the ARP entries, like the first
+	// update after a restart.
+	MemberUpdate struct {
+		Members []Member
+		Removed []Member
+		Full    bool
+	}
+
 	// Pool config
 	Pool struct {
 		Name         string   `json:"name"`
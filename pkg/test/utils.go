@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	cisapiv1 "github.com/F5Networks/k8s-bigip-ctlr/config/apis/cis/v1"
 	"github.com/F5Networks/k8s-bigip-ctlr/pkg/pollers"
 
 	routeapi "github.com/openshift/api/route/v1"
@@ -154,6 +155,102 @@ func NewIngress(id, rv, namespace string,
 	}
 }
 
+// NewVirtualServer returns a new VirtualServer custom resource
+func NewVirtualServer(id, namespace string,
+	spec cisapiv1.VirtualServerSpec) *cisapiv1.VirtualServer {
+	return &cisapiv1.VirtualServer{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "VirtualServer",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// NewTLSProfile returns a new TLSProfile custom resource
+func NewTLSProfile(id, namespace string,
+	spec cisapiv1.TLSProfileSpec) *cisapiv1.TLSProfile {
+	return &cisapiv1.TLSProfile{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "TLSProfile",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// NewTransportServer returns a new TransportServer custom resource
+func NewTransportServer(id, namespace string,
+	spec cisapiv1.TransportServerSpec) *cisapiv1.TransportServer {
+	return &cisapiv1.TransportServer{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "TransportServer",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// NewIngressLink returns a new IngressLink custom resource
+func NewIngressLink(id, namespace string,
+	spec cisapiv1.IngressLinkSpec) *cisapiv1.IngressLink {
+	return &cisapiv1.IngressLink{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IngressLink",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// NewExternalDNS returns a new ExternalDNS custom resource
+func NewExternalDNS(id, namespace string,
+	spec cisapiv1.ExternalDNSSpec) *cisapiv1.ExternalDNS {
+	return &cisapiv1.ExternalDNS{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ExternalDNS",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// NewPolicy returns a new Policy custom resource
+func NewPolicy(id, namespace string,
+	spec cisapiv1.PolicySpec) *cisapiv1.Policy {
+	return &cisapiv1.Policy{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Policy",
+			APIVersion: "cis.f5.com/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
 // NewRoute returns a new route object
 func NewRoute(
 	id,
@@ -224,6 +321,25 @@ func NewService(id, rv, namespace string, serviceType v1.ServiceType,
 	}
 }
 
+// NewSecret returns a new TLS secret object
+func NewSecret(id, namespace, cert, key string) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      id,
+			Namespace: namespace,
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(cert),
+			"tls.key": []byte(key),
+		},
+	}
+}
+
 //NewEndpoints returns an endpoints objects
 func NewEndpoints(
 	svcName,
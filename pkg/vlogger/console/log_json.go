@@ -0,0 +1,186 @@
+// Copyright (c) 2019, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//log_json.go:
+//  Provides console logging of one JSON object per message, with the fields
+//  of the context of the message. To use, create the logger object with the
+//  following syntax:
+//    NewJSONLogger()
+//
+package console
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/F5Networks/k8s-bigip-ctlr/pkg/vlogger"
+)
+
+type (
+	jsonLogger struct {
+		sync.Mutex
+		// slLogLevel uses syslog's definitions which have higher priority
+		// levels defined in descending order (0 is highest)
+		slLogLevel syslog.Priority
+		// Info messages are written to stdout, the others to stderr
+		stdout io.Writer
+		stderr io.Writer
+	}
+)
+
+// slLevels maps the vlogger log levels to syslog's definitions
+var slLevels = map[vlogger.LogLevel]syslog.Priority{
+	vlogger.LL_DEBUG:    syslog.LOG_DEBUG,
+	vlogger.LL_INFO:     syslog.LOG_INFO,
+	vlogger.LL_WARNING:  syslog.LOG_WARNING,
+	vlogger.LL_ERROR:    syslog.LOG_ERR,
+	vlogger.LL_CRITICAL: syslog.LOG_CRIT,
+}
+
+// NewJSONLogger creates a logger object that prints log messages to the
+// console as JSON objects like:
+// {"time":"...","level":"info","msg":"...","namespace":"default"}
+func NewJSONLogger() *jsonLogger {
+	return NewJSONLoggerExt(os.Stdout, os.Stderr)
+}
+
+// NewJSONLoggerExt creates a JSON logger writing the info messages to stdout
+// and the others to stderr.
+func NewJSONLoggerExt(stdout, stderr io.Writer) *jsonLogger {
+	return &jsonLogger{
+		slLogLevel: syslog.LOG_DEBUG,
+		stdout:     stdout,
+		stderr:     stderr,
+	}
+}
+
+// LogFields writes the message with the fields, sorted after the time, the
+// level and the message.
+func (jl *jsonLogger) LogFields(
+	level vlogger.LogLevel,
+	msg string,
+	fields vlogger.Fields,
+) {
+	if jl.GetLogLevel() < slLevels[level] {
+		return
+	}
+	var buf bytes.Buffer
+	writeField := func(key, value string) {
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('{')
+	writeField("time", time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeField("level", level.String())
+	buf.WriteByte(',')
+	writeField("msg", msg)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		// The fields do not replace the time, level or message
+		if key != "time" && key != "level" && key != "msg" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteByte(',')
+		writeField(key, fields[key])
+	}
+	buf.WriteString("}\n")
+
+	out := jl.stderr
+	if level == vlogger.LL_INFO {
+		out = jl.stdout
+	}
+	jl.Lock()
+	defer jl.Unlock()
+	out.Write(buf.Bytes())
+}
+
+// logf formats the message only when its level is logged
+func (jl *jsonLogger) logf(
+	level vlogger.LogLevel,
+	format string,
+	params []interface{},
+) {
+	if jl.GetLogLevel() >= slLevels[level] {
+		jl.LogFields(level, fmt.Sprintf(format, params...), nil)
+	}
+}
+
+func (jl *jsonLogger) Debug(msg string) {
+	jl.LogFields(vlogger.LL_DEBUG, msg, nil)
+}
+
+func (jl *jsonLogger) Debugf(format string, params ...interface{}) {
+	jl.logf(vlogger.LL_DEBUG, format, params)
+}
+
+func (jl *jsonLogger) Info(msg string) {
+	jl.LogFields(vlogger.LL_INFO, msg, nil)
+}
+
+func (jl *jsonLogger) Infof(format string, params ...interface{}) {
+	jl.logf(vlogger.LL_INFO, format, params)
+}
+
+func (jl *jsonLogger) Warning(msg string) {
+	jl.LogFields(vlogger.LL_WARNING, msg, nil)
+}
+
+func (jl *jsonLogger) Warningf(format string, params ...interface{}) {
+	jl.logf(vlogger.LL_WARNING, format, params)
+}
+
+func (jl *jsonLogger) Error(msg string) {
+	jl.LogFields(vlogger.LL_ERROR, msg, nil)
+}
+
+func (jl *jsonLogger) Errorf(format string, params ...interface{}) {
+	jl.logf(vlogger.LL_ERROR, format, params)
+}
+
+func (jl *jsonLogger) Critical(msg string) {
+	jl.LogFields(vlogger.LL_CRITICAL, msg, nil)
+}
+
+func (jl *jsonLogger) Criticalf(format string, params ...interface{}) {
+	jl.logf(vlogger.LL_CRITICAL, format, params)
+}
+
+func (jl *jsonLogger) SetLogLevel(slLogLevel syslog.Priority) {
+	jl.Lock()
+	defer jl.Unlock()
+	jl.slLogLevel = slLogLevel
+}
+
+func (jl *jsonLogger) GetLogLevel() syslog.Priority {
+	jl.Lock()
+	defer jl.Unlock()
+	return jl.slLogLevel
+}
+
+func (jl *jsonLogger) Close() {
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2019, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//context.go:
+//  This module attaches structured fields to the messages logged by a goroutine,
+//  so that the callers of the printf-style functions need not pass them.
+//
+package vlogger
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Fields are the structured fields of the messages logged with a context
+	Fields map[string]string
+
+	// StructuredLogger is a Logger writing the fields of the messages logged
+	// with a context, the other loggers ignore them.
+	StructuredLogger interface {
+		Logger
+		LogFields(level LogLevel, msg string, fields Fields)
+	}
+)
+
+var (
+	// contexts are the fields of the goroutines logging with a context,
+	// key is the goroutine id
+	contexts      = make(map[uint64]Fields)
+	contextsMutex sync.RWMutex
+	// contextCount is the length of contexts, the goroutine id is not
+	// looked up while no goroutine logs with a context
+	contextCount int32
+)
+
+// WithContext attaches the fields to the messages logged by the calling
+// goroutine, in addition to the fields already attached, until the returned
+// function is called. Fields with empty values are not attached. Contexts
+// must be ended in reverse order.
+func WithContext(fields Fields) (end func()) {
+	gid := goroutineID()
+	contextsMutex.Lock()
+	defer contextsMutex.Unlock()
+	parent, nested := contexts[gid]
+	ctx := make(Fields, len(parent)+len(fields))
+	for k, v := range parent {
+		ctx[k] = v
+	}
+	for k, v := range fields {
+		if v != "" {
+			ctx[k] = v
+		}
+	}
+	contexts[gid] = ctx
+	if !nested {
+		atomic.AddInt32(&contextCount, 1)
+	}
+	return func() {
+		contextsMutex.Lock()
+		defer contextsMutex.Unlock()
+		if nested {
+			contexts[gid] = parent
+			return
+		}
+		delete(contexts, gid)
+		atomic.AddInt32(&contextCount, -1)
+	}
+}
+
+// ContextFields returns the fields attached to the messages logged by the
+// calling goroutine, nil without a context.
+func ContextFields() Fields {
+	if atomic.LoadInt32(&contextCount) == 0 {
+		return nil
+	}
+	gid := goroutineID()
+	contextsMutex.RLock()
+	defer contextsMutex.RUnlock()
+	return contexts[gid]
+}
+
+// logContext logs the message with the fields of the context of the calling
+// goroutine. It returns false when there is no context or the logger of the
+// level is not a StructuredLogger, the message is not logged then.
+func logContext(level LogLevel, format string, params []interface{}) bool {
+	fields := ContextFields()
+	if nil == fields {
+		return false
+	}
+	sl, ok := vlog[level].(StructuredLogger)
+	if !ok {
+		return false
+	}
+	sl.LogFields(level, fmt.Sprintf(format, params...), fields)
+	return true
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the
+// header of its stack trace: "goroutine 18 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	stack := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)],
+		[]byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		if id, err := strconv.ParseUint(string(stack[:i]), 10, 64); nil == err {
+			return id
+		}
+	}
+	return 0
+}
@@ -148,62 +148,80 @@ func RegisterLogger(minLogLevel, maxLogLevel LogLevel, log Logger) {
 
 // Debug sends a message to the logger object to record debug/trace level statements
 func Debug(msg string) {
-	vlog[LL_DEBUG].Debug(msg)
+	if !logContext(LL_DEBUG, "%s", []interface{}{msg}) {
+		vlog[LL_DEBUG].Debug(msg)
+	}
 }
 
 // Debugf formats a message before sending it to the logger object to record
 // debug/trace level statements
 func Debugf(format string, params ...interface{}) {
-	vlog[LL_DEBUG].Debugf(format, params...)
+	if !logContext(LL_DEBUG, format, params) {
+		vlog[LL_DEBUG].Debugf(format, params...)
+	}
 }
 
 // Info sends a message to the logger object to record informational level statements
 // (these should be statements that can normally be logged without causing performance
 // issues).
 func Info(msg string) {
-	vlog[LL_INFO].Info(msg)
+	if !logContext(LL_INFO, "%s", []interface{}{msg}) {
+		vlog[LL_INFO].Info(msg)
+	}
 }
 
 // Infof formats a message before sending it to the logger object to record
 // informational level statements (there should be statements that can normally
 // be logged without causing performance issues).
 func Infof(format string, params ...interface{}) {
-	vlog[LL_INFO].Infof(format, params...)
+	if !logContext(LL_INFO, format, params) {
+		vlog[LL_INFO].Infof(format, params...)
+	}
 }
 
 // Warning sends a message to the logger object to record warning level statements
 // (these indication conditions that are unexpected or may cause issues but are not
 // normally going to affect the program execution).
 func Warning(msg string) {
-	vlog[LL_WARNING].Warning(msg)
+	if !logContext(LL_WARNING, "%s", []interface{}{msg}) {
+		vlog[LL_WARNING].Warning(msg)
+	}
 }
 
 // Warningf formats a message before sending it to the logger object to record
 // warning level statements (these indication conditions that are unexpected or
 // may cause issues but are not normally going to affect the program execution).
 func Warningf(format string, params ...interface{}) {
-	vlog[LL_WARNING].Warningf(format, params...)
+	if !logContext(LL_WARNING, format, params) {
+		vlog[LL_WARNING].Warningf(format, params...)
+	}
 }
 
 // Error sends a message to the logger object to record error level statements
 // (these indicate conditions that should not occur and may indicate a failure
 // in performing the requested action).
 func Error(msg string) {
-	vlog[LL_ERROR].Error(msg)
+	if !logContext(LL_ERROR, "%s", []interface{}{msg}) {
+		vlog[LL_ERROR].Error(msg)
+	}
 }
 
 // Errorf formats a message before sending it to the logger object to record
 // error level statements (these indicate conditions that should not occur
 // and may indicate a failure in performing the requested action).
 func Errorf(format string, params ...interface{}) {
-	vlog[LL_ERROR].Errorf(format, params...)
+	if !logContext(LL_ERROR, format, params) {
+		vlog[LL_ERROR].Errorf(format, params...)
+	}
 }
 
 // Critical sends a message to the logger object to record critical level statements
 // (these indicate conditions that should never occur and might cause a failure/crash
 // of the executing program or unexpected outcome from the requested action).
 func Critical(msg string) {
-	vlog[LL_CRITICAL].Critical(msg)
+	if !logContext(LL_CRITICAL, "%s", []interface{}{msg}) {
+		vlog[LL_CRITICAL].Critical(msg)
+	}
 }
 
 // Criticalf formats a message before sending it to the logger object to record
@@ -211,14 +229,16 @@ func Critical(msg string) {
 // and might cause a failure/crash of the executing program or unexpected
 // outcome from the requested action).
 func Criticalf(format string, params ...interface{}) {
-	vlog[LL_CRITICAL].Criticalf(format, params...)
+	if !logContext(LL_CRITICAL, format, params) {
+		vlog[LL_CRITICAL].Criticalf(format, params...)
+	}
 }
 
 // Fatal sends a CRITICAL message to the logger object and then exits.
 // NOTE: This call should not be made in packages that are meant to serve
 // as libraries for other developers.
 func Fatal(msg string) {
-	vlog[LL_CRITICAL].Critical(msg)
+	Critical(msg)
 	Close()
 	os.Exit(1)
 }
@@ -227,7 +247,7 @@ func Fatal(msg string) {
 // NOTE: This call should not be made in packages that are meant to serve
 // as libraries for other developers.
 func Fatalf(format string, params ...interface{}) {
-	vlog[LL_CRITICAL].Criticalf(format, params...)
+	Criticalf(format, params...)
 	Close()
 	os.Exit(1)
 }
@@ -236,7 +256,7 @@ func Fatalf(format string, params ...interface{}) {
 // NOTE: This call should not be made in packages that are meant to serve
 // as libraries for other developers.
 func Panic(msg string) {
-	vlog[LL_CRITICAL].Critical(msg)
+	Critical(msg)
 	panic(msg)
 }
 
@@ -245,7 +265,7 @@ func Panic(msg string) {
 // as libraries for other developers.
 func Panicf(format string, params ...interface{}) {
 	msg := fmt.Sprintf(format, params...)
-	vlog[LL_CRITICAL].Critical(msg)
+	Critical(msg)
 	panic(msg)
 }
 
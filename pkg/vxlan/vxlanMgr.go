@@ -18,6 +18,7 @@ package vxlan
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -57,6 +58,8 @@ type VxlanMgr struct {
 	useNodeInt bool
 	config     writer.Writer
 	podChan    <-chan interface{}
+	// ARP entries last written, key is the address of the pod
+	arps map[string]arpEntry
 }
 
 func NewVxlanMgr(
@@ -199,9 +202,15 @@ func (vxm *VxlanMgr) ProcessAppmanagerEvents(kubeClient kubernetes.Interface) {
 		for {
 			select {
 			case pods := <-vxm.podChan:
-				if pods, ok := pods.([]resource.Member); ok {
-					vxm.addArpForPods(pods, kubeClient)
-				} else {
+				switch update := pods.(type) {
+				case []resource.Member:
+					vxm.addArpForPods(resource.MemberUpdate{
+						Members: update,
+						Full:    true,
+					}, kubeClient)
+				case resource.MemberUpdate:
+					vxm.addArpForPods(update, kubeClient)
+				default:
 					log.Errorf("[VxLAN] Vxlan Manager could not read Endpoints from appManager channel.")
 				}
 			}
@@ -210,8 +219,14 @@ func (vxm *VxlanMgr) ProcessAppmanagerEvents(kubeClient kubernetes.Interface) {
 	return
 }
 
-func (vxm *VxlanMgr) addArpForPods(pods interface{}, kubeClient kubernetes.Interface) {
-	arps := arpSection{}
+// addArpForPods writes the ARP entries of the pods. The entries of the
+// removed members are deleted, the entries of the members whose node is
+// not found are kept until they are removed. A full update replaces all
+// the entries.
+func (vxm *VxlanMgr) addArpForPods(
+	update resource.MemberUpdate,
+	kubeClient kubernetes.Interface,
+) {
 	kubePods, err := kubeClient.CoreV1().Pods("").List(metav1.ListOptions{})
 	if nil != err {
 		log.Errorf("[VxLAN] Vxlan Manager could not list Kubernetes Pods for ARP entries: %v", err)
@@ -222,20 +237,39 @@ func (vxm *VxlanMgr) addArpForPods(pods interface{}, kubeClient kubernetes.Inter
 		log.Errorf("[VxLAN] Vxlan Manager could not list Kubernetes Nodes for ARP entries: %v", err)
 		return
 	}
-	for _, pod := range pods.([]resource.Member) {
+	entries := make(map[string]arpEntry)
+	if !update.Full {
+		for addr, entry := range vxm.arps {
+			entries[addr] = entry
+		}
+		for _, pod := range update.Removed {
+			delete(entries, pod.Address)
+		}
+	}
+	for _, pod := range update.Members {
 		var mac string
 		mac, err = getVtepMac(pod, kubePods, kubeNodes)
 		if nil != err {
 			log.Errorf("[VxLAN] %v", err)
-			return
+			continue
 		}
-		entry := arpEntry{
+		entries[pod.Address] = arpEntry{
 			Name:    fmt.Sprintf("k8s-%v", pod.Address),
 			IPAddr:  pod.Address,
 			MACAddr: mac,
 		}
+	}
+	// The entries written next are based on these entries, even if this
+	// write fails
+	vxm.arps = entries
+
+	arps := arpSection{}
+	for _, entry := range entries {
 		arps.Entries = append(arps.Entries, entry)
 	}
+	sort.Slice(arps.Entries, func(i, j int) bool {
+		return arps.Entries[i].IPAddr < arps.Entries[j].IPAddr
+	})
 	doneCh, errCh, err := vxm.config.SendSection(
 		"vxlan-arp",
 		arps,
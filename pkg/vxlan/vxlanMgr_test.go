@@ -373,4 +373,75 @@ var _ = Describe("VxlanMgr Tests", func() {
 		}
 		Expect(section).To(Equal(expected))
 	})
+
+	It("deletes the arp entries of removed members", func() {
+		mock := &test.MockWriter{
+			FailStyle: test.Success,
+			Sections:  make(map[string]interface{}),
+		}
+		fakeClient := fake.NewSimpleClientset()
+		eventChan := make(chan interface{})
+		vxMgr, err := NewVxlanMgr("maintain", "vxlan500", true, mock, eventChan)
+		Expect(err).ToNot(HaveOccurred())
+
+		annotations := map[string]string{
+			"flannel.alpha.coreos.com/backend-data": "{\"VtepMAC\":\"12:ab:34:cd:56:ef\"}",
+			"flannel.alpha.coreos.com/public-ip":    "127.0.0.10",
+		}
+		flannelNode := *newNode("flannelNode", "9", false,
+			[]v1.NodeAddress{{Type: "InternalIP", Address: "127.0.0.10"}}, annotations)
+		fakeClient.CoreV1().Nodes().Create(&flannelNode)
+		for _, ip := range []string{"1.2.3.4", "1.2.3.5"} {
+			fakeClient.CoreV1().Pods("default").Create(&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-" + ip},
+				Status:     v1.PodStatus{PodIP: ip},
+				Spec:       v1.PodSpec{NodeName: "flannelNode"},
+			})
+		}
+		entry := func(ip string) arpEntry {
+			return arpEntry{
+				Name:    "k8s-" + ip,
+				IPAddr:  ip,
+				MACAddr: "12:ab:34:cd:56:ef",
+			}
+		}
+		written := func(times int) []arpEntry {
+			Eventually(func() int {
+				mock.Lock()
+				defer mock.Unlock()
+				return mock.WrittenTimes
+			}).Should(Equal(times))
+			mock.Lock()
+			defer mock.Unlock()
+			return mock.Sections["vxlan-arp"].(arpSection).Entries
+		}
+
+		vxMgr.ProcessAppmanagerEvents(fakeClient)
+		eventChan <- resource.MemberUpdate{
+			Members: []resource.Member{{Address: "1.2.3.4"}, {Address: "1.2.3.5"}},
+			Full:    true,
+		}
+		Expect(written(1)).To(Equal([]arpEntry{entry("1.2.3.4"), entry("1.2.3.5")}))
+
+		// The pod of the removed member is gone, the address is reused by
+		// a pod not found yet
+		fakeClient.CoreV1().Pods("default").Delete("pod-1.2.3.5", nil)
+		eventChan <- resource.MemberUpdate{
+			Members: []resource.Member{{Address: "1.2.3.4"}, {Address: "1.2.3.6"}},
+			Removed: []resource.Member{{Address: "1.2.3.5"}},
+		}
+		Expect(written(2)).To(Equal([]arpEntry{entry("1.2.3.4")}))
+
+		// The entry of a member whose pod is not found is kept until the
+		// member is removed
+		fakeClient.CoreV1().Pods("default").Delete("pod-1.2.3.4", nil)
+		eventChan <- resource.MemberUpdate{
+			Members: []resource.Member{{Address: "1.2.3.4"}},
+		}
+		Expect(written(3)).To(Equal([]arpEntry{entry("1.2.3.4")}))
+
+		// A full update replaces the entries
+		eventChan <- []resource.Member{{Address: "1.2.3.4"}}
+		Expect(written(4)).To(BeEmpty())
+	})
 })